@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"proxy/config"
+	"proxy/server/diagnose"
+	"proxy/server/stats"
+)
+
+// serviceName 注册为系统服务（Windows 服务、systemd unit、launchd 标签）时使用的名称
+const serviceName = "celestial-ladder"
+
+// runCommand 执行子命令，返回进程退出码
+func runCommand(ctx context.Context, args []string) int {
+	switch args[0] {
+	case "trace":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "usage: proxy [-c config.json] trace <host[:port]>")
+			return 2
+		}
+		if err := diagnose.Trace(ctx, args[1], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "trace failed: %v\n", err)
+			return 1
+		}
+		return 0
+	case "test":
+		if !diagnose.SelfTest(ctx, os.Stdout) {
+			return 1
+		}
+		return 0
+	case "bench":
+		fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+		opts := diagnose.BenchOptions{}
+		fs.StringVar(&opts.URL, "url", diagnose.DefaultBenchURL, "file to download, its host:port is also the handshake target")
+		fs.IntVar(&opts.Count, "n", 5, "handshakes per remote")
+		fs.DurationVar(&opts.Duration, "t", 10*time.Second, "download (and upload) duration per remote")
+		via := fs.Int("via", 0, "only test this outbound type (same values as out.type), 0 tests out.type and direct")
+		fs.StringVar(&opts.Addr, "addr", "", "only test this address of out.remote_addr")
+		up := fs.Bool("up", false, "also measure upload throughput")
+		uploadURL := fs.String("upload-url", diagnose.DefaultUploadURL, "upload target, accepts POST bodies of any size")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		if *via < 0 || *via > int(config.RemoteTypeKCP) {
+			fmt.Fprintf(os.Stderr, "-via must be 0 or an out.type value 1-%d\n", config.RemoteTypeKCP)
+			return 2
+		}
+		opts.Via = int8(*via)
+		if *up {
+			opts.UploadURL = *uploadURL
+		}
+		if err := diagnose.Bench(ctx, opts, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "bench failed: %v\n", err)
+			return 1
+		}
+		return 0
+	case "stats":
+		fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+		days := fs.Int("days", 7, "days to report, including today")
+		top := fs.Int("top", 20, "domains to list")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		if config.Config.Stats.File == "" {
+			fmt.Fprintln(os.Stderr, "stats.file is not configured")
+			return 1
+		}
+		report, err := stats.ReadReport(config.Config.Stats.File, *days, *top)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "read traffic stats failed: %v\n", err)
+			return 1
+		}
+		report.Print(os.Stdout)
+		return 0
+	case "status":
+		return runStatus(args[1:])
+	case "service":
+		return runServiceCommand(args[1:])
+	case "check":
+		if !diagnose.Check(os.Stdout) {
+			return 1
+		}
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", args[0])
+		return 2
+	}
+}
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certCheckInterval 自备证书文件变化的检查间隔，续期后无需重启
+const certCheckInterval = time.Minute
+
+// certReloader 从 cert_file / key_file 加载证书，文件修改后在下一次握手时重新加载
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	r.cert, r.modTime = &cert, info.ModTime()
+	return nil
+}
+
+// GetCertificate 用作 tls.Config.GetCertificate；重新加载失败时继续使用旧证书
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) >= certCheckInterval {
+		r.checked = time.Now()
+		if info, err := os.Stat(r.certFile); err == nil && !info.ModTime().Equal(r.modTime) {
+			_ = r.load()
+		}
+	}
+	return r.cert, nil
+}
+
+// LoadCertPool 读取 PEM 格式的 CA 证书
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", file)
+	}
+	return pool, nil
+}
+
+// fileTLSConfig 使用 in.cert_file / in.key_file 自备证书的服务端 TLS 配置
+func fileTLSConfig() (*tls.Config, error) {
+	if Config.In.KeyFile == "" {
+		return nil, errors.New("in.key_file is required with in.cert_file")
+	}
+	r, err := newCertReloader(Config.In.CertFile, Config.In.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		GetCertificate: r.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+		MinVersion:     tls.VersionTLS12,
+	}, nil
+}
+
+// applyHosts 为 in.hosts 中自备证书的域名按 SNI 选择证书，其余连接仍使用 cfg 原有的证书
+func applyHosts(cfg *tls.Config) error {
+	type hostCert struct {
+		name string
+		r    *certReloader
+	}
+	var hosts []hostCert
+	for _, h := range Config.In.Hosts {
+		if h.CertFile == "" {
+			continue
+		}
+		r, err := newCertReloader(h.CertFile, h.KeyFile)
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.ServerName, err)
+		}
+		hosts = append(hosts, hostCert{name: h.ServerName, r: r})
+	}
+	if len(hosts) == 0 {
+		return nil
+	}
+	next := cfg.GetCertificate
+	cfg.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		for _, h := range hosts {
+			if MatchServerName(h.name, hello.ServerName) {
+				return h.r.GetCertificate(hello)
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("no certificate for %q", hello.ServerName)
+		}
+		return next(hello)
+	}
+	return nil
+}
+
+// MatchServerName name 是否与 pattern 一致（不区分大小写），*.example.com 匹配一级子域名
+func MatchServerName(pattern, name string) bool {
+	pattern, name = strings.ToLower(strings.TrimSuffix(pattern, ".")), strings.ToLower(strings.TrimSuffix(name, "."))
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		label, rest, found := strings.Cut(name, ".")
+		return found && label != "" && rest == suffix
+	}
+	return pattern != "" && pattern == name
+}
+
+// IsLocalServerName name 是否为本机代理服务的域名（in.server_name 或 in.hosts），未配置任何域名时视为是
+func IsLocalServerName(name string) bool {
+	if Config.In.ServerName == "" && len(Config.In.Hosts) == 0 {
+		return true
+	}
+	if MatchServerName(Config.In.ServerName, name) {
+		return true
+	}
+	for _, h := range Config.In.Hosts {
+		if MatchServerName(h.ServerName, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyClientCA 配置了 in.client_ca 时要求客户端出示该 CA 签发的证书（双向 TLS）
+func applyClientCA(cfg *tls.Config) error {
+	if Config.In.ClientCA == "" {
+		return nil
+	}
+	pool, err := LoadCertPool(Config.In.ClientCA)
+	if err != nil {
+		return err
+	}
+	cfg.ClientCAs, cfg.ClientAuth = pool, tls.RequireAndVerifyClientCert
+	return nil
+}
+
+// remoteTLS 缓存的出口客户端证书与 CA，配置的文件路径变化后重新加载
+var remoteTLS struct {
+	sync.Mutex
+	certFile, keyFile, caFile string
+	certs                     []tls.Certificate
+	roots                     *x509.CertPool
+}
+
+// RemoteTLS 连接远端时出示的客户端证书（out.cert_file / out.key_file）与信任的 CA（out.ca_file），未配置时均为 nil
+func RemoteTLS() ([]tls.Certificate, *x509.CertPool, error) {
+	out := Config.Out
+	remoteTLS.Lock()
+	defer remoteTLS.Unlock()
+	if remoteTLS.certFile == out.CertFile && remoteTLS.keyFile == out.KeyFile && remoteTLS.caFile == out.CAFile &&
+		(out.CertFile == "" || remoteTLS.certs != nil) && (out.CAFile == "" || remoteTLS.roots != nil) {
+		return remoteTLS.certs, remoteTLS.roots, nil
+	}
+	var certs []tls.Certificate
+	var roots *x509.CertPool
+	if out.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(out.CertFile, out.KeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		certs = []tls.Certificate{cert}
+	}
+	if out.CAFile != "" {
+		pool, err := LoadCertPool(out.CAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		roots = pool
+	}
+	remoteTLS.certFile, remoteTLS.keyFile, remoteTLS.caFile = out.CertFile, out.KeyFile, out.CAFile
+	remoteTLS.certs, remoteTLS.roots = certs, roots
+	return certs, roots, nil
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	configData []byte // 配置文件原文，用于 check 子命令定位出错的行
+	loadErr    error  // check 子命令下读取或解析配置文件的错误
+)
+
+// CheckMode 是否在执行 check 子命令，此时配置错误不直接退出，由 check 统一报告
+func CheckMode() bool {
+	return len(Args) > 0 && Args[0] == "check"
+}
+
+// Path 当前使用的配置文件路径
+func Path() string {
+	return configPath
+}
+
+// LoadError check 子命令下读取或解析配置文件的错误
+func LoadError() error {
+	return loadErr
+}
+
+// Locate 返回配置项（如 in.port、white_list[2]）在配置文件中的行号与该行内容，找不到时行号为 0
+// 按层级依次查找键名，JSON、YAML、TOML 通用
+func Locate(field string) (int, string) {
+	text := string(configData)
+	offset, found := 0, false
+	for _, key := range strings.Split(field, ".") {
+		if i := strings.IndexByte(key, '['); i >= 0 {
+			key = key[:i]
+		}
+		re := regexp.MustCompile(`(?m)(?:^|[\s{,\["])(` + regexp.QuoteMeta(key) + `)"?\s*[:=\]]`)
+		loc := re.FindStringSubmatchIndex(text[offset:])
+		if loc == nil {
+			break
+		}
+		offset, found = offset+loc[2], true
+	}
+	if !found {
+		return 0, ""
+	}
+	line := strings.Count(text[:offset], "\n") + 1
+	return line, strings.TrimSpace(strings.Split(text, "\n")[line-1])
+}
@@ -1,19 +1,117 @@
 package config
 
+// Settings 配置文件对应的结构，嵌入时可自行构造后传给 server.New
+type Settings = config
+
 type config struct {
 	Debug     bool   `json:"debug"`
 	User      string `json:"user"` // password, used to encode the connection, must 32 byte length
 	ECSSubnet string `json:"ecs_subnet"`
 	In        struct {
-		Type       int8   `json:"type"`        // 1: local socks5 2: local http 3: https 4: web socket secure
-		Port       int    `json:"port"`        // https 和wss 不能指定，默认443
-		ServerName string `json:"server_name"` // 本机是https服务器时，使用的域名
-		Email      string `json:"email"`       // used to issue cert
+		Type          int8     `json:"type"`             // 1: local socks5 2: local http 3: https 4: web socket secure 5: quic 6: grpc 7: socks5 over tls 8: mixed 9: kcp
+		Port          int      `json:"port"`             // https 和wss 不能指定，默认443
+		Listen        string   `json:"listen"`           // 监听地址，默认 0.0.0.0（所有网卡），只供本机使用时可设为 127.0.0.1
+		AllowClients  []string `json:"allow_clients"`    // 允许连接的客户端 IP 或网段，如 192.168.1.0/24，为空时不限；本机回环地址始终允许
+		MaxConnsPerIP int      `json:"max_conns_per_ip"` // 每个客户端 IP 同时建立的连接数上限，0 表示不限
+		MaxConns      int      `json:"max_conns"`        // 入口同时建立的连接数上限，含 TUN 与系统代理经回环地址的连接，0 表示不限
+		Overload      string   `json:"overload"`         // 达到 max_conns 时的处理：wait（默认，暂停接受，新连接在系统队列中等待）或 reject（接受后立即关闭）
+		ServerName    string   `json:"server_name"`      // 本机是https服务器时，使用的域名
+		Email         string   `json:"email"`            // used to issue cert
+		CertFile      string   `json:"cert_file"`        // 自备证书（PEM，可含中间证书链），配置后不再通过 ACME 申请
+		KeyFile       string   `json:"key_file"`         // 自备证书的私钥
+		ClientCA      string   `json:"client_ca"`        // 校验客户端证书的 CA，配置后只接受出示该 CA 签发证书的客户端
+		TimeWindow    string   `json:"time_window"`      // 认证头时间戳与本机时钟允许的偏差，默认 60s，配合 nonce 去重防止重放
+		ProxyProtocol bool     `json:"proxy_protocol"`   // 入口连接开头带有 PROXY protocol v1/v2 头，位于 HAProxy、Nginx stream 或负载均衡之后时开启
+		TrustedProxy  []string `json:"trusted_proxies"`  // 允许发送 PROXY protocol 头的负载均衡 IP 或网段，为空时只接受本机回环地址发来的头
+		GRPCService   string   `json:"grpc_service"`     // gRPC 入口的服务名，请求路径为 /<服务名>/Tun，默认 GunService
+		Hosts         []struct {
+			ServerName string `json:"server_name"` // 域名，可用 *.example.com 通配一级子域名
+			CertFile   string `json:"cert_file"`   // 该域名的证书，为空时使用默认证书（ACME 申请时一并申请）
+			KeyFile    string `json:"key_file"`
+		} `json:"hosts"` // server_name 之外由本机代理提供服务的域名，按 SNI 选择证书
+		Fallback string `json:"fallback"` // SNI 不属于 server_name 与 hosts 的 TLS 连接原样转发到该地址（host:port），如同机的网站；为空时不转发
+		WSS      struct {
+			Path    string            `json:"path"`    // 只接受该路径的 WebSocket 升级，为空时不限
+			Host    string            `json:"host"`    // 只接受该 Host 的请求，为空时不限
+			Headers map[string]string `json:"headers"` // 升级响应附加的头
+		} `json:"wss"`
 	} `json:"in"`
 	Out struct {
-		Type       int8   `json:"type"`        // 1: remote tls 2: remote wss 3: direct
-		RemoteAddr string `json:"remote_addr"` // remote时，远端服务器地址，由于tls原因，仅支持域名，如:my-ti-zi.remote.cn
+		Type          int8   `json:"type"`           // 1: remote tls 2: remote wss 3: direct 4: subscription node 5: remote quic 6: remote grpc 7: http connect proxy 8: remote kcp
+		RemoteAddr    string `json:"remote_addr"`    // remote时，远端服务器地址，由于tls原因，仅支持域名，可带端口（默认 443），多个地址以逗号分隔，如:my-ti-zi.remote.cn,backup.remote.cn:8443
+		BindInterface string `json:"bind_interface"` // 出站连接绑定的网卡名，如 eth0 / en0 / 以太网，为空时按路由表
+		GRPCService   string `json:"grpc_service"`   // gRPC 出口的服务名，需与服务端 in.grpc_service 一致
+		CertFile      string `json:"cert_file"`      // 连接远端时出示的客户端证书，服务端配置了 in.client_ca 时需要
+		KeyFile       string `json:"key_file"`       // 客户端证书的私钥
+		CAFile        string `json:"ca_file"`        // 校验远端证书的 CA，远端使用内部 CA 签发的证书时配置，为空时使用系统 CA
+		KillSwitch    bool   `json:"kill_switch"`    // 远端不可达时拒绝本应走代理的连接，不改走直连也不逐个等待超时
+		FailOpen      bool   `json:"fail_open"`      // 远端不可达时本应走代理的连接暂时改走直连，远端恢复后自动切回，不能与 kill_switch 同时开启
+		Relay         bool   `json:"relay"`          // 中继模式：全部连接交给 out 指定的上游，不再按规则分流；服务端串联到下一跳时开启
+		User          string `json:"user"`           // 连接上游使用的 32 字节密钥，为空时使用顶层 user；中继与上游的密钥不同时配置
+		DNSFeedback   bool   `json:"dns_feedback"`   // 请求服务端回送为域名实际连接的 IP，用于预热本地 DNS 缓存与统计，需服务端同样支持
+		HTTPProxy     struct {
+			Addr     string `json:"addr"`     // 上游 HTTP 代理地址 host:port，out.type 为 7 时经它访问目标，为 TLS/WSS/gRPC 时经它连接远端
+			Username string `json:"username"` // Basic 认证用户名，为空时不认证
+			Password string `json:"password"`
+		} `json:"http_proxy"`
+		WSS struct {
+			Path    string            `json:"path"`    // WebSocket 请求路径，可带查询参数，默认 /
+			Host    string            `json:"host"`    // Host 头与 TLS SNI，默认 remote_addr；经 CDN 转发时填写回源域名
+			Headers map[string]string `json:"headers"` // 附加的请求头，如 User-Agent
+			CDN     bool              `json:"cdn"`     // 按 WebSocket 帧收发并发送早期数据，用于经 CDN 转发，需服务端同样支持
+		} `json:"wss"`
 	}
+	Subscription struct {
+		URLs     []string `json:"urls"`     // 订阅地址，内容为 base64 编码的分享链接列表
+		Links    []string `json:"links"`    // 直接填写的分享链接：ss://、trojan://、vmess://
+		Interval string   `json:"interval"` // 订阅刷新间隔，如 6h，默认 1h
+		Node     string   `json:"node"`     // out.type 为 4 时使用的节点名，为空时使用第一个可用节点
+	} `json:"subscription"`
+	Forward []struct {
+		Listen string `json:"listen"` // 本地监听地址，如 127.0.0.1:15432
+		Target string `json:"target"` // 转发到的目标，如 db.internal:5432
+		Via    int8   `json:"via"`    // 使用的出口类型（取值同 out.type），0 表示按分流规则选择
+	} `json:"forward"` // 静态端口转发，供不支持代理的程序经隧道访问固定的远端服务
+	Reverse struct {
+		Ports   []int `json:"ports"` // 服务端：允许客户端经反向隧道开放的端口，为空时不接受反向隧道
+		Tunnels []struct {
+			Remote string `json:"remote"` // 服务端监听的地址，如 0.0.0.0:2222
+			Local  string `json:"local"`  // 服务端收到的连接转发到的本地或局域网地址，如 192.168.1.10:22
+		} `json:"tunnels"` // 客户端：经 out 的加密隧道在服务端开放的端口（类似 ssh -R）
+	} `json:"reverse"`
+	DNS struct {
+		IPStrategy    string            `json:"ip_strategy"`    // 地址族偏好：ipv4-only（默认）、ipv6-first、dual
+		Hosts         map[string]string `json:"hosts"`          // 静态 hosts：域名 -> IP 或域名别名，优先于 DoH
+		RemoteResolve []string          `json:"remote_resolve"` // 本地不解析的域名，格式同 white_list：分流时不查询 DoH，未被其他规则判为直连的走代理，由服务端解析
+		LocalResolve  []string          `json:"local_resolve"`  // 走代理时在本地解析的域名，格式同 white_list：经 DoH 解析后把 IP 而不是域名发给服务端
+		Guard         struct {
+			Enable bool     `json:"enable"` // TUN 模式下劫持全部 53 端口的查询改由 DoH 应答，并阻断已知的第三方 DoH/DoT 服务器
+			Allow  []string `json:"allow"`  // 不阻断的 DoH/DoT 服务器，格式同 white_list
+		} `json:"guard"`
+	} `json:"dns"`
+	Tor struct {
+		Socks   string   `json:"socks"`    // Tor 的 SOCKS 端口，默认 127.0.0.1:9050
+		Binary  string   `json:"binary"`   // tor 可执行文件，配置后随本程序启动与退出并监听 socks 端口，为空时使用已运行的 Tor
+		DataDir string   `json:"data_dir"` // 启动 tor 时使用的数据目录，默认 tor-data
+		Rules   []string `json:"rules"`    // 经 Tor 访问的目标，格式同 white_list，优先于其他分流规则
+	} `json:"tor"`
+	MITM struct {
+		Rules   []string `json:"rules"`    // 解密检查的目标，格式同 white_list，为空时不启用
+		CACert  string   `json:"ca_cert"`  // 签发站点证书的 CA 证书，不存在时自动生成，默认 mitm-ca.crt
+		CAKey   string   `json:"ca_key"`   // CA 私钥，默认 mitm-ca.key
+		LogBody bool     `json:"log_body"` // 日志中同时记录请求与响应体（截取前 max_body 字节）
+		MaxBody int      `json:"max_body"` // 记录的请求、响应体长度上限，默认 4096
+	} `json:"mitm"` // HTTPS 解密检查，仅用于排查问题：本地入口收到的命中 rules 的 TLS 连接由本机 CA 签发证书解密，记录请求与响应
+	AdBlock struct {
+		Lists    []string `json:"lists"`     // 拦截列表：本地文件或 http(s) 地址，支持 hosts 格式与 AdGuard 规则（||example.com^、@@||example.com^）
+		Allow    []string `json:"allow"`     // 不拦截的目标，格式同 white_list，优先于 lists
+		Interval string   `json:"interval"`  // 远程列表的刷新间隔，默认 24h
+		CacheDir string   `json:"cache_dir"` // 远程列表的本地缓存目录，默认 adblock，启动时先使用缓存
+	} `json:"adblock"` // 广告与跟踪拦截：命中列表的域名拒绝连接，TUN 模式下 DNS 查询直接返回 NXDOMAIN
+	Script struct {
+		File string `json:"file"` // 路由脚本（Starlark 语法），需定义 route(c) 函数，为空时不启用
+	} `json:"script"` // 路由脚本：在其他分流规则之前调用，可指定出口或改写目标地址
+	Mode        string   `json:"mode"` // 分流模式：rule（默认，按规则分流）、global（全部走代理）、direct（全部直连），可经管理接口切换
 	WhiteList   []string `json:"white_list"`
 	BlackList   []string `json:"black_list"`
 	ChinaIpFile string   `json:"china_ip_file"`
@@ -25,13 +123,111 @@ type config struct {
 		Netmask string   `json:"netmask"`
 		MTU     int      `json:"mtu"`
 		DNS     []string `json:"dns"`
+		DNSPush string   `json:"dns_push"` // 开启 TUN 时把系统 DNS 改为 tun.dns，退出时恢复：off 不改动（默认），reassert 被 DHCP 续约等改回时重新设置，warn 只记录日志
+		Mark    int      `json:"mark"`     // Linux 出站连接的 SO_MARK，同时作为绕过 TUN 的路由表号，默认 0x162
+		Uplink  string   `json:"uplink"`   // 原出口网卡名，有多条默认路由（有线、Wi-Fi、4G）时使用该网卡上的默认路由，为空时取 metric 最小的
+		// Gateway 网关模式（仅 Linux）：本机作为局域网的默认网关与 DNS，局域网设备的流量经 TUN 分流
+		Gateway struct {
+			Enable bool   `json:"enable"` // 开启 IPv4 转发并放行、NAT 局域网流量，退出时恢复
+			LAN    string `json:"lan"`    // 局域网网卡名，如 br-lan、eth1
+			DNS    string `json:"dns"`    // 向局域网提供 DNS 的监听地址，默认局域网网卡地址的 53 端口，- 表示不提供
+		} `json:"gateway"`
 	} `json:"tun"`
 	SystemProxy struct {
 		Enable bool `json:"enable"` // 是否自动配置系统代理
 	} `json:"system_proxy"`
+	Limit struct {
+		Upload   string `json:"upload"`   // 全局上行限速（每秒字节数），如 2MB、512KB，为空不限速
+		Download string `json:"download"` // 全局下行限速
+		Rules    []struct {
+			Match    []string `json:"match"` // 匹配规则，格式同 white_list，命中的连接共享该条规则的限速
+			Upload   string   `json:"upload"`
+			Download string   `json:"download"`
+		} `json:"rules"`
+	} `json:"limit"`
+	Users struct {
+		QuotaFile string `json:"quota_file"` // 服务端按用户统计的用量持久化文件，默认 quota.json
+		List      []struct {
+			Name          string `json:"name"`
+			Key           string `json:"key"`             // 32 字节密钥，客户端的 user 配置为同一值
+			Quota         string `json:"quota"`           // 每月流量配额（上下行合计），如 100GB，为空不限
+			OverQuotaRate string `json:"over_quota_rate"` // 超出配额后的限速，如 128KB，为空时拒绝连接
+		} `json:"list"`
+	} `json:"users"`
+	Metrics struct {
+		Listen string `json:"listen"` // Prometheus 指标监听地址，如 127.0.0.1:9100，为空时不启用
+	} `json:"metrics"`
+	Stats struct {
+		File    string `json:"file"`    // 按天、出口与域名统计的流量持久化文件，如 stats.json，为空时只在内存中统计
+		Days    int    `json:"days"`    // 保留的天数，默认 90
+		Domains int    `json:"domains"` // 每天保留的域名数，超出时淘汰流量最少的，默认 200
+	} `json:"stats"`
+	Tracing struct {
+		Endpoint    string `json:"endpoint"`     // OTLP/HTTP 上报地址，如 http://127.0.0.1:4318，为空时不启用
+		ServiceName string `json:"service_name"` // 上报的 service.name，默认 celestial-ladder
+	} `json:"tracing"`
+	Admin struct {
+		Listen string `json:"listen"` // 本机管理接口监听地址，如 127.0.0.1:9090，为空时不启用
+		Token  string `json:"token"`  // 访问令牌，请求头 Authorization: Bearer <token>
+		Pprof  bool   `json:"pprof"`  // 是否在管理接口上开放 /debug/pprof/，修改后需重启
+		Socket string `json:"socket"` // 本机控制套接字（Unix 域套接字）路径，status 子命令经它查询，默认为配置文件所在目录下的 proxy.sock，- 表示不启用
+		// Webhooks 以 HTTP POST 推送连接事件的地址
+		Webhooks []struct {
+			URL     string            `json:"url"`
+			Events  []string          `json:"events"`  // connect、route、close、new_device，为空时只推送 new_device
+			Headers map[string]string `json:"headers"` // 附加的请求头，如 Authorization
+		} `json:"webhooks"`
+	} `json:"admin"`
+	Profile  string                 `json:"profile"`  // 使用的 profile，为空时不合并
+	Profiles map[string]interface{} `json:"profiles"` // 命名的配置片段，选中时合并到上面的配置，如 home / office
+	TCP      struct {
+		KeepAlive string `json:"keep_alive"` // TCP keepalive 探测间隔，默认 15s，0 表示关闭
+		NoDelay   *bool  `json:"no_delay"`   // 是否设置 TCP_NODELAY，默认 true
+		FastOpen  bool   `json:"fast_open"`  // TCP Fast Open（仅 Linux）：TLS 出口连接的 ClientHello 随 SYN 发出，TCP 入口接受随 SYN 发送的数据
+	} `json:"tcp"`
+	KCP struct {
+		MTU      int    `json:"mtu"`      // 每个 UDP 包的载荷上限，默认 1350，两端需一致
+		SndWnd   int    `json:"snd_wnd"`  // 发送窗口（包数），默认 256
+		RcvWnd   int    `json:"rcv_wnd"`  // 接收窗口（包数），默认 256
+		FEC      int    `json:"fec"`      // 每组数据包数，每组附加一个 Reed-Solomon 校验包，组内丢一个包不必重传；0 关闭（默认），两端需一致
+		Interval string `json:"interval"` // 发送与重传的检查间隔，默认 10ms
+	} `json:"kcp"` // KCP 入口（in.type 9）与出口（out.type 8）的参数
+	Timeouts struct {
+		Handshake  string `json:"handshake"`   // 握手超时，默认 4s
+		Dial       string `json:"dial"`        // 连接远端与 DoH 查询超时，默认 10s
+		Idle       string `json:"idle"`        // 转发连接空闲超时，默认 0 即不限
+		UDPSession string `json:"udp_session"` // UDP 会话空闲超时，默认 5m，0 表示不限
+		UDPMapping string `json:"udp_mapping"` // 服务端 UDP 映射在会话断开后保留的时间，默认 1m，0 表示随会话关闭
+	} `json:"timeouts"`
+	Retry struct {
+		Attempts   *int   `json:"attempts"`    // 出口握手遇到网络错误后的重试次数，默认 2，0 表示不重试
+		Backoff    string `json:"backoff"`     // 首次重试前的等待时间，之后每次翻倍并加入随机抖动，默认 200ms
+		MaxBackoff string `json:"max_backoff"` // 单次等待的上限，默认 2s
+		Fallback   int8   `json:"fallback"`    // 代理出口重试后仍失败时改用的出口类型（取值同 out.type），0 表示不切换
+	} `json:"retry"`
+	Memory struct {
+		Limit       string `json:"limit"`        // Go 运行时的软内存上限，如 100MB，接近时 GC 更积极，为空时沿用环境变量 GOMEMLIMIT
+		GCPercent   int    `json:"gc_percent"`   // 堆增长到上次 GC 后存活大小的多少百分比时触发 GC，同 GOGC，0 沿用环境变量或默认 100，-1 只按 limit 触发
+		Ballast     string `json:"ballast"`      // 常驻的压舱内存，如 16MB，抬高 GC 触发点以减少小堆时的 GC 次数；设置 limit 后一般不需要
+		RelayBuffer string `json:"relay_buffer"` // 转发时每个方向的缓冲区大小，默认 32KB，范围 4KB~64KB
+		DNSCache    int    `json:"dns_cache"`    // DoH 缓存的最大条目数，超出时先清理过期条目再随机淘汰，0 表示不限
+		HighWater   int    `json:"high_water"`   // 内存占用达到 limit 的该百分比时拒绝新的 TUN 连接，默认 90，未设置上限时不生效
+	} `json:"memory"`
+	Shutdown struct {
+		GracePeriod string `json:"grace_period"` // 退出时等待在途连接结束的最长时间，如 10s，默认 10s，超时后强制断开
+	} `json:"shutdown"`
+	Reload struct {
+		Watch *bool `json:"watch"` // 是否监控配置文件变化自动重载，未设置时仅在启用 TUN 时监控；SIGHUP 与 /api/reload 不受影响
+	} `json:"reload"`
 	Log struct {
-		Path     string `json:"path"`
-		Level    string `json:"level"`
-		FileName string `json:"file_name"`
+		Path         string `json:"path"`
+		Level        string `json:"level"`
+		FileName     string `json:"file_name"`
+		MaxSize      string `json:"max_size"`       // 单个日志文件大小上限，如 100MB，超过后切分新文件，为空只按时间切分
+		MaxTotalSize string `json:"max_total_size"` // 日志文件总大小上限，如 1GB，超出后从最旧的文件开始删除，为空不限
+		Access       bool   `json:"access"`         // 连接结束时输出访问日志（目标、规则、出口、流量、耗时）
+		Audit        string `json:"audit"`          // 审计日志文件，记录对路由、系统代理与 TUN 设备的改动，默认 log.path 下的 audit.log，- 表示不记录
+		Sink         string `json:"sink"`           // 同时写入系统日志：syslog（Linux/macOS）、journald（Linux）或 eventlog（Windows），为空不写
+		Tag          string `json:"tag"`            // 系统日志中的程序标识，默认 celestial-ladder
 	} `json:"log"`
 }
@@ -0,0 +1,34 @@
+//go:build !windows
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// envDaemon 标记已在后台运行的子进程，避免再次转入后台
+const envDaemon = "CLT_DAEMON"
+
+// daemonize 以相同参数在新会话中启动子进程并退出，子进程的标准输入输出指向 /dev/null，日志写入 log.path
+func daemonize() {
+	if os.Getenv(envDaemon) != "" {
+		return
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Printf("run in background with error：%+v\n", err)
+		os.Exit(1)
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), envDaemon+"=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		fmt.Printf("run in background with error：%+v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("running in background, pid %d\n", cmd.Process.Pid)
+	os.Exit(0)
+}
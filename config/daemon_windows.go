@@ -0,0 +1,14 @@
+//go:build windows
+
+package config
+
+import (
+	"fmt"
+	"os"
+)
+
+// daemonize Windows 下请使用 service install 注册为系统服务
+func daemonize() {
+	fmt.Println("-daemon is not supported on Windows, use `service install` instead")
+	os.Exit(1)
+}
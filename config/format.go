@@ -0,0 +1,89 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// 未通过 -c 指定且 config.json 不存在时，依次尝试的配置文件
+var fallbackConfigFiles = []string{"config.yaml", "config.yml", "config.toml"}
+
+// decodeConfig 按扩展名解析配置文件：.yaml/.yml 为 YAML，.toml 为 TOML，其余按 JSON
+// YAML/TOML 先解析为通用结构再转成 JSON，沿用 config 结构体上的 json 标签；选中的 profile 合并在通用结构上
+func decodeConfig(file string, data []byte, v interface{}) error {
+	var generic interface{}
+	isJSON := false
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &generic); err != nil {
+			return err
+		}
+	case ".toml":
+		m := make(map[string]interface{})
+		if _, err := toml.Decode(string(data), &m); err != nil {
+			return err
+		}
+		generic = m
+	default:
+		if err := json.Unmarshal(data, &generic); err != nil {
+			return jsonError(data, err)
+		}
+		isJSON = true
+	}
+	if root, ok := generic.(map[string]interface{}); ok {
+		name, err := mergeProfile(root)
+		if err != nil {
+			return err
+		}
+		// 未使用 profile 的 JSON 直接解析原文，出错时行号才准确
+		if name == "" && isJSON {
+			return jsonError(data, json.Unmarshal(data, v))
+		}
+	}
+	buf, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
+
+// jsonError 为 JSON 解析错误补上出错的行号
+func jsonError(data []byte, err error) error {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return err
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return fmt.Errorf("line %d: %w", bytes.Count(data[:offset], []byte("\n"))+1, err)
+}
+
+// findConfigFile 默认的 config.json 不存在时，返回同目录下存在的 YAML/TOML 配置文件
+func findConfigFile(file string) string {
+	if _, err := os.Stat(file); err == nil || filepath.Base(file) != "config.json" {
+		return file
+	}
+	for _, name := range fallbackConfigFiles {
+		candidate := filepath.Join(filepath.Dir(file), name)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return file
+}
@@ -2,13 +2,14 @@ package config
 
 import (
 	"crypto/tls"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
+	"net"
 	"os"
-	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/caddyserver/certmagic"
@@ -32,12 +33,36 @@ const (
 	ServerTypeHttp
 	ServerTypeTLS
 	ServerTypeWSS
+	ServerTypeQUIC
+	ServerTypeGRPC
+	ServerTypeSocksTLS
+	ServerTypeMixed
+	ServerTypeKCP
 )
 const (
 	_ = iota
 	RemoteTypeTLS
 	RemoteTypeWSS
 	RemoteTypeDirect
+	RemoteTypeSubscription
+	RemoteTypeQUIC
+	RemoteTypeGRPC
+	RemoteTypeHTTPConnect
+	RemoteTypeKCP
+)
+const (
+	IPStrategyIPv4Only  = "ipv4-only"
+	IPStrategyIPv6First = "ipv6-first"
+	IPStrategyDual      = "dual"
+)
+const (
+	OverloadWait   = "wait"
+	OverloadReject = "reject"
+)
+const (
+	ModeRule   = "rule"
+	ModeGlobal = "global"
+	ModeDirect = "direct"
 )
 const (
 	TimeFormat  = "2006-01-02 15:04:05"
@@ -46,64 +71,269 @@ const (
 
 var TLSConfig = new(tls.Config)
 
-func init() {
+// IPv4Only 是否仅使用 IPv4（未配置 dns.ip_strategy 时默认仅 IPv4）
+func IPv4Only() bool {
+	return Config.DNS.IPStrategy == "" || Config.DNS.IPStrategy == IPStrategyIPv4Only
+}
+
+// RouteMode 当前的分流模式，未配置时为 rule
+func RouteMode() string {
+	if mode, _ := routeMode.Load().(string); mode != "" {
+		return mode
+	}
+	return ModeRule
+}
+
+// routeMode 当前的分流模式，与 Config.Mode 分开保存，管理接口切换时不改写配置结构
+var routeMode atomic.Value
+
+// SetRouteMode 运行时切换分流模式，与配置重载互斥，只影响之后新建的连接；重载配置后恢复为配置文件中的 mode
+func SetRouteMode(mode string) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	routeMode.Store(mode)
+}
+
+// DefaultRemotePort out.remote_addr 未写端口时连接的端口
+const DefaultRemotePort = "443"
+
+// RemoteAddrs out.remote_addr 中的远端服务器地址（host:port），多个地址以逗号分隔，未写端口时使用 443
+func RemoteAddrs() []string {
+	addrs := make([]string, 0)
+	for _, addr := range strings.Split(Config.Out.RemoteAddr, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, DefaultRemotePort)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// outType 当前的出口类型，与 Config.Out.Type 分开保存，管理接口切换时不改写配置结构
+var outType atomic.Int32
+
+// OutType 当前的出口类型：配置文件中的 out.type，或经管理接口切换后的类型
+func OutType() int8 {
+	return int8(outType.Load())
+}
+
+// SetOutType 运行时切换出口类型，与配置重载互斥，只影响之后新建的连接；重载配置后恢复为配置文件中的 out.type
+func SetOutType(t int8) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	outType.Store(int32(t))
+}
+
+// tunEnabled、systemProxyEnabled 当前是否启用 TUN 与系统代理，与 Config.Tun.Enable、Config.SystemProxy.Enable 分开保存，
+// 管理接口切换时不改写配置结构
+var tunEnabled, systemProxyEnabled atomic.Bool
+
+// TunEnabled 当前是否启用 TUN：配置文件中的 tun.enable，或经管理接口切换后的状态
+func TunEnabled() bool {
+	return tunEnabled.Load()
+}
+
+// SetTunEnabled 运行时切换 TUN 的启用状态，与配置重载互斥；重载配置时仅在配置文件中的 tun.enable 变化后才恢复为文件中的值
+func SetTunEnabled(enable bool) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	tunEnabled.Store(enable)
+}
+
+// SystemProxyEnabled 当前是否设置系统代理：配置文件中的 system_proxy.enable，或经管理接口切换后的状态
+func SystemProxyEnabled() bool {
+	return systemProxyEnabled.Load()
+}
+
+// SetSystemProxyEnabled 运行时切换系统代理的启用状态，与配置重载互斥；重载配置时仅在配置文件中的 system_proxy.enable 变化后才恢复为文件中的值
+func SetSystemProxyEnabled(enable bool) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	systemProxyEnabled.Store(enable)
+}
+
+// OutUser 连接上游时加密使用的密钥，out.user 为空时使用 user
+func OutUser() string {
+	if Config.Out.User != "" {
+		return Config.Out.User
+	}
+	return Config.User
+}
+
+// ListenAddr 入口监听地址 in.listen:in.port，in.listen 为空时监听所有网卡
+func ListenAddr() string {
+	host := Config.In.Listen
+	if host == "" {
+		host = "0.0.0.0"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(Config.In.Port))
+}
+
+// ControlSocket 本机控制套接字路径（admin.socket），未配置时为配置文件所在目录下的 proxy.sock，不启用时返回空串
+func ControlSocket() string {
+	switch s := Config.Admin.Socket; s {
+	case "-":
+		return ""
+	case "":
+		return filepath.Join(filepath.Dir(configPath), "proxy.sock")
+	default:
+		return s
+	}
+}
+
+// TunMark Linux 下开启 TUN 时出站连接的 SO_MARK 与对应的路由表号（tun.mark，默认 0x162）
+func TunMark() int {
+	if Config.Tun.Mark > 0 {
+		return Config.Tun.Mark
+	}
+	return 0x162
+}
+
+// Args 命令行中 flag 之后的子命令及其参数，为空时以代理服务方式运行
+var Args []string
+
+// normalizeHosts 把 dns.hosts 的域名统一为小写并去掉末尾的点，与查询时的处理一致
+func normalizeHosts(c *config) {
+	if len(c.DNS.Hosts) == 0 {
+		return
+	}
+	hosts := make(map[string]string, len(c.DNS.Hosts))
+	for name, value := range c.DNS.Hosts {
+		hosts[strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")] = value
+	}
+	c.DNS.Hosts = hosts
+}
+
+// PidFile 写入进程号的文件（-pidfile），为空时不写
+var PidFile string
+
+// Load 解析命令行参数并加载配置文件，由 main 启动时调用一次；加载完成后执行 RegisterLoadCallback 注册的回调
+// check 子命令下读取或解析配置的错误记录在 LoadError 中由 check 统一报告，不返回错误
+func Load() error {
 	var c string
-	flag.StringVar(&c, "c", "config.json", "config file，default is config.json in current directory")
+	flag.StringVar(&c, "c", "config.json", "config file (.json/.yaml/.yml/.toml)，default is config.json in current directory")
+	var daemon bool
+	flag.BoolVar(&daemon, "daemon", false, "run in background (Linux/macOS)")
+	flag.StringVar(&PidFile, "pidfile", "", "write process id to this file")
+	registerOverrideFlags()
 	flag.Parse()
+	Args = flag.Args()
+	// 尽早转入后台，父进程不必加载配置和初始化其它包
+	if daemon && len(Args) == 0 {
+		daemonize()
+	}
 	if len(c) == 0 {
 		c = "config.json"
 	}
-	if strings.Index(c, "/") != 0 {
+	// Windows 的绝对路径不以 / 开头（如 C:\proxy\config.json）
+	if !filepath.IsAbs(c) {
 		p, err := os.Getwd()
 		if nil != err {
-			fmt.Printf("read config file with error：%+v", err)
-			os.Exit(1)
+			return fmt.Errorf("read config file with error：%w", err)
 		}
-		c = path.Join(p, c)
+		c = filepath.Join(p, c)
 	}
-	// load json config file
-	jsonFile, err := os.OpenFile(c, os.O_RDONLY, 0755)
-	if nil != err {
-		fmt.Printf("read config file with error：%+v", err)
-		os.Exit(1)
+	c = findConfigFile(c)
+	configPath = c
+	// 作为 Windows 服务运行时工作目录是 System32，切换到配置文件所在目录，使相对路径（日志、IP 列表等）照常生效
+	chdirForService(c)
+	if err := readConfig(c); err != nil {
+		if !CheckMode() {
+			return err
+		}
+		loadErr = err
+	} else if !CheckMode() {
+		// check 子命令只校验配置，不监控文件、不申请证书
+		if err := startup(c); err != nil {
+			return err
+		}
 	}
-	jsonData, err := io.ReadAll(jsonFile)
+	runLoadCallbacks()
+	return nil
+}
+
+// readConfig 读取并解析配置文件（JSON / YAML / TOML），再叠加环境变量与命令行参数
+func readConfig(c string) error {
+	jsonData, err := os.ReadFile(c)
 	if nil != err {
-		fmt.Printf("read config file with error：%+v", err)
-		os.Exit(1)
+		return fmt.Errorf("read config file with error：%w", err)
 	}
-	err = json.Unmarshal(jsonData, Config)
-	if nil != err {
-		fmt.Printf("parse config with error：%+v", err)
-		os.Exit(1)
+	configData = jsonData
+	if err = decodeConfig(c, jsonData, Config); nil != err {
+		return fmt.Errorf("parse config with error：%w", err)
 	}
+	if err = applyOverrides(Config); err != nil {
+		return fmt.Errorf("override config with error：%w", err)
+	}
+	normalizeHosts(Config)
+	outType.Store(int32(Config.Out.Type))
+	routeMode.Store(Config.Mode)
+	tunEnabled.Store(Config.Tun.Enable)
+	systemProxyEnabled.Store(Config.SystemProxy.Enable)
+	relayBufferSize.Store(int32(parseRelayBuffer(Config.Memory.RelayBuffer)))
+	return nil
+}
 
-	// 启动配置文件监控（如果启用TUN或需要热重载）
-	if Config.Tun.Enable {
+// startup 按配置启动文件监控并准备入口的 TLS 证书
+func startup(c string) error {
+	// 启动配置文件监控（reload.watch，未设置时仅 TUN 模式下启用）
+	if watchEnabled() {
 		if err := StartConfigWatcher(c); err != nil {
 			// 配置文件监控失败不影响启动，只记录警告
 			fmt.Printf("启动配置文件监控失败：%+v\n", err)
 		}
 	}
-	// TLS 服务 (type=3) 和 WSS 服务 (type=4) 都需要配置 TLS 证书
-	if Config.In.Type == ServerTypeTLS || Config.In.Type == ServerTypeWSS {
-		if len(Config.In.ServerName) < 3 {
-			fmt.Printf("domain is wrong：%s", Config.In.ServerName)
-			os.Exit(1)
+	// TLS (type=3)、WSS (type=4)、QUIC (type=5)、gRPC (type=6)、SOCKS5 over TLS (type=7) 与混合入口 (type=8) 都需要配置 TLS 证书
+	if Config.In.Type < ServerTypeTLS || Config.In.Type > ServerTypeMixed {
+		return nil
+	}
+	var err error
+	// 自备证书时不经过 ACME
+	if Config.In.CertFile != "" {
+		if TLSConfig, err = fileTLSConfig(); nil != err {
+			return fmt.Errorf("can not load cert file：%w", err)
 		}
-		// read and agree to your CA's legal documents
-		certmagic.DefaultACME.Agreed = true
-		// provide an email address
-		certmagic.DefaultACME.Email = Config.In.Email
-		// use the staging endpoint while we're developing
-		certmagic.DefaultACME.CA = certmagic.LetsEncryptProductionCA
-
-		TLSConfig, err = certmagic.TLS([]string{Config.In.ServerName})
-		if nil != err {
-			fmt.Printf("can not get cert for domain：%+v", err)
-			os.Exit(1)
+	} else if err = issueTLSConfig(); err != nil {
+		return err
+	}
+	if err = applyHosts(TLSConfig); nil != err {
+		return fmt.Errorf("can not load in.hosts cert：%w", err)
+	}
+	if err = applyClientCA(TLSConfig); nil != err {
+		return fmt.Errorf("can not load client ca：%w", err)
+	}
+	return nil
+}
+
+// issueTLSConfig 通过 ACME 为 in.server_name 申请并自动续期证书
+func issueTLSConfig() error {
+	if len(Config.In.ServerName) < 3 {
+		return fmt.Errorf("domain is wrong：%s", Config.In.ServerName)
+	}
+	// read and agree to your CA's legal documents
+	certmagic.DefaultACME.Agreed = true
+	// provide an email address
+	certmagic.DefaultACME.Email = Config.In.Email
+	// use the staging endpoint while we're developing
+	certmagic.DefaultACME.CA = certmagic.LetsEncryptProductionCA
+
+	// in.hosts 中未自备证书的域名一并申请，通配域名无法经 HTTP / TLS-ALPN 验证，需自备证书
+	names := []string{Config.In.ServerName}
+	for _, h := range Config.In.Hosts {
+		if h.CertFile == "" && h.ServerName != "" && !strings.HasPrefix(h.ServerName, "*.") {
+			names = append(names, h.ServerName)
 		}
-		TLSConfig.NextProtos = append(TLSConfig.NextProtos, "http/1.1")
-		//TLSConfig.ServerName = Config.In.ServerName
 	}
+	var err error
+	TLSConfig, err = certmagic.TLS(names)
+	if nil != err {
+		return fmt.Errorf("can not get cert for domain：%w", err)
+	}
+	TLSConfig.NextProtos = append(TLSConfig.NextProtos, "http/1.1")
+	//TLSConfig.ServerName = Config.In.ServerName
+	return nil
 }
@@ -0,0 +1,14 @@
+package config
+
+import "time"
+
+const defaultKCPInterval = 10 * time.Millisecond
+
+// KCPInterval KCP 发送与重传的检查间隔，kcp.interval，默认 10ms
+func KCPInterval() time.Duration {
+	d := parseTimeout(Config.KCP.Interval, defaultKCPInterval)
+	if d <= 0 {
+		return defaultKCPInterval
+	}
+	return d
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"sync/atomic"
+
+	"proxy/utils/helper"
+)
+
+const (
+	defaultRelayBuffer     = 32 << 10
+	minRelayBuffer         = 4 << 10
+	maxRelayBuffer         = 64 << 10 // 缓冲池的最大规格
+	defaultMemoryHighWater = 90
+)
+
+// relayBufferSize 加载、重载配置时按 memory.relay_buffer 算好的缓冲区大小，转发时不必每次解析
+var relayBufferSize atomic.Int32
+
+// RelayBufferSize 转发时每个方向使用的缓冲区大小，memory.relay_buffer，默认 32KB，限制在 4KB~64KB
+func RelayBufferSize() int {
+	if n := relayBufferSize.Load(); n > 0 {
+		return int(n)
+	}
+	return defaultRelayBuffer
+}
+
+// parseRelayBuffer 解析 memory.relay_buffer，未配置或无效时为默认值，超出范围时取边界值
+func parseRelayBuffer(raw string) int {
+	n, err := helper.ParseBytes(raw)
+	switch {
+	case err != nil || n == 0:
+		return defaultRelayBuffer
+	case n < minRelayBuffer:
+		return minRelayBuffer
+	case n > maxRelayBuffer:
+		return maxRelayBuffer
+	}
+	return int(n)
+}
+
+// DNSCacheSize DoH 缓存的最大条目数，memory.dns_cache，0 表示不限
+func DNSCacheSize() int {
+	if Config.Memory.DNSCache < 0 {
+		return 0
+	}
+	return Config.Memory.DNSCache
+}
+
+// MemoryHighWater 拒绝新 TUN 连接的内存占用百分比（相对内存上限），memory.high_water，默认 90
+func MemoryHighWater() int {
+	if h := Config.Memory.HighWater; h > 0 && h <= 100 {
+		return h
+	}
+	return defaultMemoryHighWater
+}
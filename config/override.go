@@ -0,0 +1,94 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// override 可在配置文件之上覆盖的配置项，优先级：命令行参数 > 环境变量 > 配置文件
+// 便于容器与脚本部署时不必为 config.json 生成模板
+type override struct {
+	flag  string
+	env   string
+	usage string
+	value overrideValue
+	apply func(c *config, value string) error
+}
+
+var overrides = []*override{
+	{flag: "port", env: "CLT_IN_PORT", usage: "override in.port", apply: func(c *config, value string) error {
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 0 || port > 65535 {
+			return fmt.Errorf("invalid port")
+		}
+		c.In.Port = port
+		return nil
+	}},
+	{flag: "remote", env: "CLT_OUT_REMOTE_ADDR", usage: "override out.remote_addr", apply: func(c *config, value string) error {
+		c.Out.RemoteAddr = value
+		return nil
+	}},
+	{flag: "user", env: "CLT_USER", usage: "override user", apply: func(c *config, value string) error {
+		c.User = value
+		return nil
+	}},
+	{flag: "log-level", env: "CLT_LOG_LEVEL", usage: "override log.level", apply: func(c *config, value string) error {
+		c.Log.Level = value
+		return nil
+	}},
+	{flag: "tun", env: "CLT_TUN_ENABLE", usage: "override tun.enable", value: overrideValue{isBool: true}, apply: func(c *config, value string) error {
+		enable, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		c.Tun.Enable = enable
+		return nil
+	}},
+}
+
+// overrideValue 命令行参数的值，记录是否在命令行中出现过
+type overrideValue struct {
+	value  string
+	set    bool
+	isBool bool
+}
+
+func (v *overrideValue) String() string {
+	return v.value
+}
+
+func (v *overrideValue) Set(value string) error {
+	v.value, v.set = value, true
+	return nil
+}
+
+// IsBoolFlag 布尔项允许只写 -tun
+func (v *overrideValue) IsBoolFlag() bool {
+	return v.isBool
+}
+
+// registerOverrideFlags 注册覆盖配置用的命令行参数，需在 flag.Parse 之前调用
+func registerOverrideFlags() {
+	for _, o := range overrides {
+		flag.Var(&o.value, o.flag, fmt.Sprintf("%s (env %s)", o.usage, o.env))
+	}
+}
+
+// applyOverrides 把环境变量与命令行参数覆盖到配置上，启动与热重载时都会调用
+func applyOverrides(c *config) error {
+	for _, o := range overrides {
+		value, name := os.Getenv(o.env), o.env
+		if o.value.set {
+			value, name = o.value.value, "-"+o.flag
+		}
+		if value == "" {
+			continue
+		}
+		if err := o.apply(c, value); err != nil {
+			return fmt.Errorf("%s=%q: %w", name, value, err)
+		}
+	}
+	return nil
+}
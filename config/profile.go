@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	profileMu       sync.RWMutex
+	selectedProfile string // 运行时切换到的 profile，为空时使用配置中的 profile 字段
+)
+
+// mergeProfile 把选中的 profile 合并到配置上：对象逐层合并，数组与其它值整体替换
+// 返回生效的 profile 名，没有选中 profile 时为空
+func mergeProfile(root map[string]interface{}) (string, error) {
+	profiles, _ := root["profiles"].(map[string]interface{})
+	name, _ := root["profile"].(string)
+	profileMu.RLock()
+	if _, ok := profiles[selectedProfile]; ok {
+		name = selectedProfile
+	}
+	profileMu.RUnlock()
+	if name == "" {
+		return "", nil
+	}
+	overlay, ok := profiles[name].(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("profile %q not found in profiles", name)
+	}
+	mergeObject(root, overlay)
+	root["profile"] = name
+	return name, nil
+}
+
+func mergeObject(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if sub, ok := v.(map[string]interface{}); ok {
+			if base, ok := dst[k].(map[string]interface{}); ok {
+				mergeObject(base, sub)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+// ProfileNames 返回配置中定义的 profile 名称，按名称排序
+func ProfileNames() []string {
+	names := make([]string, 0, len(Config.Profiles))
+	for name := range Config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SwitchProfile 切换到指定 profile 并重新加载配置，由重载回调重新应用路由、TUN 与系统代理；
+// 失败时保留原来的 profile。运行时的选择在之后的重载中保持，直到重启
+func SwitchProfile(name string) error {
+	reloadMu.RLock()
+	_, ok := Config.Profiles[name]
+	reloadMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("profile %q not found", name)
+	}
+	profileMu.Lock()
+	prev := selectedProfile
+	selectedProfile = name
+	profileMu.Unlock()
+	if err := ReloadConfig(); err != nil {
+		profileMu.Lock()
+		selectedProfile = prev
+		profileMu.Unlock()
+		return err
+	}
+	return nil
+}
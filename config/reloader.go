@@ -1,13 +1,11 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"path"
-	"strings"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -15,10 +13,13 @@ import (
 )
 
 var (
-	configWatcher *fsnotify.Watcher
-	configPath    string
-	reloadMu      sync.RWMutex
+	watcherMu       sync.Mutex
+	configWatcher   *fsnotify.Watcher
+	configPath      string
+	reloadMu        sync.RWMutex
 	reloadCallbacks []func()
+	loadCallbacks   []func()
+	loadOnce        sync.Once
 )
 
 // StartConfigWatcher 启动配置文件监控
@@ -28,16 +29,22 @@ func StartConfigWatcher(configFile string) error {
 	}
 
 	// 解析配置文件路径
-	if strings.Index(configFile, "/") != 0 {
+	if !filepath.IsAbs(configFile) {
 		p, err := os.Getwd()
 		if nil != err {
 			return fmt.Errorf("无法获取工作目录: %w", err)
 		}
-		configFile = path.Join(p, configFile)
+		configFile = filepath.Join(p, configFile)
 	}
 
 	configPath = configFile
 
+	watcherMu.Lock()
+	defer watcherMu.Unlock()
+	if configWatcher != nil {
+		return nil
+	}
+
 	// 创建文件监控器
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -47,20 +54,22 @@ func StartConfigWatcher(configFile string) error {
 	configWatcher = watcher
 
 	// 监控配置文件所在目录
-	configDir := path.Dir(configFile)
+	configDir := filepath.Dir(configFile)
 	if err := watcher.Add(configDir); err != nil {
 		watcher.Close()
 		return fmt.Errorf("添加监控目录失败: %w", err)
 	}
 
 	// 启动监控goroutine
-	go watchConfigFile()
+	go watchConfigFile(watcher)
 
 	return nil
 }
 
 // StopConfigWatcher 停止配置文件监控
 func StopConfigWatcher() {
+	watcherMu.Lock()
+	defer watcherMu.Unlock()
 	if configWatcher != nil {
 		configWatcher.Close()
 		configWatcher = nil
@@ -74,15 +83,54 @@ func RegisterReloadCallback(callback func()) {
 	reloadCallbacks = append(reloadCallbacks, callback)
 }
 
+// RegisterLoadCallback 注册首次加载配置后执行的回调（读取规则文件、用户与限速等），需在 Load 之前注册，通常在包的 init 中
+func RegisterLoadCallback(callback func()) {
+	loadCallbacks = append(loadCallbacks, callback)
+}
+
+// runLoadCallbacks 按注册顺序执行加载回调，只执行一次
+func runLoadCallbacks() {
+	loadOnce.Do(func() {
+		for _, callback := range loadCallbacks {
+			callback()
+		}
+	})
+}
+
+// watchEnabled 是否监控配置文件：reload.watch 未设置时仅在启用 TUN 时监控
+func watchEnabled() bool {
+	if Config.Reload.Watch != nil {
+		return *Config.Reload.Watch
+	}
+	return TunEnabled()
+}
+
+// applyWatch 重载后按 reload.watch 启停配置文件监控
+func applyWatch() {
+	watcherMu.Lock()
+	running := configWatcher != nil
+	watcherMu.Unlock()
+	if want := watchEnabled(); want && !running {
+		if err := StartConfigWatcher(configPath); err != nil {
+			log.Printf("启动配置文件监控失败: %v", err)
+		} else {
+			log.Printf("配置文件监控已开启")
+		}
+	} else if !want && running {
+		StopConfigWatcher()
+		log.Printf("配置文件监控已关闭")
+	}
+}
+
 // watchConfigFile 监控配置文件变化
-func watchConfigFile() {
+func watchConfigFile(watcher *fsnotify.Watcher) {
 	debounceTimer := time.NewTimer(0)
 	debounceTimer.Stop()
 	var debounceDelay = 500 * time.Millisecond
 
 	for {
 		select {
-		case event, ok := <-configWatcher.Events:
+		case event, ok := <-watcher.Events:
 			if !ok {
 				return
 			}
@@ -92,8 +140,8 @@ func watchConfigFile() {
 				continue
 			}
 
-			// 文件写入或重命名
-			if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Rename == fsnotify.Rename {
+			// 文件写入、重命名或被替换（编辑器原子保存）
+			if event.Op&(fsnotify.Write|fsnotify.Rename|fsnotify.Create) != 0 {
 				// 防抖：延迟处理
 				debounceTimer.Reset(debounceDelay)
 				<-debounceTimer.C
@@ -106,7 +154,7 @@ func watchConfigFile() {
 				}
 			}
 
-		case err, ok := <-configWatcher.Errors:
+		case err, ok := <-watcher.Errors:
 			if !ok {
 				return
 			}
@@ -134,21 +182,84 @@ func ReloadConfig() error {
 
 	// 创建临时配置对象
 	var newConfig config
-	if err := json.Unmarshal(jsonData, &newConfig); err != nil {
+	if err := decodeConfig(configPath, jsonData, &newConfig); err != nil {
 		return fmt.Errorf("解析配置文件失败: %w", err)
 	}
+	if err := applyOverrides(&newConfig); err != nil {
+		return fmt.Errorf("覆盖配置失败: %w", err)
+	}
+	normalizeHosts(&newConfig)
+
+	apply(&newConfig)
+	return nil
+}
+
+// Apply 以 c 替换当前配置并执行重载回调，供嵌入时不经配置文件直接传入配置；未调用 Load 时同时执行加载回调
+func Apply(c *Settings) {
+	if c == Config {
+		reloadMu.Lock()
+		tunEnabled.Store(c.Tun.Enable)
+		systemProxyEnabled.Store(c.SystemProxy.Enable)
+		reloadMu.Unlock()
+		runLoadCallbacks()
+		return
+	}
+	normalizeHosts(c)
+	reloadMu.Lock()
+	apply(c)
+	reloadMu.Unlock()
+	// 嵌入时不调用 Load，在此完成首次加载
+	runLoadCallbacks()
+}
 
+// apply 更新配置并执行回调，调用方需持有 reloadMu 写锁
+func apply(newConfig *config) {
 	// 原子性更新配置
 	Config.Debug = newConfig.Debug
 	Config.User = newConfig.User
 	Config.ECSSubnet = newConfig.ECSSubnet
 	Config.In = newConfig.In
 	Config.Out = newConfig.Out
+	outType.Store(int32(newConfig.Out.Type))
+	Config.Retry = newConfig.Retry
+	Config.Subscription = newConfig.Subscription
+	Config.Forward = newConfig.Forward
+	Config.Reverse = newConfig.Reverse
+	Config.DNS = newConfig.DNS
+	Config.Mode = newConfig.Mode
+	routeMode.Store(newConfig.Mode)
 	Config.WhiteList = newConfig.WhiteList
 	Config.BlackList = newConfig.BlackList
+	Config.Tor = newConfig.Tor
+	Config.MITM = newConfig.MITM
+	Config.AdBlock = newConfig.AdBlock
+	Config.Script = newConfig.Script
 	Config.ChinaIpFile = newConfig.ChinaIpFile
 	Config.GFWListFile = newConfig.GFWListFile
+	// 文件中的开关未变化时保留管理接口切换后的状态，避免无关的重载撤销切换
+	if newConfig.Tun.Enable != Config.Tun.Enable {
+		tunEnabled.Store(newConfig.Tun.Enable)
+	}
+	if newConfig.SystemProxy.Enable != Config.SystemProxy.Enable {
+		systemProxyEnabled.Store(newConfig.SystemProxy.Enable)
+	}
 	Config.Tun = newConfig.Tun
+	Config.SystemProxy = newConfig.SystemProxy
+	Config.Limit = newConfig.Limit
+	Config.Users = newConfig.Users
+	Config.Metrics = newConfig.Metrics
+	Config.Stats = newConfig.Stats
+	Config.Tracing = newConfig.Tracing
+	Config.Memory = newConfig.Memory
+	relayBufferSize.Store(int32(parseRelayBuffer(newConfig.Memory.RelayBuffer)))
+	Config.Admin = newConfig.Admin
+	Config.Reload = newConfig.Reload
+	Config.Shutdown = newConfig.Shutdown
+	Config.Timeouts = newConfig.Timeouts
+	Config.TCP = newConfig.TCP
+	Config.KCP = newConfig.KCP
+	Config.Profile = newConfig.Profile
+	Config.Profiles = newConfig.Profiles
 	Config.Log = newConfig.Log
 
 	// 重新加载规则引擎（通过回调函数，避免循环导入）
@@ -158,7 +269,5 @@ func ReloadConfig() error {
 	for _, callback := range reloadCallbacks {
 		callback()
 	}
-
-	return nil
+	applyWatch()
 }
-
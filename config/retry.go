@@ -0,0 +1,35 @@
+package config
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	defaultRetryAttempts   = 2
+	defaultRetryBackoff    = 200 * time.Millisecond
+	defaultRetryMaxBackoff = 2 * time.Second
+)
+
+// RetryAttempts 出口握手失败后的重试次数，retry.attempts，默认 2
+func RetryAttempts() int {
+	if Config.Retry.Attempts == nil {
+		return defaultRetryAttempts
+	}
+	return max(*Config.Retry.Attempts, 0)
+}
+
+// RetryBackoff 第 n 次（从 1 开始）重试前的等待时间：retry.backoff 按次数翻倍，不超过 retry.max_backoff，
+// 再在 d/2 到 d 之间随机取值，避免大量连接同时失败后在同一时刻重试
+func RetryBackoff(n int) time.Duration {
+	d := parseTimeout(Config.Retry.Backoff, defaultRetryBackoff)
+	limit := parseTimeout(Config.Retry.MaxBackoff, defaultRetryMaxBackoff)
+	for i := 1; i < n && d < limit; i++ {
+		d *= 2
+	}
+	d = min(d, limit)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+func chdirForService(file string) {
+	if ok, err := svc.IsWindowsService(); err == nil && ok {
+		_ = os.Chdir(filepath.Dir(file))
+	}
+}
@@ -0,0 +1,22 @@
+package config
+
+import "time"
+
+const defaultTCPKeepAlive = 15 * time.Second
+
+// TCPKeepAlive TCP keepalive 探测间隔，tcp.keep_alive，默认 15s；返回 0 表示关闭
+func TCPKeepAlive() time.Duration {
+	if Config.TCP.KeepAlive == "" {
+		return defaultTCPKeepAlive
+	}
+	d, err := time.ParseDuration(Config.TCP.KeepAlive)
+	if err != nil || d < 0 {
+		return defaultTCPKeepAlive
+	}
+	return d
+}
+
+// TCPNoDelay 是否设置 TCP_NODELAY，tcp.no_delay，默认开启
+func TCPNoDelay() bool {
+	return Config.TCP.NoDelay == nil || *Config.TCP.NoDelay
+}
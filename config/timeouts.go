@@ -0,0 +1,64 @@
+package config
+
+import "time"
+
+const (
+	defaultHandshakeTimeout  = 4 * time.Second
+	defaultDialTimeout       = 10 * time.Second
+	defaultIdleTimeout       = 0
+	defaultUDPSessionTimeout = 5 * time.Minute
+	defaultUDPMappingTimeout = time.Minute
+	defaultAuthTimeWindow    = time.Minute
+)
+
+// HandshakeTimeout 入口与出口握手（TLS、SOCKS/HTTP 请求头、认证头）的超时，timeouts.handshake，默认 4s
+func HandshakeTimeout() time.Duration {
+	if d := parseTimeout(Config.Timeouts.Handshake, defaultHandshakeTimeout); d > 0 {
+		return d
+	}
+	return defaultHandshakeTimeout
+}
+
+// DialTimeout 连接远端与 DoH 查询的超时，timeouts.dial，默认 10s
+func DialTimeout() time.Duration {
+	if d := parseTimeout(Config.Timeouts.Dial, defaultDialTimeout); d > 0 {
+		return d
+	}
+	return defaultDialTimeout
+}
+
+// IdleTimeout 转发中的 TCP 连接两个方向都没有数据超过该时间后断开，timeouts.idle，默认 0 即不限
+func IdleTimeout() time.Duration {
+	return parseTimeout(Config.Timeouts.Idle, defaultIdleTimeout)
+}
+
+// UDPSessionTimeout UDP 会话没有数据超过该时间后结束，timeouts.udp_session，默认 5m，0 表示不限
+func UDPSessionTimeout() time.Duration {
+	return parseTimeout(Config.Timeouts.UDPSession, defaultUDPSessionTimeout)
+}
+
+// UDPMappingTimeout 服务端 UDP 映射（出站套接字）在会话断开后保留的时间，客户端在此期间重连可继续使用同一外部端口，
+// timeouts.udp_mapping，默认 1m，0 表示随会话关闭
+func UDPMappingTimeout() time.Duration {
+	return parseTimeout(Config.Timeouts.UDPMapping, defaultUDPMappingTimeout)
+}
+
+// AuthTimeWindow 服务端接受的认证头时间戳与本机时钟的最大偏差，in.time_window，默认 60s
+func AuthTimeWindow() time.Duration {
+	if d := parseTimeout(Config.In.TimeWindow, defaultAuthTimeWindow); d > 0 {
+		return d
+	}
+	return defaultAuthTimeWindow
+}
+
+// parseTimeout 未配置或格式错误时使用默认值，配置错误由 check 子命令报告
+func parseTimeout(raw string, def time.Duration) time.Duration {
+	if raw == "" {
+		return def
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 0 {
+		return def
+	}
+	return d
+}
@@ -0,0 +1,28 @@
+package config
+
+const (
+	defaultTorSocks   = "127.0.0.1:9050"
+	defaultTorDataDir = "tor-data"
+)
+
+// TorEnabled 是否配置了 Tor 出口（tor 下任一项非空）
+func TorEnabled() bool {
+	tor := Config.Tor
+	return tor.Socks != "" || tor.Binary != "" || len(tor.Rules) > 0
+}
+
+// TorSocks Tor 的 SOCKS 端口，tor.socks，默认 127.0.0.1:9050
+func TorSocks() string {
+	if Config.Tor.Socks == "" {
+		return defaultTorSocks
+	}
+	return Config.Tor.Socks
+}
+
+// TorDataDir 启动 tor 时使用的数据目录，tor.data_dir，默认 tor-data
+func TorDataDir() string {
+	if Config.Tor.DataDir == "" {
+		return defaultTorDataDir
+	}
+	return Config.Tor.DataDir
+}
@@ -18,31 +18,68 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"proxy/config"
 	"proxy/server"
-	_ "proxy/server"
+	"proxy/server/crash"
 	utilContext "proxy/utils/context"
 	"proxy/utils/logger"
 )
 
 func main() {
+	// 主协程未捕获的 panic 写入崩溃报告并恢复系统设置后退出
+	defer crash.Handle()
+	// 解析命令行参数并加载配置文件，各包在加载回调中读取规则文件、用户与限速等
+	if err := config.Load(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	gCtx := utilContext.NewContext()
 
-	// 确保程序退出时恢复系统代理（即使异常退出）
-	defer func() {
-		if config.Config.SystemProxy.Enable {
-			logger.Info(gCtx, map[string]interface{}{
+	// 子命令模式：执行完直接退出
+	if len(config.Args) > 0 {
+		os.Exit(runCommand(gCtx, config.Args))
+	}
+
+	p, err := server.New(config.Config)
+	if err != nil {
+		logger.Error(gCtx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"error":  err,
+		}, "create proxy failed")
+		os.Exit(-1)
+	}
+
+	if config.PidFile != "" {
+		if err := os.WriteFile(config.PidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+			logger.Error(gCtx, map[string]interface{}{
 				"action": config.ActionRuntime,
-			}, "program exiting, restoring system proxy...")
-			server.RestoreSystemProxy(gCtx)
+				"error":  err,
+				"file":   config.PidFile,
+			}, "write pid file failed")
 		}
+		defer removePidFile()
+	}
+	// 入口监听就绪后通知 systemd
+	go func() {
+		<-p.Ready()
+		notifyReady()
 	}()
 
+	// 由 Windows 服务管理器启动时，停止请求代替信号触发优雅关闭
+	if inService() {
+		code := runAsService(gCtx, p)
+		removePidFile()
+		os.Exit(code)
+	}
+
 	// 创建一个可取消的上下文用于优雅关闭
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -50,78 +87,48 @@ func main() {
 	// 等待中断信号
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-
-	// 信号处理
 	go func() {
 		sig := <-quit
 		logger.Info(gCtx, map[string]interface{}{
 			"action": config.ActionRuntime,
 			"signal": sig.String(),
 		}, "Received shutdown signal, gracefully shutting down...")
-
-		// 设置关闭超时上下文
-		shutdownCtx, shutdownCancel := context.WithTimeout(ctx, 30*time.Second)
-		defer shutdownCancel()
-
-		// 创建关闭完成通道
-		shutdownDone := make(chan struct{})
-
-		go func() {
-			defer func() {
-				// 确保即使发生 panic 也会恢复系统代理
-				if r := recover(); r != nil {
-					logger.Error(gCtx, map[string]interface{}{
-						"action": config.ActionRuntime,
-						"error":  r,
-					}, "panic during shutdown, attempting to restore system proxy")
-				}
-				
-				// 无论是否启用 SystemProxy，都尝试恢复（防止配置丢失）
-				if config.Config.SystemProxy.Enable {
-					logger.Info(gCtx, map[string]interface{}{
-						"action": config.ActionRuntime,
-					}, "restoring system proxy settings...")
-					server.RestoreSystemProxy(gCtx)
-				}
-			}()
-
-			// 停止 TUN 服务
-			server.StopTunService()
-
-			// 恢复系统代理配置（必须在 TUN 停止后）
-			if config.Config.SystemProxy.Enable {
-				server.RestoreSystemProxy(gCtx)
-			}
-
-			close(shutdownDone)
-		}()
-
-		// 等待关闭完成或超时
-		select {
-		case <-shutdownDone:
-			logger.Info(gCtx, map[string]interface{}{
-				"action": config.ActionRuntime,
-			}, "Graceful shutdown completed")
-		case <-shutdownCtx.Done():
-			logger.Warn(gCtx, map[string]interface{}{
-				"action": config.ActionRuntime,
-			}, "Shutdown timeout, forcing exit")
-			// 超时后仍然尝试恢复系统代理
-			if config.Config.SystemProxy.Enable {
-				logger.Warn(gCtx, map[string]interface{}{
-					"action": config.ActionRuntime,
-				}, "attempting to restore system proxy before force exit")
-				server.RestoreSystemProxy(gCtx)
-			}
-		}
-
-		cancel() // 通知主 goroutine 退出
+		cancel()
 	}()
 
-	// 阻塞直到收到取消信号
-	<-ctx.Done()
+	// 阻塞直到收到信号或启动失败
+	runErr := p.Run(ctx)
+
+	// 设置关闭超时上下文（连接宽限期之外再留 30 秒），停止 TUN 并恢复系统代理
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), p.GracePeriod()+30*time.Second)
+	defer shutdownCancel()
+	if err := p.Shutdown(shutdownCtx); err != nil {
+		logger.Warn(gCtx, map[string]interface{}{
+			"action": config.ActionRuntime,
+		}, "Shutdown timeout, forcing exit")
+	} else {
+		logger.Info(gCtx, map[string]interface{}{
+			"action": config.ActionRuntime,
+		}, "Graceful shutdown completed")
+	}
+	if runErr != nil {
+		removePidFile()
+		os.Exit(-1)
+	}
 
 	logger.Info(gCtx, map[string]interface{}{
 		"action": config.ActionRuntime,
 	}, "Server exited")
 }
+
+// removePidFile 删除 pidfile；平滑重启后文件已由新进程改写，此时保留
+func removePidFile() {
+	if config.PidFile == "" {
+		return
+	}
+	buf, err := os.ReadFile(config.PidFile)
+	if err != nil || strings.TrimSpace(string(buf)) != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	_ = os.Remove(config.PidFile)
+}
@@ -0,0 +1,453 @@
+// Package admin 提供仅限本机访问的管理接口，供 GUI 和脚本查询状态、调整运行参数
+package admin
+
+import (
+	context2 "context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"proxy/config"
+	"proxy/server/conntrack"
+	"proxy/server/metrics"
+	"proxy/server/proxy/client"
+	"proxy/server/quota"
+	"proxy/server/route"
+	"proxy/server/stats"
+	"proxy/server/subscription"
+	"proxy/server/upgrade"
+	"proxy/utils/context"
+	"proxy/utils/logger"
+)
+
+var startTime = time.Now()
+
+// Serve 在 addr 上提供管理接口，阻塞直到监听失败
+// addr 必须是本机回环地址，且必须配置 token
+func Serve(ctx context2.Context, addr, token string) {
+	if err := CheckListen(addr, token); err != nil {
+		logger.Error(ctx, map[string]interface{}{
+			"action":    config.ActionRuntime,
+			"errorCode": logger.ErrCodeListen,
+			"error":     err,
+		}, "admin api disabled")
+		return
+	}
+	listener, err := upgrade.Listen(addr)
+	if err != nil {
+		logger.Errorf(ctx, map[string]interface{}{
+			"action":    config.ActionRuntime,
+			"errorCode": logger.ErrCodeListen,
+			"error":     err,
+		}, "can not listen on %v for admin api: %v", addr, err)
+		return
+	}
+	logger.Info(ctx, map[string]interface{}{
+		"action": config.ActionRuntime,
+		"listen": listener.Addr().String(),
+	}, "admin api started")
+	if err := http.Serve(listener, Handler(token)); err != nil && !errors.Is(err, net.ErrClosed) {
+		logger.Error(ctx, map[string]interface{}{
+			"action":    config.ActionRuntime,
+			"errorCode": logger.ErrCodeListen,
+			"error":     err,
+		}, "admin api stopped")
+	}
+}
+
+// CheckListen 管理接口只允许监听回环地址，并且必须设置 token
+func CheckListen(addr, token string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host != "localhost" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return fmt.Errorf("admin.listen must be a loopback address, got %s", addr)
+		}
+	}
+	if token == "" {
+		return errors.New("admin.token is required")
+	}
+	return nil
+}
+
+// Handler 返回管理接口路由，/api/ 下的接口需要 token，面板页面与健康检查本身不含敏感数据
+func Handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/api/", authenticate(token, apiHandler()))
+	// 健康检查供容器编排与监控探测，不含敏感信息，不需要 token
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /readyz", handleReadyz)
+	if config.Config.Admin.Pprof {
+		mux.Handle("/debug/pprof/", authenticate(token, debugHandler()))
+	}
+	mux.Handle("GET /{$}", dashboardHandler())
+	return mux
+}
+
+// apiHandler /api/ 下的接口，管理接口经 token 校验后访问，控制套接字直接访问
+func apiHandler() http.Handler {
+	api := http.NewServeMux()
+	api.HandleFunc("GET /api/status", handleStatus)
+	api.HandleFunc("GET /api/summary", handleSummary)
+	api.HandleFunc("GET /api/connections", handleConnections)
+	api.HandleFunc("DELETE /api/connections/{id}", handleKillConnection)
+	api.HandleFunc("GET /api/routes", handleRoutes)
+	api.HandleFunc("GET /api/traffic", handleTraffic)
+	api.HandleFunc("GET /api/domains", handleDomains)
+	api.HandleFunc("GET /api/stats", handleStats)
+	api.HandleFunc("GET /api/health", handleHealth)
+	api.HandleFunc("GET /api/selftest", handleSelfTest)
+	api.HandleFunc("POST /api/speedtest", handleSpeedTest)
+	api.HandleFunc("GET /api/users", handleUsers)
+	api.HandleFunc("GET /api/runtime", handleRuntime)
+	api.HandleFunc("POST /api/reload", handleReload)
+	api.HandleFunc("GET /api/log/level", handleGetLogLevel)
+	api.HandleFunc("PUT /api/log/level", handleSetLogLevel)
+	api.HandleFunc("GET /api/outbound", handleGetOutbound)
+	api.HandleFunc("PUT /api/outbound", handleSetOutbound)
+	api.HandleFunc("GET /api/mode", handleGetMode)
+	api.HandleFunc("PUT /api/mode", handleSetMode)
+	api.HandleFunc("GET /api/nodes", handleNodes)
+	api.HandleFunc("PUT /api/nodes/selected", handleSelectNode)
+	api.HandleFunc("GET /api/profiles", handleProfiles)
+	api.HandleFunc("PUT /api/profiles/active", handleSwitchProfile)
+	api.HandleFunc("GET /api/toggles", handleGetToggles)
+	api.HandleFunc("PUT /api/toggles/{name}", handleSetToggle)
+	return api
+}
+
+// authenticate 校验 Authorization: Bearer <token>
+func authenticate(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// Status 运行状态
+type Status struct {
+	StartTime   string  `json:"start_time"`
+	Uptime      float64 `json:"uptime_s"`
+	Goroutines  int     `json:"goroutines"`
+	Connections int     `json:"connections"`
+	InType      int8    `json:"in_type"`
+	InPort      int     `json:"in_port"`
+	OutType     int8    `json:"out_type"`
+	Mode        string  `json:"mode"`
+	RemoteAddr  string  `json:"remote_addr"`
+	Tun         bool    `json:"tun"`
+	LogLevel    string  `json:"log_level"`
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, currentStatus())
+}
+
+func currentStatus() Status {
+	return Status{
+		StartTime:   startTime.In(config.CstZone).Format(config.TimeFormat),
+		Uptime:      time.Since(startTime).Truncate(time.Second).Seconds(),
+		Goroutines:  runtime.NumGoroutine(),
+		Connections: conntrack.Count(),
+		InType:      config.Config.In.Type,
+		InPort:      config.Config.In.Port,
+		OutType:     config.OutType(),
+		Mode:        config.RouteMode(),
+		RemoteAddr:  config.Config.Out.RemoteAddr,
+		Tun:         config.TunEnabled(),
+		LogLevel:    logger.GetLevel(),
+	}
+}
+
+// Summary 运行概况：运行状态、远端是否可达、各出口最近一次握手与最近的告警和错误日志，供 status 子命令使用
+type Summary struct {
+	Status
+	RemoteDown bool                   `json:"remote_down"`
+	Health     []metrics.RemoteHealth `json:"health"`
+	Errors     []logger.Record        `json:"recent_errors"`
+}
+
+func handleSummary(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Summary{
+		Status:     currentStatus(),
+		RemoteDown: client.RemoteDown(),
+		Health:     metrics.Health(),
+		Errors:     logger.Recent(),
+	})
+}
+
+func handleConnections(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, conntrack.List())
+}
+
+// handleKillConnection 终止指定连接
+func handleKillConnection(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !conntrack.Kill(id) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("connection %d not found", id))
+		return
+	}
+	logger.Info(context.NewContext(), map[string]interface{}{
+		"action": config.ActionRuntime,
+		"connID": id,
+	}, "connection killed via admin api")
+	writeJSON(w, http.StatusOK, map[string]uint64{"killed": id})
+}
+
+func handleRoutes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, route.RecentDecisions())
+}
+
+// Traffic 累计流量
+type Traffic struct {
+	Up   int64 `json:"up_bytes"`
+	Down int64 `json:"down_bytes"`
+}
+
+func handleTraffic(w http.ResponseWriter, r *http.Request) {
+	up, down := conntrack.Traffic()
+	writeJSON(w, http.StatusOK, Traffic{Up: up, Down: down})
+}
+
+func handleDomains(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, conntrack.Domains())
+}
+
+// handleStats 最近 days 天（默认 7）的流量报表，列出流量最多的 top 个域名（默认 20）
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	days, top := 7, 20
+	for name, v := range map[string]*int{"days": &days, "top": &top} {
+		raw := r.URL.Query().Get(name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s %q", name, raw))
+			return
+		}
+		*v = n
+	}
+	writeJSON(w, http.StatusOK, stats.BuildReport(days, top))
+}
+
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, metrics.Health())
+}
+
+func handleUsers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, quota.List())
+}
+
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	if err := config.ReloadConfig(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	logger.Info(context.NewContext(), map[string]interface{}{
+		"action": config.ActionRuntime,
+	}, "config reloaded by admin api")
+	writeJSON(w, http.StatusOK, map[string]string{"result": "reloaded"})
+}
+
+// LogLevel 日志级别请求/响应
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+func handleGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, LogLevel{Level: logger.GetLevel()})
+}
+
+func handleSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req LogLevel
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := logger.SetLevel(req.Level); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, LogLevel{Level: logger.GetLevel()})
+}
+
+// Outbound 出口请求/响应
+type Outbound struct {
+	Type       int8   `json:"type"` // 1: remote tls 2: remote wss 3: direct 4: subscription node
+	RemoteAddr string `json:"remote_addr,omitempty"`
+}
+
+func handleGetOutbound(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Outbound{Type: config.OutType(), RemoteAddr: config.Config.Out.RemoteAddr})
+}
+
+// handleSetOutbound 切换出口类型，只影响之后新建的连接
+func handleSetOutbound(w http.ResponseWriter, r *http.Request) {
+	var req Outbound
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	switch req.Type {
+	case config.RemoteTypeTLS, config.RemoteTypeWSS, config.RemoteTypeQUIC, config.RemoteTypeGRPC, config.RemoteTypeKCP:
+		if len(config.RemoteAddrs()) == 0 {
+			writeError(w, http.StatusBadRequest, errors.New("out.remote_addr is not configured"))
+			return
+		}
+	case config.RemoteTypeHTTPConnect:
+		if config.Config.Out.HTTPProxy.Addr == "" {
+			writeError(w, http.StatusBadRequest, errors.New("out.http_proxy.addr is not configured"))
+			return
+		}
+	case config.RemoteTypeSubscription:
+		if _, err := subscription.Selected(); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	case config.RemoteTypeDirect:
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown outbound type %d", req.Type))
+		return
+	}
+	config.SetOutType(req.Type)
+	logger.Info(context.NewContext(), map[string]interface{}{
+		"action":  config.ActionRuntime,
+		"outType": req.Type,
+	}, "outbound switched via admin api")
+	handleGetOutbound(w, r)
+}
+
+// Mode 分流模式请求/响应
+type Mode struct {
+	Mode string `json:"mode"` // rule / global / direct
+}
+
+func handleGetMode(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Mode{Mode: config.RouteMode()})
+}
+
+// handleSetMode 切换分流模式，只影响之后新建的连接；重载配置后恢复为配置文件中的 mode
+func handleSetMode(w http.ResponseWriter, r *http.Request) {
+	var req Mode
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	switch req.Mode {
+	case config.ModeRule, config.ModeGlobal, config.ModeDirect:
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown mode %q", req.Mode))
+		return
+	}
+	config.SetRouteMode(req.Mode)
+	logger.Info(context.NewContext(), map[string]interface{}{
+		"action": config.ActionRuntime,
+		"mode":   req.Mode,
+	}, "route mode switched via admin api")
+	handleGetMode(w, r)
+}
+
+// Node 订阅节点及其状态
+type Node struct {
+	*subscription.Node
+	Selected bool   `json:"selected"`
+	Error    string `json:"error,omitempty"` // 不能作为出口的原因
+}
+
+func handleNodes(w http.ResponseWriter, r *http.Request) {
+	current, _ := subscription.Selected()
+	nodes := subscription.Nodes()
+	list := make([]Node, 0, len(nodes))
+	for _, n := range nodes {
+		item := Node{Node: n, Selected: n == current}
+		if err := n.Supported(); err != nil {
+			item.Error = err.Error()
+		}
+		list = append(list, item)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleSelectNode 切换订阅节点，只影响之后新建的连接
+func handleSelectNode(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := subscription.Select(req.Name); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	logger.Info(context.NewContext(), map[string]interface{}{
+		"action": config.ActionRuntime,
+		"node":   req.Name,
+	}, "subscription node switched via admin api")
+	handleNodes(w, r)
+}
+
+// Profiles profile 列表与当前生效的 profile
+type Profiles struct {
+	Active   string   `json:"active"`
+	Profiles []string `json:"profiles"`
+}
+
+func handleProfiles(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Profiles{Active: config.Config.Profile, Profiles: config.ProfileNames()})
+}
+
+// handleSwitchProfile 切换 profile 并重新加载配置
+func handleSwitchProfile(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, ok := config.Config.Profiles[req.Name]; !ok {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("profile %q not found", req.Name))
+		return
+	}
+	if err := config.SwitchProfile(req.Name); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	logger.Info(context.NewContext(), map[string]interface{}{
+		"action":  config.ActionRuntime,
+		"profile": req.Name,
+	}, "profile switched via admin api")
+	handleProfiles(w, r)
+}
@@ -0,0 +1,77 @@
+package admin
+
+import (
+	context2 "context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+
+	"proxy/config"
+	"proxy/utils/logger"
+)
+
+// 控制套接字：本机的 Unix 域套接字（Windows 10 1803 起同样支持），提供与 /api/ 相同的接口，
+// 访问由套接字文件的权限（仅当前用户）控制，不需要 token，供 status 子命令在没有开启管理接口时查询
+
+var control struct {
+	mu       sync.Mutex
+	listener net.Listener
+	path     string
+	info     os.FileInfo // 绑定后的套接字文件，退出时只删除仍属于本进程的文件
+}
+
+// ServeControl 在 path 上提供控制套接字，阻塞直到 StopControl 或监听失败
+func ServeControl(ctx context2.Context, path string) {
+	// 残留的套接字文件（进程异常退出或平滑重启时旧进程仍持有）直接替换，新连接由本进程处理
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		logger.Error(ctx, map[string]interface{}{
+			"action":    config.ActionRuntime,
+			"errorCode": logger.ErrCodeListen,
+			"error":     err,
+			"path":      path,
+		}, "control socket disabled")
+		return
+	}
+	// 与 net.Listen 之间存在很短的窗口，由所在目录的权限兜底
+	_ = os.Chmod(path, 0600)
+	info, _ := os.Lstat(path)
+	control.mu.Lock()
+	control.listener, control.path, control.info = l, path, info
+	control.mu.Unlock()
+	logger.Info(ctx, map[string]interface{}{
+		"action": config.ActionRuntime,
+		"path":   path,
+	}, "control socket started")
+	mux := http.NewServeMux()
+	mux.Handle("/api/", apiHandler())
+	if err := http.Serve(l, mux); err != nil && !errors.Is(err, net.ErrClosed) {
+		logger.Error(ctx, map[string]interface{}{
+			"action":    config.ActionRuntime,
+			"errorCode": logger.ErrCodeListen,
+			"error":     err,
+		}, "control socket stopped")
+	}
+}
+
+// StopControl 关闭控制套接字并删除套接字文件（已被新进程替换时保留）
+func StopControl() {
+	control.mu.Lock()
+	defer control.mu.Unlock()
+	if control.listener == nil {
+		return
+	}
+	// 关闭时 net 包会删除套接字文件，先确认文件仍是本进程绑定的那个
+	if info, err := os.Lstat(control.path); err != nil || control.info == nil || !os.SameFile(info, control.info) {
+		if l, ok := control.listener.(*net.UnixListener); ok {
+			l.SetUnlinkOnClose(false)
+		}
+	}
+	_ = control.listener.Close()
+	control.listener = nil
+}
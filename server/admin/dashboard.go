@@ -0,0 +1,18 @@
+package admin
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed dashboard/index.html
+var dashboardHTML []byte
+
+// dashboardHandler 返回内嵌的单页面板，数据由页面携带 token 调用 /api/ 获取
+func dashboardHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write(dashboardHTML)
+	})
+}
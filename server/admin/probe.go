@@ -0,0 +1,80 @@
+package admin
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Probe 一项健康检查，返回是否正常与说明
+type Probe struct {
+	Live  bool // 同时用于存活检查 /healthz；否则只用于就绪检查 /readyz
+	Check func() (ok bool, detail string)
+}
+
+var probes = struct {
+	mu    sync.Mutex
+	items map[string]Probe
+}{items: make(map[string]Probe)}
+
+// RegisterProbe 注册一项健康检查，同名覆盖
+func RegisterProbe(name string, p Probe) {
+	probes.mu.Lock()
+	defer probes.mu.Unlock()
+	probes.items[name] = p
+}
+
+// ProbeResult 一项检查的结果
+type ProbeResult struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ProbeReport /healthz 与 /readyz 的响应
+type ProbeReport struct {
+	Status string        `json:"status"` // ok 或 fail
+	Checks []ProbeResult `json:"checks"`
+}
+
+// runProbes 执行检查，live 为 true 时只执行存活检查
+func runProbes(live bool) ProbeReport {
+	probes.mu.Lock()
+	items := make(map[string]Probe, len(probes.items))
+	for name, p := range probes.items {
+		if !live || p.Live {
+			items[name] = p
+		}
+	}
+	probes.mu.Unlock()
+	report := ProbeReport{Status: "ok", Checks: make([]ProbeResult, 0, len(items))}
+	for name, p := range items {
+		ok, detail := p.Check()
+		if !ok {
+			report.Status = "fail"
+		}
+		report.Checks = append(report.Checks, ProbeResult{Name: name, OK: ok, Detail: detail})
+	}
+	sort.Slice(report.Checks, func(i, j int) bool {
+		return report.Checks[i].Name < report.Checks[j].Name
+	})
+	return report
+}
+
+// handleHealthz 存活检查：入口监听与 TUN 等本机组件，失败时返回 503，可据此重启进程
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeProbes(w, runProbes(true))
+}
+
+// handleReadyz 就绪检查：在存活检查之外还检查远端是否可达与最近的 DoH 查询，失败时返回 503
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	writeProbes(w, runProbes(false))
+}
+
+func writeProbes(w http.ResponseWriter, report ProbeReport) {
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
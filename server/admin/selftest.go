@@ -0,0 +1,31 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+)
+
+var selfTest struct {
+	mu  sync.Mutex
+	run func() interface{}
+}
+
+// SetSelfTest 设置 /api/selftest 执行的自检，返回值以 JSON 输出
+func SetSelfTest(run func() interface{}) {
+	selfTest.mu.Lock()
+	defer selfTest.mu.Unlock()
+	selfTest.run = run
+}
+
+// handleSelfTest 执行连通性与泄露自检，耗时可能达数十秒
+func handleSelfTest(w http.ResponseWriter, r *http.Request) {
+	selfTest.mu.Lock()
+	run := selfTest.run
+	selfTest.mu.Unlock()
+	if run == nil {
+		writeError(w, http.StatusNotImplemented, errors.New("self test is not available"))
+		return
+	}
+	writeJSON(w, http.StatusOK, run())
+}
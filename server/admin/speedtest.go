@@ -0,0 +1,79 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 单次测速的上限，避免长时间占用出口带宽
+const (
+	maxSpeedTestCount    = 20
+	maxSpeedTestDuration = time.Minute
+)
+
+// SpeedTestRequest /api/speedtest 的请求体，字段含义同 bench 子命令的参数
+type SpeedTestRequest struct {
+	Via       int8   `json:"via"`        // 出口类型（取值同 out.type），0 时测 out.type 对应的出口并与直连对比
+	URL       string `json:"url"`        // 下载测速的地址，为空时使用默认地址
+	Upload    bool   `json:"upload"`     // 是否测上传
+	UploadURL string `json:"upload_url"` // 上传测速的地址，为空时使用默认地址
+	Count     int    `json:"n"`          // 每个出口的握手次数，默认 5
+	Duration  string `json:"t"`          // 每个出口的下载与上传时长，默认 10s
+}
+
+var speedTest struct {
+	mu      sync.Mutex
+	run     func(req SpeedTestRequest, duration time.Duration) (interface{}, error)
+	running sync.Mutex // 同一时间只进行一次测速
+}
+
+// SetSpeedTest 设置 /api/speedtest 执行的测速，返回值以 JSON 输出
+func SetSpeedTest(run func(req SpeedTestRequest, duration time.Duration) (interface{}, error)) {
+	speedTest.mu.Lock()
+	defer speedTest.mu.Unlock()
+	speedTest.run = run
+}
+
+// handleSpeedTest 经指定出口测速，耗时约为出口数 ×（握手 + 下载与上传时长）
+func handleSpeedTest(w http.ResponseWriter, r *http.Request) {
+	speedTest.mu.Lock()
+	run := speedTest.run
+	speedTest.mu.Unlock()
+	if run == nil {
+		writeError(w, http.StatusNotImplemented, errors.New("speed test is not available"))
+		return
+	}
+	req := SpeedTestRequest{Count: 5}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	duration := 10 * time.Second
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 || d > maxSpeedTestDuration {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("t must be a duration up to %s, got %q", maxSpeedTestDuration, req.Duration))
+			return
+		}
+		duration = d
+	}
+	if req.Count < 1 || req.Count > maxSpeedTestCount {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("n must be between 1 and %d, got %d", maxSpeedTestCount, req.Count))
+		return
+	}
+	if !speedTest.running.TryLock() {
+		writeError(w, http.StatusConflict, errors.New("another speed test is running"))
+		return
+	}
+	defer speedTest.running.Unlock()
+	result, err := run(req, duration)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,67 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Toggle 可在管理接口中开关的功能，如 TUN、系统代理
+type Toggle struct {
+	Get func() bool
+	Set func(enable bool) error
+}
+
+var toggles = struct {
+	mu    sync.Mutex
+	items map[string]Toggle
+}{items: make(map[string]Toggle)}
+
+// RegisterToggle 注册一个开关，同名覆盖
+func RegisterToggle(name string, t Toggle) {
+	toggles.mu.Lock()
+	defer toggles.mu.Unlock()
+	toggles.items[name] = t
+}
+
+// ToggleState 开关状态
+type ToggleState struct {
+	Name   string `json:"name"`
+	Enable bool   `json:"enable"`
+}
+
+func handleGetToggles(w http.ResponseWriter, r *http.Request) {
+	toggles.mu.Lock()
+	list := make([]ToggleState, 0, len(toggles.items))
+	for name, t := range toggles.items {
+		list = append(list, ToggleState{Name: name, Enable: t.Get()})
+	}
+	toggles.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	writeJSON(w, http.StatusOK, list)
+}
+
+func handleSetToggle(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	toggles.mu.Lock()
+	t, ok := toggles.items[name]
+	toggles.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown toggle %s", name))
+		return
+	}
+	var req ToggleState
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := t.Set(req.Enable); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ToggleState{Name: name, Enable: t.Get()})
+}
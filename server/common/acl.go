@@ -0,0 +1,131 @@
+package common
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"proxy/config"
+	"proxy/utils/context"
+	"proxy/utils/logger"
+)
+
+// NewACLListener 包装 l，Accept 时按 in.allow_clients 与 in.max_conns_per_ip 检查客户端地址，不符合的连接直接关闭；
+// 每次 Accept 读取当前配置，重载后对新连接立即生效。本机回环地址始终允许，TUN 与系统代理经 127.0.0.1 连接入口；
+// 经 PROXY protocol 时只有连接的真实对端与头中的客户端都是回环地址才豁免，远端不能伪造回环地址绕过检查
+func NewACLListener(l net.Listener) net.Listener {
+	return &aclListener{Listener: l, conns: make(map[netip.Addr]int)}
+}
+
+type aclListener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns map[netip.Addr]int // 客户端 IP -> 当前连接数
+}
+
+func (l *aclListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := remoteIP(conn)
+		if reason := l.admit(ip, ip.IsLoopback() && socketIP(conn).IsLoopback()); reason != "" {
+			logger.ErrorAggregated(context.NewContext(), "acl:"+reason+":"+ip.String(), map[string]interface{}{
+				"action":    config.ActionSocketOperate,
+				"errorCode": logger.ErrCodeAccept,
+				"source":    conn.RemoteAddr().String(),
+				"reason":    reason,
+			})
+			_ = conn.Close()
+			continue
+		}
+		return &aclConn{Conn: conn, l: l, ip: ip}, nil
+	}
+}
+
+// admit 检查并占用 ip 的一个连接名额，拒绝时返回原因；loopback 为 true 时不受白名单与单 IP 连接数限制
+func (l *aclListener) admit(ip netip.Addr, loopback bool) string {
+	if !loopback && !clientAllowed(ip) {
+		return "not in in.allow_clients"
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limit := config.Config.In.MaxConnsPerIP; limit > 0 && !loopback && l.conns[ip] >= limit {
+		return "too many connections"
+	}
+	l.conns[ip]++
+	return ""
+}
+
+func (l *aclListener) release(ip netip.Addr) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[ip]--; l.conns[ip] <= 0 {
+		delete(l.conns, ip)
+	}
+}
+
+// aclConn 关闭时归还客户端 IP 的连接名额
+type aclConn struct {
+	net.Conn
+	l    *aclListener
+	ip   netip.Addr
+	once sync.Once
+}
+
+func (c *aclConn) Close() error {
+	c.once.Do(func() {
+		c.l.release(c.ip)
+	})
+	return c.Conn.Close()
+}
+
+func (c *aclConn) CloseWrite() error {
+	return HalfClose(c.Conn)
+}
+
+func (c *aclConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+func remoteIP(conn net.Conn) netip.Addr {
+	return addrIP(conn.RemoteAddr())
+}
+
+// socketIP 连接的真实对端地址，经 PROXY protocol 时为发送头的负载均衡，而不是头中的客户端
+func socketIP(conn net.Conn) netip.Addr {
+	if pc, ok := conn.(*proxyProtoConn); ok {
+		return addrIP(pc.Conn.RemoteAddr())
+	}
+	return addrIP(conn.RemoteAddr())
+}
+
+func addrIP(addr net.Addr) netip.Addr {
+	if ap, err := netip.ParseAddrPort(addr.String()); err == nil {
+		return ap.Addr().Unmap()
+	}
+	return netip.Addr{}
+}
+
+// clientAllowed ip 是否在 in.allow_clients 中，未配置时全部允许
+func clientAllowed(ip netip.Addr) bool {
+	list := config.Config.In.AllowClients
+	return len(list) == 0 || inList(ip, list)
+}
+
+// inList ip 是否匹配 list 中的某个 IP 或网段
+func inList(ip netip.Addr, list []string) bool {
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if prefix, err := netip.ParsePrefix(item); err == nil {
+			if prefix.Contains(ip) {
+				return true
+			}
+		} else if addr, err := netip.ParseAddr(item); err == nil && addr.Unmap() == ip {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// clockSkewMagic 服务端因时间戳超出允许范围拒绝认证时，用该用户的密钥加密回复 magic 与服务端时间（Unix 秒），
+// 只有持有密钥的客户端能读出，探测者看到的仍是伪装页面之前的一段随机数据
+var clockSkewMagic = []byte{0xc1, 0x0c, 0x5e, 0x3a, 0x9d, 0x27, 0xb4, 0x61}
+
+// ErrClockSkew 远端因本机时钟偏差拒绝了认证，属于 ErrAuth
+var ErrClockSkew = Wrap(ErrAuth, errors.New("remote rejected the handshake because of clock skew"))
+
+// WriteClockSkew 服务端回复本机时间，客户端据此校正之后的时间戳
+func WriteClockSkew(w io.Writer) error {
+	buf := make([]byte, len(clockSkewMagic)+8)
+	copy(buf, clockSkewMagic)
+	binary.BigEndian.PutUint64(buf[len(clockSkewMagic):], uint64(time.Now().Unix()))
+	_, err := w.Write(buf)
+	return err
+}
+
+func parseClockSkew(p []byte) (int64, bool) {
+	if len(p) < len(clockSkewMagic)+8 || !bytes.Equal(p[:len(clockSkewMagic)], clockSkewMagic) {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(p[len(clockSkewMagic):])), true
+}
+
+// WatchClockSkew 客户端检查收到的开头数据是否为服务端的时间回复，是则把服务端时间交给 fn，这次读取返回 ErrClockSkew
+func (s *Chacha20Stream) WatchClockSkew(fn func(serverTime int64)) {
+	s.onSkew = fn
+}
+
+// readClockSkew 读取并解密对端开头的数据，直到足够判断是否为时间回复：读满回复的长度、开头已与 magic 不符或读取出错。
+// 是时间回复时返回 true，否则读到的数据与错误留给之后的 Read；时间回复可能分多段到达
+func (s *Chacha20Stream) readClockSkew() bool {
+	onSkew := s.onSkew
+	s.onSkew = nil
+	buf := make([]byte, len(clockSkewMagic)+8)
+	n := 0
+	for n < len(buf) {
+		m, err := s.conn.Read(buf[n:])
+		s.decoder.XORKeyStream(buf[n:n+m], buf[n:n+m])
+		n += m
+		if err != nil {
+			s.headErr = err
+			break
+		}
+		if !bytes.HasPrefix(clockSkewMagic, buf[:min(n, len(clockSkewMagic))]) {
+			break
+		}
+	}
+	if serverTime, ok := parseClockSkew(buf[:n]); ok {
+		onSkew(serverTime)
+		return true
+	}
+	s.head = buf[:n]
+	return false
+}
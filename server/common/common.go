@@ -1,6 +1,7 @@
 package common
 
 import (
+	context2 "context"
 	"crypto/rand"
 	"io"
 	"net"
@@ -9,17 +10,18 @@ import (
 
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/chacha20"
-	"proxy/utils/context"
+	"proxy/config"
 )
 
 type Server interface {
-	Start(l net.Listener)
-	Handshake(ctx *context.Context, conn net.Conn) (io.ReadWriter, *TargetAddr, error)
+	// Start 在 l 上接受连接，ctx 取消时关闭 l 并返回，已建立的连接不受影响
+	Start(ctx context2.Context, l net.Listener)
+	Handshake(ctx context2.Context, conn net.Conn) (io.ReadWriter, *TargetAddr, error)
 	Name() string
 }
 
 type Remote interface {
-	Handshake(ctx *context.Context, target *TargetAddr) (io.ReadWriter, error)
+	Handshake(ctx context2.Context, target *TargetAddr) (io.ReadWriter, error)
 	Name() string
 }
 
@@ -31,10 +33,16 @@ type CipherStream interface {
 
 // Chacha20Stream 加密链接
 type Chacha20Stream struct {
-	key     []byte
-	encoder *chacha20.Cipher
-	decoder *chacha20.Cipher
-	conn    net.Conn
+	key        []byte
+	encoder    *chacha20.Cipher
+	decoder    *chacha20.Cipher
+	conn       net.Conn
+	nonce      []byte       // 对端发来的 nonce
+	onSkew     func(int64)  // 见 WatchClockSkew
+	head       []byte       // 判断时间回复时已读到、尚未交给调用方的明文
+	headErr    error        // 判断时间回复时读取遇到的错误，head 交出后返回
+	onFeedback func(net.IP) // 见 WatchFeedback
+	feedback   []byte       // 已读到的部分回送帧
 }
 
 func NewChacha20Stream(key []byte, conn net.Conn) *Chacha20Stream {
@@ -46,31 +54,79 @@ func NewChacha20Stream(key []byte, conn net.Conn) *Chacha20Stream {
 	return s
 }
 
+// AcceptChacha20Stream 服务端读取 nonce 与 len(head) 字节的明文头，依次用候选密钥解密，
+// 返回第一个使 check 通过的密钥下标及对应的流，head 中为解密后的明文
+func AcceptChacha20Stream(keys [][]byte, conn net.Conn, head []byte, check func(head []byte) bool) (*Chacha20Stream, int, error) {
+	buf := make([]byte, chacha20.NonceSizeX+len(head))
+	conn.SetReadDeadline(time.Now().Add(config.HandshakeTimeout()))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, -1, errors.Wrap(err, "can't read nonce from stream")
+	}
+	conn.SetReadDeadline(time.Time{})
+	nonce, sealed := buf[:chacha20.NonceSizeX], buf[chacha20.NonceSizeX:]
+	for i, key := range keys {
+		decoder, err := chacha20.NewUnauthenticatedCipher(key, nonce)
+		if err != nil {
+			continue
+		}
+		decoder.XORKeyStream(head, sealed)
+		if check(head) {
+			return &Chacha20Stream{key: key, decoder: decoder, conn: conn, nonce: nonce}, i, nil
+		}
+	}
+	return nil, -1, Wrap(ErrAuth, errors.New("no key matched"))
+}
+
 func (s *Chacha20Stream) Read(p []byte) (int, error) {
 	if s.decoder == nil {
 		nonce := make([]byte, chacha20.NonceSizeX)
-		s.conn.SetReadDeadline(time.Now().Add(time.Second * 4))
+		s.conn.SetReadDeadline(time.Now().Add(config.HandshakeTimeout()))
 		if n, err := io.ReadAtLeast(s.conn, nonce, len(nonce)); err != nil || n != len(nonce) {
-			return n, errors.New("can't read nonce from stream: " + err.Error())
+			if n == 0 && err == io.EOF {
+				// 对端未发送任何数据就半关闭了写方向
+				return 0, io.EOF
+			}
+			return n, errors.Wrap(err, "can't read nonce from stream")
 		}
 		s.conn.SetReadDeadline(time.Time{})
 		decoder, err := chacha20.NewUnauthenticatedCipher(s.key, nonce)
 		if err != nil {
 			return 0, errors.New("generate decoder failed: " + err.Error())
 		}
-		s.decoder = decoder
+		s.decoder, s.nonce = decoder, nonce
+	}
+
+	if s.onSkew != nil && s.readClockSkew() {
+		return 0, ErrClockSkew
 	}
 
-	n, err := s.conn.Read(p)
-	if err != nil || n == 0 {
+	for {
+		var n int
+		var err error
+		if len(s.head) > 0 || s.headErr != nil {
+			n = copy(p, s.head)
+			if s.head = s.head[n:]; len(s.head) == 0 {
+				err, s.headErr = s.headErr, nil
+			}
+		} else if n, err = s.conn.Read(p); n > 0 {
+			// QUIC 流等可能在返回最后一段数据的同时返回 io.EOF，读到的数据都要解密；原地解密，XORKeyStream 允许 dst 与 src 完全重叠
+			s.decoder.XORKeyStream(p[:n], p[:n])
+		}
+		if n > 0 && s.onFeedback != nil {
+			used := s.readFeedback(p[:n])
+			n = copy(p, p[used:n])
+			if n == 0 && err == nil {
+				// 这段数据只有回送帧，继续读取
+				continue
+			}
+		}
 		return n, err
 	}
+}
 
-	dst := make([]byte, n)
-	pn := p[:n]
-	s.decoder.XORKeyStream(dst, pn)
-	copy(pn, dst)
-	return n, nil
+// Nonce 对端发来的 nonce，读到对端数据之前为 nil
+func (s *Chacha20Stream) Nonce() []byte {
+	return s.nonce
 }
 
 func (s *Chacha20Stream) Write(p []byte) (int, error) {
@@ -85,31 +141,63 @@ func (s *Chacha20Stream) Write(p []byte) (int, error) {
 		if err != nil {
 			return 0, err
 		}
-		s.conn.SetWriteDeadline(time.Now().Add(time.Second * 4))
+		s.conn.SetWriteDeadline(time.Now().Add(config.HandshakeTimeout()))
 		if n, err := s.conn.Write(nonce); err != nil || n != len(nonce) {
 			return 0, errors.New("write nonce failed: " + err.Error())
 		}
 		s.conn.SetWriteDeadline(time.Time{})
 	}
-	dst := make([]byte, len(p))
-	s.encoder.XORKeyStream(dst, p)
-	return s.conn.Write(dst)
+	// p 属于调用方不能改写，分块加密到池化缓冲区后写出
+	buf := GetBuffer(config.RelayBufferSize())
+	defer PutBuffer(buf)
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if len(chunk) > len(buf) {
+			chunk = chunk[:len(buf)]
+		}
+		s.encoder.XORKeyStream(buf[:len(chunk)], chunk)
+		n, err := s.conn.Write(buf[:len(chunk)])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
 }
 
 func (s *Chacha20Stream) Close() error {
 	return s.conn.Close()
 }
 
+// CloseWrite 半关闭底层连接的写方向
+func (s *Chacha20Stream) CloseWrite() error {
+	return HalfClose(s.conn)
+}
+
+// 反向隧道使用的 TargetAddr.Proto 取值
+const (
+	ProtoReverse     = 4 // 控制连接，目标为服务端要监听的地址
+	ProtoReverseData = 5 // 数据连接，目标主机名为服务端经控制连接下发的连接 ID
+)
+
+// ProtoTCPFeedback 请求头中的协议取值：TCP 连接，服务端连上目标后先回送实际连接的 IP，见 WriteFeedback
+const ProtoTCPFeedback = 6
+
+// ValidProto 加密入口接受的协议：TCP、UDP、反向隧道与带解析回送的 TCP
+func ValidProto(proto uint16) bool {
+	return proto == 1 || proto == 3 || proto == ProtoReverse || proto == ProtoReverseData || proto == ProtoTCPFeedback
+}
+
 // TargetAddr An Addr represents an address that you want to access by proxy. Either Name or IP is used exclusively.
 type TargetAddr struct {
 	Name     string // fully-qualified domain name
 	IP       net.IP
 	Port     int
-	Proto    uint16       // protocol 1: tcp 3: udp
+	Proto    uint16       // protocol 1: tcp 3: udp 4/5: reverse tunnel
 	UdpConn  *net.UDPConn // local udp connection
 	UdpAddr  *net.UDPAddr // local udp addr
-	RUdpConn *net.UDPConn // remote udp connection
-	RUdpAddr *net.UDPAddr // remote udp addr
+	Feedback bool         // 服务端：客户端以 ProtoTCPFeedback 请求，连上目标后先回送实际连接的 IP（Proto 已改为 1）
 }
 
 // Return host:port string
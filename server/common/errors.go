@@ -0,0 +1,89 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+
+	"github.com/gorilla/websocket"
+)
+
+// 握手与转发错误的类别。入口与出口用 Wrap 标注错误，重试、回退、日志与指标经 errors.Is 或 Classify 按类别处理，
+// 不依赖错误信息中的字符串
+var (
+	// ErrAuth 认证失败：未知用户、重放的握手、时间差过大
+	ErrAuth = errors.New("authentication failed")
+	// ErrTimeout 拨号、握手或 DNS 查询超时
+	ErrTimeout = errors.New("timeout")
+	// ErrRemoteUnreachable 目标或代理服务端连不上：拒绝连接、网络不可达、域名解析失败、kill switch 拒绝
+	ErrRemoteUnreachable = errors.New("remote unreachable")
+	// ErrProtocol 对端发来的数据不符合协议：消息格式错误、不支持的命令或协议
+	ErrProtocol = errors.New("protocol error")
+)
+
+// classError 标注了类别的错误，错误信息不变，errors.Is 对类别与原错误都成立
+type classError struct {
+	class error
+	err   error
+}
+
+func (e *classError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classError) Unwrap() []error {
+	return []error{e.class, e.err}
+}
+
+// Wrap 把 err 标注为 class 类别（ErrAuth 等），err 为 nil 时返回 nil
+func Wrap(class, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classError{class: class, err: err}
+}
+
+// Classify 返回 err 的类别：优先取 Wrap 标注的类别，未标注时从网络错误推断超时与不可达，无法归类时返回 nil
+func Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, class := range []error{ErrAuth, ErrProtocol, ErrTimeout, ErrRemoteUnreachable} {
+		if errors.Is(err, class) {
+			return class
+		}
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) ||
+		errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrTimeout
+	}
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	if errors.As(err, &dnsErr) || errors.As(err, &opErr) && opErr.Op == "dial" {
+		return ErrRemoteUnreachable
+	}
+	return nil
+}
+
+// ErrorClass 错误类别的简称，用作指标标签与日志字段：auth、timeout、unreachable、protocol，无法归类时为 other
+func ErrorClass(err error) string {
+	switch Classify(err) {
+	case ErrAuth:
+		return "auth"
+	case ErrTimeout:
+		return "timeout"
+	case ErrRemoteUnreachable:
+		return "unreachable"
+	case ErrProtocol:
+		return "protocol"
+	}
+	return "other"
+}
+
+// IsClosed 是否为连接已被本端或对端关闭导致的错误，转发结束时的这类错误不需要记录
+func IsClosed(err error) bool {
+	return errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, websocket.ErrCloseSent)
+}
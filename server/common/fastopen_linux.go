@@ -0,0 +1,16 @@
+package common
+
+import "golang.org/x/sys/unix"
+
+// fastOpenQueue 监听端 TCP_FASTOPEN 的队列长度，即尚未完成握手、已携带数据的连接数上限
+const fastOpenQueue = 256
+
+// setFastOpenConnect 出站连接的 SYN 携带首次写入的数据，需内核 net.ipv4.tcp_fastopen 开启客户端（第 1 位）
+func setFastOpenConnect(fd uintptr) error {
+	return unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, 1)
+}
+
+// setFastOpenListen 监听端接受 SYN 携带的数据，需内核 net.ipv4.tcp_fastopen 开启服务端（第 2 位）
+func setFastOpenListen(fd uintptr) error {
+	return unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN, fastOpenQueue)
+}
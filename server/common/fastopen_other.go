@@ -0,0 +1,13 @@
+//go:build !linux
+
+package common
+
+import "errors"
+
+func setFastOpenConnect(fd uintptr) error {
+	return errors.ErrUnsupported
+}
+
+func setFastOpenListen(fd uintptr) error {
+	return errors.ErrUnsupported
+}
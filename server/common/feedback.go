@@ -0,0 +1,57 @@
+package common
+
+import (
+	"io"
+	"net"
+)
+
+// 解析回送：客户端以 ProtoTCPFeedback 发起 TCP 请求时，服务端连上目标后先回送一帧（1 字节长度与 4 或 16 字节的 IP，
+// 经上游中继等无法得知时长度为 0），之后才是目标的数据。客户端据此得知服务端为域名实际连接的 IP
+
+// SplitProto 把请求头中的协议取值拆为实际协议与是否需要回送
+func SplitProto(proto uint16) (uint16, bool) {
+	if proto == ProtoTCPFeedback {
+		return 1, true
+	}
+	return proto, false
+}
+
+// WriteFeedback 服务端回送实际连接的 IP，ip 为 nil 时回送空帧
+func WriteFeedback(w io.Writer, ip net.IP) error {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	buf := make([]byte, 1, 1+len(ip))
+	buf[0] = byte(len(ip))
+	buf = append(buf, ip...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// WatchFeedback 客户端从读到的数据中取出服务端的回送帧，把其中的 IP 交给 fn（空帧时为 nil），回送帧不返回给调用方
+func (s *Chacha20Stream) WatchFeedback(fn func(ip net.IP)) {
+	s.onFeedback = fn
+}
+
+// readFeedback 从 p 开头读取回送帧，返回用掉的字节数；帧读完后调用 onFeedback
+func (s *Chacha20Stream) readFeedback(p []byte) int {
+	used := 0
+	for used < len(p) {
+		if len(s.feedback) > 0 && len(s.feedback) == 1+int(s.feedback[0]) {
+			break
+		}
+		s.feedback = append(s.feedback, p[used])
+		used++
+	}
+	if len(s.feedback) == 0 || len(s.feedback) < 1+int(s.feedback[0]) {
+		return used
+	}
+	var ip net.IP
+	if n := s.feedback[0]; n == net.IPv4len || n == net.IPv6len {
+		ip = net.IP(append([]byte(nil), s.feedback[1:]...))
+	}
+	fn := s.onFeedback
+	s.onFeedback, s.feedback = nil, nil
+	fn(ip)
+	return used
+}
@@ -0,0 +1,186 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultGunService 未配置 grpc_service 时使用的 gRPC 服务名
+const DefaultGunService = "GunService"
+
+// GunPath gun 传输的请求路径 /<服务名>/Tun，与 v2ray 的 gRPC 传输一致，CDN 按此路径转发
+func GunPath(service string) string {
+	if service == "" {
+		service = DefaultGunService
+	}
+	return "/" + service + "/Tun"
+}
+
+// GunConn 在 HTTP/2 流上按 gun 格式收发数据，适配为 net.Conn：
+// 每次写入封装为一条 gRPC 消息，消息体是只含 bytes 字段 1 的 protobuf（Hunk）
+// 截止时间到期时直接关闭连接，只用于握手阶段的超时；关闭后读写返回 net.ErrClosed
+type GunConn struct {
+	r       io.Reader
+	w       io.Writer
+	flush   func()
+	onClose func()
+	local   net.Addr
+	remote  net.Addr
+
+	rbuf   []byte // 读缓冲，data 指向其中尚未读出的部分
+	data   []byte
+	wbuf   []byte
+	wmu    sync.Mutex
+	once   sync.Once
+	closed atomic.Bool
+	tmu    sync.Mutex
+	timer  [2]*time.Timer // 读、写截止时间
+}
+
+// NewGunConn 从 r 读取、向 w 写入 gun 消息；flush 在每次写入后调用，onClose 在 Close 时调用一次，均可为 nil
+func NewGunConn(r io.Reader, w io.Writer, flush, onClose func(), local, remote net.Addr) *GunConn {
+	return &GunConn{r: r, w: w, flush: flush, onClose: onClose, local: local, remote: remote}
+}
+
+func (c *GunConn) Read(p []byte) (int, error) {
+	for len(c.data) == 0 {
+		if err := c.readMessage(); err != nil {
+			return 0, c.closedError(err)
+		}
+	}
+	n := copy(p, c.data)
+	c.data = c.data[n:]
+	return n, nil
+}
+
+// readMessage 读取下一条 gRPC 消息并取出 Hunk.data，压缩过的消息与其他字段视为错误
+func (c *GunConn) readMessage() error {
+	var head [5]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return err
+	}
+	if head[0] != 0 {
+		return Wrap(ErrProtocol, errors.New("gun: compressed message is not supported"))
+	}
+	size := int(binary.BigEndian.Uint32(head[1:]))
+	if cap(c.rbuf) < size {
+		c.rbuf = make([]byte, size)
+	}
+	msg := c.rbuf[:size]
+	if _, err := io.ReadFull(c.r, msg); err != nil {
+		return err
+	}
+	if size == 0 {
+		c.data = nil
+		return nil
+	}
+	if msg[0] != 0x0a {
+		return Wrap(ErrProtocol, errors.New("gun: unexpected protobuf field"))
+	}
+	l, k := binary.Uvarint(msg[1:])
+	if k <= 0 || uint64(len(msg)-1-k) != l {
+		return Wrap(ErrProtocol, errors.New("gun: malformed message"))
+	}
+	c.data = msg[1+k:]
+	return nil
+}
+
+func (c *GunConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	var varint [binary.MaxVarintLen64]byte
+	k := binary.PutUvarint(varint[:], uint64(len(p)))
+	size := 1 + k + len(p)
+	if cap(c.wbuf) < 5+size {
+		c.wbuf = make([]byte, 5+size)
+	}
+	buf := c.wbuf[:5+size]
+	buf[0] = 0
+	binary.BigEndian.PutUint32(buf[1:5], uint32(size))
+	buf[5] = 0x0a
+	copy(buf[6:], varint[:k])
+	copy(buf[6+k:], p)
+	if _, err := c.w.Write(buf); err != nil {
+		return 0, c.closedError(err)
+	}
+	if c.flush != nil {
+		c.flush()
+	}
+	return len(p), nil
+}
+
+func (c *GunConn) Close() error {
+	c.once.Do(func() {
+		c.closed.Store(true)
+		c.tmu.Lock()
+		for _, t := range c.timer {
+			if t != nil {
+				t.Stop()
+			}
+		}
+		c.tmu.Unlock()
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return nil
+}
+
+func (c *GunConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *GunConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *GunConn) SetDeadline(t time.Time) error {
+	c.setDeadline(0, t)
+	c.setDeadline(1, t)
+	return nil
+}
+
+func (c *GunConn) SetReadDeadline(t time.Time) error {
+	c.setDeadline(0, t)
+	return nil
+}
+
+func (c *GunConn) SetWriteDeadline(t time.Time) error {
+	c.setDeadline(1, t)
+	return nil
+}
+
+func (c *GunConn) setDeadline(i int, t time.Time) {
+	c.tmu.Lock()
+	defer c.tmu.Unlock()
+	if c.timer[i] != nil {
+		c.timer[i].Stop()
+		c.timer[i] = nil
+	}
+	if !t.IsZero() {
+		c.timer[i] = time.AfterFunc(time.Until(t), func() {
+			_ = c.Close()
+		})
+	}
+}
+
+// CloseWrite 客户端一侧结束请求体，对端读到 io.EOF；服务端一侧的响应无法单独结束，返回 errors.ErrUnsupported
+func (c *GunConn) CloseWrite() error {
+	if w, ok := c.w.(io.Closer); ok {
+		return w.Close()
+	}
+	return errors.ErrUnsupported
+}
+
+// closedError 关闭后 HTTP/2 流的读写错误（如 body closed）统一为 net.ErrClosed
+func (c *GunConn) closedError(err error) error {
+	if c.closed.Load() {
+		return net.ErrClosed
+	}
+	return err
+}
@@ -0,0 +1,52 @@
+package common
+
+import (
+	"errors"
+	"io"
+)
+
+// CloseWriter 可以只关闭写方向的连接，如 *net.TCPConn、*tls.Conn；
+// 包装连接的类型实现 CloseWrite 时转发给内层连接，内层不支持时返回 errors.ErrUnsupported
+type CloseWriter interface {
+	CloseWrite() error
+}
+
+// HalfClose 半关闭 v 的写方向，对端读到 EOF 后仍可继续发送；v 不支持半关闭时返回 errors.ErrUnsupported
+func HalfClose(v interface{}) error {
+	if cw, ok := v.(CloseWriter); ok {
+		return cw.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
+
+// Stream 转发的一个方向：从 Src 读出写入 Dst，Src 读完后半关闭 Conn 的写方向。
+// Dst 通常是 Conn 外面包了计数、限速的 Writer，CloseWrite 需作用在 Conn 上
+type Stream struct {
+	Dst  io.Writer
+	Src  io.Reader
+	Conn interface{}
+}
+
+// Relay 双向转发 up（客户端到远端）与 down（远端到客户端）。一个方向读到 EOF 时半关闭该方向的目标连接，
+// 把 FIN 传给另一端，另一个方向继续转发，git、依赖半关闭的 REST 客户端等因此不会一直等到超时。
+// down 方向结束后，客户端连接支持半关闭时等 up 方向也结束再返回，否则立即返回，由调用方关闭两端；
+// 返回时 up 方向尚未结束的，upErr 为 nil
+func Relay(up, down Stream) (upErr, downErr error) {
+	upDone := make(chan error, 1)
+	go func() {
+		_, err := Copy(up.Dst, up.Src)
+		if err == nil {
+			_ = HalfClose(up.Conn)
+		}
+		upDone <- err
+	}()
+	_, downErr = Copy(down.Dst, down.Src)
+	if downErr == nil && HalfClose(down.Conn) == nil {
+		return <-upDone, nil
+	}
+	select {
+	case upErr = <-upDone:
+	default:
+	}
+	return upErr, downErr
+}
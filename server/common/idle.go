@@ -0,0 +1,66 @@
+package common
+
+import (
+	"io"
+	"time"
+)
+
+// IdleTimer 超过 timeout 没有调用 Touch 时执行 onIdle，用于断开长时间无数据的连接
+// timeout 为 0 时 NewIdleTimer 返回 nil，nil 的各方法均为空操作
+type IdleTimer struct {
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func NewIdleTimer(timeout time.Duration, onIdle func()) *IdleTimer {
+	if timeout <= 0 {
+		return nil
+	}
+	return &IdleTimer{timer: time.AfterFunc(timeout, onIdle), timeout: timeout}
+}
+
+// Touch 有数据收发，重新计时
+func (t *IdleTimer) Touch() {
+	if t != nil {
+		t.timer.Reset(t.timeout)
+	}
+}
+
+// Stop 停止计时，转发结束后调用
+func (t *IdleTimer) Stop() {
+	if t != nil {
+		t.timer.Stop()
+	}
+}
+
+// Reader 包装 r，每次读到数据时 Touch
+func (t *IdleTimer) Reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &idleReader{Reader: r, timer: t}
+}
+
+type idleReader struct {
+	io.Reader
+	timer *IdleTimer
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.timer.Touch()
+	}
+	return n, err
+}
+
+// RelayIdle 在 client 与 remote 之间双向转发并半关闭，见 Relay；两个方向都超过 timeout 没有数据时调用 onIdle，
+// onIdle 需关闭两端使转发结束，避免对端休眠、断网后连接一直堆积。timeout 为 0 时不限
+func RelayIdle(client, remote io.ReadWriter, timeout time.Duration, onIdle func()) (upErr, downErr error) {
+	idle := NewIdleTimer(timeout, onIdle)
+	defer idle.Stop()
+	return Relay(
+		Stream{Dst: remote, Src: idle.Reader(client), Conn: remote},
+		Stream{Dst: client, Src: idle.Reader(remote), Conn: client},
+	)
+}
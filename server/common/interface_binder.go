@@ -1,12 +1,14 @@
 package common
 
 import (
+	context2 "context"
 	"net"
 	"runtime"
 	"sync"
-	"time"
+	"sync/atomic"
+	"syscall"
 
-	"proxy/utils/context"
+	"proxy/config"
 	"proxy/utils/logger"
 )
 
@@ -14,27 +16,83 @@ var (
 	globalDialer     *net.Dialer
 	globalDialerOnce sync.Once
 	globalDialerMu   sync.RWMutex
+	socketMark       atomic.Int32 // 出站连接的 SO_MARK（仅 Linux），0 表示不设置
+	originalIface    string       // 原默认路由的出口网卡，由路由管理器记录
 )
 
 // GetOriginalInterfaceDialer 获取绑定到原默认接口的 Dialer
 // 所有远程连接（Direct/WSS/TLS）都应该使用这个 Dialer，确保不走 TUN
+// 返回副本，连接超时取当前的 timeouts.dial，keepalive 取 tcp.keep_alive；
+// 配置了 out.bind_interface 时按网卡名绑定，代替源地址绑定；macOS/Windows 上路由管理器记录了原出口网卡时同样按网卡绑定，
+// 多条默认路由时不会因源地址选路落到其他网卡
 func GetOriginalInterfaceDialer() *net.Dialer {
 	globalDialerOnce.Do(func() {
 		// 默认 Dialer，不绑定接口（如果还没初始化 RouteManager）
-		globalDialer = &net.Dialer{
-			Timeout: 10 * time.Second,
-		}
+		globalDialer = &net.Dialer{}
 	})
 
 	globalDialerMu.RLock()
-	defer globalDialerMu.RUnlock()
-	return globalDialer
+	d := *globalDialer
+	uplink := originalIface
+	globalDialerMu.RUnlock()
+	d.Timeout = config.DialTimeout()
+	if d.KeepAlive = config.TCPKeepAlive(); d.KeepAlive == 0 {
+		d.KeepAlive = -1 // 0 在 net.Dialer 中表示默认值，负数才是关闭
+	}
+	iface := config.Config.Out.BindInterface
+	if iface == "" && bindLocalAddr {
+		iface = uplink
+	}
+	if iface != "" {
+		d.LocalAddr = nil // 已按网卡名绑定，不再指定源地址
+	}
+	if mark := int(socketMark.Load()); mark != 0 || iface != "" {
+		d.Control = socketControl(mark, iface)
+	}
+	return &d
+}
+
+// socketControl 连接建立前设置 SO_MARK 并绑定到指定网卡（out.bind_interface），不依赖路由表
+func socketControl(mark int, iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var serr error
+		if err := c.Control(func(fd uintptr) {
+			if mark != 0 {
+				serr = setMark(fd, mark)
+			}
+			if serr == nil && iface != "" {
+				serr = bindToInterface(fd, network, iface)
+			}
+		}); err != nil {
+			return err
+		}
+		return serr
+	}
+}
+
+// SetSocketMark 设置之后出站连接的 SO_MARK，由路由管理器在安装 ip rule 后调用，0 表示取消
+func SetSocketMark(mark int) {
+	socketMark.Store(int32(mark))
+}
+
+// SetOriginalInterface 记录原默认路由的出口网卡及其 IP 地址（可为 nil），TUN 模式下由路由管理器在接管默认路由前调用
+func SetOriginalInterface(ctx context2.Context, name string, ip net.IP) {
+	globalDialerMu.Lock()
+	originalIface = name
+	globalDialerMu.Unlock()
+	logger.Info(ctx, map[string]interface{}{
+		"action":    "Runtime",
+		"interface": name,
+		"ip":        ip,
+	}, "set original interface for remote connections")
+	SetOriginalInterfaceIP(ctx, ip)
 }
 
 // SetOriginalInterfaceIP 设置原默认接口的 IP 地址
 // 调用后，所有通过 GetOriginalInterfaceDialer() 获取的 Dialer 都会绑定到这个 IP
-func SetOriginalInterfaceIP(ctx *context.Context, ip net.IP) {
-	if ip == nil {
+// Linux 改用 SO_MARK 配合 ip rule 绕过 TUN，不绑定源地址，避免 DHCP 更换地址后连接失败
+func SetOriginalInterfaceIP(ctx context2.Context, ip net.IP) {
+	if ip == nil || !bindLocalAddr {
 		return
 	}
 
@@ -48,11 +106,10 @@ func SetOriginalInterfaceIP(ctx *context.Context, ip net.IP) {
 			IP:   ip,
 			Port: 0, // 系统自动分配端口
 		},
-		Timeout: 10 * time.Second,
 	}
 
 	// 注意：绑定接口主要通过 LocalAddr 实现
-	// Windows/Linux 都通过 LocalAddr 指定源 IP，配合路由表实现接口绑定
+	// Windows/macOS 通过 LocalAddr 指定源 IP，配合路由表实现接口绑定
 	_ = runtime.GOOS // 标记使用
 
 	logger.Info(ctx, map[string]interface{}{
@@ -61,5 +118,3 @@ func SetOriginalInterfaceIP(ctx *context.Context, ip net.IP) {
 		"os":     runtime.GOOS,
 	}, "set original interface IP for remote connections")
 }
-
-
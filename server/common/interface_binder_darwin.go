@@ -0,0 +1,32 @@
+package common
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+)
+
+const bindLocalAddr = true
+
+// IP_BOUND_IF / IPV6_BOUND_IF，syscall 包未导出
+const (
+	ipBoundIf   = 25
+	ipv6BoundIf = 125
+)
+
+func setMark(fd uintptr, mark int) error {
+	return errors.New("SO_MARK is not supported on darwin")
+}
+
+// bindToInterface IP_BOUND_IF / IPV6_BOUND_IF，按网卡序号绑定
+func bindToInterface(fd uintptr, network, iface string) error {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(network, "6") {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, ipv6BoundIf, ifi.Index)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, ipBoundIf, ifi.Index)
+}
@@ -0,0 +1,16 @@
+package common
+
+import "syscall"
+
+// bindLocalAddr Linux 通过 SO_MARK 排除自身流量，不绑定源地址
+const bindLocalAddr = false
+
+// setMark 设置 SO_MARK，需要 CAP_NET_ADMIN
+func setMark(fd uintptr, mark int) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, mark)
+}
+
+// bindToInterface SO_BINDTODEVICE，需要 CAP_NET_RAW
+func bindToInterface(fd uintptr, network, iface string) error {
+	return syscall.BindToDevice(int(fd), iface)
+}
@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !windows
+
+package common
+
+import (
+	"errors"
+	"runtime"
+)
+
+const bindLocalAddr = true
+
+func setMark(fd uintptr, mark int) error {
+	return errors.New("SO_MARK is not supported on " + runtime.GOOS)
+}
+
+func bindToInterface(fd uintptr, network, iface string) error {
+	return errors.New("out.bind_interface is not supported on " + runtime.GOOS)
+}
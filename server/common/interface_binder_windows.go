@@ -0,0 +1,35 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+)
+
+const bindLocalAddr = true
+
+// IP_UNICAST_IF / IPV6_UNICAST_IF，syscall 包未导出
+const (
+	ipUnicastIf   = 31
+	ipv6UnicastIf = 31
+)
+
+func setMark(fd uintptr, mark int) error {
+	return errors.New("SO_MARK is not supported on windows")
+}
+
+// bindToInterface IP_UNICAST_IF / IPV6_UNICAST_IF；IPv4 的网卡序号需按网络字节序传入
+func bindToInterface(fd uintptr, network, iface string) error {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(network, "6") {
+		return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IPV6, ipv6UnicastIf, ifi.Index)
+	}
+	var be [4]byte
+	binary.BigEndian.PutUint32(be[:], uint32(ifi.Index))
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, ipUnicastIf, int(binary.NativeEndian.Uint32(be[:])))
+}
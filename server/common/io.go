@@ -5,6 +5,8 @@ import (
 	"errors"
 	"io"
 	"net"
+
+	"proxy/config"
 )
 
 const (
@@ -46,6 +48,10 @@ func (c *SniffConn) Read(p []byte) (int, error) {
 	return c.rout.Read(p)
 }
 
+func (c *SniffConn) CloseWrite() error {
+	return HalfClose(c.Conn)
+}
+
 func (c *SniffConn) Sniff() int {
 	var err error
 	c.peeks, err = c.peek(64)
@@ -87,3 +93,19 @@ func (c *SniffConn) sniffHttp() bool {
 	}
 	return false
 }
+
+// Copy 同 io.Copy，但缓冲区取自 bufPools，避免每条连接分配新的缓冲区；
+// src 的 WriterTo 被屏蔽，否则 *net.TCPConn 等会绕过传入的缓冲区自行分配。
+// dst 为 SpliceWriter 且 src 为 *net.TCPConn 时零拷贝转发，见 SpliceWriter
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	if sw, ok := dst.(*SpliceWriter); ok {
+		if tc, ok := src.(*net.TCPConn); ok {
+			if n, handled, err := splice(sw.Conn, tc, sw.OnData); handled {
+				return n, err
+			}
+		}
+	}
+	buf := GetBuffer(config.RelayBufferSize())
+	defer PutBuffer(buf)
+	return io.CopyBuffer(dst, struct{ io.Reader }{src}, buf)
+}
@@ -0,0 +1,17 @@
+package common
+
+import (
+	"proxy/config"
+	"proxy/server/kcp"
+)
+
+// KCPOptions KCP 入口与出口共用的会话参数，取自 kcp 配置
+func KCPOptions() kcp.Options {
+	return kcp.Options{
+		MTU:      config.Config.KCP.MTU,
+		SndWnd:   config.Config.KCP.SndWnd,
+		RcvWnd:   config.Config.KCP.RcvWnd,
+		FEC:      config.Config.KCP.FEC,
+		Interval: config.KCPInterval(),
+	}
+}
@@ -0,0 +1,160 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+
+	"proxy/config"
+)
+
+// MaxPacketSize 单个 UDP 数据报的最大长度
+const MaxPacketSize = 65535
+
+// PacketConn 按数据报收发的 UDP 通道，每个数据报带有目标（写）或来源（读）地址，地址格式为 host:port
+type PacketConn interface {
+	ReadPacket(p []byte) (n int, addr string, err error)
+	WritePacket(p []byte, addr string) error
+	Close() error
+}
+
+// PacketStream 在加密流上按帧承载 UDP 数据报：2 字节帧长度、1 字节地址长度、地址（host:port）、数据
+type PacketStream struct {
+	rw   io.ReadWriter
+	rbuf []byte
+	wmu  sync.Mutex
+	wbuf []byte
+}
+
+func NewPacketStream(rw io.ReadWriter) *PacketStream {
+	return &PacketStream{rw: rw}
+}
+
+func (s *PacketStream) ReadPacket(p []byte) (int, string, error) {
+	var head [3]byte
+	if _, err := io.ReadFull(s.rw, head[:]); err != nil {
+		return 0, "", err
+	}
+	size := int(binary.BigEndian.Uint16(head[:2]))
+	addrLen := int(head[2])
+	if size < 1+addrLen {
+		return 0, "", Wrap(ErrProtocol, errors.New("packet: malformed frame"))
+	}
+	if cap(s.rbuf) < size-1 {
+		s.rbuf = make([]byte, size-1)
+	}
+	body := s.rbuf[:size-1]
+	if _, err := io.ReadFull(s.rw, body); err != nil {
+		return 0, "", err
+	}
+	// 超出 p 的部分丢弃，与 UDP 套接字的行为一致
+	return copy(p, body[addrLen:]), string(body[:addrLen]), nil
+}
+
+// WritePacket 整帧一次写出，多个出口并发写入同一条流时帧不会交错
+func (s *PacketStream) WritePacket(p []byte, addr string) error {
+	if len(addr) > 255 {
+		return errors.New("packet: address too long")
+	}
+	size := 1 + len(addr) + len(p)
+	if size > MaxPacketSize {
+		return errors.New("packet: datagram too large")
+	}
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if cap(s.wbuf) < 2+size {
+		s.wbuf = make([]byte, 2+size)
+	}
+	buf := s.wbuf[:2+size]
+	binary.BigEndian.PutUint16(buf, uint16(size))
+	buf[2] = byte(len(addr))
+	copy(buf[3:], addr)
+	copy(buf[3+len(addr):], p)
+	_, err := s.rw.Write(buf)
+	return err
+}
+
+func (s *PacketStream) Close() error {
+	if closer, ok := s.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// maxResolvedAddrs UDPPacketConn 缓存的域名解析结果数量上限
+const maxResolvedAddrs = 256
+
+// UDPPacketConn 直连出口的 UDP 通道：未连接的套接字，可发往任意地址，也接收任意来源的回包
+type UDPPacketConn struct {
+	*net.UDPConn
+	mu    sync.Mutex
+	addrs map[string]*net.UDPAddr
+	held  atomic.Bool
+}
+
+func NewUDPPacketConn(conn *net.UDPConn) *UDPPacketConn {
+	return &UDPPacketConn{UDPConn: conn, addrs: make(map[string]*net.UDPAddr)}
+}
+
+// Hold 之后 Close 不再关闭套接字，改由持有者调用 Release 关闭，用于会话结束后仍要保留的 UDP 映射
+func (c *UDPPacketConn) Hold() {
+	c.held.Store(true)
+}
+
+func (c *UDPPacketConn) Release() error {
+	return c.UDPConn.Close()
+}
+
+func (c *UDPPacketConn) Close() error {
+	if c.held.Load() {
+		return nil
+	}
+	return c.UDPConn.Close()
+}
+
+func (c *UDPPacketConn) ReadPacket(p []byte) (int, string, error) {
+	n, from, err := c.ReadFromUDPAddrPort(p)
+	if err != nil {
+		return n, "", err
+	}
+	// 双栈套接字收到的 IPv4 来源是映射地址，还原为 IPv4
+	return n, netip.AddrPortFrom(from.Addr().Unmap(), from.Port()).String(), nil
+}
+
+func (c *UDPPacketConn) WritePacket(p []byte, addr string) error {
+	udpAddr, err := c.resolve(addr)
+	if err != nil {
+		return err
+	}
+	_, err = c.WriteToUDP(p, udpAddr)
+	return err
+}
+
+// resolve 解析目标地址，域名按地址族偏好解析并缓存，避免每个数据报都查询一次
+func (c *UDPPacketConn) resolve(addr string) (*net.UDPAddr, error) {
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		return net.UDPAddrFromAddrPort(ap), nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if udpAddr, ok := c.addrs[addr]; ok {
+		return udpAddr, nil
+	}
+	network := "udp"
+	if config.IPv4Only() {
+		network = "udp4"
+	}
+	udpAddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.addrs) >= maxResolvedAddrs {
+		clear(c.addrs)
+	}
+	c.addrs[addr] = udpAddr
+	return udpAddr, nil
+}
@@ -0,0 +1,229 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"proxy/config"
+	"proxy/utils/context"
+	"proxy/utils/logger"
+)
+
+// proxyV2Signature PROXY protocol v2 头的固定前 12 字节
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyV1MaxLen PROXY protocol v1 头的最大长度（含 \r\n）
+const proxyV1MaxLen = 107
+
+// NewProxyProtoListener 包装 l：每个连接先读取 HAProxy PROXY protocol（v1 或 v2）头，RemoteAddr 返回头中的客户端地址；
+// 读取头受 timeouts.handshake 限制且不阻塞其他连接，没有合法头的连接直接关闭；
+// 只接受 in.trusted_proxies（为空时为本机回环地址）发来的头，其他对端的连接直接关闭，客户端无法伪造地址
+func NewProxyProtoListener(l net.Listener) net.Listener {
+	pl := &proxyProtoListener{
+		Listener: l,
+		results:  make(chan acceptResult),
+		done:     make(chan struct{}),
+	}
+	go pl.serve()
+	return pl
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+type proxyProtoListener struct {
+	net.Listener
+	results chan acceptResult
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (l *proxyProtoListener) serve() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.results <- acceptResult{err: err}:
+			case <-l.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go l.handshake(conn)
+	}
+}
+
+func (l *proxyProtoListener) handshake(conn net.Conn) {
+	if peer := addrIP(conn.RemoteAddr()); !proxyTrusted(peer) {
+		logger.ErrorAggregated(context.NewContext(), "proxy_protocol:untrusted:"+peer.String(), map[string]interface{}{
+			"action":    config.ActionSocketOperate,
+			"errorCode": logger.ErrCodeAccept,
+			"source":    conn.RemoteAddr().String(),
+			"reason":    "not in in.trusted_proxies",
+		})
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(config.HandshakeTimeout()))
+	br := bufio.NewReader(conn)
+	remote, err := readProxyHeader(br)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	pc := &proxyProtoConn{Conn: conn, br: br, remote: remote}
+	select {
+	case l.results <- acceptResult{conn: pc}:
+	case <-l.done:
+		_ = conn.Close()
+	}
+}
+
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	select {
+	case r := <-l.results:
+		return r.conn, r.err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *proxyProtoListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	return l.Listener.Close()
+}
+
+// proxyTrusted peer 是否可以发送 PROXY protocol 头：in.trusted_proxies 中的地址，未配置时只信任本机回环地址
+func proxyTrusted(peer netip.Addr) bool {
+	list := config.Config.In.TrustedProxy
+	if len(list) == 0 {
+		return peer.IsLoopback()
+	}
+	return inList(peer, list)
+}
+
+// proxyProtoConn 已读取 PROXY protocol 头的连接，头之后已缓冲的数据先于连接上的数据读出
+type proxyProtoConn struct {
+	net.Conn
+	br     *bufio.Reader
+	remote net.Addr // 头中的客户端地址，LOCAL 命令或 UNKNOWN 协议时为 nil
+}
+
+func (c *proxyProtoConn) Read(p []byte) (int, error) {
+	return c.br.Read(p)
+}
+
+func (c *proxyProtoConn) CloseWrite() error {
+	return HalfClose(c.Conn)
+}
+
+// Unwrap 头之后随同读入的数据读完后才能绕过
+func (c *proxyProtoConn) Unwrap() net.Conn {
+	if c.br.Buffered() > 0 {
+		return nil
+	}
+	return c.Conn
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader 读取 v1 或 v2 头，返回其中的客户端地址
+func readProxyHeader(br *bufio.Reader) (net.Addr, error) {
+	sig, err := br.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(sig, proxyV2Signature) {
+		return readProxyV2(br)
+	}
+	if string(sig[:6]) == "PROXY " {
+		return readProxyV1(br)
+	}
+	return nil, Wrap(ErrProtocol, errors.New("proxy protocol: missing header"))
+}
+
+// readProxyV1 文本格式：PROXY TCP4|TCP6|UNKNOWN 源地址 目的地址 源端口 目的端口\r\n
+func readProxyV1(br *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLen {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, Wrap(ErrProtocol, errors.New("proxy protocol: malformed v1 header"))
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, Wrap(ErrProtocol, errors.New("proxy protocol: malformed v1 header"))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, Wrap(ErrProtocol, errors.New("proxy protocol: malformed v1 address"))
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyV2 二进制格式：签名、版本与命令、地址族与协议、2 字节长度、地址（及 TLV，忽略）
+func readProxyV2(br *bufio.Reader) (net.Addr, error) {
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return nil, err
+	}
+	if head[12]>>4 != 2 {
+		return nil, Wrap(ErrProtocol, errors.New("proxy protocol: unsupported v2 version"))
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[14:]))
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, err
+	}
+	// LOCAL 命令为负载均衡器自身的健康检查，使用连接的真实地址
+	if head[12]&0x0f == 0 {
+		return nil, nil
+	}
+	switch head[13] >> 4 {
+	case 1: // AF_INET
+		if len(body) < 12 {
+			return nil, Wrap(ErrProtocol, errors.New("proxy protocol: short v2 address"))
+		}
+		return &net.TCPAddr{IP: net.IP(body[:4]), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 2: // AF_INET6
+		if len(body) < 36 {
+			return nil, Wrap(ErrProtocol, errors.New("proxy protocol: short v2 address"))
+		}
+		return &net.TCPAddr{IP: net.IP(body[:16]), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,88 @@
+package common
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"proxy/config"
+)
+
+// QuicALPN QUIC 入口与出口协商的应用层协议名
+const QuicALPN = "celestial-ladder"
+
+// QuicConfig QUIC 入口与出口共用的传输参数：允许 0-RTT，定期发送 keepalive 保持 NAT 映射
+func QuicConfig() *quic.Config {
+	return &quic.Config{
+		HandshakeIdleTimeout: config.HandshakeTimeout(),
+		MaxIdleTimeout:       30 * time.Second,
+		KeepAlivePeriod:      15 * time.Second,
+		MaxIncomingStreams:   1024,
+		Allow0RTT:            true,
+	}
+}
+
+// QuicConn 把 QUIC 双向流适配为 net.Conn，一个流承载一次代理请求
+type QuicConn struct {
+	*quic.Stream
+	conn    *quic.Conn
+	once    sync.Once
+	onClose func()
+}
+
+// NewQuicConn 包装 conn 上的流 stream，Close 时调用一次 onClose（可为 nil）
+func NewQuicConn(stream *quic.Stream, conn *quic.Conn, onClose func()) *QuicConn {
+	return &QuicConn{Stream: stream, conn: conn, onClose: onClose}
+}
+
+// Read 对端正常关闭流（错误码 0）时返回 io.EOF
+func (c *QuicConn) Read(p []byte) (int, error) {
+	n, err := c.Stream.Read(p)
+	if isStreamCanceled(err) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Write 流已被任一端关闭时返回 net.ErrClosed，与 TCP 连接关闭的处理一致
+func (c *QuicConn) Write(p []byte) (int, error) {
+	n, err := c.Stream.Write(p)
+	if isStreamCanceled(err) {
+		err = net.ErrClosed
+	}
+	return n, err
+}
+
+// Close 同时关闭读写两个方向；只关闭写方向的流在对端不读时会一直占用流配额
+func (c *QuicConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		c.Stream.CancelRead(0)
+		err = c.Stream.Close()
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return err
+}
+
+// CloseWrite 关闭流的发送方向，对端读到 io.EOF，接收方向不受影响
+func (c *QuicConn) CloseWrite() error {
+	return c.Stream.Close()
+}
+
+func (c *QuicConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *QuicConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func isStreamCanceled(err error) bool {
+	var serr *quic.StreamError
+	return errors.As(err, &serr) && serr.ErrorCode == 0
+}
@@ -0,0 +1,49 @@
+package common
+
+import (
+	"net"
+)
+
+// Unwrapper 只转发读写、不改变数据的连接包装，Unwrap 返回内层连接；
+// 本层还有尚未读出的缓冲数据、不能绕过时返回 nil
+type Unwrapper interface {
+	Unwrap() net.Conn
+}
+
+// RawTCP 逐层 Unwrap，返回最内层的 *net.TCPConn；某层不能绕过或最内层不是 TCP 连接时返回 nil
+func RawTCP(v interface{}) *net.TCPConn {
+	for {
+		switch c := v.(type) {
+		case *net.TCPConn:
+			return c
+		case Unwrapper:
+			inner := c.Unwrap()
+			if inner == nil {
+				return nil
+			}
+			v = inner
+		default:
+			return nil
+		}
+	}
+}
+
+// SpliceWriter 写入 TCP 连接 Conn，每写入一段数据后调用 OnData（计数、空闲计时）。
+// Copy 的来源同为 *net.TCPConn 时在 Linux 上改用 splice(2) 在内核中搬运，数据不经用户态缓冲区；
+// 其他系统或无法 splice 时按普通写入处理
+type SpliceWriter struct {
+	Conn   *net.TCPConn
+	OnData func(n int64)
+}
+
+func (w *SpliceWriter) Write(p []byte) (int, error) {
+	n, err := w.Conn.Write(p)
+	if n > 0 {
+		w.OnData(int64(n))
+	}
+	return n, err
+}
+
+func (w *SpliceWriter) CloseWrite() error {
+	return w.Conn.CloseWrite()
+}
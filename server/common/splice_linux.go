@@ -0,0 +1,74 @@
+package common
+
+import (
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// spliceChunk 每次从连接搬入管道的最大字节数，与管道默认容量相同
+const spliceChunk = 64 << 10
+
+// splice 经管道把 src 的数据在内核中搬到 dst，直到 src 读完（EOF）或出错；每搬出一段调用 onData。
+// 无法创建管道时 handled 为 false，由调用方按普通复制处理
+func splice(dst, src *net.TCPConn, onData func(n int64)) (written int64, handled bool, err error) {
+	rc, err := src.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+	wc, err := dst.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+	var pipe [2]int
+	if err = unix.Pipe2(pipe[:], unix.O_CLOEXEC|unix.O_NONBLOCK); err != nil {
+		return 0, false, nil
+	}
+	defer unix.Close(pipe[0])
+	defer unix.Close(pipe[1])
+	const flags = unix.SPLICE_F_MOVE | unix.SPLICE_F_NONBLOCK
+	for {
+		var n int64
+		var serr error
+		// 连接暂无数据（EAGAIN）时由 netpoller 等待可读，读超时同样生效
+		err = rc.Read(func(fd uintptr) bool {
+			for {
+				n, serr = unix.Splice(int(fd), nil, pipe[1], nil, spliceChunk, flags)
+				if serr != unix.EINTR {
+					return serr != unix.EAGAIN
+				}
+			}
+		})
+		if err == nil && serr != nil {
+			err = os.NewSyscallError("splice", serr)
+		}
+		if err != nil {
+			return written, true, err
+		}
+		if n == 0 {
+			return written, true, nil
+		}
+		// 每次把管道中的数据全部写出，下一次读入前管道为空
+		for n > 0 {
+			var m int64
+			err = wc.Write(func(fd uintptr) bool {
+				for {
+					m, serr = unix.Splice(pipe[0], nil, int(fd), nil, int(n), flags)
+					if serr != unix.EINTR {
+						return serr != unix.EAGAIN
+					}
+				}
+			})
+			if err == nil && serr != nil {
+				err = os.NewSyscallError("splice", serr)
+			}
+			if err != nil {
+				return written, true, err
+			}
+			n -= m
+			written += m
+			onData(m)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package common
+
+import "net"
+
+func splice(dst, src *net.TCPConn, onData func(n int64)) (int64, bool, error) {
+	return 0, false, nil
+}
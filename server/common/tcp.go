@@ -0,0 +1,84 @@
+package common
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"syscall"
+
+	"proxy/config"
+)
+
+// TuneTCP 按 tcp 配置设置 keepalive 与 TCP_NODELAY，入口接受的连接与出口连接都需调用；非 TCP 连接不处理
+func TuneTCP(conn net.Conn) {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	_ = tc.SetNoDelay(config.TCPNoDelay())
+	if d := config.TCPKeepAlive(); d > 0 {
+		_ = tc.SetKeepAlive(true)
+		_ = tc.SetKeepAlivePeriod(d)
+	} else {
+		_ = tc.SetKeepAlive(false)
+	}
+}
+
+// TuneListener 包装 l，Accept 得到的连接先经 TuneTCP 设置
+func TuneListener(l net.Listener) net.Listener {
+	return &tunedListener{Listener: l}
+}
+
+type tunedListener struct {
+	net.Listener
+}
+
+func (l *tunedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		TuneTCP(conn)
+	}
+	return conn, err
+}
+
+// FastOpenDialer tcp.fast_open 开启时返回设置了 TCP_FASTOPEN_CONNECT 的 d 的副本，握手数据随 SYN 发出，
+// 与远端再次建立连接时省去一个往返；仅 Linux 支持，其他系统与未开启时原样返回 d。
+// 连接建立后由服务端先发送数据的协议不能使用，只用于 TLS 等由客户端先发送的出口
+func FastOpenDialer(d *net.Dialer) *net.Dialer {
+	if !config.Config.TCP.FastOpen || runtime.GOOS != "linux" {
+		return d
+	}
+	fd := *d
+	control := d.Control
+	fd.Control = func(network, address string, c syscall.RawConn) error {
+		if control != nil {
+			if err := control(network, address, c); err != nil {
+				return err
+			}
+		}
+		// 内核不支持时按普通连接建立
+		return c.Control(func(s uintptr) {
+			_ = setFastOpenConnect(s)
+		})
+	}
+	return &fd
+}
+
+// EnableFastOpen 在监听 l 上开启 TCP_FASTOPEN，接受客户端随 SYN 发送的数据；l 不是 TCP 监听或系统不支持时返回错误
+func EnableFastOpen(l net.Listener) error {
+	sc, ok := l.(syscall.Conn)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err = raw.Control(func(fd uintptr) {
+		serr = setFastOpenListen(fd)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,95 @@
+package common
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsPingInterval WebSocket 帧模式下发送 ping 的间隔，需小于 CDN 的空闲超时（Cloudflare 为 100 秒）
+const wsPingInterval = 30 * time.Second
+
+// WSConn 把 WebSocket 连接适配为 net.Conn：数据以二进制消息收发，经 CDN 转发时只能使用完整的帧；
+// 定期发送 ping 防止空闲的隧道被 CDN 断开
+type WSConn struct {
+	*websocket.Conn
+	r    io.Reader // 当前正在读取的消息，起初为早期数据
+	wmu  sync.Mutex
+	once sync.Once
+	done chan struct{}
+}
+
+// NewWSConn 包装 c，early 为握手时随请求带来的早期数据，会先于后续消息读出
+func NewWSConn(c *websocket.Conn, early []byte) *WSConn {
+	w := &WSConn{Conn: c, done: make(chan struct{})}
+	if len(early) > 0 {
+		w.r = bytes.NewReader(early)
+	}
+	go w.keepalive()
+	return w
+}
+
+func (w *WSConn) keepalive() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			if err := w.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsPingInterval)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (w *WSConn) Read(p []byte) (int, error) {
+	for {
+		if w.r != nil {
+			n, err := w.r.Read(p)
+			if err != io.EOF {
+				return n, err
+			}
+			// 当前消息读完，继续读下一条
+			w.r = nil
+			if n > 0 {
+				return n, nil
+			}
+		}
+		_, r, err := w.NextReader()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+		w.r = r
+	}
+}
+
+func (w *WSConn) Write(p []byte) (int, error) {
+	w.wmu.Lock()
+	defer w.wmu.Unlock()
+	if err := w.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *WSConn) SetDeadline(t time.Time) error {
+	if err := w.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return w.SetWriteDeadline(t)
+}
+
+func (w *WSConn) Close() error {
+	w.once.Do(func() {
+		close(w.done)
+	})
+	return w.Conn.Close()
+}
@@ -0,0 +1,257 @@
+// Package conntrack 记录当前正在转发的连接，供管理接口查询
+package conntrack
+
+import (
+	context2 "context"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/hooks"
+	"proxy/server/metrics"
+)
+
+// Conn 一条正在转发的连接
+type Conn struct {
+	ID      uint64
+	Inbound string // 入口名称，如 SocketServer
+	Source  string // 客户端地址
+	Target  string
+	Remote  string // 出口名称
+	Start   time.Time
+	Up      metrics.Counter // 客户端发往远端的字节数
+	Down    metrics.Counter // 远端发回客户端的字节数
+
+	kill     func() // 关闭两端连接，使转发结束
+	killOnce sync.Once
+}
+
+// Snapshot 连接的只读快照
+type Snapshot struct {
+	ID       uint64  `json:"id"`
+	Inbound  string  `json:"inbound"`
+	Source   string  `json:"source"`
+	Target   string  `json:"target"`
+	Remote   string  `json:"remote"`
+	Start    string  `json:"start"`
+	Duration float64 `json:"duration_s"`
+	Up       int64   `json:"up_bytes"`
+	Down     int64   `json:"down_bytes"`
+}
+
+// 按域名统计保留的最大条目数
+const maxDomains = 1024
+
+// DomainStat 按目标域名（或 IP）汇总的流量
+type DomainStat struct {
+	Host        string `json:"host"`
+	Connections int64  `json:"connections"`
+	Up          int64  `json:"up_bytes"`
+	Down        int64  `json:"down_bytes"`
+}
+
+var (
+	nextID atomic.Uint64
+	conns  sync.Map // id -> *Conn
+
+	// 已结束连接的累计流量
+	finished = struct {
+		mu       sync.Mutex
+		up, down int64
+		domains  map[string]*DomainStat
+	}{domains: make(map[string]*DomainStat)}
+)
+
+// Add 登记一条连接并通知连接建立，连接结束时需调用 Remove
+// kill 用于从管理接口终止连接，应关闭客户端与远端两侧
+func Add(inbound, source string, target *common.TargetAddr, remote string, kill func()) *Conn {
+	c := &Conn{
+		ID:      nextID.Add(1),
+		Inbound: inbound,
+		Source:  source,
+		Target:  target.String(),
+		Remote:  remote,
+		Start:   time.Now(),
+		kill:    kill,
+	}
+	conns.Store(c.ID, c)
+	hooks.Connect(c.event())
+	return c
+}
+
+// Remove 注销连接，通知连接结束并将其流量计入累计统计
+func Remove(c *Conn) {
+	conns.Delete(c.ID)
+	up, down := c.Up.Value(), c.Down.Value()
+	e := c.event()
+	e.Up, e.Down, e.Duration = up, down, time.Since(c.Start)
+	hooks.Close(e)
+	finished.mu.Lock()
+	defer finished.mu.Unlock()
+	finished.up += up
+	finished.down += down
+	host := c.Host()
+	stat, ok := finished.domains[host]
+	if !ok {
+		if len(finished.domains) >= maxDomains {
+			evictSmallestDomain()
+		}
+		stat = &DomainStat{Host: host}
+		finished.domains[host] = stat
+	}
+	stat.Connections++
+	stat.Up += up
+	stat.Down += down
+}
+
+// event 连接的生命周期事件
+func (c *Conn) event() hooks.ConnEvent {
+	return hooks.ConnEvent{
+		ID:      c.ID,
+		Inbound: c.Inbound,
+		Source:  c.Source,
+		Target:  c.Target,
+		Remote:  c.Remote,
+		Start:   c.Start,
+	}
+}
+
+// evictSmallestDomain 淘汰流量最小的域名统计，调用方需持有 finished.mu
+func evictSmallestDomain() {
+	var victim string
+	var smallest int64 = -1
+	for host, stat := range finished.domains {
+		if total := stat.Up + stat.Down; smallest < 0 || total < smallest {
+			victim, smallest = host, total
+		}
+	}
+	delete(finished.domains, victim)
+}
+
+// Kill 终止指定连接，连接不存在时返回 false
+func Kill(id uint64) bool {
+	v, ok := conns.Load(id)
+	if !ok {
+		return false
+	}
+	c := v.(*Conn)
+	c.killOnce.Do(c.kill)
+	return true
+}
+
+// Host 目标地址去掉端口后的部分
+func (c *Conn) Host() string {
+	if host, _, err := net.SplitHostPort(c.Target); err == nil {
+		return host
+	}
+	return c.Target
+}
+
+// Snapshot 生成连接快照
+func (c *Conn) Snapshot() Snapshot {
+	return Snapshot{
+		ID:       c.ID,
+		Inbound:  c.Inbound,
+		Source:   c.Source,
+		Target:   c.Target,
+		Remote:   c.Remote,
+		Start:    c.Start.In(config.CstZone).Format(config.TimeFormat),
+		Duration: time.Since(c.Start).Truncate(time.Millisecond).Seconds(),
+		Up:       c.Up.Value(),
+		Down:     c.Down.Value(),
+	}
+}
+
+// List 返回全部连接的快照，按 ID 升序
+func List() []Snapshot {
+	list := make([]Snapshot, 0)
+	conns.Range(func(_, v interface{}) bool {
+		list = append(list, v.(*Conn).Snapshot())
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Count 当前连接数
+func Count() int {
+	n := 0
+	conns.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// Drain 等待在途连接全部结束，ctx 到期后终止剩余的连接，返回被终止的连接数
+func Drain(ctx context2.Context) int {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for Count() > 0 {
+		select {
+		case <-ctx.Done():
+			killed := 0
+			conns.Range(func(_, v interface{}) bool {
+				c := v.(*Conn)
+				c.killOnce.Do(c.kill)
+				killed++
+				return true
+			})
+			return killed
+		case <-ticker.C:
+		}
+	}
+	return 0
+}
+
+// Traffic 累计转发的字节数（含正在转发的连接）
+func Traffic() (up, down int64) {
+	finished.mu.Lock()
+	up, down = finished.up, finished.down
+	finished.mu.Unlock()
+	conns.Range(func(_, v interface{}) bool {
+		c := v.(*Conn)
+		up += c.Up.Value()
+		down += c.Down.Value()
+		return true
+	})
+	return up, down
+}
+
+// Domains 按域名汇总的流量（含正在转发的连接），按总流量降序
+func Domains() []DomainStat {
+	stats := make(map[string]*DomainStat)
+	finished.mu.Lock()
+	for host, stat := range finished.domains {
+		copied := *stat
+		stats[host] = &copied
+	}
+	finished.mu.Unlock()
+	conns.Range(func(_, v interface{}) bool {
+		c := v.(*Conn)
+		host := c.Host()
+		stat, ok := stats[host]
+		if !ok {
+			stat = &DomainStat{Host: host}
+			stats[host] = stat
+		}
+		stat.Connections++
+		stat.Up += c.Up.Value()
+		stat.Down += c.Down.Value()
+		return true
+	})
+	list := make([]DomainStat, 0, len(stats))
+	for _, stat := range stats {
+		list = append(list, *stat)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Up+list[i].Down > list[j].Up+list[j].Down
+	})
+	return list
+}
@@ -0,0 +1,92 @@
+// Package crash 处理未被捕获的 panic：写入崩溃报告（堆栈、脱敏后的配置摘要、最近的日志），
+// 执行注册的恢复操作（删除 TUN 路由、恢复系统代理）后退出，避免进程崩溃后系统网络设置处于被修改的状态
+package crash
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"proxy/config"
+	"proxy/utils/context"
+	"proxy/utils/logger"
+)
+
+// restoreTimeout 恢复操作的最长耗时，超时后直接退出，遗留的设置由下次启动清理
+const restoreTimeout = 10 * time.Second
+
+var (
+	mu      sync.Mutex
+	restore func()
+	once    sync.Once
+)
+
+// SetRestore 注册崩溃退出前执行的恢复操作
+func SetRestore(fn func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	restore = fn
+}
+
+// Handle 捕获当前协程的 panic，写入崩溃报告并执行恢复操作后退出进程，需直接 defer 调用
+func Handle() {
+	if r := recover(); r != nil {
+		fatal(r)
+	}
+}
+
+// Go 在新协程中执行 fn，fn panic 时按 Handle 处理
+func Go(fn func()) {
+	go func() {
+		defer Handle()
+		fn()
+	}()
+}
+
+// fatal 只处理第一个 panic，同时 panic 的其他协程等待进程退出
+func fatal(r interface{}) {
+	first := false
+	once.Do(func() { first = true })
+	if !first {
+		select {}
+	}
+
+	ctx := context.NewContext()
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	path, err := WriteReport(fmt.Sprint(r), buf)
+	logger.Error(ctx, map[string]interface{}{
+		"action":    config.ActionRuntime,
+		"errorCode": logger.ErrCodeDefault,
+		"error":     fmt.Sprint(r),
+		"report":    path,
+		"reportErr": err,
+	}, "unrecovered panic, restoring system settings before exit")
+	// 报告写入失败时至少把堆栈留在标准错误
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "panic: %v\n\n%s\n", r, buf)
+	}
+
+	mu.Lock()
+	fn := restore
+	mu.Unlock()
+	if fn != nil {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer func() { _ = recover() }()
+			fn()
+		}()
+		select {
+		case <-done:
+		case <-time.After(restoreTimeout):
+			logger.Error(ctx, map[string]interface{}{
+				"action":    config.ActionRuntime,
+				"errorCode": logger.ErrCodeDefault,
+			}, "restore timed out, stale settings will be cleaned up on next start")
+		}
+	}
+	os.Exit(2)
+}
@@ -0,0 +1,150 @@
+package crash
+
+import (
+	"bytes"
+	context2 "context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"proxy/config"
+	"proxy/utils/logger"
+)
+
+const (
+	// pendingFile 运行时致命错误（未经 Handle 的协程 panic、并发读写 map 等）的输出，下次启动时整理为崩溃报告
+	pendingFile = "crash.pending"
+	tailBytes   = 64 << 10 // 最多读取日志文件末尾的字节数
+	tailLines   = 100
+)
+
+// 配置中视为敏感的键，值替换为 ***：用户密钥、密码、令牌、订阅与 webhook 地址、分享链接、自定义请求头等
+var secretKeys = map[string]bool{
+	"user": true, "users": true, "username": true, "password": true, "token": true, "key": true,
+	"url": true, "urls": true, "links": true, "headers": true, "ecs_subnet": true,
+}
+
+// WriteReport 在 log.path 下写入崩溃报告 crash-<时间>.log，返回文件路径
+func WriteReport(reason string, stack []byte) (string, error) {
+	now := time.Now().In(config.CstZone)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "time: %s\n", now.Format(config.TimeFormat))
+	fmt.Fprintf(&b, "pid: %d\n", os.Getpid())
+	fmt.Fprintf(&b, "go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		fmt.Fprintf(&b, "build: %s\n", info.Main.Version)
+	}
+	fmt.Fprintf(&b, "panic: %s\n", reason)
+	fmt.Fprintf(&b, "\n==== goroutines ====\n%s\n", stack)
+	fmt.Fprintf(&b, "\n==== config (secrets redacted) ====\n%s\n", configSummary())
+	fmt.Fprintf(&b, "\n==== recent log ====\n%s\n", logTail())
+
+	path := filepath.Join(config.Config.Log.Path, "crash-"+now.Format("20060102-150405")+".log")
+	if err := os.WriteFile(path, b.Bytes(), 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// configSummary 当前配置的 JSON，敏感字段已脱敏
+func configSummary() string {
+	buf, err := json.Marshal(config.Config)
+	if err != nil {
+		return err.Error()
+	}
+	var tree interface{}
+	if err := json.Unmarshal(buf, &tree); err != nil {
+		return err.Error()
+	}
+	out, _ := json.MarshalIndent(redact(tree), "", "  ")
+	return string(out)
+}
+
+func redact(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if secretKeys[k] && !empty(child) {
+				t[k] = "***"
+				continue
+			}
+			t[k] = redact(child)
+		}
+	case []interface{}:
+		for i, child := range t {
+			t[i] = redact(child)
+		}
+	}
+	return v
+}
+
+func empty(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return true
+	case string:
+		return t == ""
+	case map[string]interface{}:
+		return len(t) == 0
+	case []interface{}:
+		return len(t) == 0
+	}
+	return false
+}
+
+// logTail 日志文件（log.path/log.file_name 指向的最新文件）的最后 tailLines 行
+func logTail() string {
+	f, err := os.Open(filepath.Join(config.Config.Log.Path, config.Config.Log.FileName))
+	if err != nil {
+		return err.Error()
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.Size() > tailBytes {
+		_, _ = f.Seek(info.Size()-tailBytes, io.SeekStart)
+	}
+	buf, err := io.ReadAll(f)
+	if err != nil {
+		return err.Error()
+	}
+	lines := strings.Split(strings.TrimRight(string(buf), "\n"), "\n")
+	if len(lines) > tailLines {
+		lines = lines[len(lines)-tailLines:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Install 把运行时致命错误的输出同时写入 log.path 下的 crash.pending；
+// 此前的运行留下非空的 crash.pending 时先整理为崩溃报告，遗留的路由与系统代理由启动时的清理恢复
+func Install(ctx context2.Context) {
+	path := filepath.Join(config.Config.Log.Path, pendingFile)
+	if buf, err := os.ReadFile(path); err == nil && len(bytes.TrimSpace(buf)) > 0 {
+		report, err := WriteReport("fatal error in previous run (see goroutines)", buf)
+		logger.Warn(ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"report": report,
+			"error":  err,
+		}, "previous run crashed, crash report written")
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		logger.Warn(ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"file":   path,
+			"error":  err,
+		}, "open crash output failed")
+		return
+	}
+	defer f.Close()
+	if err := debug.SetCrashOutput(f, debug.CrashOptions{}); err != nil {
+		logger.Warn(ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"error":  err,
+		}, "set crash output failed")
+	}
+}
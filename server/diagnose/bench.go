@@ -0,0 +1,284 @@
+package diagnose
+
+import (
+	context2 "context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/proxy/client"
+	"proxy/server/route"
+)
+
+// DefaultBenchURL 测速默认下载的文件
+const DefaultBenchURL = "https://speed.cloudflare.com/__down?bytes=200000000"
+
+// DefaultUploadURL 上传测速默认的地址，接受任意长度的 POST 请求体
+const DefaultUploadURL = "https://speed.cloudflare.com/__up"
+
+// BenchOptions 测速参数
+type BenchOptions struct {
+	URL       string        // 下载测速的地址，其主机与端口同时作为握手目标
+	UploadURL string        // 上传测速的地址，为空时不测上传
+	Count     int           // 每个出口的握手次数
+	Duration  time.Duration // 每个出口的下载（与上传）时长
+	Via       int8          // 只测该类型的出口（取值同 out.type），0 时测 out.type 对应的出口并与直连对比
+	Addr      string        // 只测 out.remote_addr 中的该地址，为空时逐个地址测量
+	// Live 在运行中的进程内测速（管理接口）：不临时改写 out.remote_addr，隧道出口按正常的地址选择只测一次，以免影响在途流量
+	Live bool
+}
+
+// BenchResult 单个出口的测速结果
+type BenchResult struct {
+	Remote    string  `json:"remote"`
+	Addr      string  `json:"addr,omitempty"`
+	Succeeded int     `json:"handshake_ok"`
+	Min       float64 `json:"handshake_min_ms"`
+	Avg       float64 `json:"handshake_avg_ms"`
+	Max       float64 `json:"handshake_max_ms"`
+	Bytes     int64   `json:"bytes"`
+	Mbps      float64 `json:"mbps"`
+	UpBytes   int64   `json:"up_bytes,omitempty"`
+	UpMbps    float64 `json:"up_mbps,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Bench 依次测量 out.remote_addr 中每个地址以及直连的握手延迟与下载吞吐，输出对比表格
+func Bench(ctx context2.Context, opts BenchOptions, w io.Writer) error {
+	results, err := RunBench(ctx, opts)
+	if err != nil {
+		return err
+	}
+	opts = benchDefaults(opts)
+	fmt.Fprintf(w, "url: %s  handshakes: %d  duration: %s\n", opts.URL, opts.Count, opts.Duration)
+	if opts.UploadURL != "" {
+		fmt.Fprintf(w, "upload: %s\n", opts.UploadURL)
+	}
+	fmt.Fprintf(w, "%-40s %-26s %s\n", "remote", "handshake min/avg/max", "throughput")
+	for _, r := range results {
+		name := r.Remote
+		if r.Addr != "" {
+			name += " " + r.Addr
+		}
+		handshake := fmt.Sprintf("%.0f/%.0f/%.0fms (%d/%d)", r.Min, r.Avg, r.Max, r.Succeeded, opts.Count)
+		if r.Succeeded == 0 {
+			handshake = fmt.Sprintf("failed (0/%d)", opts.Count)
+		}
+		throughput := fmt.Sprintf("%.1f Mbit/s (%.1f MB)", r.Mbps, float64(r.Bytes)/1e6)
+		if opts.UploadURL != "" && r.Succeeded > 0 {
+			throughput = fmt.Sprintf("down %s  up %.1f Mbit/s (%.1f MB)", throughput, r.UpMbps, float64(r.UpBytes)/1e6)
+		}
+		if r.Error != "" {
+			throughput += "  " + r.Error
+		}
+		fmt.Fprintf(w, "%-40s %-26s %s\n", name, handshake, throughput)
+	}
+	return nil
+}
+
+// RunBench 按 opts 测速，返回各出口的结果
+func RunBench(ctx context2.Context, opts BenchOptions) ([]BenchResult, error) {
+	opts = benchDefaults(opts)
+	u, err := url.Parse(opts.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q", opts.URL)
+	}
+	if opts.UploadURL != "" {
+		if u, err := url.Parse(opts.UploadURL); err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid upload url %q", opts.UploadURL)
+		}
+	}
+	if opts.Via < 0 || opts.Via > config.RemoteTypeKCP {
+		return nil, fmt.Errorf("via must be 0 or an out.type value 1-8, got %d", opts.Via)
+	}
+	if opts.Addr != "" && opts.Live {
+		return nil, errors.New("addr is not supported while the proxy is running, use the bench command instead")
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	target, err := common.NewTargetAddr(net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+	target.Proto = 1
+	return benchRemotes(ctx, target, opts), nil
+}
+
+func benchDefaults(opts BenchOptions) BenchOptions {
+	if opts.URL == "" {
+		opts.URL = DefaultBenchURL
+	}
+	opts.Count = max(opts.Count, 1)
+	if opts.Duration <= 0 {
+		opts.Duration = 10 * time.Second
+	}
+	return opts
+}
+
+// benchRemotes 加密隧道出口按 out.remote_addr 逐个地址测量（测量期间 out.remote_addr 临时只保留该地址），
+// 其余出口类型测量 out 对应的出口；未指定 via 时最后测量直连
+func benchRemotes(ctx context2.Context, target *common.TargetAddr, opts BenchOptions) []BenchResult {
+	var results []BenchResult
+	t := opts.Via
+	if t == 0 {
+		t = config.OutType()
+	}
+	switch t {
+	case config.RemoteTypeDirect:
+	case config.RemoteTypeTLS, config.RemoteTypeWSS, config.RemoteTypeQUIC, config.RemoteTypeGRPC, config.RemoteTypeKCP:
+		if opts.Live {
+			results = append(results, benchRemote(ctx, route.RemoteOf(t), target, opts))
+			break
+		}
+		addrs := config.RemoteAddrs()
+		if opts.Addr != "" {
+			addrs = []string{opts.Addr}
+		}
+		saved := config.Config.Out.RemoteAddr
+		for _, addr := range addrs {
+			config.Config.Out.RemoteAddr = addr
+			r := benchRemote(ctx, route.RemoteOf(t), target, opts)
+			r.Addr = addr
+			results = append(results, r)
+		}
+		config.Config.Out.RemoteAddr = saved
+	default:
+		if opts.Via == 0 {
+			results = append(results, benchRemote(ctx, route.ProxyRemote(), target, opts))
+		} else {
+			results = append(results, benchRemote(ctx, route.RemoteOf(t), target, opts))
+		}
+	}
+	if opts.Via != 0 && opts.Via != config.RemoteTypeDirect {
+		return results
+	}
+	return append(results, benchRemote(ctx, &client.DirectRemote{}, target, opts))
+}
+
+// benchRemote 经 remote 握手 opts.Count 次，再下载 opts.URL 至多 opts.Duration
+func benchRemote(ctx context2.Context, remote common.Remote, target *common.TargetAddr, opts BenchOptions) BenchResult {
+	result := BenchResult{Remote: remote.Name()}
+	var durations []float64
+	var lastErr error
+	for i := 0; i < opts.Count; i++ {
+		begin := time.Now()
+		rw, err := remote.Handshake(ctx, target)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		durations = append(durations, milliseconds(time.Since(begin)))
+		if closer, ok := rw.(io.Closer); ok {
+			_ = closer.Close()
+		}
+	}
+	if len(durations) == 0 {
+		result.Error = lastErr.Error()
+		return result
+	}
+	result.Succeeded = len(durations)
+	result.Min, result.Max = slices.Min(durations), slices.Max(durations)
+	var sum float64
+	for _, d := range durations {
+		sum += d
+	}
+	result.Avg = sum / float64(len(durations))
+
+	n, elapsed, err := download(ctx, remote, opts.URL, opts.Duration)
+	result.Bytes = n
+	if elapsed > 0 {
+		result.Mbps = float64(n) * 8 / 1e6 / elapsed.Seconds()
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if opts.UploadURL != "" {
+		n, elapsed, err := upload(ctx, remote, opts.UploadURL, opts.Duration)
+		result.UpBytes = n
+		if elapsed > 0 {
+			result.UpMbps = float64(n) * 8 / 1e6 / elapsed.Seconds()
+		}
+		if err != nil {
+			result.Error = "upload: " + err.Error()
+		}
+	}
+	return result
+}
+
+// download 经 remote 下载 rawURL，到达 limit 时停止，返回收到的字节数与从收到响应头开始计算的耗时
+func download(ctx context2.Context, remote common.Remote, rawURL string, limit time.Duration) (int64, time.Duration, error) {
+	c, cancel := context2.WithTimeout(context2.Background(), limit+selfTestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(c, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	resp, err := remoteHTTPClient(ctx, remote).Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, errors.New(resp.Status)
+	}
+	begin := time.Now()
+	stop := time.AfterFunc(limit, cancel)
+	defer stop.Stop()
+	n, err := io.Copy(io.Discard, resp.Body)
+	elapsed := time.Since(begin)
+	if err != nil && elapsed >= limit {
+		err = nil
+	}
+	return n, elapsed, err
+}
+
+// upload 经 remote 向 rawURL 持续发送数据，到达 limit 时结束请求体，返回发出的字节数与耗时
+func upload(ctx context2.Context, remote common.Remote, rawURL string, limit time.Duration) (int64, time.Duration, error) {
+	c, cancel := context2.WithTimeout(context2.Background(), limit+selfTestTimeout)
+	defer cancel()
+	body := &timedReader{deadline: time.Now().Add(limit)}
+	req, err := http.NewRequestWithContext(c, http.MethodPost, rawURL, body)
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	begin := time.Now()
+	resp, err := remoteHTTPClient(ctx, remote).Do(req)
+	elapsed := time.Since(begin)
+	if err != nil {
+		return body.n, elapsed, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return body.n, elapsed, errors.New(resp.Status)
+	}
+	return body.n, elapsed, nil
+}
+
+// timedReader 在 deadline 之前不断返回零字节数据，之后返回 io.EOF
+type timedReader struct {
+	deadline time.Time
+	n        int64
+}
+
+func (r *timedReader) Read(p []byte) (int, error) {
+	if time.Now().After(r.deadline) {
+		return 0, io.EOF
+	}
+	clear(p)
+	r.n += int64(len(p))
+	return len(p), nil
+}
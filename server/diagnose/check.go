@@ -0,0 +1,876 @@
+package diagnose
+
+import (
+	context2 "context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/sirupsen/logrus"
+
+	"proxy/config"
+	"proxy/server/admin"
+	"proxy/server/hooks"
+	"proxy/server/limit"
+	"proxy/server/quota"
+	"proxy/server/route"
+	"proxy/server/subscription"
+	"proxy/utils/helper"
+)
+
+// problem check 发现的一个配置问题
+type problem struct {
+	Field   string // 配置项，如 in.port、white_list[2]
+	Message string
+	Warning bool // 仅提示，不影响退出码
+}
+
+// checker 收集配置问题
+type checker struct {
+	problems []problem
+}
+
+func (c *checker) errorf(field, format string, args ...interface{}) {
+	c.problems = append(c.problems, problem{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) warnf(field, format string, args ...interface{}) {
+	c.problems = append(c.problems, problem{Field: field, Message: fmt.Sprintf(format, args...), Warning: true})
+}
+
+// Check 加载并校验配置，把发现的所有问题连同所在行写到 w，存在错误时返回 false
+func Check(w io.Writer) bool {
+	file := config.Path()
+	if err := config.LoadError(); err != nil {
+		fmt.Fprintf(w, "%s: error: %v\n", file, err)
+		return false
+	}
+	c := &checker{}
+	c.checkBasic()
+	c.checkInbound()
+	c.checkSubscription()
+	c.checkRules()
+	c.checkTor()
+	c.checkMITM()
+	c.checkAdBlock()
+	c.checkScript()
+	c.checkReverse()
+	c.checkForward()
+	c.checkFiles()
+	c.checkTun()
+	c.checkLimit()
+	c.checkMemory()
+	c.checkUsers()
+	c.checkServices()
+	c.checkLog()
+
+	errs := 0
+	for _, p := range c.problems {
+		level := "warning"
+		if !p.Warning {
+			level = "error"
+			errs++
+		}
+		line, text := config.Locate(p.Field)
+		if line == 0 {
+			fmt.Fprintf(w, "%s: %s: %s: %s\n", file, level, p.Field, p.Message)
+			continue
+		}
+		fmt.Fprintf(w, "%s:%d: %s: %s: %s\n", file, line, level, p.Field, p.Message)
+		// 密钥所在行不回显
+		if !secretField(p.Field) {
+			fmt.Fprintf(w, "    %d | %s\n", line, text)
+		}
+	}
+	if len(c.problems) == 0 {
+		fmt.Fprintf(w, "%s: config OK\n", file)
+	} else {
+		fmt.Fprintf(w, "%d error(s), %d warning(s)\n", errs, len(c.problems)-errs)
+	}
+	return errs == 0
+}
+
+func secretField(field string) bool {
+	return field == "user" || field == "out.user" || strings.HasSuffix(field, ".key") || strings.HasSuffix(field, ".password") || strings.HasSuffix(field, ".token") ||
+		strings.HasPrefix(field, "subscription.links") || strings.HasPrefix(field, "subscription.urls")
+}
+
+func (c *checker) checkBasic() {
+	cfg := config.Config
+	if len(cfg.User) != 32 {
+		c.errorf("user", "must be a 32-byte chacha20 key, got %d bytes", len(cfg.User))
+	}
+	if cfg.Out.User != "" && len(cfg.Out.User) != 32 {
+		c.errorf("out.user", "must be a 32-byte chacha20 key, got %d bytes", len(cfg.Out.User))
+	}
+	if cfg.ECSSubnet != "" {
+		if _, _, err := net.ParseCIDR(cfg.ECSSubnet); err != nil {
+			c.errorf("ecs_subnet", "invalid CIDR %q", cfg.ECSSubnet)
+		}
+	}
+	switch cfg.DNS.IPStrategy {
+	case "", config.IPStrategyIPv4Only, config.IPStrategyIPv6First, config.IPStrategyDual:
+	default:
+		c.errorf("dns.ip_strategy", "must be one of %s, %s, %s, got %q",
+			config.IPStrategyIPv4Only, config.IPStrategyIPv6First, config.IPStrategyDual, cfg.DNS.IPStrategy)
+	}
+	for name, value := range cfg.DNS.Hosts {
+		if value == "" {
+			c.errorf("dns.hosts", "empty address for %s", name)
+		}
+	}
+	for i, rule := range cfg.DNS.Guard.Allow {
+		if err := route.ValidateRule(rule); err != nil {
+			c.errorf(fmt.Sprintf("dns.guard.allow[%d]", i), "%v", err)
+		}
+	}
+	if cfg.DNS.Guard.Enable && !cfg.Tun.Enable {
+		c.warnf("dns.guard.enable", "only takes effect in TUN mode, tun.enable is off")
+	}
+	if cfg.Shutdown.GracePeriod != "" {
+		if d, err := time.ParseDuration(cfg.Shutdown.GracePeriod); err != nil || d < 0 {
+			c.errorf("shutdown.grace_period", "must be a non-negative duration, got %q", cfg.Shutdown.GracePeriod)
+		}
+	}
+	if cfg.TCP.KeepAlive != "" {
+		if d, err := time.ParseDuration(cfg.TCP.KeepAlive); err != nil || d < 0 {
+			c.errorf("tcp.keep_alive", "must be a non-negative duration, got %q", cfg.TCP.KeepAlive)
+		}
+	}
+	for _, t := range []struct {
+		key, value string
+		allowZero  bool
+	}{
+		{"timeouts.handshake", cfg.Timeouts.Handshake, false},
+		{"timeouts.dial", cfg.Timeouts.Dial, false},
+		{"timeouts.idle", cfg.Timeouts.Idle, true},
+		{"timeouts.udp_session", cfg.Timeouts.UDPSession, true},
+		{"timeouts.udp_mapping", cfg.Timeouts.UDPMapping, true},
+	} {
+		if t.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(t.value)
+		switch {
+		case err != nil || d < 0:
+			c.errorf(t.key, "must be a non-negative duration, got %q", t.value)
+		case d == 0 && !t.allowZero:
+			c.errorf(t.key, "must be a positive duration, got %q", t.value)
+		}
+	}
+	if cfg.Retry.Attempts != nil && *cfg.Retry.Attempts < 0 {
+		c.errorf("retry.attempts", "must not be negative, got %d", *cfg.Retry.Attempts)
+	}
+	for _, t := range []struct{ key, value string }{
+		{"retry.backoff", cfg.Retry.Backoff},
+		{"retry.max_backoff", cfg.Retry.MaxBackoff},
+	} {
+		if t.value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(t.value); err != nil || d < 0 {
+			c.errorf(t.key, "must be a non-negative duration, got %q", t.value)
+		}
+	}
+	switch fallback := cfg.Retry.Fallback; {
+	case fallback == 0:
+	case fallback < config.RemoteTypeTLS || fallback > config.RemoteTypeKCP:
+		c.errorf("retry.fallback", "must be 0 or an out.type value (1-8), got %d", fallback)
+	case fallback == cfg.Out.Type:
+		c.warnf("retry.fallback", "is the same as out.type, fallback is disabled")
+	case fallback == config.RemoteTypeDirect && cfg.Out.KillSwitch:
+		c.warnf("retry.fallback", "is 3 (Direct) but out.kill_switch is on, fallback is disabled")
+	case fallback == config.RemoteTypeDirect:
+		c.warnf("retry.fallback", "is 3 (Direct), proxied traffic goes out directly when the remote is unreachable")
+	}
+}
+
+func (c *checker) checkInbound() {
+	cfg := config.Config
+	if cfg.In.Type < config.ServerTypeSocket || cfg.In.Type > config.ServerTypeKCP {
+		c.errorf("in.type", "must be 1 (SOCKS5), 2 (HTTP), 3 (TLS), 4 (WSS), 5 (QUIC), 6 (gRPC), 7 (SOCKS5 over TLS), 8 (mixed) or 9 (KCP), got %d", cfg.In.Type)
+	}
+	if cfg.In.Port < 1 || cfg.In.Port > 65535 {
+		c.errorf("in.port", "must be between 1 and 65535, got %d", cfg.In.Port)
+	}
+	if cfg.In.Listen != "" {
+		if ip, err := netip.ParseAddr(cfg.In.Listen); err != nil {
+			c.errorf("in.listen", "must be an IP address, got %q", cfg.In.Listen)
+		} else if !ip.IsLoopback() && !ip.IsUnspecified() && (cfg.Tun.Enable || cfg.SystemProxy.Enable) {
+			c.warnf("in.listen", "TUN and system proxy connect to 127.0.0.1, which %s does not listen on", cfg.In.Listen)
+		}
+	}
+	for _, f := range []struct {
+		name string
+		list []string
+	}{{"in.allow_clients", cfg.In.AllowClients}, {"in.trusted_proxies", cfg.In.TrustedProxy}} {
+		for _, item := range f.list {
+			item = strings.TrimSpace(item)
+			if _, err := netip.ParsePrefix(item); err == nil {
+				continue
+			}
+			if _, err := netip.ParseAddr(item); err != nil {
+				c.errorf(f.name, "must be an IP address or CIDR, got %q", item)
+			}
+		}
+	}
+	if cfg.In.MaxConnsPerIP < 0 {
+		c.errorf("in.max_conns_per_ip", "must not be negative, got %d", cfg.In.MaxConnsPerIP)
+	}
+	if cfg.In.MaxConns < 0 {
+		c.errorf("in.max_conns", "must not be negative, got %d", cfg.In.MaxConns)
+	}
+	switch cfg.In.Overload {
+	case "", config.OverloadWait, config.OverloadReject:
+	default:
+		c.errorf("in.overload", "must be %q or %q, got %q", config.OverloadWait, config.OverloadReject, cfg.In.Overload)
+	}
+	if cfg.Out.Type < config.RemoteTypeTLS || cfg.Out.Type > config.RemoteTypeKCP {
+		c.errorf("out.type", "must be 1 (TLS), 2 (WSS), 3 (Direct), 4 (subscription node), 5 (QUIC), 6 (gRPC), 7 (HTTP CONNECT) or 8 (KCP), got %d", cfg.Out.Type)
+	}
+	switch cfg.Out.Type {
+	case config.RemoteTypeTLS, config.RemoteTypeWSS, config.RemoteTypeQUIC, config.RemoteTypeGRPC, config.RemoteTypeKCP:
+		addrs := config.RemoteAddrs()
+		if len(addrs) == 0 {
+			c.errorf("out.remote_addr", "is required when out.type is TLS, WSS, QUIC, gRPC or KCP")
+		}
+		for _, addr := range addrs {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil || host == "" || net.ParseIP(host) != nil || strings.Contains(host, ":") {
+				c.errorf("out.remote_addr", "must be a domain name with optional port, got %q", addr)
+				continue
+			}
+			if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+				c.errorf("out.remote_addr", "port must be between 1 and 65535, got %q", addr)
+			} else if cfg.Out.Relay && strings.EqualFold(host, cfg.In.ServerName) && p == cfg.In.Port {
+				c.errorf("out.remote_addr", "%s is this server itself, relaying to it would loop", addr)
+			}
+		}
+		if cfg.Out.KillSwitch && cfg.Out.FailOpen {
+			c.errorf("out.fail_open", "cannot be used together with out.kill_switch")
+		}
+	default:
+		if cfg.Out.KillSwitch {
+			c.warnf("out.kill_switch", "only applies when out.type is TLS, WSS, QUIC, gRPC or KCP")
+		}
+		if cfg.Out.Relay && cfg.Out.Type == config.RemoteTypeDirect {
+			c.errorf("out.relay", "needs an upstream, out.type must not be 3 (Direct)")
+		}
+		if cfg.Out.FailOpen {
+			c.warnf("out.fail_open", "only applies when out.type is TLS, WSS, QUIC, gRPC or KCP")
+		}
+	}
+	if cfg.Out.Relay && (cfg.In.Type < config.ServerTypeTLS || cfg.In.Type > config.ServerTypeGRPC) &&
+		cfg.In.Type != config.ServerTypeMixed && cfg.In.Type != config.ServerTypeKCP {
+		c.warnf("out.relay", "is meant for servers, with in.type %d all traffic goes through out without rules", cfg.In.Type)
+	}
+	if strings.ContainsAny(cfg.In.GRPCService, "/?# ") {
+		c.errorf("in.grpc_service", "must be a bare service name without '/', got %q", cfg.In.GRPCService)
+	}
+	if strings.ContainsAny(cfg.Out.GRPCService, "/?# ") {
+		c.errorf("out.grpc_service", "must be a bare service name without '/', got %q", cfg.Out.GRPCService)
+	}
+	c.checkWSS("in.wss", cfg.In.WSS.Path, cfg.In.WSS.Headers)
+	c.checkWSS("out.wss", cfg.Out.WSS.Path, cfg.Out.WSS.Headers)
+	c.checkHTTPProxy()
+	if cfg.Out.BindInterface != "" {
+		if _, err := net.InterfaceByName(cfg.Out.BindInterface); err != nil {
+			c.errorf("out.bind_interface", "%v", err)
+		}
+	}
+	if cfg.Out.CertFile != "" || cfg.Out.CAFile != "" {
+		if cfg.Out.CertFile != "" && cfg.Out.KeyFile == "" {
+			c.errorf("out.key_file", "is required with out.cert_file")
+		} else if _, _, err := config.RemoteTLS(); err != nil {
+			c.errorf("out.cert_file", "%v", err)
+		}
+	}
+	if cfg.In.ProxyProtocol && (cfg.In.Type == config.ServerTypeQUIC || cfg.In.Type == config.ServerTypeKCP) {
+		c.warnf("in.proxy_protocol", "is not supported by the QUIC or KCP inbound and is ignored")
+	} else if cfg.In.ProxyProtocol && len(cfg.In.TrustedProxy) == 0 {
+		c.warnf("in.trusted_proxies", "is empty, PROXY protocol headers are only accepted from loopback addresses")
+	}
+	c.checkKCP()
+	if cfg.In.Type < config.ServerTypeTLS || cfg.In.Type > config.ServerTypeMixed {
+		return
+	}
+	if cfg.In.ClientCA != "" {
+		if _, err := config.LoadCertPool(cfg.In.ClientCA); err != nil {
+			c.errorf("in.client_ca", "%v", err)
+		}
+	}
+	if cfg.In.TimeWindow != "" {
+		if d, err := time.ParseDuration(cfg.In.TimeWindow); err != nil || d < time.Second || d > time.Hour {
+			c.errorf("in.time_window", "must be a duration between 1s and 1h, got %q", cfg.In.TimeWindow)
+		}
+	}
+	c.checkHosts()
+	if cfg.In.CertFile != "" {
+		c.checkCertFile("in", cfg.In.CertFile, cfg.In.KeyFile)
+		return
+	}
+	if len(cfg.In.ServerName) < 3 {
+		c.errorf("in.server_name", "is required when in.type is TLS, WSS, QUIC or gRPC")
+		return
+	}
+	if cfg.In.Email == "" {
+		c.warnf("in.email", "is empty, certificate expiry notices will not be sent")
+	}
+	c.checkCert(cfg.In.ServerName)
+}
+
+// wssReservedHeaders 由 WebSocket 握手自行设置的头，不能在 wss.headers 中覆盖
+var wssReservedHeaders = []string{"Upgrade", "Connection", "Sec-Websocket-Key", "Sec-Websocket-Version", "Sec-Websocket-Extensions", "Sec-Websocket-Accept", "Host"}
+
+// checkWSS 检查 wss.path 是否以 / 开头，wss.headers 是否包含握手保留的头
+func (c *checker) checkWSS(key, path string, headers map[string]string) {
+	if path != "" {
+		if u, err := url.Parse(path); err != nil || !strings.HasPrefix(path, "/") || u.Host != "" {
+			c.errorf(key+".path", "must be an absolute path like /ws, got %q", path)
+		}
+	}
+	for name := range headers {
+		for _, reserved := range wssReservedHeaders {
+			if http.CanonicalHeaderKey(name) == reserved {
+				c.errorf(key+".headers", "%s is set by the WebSocket handshake, use %s.host for Host", name, key)
+			}
+		}
+	}
+}
+
+// checkCert 检查本地是否已有可用证书，没有时启动后会向 Let's Encrypt 申请
+func (c *checker) checkCert(domain string) {
+	ctx, cancel := context2.WithTimeout(context2.Background(), 10*time.Second)
+	defer cancel()
+	cert, err := certmagic.NewDefault().CacheManagedCertificate(ctx, domain)
+	switch {
+	case err != nil:
+		c.warnf("in.server_name", "no cached certificate for %s, it will be requested from Let's Encrypt on startup (ports 80/443 must be reachable)", domain)
+	case cert.Expired():
+		c.warnf("in.server_name", "cached certificate for %s expired at %s, it will be renewed on startup",
+			domain, cert.Leaf.NotAfter.In(config.CstZone).Format(config.TimeFormat))
+	}
+}
+
+// checkCertFile 检查自备证书能否与私钥配对加载，以及是否已过期或即将过期
+func (c *checker) checkCertFile(prefix, certFile, keyFile string) {
+	if keyFile == "" {
+		c.errorf(prefix+".key_file", "is required with %s.cert_file", prefix)
+		return
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		c.errorf(prefix+".cert_file", "%v", err)
+		return
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		c.errorf(prefix+".cert_file", "%v", err)
+		return
+	}
+	notAfter := leaf.NotAfter.In(config.CstZone).Format(config.TimeFormat)
+	switch {
+	case time.Now().After(leaf.NotAfter):
+		c.errorf(prefix+".cert_file", "certificate expired at %s", notAfter)
+	case time.Until(leaf.NotAfter) < 7*24*time.Hour:
+		c.warnf(prefix+".cert_file", "certificate expires at %s, renew it soon", notAfter)
+	}
+}
+
+// checkHosts 检查 in.hosts 的域名与证书，以及 in.fallback 的地址
+func (c *checker) checkHosts() {
+	in := config.Config.In
+	for i, h := range in.Hosts {
+		prefix := fmt.Sprintf("in.hosts[%d]", i)
+		if h.ServerName == "" {
+			c.errorf(prefix+".server_name", "is required")
+		}
+		switch {
+		case h.CertFile != "":
+			c.checkCertFile(prefix, h.CertFile, h.KeyFile)
+		case strings.HasPrefix(h.ServerName, "*.") && in.CertFile == "":
+			c.errorf(prefix+".cert_file", "is required for wildcard name %s, Let's Encrypt can not issue it over HTTP", h.ServerName)
+		}
+	}
+	if in.Fallback == "" {
+		return
+	}
+	if _, port, err := net.SplitHostPort(in.Fallback); err != nil || port == "" {
+		c.errorf("in.fallback", "must be host:port, got %q", in.Fallback)
+	}
+	switch {
+	case in.Type == config.ServerTypeQUIC:
+		c.warnf("in.fallback", "is not supported by the QUIC inbound and is ignored")
+	case in.ServerName == "" && len(in.Hosts) == 0:
+		c.warnf("in.fallback", "is ignored without in.server_name or in.hosts, every connection is served by the proxy")
+	}
+}
+
+func (c *checker) checkSubscription() {
+	sub := config.Config.Subscription
+	usable := len(sub.URLs) > 0
+	for i, link := range sub.Links {
+		field := fmt.Sprintf("subscription.links[%d]", i)
+		n, err := subscription.Parse(link)
+		if err != nil {
+			c.errorf(field, "%v", err)
+			continue
+		}
+		if err := n.Supported(); err != nil {
+			c.warnf(field, "%s: %v", n.Name, err)
+			continue
+		}
+		usable = true
+	}
+	for i, raw := range sub.URLs {
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			c.errorf(fmt.Sprintf("subscription.urls[%d]", i), "must be an http(s) URL")
+		}
+	}
+	if sub.Interval != "" {
+		if d, err := time.ParseDuration(sub.Interval); err != nil || d < time.Minute {
+			c.errorf("subscription.interval", "must be a duration of at least 1m, got %q", sub.Interval)
+		}
+	}
+	if config.OutType() == config.RemoteTypeSubscription && !usable {
+		c.errorf("out.type", "is 4 (subscription node) but subscription has no usable links or urls")
+	}
+}
+
+func (c *checker) checkRules() {
+	switch mode := config.Config.Mode; mode {
+	case "", config.ModeRule, config.ModeGlobal, config.ModeDirect:
+	default:
+		c.errorf("mode", "must be %q, %q or %q, got %q", config.ModeRule, config.ModeGlobal, config.ModeDirect, mode)
+	}
+	for i, rule := range config.Config.WhiteList {
+		if err := route.ValidateRule(rule); err != nil {
+			c.errorf(fmt.Sprintf("white_list[%d]", i), "%v", err)
+		}
+	}
+	for i, rule := range config.Config.BlackList {
+		if err := route.ValidateRule(rule); err != nil {
+			c.errorf(fmt.Sprintf("black_list[%d]", i), "%v", err)
+		}
+	}
+	for i, rule := range config.Config.DNS.RemoteResolve {
+		if err := route.ValidateRule(rule); err != nil {
+			c.errorf(fmt.Sprintf("dns.remote_resolve[%d]", i), "%v", err)
+		}
+	}
+	for i, rule := range config.Config.DNS.LocalResolve {
+		if err := route.ValidateRule(rule); err != nil {
+			c.errorf(fmt.Sprintf("dns.local_resolve[%d]", i), "%v", err)
+		}
+	}
+}
+
+func (c *checker) checkTor() {
+	tor := config.Config.Tor
+	for i, rule := range tor.Rules {
+		if err := route.ValidateRule(rule); err != nil {
+			c.errorf(fmt.Sprintf("tor.rules[%d]", i), "%v", err)
+		}
+	}
+	if tor.Socks != "" {
+		if _, _, err := net.SplitHostPort(tor.Socks); err != nil {
+			c.errorf("tor.socks", "%v", err)
+		}
+	}
+	if tor.Binary != "" {
+		if _, err := exec.LookPath(tor.Binary); err != nil {
+			c.errorf("tor.binary", "%v", err)
+		}
+	}
+}
+
+func (c *checker) checkMITM() {
+	m := config.Config.MITM
+	for i, rule := range m.Rules {
+		if err := route.ValidateRule(rule); err != nil {
+			c.errorf(fmt.Sprintf("mitm.rules[%d]", i), "%v", err)
+		}
+	}
+	if m.MaxBody < 0 {
+		c.errorf("mitm.max_body", "must not be negative")
+	}
+	if (m.CACert == "") != (m.CAKey == "") {
+		c.errorf("mitm.ca_cert", "ca_cert and ca_key must be set together")
+	}
+	if len(m.Rules) == 0 {
+		return
+	}
+	switch config.Config.In.Type {
+	case config.ServerTypeSocket, config.ServerTypeHttp, config.ServerTypeMixed:
+		c.warnf("mitm.rules", "HTTPS traffic to matching targets is decrypted and logged, use for debugging only")
+	default:
+		c.warnf("mitm.rules", "only applies to SOCKS5 / HTTP proxy inbounds (in.type 1, 2 or 8)")
+	}
+}
+
+func (c *checker) checkAdBlock() {
+	ab := config.Config.AdBlock
+	for i, list := range ab.Lists {
+		field := fmt.Sprintf("adblock.lists[%d]", i)
+		if strings.HasPrefix(list, "http://") || strings.HasPrefix(list, "https://") {
+			if u, err := url.Parse(list); err != nil || u.Host == "" {
+				c.errorf(field, "invalid URL %q", list)
+			}
+			continue
+		}
+		if _, err := os.Stat(absPath(list)); err != nil {
+			c.errorf(field, "%v", err)
+		}
+	}
+	for i, rule := range ab.Allow {
+		if err := route.ValidateRule(rule); err != nil {
+			c.errorf(fmt.Sprintf("adblock.allow[%d]", i), "%v", err)
+		}
+	}
+	if ab.Interval != "" {
+		if d, err := time.ParseDuration(ab.Interval); err != nil || d < time.Minute {
+			c.errorf("adblock.interval", "must be a duration of at least 1m, got %q", ab.Interval)
+		}
+	}
+}
+
+func (c *checker) checkScript() {
+	file := config.Config.Script.File
+	if file == "" {
+		return
+	}
+	if _, err := route.CompileScript(absPath(file)); err != nil {
+		c.errorf("script.file", "%v", err)
+	}
+}
+
+func (c *checker) checkForward() {
+	seen := make(map[string]bool)
+	for i, rule := range config.Config.Forward {
+		field := fmt.Sprintf("forward[%d]", i)
+		if _, _, err := net.SplitHostPort(rule.Listen); err != nil {
+			c.errorf(field+".listen", "%v", err)
+		} else if seen[rule.Listen] {
+			c.errorf(field+".listen", "%s is used by another forward rule", rule.Listen)
+		} else if rule.Listen == config.ListenAddr() {
+			c.errorf(field+".listen", "%s is the inbound listen address", rule.Listen)
+		}
+		seen[rule.Listen] = true
+		if _, _, err := net.SplitHostPort(rule.Target); err != nil {
+			c.errorf(field+".target", "%v", err)
+		}
+		if rule.Via < 0 || rule.Via > config.RemoteTypeKCP {
+			c.errorf(field+".via", "must be 0 (by rules) or an out.type value 1-8, got %d", rule.Via)
+		}
+	}
+}
+
+func (c *checker) checkHTTPProxy() {
+	cfg := config.Config
+	p := cfg.Out.HTTPProxy
+	if p.Addr == "" {
+		if cfg.Out.Type == config.RemoteTypeHTTPConnect {
+			c.errorf("out.http_proxy.addr", "is required when out.type is 7 (HTTP CONNECT)")
+		}
+		return
+	}
+	if host, port, err := net.SplitHostPort(p.Addr); err != nil || host == "" {
+		c.errorf("out.http_proxy.addr", "must be host:port, got %q", p.Addr)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		c.errorf("out.http_proxy.addr", "port must be between 1 and 65535, got %q", p.Addr)
+	}
+	if p.Username == "" && p.Password != "" {
+		c.warnf("out.http_proxy.password", "is ignored without out.http_proxy.username")
+	}
+	switch cfg.Out.Type {
+	case config.RemoteTypeQUIC, config.RemoteTypeKCP:
+		c.warnf("out.http_proxy", "QUIC and KCP run over UDP and cannot go through an HTTP proxy")
+	case config.RemoteTypeDirect, config.RemoteTypeSubscription:
+		c.warnf("out.http_proxy", "is only used when out.type is TLS, WSS, gRPC or 7 (HTTP CONNECT)")
+	}
+}
+
+func (c *checker) checkKCP() {
+	k := config.Config.KCP
+	if k.MTU != 0 && (k.MTU < 576 || k.MTU > 9000) {
+		c.errorf("kcp.mtu", "must be between 576 and 9000, got %d", k.MTU)
+	}
+	if k.SndWnd < 0 {
+		c.errorf("kcp.snd_wnd", "must not be negative, got %d", k.SndWnd)
+	}
+	if k.RcvWnd < 0 {
+		c.errorf("kcp.rcv_wnd", "must not be negative, got %d", k.RcvWnd)
+	}
+	if k.FEC < 0 || k.FEC > 32 {
+		c.errorf("kcp.fec", "must be between 0 and 32, got %d", k.FEC)
+	}
+	if k.Interval != "" {
+		if d, err := time.ParseDuration(k.Interval); err != nil || d <= 0 {
+			c.errorf("kcp.interval", "must be a positive duration such as 10ms, got %q", k.Interval)
+		}
+	}
+}
+
+func (c *checker) checkReverse() {
+	cfg := config.Config
+	for i, port := range cfg.Reverse.Ports {
+		if port < 1 || port > 65535 {
+			c.errorf(fmt.Sprintf("reverse.ports[%d]", i), "must be between 1 and 65535, got %d", port)
+		}
+	}
+	for i, t := range cfg.Reverse.Tunnels {
+		field := fmt.Sprintf("reverse.tunnels[%d]", i)
+		if ap, err := netip.ParseAddrPort(t.Remote); err != nil || ap.Port() == 0 {
+			c.errorf(field+".remote", "must be IP:port, got %q", t.Remote)
+		}
+		if _, _, err := net.SplitHostPort(t.Local); err != nil {
+			c.errorf(field+".local", "%v", err)
+		}
+	}
+	if len(cfg.Reverse.Tunnels) > 0 {
+		switch cfg.Out.Type {
+		case config.RemoteTypeTLS, config.RemoteTypeWSS, config.RemoteTypeQUIC, config.RemoteTypeGRPC, config.RemoteTypeKCP:
+		default:
+			c.errorf("reverse.tunnels", "need out.type to be TLS, WSS, QUIC, gRPC or KCP")
+		}
+	}
+}
+
+func (c *checker) checkFiles() {
+	cfg := config.Config
+	if cfg.ChinaIpFile != "" {
+		if _, err := os.Stat(absPath(cfg.ChinaIpFile)); err != nil {
+			c.errorf("china_ip_file", "%v", err)
+		}
+	}
+	gfwFile := cfg.GFWListFile
+	if gfwFile == "" {
+		gfwFile = "gfwlist.txt"
+	}
+	if _, err := os.Stat(absPath(gfwFile)); err != nil {
+		c.warnf("gfw_list_file", "%s not found, it will be downloaded on startup", gfwFile)
+	}
+}
+
+func (c *checker) checkTun() {
+	tun := config.Config.Tun
+	if !tun.Enable {
+		return
+	}
+	if tun.Address != "" && net.ParseIP(tun.Address) == nil {
+		c.errorf("tun.address", "invalid IP %q", tun.Address)
+	}
+	if tun.Netmask != "" && net.ParseIP(tun.Netmask) == nil {
+		c.errorf("tun.netmask", "invalid netmask %q", tun.Netmask)
+	}
+	if tun.MTU != 0 && (tun.MTU < 576 || tun.MTU > 65535) {
+		c.errorf("tun.mtu", "must be between 576 and 65535, got %d", tun.MTU)
+	}
+	for i, dns := range tun.DNS {
+		if net.ParseIP(dns) == nil {
+			c.errorf(fmt.Sprintf("tun.dns[%d]", i), "invalid IP %q", dns)
+		}
+	}
+	switch tun.DNSPush {
+	case "", "off":
+	case "reassert", "warn":
+		if len(tun.DNS) == 0 {
+			c.warnf("tun.dns_push", "tun.dns is empty, system DNS is left unchanged")
+		}
+	default:
+		c.errorf("tun.dns_push", "must be off, reassert or warn, got %q", tun.DNSPush)
+	}
+	if tun.Uplink != "" {
+		if _, err := net.InterfaceByName(tun.Uplink); err != nil {
+			c.errorf("tun.uplink", "%v", err)
+		}
+	}
+	c.checkGateway()
+}
+
+func (c *checker) checkGateway() {
+	gw := config.Config.Tun.Gateway
+	if !gw.Enable {
+		return
+	}
+	if runtime.GOOS != "linux" {
+		c.errorf("tun.gateway.enable", "gateway mode is only supported on Linux")
+		return
+	}
+	if gw.LAN == "" {
+		c.errorf("tun.gateway.lan", "is required in gateway mode")
+	} else if _, err := net.InterfaceByName(gw.LAN); err != nil {
+		c.errorf("tun.gateway.lan", "%v", err)
+	} else if gw.LAN == config.Config.Tun.Uplink {
+		c.errorf("tun.gateway.lan", "must not be the uplink interface %s", gw.LAN)
+	}
+	if gw.DNS != "" && gw.DNS != "-" {
+		if _, _, err := net.SplitHostPort(gw.DNS); err != nil {
+			c.errorf("tun.gateway.dns", "%v", err)
+		}
+	}
+	if _, err := exec.LookPath("iptables"); err != nil {
+		c.errorf("tun.gateway.enable", "gateway mode needs iptables: %v", err)
+	}
+}
+
+func (c *checker) checkLimit() {
+	l := config.Config.Limit
+	c.checkRate("limit.upload", l.Upload)
+	c.checkRate("limit.download", l.Download)
+	for i, rule := range l.Rules {
+		field := fmt.Sprintf("limit.rules[%d]", i)
+		if len(rule.Match) == 0 {
+			c.errorf(field+".match", "is empty")
+		}
+		for _, m := range rule.Match {
+			if err := route.ValidateRule(m); err != nil {
+				c.errorf(field+".match", "%v", err)
+			}
+		}
+		c.checkRate(field+".upload", rule.Upload)
+		c.checkRate(field+".download", rule.Download)
+	}
+}
+
+func (c *checker) checkMemory() {
+	m := config.Config.Memory
+	limitBytes, err := helper.ParseBytes(m.Limit)
+	if err != nil {
+		c.errorf("memory.limit", "%v", err)
+	} else if limitBytes > 0 && limitBytes < 16<<20 {
+		c.warnf("memory.limit", "%s is too small for the Go runtime, GC will run almost continuously", m.Limit)
+	}
+	if m.GCPercent < -1 {
+		c.errorf("memory.gc_percent", "must be -1 or greater, got %d", m.GCPercent)
+	} else if m.GCPercent == -1 && limitBytes == 0 && os.Getenv("GOMEMLIMIT") == "" {
+		c.warnf("memory.gc_percent", "-1 turns GC off without memory.limit, memory will grow without bound")
+	}
+	ballast, err := helper.ParseBytes(m.Ballast)
+	if err != nil {
+		c.errorf("memory.ballast", "%v", err)
+	} else if ballast > 0 && limitBytes > 0 && ballast >= limitBytes/2 {
+		c.warnf("memory.ballast", "counts towards memory.limit, %s leaves little room below %s", m.Ballast, m.Limit)
+	}
+	if n, err := helper.ParseBytes(m.RelayBuffer); err != nil {
+		c.errorf("memory.relay_buffer", "%v", err)
+	} else if n != 0 && (n < 4<<10 || n > 64<<10) {
+		c.warnf("memory.relay_buffer", "%s is out of range, clamped to 4KB~64KB", m.RelayBuffer)
+	}
+	if m.DNSCache < 0 {
+		c.errorf("memory.dns_cache", "must not be negative, got %d", m.DNSCache)
+	}
+	if m.HighWater < 0 || m.HighWater > 100 {
+		c.errorf("memory.high_water", "must be between 1 and 100, got %d", m.HighWater)
+	}
+}
+
+func (c *checker) checkRate(field, value string) {
+	if _, err := limit.ParseRate(value); err != nil {
+		c.errorf(field, "%v", err)
+	}
+}
+
+func (c *checker) checkUsers() {
+	names := make(map[string]bool)
+	keys := map[string]string{config.Config.User: quota.DefaultUser}
+	for i, u := range config.Config.Users.List {
+		field := fmt.Sprintf("users.list[%d]", i)
+		switch {
+		case u.Name == "" || u.Name == quota.DefaultUser:
+			c.errorf(field+".name", "must not be empty or %q", quota.DefaultUser)
+		case names[u.Name]:
+			c.errorf(field+".name", "duplicate user %q", u.Name)
+		}
+		names[u.Name] = true
+		if len(u.Key) != 32 {
+			c.errorf(field+".key", "must be a 32-byte chacha20 key, got %d bytes", len(u.Key))
+		} else if other, ok := keys[u.Key]; ok {
+			c.errorf(field+".key", "same key as user %q", other)
+		}
+		keys[u.Key] = u.Name
+		if _, err := helper.ParseBytes(u.Quota); err != nil {
+			c.errorf(field+".quota", "%v", err)
+		}
+		c.checkRate(field+".over_quota_rate", u.OverQuotaRate)
+	}
+}
+
+func (c *checker) checkServices() {
+	cfg := config.Config
+	if cfg.Metrics.Listen != "" {
+		if _, _, err := net.SplitHostPort(cfg.Metrics.Listen); err != nil {
+			c.errorf("metrics.listen", "%v", err)
+		}
+	}
+	if cfg.Stats.Days < 0 {
+		c.errorf("stats.days", "must not be negative, got %d", cfg.Stats.Days)
+	}
+	if cfg.Stats.Domains < 0 {
+		c.errorf("stats.domains", "must not be negative, got %d", cfg.Stats.Domains)
+	}
+	if cfg.Admin.Listen != "" {
+		if err := admin.CheckListen(cfg.Admin.Listen, cfg.Admin.Token); err != nil {
+			c.errorf("admin.listen", "%v", err)
+		}
+	}
+	for i, hook := range cfg.Admin.Webhooks {
+		field := fmt.Sprintf("admin.webhooks[%d]", i)
+		if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			c.errorf(field+".url", "must be an http(s) URL, got %q", hook.URL)
+		}
+		for _, event := range hook.Events {
+			switch event {
+			case hooks.EventConnect, hooks.EventRoute, hooks.EventClose, hooks.EventNewDevice:
+			default:
+				c.errorf(field+".events", "unknown event %q, must be connect, route, close or new_device", event)
+			}
+		}
+	}
+	if cfg.Tracing.Endpoint != "" {
+		if u, err := url.Parse(cfg.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			c.errorf("tracing.endpoint", "must be an http(s) URL, got %q", cfg.Tracing.Endpoint)
+		}
+	}
+}
+
+func (c *checker) checkLog() {
+	l := config.Config.Log
+	if l.Level != "" {
+		if _, err := logrus.ParseLevel(l.Level); err != nil {
+			c.errorf("log.level", "%v", err)
+		}
+	}
+	if _, err := helper.ParseBytes(l.MaxSize); err != nil {
+		c.errorf("log.max_size", "%v", err)
+	}
+	if _, err := helper.ParseBytes(l.MaxTotalSize); err != nil {
+		c.errorf("log.max_total_size", "%v", err)
+	}
+	switch l.Sink {
+	case "", "syslog", "journald", "eventlog":
+	default:
+		c.errorf("log.sink", "must be syslog, journald or eventlog, got %q", l.Sink)
+	}
+}
+
+// absPath 相对路径按当前工作目录解析，与 route 加载规则文件一致
+func absPath(file string) string {
+	if p, err := filepath.Abs(file); err == nil {
+		return p
+	}
+	return file
+}
@@ -0,0 +1,234 @@
+package diagnose
+
+import (
+	context2 "context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/doh"
+	"proxy/server/proxy/client"
+	"proxy/server/route"
+)
+
+// selfTestTimeout 单项检查的超时
+const selfTestTimeout = 10 * time.Second
+
+var (
+	// ipEchoURLs 返回访问者 IP（纯文本）的服务，依次尝试
+	ipEchoURLs = []string{"https://api.ipify.org", "https://ifconfig.me/ip", "https://icanhazip.com"}
+	// resolverEchoDomain A 记录为查询它的递归解析器出口 IP
+	resolverEchoDomain = "whoami.akamai.net"
+	// selfTestBlockedDomains 通常需要经代理访问的 GFWList 域名
+	selfTestBlockedDomains = []string{"www.google.com", "www.youtube.com", "twitter.com"}
+)
+
+// 检查结果
+const (
+	StatusPass = "pass"
+	StatusFail = "fail"
+	StatusSkip = "skip"
+)
+
+// SelfTestReport 连通性与泄露自检报告
+type SelfTestReport struct {
+	Time    string          `json:"time"`
+	Config  TraceConfig     `json:"config"`
+	Checks  []SelfTestCheck `json:"checks"`
+	Passed  int             `json:"passed"`
+	Failed  int             `json:"failed"`
+	Elapsed float64         `json:"elapsed_ms"`
+}
+
+// SelfTestCheck 单项检查
+type SelfTestCheck struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Detail   string  `json:"detail"`
+	Duration float64 `json:"duration_ms"`
+}
+
+// SelfTest 执行自检并输出简明的文本报告，全部通过时返回 true
+func SelfTest(ctx context2.Context, w io.Writer) bool {
+	report := BuildSelfTest(ctx)
+	fmt.Fprintf(w, "time: %s  out.type: %d  remote: %s  tun: %v\n",
+		report.Time, report.Config.OutType, report.Config.RemoteAddr, report.Config.Tun)
+	for _, c := range report.Checks {
+		fmt.Fprintf(w, "[%s] %-28s %s (%.0fms)\n", strings.ToUpper(c.Status), c.Name, c.Detail, c.Duration)
+	}
+	fmt.Fprintf(w, "%d passed, %d failed\n", report.Passed, report.Failed)
+	return report.Failed == 0
+}
+
+// BuildSelfTest 依次检查直连与代理的出口 IP、系统解析器与 DoH 背后的递归解析器，以及 GFWList 域名能否访问
+func BuildSelfTest(ctx context2.Context) *SelfTestReport {
+	begin := time.Now()
+	report := &SelfTestReport{
+		Time: begin.In(config.CstZone).Format(config.TimeFormat),
+		Config: TraceConfig{
+			OutType:    config.OutType(),
+			RemoteAddr: config.Config.Out.RemoteAddr,
+			ECSSubnet:  route.ECSSubnet(),
+			Tun:        config.TunEnabled(),
+		},
+	}
+	add := func(c SelfTestCheck) {
+		switch c.Status {
+		case StatusPass:
+			report.Passed++
+		case StatusFail:
+			report.Failed++
+		}
+		report.Checks = append(report.Checks, c)
+	}
+
+	directIP := ""
+	add(timed("direct egress IP", func() (string, string) {
+		ip, err := egressIP(ctx, &client.DirectRemote{})
+		if err != nil {
+			return StatusFail, err.Error()
+		}
+		directIP = ip
+		return StatusPass, ipDetail(ctx, ip)
+	}))
+	add(timed("proxied egress IP", func() (string, string) {
+		if config.OutType() == config.RemoteTypeDirect {
+			return StatusSkip, "out.type is 3 (Direct)"
+		}
+		remote := route.ProxyRemote()
+		ip, err := egressIP(ctx, remote)
+		if err != nil {
+			return StatusFail, remote.Name() + ": " + err.Error()
+		}
+		if ip == directIP {
+			return StatusFail, ip + " is the same as the direct egress IP, proxied traffic is leaking"
+		}
+		return StatusPass, ipDetail(ctx, ip)
+	}))
+	add(timed("DNS resolver (system)", func() (string, string) {
+		c, cancel := context2.WithTimeout(context2.Background(), selfTestTimeout)
+		defer cancel()
+		ips, err := net.DefaultResolver.LookupIP(c, "ip4", resolverEchoDomain)
+		return resolverResult(ctx, ips, err)
+	}))
+	add(timed("DNS resolver (DoH)", func() (string, string) {
+		c, cancel := context2.WithTimeout(context2.Background(), selfTestTimeout)
+		defer cancel()
+		ips, err := doh.New().Resolve(c, doh.Domain(resolverEchoDomain), doh.ECS(route.ECSSubnet()), doh.TypeA)
+		return resolverResult(ctx, ips, err)
+	}))
+	for _, domain := range selfTestBlockedDomains {
+		add(timed(domain, func() (string, string) {
+			target, _ := common.NewTargetAddr(net.JoinHostPort(domain, "443"))
+			target.Proto = 1
+			decision := route.Decide(ctx, target)
+			path := tracePath(ctx, decision.Remote, target)
+			detail := fmt.Sprintf("%s via %s", decision.Reason, path.Remote)
+			if path.Error != "" {
+				return StatusFail, detail + ": " + path.Error
+			}
+			return StatusPass, fmt.Sprintf("%s, tls %.0fms", detail, path.TLS)
+		}))
+	}
+	report.Elapsed = milliseconds(time.Since(begin))
+	return report
+}
+
+// timed 执行一项检查并记录耗时
+func timed(name string, check func() (status, detail string)) SelfTestCheck {
+	begin := time.Now()
+	status, detail := check()
+	return SelfTestCheck{Name: name, Status: status, Detail: detail, Duration: milliseconds(time.Since(begin))}
+}
+
+// ipDetail IP 及其是否为中国 IP
+func ipDetail(ctx context2.Context, ip string) string {
+	if route.IsCnIp(ctx, ip) {
+		return ip + " (CN)"
+	}
+	return ip + " (non-CN)"
+}
+
+// resolverResult 递归解析器出口 IP 的检查结果，解析器不可用时失败
+func resolverResult(ctx context2.Context, ips []net.IP, err error) (string, string) {
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	if len(ips) == 0 {
+		return StatusFail, "no answer for " + resolverEchoDomain
+	}
+	details := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		details = append(details, ipDetail(ctx, ip.String()))
+	}
+	return StatusPass, strings.Join(details, ", ")
+}
+
+// egressIP 经 remote 访问 ipEchoURLs，返回第一个成功的结果
+func egressIP(ctx context2.Context, remote common.Remote) (string, error) {
+	var err error
+	for _, u := range ipEchoURLs {
+		var body string
+		if body, err = httpGet(ctx, remote, u); err != nil {
+			continue
+		}
+		if ip := net.ParseIP(body); ip != nil {
+			return ip.String(), nil
+		}
+		err = fmt.Errorf("%s returned %q", u, body)
+	}
+	return "", err
+}
+
+// httpGet 经 remote 建立连接请求 rawURL，返回去掉首尾空白的响应体（最多 256 字节）
+func httpGet(ctx context2.Context, remote common.Remote, rawURL string) (string, error) {
+	httpClient := remoteHTTPClient(ctx, remote)
+	httpClient.Timeout = selfTestTimeout
+	resp, err := httpClient.Get(rawURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", rawURL, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// remoteHTTPClient 经 remote 建立每个连接的 HTTP 客户端，不复用连接
+func remoteHTTPClient(ctx context2.Context, remote common.Remote) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: nil,
+			DialContext: func(_ context2.Context, network, addr string) (net.Conn, error) {
+				target, err := common.NewTargetAddr(addr)
+				if err != nil {
+					return nil, err
+				}
+				target.Proto = 1
+				rw, err := remote.Handshake(ctx, target)
+				if err != nil {
+					return nil, err
+				}
+				if rw == nil {
+					return nil, errors.New("remote returned no connection")
+				}
+				if conn, ok := rw.(net.Conn); ok {
+					return conn, nil
+				}
+				return &streamConn{ReadWriter: rw}, nil
+			},
+			DisableKeepAlives: true,
+		},
+	}
+}
@@ -0,0 +1,266 @@
+package diagnose
+
+import (
+	context2 "context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/doh"
+	"proxy/server/proxy/client"
+	"proxy/server/route"
+	"proxy/utils/context"
+)
+
+// TraceReport 单个目标的完整分流诊断报告，可直接提交给支持人员
+type TraceReport struct {
+	Target   string      `json:"target"`
+	Time     string      `json:"time"`
+	TraceID  string      `json:"trace_id"`
+	Config   TraceConfig `json:"config"`
+	Rules    TraceRules  `json:"rules"`
+	DNS      []DNSResult `json:"dns"`
+	Decision TraceResult `json:"decision"`
+	Outbound *PathResult `json:"outbound"`
+	Direct   *PathResult `json:"direct,omitempty"`
+	Elapsed  float64     `json:"elapsed_ms"`
+}
+
+// TraceConfig 与分流相关的配置摘要（不含密钥）
+type TraceConfig struct {
+	OutType    int8   `json:"out_type"`
+	RemoteAddr string `json:"remote_addr"`
+	ECSSubnet  string `json:"ecs_subnet"`
+	Tun        bool   `json:"tun"`
+}
+
+// TraceRules 规则匹配情况
+type TraceRules struct {
+	White    string `json:"white,omitempty"` // 命中的白名单规则
+	Black    string `json:"black,omitempty"` // 命中的黑名单规则
+	GFW      bool   `json:"gfw"`             // 是否命中 GFWList
+	CnDomain bool   `json:"cn_domain"`       // 是否为 .cn 域名
+}
+
+// DNSResult 单个解析策略的结果
+type DNSResult struct {
+	Strategy string   `json:"strategy"`
+	Answers  []string `json:"answers"`
+	CnIP     []bool   `json:"cn_ip"`
+	Duration float64  `json:"duration_ms"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// TraceResult 最终路由决策
+type TraceResult struct {
+	Remote  string `json:"remote"`
+	Reason  string `json:"reason"`
+	Rule    string `json:"rule,omitempty"`
+	IP      string `json:"ip,omitempty"`
+	Hosts   string `json:"hosts,omitempty"`
+	Rewrite string `json:"rewrite,omitempty"`
+	Resolve string `json:"resolve,omitempty"`
+}
+
+// PathResult 经某个出口建立连接的耗时
+type PathResult struct {
+	Remote  string  `json:"remote"`
+	Connect float64 `json:"connect_ms"`
+	TLS     float64 `json:"tls_ms,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// Trace 对目标执行完整的分流流程并输出 JSON 报告
+// addr 格式为 host 或 host:port，未指定端口时默认 443
+func Trace(ctx context2.Context, addr string, w io.Writer) error {
+	report, err := BuildTrace(ctx, addr)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(report)
+}
+
+// BuildTrace 生成诊断报告
+func BuildTrace(ctx context2.Context, addr string) (*TraceReport, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "443")
+	}
+	target, err := common.NewTargetAddr(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target %s: %w", addr, err)
+	}
+	target.Proto = 1
+
+	begin := time.Now()
+	report := &TraceReport{
+		Target:  target.String(),
+		Time:    begin.In(config.CstZone).Format(config.TimeFormat),
+		TraceID: context.TraceID(ctx),
+		Config: TraceConfig{
+			OutType:    config.OutType(),
+			RemoteAddr: config.Config.Out.RemoteAddr,
+			ECSSubnet:  route.ECSSubnet(),
+			Tun:        config.TunEnabled(),
+		},
+	}
+
+	// 规则匹配
+	engine := route.GetRuleEngine()
+	if rule := engine.MatchWhite(target.String(), target.IP); rule != nil {
+		report.Rules.White = rule.String()
+	}
+	if rule := engine.MatchBlack(target.String(), target.IP); rule != nil {
+		report.Rules.Black = rule.String()
+	}
+	report.Rules.GFW = route.IsBlockedByGFW(target)
+	report.Rules.CnDomain = target.IP == nil && strings.HasSuffix(target.Name, ".cn")
+
+	// 各解析策略的结果
+	if target.IP == nil {
+		report.DNS = traceDNS(ctx, target.Name)
+	}
+
+	// 路由决策
+	decision := route.Decide(ctx, target)
+	report.Decision = TraceResult{
+		Remote:  decision.Remote.Name(),
+		Reason:  decision.Reason,
+		Rule:    decision.Rule,
+		Hosts:   decision.Hosts,
+		Rewrite: decision.Rewrite,
+		Resolve: decision.Resolve,
+	}
+	if decision.IP != nil {
+		report.Decision.IP = decision.IP.String()
+	}
+
+	// 经选中出口及直连分别建连对比
+	report.Outbound = tracePath(ctx, decision.Remote, target)
+	if !decision.Direct() {
+		report.Direct = tracePath(ctx, &client.DirectRemote{}, target)
+	}
+
+	report.Elapsed = milliseconds(time.Since(begin))
+	return report, nil
+}
+
+// traceDNS 分别使用带 ECS 的 DoH、不带 ECS 的 DoH 以及系统解析器解析域名
+func traceDNS(ctx context2.Context, name string) []DNSResult {
+	results := make([]DNSResult, 0, 3)
+	for _, ecs := range []string{route.ECSSubnet(), ""} {
+		strategy := "doh"
+		if ecs != "" {
+			strategy = "doh_ecs:" + ecs
+		}
+		result := DNSResult{Strategy: strategy}
+		ctxCancel, cancel := context2.WithTimeout(context2.Background(), config.DialTimeout())
+		begin := time.Now()
+		ips, err := doh.New().Resolve(ctxCancel, doh.Domain(name), doh.ECS(ecs), route.QueryTypes()...)
+		result.Duration = milliseconds(time.Since(begin))
+		cancel()
+		if err != nil {
+			result.Error = err.Error()
+		}
+		for _, ip := range ips {
+			result.Answers = append(result.Answers, ip.String())
+		}
+		results = append(results, withCnFlags(ctx, result))
+	}
+
+	result := DNSResult{Strategy: "system"}
+	ctxCancel, cancel := context2.WithTimeout(context2.Background(), config.DialTimeout())
+	defer cancel()
+	begin := time.Now()
+	network := "ip"
+	if config.IPv4Only() {
+		network = "ip4"
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctxCancel, network, name)
+	result.Duration = milliseconds(time.Since(begin))
+	if err != nil {
+		result.Error = err.Error()
+	}
+	for _, ip := range ips {
+		result.Answers = append(result.Answers, ip.String())
+	}
+	return append(results, withCnFlags(ctx, result))
+}
+
+// withCnFlags 标记每个解析结果是否为中国 IP
+func withCnFlags(ctx context2.Context, result DNSResult) DNSResult {
+	for _, answer := range result.Answers {
+		result.CnIP = append(result.CnIP, route.IsCnIp(ctx, answer))
+	}
+	return result
+}
+
+// tracePath 通过指定出口连接目标，443 端口额外测量 TLS 握手耗时
+func tracePath(ctx context2.Context, remote common.Remote, target *common.TargetAddr) *PathResult {
+	result := &PathResult{Remote: remote.Name()}
+	begin := time.Now()
+	rConn, err := remote.Handshake(ctx, target)
+	result.Connect = milliseconds(time.Since(begin))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer func() {
+		if closer, ok := rConn.(io.Closer); ok {
+			_ = closer.Close()
+		}
+	}()
+	if rConn == nil {
+		result.Error = "remote returned no connection"
+		return result
+	}
+	if target.Port != 443 {
+		return result
+	}
+
+	conn, ok := rConn.(net.Conn)
+	if !ok {
+		conn = &streamConn{ReadWriter: rConn}
+	}
+	cc := tls.Client(conn, &tls.Config{ServerName: target.Host()})
+	ctxCancel, cancel := context2.WithTimeout(context2.Background(), 10*time.Second)
+	defer cancel()
+	begin = time.Now()
+	err = cc.HandshakeContext(ctxCancel)
+	result.TLS = milliseconds(time.Since(begin))
+	if err != nil {
+		result.Error = "tls: " + err.Error()
+	}
+	return result
+}
+
+func milliseconds(d time.Duration) float64 {
+	return math.Round(float64(d.Microseconds())/10) / 100
+}
+
+// streamConn 将加密流适配为 net.Conn，供 tls.Client 使用
+type streamConn struct {
+	io.ReadWriter
+}
+
+func (c *streamConn) Close() error {
+	if closer, ok := c.ReadWriter.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (c *streamConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *streamConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *streamConn) SetDeadline(t time.Time) error      { return nil }
+func (c *streamConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *streamConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -13,12 +13,15 @@ import (
 	"time"
 
 	"github.com/likexian/gokit/xip"
+	"golang.org/x/sync/singleflight"
 	"proxy/server/common"
+	"proxy/server/metrics"
 )
 
 type AliyunProvider struct {
 	provides int
 	client   *http.Client
+	group    singleflight.Group // 合并相同 (name, type, ecs) 的并发查询
 }
 
 const (
@@ -52,10 +55,10 @@ func New() *AliyunProvider {
 // createHTTPClient 创建绑定到原接口的 HTTP 客户端
 // 只创建一次，复用连接池
 func createHTTPClient() *http.Client {
-	dialer := common.GetOriginalInterfaceDialer()
 	transport := &http.Transport{
+		// 每次拨号重新获取，TUN 启动后设置的源地址绑定或 SO_MARK 才会生效
 		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			return dialer.DialContext(ctx, network, addr)
+			return common.GetOriginalInterfaceDialer().DialContext(ctx, network, addr)
 		},
 		Proxy:                 nil, // 不使用代理
 		MaxIdleConns:          100,
@@ -65,9 +68,9 @@ func createHTTPClient() *http.Client {
 		ExpectContinueTimeout: 1 * time.Second,
 		ForceAttemptHTTP2:     true,
 	}
+	// 查询超时由调用方的 ctx 控制（timeouts.dial）
 	return &http.Client{
 		Transport: transport,
-		Timeout:   10 * time.Second,
 	}
 }
 
@@ -99,10 +102,30 @@ func (c *AliyunProvider) ECSQuery(ctx context.Context, d Domain, t Type, s ECS)
 
 	// 检查缓存
 	cache := GetCache()
-	if cached, ok := cache.Get(cacheKey); ok {
+	cached, ok := cache.Get(cacheKey)
+	metrics.DNSCacheResult("doh", ok)
+	if ok {
 		return cached, nil
 	}
 
+	// 相同查询在途时复用同一个请求，调用方各自遵守自己的 ctx
+	// 实际请求不随首个调用方取消，由 HTTP 客户端超时兜底
+	ch := c.group.DoChan(cacheKey, func() (interface{}, error) {
+		rr, err := c.query(context.WithoutCancel(ctx), name, t, s, cacheKey)
+		observe(rr, err)
+		return rr, err
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		rr, _ := res.Val.(*Response)
+		return rr, res.Err
+	}
+}
+
+// query 向上游发送 DoH 请求并写入缓存
+func (c *AliyunProvider) query(ctx context.Context, name string, t Type, s ECS, cacheKey string) (*Response, error) {
 	// 构建请求参数
 	params := url.Values{}
 	params.Set("name", name)
@@ -157,7 +180,7 @@ func (c *AliyunProvider) ECSQuery(ctx context.Context, d Domain, t Type, s ECS)
 	if len(rr.Answer) > 0 && rr.Answer[0].TTL > 0 {
 		ttl = time.Duration(rr.Answer[0].TTL) * time.Second
 	}
-	cache.Set(cacheKey, rr, ttl)
+	GetCache().Set(cacheKey, rr, ttl)
 
 	return rr, nil
 }
@@ -3,6 +3,8 @@ package doh
 import (
 	"sync"
 	"time"
+
+	"proxy/config"
 )
 
 // DNSCache DNS 缓存
@@ -64,12 +66,27 @@ func (c *DNSCache) Set(key string, resp *Response, ttl time.Duration) {
 		ttl = time.Hour
 	}
 
+	if max := config.DNSCacheSize(); max > 0 && len(c.entries) >= max {
+		if _, exists := c.entries[key]; !exists {
+			// 一次腾出一成空间，避免写满后每次写入都遍历全部条目
+			c.evictLocked(max * 9 / 10)
+		}
+	}
+
 	c.entries[key] = &cacheEntry{
 		response:  resp,
 		expiresAt: time.Now().Add(ttl),
 	}
 }
 
+// Add 缓存中没有 key 或已过期时写入，已有的结果不被覆盖
+func (c *DNSCache) Add(key string, resp *Response, ttl time.Duration) {
+	if _, ok := c.Get(key); ok {
+		return
+	}
+	c.Set(key, resp, ttl)
+}
+
 // cleanupLoop 定期清理过期条目
 func (c *DNSCache) cleanupLoop() {
 	ticker := time.NewTicker(5 * time.Minute)
@@ -93,6 +110,22 @@ func (c *DNSCache) cleanup() {
 	}
 }
 
+// evictLocked 条目数超过 keep 时先清理过期条目，仍超出时随机淘汰，调用方需持有写锁
+func (c *DNSCache) evictLocked(keep int) {
+	now := time.Now()
+	for key, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+	for key := range c.entries {
+		if len(c.entries) <= keep {
+			break
+		}
+		delete(c.entries, key)
+	}
+}
+
 // Size 返回缓存大小
 func (c *DNSCache) Size() int {
 	c.mu.RLock()
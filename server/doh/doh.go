@@ -58,6 +58,13 @@ var (
 	TypeANY   = Type("ANY")
 )
 
+// Answer record types
+const (
+	RRTypeA     = 1
+	RRTypeCNAME = 5
+	RRTypeAAAA  = 28
+)
+
 // Punycode returns punycode of domain
 func (d Domain) Punycode() (string, error) {
 	name := strings.TrimSpace(string(d))
@@ -0,0 +1,88 @@
+package doh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Resolve 依次按给定的记录类型（A / AAAA）并发查询，按 types 的顺序合并结果
+// 只要有一种类型查询成功即返回结果，全部失败时返回第一个错误
+func (c *AliyunProvider) Resolve(ctx context.Context, d Domain, s ECS, types ...Type) ([]net.IP, error) {
+	results := make([][]net.IP, len(types))
+	errs := make([]error, len(types))
+	var wg sync.WaitGroup
+	for i, t := range types {
+		wg.Add(1)
+		go func(i int, t Type) {
+			defer wg.Done()
+			rsp, err := c.ECSQuery(ctx, d, t, s)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			results[i] = AnswerIPs(rsp, t)
+		}(i, t)
+	}
+	wg.Wait()
+
+	var ips []net.IP
+	var firstErr error
+	succeeded := false
+	for i := range types {
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			continue
+		}
+		succeeded = true
+		ips = append(ips, results[i]...)
+	}
+	if !succeeded {
+		return nil, firstErr
+	}
+	return ips, nil
+}
+
+// AnswerIPs 提取响应中与查询类型匹配的 A/AAAA 记录
+func AnswerIPs(rsp *Response, t Type) []net.IP {
+	var rrType int
+	switch t {
+	case TypeA:
+		rrType = RRTypeA
+	case TypeAAAA:
+		rrType = RRTypeAAAA
+	default:
+		return nil
+	}
+	var ips []net.IP
+	for _, answer := range rsp.Answer {
+		if answer.Type != rrType {
+			continue
+		}
+		if ip := net.ParseIP(answer.Data); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// Warm 用在别处得到的解析结果（如服务端回送的连接地址）预热缓存，缓存中已有结果时不覆盖
+func Warm(d Domain, s ECS, ip net.IP, ttl time.Duration) {
+	name, err := d.Punycode()
+	if err != nil || ip == nil {
+		return
+	}
+	t, rrType := TypeA, RRTypeA
+	if ip.To4() == nil {
+		t, rrType = TypeAAAA, RRTypeAAAA
+	}
+	GetCache().Add(fmt.Sprintf("%s:%s:%s", name, string(t), string(s)), &Response{
+		Question: Question{Name: name, Type: rrType},
+		Answer:   []Answer{{Name: name, Type: rrType, TTL: int(ttl.Seconds()), Data: ip.String()}},
+		Provider: "feedback",
+	}, ttl)
+}
@@ -0,0 +1,34 @@
+package doh
+
+import (
+	"sync"
+	"time"
+)
+
+// status 最近一次向上游查询的结果，供就绪检查判断 DoH 是否可用
+var status struct {
+	mu      sync.Mutex
+	success time.Time
+	failure time.Time
+	err     string
+}
+
+// observe 记录一次上游查询：收到上游的应答（含 NXDOMAIN 等失败的响应码）即视为 DoH 可用
+func observe(rr *Response, err error) {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	if rr != nil {
+		status.success = time.Now()
+		return
+	}
+	if err != nil {
+		status.failure, status.err = time.Now(), err.Error()
+	}
+}
+
+// Status 最近一次成功与失败的上游查询时间及失败原因，没有发生过时为零值
+func Status() (success, failure time.Time, lastErr string) {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	return status.success, status.failure, status.err
+}
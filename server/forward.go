@@ -0,0 +1,85 @@
+package server
+
+import (
+	context2 "context"
+	"net"
+	"sync"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/crash"
+	"proxy/server/proxy/server"
+	"proxy/server/upgrade"
+	"proxy/utils/logger"
+)
+
+var (
+	forwardMu sync.Mutex
+	forwards  = map[string]*forwardListener{} // 监听地址 -> 运行中的转发
+)
+
+// forwardListener 一条端口转发规则的监听
+type forwardListener struct {
+	l      net.Listener
+	stop   context2.CancelFunc
+	target string
+	via    int8
+}
+
+// applyForwards 按 forward 配置开启、重启或关闭各条转发的监听，未变化的规则保持不变；
+// 某条规则监听失败时记录错误并继续处理其余规则
+func applyForwards(ctx context2.Context) {
+	forwardMu.Lock()
+	defer forwardMu.Unlock()
+	want := make(map[string]bool, len(config.Config.Forward))
+	for _, rule := range config.Config.Forward {
+		want[rule.Listen] = true
+		if f, ok := forwards[rule.Listen]; ok {
+			if f.target == rule.Target && f.via == rule.Via {
+				continue
+			}
+			f.close()
+			delete(forwards, rule.Listen)
+		}
+		l, err := upgrade.Listen(rule.Listen)
+		if err != nil {
+			logger.Errorf(ctx, map[string]interface{}{
+				"action":    config.ActionSocketOperate,
+				"errorCode": logger.ErrCodeListen,
+				"error":     err,
+			}, "can not listen on %v: %v", rule.Listen, err)
+			continue
+		}
+		lctx, stop := context2.WithCancel(listenBase)
+		forwards[rule.Listen] = &forwardListener{l: l, stop: stop, target: rule.Target, via: rule.Via}
+		s := &server.ForwardServer{Target: rule.Target, Via: rule.Via}
+		crash.Go(func() { s.Start(lctx, common.TuneListener(l)) })
+		logger.Info(ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"listen": rule.Listen,
+			"target": rule.Target,
+			"via":    rule.Via,
+		}, "port forward started")
+	}
+	for addr, f := range forwards {
+		if !want[addr] {
+			f.close()
+			delete(forwards, addr)
+		}
+	}
+}
+
+// stopForwards 关闭全部转发的监听，已建立的连接不受影响
+func stopForwards() {
+	forwardMu.Lock()
+	defer forwardMu.Unlock()
+	for addr, f := range forwards {
+		f.close()
+		delete(forwards, addr)
+	}
+}
+
+func (f *forwardListener) close() {
+	f.stop()
+	_ = f.l.Close()
+}
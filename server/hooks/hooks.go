@@ -0,0 +1,111 @@
+// Package hooks 连接生命周期事件：连接建立、路由决策与连接结束时依次调用注册的回调。
+// 编译进本程序的插件在 init 中调用 OnConnect / OnRouteDecision / OnClose 注册即可；
+// admin.webhooks 配置的地址由 StartWebhooks 注册的回调推送
+package hooks
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"proxy/config"
+	"proxy/utils/context"
+	"proxy/utils/logger"
+)
+
+// ConnEvent 连接建立或结束，结束时带上流量与时长
+type ConnEvent struct {
+	ID       uint64        // 与管理接口 /connections 中的 id 相同
+	Inbound  string        // 入口名称，如 SocketServer
+	Source   string        // 客户端地址
+	Target   string        // 目标地址
+	Remote   string        // 出口名称
+	Start    time.Time     // 连接建立时间
+	Up       int64         // 客户端发往远端的字节数，仅结束时有值
+	Down     int64         // 远端发回客户端的字节数，仅结束时有值
+	Duration time.Duration // 连接时长，仅结束时有值
+}
+
+// RouteEvent 一次路由决策，字段含义同 route.Decision
+type RouteEvent struct {
+	Target  string
+	Remote  string
+	Reason  string
+	Rule    string
+	IP      string
+	Hosts   string
+	Rewrite string
+}
+
+var (
+	mu        sync.RWMutex
+	onConnect []func(ConnEvent)
+	onRoute   []func(RouteEvent)
+	onClose   []func(ConnEvent)
+)
+
+// OnConnect 注册连接建立（出口握手成功）时的回调。回调在转发连接的协程中同步执行，耗时的操作需自行异步处理
+func OnConnect(fn func(ConnEvent)) {
+	mu.Lock()
+	defer mu.Unlock()
+	onConnect = append(onConnect, fn)
+}
+
+// OnRouteDecision 注册路由决策后的回调，要求同 OnConnect
+func OnRouteDecision(fn func(RouteEvent)) {
+	mu.Lock()
+	defer mu.Unlock()
+	onRoute = append(onRoute, fn)
+}
+
+// OnClose 注册连接结束时的回调，事件带有流量统计，要求同 OnConnect
+func OnClose(fn func(ConnEvent)) {
+	mu.Lock()
+	defer mu.Unlock()
+	onClose = append(onClose, fn)
+}
+
+// Connect 通知连接建立，由 conntrack 登记连接时调用
+func Connect(e ConnEvent) {
+	mu.RLock()
+	list := onConnect
+	mu.RUnlock()
+	for _, fn := range list {
+		call("connect", func() { fn(e) })
+	}
+}
+
+// RouteDecision 通知路由决策，由 route.Decide 调用
+func RouteDecision(e RouteEvent) {
+	mu.RLock()
+	list := onRoute
+	mu.RUnlock()
+	for _, fn := range list {
+		call("route", func() { fn(e) })
+	}
+}
+
+// Close 通知连接结束，由 conntrack 注销连接时调用
+func Close(e ConnEvent) {
+	mu.RLock()
+	list := onClose
+	mu.RUnlock()
+	for _, fn := range list {
+		call("close", func() { fn(e) })
+	}
+}
+
+// call 执行回调，回调 panic 时记录日志，不影响连接与其他回调
+func call(event string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.ErrorAggregated(context.NewContext(), "hooks:"+event, map[string]interface{}{
+				"action":    config.ActionRuntime,
+				"errorCode": logger.ErrCodeDefault,
+				"error":     fmt.Sprint(r),
+				"event":     event,
+			}, "hook panicked")
+		}
+	}()
+	fn()
+}
@@ -0,0 +1,219 @@
+package hooks
+
+import (
+	"bytes"
+	context2 "context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/utils/logger"
+)
+
+// webhook 可订阅的事件
+const (
+	EventConnect   = "connect"
+	EventRoute     = "route"
+	EventClose     = "close"
+	EventNewDevice = "new_device" // 某个客户端 IP 自启动以来首次建立连接
+)
+
+const (
+	webhookQueueSize = 1024
+	webhookTimeout   = 10 * time.Second
+	// maxDevices 记录的客户端 IP 数量上限，超出时清空重新记录
+	maxDevices = 4096
+)
+
+// webhookPayload 推送的请求体
+type webhookPayload struct {
+	Event string      `json:"event"`
+	Time  string      `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+// connPayload ConnEvent 的 JSON 形式
+type connPayload struct {
+	ID       uint64  `json:"id"`
+	Inbound  string  `json:"inbound"`
+	Source   string  `json:"source"`
+	Target   string  `json:"target"`
+	Remote   string  `json:"remote"`
+	Start    string  `json:"start"`
+	Up       int64   `json:"up_bytes,omitempty"`
+	Down     int64   `json:"down_bytes,omitempty"`
+	Duration float64 `json:"duration_s,omitempty"`
+}
+
+// routePayload RouteEvent 的 JSON 形式
+type routePayload struct {
+	Target  string `json:"target"`
+	Remote  string `json:"remote"`
+	Reason  string `json:"reason"`
+	Rule    string `json:"rule,omitempty"`
+	IP      string `json:"ip,omitempty"`
+	Hosts   string `json:"hosts,omitempty"`
+	Rewrite string `json:"rewrite,omitempty"`
+}
+
+var (
+	queue = make(chan webhookPayload, webhookQueueSize)
+
+	devicesMu sync.Mutex
+	devices   = make(map[string]struct{})
+)
+
+// StartWebhooks 注册推送 admin.webhooks 的回调并启动推送协程；事件按订阅过滤，
+// 队列满时丢弃并记录日志，推送失败不重试
+func StartWebhooks(ctx context2.Context) {
+	OnConnect(func(e ConnEvent) {
+		if subscribed(EventNewDevice) && newDevice(e.Source) {
+			enqueue(ctx, EventNewDevice, newConnPayload(e))
+		}
+		if subscribed(EventConnect) {
+			enqueue(ctx, EventConnect, newConnPayload(e))
+		}
+	})
+	OnRouteDecision(func(e RouteEvent) {
+		if subscribed(EventRoute) {
+			enqueue(ctx, EventRoute, routePayload(e))
+		}
+	})
+	OnClose(func(e ConnEvent) {
+		if subscribed(EventClose) {
+			enqueue(ctx, EventClose, newConnPayload(e))
+		}
+	})
+	go deliver(ctx)
+}
+
+func newConnPayload(e ConnEvent) connPayload {
+	return connPayload{
+		ID:       e.ID,
+		Inbound:  e.Inbound,
+		Source:   e.Source,
+		Target:   e.Target,
+		Remote:   e.Remote,
+		Start:    e.Start.In(config.CstZone).Format(config.TimeFormat),
+		Up:       e.Up,
+		Down:     e.Down,
+		Duration: e.Duration.Truncate(time.Millisecond).Seconds(),
+	}
+}
+
+// Events 订阅的事件，未配置 events 时只推送 new_device
+func Events(events []string) []string {
+	if len(events) == 0 {
+		return []string{EventNewDevice}
+	}
+	return events
+}
+
+// subscribed 是否有 webhook 订阅了 event
+func subscribed(event string) bool {
+	for _, hook := range config.Config.Admin.Webhooks {
+		if contains(Events(hook.Events), event) {
+			return true
+		}
+	}
+	return false
+}
+
+// newDevice 客户端 IP 是否首次出现，本机地址不计
+func newDevice(source string) bool {
+	host, _, err := net.SplitHostPort(source)
+	if err != nil {
+		host = source
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsLoopback() {
+		return false
+	}
+	devicesMu.Lock()
+	defer devicesMu.Unlock()
+	if _, ok := devices[host]; ok {
+		return false
+	}
+	if len(devices) >= maxDevices {
+		devices = make(map[string]struct{})
+	}
+	devices[host] = struct{}{}
+	return true
+}
+
+func enqueue(ctx context2.Context, event string, data interface{}) {
+	select {
+	case queue <- webhookPayload{Event: event, Time: time.Now().In(config.CstZone).Format(config.TimeFormat), Data: data}:
+	default:
+		logger.WarnAggregated(ctx, "webhook:queue", map[string]interface{}{
+			"action": config.ActionRuntime,
+			"event":  event,
+		}, "webhook queue is full, event dropped")
+	}
+}
+
+func deliver(ctx context2.Context) {
+	client := &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			// 绑定原默认接口，避免推送请求走 TUN
+			DialContext: func(c context2.Context, network, addr string) (net.Conn, error) {
+				return common.GetOriginalInterfaceDialer().DialContext(c, network, addr)
+			},
+		},
+	}
+	for payload := range queue {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			continue
+		}
+		for _, hook := range config.Config.Admin.Webhooks {
+			if !contains(Events(hook.Events), payload.Event) {
+				continue
+			}
+			if err = post(client, hook.URL, hook.Headers, body); err != nil {
+				logger.ErrorAggregated(ctx, "webhook:"+hook.URL, map[string]interface{}{
+					"action":    config.ActionRuntime,
+					"errorCode": logger.ErrCodeDefault,
+					"error":     err,
+					"url":       hook.URL,
+					"event":     payload.Event,
+				}, "webhook delivery failed")
+			}
+		}
+	}
+}
+
+func post(client *http.Client, url string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "celestial-ladder")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rsp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", rsp.Status)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,276 @@
+// Package kcp 把 kcp-go 的 KCP 会话适配为代理使用的 net.Conn / net.Listener：
+// 每个 KCP 会话上经 smux 承载一条流，关闭时向对端发送 FIN，并按心跳发现断开的对端
+package kcp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	kcpgo "github.com/xtaci/kcp-go/v5"
+	"github.com/xtaci/smux"
+)
+
+const (
+	// keepaliveInterval 发送心跳的间隔
+	keepaliveInterval = 10 * time.Second
+	// deadTimeout 超过该时间没有收到对端任何帧时认为会话已断开
+	deadTimeout = 45 * time.Second
+	// lingerTimeout 关闭流后保留会话的时间，使 FIN 与未确认的数据有机会重传
+	lingerTimeout = 5 * time.Second
+	// maxPending 已建立 KCP 会话、尚未收到对端打开流的会话数上限，超过时直接断开新会话
+	maxPending = 128
+)
+
+// Options 会话参数，通信双方的 MTU 与 FEC 需一致
+type Options struct {
+	MTU      int           // 每个 UDP 包的载荷上限，默认 1350
+	SndWnd   int           // 发送窗口（包数），默认 256
+	RcvWnd   int           // 接收窗口（包数），默认 256
+	FEC      int           // 每组数据包数，每组后附加一个 Reed-Solomon 校验包，组内丢失一个包可直接恢复；0 关闭，最大 32
+	Interval time.Duration // 发送与重传的检查间隔，默认 10ms
+}
+
+func (o Options) withDefaults() Options {
+	if o.MTU <= 0 {
+		o.MTU = 1350
+	}
+	o.MTU = min(max(o.MTU, 576), 9000)
+	if o.SndWnd <= 0 {
+		o.SndWnd = 256
+	}
+	if o.RcvWnd <= 0 {
+		o.RcvWnd = 256
+	}
+	o.FEC = min(max(o.FEC, 0), 32)
+	if o.Interval <= 0 {
+		o.Interval = 10 * time.Millisecond
+	}
+	return o
+}
+
+// shards FEC 的数据包与校验包数，关闭时均为 0
+func (o Options) shards() (data, parity int) {
+	if o.FEC == 0 {
+		return 0, 0
+	}
+	return o.FEC, 1
+}
+
+// tune 按 opts 设置会话：流模式、不延迟发送、固定间隔快速重传、不做拥塞控制
+func (o Options) tune(s *kcpgo.UDPSession) {
+	s.SetStreamMode(true)
+	s.SetWriteDelay(false)
+	s.SetNoDelay(1, int(o.Interval/time.Millisecond), 2, 1)
+	s.SetWindowSize(o.SndWnd, o.RcvWnd)
+	s.SetMtu(o.MTU)
+	s.SetACKNoDelay(true)
+}
+
+func muxConfig() *smux.Config {
+	c := smux.DefaultConfig()
+	c.Version = 2
+	c.KeepAliveInterval = keepaliveInterval
+	c.KeepAliveTimeout = deadTimeout
+	return c
+}
+
+// Conn 一个 KCP 会话上的流，关闭流后在 lingerTimeout 内关闭会话。
+// 不直接嵌入 smux.Stream：它的 WriteTo 在对端关闭时返回 io.EOF，io.Copy 会当作错误
+type Conn struct {
+	stream *smux.Stream
+	sess   *smux.Session
+	once   sync.Once
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.stream.Read(b)
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	return c.stream.Write(b)
+}
+
+func (c *Conn) Close() error {
+	var err error
+	c.once.Do(func() {
+		err = c.stream.Close()
+		time.AfterFunc(lingerTimeout, func() {
+			_ = c.sess.Close()
+		})
+	})
+	return err
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.stream.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.stream.RemoteAddr()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.stream.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.stream.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.stream.SetWriteDeadline(t)
+}
+
+// Dial 在新的 UDP 套接字 pc 上与 raddr 建立会话，会话结束时关闭 pc。
+// KCP 没有握手，Dial 不等待对端应答，对端不可达时读写在 deadTimeout 后返回错误
+func Dial(pc net.PacketConn, raddr net.Addr, opts Options) (*Conn, error) {
+	opts = opts.withDefaults()
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	data, parity := opts.shards()
+	s, err := kcpgo.NewConn4(binary.LittleEndian.Uint32(b[:]), raddr, nil, data, parity, true, pc)
+	if err != nil {
+		_ = pc.Close()
+		return nil, err
+	}
+	opts.tune(s)
+	sess, err := smux.Client(s, muxConfig())
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	stream, err := sess.OpenStream()
+	if err != nil {
+		_ = sess.Close()
+		return nil, err
+	}
+	return &Conn{stream: stream, sess: sess}, nil
+}
+
+// Listener 在一个 UDP 端口上按客户端地址区分会话
+type Listener struct {
+	l        *kcpgo.Listener
+	pc       net.PacketConn
+	opts     Options
+	mu       sync.Mutex
+	sessions map[*smux.Session]struct{}
+	pending  chan struct{}
+	accept   chan *Conn
+	done     chan struct{}
+	once     sync.Once
+}
+
+// Listen 在 pc 上接受会话，Close 时关闭 pc，已建立的会话随之断开
+func Listen(pc net.PacketConn, opts Options) (*Listener, error) {
+	opts = opts.withDefaults()
+	data, parity := opts.shards()
+	kl, err := kcpgo.ServeConn(nil, data, parity, pc)
+	if err != nil {
+		return nil, err
+	}
+	l := &Listener{
+		l:        kl,
+		pc:       pc,
+		opts:     opts,
+		sessions: make(map[*smux.Session]struct{}),
+		pending:  make(chan struct{}, maxPending),
+		accept:   make(chan *Conn),
+		done:     make(chan struct{}),
+	}
+	go l.serve()
+	return l, nil
+}
+
+func (l *Listener) serve() {
+	defer l.Close()
+	for {
+		s, err := l.l.AcceptKCP()
+		if err != nil {
+			return
+		}
+		select {
+		case l.pending <- struct{}{}:
+		default:
+			// 等待打开流的会话过多，断开新会话
+			_ = s.Close()
+			continue
+		}
+		l.opts.tune(s)
+		go l.open(s)
+	}
+}
+
+// open 等待对端在会话上打开流，deadTimeout 内没有打开时断开会话
+func (l *Listener) open(s *kcpgo.UDPSession) {
+	sess, err := smux.Server(s, muxConfig())
+	if err != nil {
+		<-l.pending
+		_ = s.Close()
+		return
+	}
+	l.mu.Lock()
+	l.sessions[sess] = struct{}{}
+	l.mu.Unlock()
+	go func() {
+		<-sess.CloseChan()
+		l.remove(sess)
+	}()
+	timer := time.AfterFunc(deadTimeout, func() {
+		_ = sess.Close()
+	})
+	stream, err := sess.AcceptStream()
+	timer.Stop()
+	<-l.pending
+	if err != nil {
+		_ = sess.Close()
+		return
+	}
+	select {
+	case l.accept <- &Conn{stream: stream, sess: sess}:
+	case <-l.done:
+		_ = sess.Close()
+	}
+}
+
+func (l *Listener) remove(sess *smux.Session) {
+	l.mu.Lock()
+	delete(l.sessions, sess)
+	l.mu.Unlock()
+}
+
+// Accept 返回新建立的会话
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 关闭 UDP 端口并断开全部会话
+func (l *Listener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.done)
+		_ = l.l.Close()
+		err = l.pc.Close()
+		l.mu.Lock()
+		list := make([]*smux.Session, 0, len(l.sessions))
+		for sess := range l.sessions {
+			list = append(list, sess)
+		}
+		l.mu.Unlock()
+		for _, sess := range list {
+			_ = sess.Close()
+		}
+	})
+	return err
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
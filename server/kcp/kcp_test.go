@@ -0,0 +1,100 @@
+package kcp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lossyConn 按 loss 的比例随机丢弃发出的包
+type lossyConn struct {
+	net.PacketConn
+	loss int64 // 百分比
+}
+
+func (c *lossyConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if n, _ := rand.Int(rand.Reader, big.NewInt(100)); n.Int64() < c.loss {
+		return len(p), nil
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+func listenUDP(t *testing.T) net.PacketConn {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pc
+}
+
+// transfer 客户端发送 size 字节，服务端原样回送，校验回送的数据；客户端关闭后服务端读到 io.EOF
+func transfer(t *testing.T, opts Options, loss int64, size int) {
+	server := listenUDP(t)
+	l, err := Listen(&lossyConn{PacketConn: server, loss: loss}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	closed := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			closed <- err
+			return
+		}
+		defer conn.Close()
+		_, err = io.Copy(conn, conn)
+		closed <- err
+	}()
+
+	c, err := Dial(&lossyConn{PacketConn: listenUDP(t), loss: loss}, l.Addr(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]byte, size)
+	_, _ = rand.Read(want)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := c.Write(want); err != nil {
+			t.Error(err)
+		}
+	}()
+	_ = c.SetReadDeadline(time.Now().Add(30 * time.Second))
+	got := make([]byte, size)
+	n, err := io.ReadFull(c, got)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("read: %v (got %d of %d bytes)", err, n, size)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("echoed %d bytes, want %d identical bytes", n, size)
+	}
+	_ = c.Close()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("server copy: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not see the stream closed")
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	transfer(t, Options{}, 0, 1<<20)
+}
+
+func TestTransferLossy(t *testing.T) {
+	transfer(t, Options{}, 10, 256<<10)
+}
+
+func TestTransferFEC(t *testing.T) {
+	transfer(t, Options{FEC: 8}, 10, 256<<10)
+}
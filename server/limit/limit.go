@@ -0,0 +1,180 @@
+// Package limit 基于令牌桶的带宽限速，支持全局上下行限速与按规则限速
+package limit
+
+import (
+	context2 "context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"golang.org/x/time/rate"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/route"
+	"proxy/utils/context"
+	"proxy/utils/helper"
+	"proxy/utils/logger"
+)
+
+// limitRule 一条按规则限速配置，命中的连接共享同一对令牌桶
+type limitRule struct {
+	matchers []route.Rule
+	up       *rate.Limiter
+	down     *rate.Limiter
+}
+
+var (
+	mu sync.RWMutex
+	// 全局令牌桶只创建一次，重载时原地调整速率，已有连接立即生效
+	upload   = NewLimiter(0)
+	download = NewLimiter(0)
+	rules    []*limitRule
+)
+
+func init() {
+	config.RegisterLoadCallback(Load)
+	config.RegisterReloadCallback(Load)
+}
+
+// Load 按当前配置重建限速规则，配置错误的项按不限速处理并记录日志
+// 按规则限速只对重载之后新建的连接生效
+func Load() {
+	ctx := context.NewContext()
+	cfg := config.Config.Limit
+	setLimit(upload, parseRateOrLog(ctx, "limit.upload", cfg.Upload))
+	setLimit(download, parseRateOrLog(ctx, "limit.download", cfg.Download))
+
+	list := make([]*limitRule, 0, len(cfg.Rules))
+	for i, item := range cfg.Rules {
+		r := &limitRule{
+			up:   NewLimiter(parseRateOrLog(ctx, fmt.Sprintf("limit.rules[%d].upload", i), item.Upload)),
+			down: NewLimiter(parseRateOrLog(ctx, fmt.Sprintf("limit.rules[%d].download", i), item.Download)),
+		}
+		for _, m := range item.Match {
+			if matcher := route.ParseRule(m); matcher != nil {
+				r.matchers = append(r.matchers, matcher)
+			}
+		}
+		if len(r.matchers) > 0 {
+			list = append(list, r)
+		}
+	}
+	mu.Lock()
+	rules = list
+	mu.Unlock()
+}
+
+// Upload 包装客户端到远端方向的写入，受全局上行与命中规则的上行限速
+func Upload(w io.Writer, target *common.TargetAddr) io.Writer {
+	limiters := []*rate.Limiter{upload}
+	if r := match(target); r != nil {
+		limiters = append(limiters, r.up)
+	}
+	return NewWriter(w, limiters...)
+}
+
+// Download 包装远端到客户端方向的写入，受全局下行与命中规则的下行限速
+func Download(w io.Writer, target *common.TargetAddr) io.Writer {
+	limiters := []*rate.Limiter{download}
+	if r := match(target); r != nil {
+		limiters = append(limiters, r.down)
+	}
+	return NewWriter(w, limiters...)
+}
+
+// Unlimited 当前没有任何限速作用于 target，转发可以不经 Upload / Download 包装（如零拷贝转发）；
+// 这样转发的连接不受之后重载加上的全局限速影响
+func Unlimited(target *common.TargetAddr) bool {
+	return upload.Limit() == rate.Inf && download.Limit() == rate.Inf && match(target) == nil
+}
+
+// match 返回第一条命中目标的规则
+func match(target *common.TargetAddr) *limitRule {
+	mu.RLock()
+	defer mu.RUnlock()
+	key := target.String()
+	for _, r := range rules {
+		for _, m := range r.matchers {
+			if m.Match(key, target.IP) {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+// NewWriter 包装写入，每次写入前等待所有令牌桶放行
+func NewWriter(w io.Writer, limiters ...*rate.Limiter) io.Writer {
+	return &writer{w: w, limiters: limiters}
+}
+
+type writer struct {
+	w        io.Writer
+	limiters []*rate.Limiter
+}
+
+// Write 按令牌桶容量分片写入，每片写入前等待所有令牌桶放行
+func (l *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		for _, limiter := range l.limiters {
+			if limiter.Limit() != rate.Inf && limiter.Burst() < n {
+				n = limiter.Burst()
+			}
+		}
+		for _, limiter := range l.limiters {
+			if err := limiter.WaitN(context2.Background(), n); err != nil {
+				return written, err
+			}
+		}
+		m, err := l.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// NewLimiter 创建令牌桶，bytes<=0 表示不限速；桶容量等于每秒字节数
+func NewLimiter(bytes int64) *rate.Limiter {
+	limiter := rate.NewLimiter(rate.Inf, 0)
+	setLimit(limiter, bytes)
+	return limiter
+}
+
+func setLimit(limiter *rate.Limiter, bytes int64) {
+	if bytes <= 0 {
+		limiter.SetLimit(rate.Inf)
+		return
+	}
+	limiter.SetBurst(int(bytes))
+	limiter.SetLimit(rate.Limit(bytes))
+}
+
+func parseRateOrLog(ctx context2.Context, field, s string) int64 {
+	bytes, err := ParseRate(s)
+	if err != nil {
+		logger.Error(ctx, map[string]interface{}{
+			"action":    config.ActionRuntime,
+			"errorCode": logger.ErrCodeDefault,
+			"error":     err,
+			"field":     field,
+		}, "invalid bandwidth limit, ignored")
+		return 0
+	}
+	return bytes
+}
+
+// ParseRate 解析每秒字节数，格式同 helper.ParseBytes，可带 /s 后缀，空串表示不限速
+func ParseRate(raw string) (int64, error) {
+	s := strings.TrimSpace(raw)
+	if strings.HasSuffix(strings.ToLower(s), "/s") {
+		s = s[:len(s)-2]
+	}
+	return helper.ParseBytes(s)
+}
@@ -0,0 +1,37 @@
+//go:build !windows
+
+package server
+
+import (
+	context2 "context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"proxy/config"
+	"proxy/utils/logger"
+)
+
+// watchLogLevelSignal 收到 SIGUSR1 时在 debug 与配置的日志级别之间切换（配置本身为 debug 时切到 info）
+func watchLogLevelSignal(ctx context2.Context) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	go func() {
+		for range ch {
+			level := "debug"
+			if logger.GetLevel() == "debug" {
+				level = config.Config.Log.Level
+				if level == "" || level == "debug" {
+					level = "info"
+				}
+			}
+			if err := logger.SetLevel(level); err != nil {
+				_ = logger.SetLevel("info")
+			}
+			logger.Warn(ctx, map[string]interface{}{
+				"action": config.ActionRuntime,
+				"level":  logger.GetLevel(),
+			}, "log level switched by SIGUSR1")
+		}
+	}()
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package server
+
+import (
+	context2 "context"
+)
+
+// watchLogLevelSignal Windows 没有 SIGUSR1，通过管理接口 /api/log/level 修改日志级别
+func watchLogLevelSignal(ctx context2.Context) {}
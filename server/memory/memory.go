@@ -0,0 +1,163 @@
+// Package memory 按 memory 配置设置 Go 运行时的内存上限、GC 比例与压舱，并监控内存占用：
+// 接近上限时拒绝新的 TUN 连接，已有连接不受影响，避免 128MB 的路由器、VPS 上被系统 OOM 杀掉
+package memory
+
+import (
+	context2 "context"
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"proxy/config"
+	"proxy/utils/context"
+	"proxy/utils/helper"
+	"proxy/utils/logger"
+)
+
+// sampleInterval 检查内存占用的间隔
+const sampleInterval = time.Second
+
+var (
+	// 启动时的设置（来自环境变量 GOMEMLIMIT、GOGC），配置项清空后恢复
+	initialLimit = debug.SetMemoryLimit(-1)
+	initialGC    = currentGCPercent()
+
+	mu       sync.Mutex
+	ballast  []byte
+	once     sync.Once
+	pressure atomic.Bool
+	rejected atomic.Int64 // 上次输出日志以来拒绝的连接数
+)
+
+func currentGCPercent() int {
+	p := debug.SetGCPercent(100)
+	debug.SetGCPercent(p)
+	return p
+}
+
+// Start 应用当前配置并开始监控内存占用，配置重载时重新应用
+func Start(ctx context2.Context) {
+	once.Do(func() {
+		Apply()
+		config.RegisterReloadCallback(Apply)
+		go monitor(ctx)
+	})
+}
+
+// Apply 按 memory 配置设置内存上限、GC 比例与压舱，配置错误的项沿用启动时的设置并记录日志
+func Apply() {
+	ctx := context.NewContext()
+	cfg := config.Config.Memory
+
+	limit := initialLimit
+	if n := parseOrLog(ctx, "memory.limit", cfg.Limit); n > 0 {
+		limit = n
+	}
+	debug.SetMemoryLimit(limit)
+
+	gc := initialGC
+	if cfg.GCPercent != 0 {
+		gc = cfg.GCPercent
+	}
+	debug.SetGCPercent(gc)
+
+	size := parseOrLog(ctx, "memory.ballast", cfg.Ballast)
+	mu.Lock()
+	// 只分配不写入，未访问的页不占物理内存，但计入 GC 的堆大小
+	if int64(len(ballast)) != size {
+		ballast = nil
+		if size > 0 {
+			ballast = make([]byte, size)
+		}
+	}
+	mu.Unlock()
+
+	fields := map[string]interface{}{
+		"action":    config.ActionRuntime,
+		"gcPercent": gc,
+		"ballast":   helper.FormatBytes(size),
+	}
+	if limit != math.MaxInt64 {
+		fields["limit"] = helper.FormatBytes(limit)
+		fields["highWater"] = config.MemoryHighWater()
+	}
+	logger.Debug(ctx, fields, "memory settings applied")
+}
+
+func parseOrLog(ctx context2.Context, field, raw string) int64 {
+	n, err := helper.ParseBytes(raw)
+	if err != nil {
+		logger.Warn(ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"field":  field,
+			"error":  err,
+		}, "invalid memory setting, ignored")
+	}
+	return n
+}
+
+// Admit 内存占用未达到 memory.high_water 时返回 true；否则记一次拒绝并返回 false，调用方应放弃新建连接
+func Admit() bool {
+	if !pressure.Load() {
+		return true
+	}
+	rejected.Add(1)
+	return false
+}
+
+// Usage 运行时向系统申请且未归还的内存（不含未写入、不占物理内存的压舱）与当前内存上限，未设置上限时 limit 为 0
+func Usage() (used, limit int64) {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	used = int64(samples[0].Value.Uint64() - samples[1].Value.Uint64())
+	mu.Lock()
+	used -= int64(len(ballast))
+	mu.Unlock()
+	if used < 0 {
+		used = 0
+	}
+	if limit = debug.SetMemoryLimit(-1); limit == math.MaxInt64 {
+		limit = 0
+	}
+	return used, limit
+}
+
+// monitor 每 sampleInterval 比较内存占用与上限，进入、解除高内存状态时输出日志，持续高内存时每分钟汇总一次拒绝的连接数，ctx 取消时退出
+func monitor(ctx context2.Context) {
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+	var lastLog time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		used, limit := Usage()
+		high := limit > 0 && used >= limit/100*int64(config.MemoryHighWater())
+		was := pressure.Swap(high)
+		fields := map[string]interface{}{
+			"action": config.ActionRuntime,
+			"used":   helper.FormatBytes(used),
+			"limit":  helper.FormatBytes(limit),
+		}
+		switch {
+		case high && !was:
+			lastLog = time.Now()
+			logger.Warn(ctx, fields, "memory usage is high, rejecting new TUN connections")
+		case high && time.Since(lastLog) >= time.Minute:
+			lastLog = time.Now()
+			fields["rejected"] = rejected.Swap(0)
+			logger.Warn(ctx, fields, "memory usage is still high")
+		case !high && was:
+			fields["rejected"] = rejected.Swap(0)
+			logger.Info(ctx, fields, "memory usage is back to normal, accepting new TUN connections")
+		}
+	}
+}
@@ -0,0 +1,325 @@
+// Package metrics 以 Prometheus 文本格式导出运行指标
+// 为避免引入额外依赖，这里只实现了计数器、仪表盘和直方图三种最基本的类型，输出格式由测试经 Prometheus 的文本解析器校验
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// collector 可导出的指标
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	registry = append(registry, c)
+	registryMu.Unlock()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelKey 用于在 map 中索引标签值组合
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// formatLabels 生成 {a="1",b="2"} 形式的标签串
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+labelEscaper.Replace(extra[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter 单调递增的计数器
+type Counter struct {
+	value atomic.Int64
+}
+
+// Inc 加一
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add 增加 n
+func (c *Counter) Add(n int64) {
+	c.value.Add(n)
+}
+
+// Value 当前值
+func (c *Counter) Value() int64 {
+	return c.value.Load()
+}
+
+// CountWriter 返回一个写入时向各计数器累加字节数的 io.Writer
+func CountWriter(w io.Writer, counters ...*Counter) io.Writer {
+	return &countWriter{Writer: w, counters: counters}
+}
+
+type countWriter struct {
+	io.Writer
+	counters []*Counter
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	for _, c := range w.counters {
+		c.Add(int64(n))
+	}
+	return n, err
+}
+
+// vec 按标签值组合保存子指标
+type vec[T any] struct {
+	name, help, kind string
+	labels           []string
+	mu               sync.RWMutex
+	children         map[string]*T
+	values           map[string][]string
+	newChild         func() *T
+}
+
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := labelKey(values)
+	v.mu.RLock()
+	child, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return child
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if child, ok = v.children[key]; !ok {
+		child = v.newChild()
+		v.children[key] = child
+		v.values[key] = append([]string(nil), values...)
+	}
+	return child
+}
+
+// each 按标签值排序遍历子指标
+func (v *vec[T]) each(fn func(values []string, child *T)) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	v.mu.RUnlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		v.mu.RLock()
+		child, values := v.children[key], v.values[key]
+		v.mu.RUnlock()
+		fn(values, child)
+	}
+}
+
+func (v *vec[T]) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+}
+
+func newVec[T any](name, help, kind string, labels []string, newChild func() *T) *vec[T] {
+	return &vec[T]{
+		name:     name,
+		help:     help,
+		kind:     kind,
+		labels:   labels,
+		children: make(map[string]*T),
+		values:   make(map[string][]string),
+		newChild: newChild,
+	}
+}
+
+// CounterVec 带标签的计数器
+type CounterVec struct {
+	*vec[Counter]
+}
+
+// NewCounterVec 创建并注册计数器
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, "counter", labels, func() *Counter { return &Counter{} })}
+	register(c)
+	return c
+}
+
+// With 返回指定标签值的计数器
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.with(values)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.header(w)
+	c.each(func(values []string, child *Counter) {
+		fmt.Fprintf(w, "%s%s %d\n", c.name, formatLabels(c.labels, values), child.Value())
+	})
+}
+
+// Gauge 可增可减的仪表盘
+type Gauge struct {
+	value atomic.Int64
+}
+
+// Add 增加 n（可为负数）
+func (g *Gauge) Add(n int64) {
+	g.value.Add(n)
+}
+
+// Set 设置当前值
+func (g *Gauge) Set(n int64) {
+	g.value.Store(n)
+}
+
+// Value 当前值
+func (g *Gauge) Value() int64 {
+	return g.value.Load()
+}
+
+// GaugeVec 带标签的仪表盘
+type GaugeVec struct {
+	*vec[Gauge]
+}
+
+// NewGaugeVec 创建并注册仪表盘
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, "gauge", labels, func() *Gauge { return &Gauge{} })}
+	register(g)
+	return g
+}
+
+// With 返回指定标签值的仪表盘
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.with(values)
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.header(w)
+	g.each(func(values []string, child *Gauge) {
+		fmt.Fprintf(w, "%s%s %d\n", g.name, formatLabels(g.labels, values), child.Value())
+	})
+}
+
+// gaugeFunc 抓取时才计算的仪表盘
+type gaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+// NewGaugeFunc 创建并注册抓取时计算的仪表盘
+func NewGaugeFunc(name, help string, fn func() float64) {
+	register(&gaugeFunc{name: name, help: help, fn: fn})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatFloat(g.fn()))
+}
+
+// Histogram 直方图
+type Histogram struct {
+	buckets []float64
+	mu      sync.Mutex
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+// Observe 记录一次观测值
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// HistogramVec 带标签的直方图
+type HistogramVec struct {
+	*vec[Histogram]
+}
+
+// NewHistogramVec 创建并注册直方图，buckets 为升序的上界
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{newVec(name, help, "histogram", labels, func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	})}
+	register(h)
+	return h
+}
+
+// With 返回指定标签值的直方图
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.with(values)
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.header(w)
+	h.each(func(values []string, child *Histogram) {
+		child.mu.Lock()
+		counts := append([]uint64(nil), child.counts...)
+		sum, count := child.sum, child.count
+		child.mu.Unlock()
+
+		var cumulative uint64
+		for i, le := range child.buckets {
+			cumulative += counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", formatFloat(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values), formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values), count)
+	})
+}
+
+// Handler 以 Prometheus 文本格式输出全部指标
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w)
+	})
+}
+
+// Write 将全部指标写入 w
+func Write(w io.Writer) {
+	registryMu.Lock()
+	collectors := append([]collector(nil), registry...)
+	registryMu.Unlock()
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
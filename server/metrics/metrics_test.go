@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// TestWriteParses 导出的全部指标需能被 Prometheus 的文本格式解析器解析，标签值转义后原样还原
+func TestWriteParses(t *testing.T) {
+	remote := "TLS \"edge\"\\1\nbackup"
+	TransferBytes.With(remote, "up").Add(42)
+	ActiveConnections.With("test").Add(-1)
+	HandshakeSeconds.With(remote).Observe(0.03)
+	HandshakeSeconds.With(remote).Observe(20)
+
+	var buf bytes.Buffer
+	Write(&buf)
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(&buf)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	find := func(name string, typ dto.MetricType) *dto.Metric {
+		t.Helper()
+		f, ok := families[name]
+		if !ok {
+			t.Fatalf("%s not exported", name)
+		}
+		if f.GetType() != typ {
+			t.Fatalf("%s type = %v, want %v", name, f.GetType(), typ)
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "remote" && l.GetValue() == remote || l.GetName() == "inbound" && l.GetValue() == "test" {
+					return m
+				}
+			}
+		}
+		t.Fatalf("%s has no sample for the test labels", name)
+		return nil
+	}
+
+	if v := find("proxy_transfer_bytes_total", dto.MetricType_COUNTER).GetCounter().GetValue(); v != 42 {
+		t.Errorf("transfer bytes = %v, want 42", v)
+	}
+	if v := find("proxy_active_connections", dto.MetricType_GAUGE).GetGauge().GetValue(); v != -1 {
+		t.Errorf("active connections = %v, want -1", v)
+	}
+	h := find("proxy_handshake_duration_seconds", dto.MetricType_HISTOGRAM).GetHistogram()
+	if h.GetSampleCount() != 2 || h.GetSampleSum() != 20.03 {
+		t.Errorf("handshake count = %d, sum = %v", h.GetSampleCount(), h.GetSampleSum())
+	}
+	for _, b := range h.GetBucket() {
+		want := uint64(0)
+		switch {
+		case math.IsInf(b.GetUpperBound(), 1):
+			want = 2
+		case b.GetUpperBound() >= 0.05:
+			want = 1
+		}
+		if b.GetCumulativeCount() != want {
+			t.Errorf("bucket le=%v = %d, want %d", b.GetUpperBound(), b.GetCumulativeCount(), want)
+		}
+	}
+	if _, ok := families["go_goroutines"]; !ok {
+		t.Error("go_goroutines not exported")
+	}
+}
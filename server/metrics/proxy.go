@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	context2 "context"
+	"errors"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/upgrade"
+	"proxy/utils/logger"
+)
+
+// 握手耗时直方图的上界（秒）
+var handshakeBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	// Connections 入站连接总数
+	Connections = NewCounterVec("proxy_connections_total", "Total inbound connections accepted.", "inbound")
+	// ActiveConnections 当前活跃的入站连接数
+	ActiveConnections = NewGaugeVec("proxy_active_connections", "Inbound connections currently open.", "inbound")
+	// TransferBytes 经各出口转发的字节数，direction 为 up（发往远端）或 down（发回客户端）
+	TransferBytes = NewCounterVec("proxy_transfer_bytes_total", "Bytes relayed per outbound remote.", "remote", "direction")
+	// HandshakeSeconds 出口握手耗时
+	HandshakeSeconds = NewHistogramVec("proxy_handshake_duration_seconds", "Outbound handshake latency.", handshakeBuckets, "remote")
+	// HandshakeErrors 出口握手失败次数，class 为错误类别，见 common.ErrorClass
+	HandshakeErrors = NewCounterVec("proxy_handshake_errors_total", "Failed outbound handshakes.", "remote", "class")
+	// DNSCacheRequests DNS 缓存查询次数，result 为 hit 或 miss
+	DNSCacheRequests = NewCounterVec("proxy_dns_cache_requests_total", "DNS cache lookups.", "cache", "result")
+	// RouteDecisions 路由决策次数，按命中原因统计
+	RouteDecisions = NewCounterVec("proxy_route_decisions_total", "Routing decisions by reason.", "reason", "remote")
+	// ConnLimitHits 入口连接数达到 in.max_conns 的次数，strategy 为 wait 或 reject
+	ConnLimitHits = NewCounterVec("proxy_conn_limit_hits_total", "Times the inbound connection limit was reached.", "strategy")
+	// TunPacketDrops TUN 侧丢弃的数据包
+	TunPacketDrops = NewCounterVec("proxy_tun_packet_drops_total", "Packets dropped on the TUN path.", "reason")
+	// DNSFeedback 服务端回送的域名实际连接地址，region 为 cn、foreign 或 unknown（服务端未回送）
+	DNSFeedback = NewCounterVec("proxy_dns_feedback_total", "Server-reported connect addresses for proxied domains.", "region")
+)
+
+func init() {
+	NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+}
+
+// TrackConnection 记录一个入站连接，返回的函数在连接关闭时调用
+func TrackConnection(inbound string) func() {
+	Connections.With(inbound).Inc()
+	active := ActiveConnections.With(inbound)
+	active.Add(1)
+	return func() {
+		active.Add(-1)
+	}
+}
+
+// RemoteHealth 出口最近一次握手的结果
+type RemoteHealth struct {
+	Remote  string  `json:"remote"`
+	Time    string  `json:"time"`
+	Latency float64 `json:"latency_ms"`
+	Error   string  `json:"error,omitempty"`
+}
+
+var health sync.Map // remote -> RemoteHealth
+
+// ObserveHandshake 记录一次出口握手的耗时及结果
+func ObserveHandshake(remote string, begin time.Time, err error) {
+	elapsed := time.Since(begin)
+	HandshakeSeconds.With(remote).Observe(elapsed.Seconds())
+	h := RemoteHealth{
+		Remote:  remote,
+		Time:    time.Now().In(config.CstZone).Format(config.TimeFormat),
+		Latency: float64(elapsed.Microseconds()) / 1000,
+	}
+	if err != nil {
+		HandshakeErrors.With(remote, common.ErrorClass(err)).Inc()
+		h.Error = err.Error()
+	}
+	health.Store(remote, h)
+}
+
+// Health 各出口最近一次握手的结果，按出口名排序
+func Health() []RemoteHealth {
+	list := make([]RemoteHealth, 0)
+	health.Range(func(_, v interface{}) bool {
+		list = append(list, v.(RemoteHealth))
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Remote < list[j].Remote
+	})
+	return list
+}
+
+// DNSCacheResult 记录一次 DNS 缓存查询结果
+func DNSCacheResult(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	DNSCacheRequests.With(cache, result).Inc()
+}
+
+// Serve 在 addr 上提供 /metrics，阻塞直到监听失败
+func Serve(ctx context2.Context, addr string) {
+	listener, err := upgrade.Listen(addr)
+	if err != nil {
+		logger.Errorf(ctx, map[string]interface{}{
+			"action":    config.ActionRuntime,
+			"errorCode": logger.ErrCodeListen,
+			"error":     err,
+		}, "can not listen on %v for metrics: %v", addr, err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	logger.Info(ctx, map[string]interface{}{
+		"action": config.ActionRuntime,
+		"listen": listener.Addr().String(),
+	}, "metrics endpoint started")
+	if err := http.Serve(listener, mux); err != nil && !errors.Is(err, net.ErrClosed) {
+		logger.Error(ctx, map[string]interface{}{
+			"action":    config.ActionRuntime,
+			"errorCode": logger.ErrCodeListen,
+			"error":     err,
+		}, "metrics endpoint stopped")
+	}
+}
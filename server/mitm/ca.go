@@ -0,0 +1,194 @@
+// Package mitm HTTPS 解密检查使用的本机 CA：首次使用时生成 CA 证书与私钥，按站点域名签发并缓存证书
+package mitm
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"proxy/config"
+	"proxy/utils/logger"
+)
+
+const (
+	defaultCACert = "mitm-ca.crt"
+	defaultCAKey  = "mitm-ca.key"
+	// leafValidity 站点证书有效期，剩余不足 leafRenew 时重新签发
+	leafValidity = 30 * 24 * time.Hour
+	leafRenew    = 24 * time.Hour
+	// maxLeafCache 缓存的站点证书数量上限，超出时清空重新签发
+	maxLeafCache = 1024
+)
+
+// CA 本机 CA 与已签发的站点证书
+type CA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+
+	mu     sync.Mutex
+	leaves map[string]*tls.Certificate
+}
+
+var (
+	caMu      sync.Mutex
+	current   *CA
+	loadedCrt string
+	loadedKey string
+)
+
+// Paths 配置的 CA 证书与私钥路径，未配置时使用默认文件名
+func Paths() (string, string) {
+	certFile, keyFile := config.Config.MITM.CACert, config.Config.MITM.CAKey
+	if certFile == "" {
+		certFile = defaultCACert
+	}
+	if keyFile == "" {
+		keyFile = defaultCAKey
+	}
+	return certFile, keyFile
+}
+
+// Get 返回 mitm.ca_cert / mitm.ca_key 对应的 CA，文件不存在时生成；路径变化后重新加载
+func Get(ctx context.Context) (*CA, error) {
+	certFile, keyFile := Paths()
+	caMu.Lock()
+	defer caMu.Unlock()
+	if current != nil && loadedCrt == certFile && loadedKey == keyFile {
+		return current, nil
+	}
+	ca, err := load(certFile, keyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		if ca, err = generate(certFile, keyFile); err == nil {
+			logger.Warn(ctx, map[string]interface{}{
+				"action": config.ActionRuntime,
+				"caCert": certFile,
+			}, "mitm CA generated, trust it on the devices to inspect and keep the key private")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	current, loadedCrt, loadedKey = ca, certFile, keyFile
+	return ca, nil
+}
+
+func load(certFile, keyFile string) (*CA, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		if _, statErr := os.Stat(certFile); errors.Is(statErr, os.ErrNotExist) {
+			return nil, statErr
+		}
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: only ECDSA keys are supported", keyFile)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("%s is not a CA certificate", certFile)
+	}
+	return &CA{cert: cert, key: key, leaves: make(map[string]*tls.Certificate)}, nil
+}
+
+// generate 生成有效期 10 年的 CA，私钥文件仅所有者可读
+func generate(certFile, keyFile string) (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "CelestialLadderTrial MITM CA", Organization: []string{"CelestialLadderTrial"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{cert: cert, key: key, leaves: make(map[string]*tls.Certificate)}, nil
+}
+
+// Certificate 为 host（域名或 IP）签发的站点证书，缓存到临近过期
+func (ca *CA) Certificate(host string) (*tls.Certificate, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if leaf, ok := ca.leaves[host]; ok && time.Until(leaf.Leaf.NotAfter) > leafRenew {
+		return leaf, nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	cert := &tls.Certificate{Certificate: [][]byte{der, ca.cert.Raw}, PrivateKey: key, Leaf: leaf}
+	if len(ca.leaves) >= maxLeafCache {
+		ca.leaves = make(map[string]*tls.Certificate)
+	}
+	ca.leaves[host] = cert
+	return cert, nil
+}
+
+func randomSerial() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return serial
+}
@@ -0,0 +1,367 @@
+package server
+
+import (
+	context2 "context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"proxy/config"
+	"proxy/server/admin"
+	"proxy/server/conntrack"
+	"proxy/server/crash"
+	"proxy/server/diagnose"
+	"proxy/server/hooks"
+	"proxy/server/memory"
+	"proxy/server/metrics"
+	"proxy/server/quota"
+	"proxy/server/reverse"
+	"proxy/server/route"
+	"proxy/server/stats"
+	"proxy/server/subscription"
+	"proxy/server/systemproxy"
+	"proxy/server/tor"
+	"proxy/server/tracing"
+	"proxy/server/tun"
+	"proxy/server/upgrade"
+	"proxy/utils/context"
+	"proxy/utils/logger"
+)
+
+// defaultGracePeriod 未配置 shutdown.grace_period 时等待在途连接的时间
+const defaultGracePeriod = 10 * time.Second
+
+// registerReload 重载回调只注册一次
+var registerReload sync.Once
+
+// Proxy 代理服务的生命周期：New 创建，Run 启动各子系统并阻塞，Shutdown 停止监听与 TUN 并恢复系统代理
+// 各子系统共用全局配置与状态，同一进程只能运行一个 Proxy
+type Proxy struct {
+	ctx      context2.Context
+	ready    chan struct{}
+	stopped  chan struct{}
+	once     sync.Once
+	handover atomic.Bool // 监听已交给平滑重启的新进程
+}
+
+// New 以 cfg 创建代理服务，不启动任何监听；cfg 不是当前配置时替换 config.Config
+func New(cfg *config.Settings) (*Proxy, error) {
+	if cfg == nil {
+		return nil, errors.New("nil config")
+	}
+	config.Apply(cfg)
+	if NewServer() == nil {
+		return nil, fmt.Errorf("unknown server type %d", config.Config.In.Type)
+	}
+	return &Proxy{ctx: context.NewContext(), ready: make(chan struct{}), stopped: make(chan struct{})}, nil
+}
+
+// Run 按配置启动指标、管理接口、订阅、Tor、反向隧道、系统代理、TUN、入口监听与端口转发，阻塞到 ctx 取消或调用 Shutdown；
+// 启动失败时返回错误，已启动的部分需调用 Shutdown 清理
+func (p *Proxy) Run(ctx context2.Context) error {
+	gCtx := p.ctx
+
+	// 上次运行异常退出时先清理遗留的路由与系统代理
+	recoverStaleState(gCtx)
+	// 未捕获的 panic 写入崩溃报告，退出前删除 TUN 路由并恢复系统代理
+	crash.Install(gCtx)
+	crash.SetRestore(func() { emergencyRestore(gCtx) })
+	// 内存上限、GC 比例与压舱，内存紧张时拒绝新的 TUN 连接
+	memory.Start(gCtx)
+
+	// Prometheus 指标（可选）
+	if config.Config.Metrics.Listen != "" {
+		go metrics.Serve(gCtx, config.Config.Metrics.Listen)
+	}
+
+	// SIGUSR1 切换 debug 日志
+	watchLogLevelSignal(gCtx)
+	// SIGHUP 重新加载配置，SIGUSR2 平滑重启
+	watchReloadSignal(gCtx)
+	watchUpgradeSignal(gCtx, p)
+
+	// OpenTelemetry 链路追踪（可选）
+	if config.Config.Tracing.Endpoint != "" {
+		go tracing.Export(gCtx, config.Config.Tracing.Endpoint, config.Config.Tracing.ServiceName)
+	}
+
+	// 按 admin.webhooks 推送连接事件
+	hooks.StartWebhooks(gCtx)
+
+	// 拉取订阅节点，需在 TUN 添加直连路由之前完成
+	subscription.Start(gCtx)
+	// 加载广告与跟踪拦截列表，并按 adblock.interval 定期更新
+	route.StartBlocklists(gCtx)
+	// 按配置启动 Tor 出口使用的 tor 进程
+	tor.Start(gCtx)
+	// 经加密隧道在服务端开放的反向隧道端口
+	reverse.Start(gCtx)
+
+	toggleMu.Lock()
+	// 根据配置自动设置系统代理（HTTP/HTTPS 指向本地端口）
+	if config.SystemProxyEnabled() {
+		systemproxy.Apply(gCtx, config.Config.In.Port)
+		proxyPort = config.Config.In.Port
+	}
+
+	// 初始化TUN服务（如果启用）
+	if config.TunEnabled() {
+		service, err := tun.NewService()
+		if err != nil {
+			toggleMu.Unlock()
+			logger.Error(gCtx, map[string]interface{}{
+				"action":    config.ActionRuntime,
+				"errorCode": logger.ErrCodeHandshake,
+				"error":     err,
+			}, "failed to initialize TUN service")
+			return err
+		}
+		tunService, tunApplied = service, currentTunSettings()
+
+		// 启动TUN服务（在goroutine中运行）
+		crash.Go(func() {
+			if err := service.Start(); err != nil {
+				logger.Error(gCtx, map[string]interface{}{
+					"action":    config.ActionRuntime,
+					"errorCode": logger.ErrCodeHandshake,
+					"error":     err,
+				}, "TUN service error")
+			}
+		})
+	}
+	toggleMu.Unlock()
+
+	// 服务端按用户统计流量与配额
+	if isTunnelServer(config.Config.In.Type) {
+		quota.Start(gCtx)
+	}
+
+	// 按天统计流量，stats.file 配置时持久化
+	stats.Start(gCtx)
+
+	// 管理接口与控制套接字共用的开关、自检与测速
+	registerToggles()
+	registerProbes()
+	admin.SetSelfTest(func() interface{} {
+		return diagnose.BuildSelfTest(context.NewContext())
+	})
+	admin.SetSpeedTest(func(req admin.SpeedTestRequest, duration time.Duration) (interface{}, error) {
+		opts := diagnose.BenchOptions{URL: req.URL, Count: req.Count, Duration: duration, Via: req.Via, Live: true}
+		if req.Upload || req.UploadURL != "" {
+			opts.UploadURL = req.UploadURL
+			if opts.UploadURL == "" {
+				opts.UploadURL = diagnose.DefaultUploadURL
+			}
+		}
+		return diagnose.RunBench(context.NewContext(), opts)
+	})
+	// 本机管理接口（可选）
+	if config.Config.Admin.Listen != "" {
+		go admin.Serve(gCtx, config.Config.Admin.Listen, config.Config.Admin.Token)
+	}
+	// 本机控制套接字，供 status 子命令查询
+	if path := config.ControlSocket(); path != "" {
+		go admin.ServeControl(gCtx, path)
+	}
+
+	// 开启本地的TCP监听（SOCKS5 / HTTP / TLS / WSS 入口），ctx 取消时关闭
+	listenMu.Lock()
+	listenBase = ctx
+	listenMu.Unlock()
+	if err := startListener(gCtx); err != nil {
+		return err
+	}
+	// 静态端口转发
+	applyForwards(gCtx)
+	// 配置重载时按差异重启监听、TUN 与系统代理
+	registerReload.Do(func() {
+		config.RegisterReloadCallback(applyReload)
+	})
+	// 由平滑重启启动时通知旧进程交出监听
+	if err := upgrade.Ready(); err != nil {
+		logger.Error(gCtx, map[string]interface{}{
+			"action":    config.ActionRuntime,
+			"errorCode": logger.ErrCodeDefault,
+			"error":     err,
+		}, "notify old process failed")
+	}
+	close(p.ready)
+
+	select {
+	case <-ctx.Done():
+	case <-p.stopped:
+	}
+	return nil
+}
+
+// Ready 入口监听开启后关闭，可用于通知进程管理器服务已就绪
+func (p *Proxy) Ready() <-chan struct{} {
+	return p.ready
+}
+
+// Shutdown 关闭入口监听、端口转发与配置文件监控，等待在途连接结束（最长 shutdown.grace_period，之后强制断开），
+// 再停止 tor 与 TUN 并恢复系统代理；ctx 到期时仍会尝试恢复系统代理并返回 ctx.Err()。管理接口与指标服务随进程退出
+func (p *Proxy) Shutdown(ctx context2.Context) error {
+	p.once.Do(func() {
+		close(p.stopped)
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			// 确保即使发生 panic 也会恢复系统代理
+			if r := recover(); r != nil {
+				logger.Error(p.ctx, map[string]interface{}{
+					"action": config.ActionRuntime,
+					"error":  r,
+				}, "panic during shutdown, attempting to restore system proxy")
+				if config.SystemProxyEnabled() {
+					systemproxy.Restore(p.ctx)
+				}
+			}
+		}()
+		config.StopConfigWatcher()
+		admin.StopControl()
+		stopListener()
+		stopForwards()
+		if p.handover.Load() {
+			// 管理接口与指标的监听也已交给新进程
+			upgrade.CloseAll()
+		}
+		reverse.Stop()
+		p.drain(ctx)
+		// 交接后统计文件由新进程写入
+		if !p.handover.Load() {
+			if err := stats.Save(); err != nil {
+				logger.Error(p.ctx, map[string]interface{}{
+					"action":    config.ActionRuntime,
+					"errorCode": logger.ErrCodeDefault,
+					"error":     err,
+				}, "save traffic stats failed")
+			}
+		}
+		// 取消仍在握手中的请求，中断其拨号与 DoH 查询
+		context.CancelAll()
+		tor.Stop()
+
+		toggleMu.Lock()
+		defer toggleMu.Unlock()
+		// 停止 TUN 服务
+		if tunService != nil {
+			tunService.Stop()
+			tunService, tunApplied = nil, nil
+		}
+		// 恢复系统代理配置（必须在 TUN 停止后）
+		if proxyPort != 0 {
+			logger.Info(p.ctx, map[string]interface{}{
+				"action": config.ActionRuntime,
+			}, "restoring system proxy settings...")
+			systemproxy.Restore(p.ctx)
+			proxyPort = 0
+		}
+		removeRunMarker()
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		// 超时后仍然尝试恢复系统代理
+		if config.SystemProxyEnabled() {
+			logger.Warn(p.ctx, map[string]interface{}{
+				"action": config.ActionRuntime,
+			}, "attempting to restore system proxy before force exit")
+			systemproxy.Restore(p.ctx)
+		}
+		return ctx.Err()
+	}
+}
+
+// GracePeriod 退出时等待在途连接结束的最长时间（shutdown.grace_period，默认 10s）
+func (p *Proxy) GracePeriod() time.Duration {
+	raw := config.Config.Shutdown.GracePeriod
+	if raw == "" {
+		return defaultGracePeriod
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 0 {
+		logger.Error(p.ctx, map[string]interface{}{
+			"action":    config.ActionRuntime,
+			"errorCode": logger.ErrCodeDefault,
+			"value":     raw,
+		}, "invalid shutdown grace period, use 10s")
+		return defaultGracePeriod
+	}
+	return d
+}
+
+// drain 等待在途连接结束，超过宽限期或 ctx 到期后断开剩余的连接
+func (p *Proxy) drain(ctx context2.Context) {
+	n := conntrack.Count()
+	if n == 0 {
+		return
+	}
+	grace := p.GracePeriod()
+	logger.Info(p.ctx, map[string]interface{}{
+		"action":      config.ActionRuntime,
+		"connections": n,
+		"grace":       grace.String(),
+	}, "draining connections")
+	dctx, cancel := context2.WithTimeout(ctx, grace)
+	defer cancel()
+	if killed := conntrack.Drain(dctx); killed > 0 {
+		logger.Warn(p.ctx, map[string]interface{}{
+			"action":      config.ActionRuntime,
+			"connections": killed,
+		}, "grace period expired, remaining connections closed")
+	}
+}
+
+// Upgrade 平滑重启：以相同参数启动新进程并交出全部监听，新进程启动完成后 Run 返回，
+// 由调用方执行 Shutdown 等待在途连接结束。TUN 与系统代理由进程独占，开启时不支持；QUIC 与 KCP 入口的 UDP 端口无法交接，也不支持
+func (p *Proxy) Upgrade() error {
+	toggleMu.Lock()
+	busy := tunService != nil || proxyPort != 0
+	toggleMu.Unlock()
+	if busy {
+		return errors.New("graceful restart is not supported while TUN or system proxy is enabled")
+	}
+	if isUDPServer(config.Config.In.Type) {
+		return errors.New("graceful restart is not supported for the QUIC or KCP inbound")
+	}
+	// 停止定期写回并最后写入一次用量与统计，新进程启动时读取；交接后旧进程不再写这两个文件，以免覆盖新进程的记录，
+	// 排空期间旧连接的流量不再计入
+	tunnel := isTunnelServer(config.Config.In.Type)
+	resume := func() {
+		if tunnel {
+			quota.Resume(p.ctx)
+		}
+		stats.Resume(p.ctx)
+	}
+	if tunnel {
+		if err := quota.Stop(); err != nil {
+			resume()
+			return err
+		}
+	}
+	if err := stats.Stop(); err != nil {
+		resume()
+		return err
+	}
+	pid, err := upgrade.Upgrade(30 * time.Second)
+	if err != nil {
+		resume()
+		return err
+	}
+	p.handover.Store(true)
+	logger.Info(p.ctx, map[string]interface{}{
+		"action": config.ActionRuntime,
+		"pid":    pid,
+	}, "listeners handed over to new process")
+	p.once.Do(func() {
+		close(p.stopped)
+	})
+	return nil
+}
@@ -0,0 +1,33 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/utils/logger"
+)
+
+// clockOffset 服务端时间减本机时间（秒），收到服务端的时间回复后更新，之后的认证头按校正后的时间生成
+var clockOffset atomic.Int64
+
+// authTime 认证头中的时间戳
+func authTime() uint64 {
+	return uint64(time.Now().Unix() + clockOffset.Load())
+}
+
+// watchClockSkew 服务端因时钟偏差拒绝认证时会回复它的时间，据此校正本机的时间偏移
+func watchClockSkew(ctx context.Context, ec *common.Chacha20Stream) *common.Chacha20Stream {
+	ec.WatchClockSkew(func(serverTime int64) {
+		offset := serverTime - time.Now().Unix()
+		clockOffset.Store(offset)
+		logger.Warn(ctx, map[string]interface{}{
+			"action":    config.ActionRequestBegin,
+			"errorCode": logger.ErrCodeHandshake,
+			"offset":    offset,
+		}, "local clock differs from the remote, timestamps are corrected from now on")
+	})
+	return ec
+}
@@ -0,0 +1,185 @@
+package client
+
+import (
+	context2 "context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"proxy/config"
+)
+
+const (
+	// attemptDelay 上一个地址未连上时发起下一个地址的间隔（RFC 8305 Connection Attempt Delay）
+	attemptDelay = 250 * time.Millisecond
+	// remoteRaceAddrs 远端域名解析出多个地址时参与竞速的地址数
+	remoteRaceAddrs = 3
+)
+
+// dialHappyEyeballs 双栈拨号 host:port（RFC 8305）。preferV6 为 true 时由标准库按系统的地址排序（RFC 6724，通常 IPv6 在前）拨号，
+// 首选地址族 attemptDelay 内未连上时并行尝试另一地址族；为 false 时解析后两个地址族交替排列、从 IPv4 开始错开发起连接。
+// parent 取消时放弃拨号
+func dialHappyEyeballs(parent context2.Context, dialer *net.Dialer, host string, port int, preferV6 bool) (net.Conn, error) {
+	if preferV6 {
+		d := *dialer
+		d.FallbackDelay = attemptDelay
+		return d.DialContext(parent, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+	ctx, cancel := context2.WithTimeout(parent, dialer.Timeout)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	return raceDial(ctx, dialer, interleave(ips, false), port)
+}
+
+// dialRemoteTCP 连接远端服务器 addr（host:port）。host 为域名且解析出多个地址时（anycast / DDNS 的多个接入点），
+// 按 dns.ip_strategy 取前 remoteRaceAddrs 个地址错开发起连接，第一个连上的胜出，某个接入点不可达时不必等它超时
+func dialRemoteTCP(parent context2.Context, dialer *net.Dialer, addr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || net.ParseIP(host) != nil {
+		return dialer.DialContext(parent, "tcp", addr)
+	}
+	ctx, cancel := context2.WithTimeout(parent, dialer.Timeout)
+	defer cancel()
+	network := "ip"
+	if config.IPv4Only() {
+		network = "ip4"
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, network, host)
+	if err != nil {
+		return nil, err
+	}
+	ips = interleave(ips, config.Config.DNS.IPStrategy == config.IPStrategyIPv6First)
+	if len(ips) > remoteRaceAddrs {
+		ips = ips[:remoteRaceAddrs]
+	}
+	return raceDial(ctx, dialer, ips, port)
+}
+
+// raceDial 依次向 ips 的 port 发起连接，每隔 attemptDelay（或上一个失败后立即）发起下一个，
+// 第一个连上的胜出，其余取消；全部失败时返回第一个错误
+func raceDial(ctx context2.Context, dialer *net.Dialer, ips []net.IP, port int) (net.Conn, error) {
+	ctx, cancel := context2.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(ips))
+	next, pending := 0, 0
+	start := func() {
+		addr := net.JoinHostPort(ips[next].String(), strconv.Itoa(port))
+		next++
+		pending++
+		go func() {
+			conn, err := dialer.DialContext(ctx, "tcp", addr)
+			results <- result{conn, err}
+		}()
+	}
+	start()
+	timer := time.NewTimer(attemptDelay)
+	defer timer.Stop()
+	var firstErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				cancel()
+				// 取消后仍可能有连接恰好建立，关闭它们
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.conn != nil {
+							_ = r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(ips) {
+				start()
+				timer.Reset(attemptDelay)
+			}
+		case <-timer.C:
+			if next < len(ips) {
+				start()
+				timer.Reset(attemptDelay)
+			}
+		}
+	}
+	return nil, firstErr
+}
+
+// remoteIndex 上次连上的 out.remote_addr 地址下标，之后的连接优先使用它
+var remoteIndex atomic.Int32
+
+// eachRemote 从上次连上的地址开始依次对 out.remote_addr 中的地址调用 dial（addr 为 host:port，host 用作 SNI），
+// 成功即返回并记住该地址；全部失败时返回最后一个错误，并把远端标记为不可达
+func eachRemote(dial func(addr, host string) error) error {
+	addrs := config.RemoteAddrs()
+	if len(addrs) == 0 {
+		return errors.New("out.remote_addr is not configured")
+	}
+	start := int(remoteIndex.Load())
+	var err error
+	for i := range addrs {
+		idx := (start + i) % len(addrs)
+		host, _, _ := net.SplitHostPort(addrs[idx])
+		if err = dial(addrs[idx], host); err == nil {
+			remoteIndex.Store(int32(idx))
+			markRemote(nil)
+			return nil
+		}
+	}
+	markRemote(err)
+	return err
+}
+
+// withClientAuth 为连接远端的 TLS 配置附加 out.cert_file 客户端证书与 out.ca_file 信任的 CA
+func withClientAuth(cfg *tls.Config) (*tls.Config, error) {
+	certs, roots, err := config.RemoteTLS()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Certificates, cfg.RootCAs = certs, roots
+	return cfg, nil
+}
+
+// interleave 按地址族交替排列，同一地址族内保持解析顺序
+func interleave(ips []net.IP, preferV6 bool) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	first, second := v4, v6
+	if preferV6 {
+		first, second = v6, v4
+	}
+	list := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			list = append(list, first[i])
+		}
+		if i < len(second) {
+			list = append(list, second[i])
+		}
+	}
+	return list
+}
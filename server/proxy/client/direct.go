@@ -1,19 +1,26 @@
 package client
 
 import (
+	"context"
 	"io"
 	"net"
 
 	"proxy/config"
 	"proxy/server/common"
-	"proxy/utils/context"
 	"proxy/utils/logger"
 )
 
 type DirectRemote struct {
 }
 
-func (r *DirectRemote) Handshake(ctx *context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+// Handshake 握手失败时按 retry 配置重试
+func (r *DirectRemote) Handshake(ctx context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+	return withRetry(ctx, r.Name(), func() (io.ReadWriter, error) {
+		return r.handshake(ctx, target)
+	})
+}
+
+func (r *DirectRemote) handshake(ctx context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
 	// 在函数退出前，执行defer
 	// 捕捉异常后，程序不会异常退出
 	defer func() {
@@ -33,28 +40,40 @@ func (r *DirectRemote) Handshake(ctx *context.Context, target *common.TargetAddr
 	
 	switch target.Proto {
 	case 3:
-		udpAddr := &net.UDPAddr{IP: target.IP, Port: target.Port}
-		target.RUdpAddr = udpAddr
-
+		// 未连接的套接字：一个 UDP 会话内的数据报可发往不同目标，回包来源不限
+		network, local := "udp", ""
+		if config.IPv4Only() {
+			network = "udp4"
+		}
 		// UDP 也需要绑定到原接口
-		var localAddr *net.UDPAddr
-		if dialer.LocalAddr != nil {
-			if tcpAddr, ok := dialer.LocalAddr.(*net.TCPAddr); ok {
-				localAddr = &net.UDPAddr{
-					IP:   tcpAddr.IP,
-					Port: 0, // 系统自动分配端口
-				}
-			}
+		if tcpAddr, ok := dialer.LocalAddr.(*net.TCPAddr); ok && tcpAddr != nil {
+			local = net.JoinHostPort(tcpAddr.IP.String(), "0")
 		}
-		
-		udpConn, err := net.DialUDP("udp", localAddr, udpAddr)
+		// 经 dialer 的 Control 带上 SO_MARK（Linux）与网卡绑定
+		lc := net.ListenConfig{Control: dialer.Control}
+		pc, err := lc.ListenPacket(ctx, network, local)
 		if nil != err {
 			return nil, err
 		}
-		target.RUdpConn = udpConn
-		return udpConn, nil
+		return common.NewUDPPacketConn(pc.(*net.UDPConn)), nil
 	default:
-		return dialer.Dial("tcp", target.String())
+		// 域名目标按地址族偏好解析，IP 目标保持原样；双栈时两个地址族错开发起连接（Happy Eyeballs）
+		var conn net.Conn
+		var err error
+		switch {
+		case target.IP != nil:
+			conn, err = dialer.DialContext(ctx, "tcp", target.String())
+		case config.IPv4Only():
+			conn, err = dialer.DialContext(ctx, "tcp4", target.String())
+		default:
+			preferV6 := config.Config.DNS.IPStrategy == config.IPStrategyIPv6First
+			conn, err = dialHappyEyeballs(ctx, dialer, target.Name, target.Port, preferV6)
+		}
+		if nil != err {
+			return nil, err
+		}
+		common.TuneTCP(conn)
+		return conn, nil
 	}
 }
 func (r *DirectRemote) Name() string {
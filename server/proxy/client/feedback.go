@@ -0,0 +1,34 @@
+package client
+
+import (
+	"context"
+	"net"
+
+	"proxy/config"
+	"proxy/server/common"
+)
+
+// OnDNSFeedback 收到服务端回送的域名实际连接的 IP 时调用（ip 可能为 nil），由 route 包设置
+var OnDNSFeedback func(ctx context.Context, name string, ip net.IP)
+
+// requestProto 请求头中的协议：开启 out.dns_feedback 时域名目标的 TCP 请求请求服务端回送解析结果
+func requestProto(target *common.TargetAddr) uint16 {
+	if config.Config.Out.DNSFeedback && target.Proto == 1 && target.IP == nil {
+		return common.ProtoTCPFeedback
+	}
+	return target.Proto
+}
+
+// watchFeedback 请求头中的协议 proto 请求了解析回送时，从服务端的第一段数据中取出回送的 IP 交给 OnDNSFeedback
+func watchFeedback(ctx context.Context, ec *common.Chacha20Stream, target *common.TargetAddr, proto uint16) *common.Chacha20Stream {
+	if proto != common.ProtoTCPFeedback {
+		return ec
+	}
+	name := target.Name
+	ec.WatchFeedback(func(ip net.IP) {
+		if fn := OnDNSFeedback; fn != nil {
+			fn(ctx, name, ip)
+		}
+	})
+	return ec
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"proxy/config"
+	"proxy/server/common"
+)
+
+// grpcTransport 到 out.remote_addr 的 HTTP/2 连接池，同一地址的请求复用一条 TLS 连接，各占一个流
+var grpcTransport = &http2.Transport{
+	DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+		// 使用绑定到原默认接口的 Dialer，确保不走 TUN
+		conn, err := dialRemote(ctx, common.GetOriginalInterfaceDialer(), addr)
+		if err != nil {
+			return nil, err
+		}
+		common.TuneTCP(conn)
+		if cfg, err = withClientAuth(cfg.Clone()); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		cc := tls.Client(conn, cfg)
+		if err = cc.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return cc, nil
+	},
+	TLSClientConfig: &tls.Config{
+		ClientSessionCache: tls.NewLRUClientSessionCache(32),
+		MinVersion:         tls.VersionTLS12,
+	},
+	// 空闲时定期 PING，及时发现被中间设备断开的连接
+	ReadIdleTimeout: 30 * time.Second,
+	PingTimeout:     15 * time.Second,
+}
+
+type GRPCRemote struct {
+}
+
+// Handshake 握手失败时按 retry 配置重试
+func (r *GRPCRemote) Handshake(ctx context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+	return withRetry(ctx, r.Name(), func() (io.ReadWriter, error) {
+		return r.handshake(ctx, target)
+	})
+}
+
+func (r *GRPCRemote) handshake(ctx context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+	var addr = target.String()
+	// domain length limit
+	if len(addr) > 253 {
+		return nil, errors.New("target address's length large that 253.")
+	}
+	// 流的生命周期跟随 ctx，关闭连接时取消；握手超时也通过取消 ctx 实现
+	sctx, cancel := context.WithCancel(ctx)
+	timer := time.AfterFunc(config.HandshakeTimeout(), cancel)
+	// 依次尝试 out.remote_addr 中的地址，连接池按地址分别复用连接；
+	// 失败的请求会关闭请求体，每次尝试使用新的管道
+	var rsp *http.Response
+	var pw *io.PipeWriter
+	err := eachRemote(func(addr, host string) error {
+		pr, w := io.Pipe()
+		req, err := http.NewRequestWithContext(sctx, http.MethodPost, (&url.URL{
+			Scheme: "https",
+			Host:   addr,
+			Path:   common.GunPath(config.Config.Out.GRPCService),
+		}).String(), pr)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("Te", "trailers")
+		res, err := grpcTransport.RoundTrip(req)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != http.StatusOK || !strings.HasPrefix(res.Header.Get("Content-Type"), "application/grpc") {
+			_ = res.Body.Close()
+			_ = w.Close()
+			return common.Wrap(common.ErrProtocol, fmt.Errorf("grpc remote returned %s", res.Status))
+		}
+		rsp, pw = res, w
+		return nil
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	conn := common.NewGunConn(rsp.Body, pw, nil, func() {
+		_ = pw.Close()
+		_ = rsp.Body.Close()
+		cancel()
+	}, nil, nil)
+	// 与 TLS 出口相同的请求头：时间戳、协议、地址长度、地址，合并为一次写入
+	proto := requestProto(target)
+	head := make([]byte, 12, 12+len(addr))
+	binary.BigEndian.PutUint64(head, authTime())
+	binary.BigEndian.PutUint16(head[8:], proto)
+	binary.BigEndian.PutUint16(head[10:], uint16(len(addr)))
+	head = append(head, addr...)
+	ec := watchFeedback(ctx, watchClockSkew(ctx, common.NewChacha20Stream([]byte(config.OutUser()), conn)), target, proto)
+	if _, err = ec.Write(head); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if !timer.Stop() {
+		_ = conn.Close()
+		return nil, context.DeadlineExceeded
+	}
+	return ec, nil
+}
+
+func (r *GRPCRemote) Name() string {
+	return "GRPCRemote"
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	context2 "context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/utils/context"
+	"proxy/utils/logger"
+)
+
+// probeInterval 远端不可达期间探测恢复的间隔
+const probeInterval = 5 * time.Second
+
+// ErrRemoteDown 开启 out.kill_switch 且远端不可达时，本应走代理的连接直接被拒绝
+var ErrRemoteDown = common.Wrap(common.ErrRemoteUnreachable, errors.New("remote is unreachable, connection rejected by kill switch"))
+
+// remoteDown out.remote_addr 中的地址是否全部连不上：握手时所有地址都失败后标记，
+// 之后由后台探测或任意一次成功的握手恢复；期间按 out.kill_switch / out.fail_open 拒绝或直连
+var remoteDown atomic.Bool
+
+// RemoteDown 远端当前是否不可达
+func RemoteDown() bool {
+	return remoteDown.Load()
+}
+
+// markRemote 记录一次连接远端的结果，状态变化时输出日志，变为不可达时开始探测
+func markRemote(err error) {
+	if err == nil {
+		if remoteDown.CompareAndSwap(true, false) {
+			logger.Info(context.NewContext(), map[string]interface{}{
+				"action": config.ActionSocketOperate,
+			}, "remote is reachable again")
+		}
+		return
+	}
+	if remoteDown.CompareAndSwap(false, true) {
+		msg := "remote is unreachable"
+		switch {
+		case config.Config.Out.KillSwitch:
+			msg += ", rejecting proxied traffic until it recovers"
+		case config.Config.Out.FailOpen:
+			msg += ", proxied traffic goes direct until it recovers"
+		}
+		logger.Warn(context.NewContext(), map[string]interface{}{
+			"action":    config.ActionSocketOperate,
+			"errorCode": logger.ErrCodeHandshake,
+			"error":     err,
+		}, msg)
+		go probeRemote()
+	}
+}
+
+// probeRemote 每隔 probeInterval 连接一次远端，连上后恢复；QUIC 出口建立共享连接，KCP 出口没有握手，
+// 只检查地址能否解析，其余出口建立 TCP 连接
+func probeRemote() {
+	for remoteDown.Load() {
+		time.Sleep(probeInterval)
+		switch config.OutType() {
+		case config.RemoteTypeDirect, config.RemoteTypeSubscription:
+			// 重载后不再使用 out.remote_addr
+			remoteDown.Store(false)
+			return
+		case config.RemoteTypeQUIC:
+			_, _ = quicConn()
+			continue
+		case config.RemoteTypeKCP:
+			_ = eachRemote(func(addr, _ string) error {
+				c, err := dialKCP(addr)
+				if err != nil {
+					return err
+				}
+				return c.Close()
+			})
+			continue
+		}
+		dialer := common.GetOriginalInterfaceDialer()
+		_ = eachRemote(func(addr, host string) error {
+			c, err := dialRemote(context2.Background(), dialer, addr)
+			if err != nil {
+				return err
+			}
+			return c.Close()
+		})
+	}
+}
+
+// RejectRemote 开启 out.kill_switch 且远端不可达时代替代理出口，握手直接失败而不是改走直连或等待超时
+type RejectRemote struct {
+}
+
+func (r *RejectRemote) Handshake(ctx context2.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+	return nil, ErrRemoteDown
+}
+
+func (r *RejectRemote) Name() string {
+	return "RejectRemote"
+}
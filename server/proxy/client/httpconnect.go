@@ -0,0 +1,124 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"proxy/config"
+	"proxy/server/common"
+)
+
+// HTTPConnectRemote 经 out.http_proxy 上游 HTTP 代理的 CONNECT 隧道直接访问目标，
+// 用于只能经认证代理出网的企业网络；目标域名交给上游代理解析
+type HTTPConnectRemote struct {
+}
+
+// Handshake 握手失败时按 retry 配置重试
+func (r *HTTPConnectRemote) Handshake(ctx context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+	return withRetry(ctx, r.Name(), func() (io.ReadWriter, error) {
+		return r.handshake(ctx, target)
+	})
+}
+
+func (r *HTTPConnectRemote) handshake(ctx context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+	if target.Proto == 3 {
+		return nil, errors.New("http connect: udp is not supported")
+	}
+	if config.Config.Out.HTTPProxy.Addr == "" {
+		return nil, errors.New("out.http_proxy.addr is not configured")
+	}
+	return dialHTTPConnect(ctx, common.GetOriginalInterfaceDialer(), target.String())
+}
+
+func (r *HTTPConnectRemote) Name() string {
+	return "HTTPConnectRemote"
+}
+
+// dialRemote 建立到远端 addr 的 TCP 连接：配置了 out.http_proxy 时经上游代理的 CONNECT 隧道，否则由 dialer 直接连接，
+// 域名解析出多个地址时竞速连接
+func dialRemote(ctx context.Context, dialer *net.Dialer, addr string) (net.Conn, error) {
+	if config.Config.Out.HTTPProxy.Addr != "" {
+		return dialHTTPConnect(ctx, dialer, addr)
+	}
+	return dialRemoteTCP(ctx, dialer, addr)
+}
+
+// dialHTTPConnect 连接 out.http_proxy 并请求 CONNECT 到 addr，配置了用户名时附带 Basic 认证；
+// 代理回复 2xx 后返回的连接即为到 addr 的隧道，CONNECT 交互受 timeouts.handshake 限制
+func dialHTTPConnect(ctx context.Context, dialer *net.Dialer, addr string) (net.Conn, error) {
+	p := config.Config.Out.HTTPProxy
+	conn, err := dialer.DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		return nil, err
+	}
+	common.TuneTCP(conn)
+	if err = conn.SetDeadline(time.Now().Add(config.HandshakeTimeout())); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if p.Username != "" {
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(p.Username+":"+p.Password)))
+	}
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("http connect %s: %w", p.Addr, err)
+	}
+	resp.Body.Close()
+	// 407 / 403 为认证失败或被代理禁止，重试不会成功；只有 502 / 503 / 504 表示代理连不上目标，可以重试；其余非 2xx 按协议错误处理
+	switch {
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		conn.Close()
+		return nil, common.Wrap(common.ErrAuth, fmt.Errorf("http connect %s: proxy authentication failed", p.Addr))
+	case resp.StatusCode == http.StatusForbidden:
+		conn.Close()
+		return nil, common.Wrap(common.ErrAuth, fmt.Errorf("http connect %s: forbidden by proxy", p.Addr))
+	case resp.StatusCode == http.StatusBadGateway, resp.StatusCode == http.StatusServiceUnavailable, resp.StatusCode == http.StatusGatewayTimeout:
+		conn.Close()
+		return nil, common.Wrap(common.ErrRemoteUnreachable, fmt.Errorf("http connect %s: %s", p.Addr, resp.Status))
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		conn.Close()
+		return nil, common.Wrap(common.ErrProtocol, fmt.Errorf("http connect %s: %s", p.Addr, resp.Status))
+	}
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if br.Buffered() > 0 {
+		return &connectConn{Conn: conn, br: br}, nil
+	}
+	return conn, nil
+}
+
+// connectConn 应答头之后已缓冲的隧道数据先于连接上的数据读出
+type connectConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (c *connectConn) Read(p []byte) (int, error) {
+	return c.br.Read(p)
+}
+
+func (c *connectConn) CloseWrite() error {
+	return common.HalfClose(c.Conn)
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/kcp"
+)
+
+// KcpRemote KCP 出口：每个目标连接使用一个独立的 UDP 套接字与 KCP 会话，
+// 请求头与加密方式与 QUIC 出口相同，适合丢包较多的线路
+type KcpRemote struct {
+}
+
+// Handshake 握手失败时按 retry 配置重试
+func (r *KcpRemote) Handshake(ctx context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+	return withRetry(ctx, r.Name(), func() (io.ReadWriter, error) {
+		return r.handshake(ctx, target)
+	})
+}
+
+func (r *KcpRemote) handshake(ctx context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+	var addr = target.String()
+	// domain length limit
+	if len(addr) > 253 {
+		return nil, errors.New("target address's length large that 253.")
+	}
+	var conn *kcp.Conn
+	err := eachRemote(func(remote, _ string) error {
+		c, err := dialKCP(remote)
+		if err != nil {
+			return err
+		}
+		conn = c
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = conn.SetDeadline(time.Now().Add(config.HandshakeTimeout())); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	// 与 QUIC 出口相同的请求头：时间戳、协议、地址长度、地址，合并为一次写入
+	proto := requestProto(target)
+	head := make([]byte, 12, 12+len(addr))
+	binary.BigEndian.PutUint64(head, authTime())
+	binary.BigEndian.PutUint16(head[8:], proto)
+	binary.BigEndian.PutUint16(head[10:], uint16(len(addr)))
+	head = append(head, addr...)
+	ec := watchFeedback(ctx, watchClockSkew(ctx, common.NewChacha20Stream([]byte(config.OutUser()), conn)), target, proto)
+	if _, err = ec.Write(head); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return ec, nil
+}
+
+func (r *KcpRemote) Name() string {
+	return "KCPRemote"
+}
+
+// dialKCP 经原默认接口在新的 UDP 套接字上与 addr 建立 KCP 会话并打开一条流；KCP 没有握手，
+// 只有地址解析或套接字创建失败时才换用下一个地址，远端不可达在读取应答时才会发现
+func dialKCP(addr string) (*kcp.Conn, error) {
+	dialer := common.GetOriginalInterfaceDialer()
+	ctx, cancel := context.WithTimeout(context.Background(), dialer.Timeout)
+	defer cancel()
+	network := "udp"
+	if config.IPv4Only() {
+		network = "udp4"
+	}
+	udpAddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	// 本地套接字与远端地址族一致，按网卡绑定时才能选对 IPv4/IPv6 的选项
+	network = "udp6"
+	if udpAddr.IP.To4() != nil {
+		network = "udp4"
+	}
+	var local string
+	if tcpAddr, ok := dialer.LocalAddr.(*net.TCPAddr); ok && tcpAddr != nil {
+		local = net.JoinHostPort(tcpAddr.IP.String(), "0")
+	}
+	lc := net.ListenConfig{Control: dialer.Control}
+	pc, err := lc.ListenPacket(ctx, network, local)
+	if err != nil {
+		return nil, err
+	}
+	return kcp.Dial(pc, udpAddr, common.KCPOptions())
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/subscription"
+	"proxy/utils/logger"
+)
+
+// NodeRemote 通过订阅中当前选中的节点（Shadowsocks / Trojan）转发，对应 out.type 4
+type NodeRemote struct {
+}
+
+func (r *NodeRemote) Handshake(ctx context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+	// 在函数退出前，执行defer
+	// 捕捉异常后，程序不会异常退出
+	defer func() {
+		err := recover() // 内置函数，可以捕捉到函数异常
+		if err != nil {
+			logger.Error(ctx, map[string]interface{}{
+				"action":    config.ActionRequestBegin,
+				"errorCode": logger.ErrCodeHandshake,
+				"error":     err,
+			})
+		}
+	}()
+	node, err := subscription.Selected()
+	if nil != err {
+		return nil, err
+	}
+	if target.Proto == 3 {
+		return nil, fmt.Errorf("node %s: udp is not supported", node.Name)
+	}
+	if err := node.Supported(); err != nil {
+		return nil, err
+	}
+	// 使用绑定到原默认接口的 Dialer，确保不走 TUN
+	conn, err := common.GetOriginalInterfaceDialer().Dial("tcp", node.Addr())
+	if nil != err {
+		return nil, err
+	}
+	common.TuneTCP(conn)
+	// 协议握手受 timeouts.handshake 限制
+	if err := conn.SetDeadline(time.Now().Add(config.HandshakeTimeout())); nil != err {
+		conn.Close()
+		return nil, err
+	}
+	var rw io.ReadWriter
+	switch node.Protocol {
+	case subscription.ProtocolShadowsocks:
+		rw, err = newShadowsocksConn(conn, node, target)
+	default:
+		rw, err = newTrojanConn(conn, node, target)
+	}
+	if nil == err {
+		err = conn.SetDeadline(time.Time{})
+	}
+	if nil != err {
+		conn.Close()
+		return nil, fmt.Errorf("node %s: %w", node.Name, err)
+	}
+	return rw, nil
+}
+
+func (r *NodeRemote) Name() string {
+	return "NodeRemote"
+}
@@ -0,0 +1,170 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"proxy/config"
+	"proxy/server/common"
+)
+
+// quicSession 到 out.remote_addr 中某个地址的共享 QUIC 连接，各目标连接在其上各开一个流
+var quicSession struct {
+	sync.Mutex
+	conn *quic.Conn
+	addr string
+}
+
+// quicSessionCache 跨连接复用 TLS 会话票据，重连时可发送 0-RTT 数据
+var quicSessionCache = tls.NewLRUClientSessionCache(32)
+
+type QuicRemote struct {
+}
+
+// Handshake 握手失败时按 retry 配置重试
+func (r *QuicRemote) Handshake(ctx context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+	return withRetry(ctx, r.Name(), func() (io.ReadWriter, error) {
+		return r.handshake(ctx, target)
+	})
+}
+
+func (r *QuicRemote) handshake(ctx context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+	var addr = target.String()
+	// domain length limit
+	if len(addr) > 253 {
+		return nil, errors.New("target address's length large that 253.")
+	}
+	stream, err := openQuicStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = stream.SetDeadline(time.Now().Add(config.HandshakeTimeout())); err != nil {
+		_ = stream.Close()
+		return nil, err
+	}
+	// 与 TLS 出口相同的请求头：时间戳、协议、地址长度、地址，合并为一次写入
+	proto := requestProto(target)
+	head := make([]byte, 12, 12+len(addr))
+	binary.BigEndian.PutUint64(head, authTime())
+	binary.BigEndian.PutUint16(head[8:], proto)
+	binary.BigEndian.PutUint16(head[10:], uint16(len(addr)))
+	head = append(head, addr...)
+	ec := watchFeedback(ctx, watchClockSkew(ctx, common.NewChacha20Stream([]byte(config.OutUser()), stream)), target, proto)
+	if _, err = ec.Write(head); err != nil {
+		_ = stream.Close()
+		return nil, err
+	}
+	if err = stream.SetDeadline(time.Time{}); err != nil {
+		_ = stream.Close()
+		return nil, err
+	}
+	return ec, nil
+}
+
+func (r *QuicRemote) Name() string {
+	return "QUICRemote"
+}
+
+// openQuicStream 在共享连接上开一个流；连接已断开时重新建立一次
+func openQuicStream(ctx context.Context) (*common.QuicConn, error) {
+	for retry := 0; ; retry++ {
+		conn, err := quicConn()
+		if err != nil {
+			return nil, err
+		}
+		sctx, cancel := context.WithTimeout(ctx, config.HandshakeTimeout())
+		stream, err := conn.OpenStreamSync(sctx)
+		cancel()
+		if err == nil {
+			return common.NewQuicConn(stream, conn, nil), nil
+		}
+		if conn.Context().Err() == nil || retry > 0 {
+			return nil, err
+		}
+	}
+}
+
+// quicConn 返回可用的共享连接，没有时依次尝试 out.remote_addr 中的地址建立新连接
+func quicConn() (*quic.Conn, error) {
+	quicSession.Lock()
+	defer quicSession.Unlock()
+	if c := quicSession.conn; c != nil {
+		if c.Context().Err() == nil && slices.Contains(config.RemoteAddrs(), quicSession.addr) {
+			return c, nil
+		}
+		_ = c.CloseWithError(0, "")
+		quicSession.conn = nil
+	}
+	err := eachRemote(func(addr, host string) error {
+		conn, err := dialQuic(addr, host)
+		if err != nil {
+			return err
+		}
+		quicSession.conn, quicSession.addr = conn, addr
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return quicSession.conn, nil
+}
+
+// dialQuic 经原默认接口连接 addr（有会话票据时走 0-RTT），host 用作 SNI
+func dialQuic(addr, host string) (*quic.Conn, error) {
+	dialer := common.GetOriginalInterfaceDialer()
+	ctx, cancel := context.WithTimeout(context.Background(), dialer.Timeout)
+	defer cancel()
+	network := "udp"
+	if config.IPv4Only() {
+		network = "udp4"
+	}
+	udpAddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	// 本地套接字与远端地址族一致，按网卡绑定时才能选对 IPv4/IPv6 的选项
+	network = "udp6"
+	if udpAddr.IP.To4() != nil {
+		network = "udp4"
+	}
+	var local string
+	if tcpAddr, ok := dialer.LocalAddr.(*net.TCPAddr); ok && tcpAddr != nil {
+		local = net.JoinHostPort(tcpAddr.IP.String(), "0")
+	}
+	lc := net.ListenConfig{Control: dialer.Control}
+	tlsConfig, err := withClientAuth(&tls.Config{
+		ServerName:         host,
+		NextProtos:         []string{common.QuicALPN},
+		ClientSessionCache: quicSessionCache,
+		MinVersion:         tls.VersionTLS13,
+	})
+	if err != nil {
+		return nil, err
+	}
+	pc, err := lc.ListenPacket(ctx, network, local)
+	if err != nil {
+		return nil, err
+	}
+	tr := &quic.Transport{Conn: pc}
+	conn, err := tr.DialEarly(ctx, udpAddr, tlsConfig, common.QuicConfig())
+	if err != nil {
+		_ = tr.Close()
+		_ = pc.Close()
+		return nil, err
+	}
+	// 连接断开后释放 UDP 端口
+	go func() {
+		<-conn.Context().Done()
+		_ = tr.Close()
+		_ = pc.Close()
+	}()
+	return conn, nil
+}
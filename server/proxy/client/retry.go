@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/utils/logger"
+)
+
+// withRetry 执行出口握手，遇到网络错误时按 retry 配置退避后重试；证书校验失败等不会因重试而成功的错误直接返回，
+// 退避期间 ctx 取消（客户端断开、服务关闭）时不再重试
+func withRetry(ctx context.Context, name string, handshake func() (io.ReadWriter, error)) (io.ReadWriter, error) {
+	attempts := config.RetryAttempts()
+	for i := 0; ; i++ {
+		rw, err := handshake()
+		if err == nil || i >= attempts || !retryable(err) {
+			return rw, err
+		}
+		delay := config.RetryBackoff(i + 1)
+		logger.Warn(ctx, map[string]interface{}{
+			"action":    config.ActionRequestBegin,
+			"errorCode": logger.ErrCodeHandshake,
+			"error":     err,
+			"remote":    name,
+			"attempt":   i + 1,
+			"delay":     delay.String(),
+		}, "remote handshake failed, retrying")
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// retryable 超时、连接被拒绝、重置或握手中途断开等网络错误可以重试；认证失败、协议错误、
+// 域名不存在与 kill switch 拒绝不会因重试而成功，直接返回
+func retryable(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound || errors.Is(err, ErrRemoteDown) {
+		return false
+	}
+	switch common.Classify(err) {
+	case common.ErrAuth, common.ErrProtocol:
+		return false
+	case common.ErrTimeout, common.ErrRemoteUnreachable:
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// FallbackRemote 代理出口重试后仍握手失败时改用 retry.fallback 指定的出口
+type FallbackRemote struct {
+	Primary  common.Remote
+	Fallback common.Remote
+}
+
+func (r *FallbackRemote) Handshake(ctx context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+	rw, err := r.Primary.Handshake(ctx, target)
+	if err == nil {
+		return rw, nil
+	}
+	logger.Warn(ctx, map[string]interface{}{
+		"action":    config.ActionRequestBegin,
+		"errorCode": logger.ErrCodeHandshake,
+		"error":     err,
+		"remote":    r.Primary.Name(),
+		"fallback":  r.Fallback.Name(),
+	}, "remote handshake failed, using fallback")
+	return r.Fallback.Handshake(ctx, target)
+}
+
+// Name 沿用主出口的名称，指标与连接列表中仍按主出口统计
+func (r *FallbackRemote) Name() string {
+	return r.Primary.Name()
+}
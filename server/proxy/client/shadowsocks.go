@@ -0,0 +1,32 @@
+package client
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/xjasonlyu/tun2socks/v2/transport/shadowsocks/core"
+	obfs "github.com/xjasonlyu/tun2socks/v2/transport/simple-obfs"
+	"github.com/xjasonlyu/tun2socks/v2/transport/socks5"
+
+	"proxy/server/common"
+	"proxy/server/subscription"
+)
+
+// newShadowsocksConn 按节点的加密方式与 simple-obfs 插件包装连接，并发送目标地址
+func newShadowsocksConn(conn net.Conn, node *subscription.Node, target *common.TargetAddr) (net.Conn, error) {
+	cipher, err := core.PickCipher(node.Method, nil, node.Password)
+	if err != nil {
+		return nil, err
+	}
+	switch mode, host := node.Obfs(); mode {
+	case "tls":
+		conn = obfs.NewTLSObfs(conn, host)
+	case "http":
+		conn = obfs.NewHTTPObfs(conn, host, strconv.Itoa(node.Port))
+	}
+	conn = cipher.StreamConn(conn)
+	if _, err := conn.Write(socks5.ParseAddrString(target.String())); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
@@ -1,23 +1,34 @@
 package client
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"net"
 	"time"
 
 	"github.com/go-errors/errors"
 	"proxy/config"
 	"proxy/server/common"
-	"proxy/utils/context"
 	"proxy/utils/logger"
 )
 
 type TlsRemote struct {
 }
 
-func (r *TlsRemote) Handshake(ctx *context.Context, target *common.TargetAddr) (ec io.ReadWriter, err error) {
+// tlsSessionCache 各次握手共用，再次连接远端时恢复 TLS 会话，省去证书传输与校验
+var tlsSessionCache = tls.NewLRUClientSessionCache(128)
+
+// Handshake 握手失败时按 retry 配置重试
+func (r *TlsRemote) Handshake(ctx context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+	return withRetry(ctx, r.Name(), func() (io.ReadWriter, error) {
+		return r.handshake(ctx, target)
+	})
+}
+
+func (r *TlsRemote) handshake(ctx context.Context, target *common.TargetAddr) (ec io.ReadWriter, err error) {
 	// 在函数退出前，执行defer
 	// 捕捉异常后，程序不会异常退出
 	defer func() {
@@ -33,51 +44,60 @@ func (r *TlsRemote) Handshake(ctx *context.Context, target *common.TargetAddr) (
 			fmt.Println(string(errors.Wrap(err, 3).Stack()))
 		}
 	}()
-	// 使用绑定到原默认接口的 Dialer，确保不走 TUN
-	dialer := common.GetOriginalInterfaceDialer()
-	conn, err := dialer.Dial("tcp", fmt.Sprintf("%s:%s", config.Config.Out.RemoteAddr, "443"))
-	if nil != err {
-		return nil, err
-	}
-	cc := tls.Client(conn, &tls.Config{
-		ServerName:         config.Config.Out.RemoteAddr,
-		ClientSessionCache: tls.NewLRUClientSessionCache(128),
-		MinVersion:         tls.VersionTLS13,
-		MaxVersion:         tls.VersionTLS13,
+	// 使用绑定到原默认接口的 Dialer，确保不走 TUN；开启 tcp.fast_open 时 ClientHello 随 SYN 发出
+	dialer := common.FastOpenDialer(common.GetOriginalInterfaceDialer())
+	// 依次尝试 out.remote_addr 中的地址，TLS 握手失败同样换下一个
+	var conn net.Conn
+	var cc *tls.Conn
+	err = eachRemote(func(addr, host string) error {
+		c, err := dialRemote(ctx, dialer, addr)
+		if nil != err {
+			return err
+		}
+		common.TuneTCP(c)
+		// TLS 握手与写入认证头共用 timeouts.handshake
+		if err = c.SetDeadline(time.Now().Add(config.HandshakeTimeout())); nil != err {
+			c.Close()
+			return err
+		}
+		cfg, err := withClientAuth(&tls.Config{
+			ServerName:         host,
+			ClientSessionCache: tlsSessionCache,
+			MinVersion:         tls.VersionTLS13,
+			MaxVersion:         tls.VersionTLS13,
+		})
+		if nil != err {
+			c.Close()
+			return err
+		}
+		tc := tls.Client(c, cfg)
+		if err = tc.Handshake(); nil != err {
+			c.Close()
+			return err
+		}
+		conn, cc = c, tc
+		return nil
 	})
-	err = cc.Handshake()
-	if nil != err {
-		return nil, err
-	}
-	ec = common.NewChacha20Stream([]byte(config.Config.User), cc)
-	tBuf := make([]byte, 8)
-	binary.BigEndian.PutUint64(tBuf, uint64(time.Now().Unix()))
-	_, err = ec.Write(tBuf)
-	if nil != err {
-		return nil, err
-	}
-	pBuf := make([]byte, 2)
-	binary.BigEndian.PutUint16(pBuf, target.Proto)
-	_, err = ec.Write(pBuf)
 	if nil != err {
 		return nil, err
 	}
+	proto := requestProto(target)
+	ec = watchFeedback(ctx, watchClockSkew(ctx, common.NewChacha20Stream([]byte(config.OutUser()), cc)), target, proto)
 	var addr = target.String()
-	var l = int16(len(addr))
 	// domain length limit
-	if l > 253 {
+	if len(addr) > 253 {
 		return nil, errors.New("target address's length large that 253.")
 	}
-	buf := make([]byte, 2)
-	binary.BigEndian.PutUint16(buf, uint16(l))
-	// write domain length
-	_, err = ec.Write(buf)
-	if nil != err {
+	// 时间戳、协议、地址长度与地址一次写入，只产生一个 TLS 记录，紧随客户端 Finished 发出，不等待服务端应答
+	header := make([]byte, 0, 12+len(addr))
+	header = binary.BigEndian.AppendUint64(header, authTime())
+	header = binary.BigEndian.AppendUint16(header, proto)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addr)))
+	header = append(header, addr...)
+	if _, err = ec.Write(header); nil != err {
 		return nil, err
 	}
-	// write domain
-	_, err = ec.Write([]byte(addr))
-	if nil != err {
+	if err = conn.SetDeadline(time.Time{}); nil != err {
 		return nil, err
 	}
 
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/xjasonlyu/tun2socks/v2/transport/socks5"
+
+	"proxy/config"
+	"proxy/server/common"
+)
+
+// torIsolationPassword SOCKS 认证的密码，Tor 只按用户名与密码区分线路，内容不做校验
+const torIsolationPassword = "celestial-ladder"
+
+// TorRemote 经本机 Tor 的 SOCKS 端口转发，用于命中 tor.rules 的目标与 .onion 域名；
+// 以目标主机名作为 SOCKS 用户名，Tor 默认的 IsolateSOCKSAuth 据此为每个目标使用独立的线路，
+// 域名交给 Tor 解析，不经 DoH 或本机 DNS
+type TorRemote struct {
+}
+
+// Handshake 握手失败时按 retry 配置重试
+func (r *TorRemote) Handshake(ctx context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+	return withRetry(ctx, r.Name(), func() (io.ReadWriter, error) {
+		return r.handshake(target)
+	})
+}
+
+func (r *TorRemote) handshake(target *common.TargetAddr) (io.ReadWriter, error) {
+	if target.Proto == 3 {
+		return nil, errors.New("tor: udp is not supported")
+	}
+	addr := socks5.ParseAddrString(target.String())
+	if addr == nil {
+		return nil, errors.New("tor: invalid target " + target.String())
+	}
+	// Tor 的 SOCKS 端口在本机，不需要绑定原默认接口
+	conn, err := net.DialTimeout("tcp", config.TorSocks(), config.DialTimeout())
+	if nil != err {
+		return nil, err
+	}
+	common.TuneTCP(conn)
+	// 建立线路可能需要数秒，握手受 timeouts.dial 限制
+	if err := conn.SetDeadline(time.Now().Add(config.DialTimeout())); nil != err {
+		conn.Close()
+		return nil, err
+	}
+	user := &socks5.User{Username: target.Host(), Password: torIsolationPassword}
+	if _, err = socks5.ClientHandshake(conn, addr, socks5.CmdConnect, user); nil == err {
+		err = conn.SetDeadline(time.Time{})
+	}
+	if nil != err {
+		conn.Close()
+		return nil, fmt.Errorf("tor: %w", err)
+	}
+	return conn, nil
+}
+
+func (r *TorRemote) Name() string {
+	return "TorRemote"
+}
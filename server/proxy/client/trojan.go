@@ -0,0 +1,44 @@
+package client
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+
+	"github.com/xjasonlyu/tun2socks/v2/transport/socks5"
+
+	"proxy/server/common"
+	"proxy/server/subscription"
+)
+
+// trojanSessionCache 各次握手共用，再次连接节点时恢复 TLS 会话；缓存按服务器名区分，切换节点不受影响
+var trojanSessionCache = tls.NewLRUClientSessionCache(128)
+
+// newTrojanConn 在 TLS 之上发送 Trojan 请求头：hex(SHA224(password)) CRLF CMD ATYP 地址 端口 CRLF
+func newTrojanConn(conn net.Conn, node *subscription.Node, target *common.TargetAddr) (net.Conn, error) {
+	serverName := node.SNI
+	if serverName == "" {
+		serverName = node.Server
+	}
+	tc := tls.Client(conn, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: node.Insecure,
+		ClientSessionCache: trojanSessionCache,
+		MinVersion:         tls.VersionTLS12,
+	})
+	if err := tc.Handshake(); err != nil {
+		return nil, err
+	}
+	addr := socks5.ParseAddrString(target.String())
+	sum := sha256.Sum224([]byte(node.Password))
+	header := make([]byte, 0, hex.EncodedLen(len(sum))+len(addr)+5)
+	header = hex.AppendEncode(header, sum[:])
+	header = append(header, '\r', '\n', 0x01) // CMD 0x01: CONNECT
+	header = append(header, addr...)
+	header = append(header, '\r', '\n')
+	if _, err := tc.Write(header); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
@@ -1,11 +1,14 @@
 package client
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/base64"
 	"encoding/binary"
-	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"net/url"
 	"time"
 
@@ -13,14 +16,20 @@ import (
 	"github.com/pkg/errors"
 	"proxy/config"
 	"proxy/server/common"
-	"proxy/utils/context"
 	"proxy/utils/logger"
 )
 
 type WSSRemote struct {
 }
 
-func (r *WSSRemote) Handshake(ctx *context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+// Handshake 握手失败时按 retry 配置重试
+func (r *WSSRemote) Handshake(ctx context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+	return withRetry(ctx, r.Name(), func() (io.ReadWriter, error) {
+		return r.handshake(ctx, target)
+	})
+}
+
+func (r *WSSRemote) handshake(ctx context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
 	// 在函数退出前，执行defer
 	// 捕捉异常后，程序不会异常退出
 	defer func() {
@@ -34,36 +43,32 @@ func (r *WSSRemote) Handshake(ctx *context.Context, target *common.TargetAddr) (
 			})
 		}
 	}()
-	// 使用绑定到原默认接口的 Dialer，确保不走 TUN
-	dialer := common.GetOriginalInterfaceDialer()
-	
-	// 创建自定义 Dialer，绑定到原接口
-	wsDialer := &websocket.Dialer{
-		NetDial: func(network, addr string) (net.Conn, error) {
-			return dialer.Dial(network, addr)
-		},
-		TLSClientConfig: &tls.Config{
-			ServerName:         config.Config.Out.RemoteAddr,
-			ClientSessionCache: tls.NewLRUClientSessionCache(128),
-			MinVersion:         tls.VersionTLS13,
-			MaxVersion:         tls.VersionTLS13,
-		},
+	header := http.Header{}
+	for k, v := range config.Config.Out.WSS.Headers {
+		header.Set(k, v)
+	}
+	if config.Config.Out.WSS.CDN {
+		return dialWSSEarly(ctx, header, target)
 	}
-	
-	u := url.URL{Scheme: "wss", Host: fmt.Sprintf("%s:%s", config.Config.Out.RemoteAddr, "443"), Path: "/"}
-	c, _, err := wsDialer.Dial(u.String(), nil)
+	c, err := dialWSS(ctx, header)
 	if nil != err {
 		return nil, err
 	}
-	ec := common.NewChacha20Stream([]byte(config.Config.User), c.UnderlyingConn())
+	// 写入认证头同样受 timeouts.handshake 限制
+	if err = c.UnderlyingConn().SetDeadline(time.Now().Add(config.HandshakeTimeout())); nil != err {
+		c.Close()
+		return nil, err
+	}
+	proto := requestProto(target)
+	ec := watchFeedback(ctx, watchClockSkew(ctx, common.NewChacha20Stream([]byte(config.OutUser()), c.UnderlyingConn())), target, proto)
 	tBuf := make([]byte, 8)
-	binary.BigEndian.PutUint64(tBuf, uint64(time.Now().Unix()))
+	binary.BigEndian.PutUint64(tBuf, authTime())
 	_, err = ec.Write(tBuf)
 	if nil != err {
 		return nil, err
 	}
 	pBuf := make([]byte, 2)
-	binary.BigEndian.PutUint16(pBuf, target.Proto)
+	binary.BigEndian.PutUint16(pBuf, proto)
 	_, err = ec.Write(pBuf)
 	if nil != err {
 		return nil, err
@@ -86,6 +91,9 @@ func (r *WSSRemote) Handshake(ctx *context.Context, target *common.TargetAddr) (
 	if nil != err {
 		return nil, err
 	}
+	if err = c.UnderlyingConn().SetDeadline(time.Time{}); nil != err {
+		return nil, err
+	}
 
 	return ec, err
 }
@@ -93,3 +101,106 @@ func (r *WSSRemote) Handshake(ctx *context.Context, target *common.TargetAddr) (
 func (r *WSSRemote) Name() string {
 	return "WSSRemote"
 }
+
+// dialWSS 依次尝试 out.remote_addr 中的地址完成 WebSocket 升级
+func dialWSS(ctx context.Context, header http.Header) (*websocket.Conn, error) {
+	opts := config.Config.Out.WSS
+	path := opts.Path
+	if path == "" {
+		path = "/"
+	}
+	u, err := url.Parse(path)
+	if nil != err {
+		return nil, err
+	}
+	// 使用绑定到原默认接口的 Dialer，确保不走 TUN
+	dialer := common.GetOriginalInterfaceDialer()
+	var c *websocket.Conn
+	err = eachRemote(func(addr, host string) error {
+		// 未配置 host 时 Host 头与 SNI 都使用 remote_addr 的域名
+		serverName := host
+		if opts.Host != "" {
+			serverName = opts.Host
+		}
+		h := header.Clone()
+		h.Set("Host", serverName)
+		tlsConfig, err := withClientAuth(&tls.Config{
+			ServerName:         serverName,
+			ClientSessionCache: tls.NewLRUClientSessionCache(128),
+			MinVersion:         tls.VersionTLS13,
+			MaxVersion:         tls.VersionTLS13,
+		})
+		if nil != err {
+			return err
+		}
+		// 创建自定义 Dialer，绑定到原接口
+		wsDialer := &websocket.Dialer{
+			NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := dialRemote(ctx, dialer, addr)
+				if err == nil {
+					common.TuneTCP(conn)
+				}
+				return conn, err
+			},
+			HandshakeTimeout: config.HandshakeTimeout(),
+			TLSClientConfig:  tlsConfig,
+		}
+		// 始终连接 remote_addr，Host 头可以不同（经 CDN 转发）
+		u.Scheme, u.Host = "wss", addr
+		conn, _, err := wsDialer.DialContext(ctx, u.String(), h)
+		if nil != err {
+			return err
+		}
+		c = conn
+		return nil
+	})
+	return c, err
+}
+
+// dialWSSEarly CDN 模式：认证头加密后作为早期数据放入 Sec-WebSocket-Protocol，随升级请求一起发出，
+// 省去一次往返；之后的数据按二进制消息收发，能经过只转发完整 WebSocket 帧的 CDN
+func dialWSSEarly(ctx context.Context, header http.Header, target *common.TargetAddr) (io.ReadWriter, error) {
+	var addr = target.String()
+	// domain length limit
+	if len(addr) > 253 {
+		return nil, errors.New("target address's length large that 253.")
+	}
+	proto := requestProto(target)
+	head := make([]byte, 12, 12+len(addr))
+	binary.BigEndian.PutUint64(head, authTime())
+	binary.BigEndian.PutUint16(head[8:], proto)
+	binary.BigEndian.PutUint16(head[10:], uint16(len(addr)))
+	head = append(head, addr...)
+	early := &earlyConn{}
+	ec := watchFeedback(ctx, watchClockSkew(ctx, common.NewChacha20Stream([]byte(config.OutUser()), early)), target, proto)
+	if _, err := ec.Write(head); nil != err {
+		return nil, err
+	}
+	header.Set("Sec-Websocket-Protocol", base64.RawURLEncoding.EncodeToString(early.buf.Bytes()))
+	c, err := dialWSS(ctx, header)
+	if nil != err {
+		return nil, err
+	}
+	early.Conn = common.NewWSConn(c, nil)
+	return ec, nil
+}
+
+// earlyConn 连接建立前把写入的数据缓存为早期数据，建立后绑定 Conn，读写都交给它
+type earlyConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *earlyConn) Write(p []byte) (int, error) {
+	if c.Conn == nil {
+		return c.buf.Write(p)
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *earlyConn) SetWriteDeadline(t time.Time) error {
+	if c.Conn == nil {
+		return nil
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/conntrack"
+	"proxy/server/route"
+	"proxy/utils/logger"
+)
+
+// access 一条连接的访问记录，连接结束时由 log 输出
+type access struct {
+	inbound  string
+	source   string
+	target   *common.TargetAddr
+	decision *route.Decision
+	track    *conntrack.Conn // 出口握手成功后设置
+	err      error
+}
+
+func newAccess(inbound, source string, target *common.TargetAddr, decision *route.Decision) *access {
+	return &access{
+		inbound:  inbound,
+		source:   source,
+		target:   target,
+		decision: decision,
+	}
+}
+
+// log 输出访问日志，耗时由 logger 按 ctx 自动附带；未开启 log.access 时不输出
+func (a *access) log(ctx context.Context) {
+	if !config.Config.Log.Access {
+		return
+	}
+	fields := map[string]interface{}{
+		"action":  config.ActionRequestEnd,
+		"inbound": a.inbound,
+		"source":  a.source,
+		"target":  a.target.String(),
+		"remote":  a.decision.Remote.Name(),
+		"reason":  a.decision.Reason,
+	}
+	if a.decision.Rule != "" {
+		fields["rule"] = a.decision.Rule
+	}
+	if a.decision.Hosts != "" {
+		fields["hosts"] = a.decision.Hosts
+	}
+	if a.decision.Rewrite != "" {
+		fields["rewrite"] = a.decision.Rewrite
+	}
+	if a.decision.Resolve != "" {
+		fields["resolve"] = a.decision.Resolve
+	}
+	if ip := a.target.IP; ip != nil {
+		fields["ip"] = ip.String()
+	} else if a.decision.IP != nil {
+		fields["ip"] = a.decision.IP.String()
+	}
+	if user := userOf(ctx); user != "" {
+		fields["user"] = user
+	}
+	if a.track != nil {
+		fields["upBytes"] = a.track.Up.Value()
+		fields["downBytes"] = a.track.Down.Value()
+	}
+	if a.err != nil {
+		fields["error"] = a.err.Error()
+		fields["errorClass"] = common.ErrorClass(a.err)
+	}
+	logger.Info(ctx, fields, "access")
+}
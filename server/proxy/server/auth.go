@@ -0,0 +1,105 @@
+package server
+
+import (
+	context2 "context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/quota"
+)
+
+// userKey 连接上下文中鉴权用户名的键，值为 connContext 预留的 *string，由握手时的 acceptUser 填写
+type userKey struct{}
+
+// errUnsupportedProto 认证头中的协议既不是 TCP 也不是 UDP
+var errUnsupportedProto = common.Wrap(common.ErrProtocol, errors.New("not support."))
+
+// acceptUser 读取客户端加密的时间戳，依次用各用户的密钥试解密，时间差在 in.time_window 内且 nonce 未出现过即认定为该用户；
+// 时间差超出 in.time_window 但在 maxClockSkew 内时回复本机时间供客户端校正后拒绝。
+// 用户名写入 connContext 预留的位置，超出配额且未配置超额限速的用户直接拒绝
+func acceptUser(ctx context2.Context, conn net.Conn) (*common.Chacha20Stream, error) {
+	users := quota.Users()
+	keys := make([][]byte, len(users))
+	for i, u := range users {
+		keys[i] = u.Key
+	}
+	tBuf := make([]byte, 8)
+	now := time.Now().Unix()
+	var skew int64
+	ec, i, err := common.AcceptChacha20Stream(keys, conn, tBuf, func(head []byte) bool {
+		skew = int64(binary.BigEndian.Uint64(head)) - now
+		return skew >= -int64(maxClockSkew/time.Second) && skew <= int64(maxClockSkew/time.Second)
+	})
+	if nil != err {
+		return nil, errors.Wrap(err, "unknown user or the time between server and client is not same")
+	}
+	if err := recordNonce(ctx, ec.Nonce(), now+skew); err != nil {
+		return nil, common.Wrap(common.ErrAuth, errors.Wrap(err, users[i].Name))
+	}
+	if window := int64(config.AuthTimeWindow() / time.Second); skew < -window || skew > window {
+		_ = common.WriteClockSkew(ec)
+		return nil, common.Wrap(common.ErrAuth, errors.Errorf("clock of %s differs from server by %ds", users[i].Name, skew))
+	}
+	name := users[i].Name
+	if err := quota.Accept(name, conn.RemoteAddr().String()); err != nil {
+		return nil, errors.Wrap(err, name)
+	}
+	if user, ok := ctx.Value(userKey{}).(*string); ok {
+		*user = name
+	}
+	return ec, nil
+}
+
+// userOf 鉴权时写入 ctx 的用户名，未鉴权时为空
+func userOf(ctx context2.Context) string {
+	if user, ok := ctx.Value(userKey{}).(*string); ok {
+		return *user
+	}
+	return ""
+}
+
+// acceptRequest 在已加密的传输（QUIC 流、gRPC 流、KCP 会话）上认证用户并读取协议与目标地址，整个过程受 timeouts.handshake 限制
+func acceptRequest(ctx context2.Context, conn net.Conn) (io.ReadWriter, *common.TargetAddr, error) {
+	if err := conn.SetDeadline(time.Now().Add(config.HandshakeTimeout())); err != nil {
+		return nil, nil, err
+	}
+	defer conn.SetDeadline(time.Time{})
+	ec, err := acceptUser(ctx, conn)
+	if nil != err {
+		return nil, nil, err
+	}
+	head := make([]byte, 4)
+	if _, err = io.ReadFull(ec, head); nil != err {
+		return nil, nil, err
+	}
+	proto := binary.BigEndian.Uint16(head)
+	if !common.ValidProto(proto) {
+		return nil, nil, errUnsupportedProto
+	}
+	addrBuf := make([]byte, binary.BigEndian.Uint16(head[2:]))
+	if _, err = io.ReadFull(ec, addrBuf); nil != err {
+		return nil, nil, err
+	}
+	host, portStr, err := net.SplitHostPort(string(addrBuf))
+	if nil != err {
+		return nil, nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if nil != err {
+		return nil, nil, err
+	}
+	target := &common.TargetAddr{Port: port}
+	target.Proto, target.Feedback = common.SplitProto(proto)
+	if ip := net.ParseIP(host); ip != nil {
+		target.IP = ip
+	} else {
+		target.Name = host
+	}
+	return ec, target, nil
+}
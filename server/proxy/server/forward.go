@@ -0,0 +1,105 @@
+package server
+
+import (
+	context2 "context"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/conntrack"
+	"proxy/server/metrics"
+	"proxy/server/route"
+	"proxy/server/tracing"
+	"proxy/utils/logger"
+)
+
+// ForwardServer 静态端口转发（forward 配置中的一条）：收到的连接不做代理协议握手，
+// 经 Via 指定的出口（0 表示按分流规则选择）转发到固定的 Target
+type ForwardServer struct {
+	Target string
+	Via    int8
+}
+
+func (s *ForwardServer) Start(ctx context2.Context, l net.Listener) {
+	closeOnDone(ctx, l)
+	for {
+		conn, err := l.Accept()
+		// 监听已关闭（重载时规则变化）则退出，已建立的连接不受影响
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if nil != err {
+			continue
+		}
+		go s.serve(ctx, conn)
+	}
+}
+
+func (s *ForwardServer) serve(ctx context2.Context, conn net.Conn) {
+	defer conn.Close()
+	gCtx, cancel := connContext(ctx)
+	defer cancel()
+	defer metrics.TrackConnection(s.Name())()
+	gCtx, connSpan := tracing.Start(gCtx, s.Name())
+	defer connSpan.End(nil)
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(gCtx, map[string]interface{}{
+				"action":    config.ActionRequestBegin,
+				"errorCode": logger.ErrCodeHandshake,
+				"error":     err,
+			})
+		}
+	}()
+	target, err := common.NewTargetAddr(s.Target)
+	if nil != err {
+		logger.Error(gCtx, map[string]interface{}{
+			"action":    config.ActionRequestBegin,
+			"errorCode": logger.ErrCodeHandshake,
+			"error":     err,
+			"target":    s.Target,
+		})
+		return
+	}
+	target.Proto = 1
+	var decision *route.Decision
+	if s.Via == 0 {
+		decision = route.Decide(gCtx, target)
+	} else {
+		decision = &route.Decision{Remote: route.ViaRemote(s.Via), Reason: route.ReasonForward, IP: target.IP}
+	}
+	remote := decision.Remote
+	acc := newAccess(s.Name(), conn.RemoteAddr().String(), target, decision)
+	defer acc.log(gCtx)
+	_, span := tracing.Start(gCtx, "remote.handshake")
+	span.SetAttr("remote", remote.Name())
+	begin := time.Now()
+	rConn, err := remote.Handshake(gCtx, target)
+	metrics.ObserveHandshake(remote.Name(), begin, err)
+	span.End(err)
+	if nil != err {
+		acc.err = err
+		logger.ErrorAggregated(gCtx, "handshake:"+remote.Name()+"->"+target.String(), map[string]interface{}{
+			"action":    config.ActionRequestBegin,
+			"errorCode": logger.ErrCodeHandshake,
+			"error":     err,
+			"remote":    remote.Name(),
+			"target":    target.String(),
+		})
+		return
+	}
+	track := conntrack.Add(s.Name(), conn.RemoteAddr().String(), target, remote.Name(), func() {
+		_ = conn.Close()
+		closeQuietly(rConn)
+	})
+	defer conntrack.Remove(track)
+	acc.track = track
+	defer closeQuietly(rConn)
+	acc.err = relay(gCtx, remote, target, track, conn, rConn)
+}
+
+func (s *ForwardServer) Name() string {
+	return "ForwardServer"
+}
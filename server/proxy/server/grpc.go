@@ -0,0 +1,134 @@
+package server
+
+import (
+	context2 "context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/conntrack"
+	"proxy/server/metrics"
+	"proxy/server/quota"
+	"proxy/server/route"
+	"proxy/server/tracing"
+	"proxy/utils/context"
+	"proxy/utils/logger"
+)
+
+// GRPCServer gRPC 入口：在 HTTP/2 上接受 gun 格式的双向流（与 v2ray 的 gRPC 传输一致），
+// 便于经支持 gRPC 的 CDN 转发；每个流按 TLS 入口相同的格式承载一次代理请求
+type GRPCServer struct {
+	Type     int8
+	Port     int
+	UserName string
+}
+
+func (s *GRPCServer) Start(ctx context2.Context, l net.Listener) {
+	closeOnDone(ctx, l)
+	srv := &http.Server{ReadHeaderTimeout: config.HandshakeTimeout()}
+	srv.Handler = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		gCtx, cancel := connContext(ctx)
+		defer cancel()
+		defer func() {
+			err := recover() // 内置函数，可以捕捉到函数异常
+			if err != nil {
+				logger.Error(gCtx, map[string]interface{}{
+					"action":    config.ActionRequestBegin,
+					"errorCode": logger.ErrCodeHandshake,
+					"error":     err,
+				})
+			}
+		}()
+		// 非 gRPC 请求返回伪装页面
+		if request.ProtoMajor != 2 || request.Method != http.MethodPost ||
+			request.URL.Path != common.GunPath(config.Config.In.GRPCService) ||
+			!strings.HasPrefix(request.Header.Get("Content-Type"), "application/grpc") {
+			_, _ = writer.Write([]byte(common.Body))
+			return
+		}
+		writer.Header().Set("Content-Type", "application/grpc")
+		writer.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		writer.WriteHeader(http.StatusOK)
+		flusher, _ := writer.(http.Flusher)
+		flusher.Flush()
+		remoteAddr, _ := net.ResolveTCPAddr("tcp", request.RemoteAddr)
+		conn := common.NewGunConn(request.Body, writer, flusher.Flush, func() {
+			_ = request.Body.Close()
+		}, l.Addr(), remoteAddr)
+		defer conn.Close()
+		defer metrics.TrackConnection(s.Name())()
+		gCtx, connSpan := tracing.Start(gCtx, s.Name())
+		defer connSpan.End(nil)
+		_, span := tracing.Start(gCtx, "inbound.handshake")
+		wConn, target, err := s.Handshake(gCtx, conn)
+		span.End(err)
+		if nil != err {
+			logger.Error(gCtx, map[string]interface{}{
+				"action":    config.ActionRequestBegin,
+				"errorCode": logger.ErrCodeHandshake,
+				"error":     err,
+				"name":      s.Name(),
+			})
+			return
+		}
+		// 反向隧道的控制与数据连接不经分流
+		if isReverse(target) {
+			serveReverse(gCtx, ctx, s.Name(), request.RemoteAddr, wConn, target)
+			return
+		}
+		decision := route.Decide(gCtx, target)
+		remote := decision.Remote
+		acc := newAccess(s.Name(), request.RemoteAddr, target, decision)
+		defer acc.log(gCtx)
+		_, span = tracing.Start(gCtx, "remote.handshake")
+		span.SetAttr("remote", remote.Name())
+		begin := time.Now()
+		rConn, err := remote.Handshake(gCtx, target)
+		metrics.ObserveHandshake(remote.Name(), begin, err)
+		span.End(err)
+		if nil != err {
+			acc.err = err
+			logger.ErrorAggregated(gCtx, "handshake:"+remote.Name()+"->"+target.String(), map[string]interface{}{
+				"action":    config.ActionRequestBegin,
+				"errorCode": logger.ErrCodeHandshake,
+				"error":     err,
+				"remote":    remote.Name(),
+				"target":    target.String(),
+			})
+			return
+		}
+		track := conntrack.Add(s.Name(), request.RemoteAddr, target, remote.Name(), func() {
+			_ = conn.Close()
+			closeQuietly(rConn)
+		})
+		defer conntrack.Remove(track)
+		acc.track = track
+		defer closeQuietly(rConn)
+		acc.err = relay(gCtx, remote, target, track, quota.Wrap(userOf(gCtx), wConn), rConn)
+	})
+	err := srv.Serve(tls.NewListener(l, config.TLSConfig))
+	gCtx := context.NewContext()
+	// 监听已关闭（重载时切换端口）属于正常退出
+	if nil != err && !errors.Is(err, net.ErrClosed) {
+		logger.Error(gCtx, map[string]interface{}{
+			"action":    config.ActionRequestBegin,
+			"errorCode": logger.ErrCodeHandshake,
+			"error":     err,
+		})
+	}
+}
+
+// Handshake TLS 已由 HTTP/2 完成，这里只认证用户并读取目标地址
+func (s *GRPCServer) Handshake(ctx context2.Context, conn net.Conn) (io.ReadWriter, *common.TargetAddr, error) {
+	return acceptRequest(ctx, conn)
+}
+
+func (s *GRPCServer) Name() string {
+	return "GRPCServer"
+}
@@ -1,19 +1,28 @@
 package server
 
 import (
+	context2 "context"
+	"errors"
 	"io"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"proxy/config"
 	"proxy/server/common"
+	"proxy/server/conntrack"
+	"proxy/server/metrics"
 	"proxy/server/route"
+	"proxy/server/tracing"
 	"proxy/utils/context"
 	"proxy/utils/logger"
 )
 
+// requestKey 请求上下文中 HTTP 代理请求的键，供 Handshake 读取
+type requestKey struct{}
+
 type HttpServer struct {
 	Type     int8
 	Port     int
@@ -21,11 +30,14 @@ type HttpServer struct {
 	Password string
 }
 
-func (s *HttpServer) Start(l net.Listener) {
+func (s *HttpServer) Start(ctx context2.Context, l net.Listener) {
+	closeOnDone(ctx, l)
 	// TODO http basic auth
-	err := http.Serve(l, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		gCtx := context.NewContext()
-		gCtx.Set("request", request)
+	srv := &http.Server{ReadHeaderTimeout: config.HandshakeTimeout()}
+	srv.Handler = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		gCtx, cancel := connContext(ctx)
+		defer cancel()
+		gCtx = context2.WithValue(gCtx, requestKey{}, request)
 		hj := writer.(http.Hijacker)
 		conn, _, err := hj.Hijack()
 		if err != nil {
@@ -33,7 +45,12 @@ func (s *HttpServer) Start(l net.Listener) {
 			return
 		}
 		defer conn.Close()
+		defer metrics.TrackConnection(s.Name())()
+		gCtx, connSpan := tracing.Start(gCtx, s.Name())
+		defer connSpan.End(nil)
+		_, span := tracing.Start(gCtx, "inbound.handshake")
 		wConn, target, err := s.Handshake(gCtx, conn)
+		span.End(err)
 		if nil != err {
 			logger.Error(gCtx, map[string]interface{}{
 				"action":    config.ActionRequestBegin,
@@ -42,10 +59,19 @@ func (s *HttpServer) Start(l net.Listener) {
 			})
 			return
 		}
-		remote := route.GetRemote(gCtx, target)
+		decision := route.Decide(gCtx, target)
+		remote := decision.Remote
+		acc := newAccess(s.Name(), conn.RemoteAddr().String(), target, decision)
+		defer acc.log(gCtx)
+		_, span = tracing.Start(gCtx, "remote.handshake")
+		span.SetAttr("remote", remote.Name())
+		begin := time.Now()
 		rConn, err := remote.Handshake(gCtx, target)
+		metrics.ObserveHandshake(remote.Name(), begin, err)
+		span.End(err)
 		if nil != err {
-			logger.Error(gCtx, map[string]interface{}{
+			acc.err = err
+			logger.ErrorAggregated(gCtx, "handshake:"+remote.Name()+"->"+target.String(), map[string]interface{}{
 				"action":    config.ActionRequestBegin,
 				"errorCode": logger.ErrCodeHandshake,
 				"error":     err,
@@ -55,6 +81,12 @@ func (s *HttpServer) Start(l net.Listener) {
 			_, _ = wConn.Write(common.DefaultHtml)
 			return
 		}
+		track := conntrack.Add(s.Name(), conn.RemoteAddr().String(), target, remote.Name(), func() {
+			_ = conn.Close()
+			closeQuietly(rConn)
+		})
+		defer conntrack.Remove(track)
+		acc.track = track
 		defer func() {
 			_ = wConn.(net.Conn).Close()
 			switch rConn.(type) {
@@ -64,35 +96,12 @@ func (s *HttpServer) Start(l net.Listener) {
 				_ = rConn.(*common.Chacha20Stream).Close()
 			}
 		}()
-		go func() {
-			_, err = io.Copy(rConn, wConn)
-			if nil != err {
-				if strings.Index(err.Error(), "closed") == -1 {
-					logger.Error(gCtx, map[string]interface{}{
-						"action":    config.ActionSocketOperate,
-						"errorCode": logger.ErrCodeTransfer,
-						"error":     err,
-						"remote":    remote.Name(),
-						"target":    target.String(),
-					})
-				}
-			}
-		}()
-		_, err = io.Copy(wConn, rConn)
-		if nil != err {
-			if strings.Index(err.Error(), "closed") == -1 {
-				logger.Error(gCtx, map[string]interface{}{
-					"action":    config.ActionSocketOperate,
-					"errorCode": logger.ErrCodeTransfer,
-					"error":     err,
-					"remote":    remote.Name(),
-					"target":    target.String(),
-				})
-			}
-		}
-	}))
+		acc.err = relayLocal(gCtx, remote, target, track, wConn, rConn, decision.MITM)
+	})
+	err := srv.Serve(l)
 	gCtx := context.NewContext()
-	if nil != err {
+	// 监听已关闭（重载时切换端口）属于正常退出
+	if nil != err && !errors.Is(err, net.ErrClosed) {
 		logger.Error(gCtx, map[string]interface{}{
 			"action":    config.ActionRequestBegin,
 			"errorCode": logger.ErrCodeHandshake,
@@ -100,9 +109,8 @@ func (s *HttpServer) Start(l net.Listener) {
 		})
 	}
 }
-func (s *HttpServer) Handshake(ctx *context.Context, conn net.Conn) (io.ReadWriter, *common.TargetAddr, error) {
-	req, _ := ctx.Get("request")
-	request, _ := req.(*http.Request)
+func (s *HttpServer) Handshake(ctx context2.Context, conn net.Conn) (io.ReadWriter, *common.TargetAddr, error) {
+	request, _ := ctx.Value(requestKey{}).(*http.Request)
 
 	addr := request.Host
 	i := strings.LastIndex(addr, ":")
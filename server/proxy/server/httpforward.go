@@ -0,0 +1,299 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/conntrack"
+	"proxy/server/limit"
+	"proxy/server/metrics"
+	"proxy/server/route"
+	"proxy/server/tracing"
+	"proxy/utils/logger"
+)
+
+// httpBadGateway 出口握手或上游响应失败时回复客户端，随后关闭连接
+const httpBadGateway = "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"
+
+// hopHeaders 逐跳头部，转发前从请求与响应中移除
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// httpForwardConn 普通 HTTP 代理连接，request 为握手时解析的首个请求
+type httpForwardConn struct {
+	bufferedConn
+	request *http.Request
+}
+
+// httpUpstream 当前请求目标的出口连接，目标（host:port）不变时在请求间复用
+type httpUpstream struct {
+	key    string
+	target *common.TargetAddr
+	remote common.Remote
+	rConn  io.ReadWriter
+	reader *bufio.Reader
+	up     io.Writer
+	down   io.Writer
+	track  *conntrack.Conn
+	acc    *access
+}
+
+// close 关闭出口连接并输出该目标的访问日志
+func (u *httpUpstream) close(ctx context.Context) {
+	conntrack.Remove(u.track)
+	closeQuietly(u.rConn)
+	u.acc.log(ctx)
+}
+
+// forwardHTTP 逐个读取客户端请求并转发：每个请求按自身目标分流，目标变化时重新建立出口连接，
+// 请求或响应要求关闭（Connection: close、HTTP/1.0 等）时结束；按序处理即支持客户端流水线发送
+// 两个方向都没有数据超过 timeouts.idle 时断开连接
+func (s *SocketServer) forwardHTTP(ctx context.Context, name string, fc *httpForwardConn, target *common.TargetAddr) {
+	var current atomic.Pointer[httpUpstream]
+	idle := common.NewIdleTimer(config.IdleTimeout(), func() {
+		logger.Debug(ctx, map[string]interface{}{
+			"action": config.ActionSocketOperate,
+			"target": target.String(),
+		}, "http forward idle timeout, connection closed")
+		_ = fc.Close()
+		if u := current.Load(); u != nil {
+			closeQuietly(u.rConn)
+		}
+	})
+	defer idle.Stop()
+	defer func() {
+		if u := current.Load(); u != nil {
+			u.close(ctx)
+		}
+	}()
+
+	req := fc.request
+	for {
+		if key := target.String(); current.Load() == nil || current.Load().key != key {
+			if u := current.Load(); u != nil {
+				current.Store(nil)
+				u.close(ctx)
+			}
+			u, err := s.dialHTTPUpstream(ctx, name, fc, target, idle)
+			if err != nil {
+				_, _ = io.WriteString(fc, httpBadGateway)
+				return
+			}
+			current.Store(u)
+		}
+		u := current.Load()
+		keepAlive, upgraded, err := forwardHTTPRequest(fc, u, req, idle)
+		if err != nil {
+			u.acc.err = logTransferError(ctx, err, u.remote, u.target)
+			return
+		}
+		if upgraded {
+			// 101 之后连接改用升级后的协议（WebSocket 等），不再按 HTTP 解析，改为透明转发；
+			// 两侧已缓冲的数据先转发，空闲超时由 relay 接管（u.reader 读取时会重新启动 idle，不再使用）
+			idle.Stop()
+			buffered, _ := u.reader.Peek(u.reader.Buffered())
+			u.acc.err = relay(ctx, u.remote, u.target, u.track, fc, &struct {
+				io.Reader
+				io.Writer
+			}{io.MultiReader(bytes.NewReader(buffered), u.rConn), u.rConn})
+			return
+		}
+		if !keepAlive {
+			return
+		}
+		idle.Touch()
+		req, err = http.ReadRequest(fc.reader)
+		if err != nil {
+			// 客户端关闭连接或发送了无法解析的数据
+			return
+		}
+		if req.Method == http.MethodConnect {
+			_, _ = io.WriteString(fc, "HTTP/1.1 405 Method Not Allowed\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+			return
+		}
+		if target, err = httpTarget(req); err != nil {
+			_, _ = io.WriteString(fc, "HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+			return
+		}
+	}
+}
+
+// dialHTTPUpstream 按分流规则为 target 建立出口连接并登记到连接表
+func (s *SocketServer) dialHTTPUpstream(ctx context.Context, name string, fc *httpForwardConn, target *common.TargetAddr, idle *common.IdleTimer) (*httpUpstream, error) {
+	decision := route.Decide(ctx, target)
+	remote := decision.Remote
+	acc := newAccess(name, fc.RemoteAddr().String(), target, decision)
+	_, span := tracing.Start(ctx, "remote.handshake")
+	span.SetAttr("remote", remote.Name())
+	begin := time.Now()
+	rConn, err := remote.Handshake(ctx, target)
+	metrics.ObserveHandshake(remote.Name(), begin, err)
+	span.End(err)
+	if nil != err {
+		acc.err = err
+		acc.log(ctx)
+		logger.ErrorAggregated(ctx, "handshake:"+remote.Name()+"->"+target.String(), map[string]interface{}{
+			"action":    config.ActionRequestBegin,
+			"errorCode": logger.ErrCodeHandshake,
+			"error":     err,
+			"remote":    remote.Name(),
+			"target":    target.String(),
+		})
+		return nil, err
+	}
+	track := conntrack.Add(name, fc.RemoteAddr().String(), target, remote.Name(), func() {
+		_ = fc.Close()
+		closeQuietly(rConn)
+	})
+	acc.track = track
+	return &httpUpstream{
+		key:    target.String(),
+		target: target,
+		remote: remote,
+		rConn:  rConn,
+		reader: bufio.NewReader(idle.Reader(rConn)),
+		up:     limit.Upload(metrics.CountWriter(rConn, metrics.TransferBytes.With(remote.Name(), "up"), &track.Up), target),
+		down:   limit.Download(metrics.CountWriter(fc.Conn, metrics.TransferBytes.With(remote.Name(), "down"), &track.Down), target),
+		track:  track,
+		acc:    acc,
+	}, nil
+}
+
+// forwardHTTPRequest 将 req 改写为源站形式后发往 u 并把响应写回客户端，
+// 返回连接能否继续复用，以及上游是否已以 101 切换协议
+func forwardHTTPRequest(fc *httpForwardConn, u *httpUpstream, req *http.Request, idle *common.IdleTimer) (keepAlive, upgraded bool, err error) {
+	// 客户端等待 100 Continue 才发送请求体，由代理直接答复，上游收到的是完整请求
+	if strings.EqualFold(req.Header.Get("Expect"), "100-continue") {
+		req.Header.Del("Expect")
+		if _, err = io.WriteString(fc, "HTTP/1.1 100 Continue\r\n\r\n"); err != nil {
+			return false, false, err
+		}
+	}
+	// 协议升级请求（WebSocket 等）保留 Upgrade 与 Connection: Upgrade，其余逐跳头部照常移除
+	upgrade := ""
+	if headerHasToken(req.Header, "Connection", "upgrade") {
+		upgrade = req.Header.Get("Upgrade")
+	}
+	removeHopHeaders(req.Header)
+	if upgrade != "" {
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", upgrade)
+	}
+	// 客户端未携带时不让 Write 补上 Go 的默认 User-Agent
+	if _, ok := req.Header["User-Agent"]; !ok {
+		req.Header.Set("User-Agent", "")
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{idle.Reader(req.Body), req.Body}
+	}
+	if err = req.Write(u.up); err != nil {
+		return false, false, err
+	}
+	resp, err := http.ReadResponse(u.reader, req)
+	if err != nil {
+		_, _ = io.WriteString(fc, httpBadGateway)
+		return false, false, err
+	}
+	// 信息性响应（101 除外）之后还有最终响应
+	for resp.StatusCode >= 100 && resp.StatusCode < 200 && resp.StatusCode != http.StatusSwitchingProtocols {
+		if err = resp.Write(u.down); err != nil {
+			return false, false, err
+		}
+		if resp, err = http.ReadResponse(u.reader, req); err != nil {
+			return false, false, err
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		// 原样返回 101（含 Upgrade 与 Connection 头部）
+		if err = resp.Write(u.down); err != nil {
+			return false, false, err
+		}
+		return false, true, nil
+	}
+	removeHopHeaders(resp.Header)
+	keepAlive = !req.Close && !resp.Close
+	resp.Close = !keepAlive
+	if err = resp.Write(u.down); err != nil {
+		return false, false, err
+	}
+	return keepAlive, false, nil
+}
+
+// headerHasToken 头部 name 的逗号分隔取值中是否包含 token（不区分大小写）
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, v := range header.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// removeHopHeaders 移除逐跳头部及 Connection 中列出的头部
+func removeHopHeaders(header http.Header) {
+	for _, v := range header.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+}
+
+// httpTarget 请求的目标地址：绝对 URL 取 URL 中的主机，否则取 Host 头，未指定端口时为 80
+func httpTarget(req *http.Request) (*common.TargetAddr, error) {
+	hostPort := req.URL.Host
+	if hostPort == "" {
+		hostPort = req.Host
+	}
+	if hostPort == "" {
+		return nil, fmt.Errorf("no host found in request")
+	}
+	host, portStr, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		host, portStr = strings.Trim(hostPort, "[]"), "80"
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port in host %q", hostPort)
+	}
+	addr := &common.TargetAddr{
+		Proto: 1, // TCP
+		Port:  port,
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		addr.IP = ip
+	} else {
+		addr.Name = host
+	}
+	return addr, nil
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	context2 "context"
+	"io"
+	"net"
+
+	"proxy/server/common"
+	"proxy/server/kcp"
+)
+
+// KcpServer KCP 入口：每个 KCP 会话承载一次代理请求，请求头与加密方式与 QUIC 入口相同
+type KcpServer struct {
+	QuicServer
+}
+
+func (s *KcpServer) Start(ctx context2.Context, l net.Listener) {
+	s.serve(ctx, l, s.Name())
+}
+
+// Handshake KCP 本身不加密，认证头经 chacha20 解密后校验
+func (s *KcpServer) Handshake(ctx context2.Context, conn net.Conn) (io.ReadWriter, *common.TargetAddr, error) {
+	return acceptRequest(ctx, conn)
+}
+
+func (s *KcpServer) Name() string {
+	return "KcpServer"
+}
+
+// ListenKCP 在 addr 的 UDP 端口上接受 KCP 会话，Close 时断开全部会话
+func ListenKCP(addr string) (net.Listener, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	l, err := kcp.Listen(pc, common.KCPOptions())
+	if err != nil {
+		_ = pc.Close()
+		return nil, err
+	}
+	return l, nil
+}
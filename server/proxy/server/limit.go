@@ -0,0 +1,131 @@
+package server
+
+import (
+	"net"
+	"sync"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/metrics"
+	"proxy/utils/context"
+	"proxy/utils/logger"
+)
+
+// limitListener 限制入口同时建立的连接数（in.max_conns）。达到上限时按 in.overload 处理：
+// wait 暂停 Accept，新连接留在系统的 accept 队列中，TUN 的连接随之等待，形成背压；
+// reject 照常 Accept 但立即关闭新连接。每次 Accept 读取当前配置，重载后立即生效
+type limitListener struct {
+	net.Listener
+	mu     sync.Mutex
+	active int
+	freed  chan struct{} // 归还名额时通知等待中的 Accept
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewLimitListener 包装 l，按 in.max_conns 限制同时建立的连接数
+func NewLimitListener(l net.Listener) net.Listener {
+	return &limitListener{Listener: l, freed: make(chan struct{}, 1), done: make(chan struct{})}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	if err := l.wait(); err != nil {
+		return nil, err
+	}
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if !l.acquire() {
+			l.hit(config.OverloadReject)
+			_ = conn.Close()
+			continue
+		}
+		return &limitConn{Conn: conn, l: l}, nil
+	}
+}
+
+func (l *limitListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	return l.Listener.Close()
+}
+
+// wait 策略为 wait 时阻塞到有空闲名额或监听关闭
+func (l *limitListener) wait() error {
+	waited := false
+	for {
+		limit := config.Config.In.MaxConns
+		if limit <= 0 || config.Config.In.Overload == config.OverloadReject {
+			return nil
+		}
+		l.mu.Lock()
+		full := l.active >= limit
+		l.mu.Unlock()
+		if !full {
+			return nil
+		}
+		if !waited {
+			waited = true
+			l.hit(config.OverloadWait)
+		}
+		select {
+		case <-l.freed:
+		case <-l.done:
+			return net.ErrClosed
+		}
+	}
+}
+
+// acquire 占用一个名额，已达上限时返回 false
+func (l *limitListener) acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limit := config.Config.In.MaxConns; limit > 0 && l.active >= limit {
+		return false
+	}
+	l.active++
+	return true
+}
+
+func (l *limitListener) release() {
+	l.mu.Lock()
+	l.active--
+	l.mu.Unlock()
+	select {
+	case l.freed <- struct{}{}:
+	default:
+	}
+}
+
+// hit 记录一次达到上限：wait 每次开始等待记一次，reject 每个被关闭的连接记一次
+func (l *limitListener) hit(strategy string) {
+	metrics.ConnLimitHits.With(strategy).Inc()
+	logger.WarnAggregated(context.NewContext(), "conn_limit:"+strategy, map[string]interface{}{
+		"action":   config.ActionSocketOperate,
+		"limit":    config.Config.In.MaxConns,
+		"overload": strategy,
+	}, "in.max_conns reached")
+}
+
+// limitConn 关闭时归还名额
+type limitConn struct {
+	net.Conn
+	l    *limitListener
+	once sync.Once
+}
+
+func (c *limitConn) Close() error {
+	c.once.Do(c.l.release)
+	return c.Conn.Close()
+}
+
+func (c *limitConn) CloseWrite() error {
+	return common.HalfClose(c.Conn)
+}
+
+func (c *limitConn) Unwrap() net.Conn {
+	return c.Conn
+}
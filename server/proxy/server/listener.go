@@ -0,0 +1,23 @@
+package server
+
+import (
+	context2 "context"
+	"net"
+
+	"proxy/utils/context"
+)
+
+// closeOnDone ctx 取消时关闭监听，使 Start 中的 Accept / Serve 返回；已建立的连接不受影响
+func closeOnDone(ctx context2.Context, l net.Listener) {
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+}
+
+// connContext 为 accept 的连接新建请求上下文：派生自监听的 ctx，但监听关闭时不取消（见 context.NewConnContext），
+// 并预留鉴权得到的用户名；连接处理结束时需调用返回的 cancel
+func connContext(listener context2.Context) (context2.Context, context2.CancelFunc) {
+	ctx, cancel := context.NewConnContext(listener)
+	return context2.WithValue(ctx, userKey{}, new(string)), cancel
+}
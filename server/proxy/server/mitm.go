@@ -0,0 +1,260 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/conntrack"
+	"proxy/server/limit"
+	"proxy/server/metrics"
+	"proxy/server/mitm"
+	"proxy/server/tracing"
+	"proxy/utils/logger"
+)
+
+// defaultMITMMaxBody mitm.max_body 未配置时记录的报文体长度
+const defaultMITMMaxBody = 4096
+
+// relayLocal 本地入口（SOCKS5 / HTTP 代理）的转发：分流时目标命中 mitm.rules（rule 非空）且客户端发起的是 TLS 时解密检查，
+// 其余连接交给 relay 原样转发
+func relayLocal(ctx context.Context, remote common.Remote, target *common.TargetAddr, track *conntrack.Conn, wConn, rConn io.ReadWriter, rule string) error {
+	conn, ok := wConn.(net.Conn)
+	if rule == "" || target.Proto == 3 || !ok {
+		return relay(ctx, remote, target, track, wConn, rConn)
+	}
+	// 客户端先发言才能判断是否为 TLS，服务端先发言的协议等待 timeouts.handshake 后原样转发
+	_ = conn.SetReadDeadline(time.Now().Add(config.HandshakeTimeout()))
+	br := bufio.NewReader(conn)
+	first, err := br.Peek(1)
+	_ = conn.SetReadDeadline(time.Time{})
+	bc := &bufferedConn{Conn: conn, reader: br}
+	if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+		return nil
+	}
+	if err != nil || first[0] != 0x16 {
+		return relay(ctx, remote, target, track, bc, rConn)
+	}
+	ca, err := mitm.Get(ctx)
+	if err != nil {
+		logger.ErrorAggregated(ctx, "mitm:ca", map[string]interface{}{
+			"action":    config.ActionSocketOperate,
+			"errorCode": logger.ErrCodeHandshake,
+			"error":     err,
+		})
+		return relay(ctx, remote, target, track, bc, rConn)
+	}
+	return relayMITM(ctx, remote, target, track, bc, rConn, ca, rule)
+}
+
+// relayMITM 用本机 CA 签发的证书与客户端完成 TLS 握手，再与目标建立校验证书的 TLS 连接，
+// 逐个转发 HTTP/1.1 请求并记录请求与响应；两侧都协商为 http/1.1，收到 101 后改为透明转发
+func relayMITM(ctx context.Context, remote common.Remote, target *common.TargetAddr, track *conntrack.Conn, conn net.Conn, rConn io.ReadWriter, ca *mitm.CA, rule string) (err error) {
+	_, span := tracing.Start(ctx, "mitm")
+	defer func() { span.End(err) }()
+	idle := common.NewIdleTimer(config.IdleTimeout(), func() {
+		logger.Debug(ctx, map[string]interface{}{
+			"action": config.ActionSocketOperate,
+			"target": target.String(),
+		}, "mitm idle timeout, connection closed")
+		conntrack.Kill(track.ID)
+	})
+	defer idle.Stop()
+	// 计数与限速作用于两侧的密文，与 relay 一致
+	up := limit.Upload(metrics.CountWriter(rConn, metrics.TransferBytes.With(remote.Name(), "up"), &track.Up), target)
+	down := limit.Download(metrics.CountWriter(conn, metrics.TransferBytes.With(remote.Name(), "down"), &track.Down), target)
+
+	var server *tls.Conn
+	serverName := target.Name
+	if serverName == "" && target.IP != nil {
+		serverName = target.IP.String()
+	}
+	client := tls.Server(&mitmConn{Reader: idle.Reader(conn), Writer: down, closer: conn}, &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		// 拿到 SNI 后先与目标握手，目标证书校验失败时客户端握手同样失败
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName != "" {
+				serverName = hello.ServerName
+			}
+			hsCtx, cancel := context.WithTimeout(ctx, config.HandshakeTimeout())
+			defer cancel()
+			server = tls.Client(&mitmConn{Reader: idle.Reader(rConn), Writer: up, closer: rConn}, &tls.Config{
+				ServerName: serverName,
+				NextProtos: []string{"http/1.1"},
+			})
+			if err := server.HandshakeContext(hsCtx); err != nil {
+				return nil, err
+			}
+			return ca.Certificate(serverName)
+		},
+	})
+	_ = conn.SetDeadline(time.Now().Add(config.HandshakeTimeout()))
+	err = client.Handshake()
+	_ = conn.SetDeadline(time.Time{})
+	if err != nil {
+		logger.ErrorAggregated(ctx, "mitm:"+target.String(), map[string]interface{}{
+			"action":    config.ActionSocketOperate,
+			"errorCode": logger.ErrCodeHandshake,
+			"error":     err,
+			"target":    target.String(),
+			"sni":       serverName,
+		})
+		return err
+	}
+	defer client.Close()
+	defer server.Close()
+
+	cr, sr := bufio.NewReader(client), bufio.NewReader(server)
+	for {
+		req, err := http.ReadRequest(cr)
+		if err != nil {
+			// 客户端关闭连接或发送了无法解析的数据
+			return nil
+		}
+		keepAlive, upgraded, err := inspectHTTP(ctx, rule, serverName, client, server, sr, req)
+		if err != nil {
+			return logTransferError(ctx, err, remote, target)
+		}
+		if upgraded {
+			errc := make(chan error, 1)
+			go func() {
+				_, err := common.Copy(server, cr)
+				errc <- err
+			}()
+			_, err = common.Copy(client, sr)
+			if err = logTransferError(ctx, err, remote, target); err != nil {
+				return err
+			}
+			select {
+			case err = <-errc:
+				return logTransferError(ctx, err, remote, target)
+			default:
+				return nil
+			}
+		}
+		if !keepAlive {
+			return nil
+		}
+	}
+}
+
+// inspectHTTP 转发一个请求及其响应并记录日志，返回连接能否继续复用，以及上游是否已以 101 切换协议
+func inspectHTTP(ctx context.Context, rule, serverName string, client, server io.Writer, sr *bufio.Reader, req *http.Request) (keepAlive, upgraded bool, err error) {
+	begin := time.Now()
+	// 客户端等待 100 Continue 才发送请求体，直接答复，上游收到的是完整请求
+	if strings.EqualFold(req.Header.Get("Expect"), "100-continue") {
+		req.Header.Del("Expect")
+		if _, err = io.WriteString(client, "HTTP/1.1 100 Continue\r\n\r\n"); err != nil {
+			return false, false, err
+		}
+	}
+	// 客户端未携带时不让 Write 补上 Go 的默认 User-Agent
+	if _, ok := req.Header["User-Agent"]; !ok {
+		req.Header.Set("User-Agent", "")
+	}
+	reqBody := tapBody(&req.Body)
+	if err = req.Write(server); err != nil {
+		return false, false, err
+	}
+	resp, err := http.ReadResponse(sr, req)
+	if err != nil {
+		_, _ = io.WriteString(client, httpBadGateway)
+		return false, false, err
+	}
+	// 信息性响应（101 除外）之后还有最终响应
+	for resp.StatusCode >= 100 && resp.StatusCode < 200 && resp.StatusCode != http.StatusSwitchingProtocols {
+		if err = resp.Write(client); err != nil {
+			return false, false, err
+		}
+		if resp, err = http.ReadResponse(sr, req); err != nil {
+			return false, false, err
+		}
+	}
+	defer resp.Body.Close()
+	respBody := tapBody(&resp.Body)
+	upgraded = resp.StatusCode == http.StatusSwitchingProtocols
+	keepAlive = !req.Close && !resp.Close
+	err = resp.Write(client)
+	host := req.Host
+	if host == "" {
+		host = serverName
+	}
+	fields := map[string]interface{}{
+		"action":   config.ActionSocketOperate,
+		"mitm":     rule,
+		"method":   req.Method,
+		"url":      "https://" + host + req.URL.RequestURI(),
+		"status":   resp.StatusCode,
+		"duration": time.Since(begin).Milliseconds(),
+		"reqBytes": reqBody.n,
+		"rspBytes": respBody.n,
+		"reqType":  req.Header.Get("Content-Type"),
+		"rspType":  resp.Header.Get("Content-Type"),
+	}
+	if config.Config.MITM.LogBody {
+		fields["reqBody"] = reqBody.buf.String()
+		fields["rspBody"] = respBody.buf.String()
+	}
+	logger.Info(ctx, fields, "mitm request")
+	return keepAlive, upgraded, err
+}
+
+// bodyTap 统计读过的报文体字节数，mitm.log_body 开启时保留前 mitm.max_body 字节（编码原样，不解压）
+type bodyTap struct {
+	io.ReadCloser
+	n   int64
+	buf bytes.Buffer
+	max int
+}
+
+// tapBody 替换 *body 为 bodyTap；没有报文体时不替换，避免 Write 把空请求改为分块传输
+func tapBody(body *io.ReadCloser) *bodyTap {
+	t := &bodyTap{ReadCloser: *body}
+	if config.Config.MITM.LogBody {
+		t.max = config.Config.MITM.MaxBody
+		if t.max <= 0 {
+			t.max = defaultMITMMaxBody
+		}
+	}
+	if *body != nil && *body != http.NoBody {
+		*body = t
+	}
+	return t
+}
+
+func (t *bodyTap) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	t.n += int64(n)
+	if rest := t.max - t.buf.Len(); rest > 0 {
+		t.buf.Write(p[:min(n, rest)])
+	}
+	return n, err
+}
+
+// mitmConn 供 TLS 使用的连接：读写经 Reader / Writer（空闲计时、流量统计），关闭时关闭 closer
+type mitmConn struct {
+	io.Reader
+	io.Writer
+	closer interface{}
+}
+
+func (c *mitmConn) Close() error {
+	closeQuietly(c.closer)
+	return nil
+}
+
+func (c *mitmConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *mitmConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *mitmConn) SetDeadline(t time.Time) error      { return nil }
+func (c *mitmConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *mitmConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bufio"
+	context2 "context"
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/utils/context"
+	"proxy/utils/logger"
+)
+
+// MixedServer 混合入口：同一端口同时提供 SOCKS5 / HTTP 代理、TLS 隧道与 WSS。
+// 按首字节区分明文代理请求与 TLS（0x16），TLS 握手后再按首个请求区分 WSS（HTTP 升级请求）与 TLS 隧道，
+// 分别交给 SocketServer、WSSServer 与 TlsServer 处理
+type MixedServer struct {
+	Type int8
+	Port int
+}
+
+func (s *MixedServer) Start(ctx context2.Context, l net.Listener) {
+	closeOnDone(ctx, l)
+	plain := newMuxListener(l.Addr())
+	tunnel := newMuxListener(l.Addr())
+	wss := newMuxListener(l.Addr())
+	defer plain.Close()
+	defer tunnel.Close()
+	defer wss.Close()
+	go (&SocketServer{Type: s.Type, Port: s.Port}).Start(ctx, plain)
+	tlsServer := &TlsServer{Type: s.Type, Port: s.Port}
+	go tlsServer.serve(ctx, tunnel, func(ctx context2.Context, conn net.Conn) (io.ReadWriter, *common.TargetAddr, error) {
+		// 分发时设置的握手超时覆盖到读取认证头为止
+		defer conn.SetDeadline(time.Time{})
+		return tlsServer.handshakeStream(ctx, conn)
+	})
+	go (&WSSServer{Type: s.Type, Port: s.Port}).serve(ctx, wss)
+	tlsConf := http1TLSConfig()
+	for {
+		conn, err := l.Accept()
+		// 监听已关闭（重载时切换端口）则退出，已建立的连接不受影响
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if nil != err {
+			continue
+		}
+		go s.dispatch(conn, tlsConf, plain, tunnel, wss)
+	}
+}
+
+// dispatch 识别连接的协议并投递到对应的子入口，识别失败时关闭连接
+func (s *MixedServer) dispatch(conn net.Conn, tlsConf *tls.Config, plain, tunnel, wss *muxListener) {
+	// 读取首字节、TLS 握手与之后读取认证头共用 timeouts.handshake
+	if err := conn.SetDeadline(time.Now().Add(config.HandshakeTimeout())); err != nil {
+		_ = conn.Close()
+		return
+	}
+	br := bufio.NewReader(conn)
+	first, err := br.Peek(1)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	bc := &bufferedConn{Conn: conn, reader: br}
+	switch c := first[0]; {
+	case c == Version5 || httpMethodInitial(c):
+		// 明文 SOCKS5 / HTTP 代理，SocketServer 自行设置握手超时
+		_ = conn.SetDeadline(time.Time{})
+		plain.deliver(bc)
+		return
+	case c != 0x16:
+		_ = conn.Close()
+		return
+	}
+	cc := tls.Server(bc, tlsConf)
+	if err = cc.Handshake(); err != nil {
+		logger.Debug(context.NewContext(), map[string]interface{}{
+			"action":    config.ActionRequestBegin,
+			"errorCode": logger.ErrCodeHandshake,
+			"error":     err,
+			"name":      s.Name(),
+		}, "tls handshake fail")
+		_ = conn.Close()
+		return
+	}
+	sc := common.NewSniffConn(cc)
+	if sc.Sniff() == common.TypeHttp {
+		// WSS 由 http.Server 按 ReadHeaderTimeout 读取请求
+		_ = conn.SetDeadline(time.Time{})
+		wss.deliver(sc)
+		return
+	}
+	tunnel.deliver(sc)
+}
+
+// Handshake 混合入口在 Start 中按协议分发连接，由子入口完成握手
+func (s *MixedServer) Handshake(ctx context2.Context, conn net.Conn) (io.ReadWriter, *common.TargetAddr, error) {
+	return nil, nil, errors.New("mixed inbound dispatches connections in Start")
+}
+
+func (s *MixedServer) Name() string {
+	return "MixedServer"
+}
+
+// muxListener 混合入口分发给子入口的虚拟监听，Accept 返回 deliver 投递的连接
+type muxListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newMuxListener(addr net.Addr) *muxListener {
+	return &muxListener{addr: addr, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+// deliver 把连接交给 Accept，监听已关闭时关闭连接
+func (l *muxListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		_ = conn.Close()
+	}
+}
+
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *muxListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *muxListener) Addr() net.Addr {
+	return l.addr
+}
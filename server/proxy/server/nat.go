@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"proxy/config"
+	"proxy/server/common"
+)
+
+// natControlAddr 控制帧的地址：UDP 会话建立后先发送一帧空地址的数据，内容为会话的映射 ID
+const natControlAddr = ""
+
+// maxNATMappings 服务端同时保留的 UDP 映射数量上限，超出后新会话不再保留映射
+const maxNATMappings = 4096
+
+// natTable 服务端的 UDP 映射（完全锥形 NAT）：同一用户的同一映射 ID 复用一个直连出站套接字，
+// 任意远端发来的回包都经当前会话送回；会话断开后映射保留 timeouts.udp_mapping，
+// 客户端在此期间重连可继续使用同一外部端口，游戏与 WebRTC 的对端无需重新打洞
+var natTable = struct {
+	sync.Mutex
+	m map[string]*natMapping
+}{m: make(map[string]*natMapping)}
+
+type natMapping struct {
+	key  string
+	conn *common.UDPPacketConn
+
+	mu    sync.Mutex
+	relay *packetRelay // 当前使用映射的会话，nil 表示会话已断开
+	out   *packetOut
+	timer *time.Timer
+}
+
+// mapNAT 返回 user 的映射 ID 对应的映射，不存在时以 conn 新建；
+// 只对直连 UDP 通道生效，timeouts.udp_mapping 为 0 或映射数量已满时返回 nil
+func mapNAT(user string, id []byte, conn common.PacketConn) *natMapping {
+	udpConn, ok := conn.(*common.UDPPacketConn)
+	if !ok || len(id) == 0 || config.UDPMappingTimeout() == 0 {
+		return nil
+	}
+	key := user + "/" + hex.EncodeToString(id)
+	natTable.Lock()
+	defer natTable.Unlock()
+	if m, ok := natTable.m[key]; ok {
+		return m
+	}
+	if len(natTable.m) >= maxNATMappings {
+		return nil
+	}
+	// 套接字改由映射关闭，会话结束时入口关闭出口连接不影响映射
+	udpConn.Hold()
+	m := &natMapping{key: key, conn: udpConn}
+	natTable.m[key] = m
+	go m.run()
+	return m
+}
+
+// attach 会话 r 开始使用映射，回包经 out 计数后送回 r
+func (m *natMapping) attach(r *packetRelay, out *packetOut) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	m.relay, m.out = r, out
+}
+
+// detach 会话 r 结束，映射保留 timeouts.udp_mapping 后关闭，期间收到的回包丢弃；映射已被新会话接管时不做处理
+func (m *natMapping) detach(r *packetRelay) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.relay != r {
+		return
+	}
+	m.relay, m.out = nil, nil
+	m.timer = time.AfterFunc(config.UDPMappingTimeout(), m.close)
+}
+
+// close 关闭出站套接字，run 随之退出并移除映射
+func (m *natMapping) close() {
+	_ = m.conn.Release()
+}
+
+// run 读取出站套接字的回包交给当前会话，套接字关闭后移除映射
+func (m *natMapping) run() {
+	defer func() {
+		natTable.Lock()
+		if natTable.m[m.key] == m {
+			delete(natTable.m, m.key)
+		}
+		natTable.Unlock()
+	}()
+	buf := common.GetBuffer(common.MaxPacketSize)
+	defer common.PutBuffer(buf)
+	for {
+		n, addr, err := m.conn.ReadPacket(buf)
+		if err != nil {
+			return
+		}
+		m.mu.Lock()
+		r, out := m.relay, m.out
+		m.mu.Unlock()
+		if r != nil {
+			_ = r.deliver(out, buf[:n], addr)
+		}
+	}
+}
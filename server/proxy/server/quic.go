@@ -0,0 +1,219 @@
+package server
+
+import (
+	context2 "context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/conntrack"
+	"proxy/server/metrics"
+	"proxy/server/quota"
+	"proxy/server/route"
+	"proxy/server/tracing"
+	"proxy/utils/logger"
+)
+
+// QuicServer QUIC 入口：每个双向流按 TLS 入口相同的时间戳/长度/地址格式承载一次代理请求
+type QuicServer struct {
+	Type     int8
+	Port     int
+	UserName string
+}
+
+func (s *QuicServer) Start(ctx context2.Context, l net.Listener) {
+	s.serve(ctx, l, s.Name())
+}
+
+// serve 逐个处理 l 返回的连接，每个连接承载一次代理请求，KCP 入口共用
+func (s *QuicServer) serve(ctx context2.Context, l net.Listener, name string) {
+	closeOnDone(ctx, l)
+	for {
+		conn, err := l.Accept()
+		// 监听已关闭（重载时切换端口）则退出，已建立的连接不受影响
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if nil != err {
+			continue
+		}
+		go func() {
+			defer conn.Close()
+			gCtx, cancel := connContext(ctx)
+			defer cancel()
+			defer metrics.TrackConnection(name)()
+			gCtx, connSpan := tracing.Start(gCtx, name)
+			defer connSpan.End(nil)
+			defer func() {
+				if err := recover(); err != nil {
+					logger.Error(gCtx, map[string]interface{}{
+						"action":    config.ActionRequestBegin,
+						"errorCode": logger.ErrCodeHandshake,
+						"error":     err,
+					})
+				}
+			}()
+			_, span := tracing.Start(gCtx, "inbound.handshake")
+			wConn, target, err := s.Handshake(gCtx, conn)
+			span.End(err)
+			if nil != err {
+				logger.Error(gCtx, map[string]interface{}{
+					"action":    config.ActionRequestBegin,
+					"errorCode": logger.ErrCodeHandshake,
+					"error":     err,
+					"name":      name,
+				})
+				return
+			}
+			// 反向隧道的控制与数据连接不经分流
+			if isReverse(target) {
+				serveReverse(gCtx, ctx, name, conn.RemoteAddr().String(), wConn, target)
+				return
+			}
+			decision := route.Decide(gCtx, target)
+			remote := decision.Remote
+			acc := newAccess(name, conn.RemoteAddr().String(), target, decision)
+			defer acc.log(gCtx)
+			_, span = tracing.Start(gCtx, "remote.handshake")
+			span.SetAttr("remote", remote.Name())
+			begin := time.Now()
+			rConn, err := remote.Handshake(gCtx, target)
+			metrics.ObserveHandshake(remote.Name(), begin, err)
+			span.End(err)
+			if nil != err {
+				acc.err = err
+				logger.ErrorAggregated(gCtx, "handshake:"+remote.Name()+"->"+target.String(), map[string]interface{}{
+					"action":    config.ActionRequestBegin,
+					"errorCode": logger.ErrCodeHandshake,
+					"error":     err,
+					"remote":    remote.Name(),
+					"target":    target.String(),
+				})
+				return
+			}
+			track := conntrack.Add(name, conn.RemoteAddr().String(), target, remote.Name(), func() {
+				_ = conn.Close()
+				closeQuietly(rConn)
+			})
+			defer conntrack.Remove(track)
+			acc.track = track
+			defer closeQuietly(rConn)
+			acc.err = relay(gCtx, remote, target, track, quota.Wrap(userOf(gCtx), wConn), rConn)
+		}()
+	}
+}
+
+// Handshake 连接已由 QUIC 完成 TLS 握手，这里只认证用户并读取目标地址
+func (s *QuicServer) Handshake(ctx context2.Context, conn net.Conn) (io.ReadWriter, *common.TargetAddr, error) {
+	return acceptRequest(ctx, conn)
+}
+
+func (s *QuicServer) Name() string {
+	return "QuicServer"
+}
+
+// quicListener 把 QUIC 连接上的双向流适配为 net.Listener，供 QuicServer.Start 使用
+// Close 后不再接受新连接与新流，已有流结束后关闭其所在的连接，全部连接关闭后释放 UDP 端口
+type quicListener struct {
+	tr      *quic.Transport
+	ln      *quic.EarlyListener
+	streams chan net.Conn
+	done    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+// ListenQUIC 在 addr 的 UDP 端口上开启 QUIC 监听，证书与 TLS 入口相同
+func ListenQUIC(addr string) (net.Listener, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf := config.TLSConfig.Clone()
+	tlsConf.NextProtos = []string{common.QuicALPN}
+	tr := &quic.Transport{Conn: pc}
+	ln, err := tr.ListenEarly(tlsConf, common.QuicConfig())
+	if err != nil {
+		_ = pc.Close()
+		return nil, err
+	}
+	l := &quicListener{
+		tr:      tr,
+		ln:      ln,
+		streams: make(chan net.Conn),
+		done:    make(chan struct{}),
+	}
+	go l.acceptConns()
+	return l, nil
+}
+
+func (l *quicListener) acceptConns() {
+	for {
+		conn, err := l.ln.Accept(context2.Background())
+		if err != nil {
+			break
+		}
+		l.wg.Add(1)
+		go l.serve(conn)
+	}
+	l.wg.Wait()
+	_ = l.tr.Close()
+	_ = l.tr.Conn.Close()
+}
+
+// serve 接受 conn 上的流，监听关闭或连接断开后等已有的流结束再关闭连接
+func (l *quicListener) serve(conn *quic.Conn) {
+	defer l.wg.Done()
+	ctx, cancel := context2.WithCancel(conn.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-l.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	var active sync.WaitGroup
+	for {
+		stream, err := conn.AcceptStream(ctx)
+		if err != nil {
+			break
+		}
+		active.Add(1)
+		sc := common.NewQuicConn(stream, conn, active.Done)
+		select {
+		case l.streams <- sc:
+		case <-l.done:
+			_ = sc.Close()
+		}
+	}
+	active.Wait()
+	_ = conn.CloseWithError(0, "")
+}
+
+func (l *quicListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.streams:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *quicListener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.done)
+		err = l.ln.Close()
+	})
+	return err
+}
+
+func (l *quicListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
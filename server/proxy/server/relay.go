@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/conntrack"
+	"proxy/server/limit"
+	"proxy/server/metrics"
+	"proxy/server/tracing"
+	"proxy/utils/logger"
+)
+
+// relay 在客户端与远端之间双向转发 TCP 数据，一个方向结束时半关闭对应的连接，见 common.Relay
+// 流量同时计入连接表与按出口统计的指标，并受 limit 配置的带宽限制
+// 两个方向都没有数据超过 timeouts.idle 时断开连接
+// 两端都是未经加密、包装的 TCP 连接（如直连出口）且不限速时，Linux 上经 splice(2) 零拷贝转发
+// 返回转发过程中遇到的第一个非连接关闭错误
+// UDP 会话（target.Proto 为 3）改为按帧转发数据报；客户端请求解析回送时先回送实际连接的 IP
+// ctx 取消（进程退出）时经连接表关闭两端，中断转发
+func relay(ctx context.Context, remote common.Remote, target *common.TargetAddr, track *conntrack.Conn, wConn, rConn io.ReadWriter) (err error) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conntrack.Kill(track.ID)
+		case <-done:
+		}
+	}()
+	if target.Proto == 3 {
+		return relayPackets(ctx, remote, target, track, packetConnOf(wConn), rConn)
+	}
+	if target.Feedback {
+		if err = common.WriteFeedback(wConn, connectedIP(rConn)); err != nil {
+			return err
+		}
+	}
+	up := limit.Upload(metrics.CountWriter(rConn, metrics.TransferBytes.With(remote.Name(), "up"), &track.Up), target)
+	down := limit.Download(metrics.CountWriter(wConn, metrics.TransferBytes.With(remote.Name(), "down"), &track.Down), target)
+	_, span := tracing.Start(ctx, "relay")
+	defer func() {
+		span.SetAttr("up_bytes", track.Up.Value())
+		span.SetAttr("down_bytes", track.Down.Value())
+		span.End(err)
+	}()
+	idle := common.NewIdleTimer(config.IdleTimeout(), func() {
+		logger.Debug(ctx, map[string]interface{}{
+			"action": config.ActionSocketOperate,
+			"target": target.String(),
+		}, "relay idle timeout, connection closed")
+		conntrack.Kill(track.ID)
+	})
+	defer idle.Stop()
+	upStream := common.Stream{Dst: up, Src: idle.Reader(wConn), Conn: rConn}
+	downStream := common.Stream{Dst: down, Src: idle.Reader(rConn), Conn: wConn}
+	if clientTCP, remoteTCP := common.RawTCP(wConn), common.RawTCP(rConn); clientTCP != nil && remoteTCP != nil && limit.Unlimited(target) {
+		upStream.Dst, upStream.Src = spliceWriter(remoteTCP, idle, metrics.TransferBytes.With(remote.Name(), "up"), &track.Up), clientTCP
+		downStream.Dst, downStream.Src = spliceWriter(clientTCP, idle, metrics.TransferBytes.With(remote.Name(), "down"), &track.Down), remoteTCP
+	}
+	upErr, downErr := common.Relay(upStream, downStream)
+	if err = logTransferError(ctx, downErr, remote, target); err != nil {
+		return err
+	}
+	return logTransferError(ctx, upErr, remote, target)
+}
+
+// connectedIP 直连出口实际连接的目标 IP，经上游中继等无法得知时返回 nil
+func connectedIP(rConn io.ReadWriter) net.IP {
+	if tc := common.RawTCP(rConn); tc != nil {
+		if addr, ok := tc.RemoteAddr().(*net.TCPAddr); ok {
+			return addr.IP
+		}
+	}
+	return nil
+}
+
+// spliceWriter 零拷贝转发写入 conn，每搬运一段数据计入 counters 并重新空闲计时
+func spliceWriter(conn *net.TCPConn, idle *common.IdleTimer, counters ...*metrics.Counter) *common.SpliceWriter {
+	return &common.SpliceWriter{Conn: conn, OnData: func(n int64) {
+		for _, c := range counters {
+			c.Add(n)
+		}
+		idle.Touch()
+	}}
+}
+
+// logTransferError 记录转发错误并原样返回，连接关闭导致的错误忽略并返回 nil
+func logTransferError(ctx context.Context, err error, remote common.Remote, target *common.TargetAddr) error {
+	if nil == err || common.IsClosed(err) {
+		return nil
+	}
+	logger.ErrorAggregated(ctx, "transfer:"+remote.Name()+"->"+target.String(), map[string]interface{}{
+		"action":    config.ActionSocketOperate,
+		"errorCode": logger.ErrCodeTransfer,
+		"error":     err,
+		"remote":    remote.Name(),
+		"target":    target.String(),
+	})
+	return err
+}
+
+// closeQuietly 关闭实现了 io.Closer 的连接，忽略错误
+func closeQuietly(v interface{}) {
+	if closer, ok := v.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	context2 "context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"proxy/config"
+	"proxy/utils/logger"
+)
+
+// maxClockSkew 时间戳与本机时钟相差在该范围内时才认为属于某个用户：超出 in.time_window 但在范围内的回复服务端时间，
+// 供客户端校正，更大的偏差视为密钥不匹配
+const maxClockSkew = time.Hour
+
+// maxReplayEntries nonce 去重表的容量上限，清理过期记录后仍满时拒绝新的握手，不能放过可能的重放
+const maxReplayEntries = 1 << 20
+
+// replaySweepInterval 清理去重表中过期记录的间隔（秒）
+const replaySweepInterval = 60
+
+var (
+	errReplayed   = errors.New("replayed handshake")
+	errReplayFull = errors.New("replay cache is full")
+)
+
+// replayCache 认证头 nonce 去重表：记录每个认证头的 nonce，直到其时间戳超出 in.time_window，之后的重放因时间差被拒绝；
+// 重放的认证头（含 QUIC 0-RTT 数据被重放）即使时间戳仍在允许范围内也会被拒绝，也不会触发时间回复
+var replayCache = struct {
+	sync.Mutex
+	m     map[[24]byte]int64 // nonce -> 过期时间（Unix 秒）
+	swept int64
+}{m: make(map[[24]byte]int64)}
+
+// recordNonce 记录 nonce，之前已经出现过时返回 errReplayed，清理过期记录后去重表仍满时返回 errReplayFull；
+// ts 为认证头中的时间戳
+func recordNonce(ctx context2.Context, nonce []byte, ts int64) error {
+	var key [24]byte
+	copy(key[:], nonce)
+	now := time.Now().Unix()
+	replayCache.Lock()
+	defer replayCache.Unlock()
+	if expire, ok := replayCache.m[key]; ok && expire >= now {
+		return errReplayed
+	}
+	if now-replayCache.swept >= replaySweepInterval || (len(replayCache.m) >= maxReplayEntries && now > replayCache.swept) {
+		replayCache.swept = now
+		for k, expire := range replayCache.m {
+			if expire < now {
+				delete(replayCache.m, k)
+			}
+		}
+	}
+	if len(replayCache.m) >= maxReplayEntries {
+		logger.ErrorAggregated(ctx, "replay_cache:full", map[string]interface{}{
+			"action":    config.ActionSocketOperate,
+			"errorCode": logger.ErrCodeHandshake,
+			"entries":   len(replayCache.m),
+		}, "nonce replay cache is full, rejecting handshakes until entries expire")
+		return errReplayFull
+	}
+	replayCache.m[key] = ts + int64(config.AuthTimeWindow()/time.Second)
+	return nil
+}
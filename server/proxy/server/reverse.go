@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/conntrack"
+	"proxy/server/quota"
+	"proxy/utils/logger"
+)
+
+// reverseHeartbeat 控制连接上的心跳间隔：服务端定期下发全 0 的连接 ID，客户端据此判断控制连接是否存活
+const reverseHeartbeat = 30 * time.Second
+
+// reversePendingTimeout 反向隧道端口收到的连接等待客户端建立数据连接的最长时间
+const reversePendingTimeout = 10 * time.Second
+
+// reversePending 等待数据连接的公网连接，连接 ID -> 连接
+var reversePending = struct {
+	sync.Mutex
+	m map[string]*pendingConn
+}{m: make(map[string]*pendingConn)}
+
+type pendingConn struct {
+	user  string
+	conn  net.Conn
+	timer *time.Timer
+}
+
+// reverseRemote 反向隧道数据连接在指标与连接表中的出口名
+type reverseRemote struct {
+}
+
+func (r *reverseRemote) Handshake(ctx context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+	return nil, errors.New("reverse tunnel has no outbound handshake")
+}
+
+func (r *reverseRemote) Name() string {
+	return "ReverseTunnel"
+}
+
+// isReverse 目标是否为反向隧道的控制或数据连接，这类连接不经分流，由 serveReverse 处理
+func isReverse(target *common.TargetAddr) bool {
+	return target.Proto == common.ProtoReverse || target.Proto == common.ProtoReverseData
+}
+
+// serveReverse 处理反向隧道的控制连接与数据连接，连接结束时返回；
+// 入口监听关闭（退出或重载切换监听）时 listenCtx 取消，控制连接随之断开，客户端会重新建立
+func serveReverse(ctx context.Context, listenCtx context.Context, inbound, source string, ec io.ReadWriter, target *common.TargetAddr) {
+	var err error
+	if target.Proto == common.ProtoReverse {
+		err = serveReverseControl(ctx, listenCtx, inbound, source, ec, target)
+	} else {
+		err = serveReverseData(ctx, inbound, ec, target)
+	}
+	if err != nil {
+		logger.Error(ctx, map[string]interface{}{
+			"action":    config.ActionSocketOperate,
+			"errorCode": logger.ErrCodeHandshake,
+			"error":     err,
+			"user":      userOf(ctx),
+			"target":    target.String(),
+		}, "reverse tunnel failed")
+	}
+}
+
+// serveReverseControl 在 target 上监听，每收到一个连接就在控制连接上下发 8 字节的连接 ID，
+// 客户端以该 ID 建立数据连接后两者对接；控制连接断开时关闭监听
+func serveReverseControl(ctx context.Context, listenCtx context.Context, inbound, source string, ec io.ReadWriter, target *common.TargetAddr) error {
+	user := userOf(ctx)
+	l, err := listenReverse(target)
+	if err != nil {
+		_ = writeReverseStatus(ec, err)
+		return err
+	}
+	defer l.Close()
+	if err = writeReverseStatus(ec, nil); err != nil {
+		return err
+	}
+	kill := func() {
+		_ = l.Close()
+		closeQuietly(ec)
+	}
+	track := conntrack.Add(inbound, source, target, (&reverseRemote{}).Name(), kill)
+	defer conntrack.Remove(track)
+	defer context.AfterFunc(listenCtx, kill)()
+	logger.Info(ctx, map[string]interface{}{
+		"action": config.ActionRuntime,
+		"user":   user,
+		"listen": l.Addr().String(),
+	}, "reverse tunnel opened")
+
+	var wmu sync.Mutex
+	send := func(id []byte) error {
+		wmu.Lock()
+		defer wmu.Unlock()
+		_, err := ec.Write(id)
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// 客户端不在控制连接上发送数据，读取出错说明控制连接已断开
+		_, _ = io.Copy(io.Discard, ec)
+		_ = l.Close()
+	}()
+	go func() {
+		ticker := time.NewTicker(reverseHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if send(make([]byte, 8)) != nil {
+					_ = l.Close()
+					return
+				}
+			}
+		}
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			break
+		}
+		id := make([]byte, 8)
+		_, _ = rand.Read(id)
+		key := hex.EncodeToString(id)
+		addPending(key, user, conn)
+		if err = send(id); err != nil {
+			if p := takePending(key, user); p != nil {
+				_ = p.conn.Close()
+			}
+			break
+		}
+	}
+	logger.Info(ctx, map[string]interface{}{
+		"action": config.ActionRuntime,
+		"user":   user,
+		"listen": l.Addr().String(),
+	}, "reverse tunnel closed")
+	return nil
+}
+
+// serveReverseData 把数据连接与 target.Name 对应的等待中的连接对接并转发
+func serveReverseData(ctx context.Context, inbound string, ec io.ReadWriter, target *common.TargetAddr) error {
+	user := userOf(ctx)
+	p := takePending(target.Name, user)
+	if p == nil {
+		return errors.New("unknown reverse connection id " + target.Name)
+	}
+	defer p.conn.Close()
+	defer closeQuietly(ec)
+	exposed, err := common.NewTargetAddr(p.conn.LocalAddr().String())
+	if err != nil {
+		return err
+	}
+	exposed.Proto = 1
+	remote := &reverseRemote{}
+	track := conntrack.Add(inbound, p.conn.RemoteAddr().String(), exposed, remote.Name(), func() {
+		_ = p.conn.Close()
+		closeQuietly(ec)
+	})
+	defer conntrack.Remove(track)
+	return relay(ctx, remote, exposed, track, quota.Wrap(user, ec), p.conn)
+}
+
+// listenReverse 监听客户端请求的地址，只接受 IP 地址与 reverse.ports 中的端口
+func listenReverse(target *common.TargetAddr) (net.Listener, error) {
+	if target.IP == nil {
+		return nil, fmt.Errorf("reverse listen address %s must be an IP", target.String())
+	}
+	if !slices.Contains(config.Config.Reverse.Ports, target.Port) {
+		return nil, fmt.Errorf("port %d is not in reverse.ports", target.Port)
+	}
+	return net.Listen("tcp", target.String())
+}
+
+// writeReverseStatus 回复控制连接的结果：1 字节状态（0 成功），失败时再跟 1 字节长度与原因
+func writeReverseStatus(w io.Writer, err error) error {
+	if err == nil {
+		_, err = w.Write([]byte{0})
+		return err
+	}
+	msg := err.Error()
+	if len(msg) > 255 {
+		msg = msg[:255]
+	}
+	_, err = w.Write(append([]byte{1, byte(len(msg))}, msg...))
+	return err
+}
+
+// addPending 登记等待数据连接的连接，超过 reversePendingTimeout 未对接时关闭
+func addPending(key, user string, conn net.Conn) {
+	reversePending.Lock()
+	defer reversePending.Unlock()
+	p := &pendingConn{user: user, conn: conn}
+	p.timer = time.AfterFunc(reversePendingTimeout, func() {
+		if takePending(key, user) != nil {
+			_ = conn.Close()
+		}
+	})
+	reversePending.m[key] = p
+}
+
+// takePending 取出 user 的 ID 为 key 的等待中的连接，不存在或属于其他用户时返回 nil
+func takePending(key, user string) *pendingConn {
+	reversePending.Lock()
+	defer reversePending.Unlock()
+	p, ok := reversePending.m[key]
+	if !ok || p.user != user {
+		return nil
+	}
+	delete(reversePending.m, key)
+	p.timer.Stop()
+	return p
+}
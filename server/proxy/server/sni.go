@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/utils/context"
+	"proxy/utils/logger"
+)
+
+// errHelloRead 读到 ClientHello 后中止握手
+var errHelloRead = errors.New("client hello read")
+
+// sniListener 按 TLS ClientHello 中的 SNI 分流：属于本机代理域名（in.server_name / in.hosts）的连接交给入口，
+// 其余 TLS 连接原样转发到 in.fallback，使同一 IP 上的其他域名仍可作为普通网站访问；非 TLS 连接交给入口
+type sniListener struct {
+	*muxListener
+	inner net.Listener
+}
+
+// NewSNIListener 包装 TLS 类入口的监听，in.fallback 为空时连接直接交给入口
+func NewSNIListener(l net.Listener) net.Listener {
+	s := &sniListener{muxListener: newMuxListener(l.Addr()), inner: l}
+	go s.acceptLoop()
+	return s
+}
+
+func (s *sniListener) acceptLoop() {
+	defer s.muxListener.Close()
+	for {
+		conn, err := s.inner.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		// 重载后 in.fallback 立即生效
+		if config.Config.In.Fallback == "" {
+			s.deliver(conn)
+			continue
+		}
+		go s.dispatch(conn, config.Config.In.Fallback)
+	}
+}
+
+// dispatch 读取 ClientHello 判断 SNI，读到的数据随连接一起交给入口或回源
+func (s *sniListener) dispatch(conn net.Conn, fallback string) {
+	if err := conn.SetReadDeadline(time.Now().Add(config.HandshakeTimeout())); err != nil {
+		_ = conn.Close()
+		return
+	}
+	br := bufio.NewReader(conn)
+	first, err := br.Peek(1)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	if first[0] != 0x16 {
+		_ = conn.SetReadDeadline(time.Time{})
+		s.deliver(&bufferedConn{Conn: conn, reader: br})
+		return
+	}
+	var hello bytes.Buffer
+	name, ok := readServerName(io.TeeReader(br, &hello))
+	_ = conn.SetReadDeadline(time.Time{})
+	bc := &bufferedConn{Conn: conn, reader: bufio.NewReader(io.MultiReader(&hello, br))}
+	// 无法解析的 ClientHello 交给入口，由 TLS 握手报错
+	if !ok || config.IsLocalServerName(name) {
+		s.deliver(bc)
+		return
+	}
+	serveFallback(bc, fallback, name)
+}
+
+func (s *sniListener) Close() error {
+	_ = s.muxListener.Close()
+	return s.inner.Close()
+}
+
+// readServerName 从 r 读取 ClientHello 并返回其中的 SNI（可能为空），不是合法的 ClientHello 时 ok 为 false
+func readServerName(r io.Reader) (name string, ok bool) {
+	err := tls.Server(helloConn{r: r}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			name, ok = hello.ServerName, true
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	return name, ok && errors.Is(err, errHelloRead)
+}
+
+// serveFallback 把连接原样转发到 in.fallback，不解密也不经分流，空闲超时同 relay
+func serveFallback(conn net.Conn, fallback, name string) {
+	defer conn.Close()
+	gCtx := context.NewContext()
+	backend, err := net.DialTimeout("tcp", fallback, config.HandshakeTimeout())
+	if err != nil {
+		logger.ErrorAggregated(gCtx, "fallback:"+fallback, map[string]interface{}{
+			"action":    config.ActionSocketOperate,
+			"errorCode": logger.ErrCodeHandshake,
+			"error":     err,
+			"fallback":  fallback,
+		})
+		return
+	}
+	defer backend.Close()
+	logger.Debug(gCtx, map[string]interface{}{
+		"action":   config.ActionSocketOperate,
+		"sni":      name,
+		"source":   conn.RemoteAddr().String(),
+		"fallback": fallback,
+	}, "tls connection forwarded to fallback")
+	_, _ = common.RelayIdle(conn, backend, config.IdleTimeout(), func() {
+		_ = conn.Close()
+		_ = backend.Close()
+	})
+}
+
+// helloConn 只读的伪连接，供 tls.Server 解析 ClientHello，写入被丢弃
+type helloConn struct {
+	r io.Reader
+}
+
+func (c helloConn) Read(p []byte) (int, error)         { return c.r.Read(p) }
+func (c helloConn) Write(p []byte) (int, error)        { return len(p), nil }
+func (c helloConn) Close() error                       { return nil }
+func (c helloConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c helloConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c helloConn) SetDeadline(t time.Time) error      { return nil }
+func (c helloConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c helloConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -1,17 +1,24 @@
 package server
 
 import (
+	"bufio"
+	context2 "context"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
-	"os"
+	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"proxy/config"
 	"proxy/server/common"
+	"proxy/server/conntrack"
+	"proxy/server/metrics"
+	"proxy/server/proxy/socks5"
 	"proxy/server/route"
+	"proxy/server/tracing"
 	"proxy/utils/context"
 	"proxy/utils/logger"
 
@@ -21,26 +28,26 @@ import (
 // https://www.ietf.org/rfc/rfc1928.txt
 
 // Version5 is socks5 version number.
-const Version5 = 0x05
+const Version5 = socks5.Version5
 
 // SOCKS auth type
 const (
-	AuthNone     = 0x00
-	AuthPassword = 0x02
+	AuthNone     = socks5.AuthNone
+	AuthPassword = socks5.AuthPassword
 )
 
 // SOCKS request commands as defined in RFC 1928 section 4
 const (
-	CmdConnect      = 0x01
-	CmdBind         = 0x02
-	CmdUDPAssociate = 0x03
+	CmdConnect      = socks5.CmdConnect
+	CmdBind         = socks5.CmdBind
+	CmdUDPAssociate = socks5.CmdUDPAssociate
 )
 
 // SOCKS address types as defined in RFC 1928 section 4
 const (
-	ATypIP4    = 0x1
-	ATypDomain = 0x3
-	ATypIP6    = 0x4
+	ATypIP4    = socks5.ATypIP4
+	ATypDomain = socks5.ATypDomain
+	ATypIP6    = socks5.ATypIP6
 )
 
 type SocketServer struct {
@@ -50,9 +57,19 @@ type SocketServer struct {
 	Password string
 }
 
-func (s *SocketServer) Start(l net.Listener) {
+func (s *SocketServer) Start(ctx context2.Context, l net.Listener) {
+	s.serve(ctx, l, s.Name())
+}
+
+// serve 接受连接并按 SOCKS5 / HTTP 代理处理，name 为指标与日志中的入口名
+func (s *SocketServer) serve(ctx context2.Context, l net.Listener, name string) {
+	closeOnDone(ctx, l)
 	for {
 		conn, err := l.Accept()
+		// 监听已关闭（重载时切换端口）则退出，已建立的连接不受影响
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			// Accept 错误时 conn 可能为 nil，不要进入 goroutine
 			gCtx := context.NewContext()
@@ -65,8 +82,14 @@ func (s *SocketServer) Start(l net.Listener) {
 		}
 		go func(conn net.Conn) {
 			defer conn.Close()
-			gCtx := context.NewContext()
+			defer metrics.TrackConnection(name)()
+			gCtx, cancel := connContext(ctx)
+			defer cancel()
+			gCtx, connSpan := tracing.Start(gCtx, name)
+			defer connSpan.End(nil)
+			_, span := tracing.Start(gCtx, "inbound.handshake")
 			wConn, target, err := s.Handshake(gCtx, conn)
+			span.End(err)
 			if nil != err {
 				logger.Error(gCtx, map[string]interface{}{
 					"action":    config.ActionRequestBegin,
@@ -75,10 +98,24 @@ func (s *SocketServer) Start(l net.Listener) {
 				})
 				return
 			}
-			remote := route.GetRemote(gCtx, target)
+			if fc, ok := wConn.(*httpForwardConn); ok {
+				// 普通 HTTP 代理请求逐个转发，同一连接上的请求可以去往不同目标
+				s.forwardHTTP(gCtx, name, fc, target)
+				return
+			}
+			decision := route.Decide(gCtx, target)
+			remote := decision.Remote
+			acc := newAccess(name, conn.RemoteAddr().String(), target, decision)
+			defer acc.log(gCtx)
+			_, span = tracing.Start(gCtx, "remote.handshake")
+			span.SetAttr("remote", remote.Name())
+			begin := time.Now()
 			rConn, err := remote.Handshake(gCtx, target)
+			metrics.ObserveHandshake(remote.Name(), begin, err)
+			span.End(err)
 			if nil != err {
-				logger.Error(gCtx, map[string]interface{}{
+				acc.err = err
+				logger.ErrorAggregated(gCtx, "handshake:"+remote.Name()+"->"+target.String(), map[string]interface{}{
 					"action":    config.ActionRequestBegin,
 					"errorCode": logger.ErrCodeHandshake,
 					"error":     err,
@@ -88,6 +125,15 @@ func (s *SocketServer) Start(l net.Listener) {
 				_, _ = wConn.Write(common.DefaultHtml)
 				return
 			}
+			track := conntrack.Add(name, conn.RemoteAddr().String(), target, remote.Name(), func() {
+				_ = conn.Close()
+				closeQuietly(rConn)
+				if target.UdpConn != nil {
+					_ = target.UdpConn.Close()
+				}
+			})
+			defer conntrack.Remove(track)
+			acc.track = track
 			defer func() {
 				// 安全关闭 wConn
 				if closer, ok := wConn.(io.Closer); ok {
@@ -99,81 +145,20 @@ func (s *SocketServer) Start(l net.Listener) {
 				}
 			}()
 			if target.Proto == 3 {
-				done := make(chan error, 1)
-				// relay from tcp to udp
+				// UDP ASSOCIATE：按数据报的目标地址分流，TCP 控制连接断开时会话结束
 				go func() {
-					//defer rConn.SetReadDeadline(time.Now()) // wake up anthoer goroutine
-					buf := make([]byte, 65535)
-					for {
-						n, err := rConn.Read(buf)
-						if err != nil {
-							done <- err
-							return
-						}
-						_, err = target.UdpConn.WriteTo(buf[:n], target.UdpAddr)
-						if err != nil {
-							done <- err
-							return
-						}
-					}
+					_, _ = io.Copy(io.Discard, conn)
+					conntrack.Kill(track.ID)
 				}()
-
-				// relay from udp to tcp
-				var n int
-				buf := make([]byte, 65535)
-				for {
-					n, _, err = target.UdpConn.ReadFrom(buf)
-					if err != nil {
-						break
-					}
-					_, err = rConn.Write(buf[:n])
-					if err != nil {
-						break
-					}
-				}
-				//wConn.SetReadDeadline(time.Now()) // wake up anthoer goroutine
-
-				// ignore timeout error.
-				err1 := <-done
-				if !errors.Is(err, os.ErrDeadlineExceeded) {
-					return
-				}
-				if !errors.Is(err1, os.ErrDeadlineExceeded) {
-					return
-				}
+				acc.err = relayPackets(gCtx, remote, target, track, newSocksPacketConn(target.UdpConn), rConn)
 			} else {
-				go func() {
-					_, err = io.Copy(rConn, wConn)
-					if nil != err {
-						if strings.Index(err.Error(), "closed") == -1 {
-							logger.Error(gCtx, map[string]interface{}{
-								"action":    config.ActionSocketOperate,
-								"errorCode": logger.ErrCodeTransfer,
-								"error":     err,
-								"remote":    remote.Name(),
-								"target":    target.String(),
-							})
-						}
-					}
-				}()
-				_, err = io.Copy(wConn, rConn)
-				if nil != err {
-					if strings.Index(err.Error(), "closed") == -1 {
-						logger.Error(gCtx, map[string]interface{}{
-							"action":    config.ActionSocketOperate,
-							"errorCode": logger.ErrCodeTransfer,
-							"error":     err,
-							"remote":    remote.Name(),
-							"target":    target.String(),
-						})
-					}
-				}
+				acc.err = relayLocal(gCtx, remote, target, track, wConn, rConn, decision.MITM)
 			}
 		}(conn)
 	}
 }
 
-func (s *SocketServer) Handshake(ctx *context.Context, conn net.Conn) (io.ReadWriter, *common.TargetAddr, error) {
+func (s *SocketServer) Handshake(ctx context2.Context, conn net.Conn) (io.ReadWriter, *common.TargetAddr, error) {
 	// 在函数退出前，执行defer
 	// 捕捉异常后，程序不会异常退出
 	defer func() {
@@ -187,33 +172,27 @@ func (s *SocketServer) Handshake(ctx *context.Context, conn net.Conn) (io.ReadWr
 			})
 		}
 	}()
-	// Set handshake timeout 4 seconds
-	if err := conn.SetReadDeadline(time.Now().Add(time.Second * 4)); err != nil {
+	// 握手超时 timeouts.handshake
+	if err := conn.SetReadDeadline(time.Now().Add(config.HandshakeTimeout())); err != nil {
 		return nil, nil, err
 	}
 	defer conn.SetReadDeadline(time.Time{})
 
 	// https://www.ietf.org/rfc/rfc1928.txt
-	buf := make([]byte, 4096) // 增大缓冲区以容纳 HTTP 请求
-
-	// Read hello message
-	n, err := conn.Read(buf[:])
-	if err != nil || n == 0 {
+	// 消息可能分多次到达，经缓冲按字段读取；握手后客户端已发出的数据留在 br 中随连接返回
+	br := bufio.NewReader(conn)
+	first, err := br.Peek(1)
+	if err != nil {
 		return nil, nil, fmt.Errorf("failed to read hello: %w", err)
 	}
 
-	// 检测协议类型：SOCKS5 的第一个字节是 0x05，HTTP 请求以 ASCII 字母开头
-	firstByte := buf[0]
-
-	// HTTP 请求检测：CONNECT, GET, POST, PUT, DELETE, HEAD, OPTIONS, PATCH
-	if firstByte == 'C' || firstByte == 'G' || firstByte == 'P' || firstByte == 'D' || firstByte == 'H' || firstByte == 'O' {
-		// 尝试解析 HTTP 请求
-		return s.handleHTTPProxy(ctx, conn, buf[:n])
+	// 检测协议类型：SOCKS5 的第一个字节是 0x05，HTTP 请求以方法名的首字母开头
+	if httpMethodInitial(first[0]) {
+		return s.handleHTTPProxy(ctx, conn, br)
 	}
 
-	version := firstByte
-	if version != Version5 {
-		return nil, nil, fmt.Errorf("unsupported socks version %v", version)
+	if _, err = socks5.ReadHello(br); err != nil {
+		return nil, nil, socksError("failed to read hello", err)
 	}
 
 	// Write hello response
@@ -224,15 +203,19 @@ func (s *SocketServer) Handshake(ctx *context.Context, conn net.Conn) (io.ReadWr
 	}
 
 	// Read command message
-	n, err = conn.Read(buf[:])
-	if err != nil || n < 7 { // Shortest length is 7
-		return nil, nil, fmt.Errorf("failed to read command: %w", err)
+	req, err := socks5.ReadRequest(br)
+	if err != nil {
+		return nil, nil, socksError("failed to read command", err)
 	}
-	cmd := buf[1]
-	addr := &common.TargetAddr{}
-	switch cmd {
+	addr := &common.TargetAddr{IP: req.IP, Name: req.Name, Port: req.Port}
+	switch req.Cmd {
 	case CmdConnect:
 		addr.Proto = 1
+		// Write command response，UDP ASSOCIATE 回复的是中继地址
+		_, err = conn.Write([]byte{Version5, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to write command response: %w", err)
+		}
 	case CmdUDPAssociate:
 		addr.Proto = 3
 		ip := conn.LocalAddr().(*net.TCPAddr).IP
@@ -259,97 +242,50 @@ func (s *SocketServer) Handshake(ctx *context.Context, conn net.Conn) (io.ReadWr
 		binary.BigEndian.PutUint16(portByte[:], uint16(udpAddr.Port))
 		res = append(res, portByte[:]...)
 		if _, err := conn.Write(res); err != nil {
+			_ = udpConn.Close()
 			return nil, nil, fmt.Errorf("reply accept udp err %+v", err)
 		}
 	default:
-		return nil, nil, fmt.Errorf("unsuppoted command %v", cmd)
-	}
-	l := 2
-	off := 4
-	switch buf[3] {
-	case ATypIP4:
-		l += net.IPv4len
-		addr.IP = make(net.IP, net.IPv4len)
-	case ATypIP6:
-		l += net.IPv6len
-		addr.IP = make(net.IP, net.IPv6len)
-	case ATypDomain:
-		l += int(buf[4])
-		off = 5
-	default:
-		return nil, nil, fmt.Errorf("unknown address type %v", buf[3])
+		return nil, nil, common.Wrap(common.ErrProtocol, fmt.Errorf("unsuppoted command %v", req.Cmd))
 	}
 
-	if len(buf[off:]) < l {
-		return nil, nil, errors.New("short command request")
-	}
-	if addr.IP != nil {
-		copy(addr.IP, buf[off:])
-	} else {
-		addr.Name = string(buf[off : off+l-2])
-	}
-	addr.Port = int(buf[off+l-2])<<8 | int(buf[off+l-1])
-
-	// Write command response
-	_, err = conn.Write([]byte{Version5, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to write command response: %w", err)
-	}
-
-	return conn, addr, err
+	return &bufferedConn{Conn: conn, reader: br}, addr, nil
 }
 
 func (s *SocketServer) Name() string {
 	return "SocketServer"
 }
 
-// handleHTTPProxy 处理 HTTP CONNECT 代理请求
-// HTTP CONNECT 请求格式: CONNECT host:port HTTP/1.1\r\nHost: host:port\r\n...\r\n\r\n
-func (s *SocketServer) handleHTTPProxy(ctx *context.Context, conn net.Conn, initialData []byte) (io.ReadWriter, *common.TargetAddr, error) {
-	// 将初始数据转换为字符串进行解析
-	request := string(initialData)
-
-	// 检查是否是 CONNECT 请求
-	if !strings.HasPrefix(request, "CONNECT ") {
-		// 非 CONNECT 的 HTTP 请求（如 GET/POST），对于透明代理需要特殊处理
-		// 这里暂时只支持 CONNECT 方法（HTTPS 代理）
-		return s.handleHTTPForward(ctx, conn, initialData)
+// socksError 读取 SOCKS5 消息失败，消息格式错误时标注为协议错误
+func socksError(msg string, err error) error {
+	err = fmt.Errorf("%s: %w", msg, err)
+	if errors.Is(err, socks5.ErrMalformed) {
+		return common.Wrap(common.ErrProtocol, err)
 	}
+	return err
+}
 
-	// 解析 CONNECT 请求: CONNECT host:port HTTP/1.x
-	lines := strings.Split(request, "\r\n")
-	if len(lines) < 1 {
-		return nil, nil, fmt.Errorf("invalid HTTP CONNECT request")
+// handleHTTPProxy 处理 HTTP 代理请求：CONNECT 建立隧道，其余方法交给 handleHTTPForward
+// HTTP CONNECT 请求格式: CONNECT host:port HTTP/1.1\r\nHost: host:port\r\n...\r\n\r\n
+func (s *SocketServer) handleHTTPProxy(ctx context2.Context, conn net.Conn, br *bufio.Reader) (io.ReadWriter, *common.TargetAddr, error) {
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid HTTP request: %w", err)
 	}
-
-	// 解析第一行: CONNECT host:port HTTP/1.1
-	parts := strings.Fields(lines[0])
-	if len(parts) < 2 {
-		return nil, nil, fmt.Errorf("invalid HTTP CONNECT request format")
+	if req.Method != http.MethodConnect {
+		return s.handleHTTPForward(ctx, conn, br, req)
 	}
 
-	hostPort := parts[1]
+	hostPort := req.Host
 	host, portStr, err := net.SplitHostPort(hostPort)
 	if err != nil {
 		// 如果没有端口，默认 443（HTTPS）
 		host = hostPort
 		portStr = "443"
 	}
-
-	port := 443
-	if _, err := fmt.Sscanf(portStr, "%d", &port); err != nil {
-		port = 443
-	}
-
-	// 读取完整的 HTTP 头部（直到 \r\n\r\n）
-	fullRequest := request
-	for !strings.Contains(fullRequest, "\r\n\r\n") {
-		buf := make([]byte, 4096)
-		n, err := conn.Read(buf)
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed to read HTTP headers: %w", err)
-		}
-		fullRequest += string(buf[:n])
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, nil, common.Wrap(common.ErrProtocol, fmt.Errorf("invalid HTTP CONNECT target %q", hostPort))
 	}
 
 	// 构建目标地址
@@ -377,154 +313,50 @@ func (s *SocketServer) handleHTTPProxy(ctx *context.Context, conn net.Conn, init
 		"target": hostPort,
 	}, "HTTP CONNECT tunnel established")
 
-	return conn, addr, nil
+	// 客户端紧随 CONNECT 发出的数据（如 TLS ClientHello）可能已在 br 中
+	return &bufferedConn{Conn: conn, reader: br}, addr, nil
 }
 
-// handleHTTPForward 处理非 CONNECT 的 HTTP 请求（GET/POST 等）
-// 这种情况需要解析请求 URL，转发到目标服务器
-func (s *SocketServer) handleHTTPForward(ctx *context.Context, conn net.Conn, initialData []byte) (io.ReadWriter, *common.TargetAddr, error) {
-	request := string(initialData)
-	lines := strings.Split(request, "\r\n")
-	if len(lines) < 1 {
-		return nil, nil, fmt.Errorf("invalid HTTP request")
-	}
-
-	// 解析第一行: GET http://host/path HTTP/1.1 或 GET /path HTTP/1.1
-	parts := strings.Fields(lines[0])
-	if len(parts) < 2 {
-		return nil, nil, fmt.Errorf("invalid HTTP request format")
-	}
-
-	method := parts[0]
-	url := parts[1]
-
-	// 解析 URL
-	var host string
-	var port int = 80
-	var path string = "/"
-
-	if strings.HasPrefix(url, "http://") {
-		// 绝对 URL: http://host:port/path
-		url = strings.TrimPrefix(url, "http://")
-		slashIdx := strings.Index(url, "/")
-		if slashIdx > 0 {
-			host = url[:slashIdx]
-			path = url[slashIdx:]
-		} else {
-			host = url
-		}
-	} else if strings.HasPrefix(url, "/") {
-		// 相对 URL: /path - 需要从 Host 头获取目标
-		path = url
-		for _, line := range lines[1:] {
-			if strings.HasPrefix(strings.ToLower(line), "host:") {
-				host = strings.TrimSpace(strings.TrimPrefix(line, "Host:"))
-				host = strings.TrimSpace(strings.TrimPrefix(host, "host:"))
-				break
-			}
-		}
-	} else {
-		return nil, nil, fmt.Errorf("invalid URL format: %s", url)
-	}
-
-	if host == "" {
-		return nil, nil, fmt.Errorf("no host found in request")
-	}
-
-	// 解析 host:port
-	if h, p, err := net.SplitHostPort(host); err == nil {
-		host = h
-		fmt.Sscanf(p, "%d", &port)
-	}
-
-	// 构建目标地址
-	addr := &common.TargetAddr{
-		Proto: 1, // TCP
-		Port:  port,
-	}
-
-	if ip := net.ParseIP(host); ip != nil {
-		addr.IP = ip
-	} else {
-		addr.Name = host
-	}
-
-	// 重写请求：将绝对 URL 改为相对 URL
-	newFirstLine := fmt.Sprintf("%s %s %s", method, path, parts[2])
-	lines[0] = newFirstLine
-
-	// 移除 Proxy-Connection 头，添加 Connection: close
-	newLines := make([]string, 0, len(lines))
-	for _, line := range lines {
-		lowerLine := strings.ToLower(line)
-		if strings.HasPrefix(lowerLine, "proxy-connection:") {
-			continue
-		}
-		newLines = append(newLines, line)
-	}
-
-	// 创建带前缀数据的包装器
-	modifiedRequest := []byte(strings.Join(newLines, "\r\n"))
-	prefixedConn := &prefixedReadWriter{
-		prefix: modifiedRequest,
-		conn:   conn,
+// handleHTTPForward 处理非 CONNECT 的 HTTP 请求（GET/POST 等）：以已解析的首个请求确定目标，
+// 返回的 *httpForwardConn 由 serve 交给 forwardHTTP 逐个请求转发
+func (s *SocketServer) handleHTTPForward(ctx context2.Context, conn net.Conn, br *bufio.Reader, req *http.Request) (io.ReadWriter, *common.TargetAddr, error) {
+	addr, err := httpTarget(req)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	logger.Info(ctx, map[string]interface{}{
 		"action": config.ActionRequestBegin,
-		"method": method,
-		"target": fmt.Sprintf("%s:%d%s", host, port, path),
+		"method": req.Method,
+		"target": addr.String() + req.URL.RequestURI(),
 	}, "HTTP forward request")
 
-	return prefixedConn, addr, nil
+	return &httpForwardConn{bufferedConn: bufferedConn{Conn: conn, reader: br}, request: req}, addr, nil
 }
 
-// prefixedReadWriter 包装连接，在第一次读取时返回预设的前缀数据
-// 实现 io.ReadWriteCloser 接口
-type prefixedReadWriter struct {
-	prefix []byte
-	conn   net.Conn
-	offset int
+// bufferedConn 握手时经 reader 缓冲读取的连接，之后的读取先取走缓冲中的数据
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
 }
 
-func (p *prefixedReadWriter) Read(b []byte) (int, error) {
-	if p.offset < len(p.prefix) {
-		n := copy(b, p.prefix[p.offset:])
-		p.offset += n
-		return n, nil
-	}
-	return p.conn.Read(b)
-}
-
-func (p *prefixedReadWriter) Write(b []byte) (int, error) {
-	return p.conn.Write(b)
-}
-
-func (p *prefixedReadWriter) Close() error {
-	return p.conn.Close()
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
 }
 
-// LocalAddr 返回本地地址（实现 net.Conn 接口）
-func (p *prefixedReadWriter) LocalAddr() net.Addr {
-	return p.conn.LocalAddr()
+func (c *bufferedConn) CloseWrite() error {
+	return common.HalfClose(c.Conn)
 }
 
-// RemoteAddr 返回远程地址（实现 net.Conn 接口）
-func (p *prefixedReadWriter) RemoteAddr() net.Addr {
-	return p.conn.RemoteAddr()
-}
-
-// SetDeadline 设置读写超时（实现 net.Conn 接口）
-func (p *prefixedReadWriter) SetDeadline(t time.Time) error {
-	return p.conn.SetDeadline(t)
-}
-
-// SetReadDeadline 设置读超时（实现 net.Conn 接口）
-func (p *prefixedReadWriter) SetReadDeadline(t time.Time) error {
-	return p.conn.SetReadDeadline(t)
+// Unwrap 握手时多读入的数据读完后才能绕过
+func (c *bufferedConn) Unwrap() net.Conn {
+	if c.reader.Buffered() > 0 {
+		return nil
+	}
+	return c.Conn
 }
 
-// SetWriteDeadline 设置写超时（实现 net.Conn 接口）
-func (p *prefixedReadWriter) SetWriteDeadline(t time.Time) error {
-	return p.conn.SetWriteDeadline(t)
+// httpMethodInitial c 是否为 HTTP 请求方法的首字母：CONNECT、GET、POST、PUT、PATCH、DELETE、HEAD、OPTIONS、TRACE
+func httpMethodInitial(c byte) bool {
+	return strings.IndexByte("CGPDHOT", c) >= 0
 }
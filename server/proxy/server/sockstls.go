@@ -0,0 +1,37 @@
+package server
+
+import (
+	context2 "context"
+	"crypto/tls"
+	"net"
+
+	"proxy/config"
+)
+
+// SocksTLSServer SOCKS5 over TLS 入口：在 TLS 之上提供与 SocketServer 相同的 SOCKS5 / HTTP 代理，
+// 不可信局域网中的设备可直接把本机当作加密的代理网关，无需使用隧道协议；配置 in.client_ca 时只接受持有客户端证书的设备
+type SocksTLSServer struct {
+	SocketServer
+}
+
+func (s *SocksTLSServer) Start(ctx context2.Context, l net.Listener) {
+	// 代理客户端按 HTTP/1.1 或原始 SOCKS5 通信，不协商 h2
+	s.serve(ctx, tls.NewListener(l, http1TLSConfig()), s.Name())
+}
+
+// http1TLSConfig 不协商 h2 的入口 TLS 配置，保留 ACME 的 acme-tls/1
+func http1TLSConfig() *tls.Config {
+	tlsConf := config.TLSConfig.Clone()
+	protos := tlsConf.NextProtos[:0:0]
+	for _, p := range tlsConf.NextProtos {
+		if p != "h2" {
+			protos = append(protos, p)
+		}
+	}
+	tlsConf.NextProtos = protos
+	return tlsConf
+}
+
+func (s *SocksTLSServer) Name() string {
+	return "SocksTLSServer"
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	context2 "context"
 	"crypto/tls"
 	"encoding/binary"
 	"io"
@@ -12,8 +13,11 @@ import (
 	"github.com/pkg/errors"
 	"proxy/config"
 	"proxy/server/common"
+	"proxy/server/conntrack"
+	"proxy/server/metrics"
+	"proxy/server/quota"
 	"proxy/server/route"
-	"proxy/utils/context"
+	"proxy/server/tracing"
 	"proxy/utils/logger"
 )
 
@@ -23,14 +27,25 @@ type TlsServer struct {
 	UserName string
 }
 
-func (s *TlsServer) Start(l net.Listener) {
+func (s *TlsServer) Start(ctx context2.Context, l net.Listener) {
+	s.serve(ctx, l, s.Handshake)
+}
+
+// serve 接受连接并以 handshake 完成入口握手后转发；混合入口传入已完成 TLS 握手的连接与 handshakeStream
+func (s *TlsServer) serve(ctx context2.Context, l net.Listener, handshake func(context2.Context, net.Conn) (io.ReadWriter, *common.TargetAddr, error)) {
+	closeOnDone(ctx, l)
 	// begin accept connection
 	for {
 		conn, err := l.Accept()
+		// 监听已关闭（重载时切换端口）则退出，已建立的连接不受影响
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		// process connection in go routing
 		go func() {
 			defer conn.Close()
-			gCtx := context.NewContext()
+			gCtx, cancel := connContext(ctx)
+			defer cancel()
 			if nil != err {
 				logger.Error(gCtx, map[string]interface{}{
 					"action":    config.ActionRequestBegin,
@@ -39,6 +54,9 @@ func (s *TlsServer) Start(l net.Listener) {
 				})
 				return
 			}
+			defer metrics.TrackConnection(s.Name())()
+			gCtx, connSpan := tracing.Start(gCtx, s.Name())
+			defer connSpan.End(nil)
 			// catch panic
 			defer func() {
 				err := recover() // 内置函数，可以捕捉到函数异常
@@ -51,7 +69,9 @@ func (s *TlsServer) Start(l net.Listener) {
 					})
 				}
 			}()
-			wConn, target, err := s.Handshake(gCtx, conn)
+			_, span := tracing.Start(gCtx, "inbound.handshake")
+			wConn, target, err := handshake(gCtx, conn)
+			span.End(err)
 			if nil != err {
 				logger.Error(gCtx, map[string]interface{}{
 					"action":    config.ActionRequestBegin,
@@ -61,11 +81,25 @@ func (s *TlsServer) Start(l net.Listener) {
 				})
 				return
 			}
+			// 反向隧道的控制与数据连接不经分流
+			if isReverse(target) {
+				serveReverse(gCtx, ctx, s.Name(), conn.RemoteAddr().String(), wConn, target)
+				return
+			}
 			// get remote connection by policy
-			remote := route.GetRemote(gCtx, target)
+			decision := route.Decide(gCtx, target)
+			remote := decision.Remote
+			acc := newAccess(s.Name(), conn.RemoteAddr().String(), target, decision)
+			defer acc.log(gCtx)
+			_, span = tracing.Start(gCtx, "remote.handshake")
+			span.SetAttr("remote", remote.Name())
+			begin := time.Now()
 			rConn, err := remote.Handshake(gCtx, target)
+			metrics.ObserveHandshake(remote.Name(), begin, err)
+			span.End(err)
 			if nil != err {
-				logger.Error(gCtx, map[string]interface{}{
+				acc.err = err
+				logger.ErrorAggregated(gCtx, "handshake:"+remote.Name()+"->"+target.String(), map[string]interface{}{
 					"action":    config.ActionRequestBegin,
 					"errorCode": logger.ErrCodeHandshake,
 					"error":     err,
@@ -75,6 +109,12 @@ func (s *TlsServer) Start(l net.Listener) {
 				_, _ = wConn.Write(common.DefaultHtml)
 				return
 			}
+			track := conntrack.Add(s.Name(), conn.RemoteAddr().String(), target, remote.Name(), func() {
+				_ = conn.Close()
+				closeQuietly(rConn)
+			})
+			defer conntrack.Remove(track)
+			acc.track = track
 			defer func() {
 				_ = wConn.(*common.Chacha20Stream).Close()
 				switch rConn.(type) {
@@ -84,36 +124,11 @@ func (s *TlsServer) Start(l net.Listener) {
 					_ = rConn.(*common.Chacha20Stream).Close()
 				}
 			}()
-			go func() {
-				_, err = io.Copy(rConn, wConn)
-				if nil != err {
-					if strings.Index(err.Error(), "closed") == -1 {
-						logger.Error(gCtx, map[string]interface{}{
-							"action":    config.ActionSocketOperate,
-							"errorCode": logger.ErrCodeTransfer,
-							"error":     err,
-							"remote":    remote.Name(),
-							"target":    target.String(),
-						})
-					}
-				}
-			}()
-			_, err = io.Copy(wConn, rConn)
-			if nil != err {
-				if strings.Index(err.Error(), "closed") == -1 {
-					logger.Error(gCtx, map[string]interface{}{
-						"action":    config.ActionSocketOperate,
-						"errorCode": logger.ErrCodeTransfer,
-						"error":     err,
-						"remote":    remote.Name(),
-						"target":    target.String(),
-					})
-				}
-			}
+			acc.err = relay(gCtx, remote, target, track, quota.Wrap(userOf(gCtx), wConn), rConn)
 		}()
 	}
 }
-func (s *TlsServer) Handshake(ctx *context.Context, conn net.Conn) (io.ReadWriter, *common.TargetAddr, error) {
+func (s *TlsServer) Handshake(ctx context2.Context, conn net.Conn) (io.ReadWriter, *common.TargetAddr, error) {
 	// 在函数退出前，执行defer
 	// 捕捉异常后，程序不会异常退出
 	defer func() {
@@ -127,6 +142,11 @@ func (s *TlsServer) Handshake(ctx *context.Context, conn net.Conn) (io.ReadWrite
 			})
 		}
 	}()
+	// TLS 握手与读取认证头共用 timeouts.handshake
+	if err := conn.SetDeadline(time.Now().Add(config.HandshakeTimeout())); err != nil {
+		return nil, nil, err
+	}
+	defer conn.SetDeadline(time.Time{})
 	cc := tls.Server(conn, config.TLSConfig)
 	err := cc.Handshake()
 	if nil != err {
@@ -138,33 +158,25 @@ func (s *TlsServer) Handshake(ctx *context.Context, conn net.Conn) (io.ReadWrite
 		}, "tls handshake fail")
 		return nil, nil, err
 	}
+	return s.handshakeStream(ctx, cc)
+}
+
+// handshakeStream 在已建立的 TLS 连接上读取认证头与目标地址
+func (s *TlsServer) handshakeStream(ctx context2.Context, cc net.Conn) (io.ReadWriter, *common.TargetAddr, error) {
 	sc := common.NewSniffConn(cc)
 	if sc.Sniff() == common.TypeHttp {
 		_, _ = cc.Write(common.DefaultHtml)
 		logger.Info(ctx, map[string]interface{}{
 			"action":    config.ActionRequestBegin,
 			"errorCode": logger.ErrCodeHandshake,
-			"error":     err,
 		}, "common http request")
-		return nil, nil, errors.New("common http request")
+		return nil, nil, common.Wrap(common.ErrProtocol, errors.New("common http request"))
 	}
-	ec := common.NewChacha20Stream([]byte(config.Config.User), sc)
-	tBuf := make([]byte, 8)
-	_, err = ec.Read(tBuf)
+	ec, err := acceptUser(ctx, sc)
 	if nil != err {
-		logger.Error(ctx, map[string]interface{}{
-			"action":    config.ActionRequestBegin,
-			"errorCode": logger.ErrCodeHandshake,
-			"error":     err,
-		}, "read time buf")
 		_, _ = cc.Write(common.DefaultHtml)
 		return nil, nil, err
 	}
-	ts := binary.BigEndian.Uint64(tBuf)
-	if uint64(time.Now().Unix())-ts > 10 {
-		_, _ = cc.Write(common.DefaultHtml)
-		return nil, nil, errors.New("The time between server and client must same.")
-	}
 
 	pBuf := make([]byte, 2)
 	_, err = ec.Read(pBuf)
@@ -172,8 +184,8 @@ func (s *TlsServer) Handshake(ctx *context.Context, conn net.Conn) (io.ReadWrite
 		return nil, nil, err
 	}
 	var proto = binary.BigEndian.Uint16(pBuf)
-	if proto != 1 && proto != 3 {
-		return nil, nil, errors.New("not support.")
+	if !common.ValidProto(proto) {
+		return nil, nil, errUnsupportedProto
 	}
 
 	dlBuf := make([]byte, 2)
@@ -211,9 +223,9 @@ func (s *TlsServer) Handshake(ctx *context.Context, conn net.Conn) (io.ReadWrite
 	}
 	ip := net.ParseIP(host)
 	var target = &common.TargetAddr{
-		Port:  port,
-		Proto: proto,
+		Port: port,
 	}
+	target.Proto, target.Feedback = common.SplitProto(proto)
 	if nil == ip {
 		target.Name = host
 	} else {
@@ -0,0 +1,330 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/conntrack"
+	"proxy/server/metrics"
+	"proxy/server/route"
+	"proxy/utils/logger"
+)
+
+// maxPacketRoutes 一个 UDP 会话缓存的目标地址分流结果数量上限
+const maxPacketRoutes = 1024
+
+// packetConnOf 出口或入口连接的数据报视图：直连出口本身按数据报收发，其余连接在流上按帧承载
+func packetConnOf(rw io.ReadWriter) common.PacketConn {
+	if pc, ok := rw.(common.PacketConn); ok {
+		return pc
+	}
+	return common.NewPacketStream(rw)
+}
+
+// packetRelay 一个 UDP 会话：客户端一侧的 src 与按目标地址分流的各出口通道之间转发数据报，
+// 每个出口只握手一次，之后发往该出口的数据报共用这条通道
+type packetRelay struct {
+	ctx   context.Context
+	track *conntrack.Conn
+	src   common.PacketConn
+	idle  *common.IdleTimer
+	id    []byte // 映射 ID，经加密出口建立通道时发给服务端
+
+	mu     sync.Mutex
+	outs   map[string]*packetOut // 出口名 -> 通道
+	routes map[string]*packetOut // 目标地址 -> 通道
+}
+
+// packetOut 一个出口的 UDP 通道，mapping 不为 nil 时通道属于服务端的 UDP 映射，回包由映射读取
+type packetOut struct {
+	name    string
+	conn    common.PacketConn
+	mapping *natMapping
+}
+
+// relayPackets 转发 UDP 会话，remote / rConn 为按会话目标建立的首个出口通道；
+// 两个方向都没有数据超过 timeouts.udp_session 时结束，客户端一侧读取出错时返回
+func relayPackets(ctx context.Context, remote common.Remote, target *common.TargetAddr, track *conntrack.Conn, src common.PacketConn, rConn io.ReadWriter) error {
+	r := &packetRelay{
+		ctx:    ctx,
+		track:  track,
+		src:    src,
+		id:     make([]byte, 8),
+		outs:   make(map[string]*packetOut),
+		routes: make(map[string]*packetOut),
+	}
+	_, _ = rand.Read(r.id)
+	r.idle = common.NewIdleTimer(config.UDPSessionTimeout(), func() {
+		conntrack.Kill(track.ID)
+	})
+	defer r.idle.Stop()
+	first := &packetOut{name: remote.Name(), conn: packetConnOf(rConn)}
+	r.outs[first.name] = first
+	r.routes[target.String()] = first
+	r.hello(first)
+	defer r.closeAll()
+
+	buf := common.GetBuffer(common.MaxPacketSize)
+	defer common.PutBuffer(buf)
+	// 首个出口的回包在读到第一帧后才开始转发：第一帧是映射 ID 时改用映射的套接字
+	started := false
+	for {
+		n, addr, err := src.ReadPacket(buf)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		r.idle.Touch()
+		if addr == natControlAddr {
+			if !started {
+				started = true
+				r.attach(first, buf[:n])
+			}
+			continue
+		}
+		if !started {
+			started = true
+			go r.back(first)
+		}
+		out, err := r.route(addr)
+		if err != nil {
+			// 单个目标握手失败只丢弃发往它的数据报
+			logger.ErrorAggregated(ctx, "udp:"+addr, map[string]interface{}{
+				"action":    config.ActionSocketOperate,
+				"errorCode": logger.ErrCodeHandshake,
+				"error":     err,
+				"target":    addr,
+			})
+			continue
+		}
+		if err = out.conn.WritePacket(buf[:n], addr); err != nil {
+			r.drop(out)
+			continue
+		}
+		metrics.TransferBytes.With(out.name, "up").Add(int64(n))
+		track.Up.Add(int64(n))
+	}
+}
+
+// route 返回发往 addr 的出口通道，新目标按分流策略选择出口，该出口还没有通道时握手建立
+func (r *packetRelay) route(addr string) (*packetOut, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if out, ok := r.routes[addr]; ok {
+		return out, nil
+	}
+	target, err := common.NewTargetAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	target.Proto = 3
+	remote := route.Decide(r.ctx, target).Remote
+	out, ok := r.outs[remote.Name()]
+	if !ok {
+		rw, err := remote.Handshake(r.ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		out = &packetOut{name: remote.Name(), conn: packetConnOf(rw)}
+		r.outs[out.name] = out
+		r.hello(out)
+		go r.back(out)
+	}
+	if len(r.routes) >= maxPacketRoutes {
+		clear(r.routes)
+	}
+	r.routes[addr] = out
+	return out, nil
+}
+
+// hello 经加密出口新建的通道先发送映射 ID，服务端据此复用同一会话的出站套接字
+func (r *packetRelay) hello(out *packetOut) {
+	if _, ok := out.conn.(*common.PacketStream); ok {
+		_ = out.conn.WritePacket(r.id, natControlAddr)
+	}
+}
+
+// attach 客户端发来映射 ID：首个出口为直连 UDP 时改用该 ID 的映射，已有映射则关闭新建的套接字
+func (r *packetRelay) attach(first *packetOut, id []byte) {
+	m := mapNAT(userOf(r.ctx), id, first.conn)
+	if m == nil {
+		go r.back(first)
+		return
+	}
+	if m.conn != first.conn {
+		_ = first.conn.Close()
+	}
+	out := &packetOut{name: first.name, conn: m.conn, mapping: m}
+	r.mu.Lock()
+	r.outs[out.name] = out
+	for addr, c := range r.routes {
+		if c == first {
+			r.routes[addr] = out
+		}
+	}
+	r.mu.Unlock()
+	m.attach(r, out)
+}
+
+// back 把出口通道收到的数据报转回客户端，通道出错后移除，之后的数据报重新握手
+func (r *packetRelay) back(out *packetOut) {
+	buf := common.GetBuffer(common.MaxPacketSize)
+	defer common.PutBuffer(buf)
+	for {
+		n, addr, err := out.conn.ReadPacket(buf)
+		if err != nil {
+			r.drop(out)
+			return
+		}
+		if err = r.deliver(out, buf[:n], addr); err != nil {
+			return
+		}
+	}
+}
+
+// deliver 把 out 收到的来自 addr 的数据报送回客户端
+func (r *packetRelay) deliver(out *packetOut, p []byte, addr string) error {
+	r.idle.Touch()
+	if err := r.src.WritePacket(p, addr); err != nil {
+		return err
+	}
+	metrics.TransferBytes.With(out.name, "down").Add(int64(len(p)))
+	r.track.Down.Add(int64(len(p)))
+	return nil
+}
+
+// drop 关闭并移除出错的出口通道
+func (r *packetRelay) drop(out *packetOut) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.outs[out.name] == out {
+		delete(r.outs, out.name)
+	}
+	for addr, c := range r.routes {
+		if c == out {
+			delete(r.routes, addr)
+		}
+	}
+	if out.mapping != nil {
+		out.mapping.close()
+		return
+	}
+	_ = out.conn.Close()
+}
+
+func (r *packetRelay) closeAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, out := range r.outs {
+		if out.mapping != nil {
+			out.mapping.detach(r)
+			continue
+		}
+		_ = out.conn.Close()
+	}
+	clear(r.outs)
+	clear(r.routes)
+}
+
+// socksPacketConn SOCKS5 UDP ASSOCIATE 的中继端口：数据报带有 RFC 1928 第 7 节的头，
+// 只接受第一个发送方（即客户端）的数据报，回包发给它
+type socksPacketConn struct {
+	conn   *net.UDPConn
+	client atomic.Pointer[net.UDPAddr]
+}
+
+func newSocksPacketConn(conn *net.UDPConn) *socksPacketConn {
+	return &socksPacketConn{conn: conn}
+}
+
+func (c *socksPacketConn) ReadPacket(p []byte) (int, string, error) {
+	buf := common.GetBuffer(common.MaxPacketSize)
+	defer common.PutBuffer(buf)
+	for {
+		n, from, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			return 0, "", err
+		}
+		if client := c.client.Load(); client == nil {
+			c.client.Store(from)
+		} else if !from.IP.Equal(client.IP) || from.Port != client.Port {
+			continue
+		}
+		// RSV(2) FRAG(1) ATYP(1)，不支持分片
+		if n < 4 || buf[2] != 0 {
+			continue
+		}
+		host, off := "", 4
+		switch buf[3] {
+		case ATypIP4:
+			if n < off+net.IPv4len+2 {
+				continue
+			}
+			host = net.IP(buf[off : off+net.IPv4len]).String()
+			off += net.IPv4len
+		case ATypIP6:
+			if n < off+net.IPv6len+2 {
+				continue
+			}
+			host = net.IP(buf[off : off+net.IPv6len]).String()
+			off += net.IPv6len
+		case ATypDomain:
+			if n < off+1 || n < off+1+int(buf[off])+2 {
+				continue
+			}
+			host = string(buf[off+1 : off+1+int(buf[off])])
+			off += 1 + int(buf[off])
+		default:
+			continue
+		}
+		port := binary.BigEndian.Uint16(buf[off:])
+		off += 2
+		return copy(p, buf[off:n]), net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+	}
+}
+
+func (c *socksPacketConn) WritePacket(p []byte, addr string) error {
+	client := c.client.Load()
+	if client == nil {
+		return nil
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+	// RSV FRAG ATYP 地址长度 地址 端口
+	head := make([]byte, 3, 4+1+len(host)+2+len(p))
+	if ip := net.ParseIP(host); ip == nil {
+		head = append(head, ATypDomain, byte(len(host)))
+		head = append(head, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		head = append(head, ATypIP4)
+		head = append(head, ip4...)
+	} else {
+		head = append(head, ATypIP6)
+		head = append(head, ip...)
+	}
+	head = binary.BigEndian.AppendUint16(head, uint16(port))
+	head = append(head, p...)
+	_, err = c.conn.WriteToUDP(head, client)
+	return err
+}
+
+func (c *socksPacketConn) Close() error {
+	return c.conn.Close()
+}
@@ -1,11 +1,14 @@
 package server
 
 import (
+	context2 "context"
 	"crypto/tls"
+	"encoding/base64"
 	"encoding/binary"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -13,7 +16,11 @@ import (
 	"github.com/pkg/errors"
 	"proxy/config"
 	"proxy/server/common"
+	"proxy/server/conntrack"
+	"proxy/server/metrics"
+	"proxy/server/quota"
 	"proxy/server/route"
+	"proxy/server/tracing"
 	"proxy/utils/context"
 	"proxy/utils/logger"
 
@@ -29,11 +36,18 @@ type WSSServer struct {
 
 var upgrader = websocket.Upgrader{} // use default options
 
-func (s *WSSServer) Start(l net.Listener) {
+func (s *WSSServer) Start(ctx context2.Context, l net.Listener) {
+	s.serve(ctx, tls.NewListener(l, config.TLSConfig))
+}
+
+// serve 在 l 上提供 WebSocket 服务，l 接受的连接已完成 TLS 握手
+func (s *WSSServer) serve(ctx context2.Context, l net.Listener) {
+	closeOnDone(ctx, l)
 	// TODO http basic auth
-	err := http.Serve(tls.NewListener(l, config.TLSConfig), http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		gCtx := context.NewContext()
-		gCtx.Set("request", request)
+	srv := &http.Server{ReadHeaderTimeout: config.HandshakeTimeout()}
+	srv.Handler = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		gCtx, cancel := connContext(ctx)
+		defer cancel()
 		defer func() {
 			err := recover() // 内置函数，可以捕捉到函数异常
 			if err != nil {
@@ -45,13 +59,42 @@ func (s *WSSServer) Start(l net.Listener) {
 				})
 			}
 		}()
-		conn, err := upgrader.Upgrade(writer, request, nil)
+		// 只接受配置的路径与 Host，其余请求与升级失败一样返回伪装页面，便于与网站共用端口
+		if !matchWSSRequest(request) {
+			_, _ = writer.Write([]byte(common.Body))
+			return
+		}
+		respHeader := http.Header{}
+		for k, v := range config.Config.In.WSS.Headers {
+			respHeader.Set(k, v)
+		}
+		// CDN 模式的客户端把认证头作为早期数据放在 Sec-WebSocket-Protocol 中，之后的数据按二进制消息收发；
+		// 没有该头的是直连模式，升级后直接在底层连接上收发
+		early, err := decodeEarlyData(request)
+		if err != nil {
+			_, _ = writer.Write([]byte(common.Body))
+			return
+		}
+		if early != nil {
+			respHeader.Set("Sec-Websocket-Protocol", request.Header.Get("Sec-Websocket-Protocol"))
+		}
+		conn, err := upgrader.Upgrade(writer, request, respHeader)
 		if err != nil {
 			_, _ = writer.Write([]byte(common.Body))
 			return
 		}
 		defer conn.Close()
-		wConn, target, err := s.Handshake(gCtx, conn.UnderlyingConn())
+		defer metrics.TrackConnection(s.Name())()
+		gCtx, connSpan := tracing.Start(gCtx, s.Name())
+		defer connSpan.End(nil)
+		raw := conn.UnderlyingConn()
+		if early != nil {
+			raw = common.NewWSConn(conn, early)
+		}
+		source := forwardedFor(request)
+		_, span := tracing.Start(gCtx, "inbound.handshake")
+		wConn, target, err := s.Handshake(gCtx, raw)
+		span.End(err)
 		if nil != err {
 			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"code":0, "data":[], "message":"success"}`))
 			logger.Error(gCtx, map[string]interface{}{
@@ -61,10 +104,24 @@ func (s *WSSServer) Start(l net.Listener) {
 			})
 			return
 		}
-		remote := route.GetRemote(gCtx, target)
+		// 反向隧道的控制与数据连接不经分流
+		if isReverse(target) {
+			serveReverse(gCtx, ctx, s.Name(), source, wConn, target)
+			return
+		}
+		decision := route.Decide(gCtx, target)
+		remote := decision.Remote
+		acc := newAccess(s.Name(), source, target, decision)
+		defer acc.log(gCtx)
+		_, span = tracing.Start(gCtx, "remote.handshake")
+		span.SetAttr("remote", remote.Name())
+		begin := time.Now()
 		rConn, err := remote.Handshake(gCtx, target)
+		metrics.ObserveHandshake(remote.Name(), begin, err)
+		span.End(err)
 		if nil != err {
-			logger.Error(gCtx, map[string]interface{}{
+			acc.err = err
+			logger.ErrorAggregated(gCtx, "handshake:"+remote.Name()+"->"+target.String(), map[string]interface{}{
 				"action":    config.ActionRequestBegin,
 				"errorCode": logger.ErrCodeHandshake,
 				"error":     err,
@@ -74,6 +131,12 @@ func (s *WSSServer) Start(l net.Listener) {
 			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"code":0, "data":[], "message":"success"}`))
 			return
 		}
+		track := conntrack.Add(s.Name(), source, target, remote.Name(), func() {
+			_ = conn.Close()
+			closeQuietly(rConn)
+		})
+		defer conntrack.Remove(track)
+		acc.track = track
 		defer func() {
 			_ = wConn.(*common.Chacha20Stream).Close()
 			switch rConn.(type) {
@@ -83,35 +146,12 @@ func (s *WSSServer) Start(l net.Listener) {
 				_ = rConn.(*common.Chacha20Stream).Close()
 			}
 		}()
-		go func() {
-			_, err = io.Copy(rConn, wConn)
-			if nil != err {
-				if strings.Index(err.Error(), "closed") == -1 {
-					logger.Error(gCtx, map[string]interface{}{
-						"action":    config.ActionSocketOperate,
-						"errorCode": logger.ErrCodeTransfer,
-						"error":     err,
-						"remote":    remote.Name(),
-						"target":    target.String(),
-					})
-				}
-			}
-		}()
-		_, err = io.Copy(wConn, rConn)
-		if nil != err {
-			if strings.Index(err.Error(), "closed") == -1 {
-				logger.Error(gCtx, map[string]interface{}{
-					"action":    config.ActionSocketOperate,
-					"errorCode": logger.ErrCodeTransfer,
-					"error":     err,
-					"remote":    remote.Name(),
-					"target":    target.String(),
-				})
-			}
-		}
-	}))
+		acc.err = relay(gCtx, remote, target, track, quota.Wrap(userOf(gCtx), wConn), rConn)
+	})
+	err := srv.Serve(l)
 	gCtx := context.NewContext()
-	if nil != err {
+	// 监听已关闭（重载时切换端口）属于正常退出
+	if nil != err && !errors.Is(err, net.ErrClosed) {
 		logger.Error(gCtx, map[string]interface{}{
 			"action":    config.ActionRequestBegin,
 			"errorCode": logger.ErrCodeHandshake,
@@ -119,7 +159,62 @@ func (s *WSSServer) Start(l net.Listener) {
 		})
 	}
 }
-func (s *WSSServer) Handshake(ctx *context.Context, conn net.Conn) (io.ReadWriter, *common.TargetAddr, error) {
+
+// maxEarlyData Sec-WebSocket-Protocol 中早期数据的长度上限（base64 编码后）
+const maxEarlyData = 2048
+
+// decodeEarlyData 解码 Sec-WebSocket-Protocol 中 base64url 编码的早期数据，没有时返回 nil
+func decodeEarlyData(r *http.Request) ([]byte, error) {
+	proto := r.Header.Get("Sec-Websocket-Protocol")
+	if proto == "" {
+		return nil, nil
+	}
+	if len(proto) > maxEarlyData {
+		return nil, errors.New("early data too large")
+	}
+	return base64.RawURLEncoding.DecodeString(proto)
+}
+
+// forwardedFor 日志中记录的客户端地址：经 CDN 转发时取 CF-Connecting-IP 或 X-Forwarded-For 的第一个地址，
+// 否则为 TCP 对端地址。这些头可被伪造，只用于日志与连接列表
+func forwardedFor(r *http.Request) string {
+	if ip := strings.TrimSpace(r.Header.Get("Cf-Connecting-Ip")); net.ParseIP(ip) != nil {
+		return ip
+	}
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		first, _, _ := strings.Cut(xff, ",")
+		if ip := strings.TrimSpace(first); net.ParseIP(ip) != nil {
+			return ip
+		}
+	}
+	return r.RemoteAddr
+}
+
+// matchWSSRequest 请求是否符合 in.wss 的 path 与 host，未配置的项不限制
+func matchWSSRequest(r *http.Request) bool {
+	opts := config.Config.In.WSS
+	if opts.Path != "" {
+		want := opts.Path
+		if u, err := url.Parse(opts.Path); err == nil {
+			want = u.Path
+		}
+		if r.URL.Path != want {
+			return false
+		}
+	}
+	if opts.Host != "" {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if !strings.EqualFold(host, opts.Host) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *WSSServer) Handshake(ctx context2.Context, conn net.Conn) (io.ReadWriter, *common.TargetAddr, error) {
 	// 在函数退出前，执行defer
 	// 捕捉异常后，程序不会异常退出
 	defer func() {
@@ -133,16 +228,13 @@ func (s *WSSServer) Handshake(ctx *context.Context, conn net.Conn) (io.ReadWrite
 			})
 		}
 	}()
-	ec := common.NewChacha20Stream([]byte(config.Config.User), conn)
-
-	tBuf := make([]byte, 8)
-	_, err := ec.Read(tBuf)
-	if nil != err {
+	if err := conn.SetDeadline(time.Now().Add(config.HandshakeTimeout())); err != nil {
 		return nil, nil, err
 	}
-	ts := binary.BigEndian.Uint64(tBuf)
-	if uint64(time.Now().Unix())-ts > 10 {
-		return nil, nil, errors.New("The time between server and client must same.")
+	defer conn.SetDeadline(time.Time{})
+	ec, err := acceptUser(ctx, conn)
+	if nil != err {
+		return nil, nil, err
 	}
 
 	pBuf := make([]byte, 2)
@@ -151,8 +243,8 @@ func (s *WSSServer) Handshake(ctx *context.Context, conn net.Conn) (io.ReadWrite
 		return nil, nil, err
 	}
 	var proto = binary.BigEndian.Uint16(pBuf)
-	if proto != 1 && proto != 3 {
-		return nil, nil, errors.New("not support.")
+	if !common.ValidProto(proto) {
+		return nil, nil, errUnsupportedProto
 	}
 
 	dlBuf := make([]byte, 2)
@@ -186,9 +278,9 @@ func (s *WSSServer) Handshake(ctx *context.Context, conn net.Conn) (io.ReadWrite
 	}
 	ip := net.ParseIP(host)
 	var target = &common.TargetAddr{
-		Port:  port,
-		Proto: proto,
+		Port: port,
 	}
+	target.Proto, target.Feedback = common.SplitProto(proto)
 	if nil == ip {
 		target.Name = host
 	} else {
@@ -0,0 +1,105 @@
+// Package socks5 读取 SOCKS5（RFC 1928）握手消息，每个字段按长度 io.ReadFull，
+// 消息被拆成多次到达（慢速或分段发送的客户端）时同样能正确解析
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// ErrMalformed 消息不符合 RFC 1928：版本号错误、地址类型未知或域名为空
+var ErrMalformed = errors.New("malformed socks5 message")
+
+// Version5 is socks5 version number.
+const Version5 = 0x05
+
+// SOCKS auth type
+const (
+	AuthNone     = 0x00
+	AuthPassword = 0x02
+)
+
+// SOCKS request commands as defined in RFC 1928 section 4
+const (
+	CmdConnect      = 0x01
+	CmdBind         = 0x02
+	CmdUDPAssociate = 0x03
+)
+
+// SOCKS address types as defined in RFC 1928 section 4
+const (
+	ATypIP4    = 0x1
+	ATypDomain = 0x3
+	ATypIP6    = 0x4
+)
+
+// Request 客户端的命令请求，目标为 IP 或域名之一
+type Request struct {
+	Cmd  byte
+	IP   net.IP
+	Name string
+	Port int
+}
+
+// ReadHello 读取问候消息 VER NMETHODS METHODS，返回客户端支持的认证方式
+func ReadHello(r io.Reader) ([]byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	if head[0] != Version5 {
+		return nil, fmt.Errorf("%w: unsupported socks version %v", ErrMalformed, head[0])
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return nil, err
+	}
+	return methods, nil
+}
+
+// ReadRequest 读取命令消息 VER CMD RSV ATYP DST.ADDR DST.PORT
+func ReadRequest(r io.Reader) (*Request, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	if head[0] != Version5 {
+		return nil, fmt.Errorf("%w: unsupported socks version %v", ErrMalformed, head[0])
+	}
+	req := &Request{Cmd: head[1]}
+	switch head[3] {
+	case ATypIP4:
+		req.IP = make(net.IP, net.IPv4len)
+		if _, err := io.ReadFull(r, req.IP); err != nil {
+			return nil, err
+		}
+	case ATypIP6:
+		req.IP = make(net.IP, net.IPv6len)
+		if _, err := io.ReadFull(r, req.IP); err != nil {
+			return nil, err
+		}
+	case ATypDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			return nil, err
+		}
+		if l[0] == 0 {
+			return nil, fmt.Errorf("%w: empty domain name", ErrMalformed)
+		}
+		name := make([]byte, l[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, err
+		}
+		req.Name = string(name)
+	default:
+		return nil, fmt.Errorf("%w: unknown address type %v", ErrMalformed, head[3])
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return nil, err
+	}
+	req.Port = int(port[0])<<8 | int(port[1])
+	return req, nil
+}
@@ -0,0 +1,93 @@
+package socks5
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"testing/iotest"
+)
+
+// writeByByte 在另一端逐字节写入 data，模拟分段到达的客户端
+func writeByByte(t *testing.T, data []byte) io.Reader {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = client.Close()
+	})
+	go func() {
+		for i := range data {
+			if _, err := client.Write(data[i : i+1]); err != nil {
+				return
+			}
+		}
+	}()
+	return bufio.NewReader(server)
+}
+
+func TestReadHello(t *testing.T) {
+	msg := []byte{Version5, 2, AuthNone, AuthPassword}
+	for name, r := range map[string]io.Reader{
+		"one byte reader": iotest.OneByteReader(bytes.NewReader(msg)),
+		"one byte writes": writeByByte(t, msg),
+	} {
+		methods, err := ReadHello(r)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(methods) != 2 || methods[0] != AuthNone || methods[1] != AuthPassword {
+			t.Fatalf("%s: methods %v", name, methods)
+		}
+	}
+}
+
+func TestReadRequest(t *testing.T) {
+	cases := []struct {
+		name string
+		msg  []byte
+		ip   string
+		host string
+		port int
+	}{
+		{"ipv4", []byte{Version5, CmdConnect, 0, ATypIP4, 1, 2, 3, 4, 0x01, 0xbb}, "1.2.3.4", "", 443},
+		{"ipv6", append(append([]byte{Version5, CmdConnect, 0, ATypIP6}, net.ParseIP("2001:db8::1")...), 0, 80), "2001:db8::1", "", 80},
+		{"domain", append([]byte{Version5, CmdUDPAssociate, 0, ATypDomain, 11}, append([]byte("example.com"), 0x1f, 0x90)...), "", "example.com", 8080},
+	}
+	for _, c := range cases {
+		// 请求之后紧跟的数据不应被读走
+		data := append(append([]byte(nil), c.msg...), "payload"...)
+		r := writeByByte(t, data)
+		req, err := ReadRequest(r)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if c.ip != "" && !req.IP.Equal(net.ParseIP(c.ip)) || req.Name != c.host || req.Port != c.port || req.Cmd != c.msg[1] {
+			t.Fatalf("%s: got %+v", c.name, req)
+		}
+		rest := make([]byte, len("payload"))
+		if _, err = io.ReadFull(r, rest); err != nil || string(rest) != "payload" {
+			t.Fatalf("%s: trailing data %q, %v", c.name, rest, err)
+		}
+	}
+}
+
+func TestReadRequestInvalid(t *testing.T) {
+	cases := map[string][]byte{
+		"truncated":    {Version5, CmdConnect, 0, ATypIP4, 1, 2},
+		"bad version":  {0x04, CmdConnect, 0, ATypIP4, 1, 2, 3, 4, 0, 80},
+		"unknown atyp": {Version5, CmdConnect, 0, 0x09, 1, 2, 3, 4, 0, 80},
+		"empty domain": {Version5, CmdConnect, 0, ATypDomain, 0, 0, 80},
+	}
+	for name, msg := range cases {
+		_, err := ReadRequest(iotest.OneByteReader(bytes.NewReader(msg)))
+		if err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+		if name == "truncated" && !errors.Is(err, io.ErrUnexpectedEOF) || name != "truncated" && !errors.Is(err, ErrMalformed) {
+			t.Fatalf("%s: got %v", name, err)
+		}
+	}
+}
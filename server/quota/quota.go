@@ -0,0 +1,375 @@
+// Package quota 服务端按用户统计流量，执行每月流量配额，并把用量持久化到磁盘
+package quota
+
+import (
+	context2 "context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/limit"
+	"proxy/utils/context"
+	"proxy/utils/helper"
+	"proxy/utils/logger"
+)
+
+// DefaultUser 顶层 user 配置对应的用户名，不受配额限制
+const DefaultUser = "default"
+
+// ErrQuotaExceeded 用户本月流量超出配额且未配置超额限速
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// User 可登录的用户
+type User struct {
+	Name string
+	Key  []byte
+}
+
+// policy 用户的配额策略，重载时整体替换
+type policy struct {
+	quota int64         // 每月配额字节数，0 表示不限
+	up    *rate.Limiter // 超额后的上行限速，nil 表示超额后拒绝
+	down  *rate.Limiter
+}
+
+// account 用户本月用量
+type account struct {
+	up     atomic.Int64
+	down   atomic.Int64
+	policy atomic.Pointer[policy]
+	source atomic.Pointer[string] // 最近一次连接的客户端地址，不持久化
+}
+
+func (a *account) over(p *policy) bool {
+	return p.quota > 0 && a.up.Load()+a.down.Load() >= p.quota
+}
+
+// Usage 用户本月用量
+type Usage struct {
+	Name  string `json:"name"`
+	Month string `json:"month"`
+	Up    int64  `json:"up_bytes"`
+	Down  int64  `json:"down_bytes"`
+	Quota int64  `json:"quota_bytes"`
+	// LastSource 最近一次连接的客户端地址，开启 in.proxy_protocol 时为负载均衡器转发的原始地址
+	LastSource string `json:"last_source,omitempty"`
+}
+
+// usageFile 用量持久化文件格式
+type usageFile struct {
+	Month string                `json:"month"`
+	Users map[string]usageEntry `json:"users"`
+}
+
+type usageEntry struct {
+	Up   int64 `json:"up_bytes"`
+	Down int64 `json:"down_bytes"`
+}
+
+var (
+	mu       sync.RWMutex
+	users    []User
+	accounts = make(map[string]*account)
+	month    = currentMonth()
+
+	saveMu   sync.Mutex
+	stopSave chan struct{} // 关闭时停止定期写回，未在写回时为 nil
+)
+
+func init() {
+	config.RegisterLoadCallback(loadUsers)
+	config.RegisterReloadCallback(loadUsers)
+}
+
+// loadUsers 按配置重建用户列表与配额策略，已有用量保留
+func loadUsers() {
+	ctx := context.NewContext()
+	list := make([]User, 0, len(config.Config.Users.List)+1)
+	mu.Lock()
+	defer mu.Unlock()
+	for _, item := range config.Config.Users.List {
+		if item.Name == "" || item.Name == DefaultUser {
+			logger.Error(ctx, map[string]interface{}{
+				"action":    config.ActionRuntime,
+				"errorCode": logger.ErrCodeDefault,
+				"name":      item.Name,
+			}, "invalid user name, ignored")
+			continue
+		}
+		p := &policy{}
+		var err error
+		if p.quota, err = helper.ParseBytes(item.Quota); err != nil {
+			logger.Error(ctx, map[string]interface{}{
+				"action":    config.ActionRuntime,
+				"errorCode": logger.ErrCodeDefault,
+				"error":     err,
+				"name":      item.Name,
+			}, "invalid user quota, ignored")
+		}
+		throttle, err := limit.ParseRate(item.OverQuotaRate)
+		if err != nil {
+			logger.Error(ctx, map[string]interface{}{
+				"action":    config.ActionRuntime,
+				"errorCode": logger.ErrCodeDefault,
+				"error":     err,
+				"name":      item.Name,
+			}, "invalid over quota rate, ignored")
+		}
+		if throttle > 0 {
+			p.up, p.down = limit.NewLimiter(throttle), limit.NewLimiter(throttle)
+		}
+		list = append(list, User{Name: item.Name, Key: []byte(item.Key)})
+		getAccount(item.Name).policy.Store(p)
+	}
+	list = append(list, User{Name: DefaultUser, Key: []byte(config.Config.User)})
+	getAccount(DefaultUser).policy.Store(&policy{})
+	users = list
+}
+
+// getAccount 获取或创建用户用量，调用方需持有 mu 写锁
+func getAccount(name string) *account {
+	a, ok := accounts[name]
+	if !ok {
+		a = &account{}
+		a.policy.Store(&policy{})
+		accounts[name] = a
+	}
+	return a
+}
+
+func lookup(name string) *account {
+	mu.RLock()
+	a, ok := accounts[name]
+	mu.RUnlock()
+	if ok {
+		return a
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return getAccount(name)
+}
+
+// Users 返回可登录的用户，配置的用户在前，默认用户在最后
+func Users() []User {
+	mu.RLock()
+	defer mu.RUnlock()
+	return users
+}
+
+// Accept 检查用户是否还能建立新连接，并记录连接的客户端地址 source
+func Accept(name, source string) error {
+	a := lookup(name)
+	a.source.Store(&source)
+	if p := a.policy.Load(); a.over(p) && p.up == nil {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Wrap 包装服务端与客户端之间的连接，Read 计为上行、Write 计为下行
+// 超出配额后按 over_quota_rate 限速，未配置时中断连接
+func Wrap(name string, rw io.ReadWriter) io.ReadWriter {
+	return &conn{ReadWriter: rw, account: lookup(name)}
+}
+
+type conn struct {
+	io.ReadWriter
+	account *account
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	pol := c.account.policy.Load()
+	over := c.account.over(pol)
+	if over {
+		if pol.up == nil {
+			return 0, ErrQuotaExceeded
+		}
+		if burst := pol.up.Burst(); len(p) > burst {
+			p = p[:burst]
+		}
+	}
+	n, err := c.ReadWriter.Read(p)
+	c.account.up.Add(int64(n))
+	if over && n > 0 {
+		_ = pol.up.WaitN(context2.Background(), n)
+	}
+	return n, err
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	pol := c.account.policy.Load()
+	var w io.Writer = c.ReadWriter
+	if c.account.over(pol) {
+		if pol.down == nil {
+			return 0, ErrQuotaExceeded
+		}
+		w = limit.NewWriter(c.ReadWriter, pol.down)
+	}
+	n, err := w.Write(p)
+	c.account.down.Add(int64(n))
+	return n, err
+}
+
+// CloseWrite 半关闭内层连接的写方向
+func (c *conn) CloseWrite() error {
+	return common.HalfClose(c.ReadWriter)
+}
+
+// List 返回所有用户本月用量，按用户名排序
+func List() []Usage {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := make([]Usage, 0, len(accounts))
+	for name, a := range accounts {
+		u := Usage{
+			Name:  name,
+			Month: month,
+			Up:    a.up.Load(),
+			Down:  a.down.Load(),
+			Quota: a.policy.Load().quota,
+		}
+		if source := a.source.Load(); source != nil {
+			u.LastSource = *source
+		}
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Start 读取持久化的用量，并定期切换月份、写回磁盘
+func Start(ctx context2.Context) {
+	if err := load(); err != nil && !os.IsNotExist(err) {
+		logger.Error(ctx, map[string]interface{}{
+			"action":    config.ActionRuntime,
+			"errorCode": logger.ErrCodeDefault,
+			"error":     err,
+			"file":      quotaFile(),
+		}, "load quota usage failed")
+	}
+	Resume(ctx)
+}
+
+// Resume 开始定期切换月份、写回磁盘，已在写回时不做处理；平滑重启失败后由此恢复
+func Resume(ctx context2.Context) {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+	if stopSave != nil {
+		return
+	}
+	stop := make(chan struct{})
+	stopSave = stop
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			rollover()
+			if err := Save(); err != nil {
+				logger.Error(ctx, map[string]interface{}{
+					"action":    config.ActionRuntime,
+					"errorCode": logger.ErrCodeDefault,
+					"error":     err,
+					"file":      quotaFile(),
+				}, "save quota usage failed")
+			}
+		}
+	}()
+}
+
+// Stop 停止定期写回并最后写入一次用量，平滑重启交出监听前调用，之后用量文件由新进程写入
+func Stop() error {
+	saveMu.Lock()
+	if stopSave != nil {
+		close(stopSave)
+		stopSave = nil
+	}
+	saveMu.Unlock()
+	return Save()
+}
+
+// Save 把本月用量写入 users.quota_file
+func Save() error {
+	mu.RLock()
+	data := usageFile{Month: month, Users: make(map[string]usageEntry, len(accounts))}
+	for name, a := range accounts {
+		data.Users[name] = usageEntry{Up: a.up.Load(), Down: a.down.Load()}
+	}
+	mu.RUnlock()
+	buf, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := quotaFile()
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// load 读取持久化的用量，不是本月的记录直接丢弃
+func load() error {
+	buf, err := os.ReadFile(quotaFile())
+	if err != nil {
+		return err
+	}
+	var data usageFile
+	if err := json.Unmarshal(buf, &data); err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if data.Month != month {
+		return nil
+	}
+	for name, entry := range data.Users {
+		a := getAccount(name)
+		a.up.Store(entry.Up)
+		a.down.Store(entry.Down)
+	}
+	return nil
+}
+
+// rollover 进入新的月份时清零所有用户的用量
+func rollover() {
+	now := currentMonth()
+	mu.Lock()
+	defer mu.Unlock()
+	if now == month {
+		return
+	}
+	month = now
+	for _, a := range accounts {
+		a.up.Store(0)
+		a.down.Store(0)
+	}
+}
+
+func currentMonth() string {
+	return time.Now().In(config.CstZone).Format("2006-01")
+}
+
+func quotaFile() string {
+	if config.Config.Users.QuotaFile == "" {
+		return "quota.json"
+	}
+	return config.Config.Users.QuotaFile
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	context2 "context"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/crash"
+	"proxy/server/proxy/server"
+	"proxy/server/systemproxy"
+	"proxy/server/tun"
+	"proxy/server/upgrade"
+	"proxy/utils/context"
+	"proxy/utils/logger"
+)
+
+var (
+	listenMu   sync.Mutex
+	listenBase = context2.Background() // 入口监听的父 ctx，由 Run 设置
+	listener   net.Listener            // 当前的入口监听
+	listenStop context2.CancelFunc     // 取消当前监听的 ctx，使其 Start 返回
+	listenSrv  common.Server           // 当前监听上运行的服务，新监听失败时重新打开旧监听用
+	listenType int8                    // 当前监听对应的 in.type
+	listenPort int                     // 当前监听对应的 in.port
+	listenAddr string                  // 当前监听的地址 in.listen:in.port
+	listenPP   bool                    // 当前监听是否读取 PROXY protocol 头
+
+	tunApplied *tunSettings // 运行中的 TUN 所用的配置，受 toggleMu 保护
+	proxyPort  int          // 系统代理指向的本地端口，0 表示未设置，受 toggleMu 保护
+)
+
+// tunSettings TUN 服务依赖的配置，任一项变化都需要重启 TUN
+type tunSettings struct {
+	tun    interface{} // config.Config.Tun 的副本
+	port   int         // tun2socks 转发到的本地入口端口
+	remote []string    // 需要直连路由的远端服务器
+}
+
+func currentTunSettings() *tunSettings {
+	// 是否启用由 config.TunEnabled 决定，不参与比较
+	t := config.Config.Tun
+	t.Enable = false
+	return &tunSettings{
+		tun:    t,
+		port:   config.Config.In.Port,
+		remote: append(config.RemoteAddrs(), config.Config.Out.HTTPProxy.Addr),
+	}
+}
+
+// startListener 按当前配置开启入口监听并替换旧的监听；旧监听上已建立的连接不受影响。
+// 先绑定新监听再关闭旧监听，只有与旧监听冲突（同一端口）时才先关闭旧监听，新监听仍失败时按旧的设置重新打开，入口不会因重载失败而中断
+func startListener(ctx context2.Context) error {
+	listenMu.Lock()
+	defer listenMu.Unlock()
+	addr := config.ListenAddr()
+	s := NewServer()
+	if nil == s {
+		logger.Error(ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+		}, "unknown server type")
+		return fmt.Errorf("unknown server type %d", config.Config.In.Type)
+	}
+	inType := config.Config.In.Type
+	l, err := listen(inType, addr)
+	if err != nil && listener != nil && listenPort == config.Config.In.Port {
+		// 端口被旧监听占用，关闭后重新绑定
+		old, oldServer, oldType, oldAddr, oldPP := listener, listenSrv, listenType, listenAddr, listenPP
+		listenStop()
+		old.Close()
+		listener, listenStop = nil, nil
+		if l, err = listen(inType, addr); err != nil {
+			reopenListener(ctx, oldServer, oldType, oldAddr, oldPP)
+		}
+	}
+	if err != nil {
+		logger.Errorf(ctx, map[string]interface{}{
+			"action":    config.ActionSocketOperate,
+			"errorCode": logger.ErrCodeListen,
+			"error":     err,
+		}, "can not listen on %v: %v", addr, err)
+		return err
+	}
+	if listener != nil {
+		listenStop()
+		listener.Close()
+	}
+	serveListener(ctx, s, l, inType, addr, config.Config.In.ProxyProtocol && !isUDPServer(inType))
+	return nil
+}
+
+// listen 按入口类型监听 addr：QUIC 与 KCP 使用 UDP 端口，不经 upgrade 交接
+func listen(inType int8, addr string) (net.Listener, error) {
+	switch inType {
+	case config.ServerTypeQUIC:
+		return server.ListenQUIC(addr)
+	case config.ServerTypeKCP:
+		return server.ListenKCP(addr)
+	default:
+		return upgrade.Listen(addr)
+	}
+}
+
+// reopenListener 新监听绑定失败后按旧监听的类型与地址重新打开，仍由旧的服务处理连接，调用方需持有 listenMu
+func reopenListener(ctx context2.Context, s common.Server, inType int8, addr string, pp bool) {
+	l, err := listen(inType, addr)
+	if err != nil {
+		logger.Errorf(ctx, map[string]interface{}{
+			"action":    config.ActionSocketOperate,
+			"errorCode": logger.ErrCodeListen,
+			"error":     err,
+		}, "can not reopen previous listener on %v: %v", addr, err)
+		return
+	}
+	serveListener(ctx, s, l, inType, addr, pp)
+	logger.Warn(ctx, map[string]interface{}{
+		"action": config.ActionSocketOperate,
+		"inType": inType,
+		"addr":   addr,
+	}, "new listener failed, previous listener reopened")
+}
+
+// serveListener 在 l 上包装 PROXY protocol、访问控制、连接数限制与 SNI 分流后启动 s，并记为当前监听，调用方需持有 listenMu
+func serveListener(ctx context2.Context, s common.Server, l net.Listener, inType int8, addr string, pp bool) {
+	_, port, _ := net.SplitHostPort(addr)
+	lctx, stop := context2.WithCancel(listenBase)
+	listener, listenStop, listenSrv, listenType, listenAddr, listenPP = l, stop, s, inType, addr, pp
+	listenPort, _ = strconv.Atoi(port)
+	if config.Config.TCP.FastOpen && !isUDPServer(inType) {
+		if err := common.EnableFastOpen(l); err != nil {
+			logger.Warn(ctx, map[string]interface{}{
+				"action": config.ActionSocketOperate,
+				"error":  err,
+			}, "can not enable tcp fast open on listener")
+		}
+	}
+	tuned := common.TuneListener(l)
+	if pp {
+		tuned = common.NewProxyProtoListener(tuned)
+	}
+	// 访问控制在 PROXY protocol 之后，按负载均衡转发的原始客户端地址检查
+	accepted := server.NewLimitListener(common.NewACLListener(tuned))
+	// TLS 类入口按 SNI 把其他域名的连接转发到 in.fallback
+	if needsCert(inType) && inType != config.ServerTypeQUIC {
+		accepted = server.NewSNIListener(accepted)
+	}
+	crash.Go(func() { s.Start(lctx, accepted) })
+}
+
+// stopListener 关闭入口监听，已建立的连接不受影响
+func stopListener() {
+	listenMu.Lock()
+	defer listenMu.Unlock()
+	if listener != nil {
+		listenStop()
+		listener.Close()
+		listener, listenStop = nil, nil
+	}
+}
+
+// applyReload 配置重载回调：比较已生效的设置，只重启有变化的监听、端口转发、系统代理与 TUN
+func applyReload() {
+	ctx := context.NewContext()
+	listenMu.Lock()
+	if listener == nil {
+		// 服务未运行或已关闭
+		listenMu.Unlock()
+		return
+	}
+	changed := listenType != config.Config.In.Type || listenAddr != config.ListenAddr() ||
+		listenPP != (config.Config.In.ProxyProtocol && !isUDPServer(config.Config.In.Type))
+	listenMu.Unlock()
+	if changed {
+		if needsCert(config.Config.In.Type) && !needsCert(listenType) {
+			logger.Warn(ctx, map[string]interface{}{
+				"action": config.ActionRuntime,
+				"inType": config.Config.In.Type,
+			}, "switching to a TLS/WSS/QUIC/gRPC inbound needs a certificate, restart to apply")
+		} else if err := startListener(ctx); err == nil {
+			logger.Info(ctx, map[string]interface{}{
+				"action": config.ActionRuntime,
+				"inType": config.Config.In.Type,
+				"port":   config.Config.In.Port,
+			}, "listener restarted after reload")
+		}
+	}
+
+	applyForwards(ctx)
+
+	toggleMu.Lock()
+	defer toggleMu.Unlock()
+	reloadSystemProxy(ctx)
+	if err := reloadTun(ctx); err != nil {
+		logger.Error(ctx, map[string]interface{}{
+			"action":    config.ActionRuntime,
+			"errorCode": logger.ErrCodeHandshake,
+			"error":     err,
+		}, "failed to restart TUN service after reload")
+	}
+}
+
+func needsCert(inType int8) bool {
+	return inType >= config.ServerTypeTLS && inType <= config.ServerTypeMixed
+}
+
+// isTunnelServer 入口是否为接受加密隧道的服务端（TLS/WSS/QUIC/gRPC/混合/KCP 入口），需按用户统计流量
+func isTunnelServer(inType int8) bool {
+	return inType >= config.ServerTypeTLS && inType <= config.ServerTypeGRPC || inType == config.ServerTypeMixed ||
+		inType == config.ServerTypeKCP
+}
+
+// isUDPServer 入口是否监听 UDP 端口（QUIC/KCP），不支持 PROXY protocol 与 TCP Fast Open
+func isUDPServer(inType int8) bool {
+	return inType == config.ServerTypeQUIC || inType == config.ServerTypeKCP
+}
+
+// reloadSystemProxy 按 config.SystemProxyEnabled 与 in.port 设置或恢复系统代理，调用方需持有 toggleMu
+func reloadSystemProxy(ctx context2.Context) {
+	want := 0
+	if config.SystemProxyEnabled() {
+		want = config.Config.In.Port
+	}
+	if want == proxyPort {
+		return
+	}
+	if proxyPort != 0 {
+		systemproxy.Restore(ctx)
+	}
+	if want != 0 {
+		systemproxy.Apply(ctx, want)
+	}
+	proxyPort = want
+}
+
+// reloadTun 按 config.TunEnabled 与 tun 配置启停 TUN，运行中且依赖的配置有变化时重启，调用方需持有 toggleMu
+func reloadTun(ctx context2.Context) error {
+	want := config.TunEnabled()
+	if tunService != nil {
+		if want && reflect.DeepEqual(tunApplied, currentTunSettings()) {
+			return nil
+		}
+		err := tunService.Stop()
+		tunService, tunApplied = nil, nil
+		if err != nil {
+			return err
+		}
+		logger.Info(ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+		}, "TUN service stopped")
+	}
+	if !want {
+		return nil
+	}
+	service, err := tun.NewService()
+	if err == nil {
+		err = service.Start()
+	}
+	if err != nil {
+		return err
+	}
+	tunService, tunApplied = service, currentTunSettings()
+	logger.Info(ctx, map[string]interface{}{
+		"action": config.ActionRuntime,
+	}, "TUN service started")
+	return nil
+}
@@ -0,0 +1,54 @@
+//go:build !windows
+
+package server
+
+import (
+	context2 "context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"proxy/config"
+	"proxy/server/crash"
+	"proxy/utils/logger"
+)
+
+// watchReloadSignal 收到 SIGHUP 时重新加载配置文件
+func watchReloadSignal(ctx context2.Context) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	crash.Go(func() {
+		for range ch {
+			if err := config.ReloadConfig(); err != nil {
+				logger.Error(ctx, map[string]interface{}{
+					"action":    config.ActionRuntime,
+					"errorCode": logger.ErrCodeDefault,
+					"error":     err,
+				}, "reload config by SIGHUP failed")
+				continue
+			}
+			logger.Info(ctx, map[string]interface{}{
+				"action": config.ActionRuntime,
+			}, "config reloaded by SIGHUP")
+		}
+	})
+}
+
+// watchUpgradeSignal 收到 SIGUSR2 时平滑重启，失败时继续运行
+func watchUpgradeSignal(ctx context2.Context, p *Proxy) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR2)
+	crash.Go(func() {
+		for range ch {
+			if err := p.Upgrade(); err != nil {
+				logger.Error(ctx, map[string]interface{}{
+					"action":    config.ActionRuntime,
+					"errorCode": logger.ErrCodeDefault,
+					"error":     err,
+				}, "graceful restart by SIGUSR2 failed")
+				continue
+			}
+			return
+		}
+	})
+}
@@ -0,0 +1,13 @@
+//go:build windows
+
+package server
+
+import (
+	context2 "context"
+)
+
+// watchReloadSignal Windows 没有 SIGHUP，通过管理接口 /api/reload 重新加载配置
+func watchReloadSignal(ctx context2.Context) {}
+
+// watchUpgradeSignal Windows 不支持平滑重启
+func watchUpgradeSignal(ctx context2.Context, p *Proxy) {}
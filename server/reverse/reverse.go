@@ -0,0 +1,221 @@
+// Package reverse 客户端一侧的反向隧道（类似 ssh -R）：经 out 的加密隧道请求服务端开放端口，
+// 服务端在该端口收到的连接经新建的数据连接转发到本地或局域网的服务
+package reverse
+
+import (
+	context2 "context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/route"
+	"proxy/utils/context"
+	"proxy/utils/logger"
+)
+
+const (
+	heartbeatTimeout = 90 * time.Second // 控制连接超过该时间没有收到任何数据（服务端每 30s 发送心跳）时重连
+	minBackoff       = time.Second
+	maxBackoff       = time.Minute
+)
+
+var (
+	mu      sync.Mutex
+	started bool
+	cancel  context2.CancelFunc
+	applied string // 当前生效的 reverse.tunnels，重载时据此判断是否需要重建
+)
+
+func init() {
+	config.RegisterReloadCallback(reload)
+}
+
+// Start 为 reverse.tunnels 中的每条隧道维持一条控制连接，断开后按退避间隔重连；重载后配置有变化时重建全部隧道
+func Start(ctx context2.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+	started = true
+	apply(ctx)
+}
+
+// Stop 断开全部隧道，服务端随之关闭开放的端口
+func Stop() {
+	mu.Lock()
+	defer mu.Unlock()
+	started = false
+	if cancel != nil {
+		cancel()
+		cancel = nil
+	}
+	applied = ""
+}
+
+func reload() {
+	mu.Lock()
+	defer mu.Unlock()
+	if started {
+		apply(context.NewContext())
+	}
+}
+
+func apply(ctx context2.Context) {
+	tunnels := config.Config.Reverse.Tunnels
+	b, _ := json.Marshal(tunnels)
+	if string(b) == applied {
+		return
+	}
+	applied = string(b)
+	if cancel != nil {
+		cancel()
+		cancel = nil
+	}
+	if len(tunnels) == 0 {
+		return
+	}
+	c, cn := context2.WithCancel(context2.Background())
+	cancel = cn
+	for _, t := range tunnels {
+		go keep(c, ctx, t.Remote, t.Local)
+	}
+}
+
+// keep 维持一条隧道，c 取消时返回
+func keep(c context2.Context, ctx context2.Context, remoteAddr, local string) {
+	backoff := minBackoff
+	for {
+		opened, err := serve(c, ctx, remoteAddr, local)
+		if c.Err() != nil {
+			return
+		}
+		if opened {
+			backoff = minBackoff
+		}
+		logger.Warn(ctx, map[string]interface{}{
+			"action": config.ActionSocketOperate,
+			"error":  err,
+			"remote": remoteAddr,
+			"local":  local,
+			"retry":  backoff.String(),
+		}, "reverse tunnel disconnected")
+		select {
+		case <-c.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// serve 建立控制连接并读取服务端下发的连接 ID，每个 ID 新建一条数据连接转发到 local；
+// 控制连接断开时返回，opened 表示服务端已开放端口
+func serve(c context2.Context, ctx context2.Context, remoteAddr, local string) (opened bool, err error) {
+	remote := route.TunnelRemote()
+	if remote == nil {
+		return false, errors.New("out.type is not an encrypted tunnel")
+	}
+	target, err := common.NewTargetAddr(remoteAddr)
+	if err != nil {
+		return false, err
+	}
+	target.Proto = common.ProtoReverse
+	rw, err := remote.Handshake(ctx, target)
+	if err != nil {
+		return false, err
+	}
+	defer closeQuietly(rw)
+	stop := context2.AfterFunc(c, func() {
+		closeQuietly(rw)
+	})
+	defer stop()
+	if err = readStatus(rw); err != nil {
+		return false, err
+	}
+	logger.Info(ctx, map[string]interface{}{
+		"action": config.ActionSocketOperate,
+		"remote": remoteAddr,
+		"local":  local,
+	}, "reverse tunnel opened")
+	idle := common.NewIdleTimer(heartbeatTimeout, func() {
+		closeQuietly(rw)
+	})
+	defer idle.Stop()
+	r := idle.Reader(rw)
+	id := make([]byte, 8)
+	for {
+		if _, err = io.ReadFull(r, id); err != nil {
+			return true, err
+		}
+		// 全 0 为心跳
+		if [8]byte(id) == [8]byte{} {
+			continue
+		}
+		go forward(remote, hex.EncodeToString(id), local)
+	}
+}
+
+// readStatus 读取服务端对控制连接的应答：1 字节状态（0 成功），失败时跟 1 字节长度与原因
+func readStatus(r io.Reader) error {
+	buf := make([]byte, 255)
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return err
+	}
+	if buf[0] == 0 {
+		return nil
+	}
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return err
+	}
+	msg := buf[:buf[0]]
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return err
+	}
+	return errors.New("server rejected reverse tunnel: " + string(msg))
+}
+
+// forward 连接 local，再以连接 ID 建立数据连接，两者之间双向转发，见 common.RelayIdle；两个方向都超过 timeouts.idle 没有数据时关闭两端
+func forward(remote common.Remote, id, local string) {
+	ctx := context.NewContext()
+	// 本地服务可能在回环地址上，不绑定原默认接口
+	lc, err := net.DialTimeout("tcp", local, config.DialTimeout())
+	if err != nil {
+		logger.ErrorAggregated(ctx, "reverse:"+local, map[string]interface{}{
+			"action":    config.ActionSocketOperate,
+			"errorCode": logger.ErrCodeHandshake,
+			"error":     err,
+			"local":     local,
+		})
+		return
+	}
+	defer lc.Close()
+	rw, err := remote.Handshake(ctx, &common.TargetAddr{Name: id, Proto: common.ProtoReverseData})
+	if err != nil {
+		logger.ErrorAggregated(ctx, "reverse:"+remote.Name(), map[string]interface{}{
+			"action":    config.ActionSocketOperate,
+			"errorCode": logger.ErrCodeHandshake,
+			"error":     err,
+			"remote":    remote.Name(),
+		})
+		return
+	}
+	defer closeQuietly(rw)
+	_, _ = common.RelayIdle(lc, rw, config.IdleTimeout(), func() {
+		logger.Debug(ctx, map[string]interface{}{
+			"action": config.ActionSocketOperate,
+			"local":  local,
+		}, "reverse tunnel idle timeout, connection closed")
+		closeQuietly(rw)
+		_ = lc.Close()
+	})
+}
+
+func closeQuietly(v interface{}) {
+	if closer, ok := v.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
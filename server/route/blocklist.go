@@ -0,0 +1,328 @@
+package route
+
+import (
+	"bufio"
+	context2 "context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/utils/logger"
+)
+
+const (
+	defaultBlockInterval = 24 * time.Hour
+	defaultBlockCacheDir = "adblock"
+	maxBlocklistSize     = 32 << 20 // 单个远程列表的大小上限
+)
+
+// blockReload 配置重载后通知刷新协程重新加载列表
+var blockReload = make(chan struct{}, 1)
+
+// blocklist adblock.lists 的解析结果：hosts 格式与纯域名按域名精确匹配，AdGuard 的 ||domain^ 同时匹配子域名，
+// @@ 开头的例外规则优先；取值为规则所在的列表，用于日志与访问记录
+type blocklist struct {
+	exact       map[string]string
+	suffix      map[string]string
+	allowExact  map[string]string
+	allowSuffix map[string]string
+}
+
+func newBlocklist() *blocklist {
+	return &blocklist{
+		exact:       make(map[string]string),
+		suffix:      make(map[string]string),
+		allowExact:  make(map[string]string),
+		allowSuffix: make(map[string]string),
+	}
+}
+
+// add 逐行解析列表内容，返回加入的规则数，无法识别的行（元素隐藏、带路径或修饰符的规则等）忽略
+func (b *blocklist) add(r io.Reader, source string) (n int, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		domains, suffix, allow := parseBlockLine(scanner.Text())
+		for _, domain := range domains {
+			switch {
+			case allow && suffix:
+				b.allowSuffix[domain] = source
+			case allow:
+				b.allowExact[domain] = source
+			case suffix:
+				b.suffix[domain] = source
+			default:
+				b.exact[domain] = source
+			}
+			n++
+		}
+	}
+	return n, scanner.Err()
+}
+
+// match 返回拦截 host 的规则描述，未拦截时返回空串
+func (b *blocklist) match(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return ""
+	}
+	if _, ok := b.allowExact[host]; ok {
+		return ""
+	}
+	for d := host; ; {
+		if _, ok := b.allowSuffix[d]; ok {
+			return ""
+		}
+		i := strings.IndexByte(d, '.')
+		if i < 0 {
+			break
+		}
+		d = d[i+1:]
+	}
+	if source, ok := b.exact[host]; ok {
+		return host + " (" + source + ")"
+	}
+	for d := host; ; {
+		if source, ok := b.suffix[d]; ok {
+			return "||" + d + "^ (" + source + ")"
+		}
+		i := strings.IndexByte(d, '.')
+		if i < 0 {
+			return ""
+		}
+		d = d[i+1:]
+	}
+}
+
+// parseBlockLine 解析一行规则：hosts 格式（0.0.0.0 a.com b.com）、纯域名、AdGuard 域名规则（||a.com^，
+// 仅允许 $important 等不限定请求类型的修饰符）及其例外（@@||a.com^）
+func parseBlockLine(line string) (domains []string, suffix, allow bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '!' || line[0] == '#' || line[0] == '[' {
+		return nil, false, false
+	}
+	if strings.HasPrefix(line, "@@") {
+		allow, line = true, line[2:]
+	}
+	if strings.HasPrefix(line, "||") {
+		rule, modifiers, _ := strings.Cut(line[2:], "$")
+		for _, m := range strings.Split(modifiers, ",") {
+			if m != "" && m != "important" && m != "all" && m != "document" {
+				return nil, false, false
+			}
+		}
+		rule = strings.TrimSuffix(strings.TrimSuffix(rule, "|"), "^")
+		if domain, ok := normalizeBlockDomain(rule); ok {
+			return []string{domain}, true, allow
+		}
+		return nil, false, false
+	}
+	// 行尾注释需以空白分隔，example.com##.banner 之类的元素隐藏规则整行忽略
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		if line[i-1] != ' ' && line[i-1] != '\t' {
+			return nil, false, false
+		}
+		line = line[:i]
+	}
+	if allow || strings.ContainsAny(line, "|^$/*") {
+		return nil, false, false
+	}
+	fields := strings.Fields(line)
+	if len(fields) > 1 && net.ParseIP(fields[0]) != nil {
+		fields = fields[1:]
+	} else if len(fields) != 1 {
+		return nil, false, false
+	}
+	for _, f := range fields {
+		if domain, ok := normalizeBlockDomain(f); ok {
+			domains = append(domains, domain)
+		}
+	}
+	return domains, false, false
+}
+
+// normalizeBlockDomain 转为小写并校验域名，排除 localhost 等 hosts 文件中常见的本机名称
+func normalizeBlockDomain(s string) (string, bool) {
+	s = strings.TrimSuffix(strings.ToLower(s), ".")
+	if !strings.Contains(s, ".") || net.ParseIP(s) != nil || strings.HasSuffix(s, ".localdomain") || strings.HasSuffix(s, ".local") {
+		return "", false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_') {
+			return "", false
+		}
+	}
+	return s, true
+}
+
+// adBlockedRemote 命中 adblock.lists 的连接使用的出口，握手直接失败
+type adBlockedRemote struct{}
+
+func (r *adBlockedRemote) Handshake(ctx context2.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+	return nil, errors.New("blocked by adblock list")
+}
+
+func (r *adBlockedRemote) Name() string {
+	return "AdBlocked"
+}
+
+// StartBlocklists 加载 adblock.lists（远程列表优先使用本地缓存），之后按 adblock.interval 重新下载远程列表；
+// 配置重载后按新的列表重新加载
+func StartBlocklists(ctx context2.Context) {
+	config.RegisterReloadCallback(func() {
+		select {
+		case blockReload <- struct{}{}:
+		default:
+		}
+	})
+	go func() {
+		loadBlocklists(ctx, false)
+		for {
+			select {
+			case <-time.After(blockInterval(ctx)):
+				loadBlocklists(ctx, true)
+			case <-blockReload:
+				loadBlocklists(ctx, false)
+			}
+		}
+	}()
+}
+
+// loadBlocklists 读取全部列表并替换规则引擎中的拦截规则；refresh 为 false 时远程列表有缓存则直接使用缓存，
+// 下载失败的远程列表使用上次的缓存
+func loadBlocklists(ctx context2.Context, refresh bool) {
+	lists := config.Config.AdBlock.Lists
+	if len(lists) == 0 {
+		GetRuleEngine().setBlocklist(nil)
+		return
+	}
+	b := newBlocklist()
+	client := &http.Client{
+		Timeout: time.Minute,
+		Transport: &http.Transport{
+			// 绑定原默认接口，避免下载请求走 TUN
+			DialContext: func(c context2.Context, network, addr string) (net.Conn, error) {
+				return common.GetOriginalInterfaceDialer().DialContext(c, network, addr)
+			},
+		},
+	}
+	for _, list := range lists {
+		path := list
+		if isRemoteList(list) {
+			path = blockCachePath(list)
+			if _, err := os.Stat(path); refresh || err != nil {
+				if err = downloadBlocklist(client, list, path); err != nil {
+					logger.Error(ctx, map[string]interface{}{
+						"action":    config.ActionRuntime,
+						"errorCode": logger.ErrCodeDefault,
+						"error":     err,
+						"list":      list,
+					}, "download adblock list failed, use the cached copy")
+				}
+			}
+		}
+		n, err := readBlocklist(b, path, list)
+		if err != nil {
+			logger.Error(ctx, map[string]interface{}{
+				"action":    config.ActionRuntime,
+				"errorCode": logger.ErrCodeDefault,
+				"error":     err,
+				"list":      list,
+			}, "load adblock list failed")
+			continue
+		}
+		logger.Info(ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"list":   list,
+			"rules":  n,
+		}, "adblock list loaded")
+	}
+	GetRuleEngine().setBlocklist(b)
+}
+
+func readBlocklist(b *blocklist, path, source string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return b.add(f, source)
+}
+
+// downloadBlocklist 下载远程列表，完整下载后才替换缓存文件
+func downloadBlocklist(client *http.Client, url, path string) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "celestial-ladder")
+	rsp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("adblock list returned %s", rsp.Status)
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, io.LimitReader(rsp.Body, maxBlocklistSize+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > maxBlocklistSize {
+		err = fmt.Errorf("adblock list exceeds %d bytes", maxBlocklistSize)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func isRemoteList(list string) bool {
+	return strings.HasPrefix(list, "http://") || strings.HasPrefix(list, "https://")
+}
+
+// blockCachePath 远程列表在 adblock.cache_dir 中的缓存文件，按地址的哈希命名
+func blockCachePath(url string) string {
+	dir := config.Config.AdBlock.CacheDir
+	if dir == "" {
+		dir = defaultBlockCacheDir
+	}
+	sum := sha1.Sum([]byte(url))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".txt")
+}
+
+func blockInterval(ctx context2.Context) time.Duration {
+	raw := config.Config.AdBlock.Interval
+	if raw == "" {
+		return defaultBlockInterval
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < time.Minute {
+		logger.Error(ctx, map[string]interface{}{
+			"action":    config.ActionRuntime,
+			"errorCode": logger.ErrCodeDefault,
+			"interval":  raw,
+		}, "invalid adblock interval, use 24h")
+		return defaultBlockInterval
+	}
+	return d
+}
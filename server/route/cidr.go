@@ -0,0 +1,75 @@
+package route
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"net"
+	"sort"
+)
+
+// ipv4Range 闭区间 [start, end] 表示的 IPv4 地址段，end 用 uint64 保存以便计算 end+1
+type ipv4Range struct {
+	start uint64
+	end   uint64
+}
+
+func ipv4ToUint(ip net.IP) (uint64, bool) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return 0, false
+	}
+	return uint64(binary.BigEndian.Uint32(ip4)), true
+}
+
+func uintToIPv4(v uint64) net.IP {
+	return net.IP(binary.BigEndian.AppendUint32(nil, uint32(v)))
+}
+
+// networkRange CIDR 覆盖的地址段，IPv6 网段返回 false
+func networkRange(network *net.IPNet) (ipv4Range, bool) {
+	start, ok := ipv4ToUint(network.IP.Mask(network.Mask))
+	ones, size := network.Mask.Size()
+	if !ok || size != 32 {
+		return ipv4Range{}, false
+	}
+	return ipv4Range{start: start, end: start + 1<<(32-ones) - 1}, true
+}
+
+// rangeToCIDRs 恰好覆盖 [start, end] 的最少 CIDR：每次取起点对齐且不超出终点的最大网段
+func rangeToCIDRs(r ipv4Range) []*net.IPNet {
+	var cidrs []*net.IPNet
+	for start := r.start; start <= r.end; {
+		// 起点对齐决定的最大块
+		size := bits.TrailingZeros32(uint32(start))
+		// 不超出终点
+		for size > 0 && start+1<<size-1 > r.end {
+			size--
+		}
+		cidrs = append(cidrs, &net.IPNet{IP: uintToIPv4(start), Mask: net.CIDRMask(32-size, 32)})
+		start += 1 << size
+	}
+	return cidrs
+}
+
+// aggregateRanges 合并重叠与相邻的地址段后转换为最少的 CIDR
+func aggregateRanges(ranges []ipv4Range) []*net.IPNet {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sorted := append([]ipv4Range(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start < sorted[j].start })
+	merged := []ipv4Range{sorted[0]}
+	for _, r := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if r.start <= last.end+1 {
+			last.end = max(last.end, r.end)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	var cidrs []*net.IPNet
+	for _, r := range merged {
+		cidrs = append(cidrs, rangeToCIDRs(r)...)
+	}
+	return cidrs
+}
@@ -0,0 +1,114 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/utils/logger"
+)
+
+// knownDoHServers 常见公共 DoH/DoT 服务器的地址与域名，浏览器等应用内置的加密 DNS 多指向它们
+var knownDoHServers = []string{
+	// Google
+	"8.8.8.8", "8.8.4.4", "2001:4860:4860::8888", "2001:4860:4860::8844", "dns.google", "dns.google.com",
+	// Cloudflare
+	"1.1.1.1", "1.0.0.1", "2606:4700:4700::1111", "2606:4700:4700::1001",
+	"cloudflare-dns.com", "mozilla.cloudflare-dns.com", "chrome.cloudflare-dns.com", "one.one.one.one",
+	// Quad9
+	"9.9.9.9", "149.112.112.112", "2620:fe::fe", "2620:fe::9", "dns.quad9.net", "dns9.quad9.net",
+	// OpenDNS
+	"208.67.222.222", "208.67.220.220", "doh.opendns.com",
+	// AdGuard
+	"94.140.14.14", "94.140.15.15", "dns.adguard.com", "dns.adguard-dns.com",
+	// NextDNS / CleanBrowsing
+	"dns.nextdns.io", "doh.cleanbrowsing.org",
+}
+
+var (
+	knownDoHIPs   = map[string]bool{}
+	knownDoHHosts = map[string]bool{}
+)
+
+func init() {
+	for _, s := range knownDoHServers {
+		if ip := net.ParseIP(s); ip != nil {
+			knownDoHIPs[ip.String()] = true
+		} else {
+			knownDoHHosts[s] = true
+		}
+	}
+}
+
+var (
+	dnsHijack   common.Remote
+	dnsHijackMu sync.RWMutex
+)
+
+// SetDNSHijack 设置 dns.guard 劫持 53 端口查询时使用的出口，由 TUN 的 DNS 处理提供
+func SetDNSHijack(r common.Remote) {
+	dnsHijackMu.Lock()
+	defer dnsHijackMu.Unlock()
+	dnsHijack = r
+}
+
+func getDNSHijack() common.Remote {
+	dnsHijackMu.RLock()
+	defer dnsHijackMu.RUnlock()
+	return dnsHijack
+}
+
+// dohBlockedRemote dns.guard 阻断的连接使用的出口，握手直接失败
+type dohBlockedRemote struct {
+}
+
+func (r *dohBlockedRemote) Handshake(ctx context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+	return nil, errors.New("blocked by dns.guard: third-party DoH/DoT server")
+}
+
+func (r *dohBlockedRemote) Name() string {
+	return "DoHBlocked"
+}
+
+// guardDNS 开启 dns.guard 且处于 TUN 模式时，53 端口的查询交给本机 DoH 应答，
+// 发往已知第三方 DoH/DoT 服务器（443、853 端口）且不在 dns.guard.allow 中的连接被阻断；两者都记录到日志
+func guardDNS(ctx context.Context, engine *RuleEngine, target *common.TargetAddr, key string) *Decision {
+	if !config.Config.DNS.Guard.Enable || !config.TunEnabled() {
+		return nil
+	}
+	if target.Port == 53 {
+		hijack := getDNSHijack()
+		if hijack == nil {
+			return nil
+		}
+		logger.WarnAggregated(ctx, "dns_guard:"+key, map[string]interface{}{
+			"action": config.ActionSocketOperate,
+			"target": key,
+		}, "plain DNS query hijacked to DoH")
+		return &Decision{Remote: hijack, Reason: ReasonDNSHijack, IP: target.IP}
+	}
+	if target.Port != 443 && target.Port != 853 || !isKnownDoH(target) {
+		return nil
+	}
+	if rule := engine.MatchDNSAllow(key, target.IP); rule != nil {
+		return nil
+	}
+	logger.WarnAggregated(ctx, "dns_guard:"+key, map[string]interface{}{
+		"action": config.ActionSocketOperate,
+		"target": key,
+	}, "third-party DoH/DoT connection blocked")
+	return &Decision{Remote: &dohBlockedRemote{}, Reason: ReasonDoHBlocked, IP: target.IP}
+}
+
+// isKnownDoH 目标是否为已知的公共 DoH/DoT 服务器
+func isKnownDoH(target *common.TargetAddr) bool {
+	if target.IP != nil {
+		return knownDoHIPs[target.IP.String()]
+	}
+	return knownDoHHosts[strings.TrimSuffix(strings.ToLower(target.Name), ".")]
+}
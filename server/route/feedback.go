@@ -0,0 +1,50 @@
+package route
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"proxy/config"
+	"proxy/server/doh"
+	"proxy/server/metrics"
+	"proxy/server/proxy/client"
+	"proxy/utils/logger"
+)
+
+// feedbackTTL 服务端回送的解析结果在本地 DNS 缓存中的有效期
+const feedbackTTL = time.Minute
+
+func init() {
+	client.OnDNSFeedback = dnsFeedback
+}
+
+// dnsFeedback 处理服务端回送的域名实际连接地址（out.dns_feedback）：统计所属地区并预热本地 DNS 缓存；
+// 走代理的域名在服务端解析到中国 IP 时记录一条告警，多见于规则误判或 DNS 污染导致“直连可用、代理不可用”
+func dnsFeedback(ctx context.Context, name string, ip net.IP) {
+	if ip == nil {
+		metrics.DNSFeedback.With("unknown").Inc()
+		return
+	}
+	region := "foreign"
+	if ip.To4() != nil && IsCnIp(ctx, ip.String()) {
+		region = "cn"
+		logger.WarnAggregated(ctx, "dns_feedback_cn:"+name, map[string]interface{}{
+			"action": config.ActionSocketOperate,
+			"domain": name,
+			"ip":     ip.String(),
+		}, "proxied domain resolved to a CN address on the server")
+	}
+	metrics.DNSFeedback.With(region).Inc()
+	logger.Debug(ctx, map[string]interface{}{
+		"action": config.ActionSocketOperate,
+		"domain": name,
+		"ip":     ip.String(),
+		"region": region,
+	}, "dns feedback from server")
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() {
+		// 服务端所在网络的内网地址对本机没有意义
+		return
+	}
+	doh.Warm(doh.Domain(name), doh.ECS(ECSSubnet()), ip, feedbackTTL)
+}
@@ -0,0 +1,82 @@
+package route
+
+import (
+	"sync"
+	"time"
+
+	"proxy/config"
+	"proxy/server/hooks"
+)
+
+// 保留最近的路由决策条数
+const historySize = 256
+
+// DecisionRecord 一次路由决策的记录
+type DecisionRecord struct {
+	Time    string `json:"time"`
+	Target  string `json:"target"`
+	Remote  string `json:"remote"`
+	Reason  string `json:"reason"`
+	Rule    string `json:"rule,omitempty"`
+	IP      string `json:"ip,omitempty"`
+	Hosts   string `json:"hosts,omitempty"`
+	Rewrite string `json:"rewrite,omitempty"`
+}
+
+var history = struct {
+	mu      sync.Mutex
+	records []DecisionRecord
+	next    int
+}{records: make([]DecisionRecord, 0, historySize)}
+
+// recordDecision 将决策写入环形缓冲区
+func recordDecision(target string, d *Decision) {
+	record := DecisionRecord{
+		Time:    time.Now().In(config.CstZone).Format(config.TimeFormat),
+		Target:  target,
+		Remote:  d.Remote.Name(),
+		Reason:  d.Reason,
+		Rule:    d.Rule,
+		Hosts:   d.Hosts,
+		Rewrite: d.Rewrite,
+	}
+	if d.IP != nil {
+		record.IP = d.IP.String()
+	}
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	if len(history.records) < historySize {
+		history.records = append(history.records, record)
+	} else {
+		history.records[history.next] = record
+	}
+	history.next = (history.next + 1) % historySize
+}
+
+// notifyDecision 通知 hooks.OnRouteDecision 注册的回调
+func notifyDecision(target string, d *Decision) {
+	e := hooks.RouteEvent{
+		Target:  target,
+		Remote:  d.Remote.Name(),
+		Reason:  d.Reason,
+		Rule:    d.Rule,
+		Hosts:   d.Hosts,
+		Rewrite: d.Rewrite,
+	}
+	if d.IP != nil {
+		e.IP = d.IP.String()
+	}
+	hooks.RouteDecision(e)
+}
+
+// RecentDecisions 返回最近的路由决策，最新的在前
+func RecentDecisions() []DecisionRecord {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	n := len(history.records)
+	list := make([]DecisionRecord, 0, n)
+	for i := 1; i <= n; i++ {
+		list = append(list, history.records[(history.next-i+n)%n])
+	}
+	return list
+}
@@ -0,0 +1,59 @@
+package route
+
+import (
+	"net"
+	"strings"
+
+	"proxy/config"
+)
+
+// maxHostsAliasDepth 别名最多跟随的层数，防止配置成环
+const maxHostsAliasDepth = 8
+
+// LookupHosts 查询静态 hosts 映射（dns.hosts）
+// 映射值为 IP 时返回该 IP；为域名时视为别名继续查找，最终未映射到 IP 则返回别名域名
+// 支持精确匹配和 *.example.com 形式的通配
+func LookupHosts(name string) (ip net.IP, alias string, ok bool) {
+	hosts := config.Config.DNS.Hosts
+	if len(hosts) == 0 || name == "" {
+		return nil, "", false
+	}
+	current := name
+	for i := 0; i < maxHostsAliasDepth; i++ {
+		value, hit := lookupHostsEntry(hosts, current)
+		if !hit {
+			break
+		}
+		ok = true
+		if ip = net.ParseIP(value); ip != nil {
+			return ip, "", true
+		}
+		current = value
+	}
+	if !ok {
+		return nil, "", false
+	}
+	return nil, current, true
+}
+
+// lookupHostsEntry 查找单个 hosts 条目，精确匹配优先，其次是最长的通配后缀；hosts 的键在加载配置时已统一为小写
+func lookupHostsEntry(hosts map[string]string, name string) (string, bool) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if value, ok := hosts[name]; ok {
+		return strings.TrimSpace(value), true
+	}
+	var matched, value string
+	for pattern, v := range hosts {
+		if !strings.HasPrefix(pattern, "*.") {
+			continue
+		}
+		suffix := pattern[1:] // 保留前导点，如 .example.com
+		if strings.HasSuffix(name, suffix) && len(suffix) > len(matched) {
+			matched, value = suffix, v
+		}
+	}
+	if matched == "" {
+		return "", false
+	}
+	return strings.TrimSpace(value), true
+}
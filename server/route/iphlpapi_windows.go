@@ -0,0 +1,94 @@
+//go:build windows
+
+package route
+
+import (
+	"fmt"
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// IP Helper 路由接口，x/sys/windows 只导出了其中的查询部分
+var (
+	modiphlpapi                  = windows.NewLazySystemDLL("iphlpapi.dll")
+	procGetBestRoute2            = modiphlpapi.NewProc("GetBestRoute2")
+	procInitializeIpForwardEntry = modiphlpapi.NewProc("InitializeIpForwardEntry")
+	procCreateIpForwardEntry2    = modiphlpapi.NewProc("CreateIpForwardEntry2")
+	procDeleteIpForwardEntry2    = modiphlpapi.NewProc("DeleteIpForwardEntry2")
+)
+
+// netioStatus NETIO_STATUS 转为 error，0 表示成功
+func netioStatus(r uintptr) error {
+	if r != 0 {
+		return windows.Errno(r)
+	}
+	return nil
+}
+
+// getBestRoute2 查询到 dst 的最优路由及对应的本机源地址
+func getBestRoute2(dst net.IP) (*windows.MibIpForwardRow2, net.IP, error) {
+	dest := sockaddrInet(dst)
+	var row windows.MibIpForwardRow2
+	var src windows.RawSockaddrInet
+	r, _, _ := procGetBestRoute2.Call(0, 0, 0,
+		uintptr(unsafe.Pointer(&dest)), 0,
+		uintptr(unsafe.Pointer(&row)), uintptr(unsafe.Pointer(&src)))
+	if err := netioStatus(r); err != nil {
+		return nil, nil, fmt.Errorf("GetBestRoute2 %s: %w", dst, err)
+	}
+	return &row, sockaddrInetIP(&src), nil
+}
+
+// newForwardRow 构造经 ifIndex 接口、下一跳为 nextHop 的路由，地址族取自 dst；IPv6 下一跳为 :: 时为接口直连路由
+func newForwardRow(dst *net.IPNet, nextHop net.IP, ifIndex uint32, metric uint32) *windows.MibIpForwardRow2 {
+	var row windows.MibIpForwardRow2
+	procInitializeIpForwardEntry.Call(uintptr(unsafe.Pointer(&row)))
+	ones, _ := dst.Mask.Size()
+	row.InterfaceIndex = ifIndex
+	row.DestinationPrefix.Prefix = sockaddrInet(dst.IP)
+	row.DestinationPrefix.PrefixLength = uint8(ones)
+	row.NextHop = sockaddrInet(nextHop)
+	row.Metric = metric
+	row.Protocol = windows.MIB_IPPROTO_NETMGMT
+	return &row
+}
+
+func createIpForwardEntry2(row *windows.MibIpForwardRow2) error {
+	r, _, _ := procCreateIpForwardEntry2.Call(uintptr(unsafe.Pointer(row)))
+	return netioStatus(r)
+}
+
+func deleteIpForwardEntry2(row *windows.MibIpForwardRow2) error {
+	r, _, _ := procDeleteIpForwardEntry2.Call(uintptr(unsafe.Pointer(row)))
+	return netioStatus(r)
+}
+
+// sockaddrInet IPv4 地址编码为 SOCKADDR_IN，其余编码为 SOCKADDR_IN6
+func sockaddrInet(ip net.IP) windows.RawSockaddrInet {
+	var sa windows.RawSockaddrInet
+	if ip4 := ip.To4(); ip4 != nil {
+		sa4 := (*windows.RawSockaddrInet4)(unsafe.Pointer(&sa))
+		sa4.Family = windows.AF_INET
+		copy(sa4.Addr[:], ip4)
+		return sa
+	}
+	sa6 := (*windows.RawSockaddrInet6)(unsafe.Pointer(&sa))
+	sa6.Family = windows.AF_INET6
+	copy(sa6.Addr[:], ip.To16())
+	return sa
+}
+
+// sockaddrInetIP 地址族不是 IPv4/IPv6 时返回 nil
+func sockaddrInetIP(sa *windows.RawSockaddrInet) net.IP {
+	switch sa.Family {
+	case windows.AF_INET:
+		sa4 := (*windows.RawSockaddrInet4)(unsafe.Pointer(sa))
+		return net.IPv4(sa4.Addr[0], sa4.Addr[1], sa4.Addr[2], sa4.Addr[3]).To4()
+	case windows.AF_INET6:
+		sa6 := (*windows.RawSockaddrInet6)(unsafe.Pointer(sa))
+		return append(net.IP(nil), sa6.Addr[:]...)
+	}
+	return nil
+}
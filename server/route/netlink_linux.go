@@ -0,0 +1,277 @@
+//go:build linux
+
+package route
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// netlinkBatch 一次 sendto 提交的请求条数上限，避免超出套接字缓冲区
+const netlinkBatch = 128
+
+// rtnetlink NETLINK_ROUTE 套接字，请求按序号逐条确认，非并发安全
+type rtnetlink struct {
+	fd  int
+	seq uint32
+}
+
+// nlRequest 一条 rtnetlink 请求：消息类型、附加标志与请求体（固定头部加属性）
+type nlRequest struct {
+	typ   uint16
+	flags uint16
+	body  []byte
+}
+
+func openRtnetlink() (*rtnetlink, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("netlink socket: %w", err)
+	}
+	// 内核不应答时不至于永久阻塞
+	tv := unix.Timeval{Sec: 5}
+	if err = unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("netlink setsockopt: %w", err)
+	}
+	if err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("netlink bind: %w", err)
+	}
+	return &rtnetlink{fd: fd}, nil
+}
+
+func (nl *rtnetlink) Close() error {
+	return unix.Close(nl.fd)
+}
+
+// execute 按批提交请求并等待每条的确认，返回与 reqs 一一对应的错误（内核返回的 syscall.Errno）
+func (nl *rtnetlink) execute(reqs []nlRequest) []error {
+	errs := make([]error, len(reqs))
+	for start := 0; start < len(reqs); start += netlinkBatch {
+		end := min(start+netlinkBatch, len(reqs))
+		base := nl.seq + 1
+		var buf []byte
+		for _, req := range reqs[start:end] {
+			buf = nl.appendMessage(buf, req.typ, req.flags|unix.NLM_F_REQUEST|unix.NLM_F_ACK, req.body)
+		}
+		if err := unix.Sendto(nl.fd, buf, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+			for i := start; i < end; i++ {
+				errs[i] = fmt.Errorf("netlink send: %w", err)
+			}
+			continue
+		}
+		pending := end - start
+		acked := make([]bool, pending)
+		for pending > 0 {
+			msgs, err := nl.receive()
+			if err != nil {
+				for i, ok := range acked {
+					if !ok {
+						errs[start+i] = fmt.Errorf("netlink receive: %w", err)
+					}
+				}
+				break
+			}
+			for _, m := range msgs {
+				i := int(m.Header.Seq - base)
+				if m.Header.Type != unix.NLMSG_ERROR || m.Header.Seq < base || i >= len(acked) || acked[i] {
+					continue
+				}
+				acked[i] = true
+				pending--
+				errs[start+i] = parseAck(m)
+			}
+		}
+	}
+	return errs
+}
+
+// dump 发送 NLM_F_DUMP 请求并收集全部应答消息
+func (nl *rtnetlink) dump(typ uint16, body []byte) ([]syscall.NetlinkMessage, error) {
+	buf := nl.appendMessage(nil, typ, unix.NLM_F_REQUEST|unix.NLM_F_DUMP, body)
+	seq := nl.seq
+	if err := unix.Sendto(nl.fd, buf, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("netlink send: %w", err)
+	}
+	var result []syscall.NetlinkMessage
+	for {
+		msgs, err := nl.receive()
+		if err != nil {
+			return nil, fmt.Errorf("netlink receive: %w", err)
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != seq {
+				continue
+			}
+			switch m.Header.Type {
+			case unix.NLMSG_DONE:
+				return result, nil
+			case unix.NLMSG_ERROR:
+				if err := parseAck(m); err != nil {
+					return nil, err
+				}
+				return result, nil
+			default:
+				result = append(result, m)
+			}
+		}
+	}
+}
+
+func (nl *rtnetlink) appendMessage(buf []byte, typ, flags uint16, body []byte) []byte {
+	nl.seq++
+	buf = binary.NativeEndian.AppendUint32(buf, uint32(unix.NLMSG_HDRLEN+len(body)))
+	buf = binary.NativeEndian.AppendUint16(buf, typ)
+	buf = binary.NativeEndian.AppendUint16(buf, flags)
+	buf = binary.NativeEndian.AppendUint32(buf, nl.seq)
+	buf = binary.NativeEndian.AppendUint32(buf, 0)
+	return append(buf, body...)
+}
+
+func (nl *rtnetlink) receive() ([]syscall.NetlinkMessage, error) {
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := unix.Recvfrom(nl.fd, buf, 0)
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return syscall.ParseNetlinkMessage(buf[:n])
+	}
+}
+
+// parseAck 解析 NLMSG_ERROR 应答，错误码为 0 表示成功
+func parseAck(m syscall.NetlinkMessage) error {
+	if len(m.Data) < 4 {
+		return errors.New("netlink: truncated ack")
+	}
+	if code := int32(binary.NativeEndian.Uint32(m.Data[:4])); code != 0 {
+		return syscall.Errno(-code)
+	}
+	return nil
+}
+
+// appendAttr 追加一个 rtattr 并按 4 字节对齐
+func appendAttr(buf []byte, typ uint16, data []byte) []byte {
+	buf = binary.NativeEndian.AppendUint16(buf, uint16(unix.SizeofRtAttr+len(data)))
+	buf = binary.NativeEndian.AppendUint16(buf, typ)
+	buf = append(buf, data...)
+	for len(buf)%4 != 0 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+func appendUint32Attr(buf []byte, typ uint16, v uint32) []byte {
+	return appendAttr(buf, typ, binary.NativeEndian.AppendUint32(nil, v))
+}
+
+// routeBody 构造路由请求体，地址族取自 dst：gateway 非空时经网关（oif 非 0 时限定出接口，用于链路本地网关），
+// 否则为经 oif 接口的直连路由；priority 为 0 时不设置（IPv6 由内核取 1024）；表号超过 255 时通过 RTA_TABLE 传递
+func routeBody(dst *net.IPNet, gateway net.IP, oif int, table int, scope uint8, protocol uint8, priority uint32) []byte {
+	ones, _ := dst.Mask.Size()
+	family := familyOf(dst.IP)
+	rtm := []byte{family, byte(ones), 0, 0, unix.RT_TABLE_UNSPEC, protocol, scope, unix.RTN_UNICAST, 0, 0, 0, 0}
+	if table < 256 {
+		rtm[4] = byte(table)
+	}
+	body := appendAttr(rtm, unix.RTA_DST, ipBytes(dst.IP))
+	if gateway != nil {
+		body = appendAttr(body, unix.RTA_GATEWAY, ipBytes(gateway))
+	}
+	if oif != 0 {
+		body = appendUint32Attr(body, unix.RTA_OIF, uint32(oif))
+	}
+	if priority != 0 {
+		body = appendUint32Attr(body, unix.RTA_PRIORITY, priority)
+	}
+	return appendUint32Attr(body, unix.RTA_TABLE, uint32(table))
+}
+
+// ruleBody 构造 fwmark 策略路由请求体，suppressPrefixlen 小于 0 时不设置
+func ruleBody(family uint8, mark, table, priority, suppressPrefixlen int) []byte {
+	frh := []byte{family, 0, 0, 0, unix.RT_TABLE_UNSPEC, 0, 0, unix.FR_ACT_TO_TBL, 0, 0, 0, 0}
+	if table < 256 {
+		frh[4] = byte(table)
+	}
+	body := appendUint32Attr(frh, unix.FRA_FWMARK, uint32(mark))
+	body = appendUint32Attr(body, unix.FRA_PRIORITY, uint32(priority))
+	body = appendUint32Attr(body, unix.FRA_TABLE, uint32(table))
+	if suppressPrefixlen >= 0 {
+		body = appendUint32Attr(body, unix.FRA_SUPPRESS_PREFIXLEN, uint32(suppressPrefixlen))
+	}
+	return body
+}
+
+// familyOf IPv4 地址返回 AF_INET，否则 AF_INET6
+func familyOf(ip net.IP) uint8 {
+	if ip.To4() != nil {
+		return unix.AF_INET
+	}
+	return unix.AF_INET6
+}
+
+// ipBytes 按地址族编码的地址：IPv4 为 4 字节，IPv6 为 16 字节
+func ipBytes(ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
+
+// defaultRoute main 表中的一条默认路由
+type defaultRoute struct {
+	gateway  net.IP
+	oif      int
+	priority uint32
+}
+
+// defaultRoutes 读取 main 表中 family 地址族的全部默认路由
+func (nl *rtnetlink) defaultRoutes(family uint8) ([]*defaultRoute, error) {
+	req := make([]byte, unix.SizeofRtMsg)
+	req[0] = family
+	msgs, err := nl.dump(unix.RTM_GETROUTE, req)
+	if err != nil {
+		return nil, err
+	}
+	var routes []*defaultRoute
+	for _, m := range msgs {
+		if m.Header.Type != unix.RTM_NEWROUTE || len(m.Data) < unix.SizeofRtMsg {
+			continue
+		}
+		// rtmsg: family, dst_len, src_len, tos, table, protocol, scope, type
+		if m.Data[0] != family || m.Data[1] != 0 || m.Data[7] != unix.RTN_UNICAST {
+			continue
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			continue
+		}
+		table := uint32(m.Data[4])
+		r := &defaultRoute{}
+		for _, a := range attrs {
+			switch a.Attr.Type {
+			case unix.RTA_TABLE:
+				table = binary.NativeEndian.Uint32(a.Value)
+			case unix.RTA_GATEWAY:
+				r.gateway = net.IP(append([]byte(nil), a.Value...))
+			case unix.RTA_OIF:
+				r.oif = int(binary.NativeEndian.Uint32(a.Value))
+			case unix.RTA_PRIORITY:
+				r.priority = binary.NativeEndian.Uint32(a.Value)
+			}
+		}
+		if table == unix.RT_TABLE_MAIN {
+			routes = append(routes, r)
+		}
+	}
+	return routes, nil
+}
@@ -1,7 +1,7 @@
 package route
 
 import (
-	context2 "context"
+	"context"
 	"fmt"
 	"log"
 	"math"
@@ -13,13 +13,13 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"time"
 
 	"proxy/config"
 	"proxy/server/common"
 	"proxy/server/doh"
+	"proxy/server/metrics"
 	"proxy/server/proxy/client"
-	"proxy/utils/context"
+	"proxy/server/tracing"
 	"proxy/utils/gfwlist"
 	"proxy/utils/helper"
 	"proxy/utils/logger"
@@ -39,7 +39,16 @@ func init() {
 		// 重新加载规则引擎
 		GetRuleEngine().ReloadRules()
 	})
-	
+	config.RegisterLoadCallback(loadLists)
+}
+
+// loadLists 加载 GFW 列表与中国 IP 列表，配置加载后执行一次
+func loadLists() {
+	// check 子命令自行检查规则文件，不在此加载或下载
+	if config.CheckMode() {
+		return
+	}
+
 	var err error
 	if len(config.Config.GFWListFile) == 0 {
 		config.Config.GFWListFile = "gfwlist.txt"
@@ -117,7 +126,7 @@ func init() {
 
 // IsCnIp determine chinese ip
 // 使用二分查找优化性能（O(log n) 替代 O(n)）
-func IsCnIp(ctx *context.Context, ip string) bool {
+func IsCnIp(ctx context.Context, ip string) bool {
 	segs := strings.Split(ip, ".")
 	if len(segs) != 4 {
 		return false
@@ -147,120 +156,352 @@ func IsCnIp(ctx *context.Context, ip string) bool {
 	
 	return false
 }
-func GetRemote(ctx *context.Context, target *common.TargetAddr) common.Remote {
-	if config.Config.Out.Type == config.RemoteTypeDirect {
-		return &client.DirectRemote{}
+// 路由决策原因
+const (
+	ReasonDNSHijack  = "dns_hijack"     // dns.guard 劫持的 53 端口查询
+	ReasonDoHBlocked = "doh_blocked"    // dns.guard 阻断的第三方 DoH/DoT 服务器
+	ReasonAdBlock    = "adblock"        // 命中 adblock.lists，拒绝连接
+	ReasonScript     = "script"         // 路由脚本指定了出口
+	ReasonTor        = "tor"            // 命中 tor.rules 或 .onion 域名
+	ReasonForward    = "forward"        // 端口转发指定了出口
+	ReasonRelay      = "relay"          // 中继模式，全部交给上游
+	ReasonDirectMode = "direct_mode"    // 出口配置为直连或分流模式为 direct
+	ReasonGlobalMode = "global_mode"    // 分流模式为 global，全部走代理
+	ReasonWhiteList  = "white_list"     // 命中白名单
+	ReasonBlackList  = "black_list"     // 命中黑名单
+	ReasonGFWList    = "gfw_list"       // 命中 GFWList
+	ReasonCnDomain   = "cn_domain"      // .cn 域名
+	ReasonDohFailed  = "doh_failed"     // DoH 解析失败
+	ReasonRemoteDNS  = "remote_resolve" // 命中 dns.remote_resolve，不在本地解析
+	ReasonPrivateIP  = "private_ip"     // 本地/私有网络 IP
+	ReasonCnIP       = "cn_ip"          // 中国 IP
+	ReasonForeignIP  = "foreign_ip"     // 非中国 IP 或无法判断
+)
+
+// Decision 路由决策结果
+type Decision struct {
+	Remote  common.Remote
+	Reason  string // 决策原因，见 Reason* 常量
+	Rule    string // 命中的白名单/黑名单规则
+	IP      net.IP // 决策时使用的目标 IP（域名时为 DoH 解析结果）
+	Hosts   string // 命中的静态 hosts 映射
+	MITM    string // 命中的 mitm.rules 规则，本地入口据此解密检查 HTTPS
+	Rewrite string // 路由脚本对目标的改写（原地址 -> 新地址）
+	Resolve string // 命中 dns.local_resolve 时本地解析的结果（域名 -> IP）
+}
+
+// Direct 是否直连
+func (d *Decision) Direct() bool {
+	_, ok := d.Remote.(*client.DirectRemote)
+	return ok
+}
+
+// GetRemote 根据分流策略选择出口
+func GetRemote(ctx context.Context, target *common.TargetAddr) common.Remote {
+	return Decide(ctx, target).Remote
+}
+
+// Decide 根据分流策略选择出口，并返回决策依据
+// 域名命中静态 hosts 时会改写 target：映射到 IP 则设置 target.IP，映射到别名则替换 target.Name
+// 路由脚本返回了新目标时同样改写 target
+func Decide(ctx context.Context, target *common.TargetAddr) *Decision {
+	// 规则按原始目标匹配，hosts 改写之后再做后续判断
+	key := target.String()
+	ctx, span := tracing.Start(ctx, "route.decide")
+	span.SetAttr("target", key)
+	// 路由脚本最先执行，改写目标后 hosts 与其他规则按新目标判断；global / direct 模式下不按规则分流，也不调用脚本
+	var decision *Decision
+	var rewrite string
+	if config.RouteMode() == config.ModeRule {
+		decision, rewrite = runScript(ctx, target)
 	}
-	// check white and black list
-	if IsWhite(target.String()) {
-		return &client.DirectRemote{}
-	} else if IsBlack(target.String()) {
-		switch config.Config.Out.Type {
-		case config.RemoteTypeTLS:
-			return &client.TlsRemote{}
-		case config.RemoteTypeWSS:
-			return &client.WSSRemote{}
-		default:
-			return &client.DirectRemote{}
-		}
+	ruleKey := key
+	if rewrite != "" {
+		ruleKey = target.String()
 	}
-	// domain
-	if target.IP == nil {
-		var u = &url.URL{
-			Scheme: "http",
-			Host:   target.Host(),
-			Path:   "/",
+	hosts := applyHosts(target)
+	if decision == nil {
+		decision = decide(ctx, target, ruleKey)
+	}
+	decision.Hosts = hosts
+	decision.Rewrite = rewrite
+	decision.Resolve = resolveLocal(ctx, target, decision)
+	if len(config.Config.MITM.Rules) > 0 {
+		if rule := GetRuleEngine().MatchMITM(key, target.IP); rule != nil {
+			decision.MITM = rule.String()
 		}
-		if target.Port == 443 {
-			u.Scheme = "https"
+	}
+	span.SetAttr("reason", decision.Reason)
+	span.SetAttr("remote", decision.Remote.Name())
+	span.End(nil)
+	metrics.RouteDecisions.With(decision.Reason, decision.Remote.Name()).Inc()
+	recordDecision(key, decision)
+	notifyDecision(key, decision)
+	return decision
+}
+
+// resolveLocal 走代理的域名目标命中 dns.local_resolve 时在本地解析，把 target 改写为 IP 后发给服务端，返回解析结果描述；
+// Tor 出口与命中 dns.remote_resolve 的目标不在本地解析
+func resolveLocal(ctx context.Context, target *common.TargetAddr, decision *Decision) string {
+	if target.IP != nil || decision.Direct() || decision.Reason == ReasonTor || decision.Reason == ReasonAdBlock {
+		return ""
+	}
+	engine := GetRuleEngine()
+	key := target.String()
+	if engine.MatchLocalResolve(key, nil) == nil || engine.MatchRemoteResolve(key, nil) != nil {
+		return ""
+	}
+	ip := decision.IP
+	if ip == nil {
+		ctxCancel, cancel := context.WithTimeout(ctx, config.DialTimeout())
+		defer cancel()
+		ips, err := doh.New().Resolve(ctxCancel, doh.Domain(target.Name), doh.ECS(ECSSubnet()), QueryTypes()...)
+		if err != nil || len(ips) == 0 {
+			// 解析失败时仍发送域名，由服务端解析
+			logger.Warn(ctx, map[string]interface{}{
+				"action": config.ActionSocketOperate,
+				"target": target.Name,
+				"error":  err,
+			}, "local resolve failed, sending hostname to remote")
+			return ""
 		}
-		// gfw list check
-		if gfw.IsBlockedByGFW(&http.Request{
-			Method: "GET",
-			URL:    u,
-			Host:   target.String(),
-		}) {
-			switch config.Config.Out.Type {
-			case config.RemoteTypeTLS:
-				return &client.TlsRemote{}
-			case config.RemoteTypeWSS:
-				return &client.WSSRemote{}
-			default:
-				return &client.DirectRemote{}
-			}
-		} else if strings.HasSuffix(target.Name, ".cn") {
-			return &client.DirectRemote{}
-		} else {
-			// doh 获取域名解析
-			ctxCancel, cancel := context2.WithTimeout(context2.Background(), 10*time.Second)
-			defer cancel()
+		ip = ips[0]
+	}
+	target.IP = ip
+	decision.IP = ip
+	return target.Name + " -> " + ip.String()
+}
 
-			c := doh.New()
-			// ECS subnet
-			var subnet = config.Config.ECSSubnet
-			if subnet == "" {
-				subnet = "110.242.68.0/24"
-			}
-			rsp, err := c.ECSQuery(ctxCancel, doh.Domain(target.Name), doh.TypeA, doh.ECS(subnet))
-			if nil != err {
-				// DoH 查询失败时，走代理（保守策略，避免直连被阻断）
-				logger.Error(ctx, map[string]interface{}{
-					"action":    config.ActionSocketOperate,
-					"errorCode": logger.ErrCodeHandshake,
-					"error":     err,
-				}, "ECSQuery failed, using proxy")
-				switch config.Config.Out.Type {
-				case config.RemoteTypeTLS:
-					return &client.TlsRemote{}
-				case config.RemoteTypeWSS:
-					return &client.WSSRemote{}
-				default:
-					return &client.DirectRemote{}
-				}
-			}
-			var ip string
-			for _, v := range rsp.Answer {
-				// only use ipv4 type A record
-				// @link https://www.alidns.com/articles/6018321800a44d0e45e90d71
-				if v.Type == 1 {
-					ip = v.Data
-				}
-			}
-			if ip != "" && len(ip) > 0 {
-				var ipObj = net.ParseIP(ip)
-				// local network ip
-				if nil == ipObj || ipObj.IsLoopback() || ipObj.IsPrivate() {
-					return &client.DirectRemote{}
-				}
-				// chinese ip
-				if IsCnIp(ctx, ip) {
-					return &client.DirectRemote{}
-				}
-			}
-			// 非中国 IP 或无法判断时，走代理
-			switch config.Config.Out.Type {
-			case config.RemoteTypeTLS:
-				return &client.TlsRemote{}
-			case config.RemoteTypeWSS:
-				return &client.WSSRemote{}
-			default:
-				return &client.DirectRemote{}
-			}
+// applyHosts 使用静态 hosts 改写目标地址，返回命中的映射描述
+func applyHosts(target *common.TargetAddr) string {
+	if target.IP != nil {
+		return ""
+	}
+	ip, alias, ok := LookupHosts(target.Name)
+	if !ok {
+		return ""
+	}
+	if ip != nil {
+		target.IP = ip
+		return target.Name + " -> " + ip.String()
+	}
+	from := target.Name
+	target.Name = alias
+	return from + " -> " + alias
+}
+
+func decide(ctx context.Context, target *common.TargetAddr, key string) *Decision {
+	engine := GetRuleEngine()
+	if d := guardDNS(ctx, engine, target, key); d != nil {
+		return d
+	}
+	// 拦截列表优先于 Tor 与其他分流规则
+	if rule := engine.MatchBlock(key, target.IP); rule != "" {
+		logger.Debug(ctx, map[string]interface{}{
+			"action": config.ActionSocketOperate,
+			"target": key,
+			"rule":   rule,
+		}, "connection blocked by adblock list")
+		return &Decision{Remote: &adBlockedRemote{}, Reason: ReasonAdBlock, Rule: rule, IP: target.IP}
+	}
+	// Tor 规则优先于直连模式与其他规则，且在 DoH 查询之前判断，避免域名泄露到 DNS
+	if rule := engine.MatchTor(key, target.IP); rule != nil {
+		return &Decision{Remote: &client.TorRemote{}, Reason: ReasonTor, Rule: rule.String(), IP: target.IP}
+	}
+	if strings.HasSuffix(target.Name, ".onion") && config.TorEnabled() {
+		return &Decision{Remote: &client.TorRemote{}, Reason: ReasonTor}
+	}
+	// 中继模式下连接已由上一跳决定走代理，不再查询 DoH 与规则
+	if config.Config.Out.Relay && config.OutType() != config.RemoteTypeDirect {
+		return &Decision{Remote: ProxyRemote(), Reason: ReasonRelay, IP: target.IP}
+	}
+	if config.OutType() == config.RemoteTypeDirect {
+		return &Decision{Remote: &client.DirectRemote{}, Reason: ReasonDirectMode, IP: target.IP}
+	}
+	switch config.RouteMode() {
+	case config.ModeDirect:
+		return &Decision{Remote: &client.DirectRemote{}, Reason: ReasonDirectMode, IP: target.IP}
+	case config.ModeGlobal:
+		// 本机与局域网地址仍然直连
+		if target.IP != nil && (target.IP.IsLoopback() || target.IP.IsPrivate()) {
+			return &Decision{Remote: &client.DirectRemote{}, Reason: ReasonPrivateIP, IP: target.IP}
 		}
-	} else {
+		return &Decision{Remote: ProxyRemote(), Reason: ReasonGlobalMode, IP: target.IP}
+	}
+	// check white and black list
+	if rule := engine.MatchWhite(key, target.IP); rule != nil {
+		return &Decision{Remote: &client.DirectRemote{}, Reason: ReasonWhiteList, Rule: rule.String(), IP: target.IP}
+	} else if rule := engine.MatchBlack(key, target.IP); rule != nil {
+		return &Decision{Remote: ProxyRemote(), Reason: ReasonBlackList, Rule: rule.String(), IP: target.IP}
+	}
+	// ip
+	if target.IP != nil {
 		// local network or chinese ip
-		if IsCnIp(ctx, target.IP.String()) || target.IP.IsLoopback() || target.IP.IsPrivate() {
-			return &client.DirectRemote{}
+		if target.IP.IsLoopback() || target.IP.IsPrivate() {
+			return &Decision{Remote: &client.DirectRemote{}, Reason: ReasonPrivateIP, IP: target.IP}
+		}
+		if IsCnIp(ctx, target.IP.String()) {
+			return &Decision{Remote: &client.DirectRemote{}, Reason: ReasonCnIP, IP: target.IP}
+		}
+		return &Decision{Remote: ProxyRemote(), Reason: ReasonForeignIP, IP: target.IP}
+	}
+	// gfw list check
+	if IsBlockedByGFW(target) {
+		return &Decision{Remote: ProxyRemote(), Reason: ReasonGFWList}
+	}
+	if strings.HasSuffix(target.Name, ".cn") {
+		return &Decision{Remote: &client.DirectRemote{}, Reason: ReasonCnDomain}
+	}
+	// 不在本地解析的域名直接交给服务端，不查询 DoH
+	if rule := engine.MatchRemoteResolve(key, nil); rule != nil {
+		return &Decision{Remote: ProxyRemote(), Reason: ReasonRemoteDNS, Rule: rule.String()}
+	}
+	// doh 获取域名解析
+	ctxCancel, cancel := context.WithTimeout(ctx, config.DialTimeout())
+	defer cancel()
+
+	_, span := tracing.Start(ctx, "doh.resolve")
+	span.SetAttr("domain", target.Name)
+	ips, err := doh.New().Resolve(ctxCancel, doh.Domain(target.Name), doh.ECS(ECSSubnet()), QueryTypes()...)
+	span.SetAttr("answers", len(ips))
+	span.End(err)
+	if nil != err {
+		// DoH 查询失败时，走代理（保守策略，避免直连被阻断）
+		logger.Error(ctx, map[string]interface{}{
+			"action":    config.ActionSocketOperate,
+			"errorCode": logger.ErrCodeHandshake,
+			"error":     err,
+		}, "ECSQuery failed, using proxy")
+		return &Decision{Remote: ProxyRemote(), Reason: ReasonDohFailed}
+	}
+	var preferred net.IP
+	if len(ips) > 0 {
+		preferred = ips[0]
+	}
+	for _, ip := range ips {
+		// local network ip
+		if ip.IsLoopback() || ip.IsPrivate() {
+			return &Decision{Remote: &client.DirectRemote{}, Reason: ReasonPrivateIP, IP: ip}
+		}
+		// chinese ip（中国 IP 库仅包含 IPv4，任一 A 记录为中国 IP 即直连）
+		// @link https://www.alidns.com/articles/6018321800a44d0e45e90d71
+		if ip.To4() != nil && IsCnIp(ctx, ip.String()) {
+			return &Decision{Remote: &client.DirectRemote{}, Reason: ReasonCnIP, IP: ip}
 		}
-		switch config.Config.Out.Type {
-		case config.RemoteTypeTLS:
-			return &client.TlsRemote{}
-		case config.RemoteTypeWSS:
-			return &client.WSSRemote{}
-		default:
+	}
+	// 非中国 IP 或无法判断时，走代理
+	return &Decision{Remote: ProxyRemote(), Reason: ReasonForeignIP, IP: preferred}
+}
+
+// QueryTypes 根据地址族偏好返回需要查询的记录类型，按优先级排序
+func QueryTypes() []doh.Type {
+	switch config.Config.DNS.IPStrategy {
+	case config.IPStrategyIPv6First:
+		return []doh.Type{doh.TypeAAAA, doh.TypeA}
+	case config.IPStrategyDual:
+		return []doh.Type{doh.TypeA, doh.TypeAAAA}
+	default:
+		return []doh.Type{doh.TypeA}
+	}
+}
+
+// ProxyRemote 根据出口配置返回代理出口，配置了 retry.fallback 时主出口失败后改用该出口；
+// 远端不可达期间，开启 out.kill_switch 时直接拒绝，也不会回退到直连，开启 out.fail_open 时改走直连
+func ProxyRemote() common.Remote {
+	out := config.Config.Out
+	if client.RemoteDown() {
+		switch {
+		case out.KillSwitch:
+			return &client.RejectRemote{}
+		case out.FailOpen:
 			return &client.DirectRemote{}
 		}
 	}
+	outType := config.OutType()
+	remote := remoteOfType(outType)
+	fallback := config.Config.Retry.Fallback
+	if out.FailOpen && fallback == 0 {
+		// 发现远端不可达的连接本身也改走直连
+		fallback = config.RemoteTypeDirect
+	}
+	if fallback == 0 || fallback == outType || (out.KillSwitch && fallback == config.RemoteTypeDirect) {
+		return remote
+	}
+	return &client.FallbackRemote{Primary: remote, Fallback: remoteOfType(fallback)}
+}
+
+// ViaRemote 出口类型（取值同 out.type）对应的出口，与 out.type 相同时等同 ProxyRemote
+func ViaRemote(t int8) common.Remote {
+	if t == config.OutType() {
+		return ProxyRemote()
+	}
+	return remoteOfType(t)
+}
+
+// TunnelRemote out.type 对应的加密隧道出口（TLS / WSS / QUIC / gRPC / KCP），不经 retry.fallback 与 kill_switch 处理；
+// out.type 不是加密隧道时返回 nil
+func TunnelRemote() common.Remote {
+	switch t := config.OutType(); t {
+	case config.RemoteTypeTLS, config.RemoteTypeWSS, config.RemoteTypeQUIC, config.RemoteTypeGRPC, config.RemoteTypeKCP:
+		return remoteOfType(t)
+	default:
+		return nil
+	}
+}
+
+// RemoteOf 出口类型（取值同 out.type）对应的出口，不经 retry.fallback 与 kill_switch 处理，供测速等诊断使用
+func RemoteOf(t int8) common.Remote {
+	return remoteOfType(t)
+}
+
+// remoteOfType 出口类型（取值同 out.type）对应的出口
+func remoteOfType(t int8) common.Remote {
+	switch t {
+	case config.RemoteTypeTLS:
+		return &client.TlsRemote{}
+	case config.RemoteTypeWSS:
+		return &client.WSSRemote{}
+	case config.RemoteTypeSubscription:
+		return &client.NodeRemote{}
+	case config.RemoteTypeQUIC:
+		return &client.QuicRemote{}
+	case config.RemoteTypeGRPC:
+		return &client.GRPCRemote{}
+	case config.RemoteTypeHTTPConnect:
+		return &client.HTTPConnectRemote{}
+	case config.RemoteTypeKCP:
+		return &client.KcpRemote{}
+	default:
+		return &client.DirectRemote{}
+	}
+}
+
+// IsBlockedByGFW 检查域名是否命中 GFWList
+func IsBlockedByGFW(target *common.TargetAddr) bool {
+	if gfw == nil || target.IP != nil {
+		return false
+	}
+	var u = &url.URL{
+		Scheme: "http",
+		Host:   target.Host(),
+		Path:   "/",
+	}
+	if target.Port == 443 {
+		u.Scheme = "https"
+	}
+	return gfw.IsBlockedByGFW(&http.Request{
+		Method: "GET",
+		URL:    u,
+		Host:   target.String(),
+	})
+}
+
+// ECSSubnet 返回 DoH 查询使用的 ECS 子网
+func ECSSubnet() string {
+	if config.Config.ECSSubnet == "" {
+		return "110.242.68.0/24"
+	}
+	return config.Config.ECSSubnet
 }
 
 // IsWhite check white list
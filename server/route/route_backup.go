@@ -0,0 +1,107 @@
+package route
+
+import (
+	context2 "context"
+	"encoding/json"
+	"os"
+
+	"proxy/config"
+	"proxy/utils/helper"
+	"proxy/utils/logger"
+)
+
+// routeBackupFile 已安装路由的记录（可执行文件所在目录），恢复路由后删除；
+// 进程崩溃或被强制结束时文件仍在，下次启动据此清理
+const routeBackupFile = "tun_route_backup.json"
+
+// routeBackup 路由管理器改动过的路由
+type routeBackup struct {
+	TunInterface    string `json:"tun_interface"`
+	TunGateway      string `json:"tun_gateway"`
+	OriginalGateway string `json:"original_gateway"`
+	// 原 IPv6 默认网关，为空时没有接管 IPv6
+	OriginalGateway6 string   `json:"original_gateway6,omitempty"`
+	Routes           []string `json:"routes"`                  // 经原网关添加的路由
+	DefaultRoute     bool     `json:"default_route,omitempty"` // 经 TUN 的默认路由
+	Mark             int      `json:"mark,omitempty"`          // fwmark 策略路由与路由表号（Linux）
+}
+
+// saveBackup 记录当前已安装的路由，写入失败只记录日志
+func (rm *RouteManager) saveBackup(ctx context2.Context) {
+	path, err := helper.ExeFilePath(routeBackupFile)
+	if err == nil {
+		var data []byte
+		data, err = json.MarshalIndent(&routeBackup{
+			TunInterface:     rm.tunInterface,
+			TunGateway:       rm.tunGateway,
+			OriginalGateway:  rm.originalGateway,
+			OriginalGateway6: rm.originalGateway6,
+			Routes:           rm.added,
+			DefaultRoute:     rm.defaultRoute,
+			Mark:             config.TunMark(),
+		}, "", "  ")
+		if err == nil {
+			err = os.WriteFile(path, data, 0644)
+		}
+	}
+	if err != nil {
+		logger.Warn(ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"error":  err,
+		}, "failed to save route backup")
+	}
+}
+
+// auditRoute 把经 via（网关或接口名）的路由的添加、删除写入审计日志
+func auditRoute(op, network, via string, err error) {
+	if op == "add" {
+		logger.Audit("route", op, network, "", "via "+via, err)
+		return
+	}
+	logger.Audit("route", op, network, "via "+via, "", err)
+}
+
+func removeRouteBackup() {
+	if path, err := helper.ExeFilePath(routeBackupFile); err == nil {
+		_ = os.Remove(path)
+	}
+}
+
+// CleanupStaleRoutes 上次运行异常退出时按路由备份删除遗留的经 TUN 默认路由、策略路由与直连路由；没有遗留时返回 false
+func CleanupStaleRoutes(ctx context2.Context) bool {
+	path, err := helper.ExeFilePath(routeBackupFile)
+	if err != nil {
+		return false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	var b routeBackup
+	if err = json.Unmarshal(data, &b); err != nil {
+		logger.Warn(ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"file":   path,
+			"error":  err,
+		}, "invalid route backup, removed")
+		_ = os.Remove(path)
+		return false
+	}
+	rm := &RouteManager{
+		tunInterface:     b.TunInterface,
+		tunGateway:       b.TunGateway,
+		originalGateway:  b.OriginalGateway,
+		originalGateway6: b.OriginalGateway6,
+		backedUp:         true,
+		mark:             b.Mark,
+		added:            b.Routes,
+		defaultRoute:     b.DefaultRoute,
+	}
+	_ = rm.RestoreRoutes(ctx)
+	logger.Warn(ctx, map[string]interface{}{
+		"action":       config.ActionRuntime,
+		"routes":       len(b.Routes),
+		"defaultRoute": b.DefaultRoute,
+	}, "cleaned up routes left by a previous run")
+	return true
+}
@@ -1,28 +1,44 @@
 package route
 
 import (
+	context2 "context"
 	"fmt"
 	"net"
 	"os"
-	"os/exec"
-	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	"proxy/config"
 	"proxy/server/common"
-	"proxy/utils/context"
+	"proxy/server/subscription"
 	"proxy/utils/logger"
 )
 
+const (
+	maxWhiteListRoutes  = 1000 // 白名单路由数量上限，避免路由表过大
+	whiteListRouteBatch = 200  // 每批添加的白名单路由数，批间输出进度
+)
+
 // RouteManager 路由管理器
 type RouteManager struct {
 	originalGateway string // 原默认网关 IP
-	tunInterface    string // TUN 接口名称
-	tunGateway      string // TUN 接口的网关/本地 IP（如 10.0.0.1）
-	backedUp        bool
-	remoteServerIPs []net.IP // 远程服务器 IP 列表（用于快速检查）
-	remoteIPsMu     sync.RWMutex
+	// 原 IPv6 默认网关，带出接口名（如 fe80::1%eth0）；没有 IPv6 默认路由时为空，不接管 IPv6
+	originalGateway6 string
+	uplink           string // 原默认路由的出口网卡
+	tunInterface     string // TUN 接口名称
+	tunGateway       string // TUN 接口的网关/本地 IP（如 10.0.0.1）
+	backedUp         bool
+	remoteServers    map[string][]net.IP // 远端服务器地址 -> 解析出的 IPv4，定期重新解析
+	remoteServerIPs  []net.IP            // 远程服务器 IP 列表（用于快速检查）
+	remoteIPsMu      sync.RWMutex
+	lastResolve      time.Time // 上次解析远端服务器地址的时间
+	nodesVersion     uint64    // 上次解析时订阅节点列表的版本号
+	mark             int       // 已安装的 fwmark 规则（Linux），0 表示未安装
+	added            []string  // 经原网关添加的路由，恢复时删除
+	defaultRoute     bool      // 已设置（或正在设置）经 TUN 的默认路由
+	watchStop        chan struct{}
+	watchDone        chan struct{}
 }
 
 // NewRouteManager 创建路由管理器
@@ -34,13 +50,13 @@ func NewRouteManager(tunInterface, tunGateway string) *RouteManager {
 }
 
 // BackupRoutes 备份原始路由表
-func (rm *RouteManager) BackupRoutes(ctx *context.Context) error {
+func (rm *RouteManager) BackupRoutes(ctx context2.Context) error {
 	if rm.backedUp {
 		return nil
 	}
 
-	// 检测当前默认网关
-	gateway, err := rm.getDefaultGateway(ctx)
+	// 检测当前默认网关：多条默认路由时取 tun.uplink 网卡上的或 metric 最小的
+	gateway, uplink, err := rm.getDefaultGateway(ctx)
 	if err != nil {
 		logger.Error(ctx, map[string]interface{}{
 			"action":    config.ActionRuntime,
@@ -51,31 +67,42 @@ func (rm *RouteManager) BackupRoutes(ctx *context.Context) error {
 	}
 
 	rm.originalGateway = gateway
+	rm.uplink = uplink.Name
 
-	// 获取原默认接口的 IP 地址，用于绑定远程连接
-	interfaceIP, err := rm.getDefaultInterfaceIP(ctx)
+	// 记录原出口网卡及其 IP 地址，用于绑定远程连接
+	interfaceIP, err := interfaceIPv4(uplink)
 	if err != nil {
 		logger.Warn(ctx, map[string]interface{}{
 			"action": config.ActionRuntime,
 			"error":  err,
 		}, "failed to get default interface IP, remote connections may not bind to original interface")
-	} else if interfaceIP != nil {
-		// 设置全局 Dialer 绑定到原接口
-		common.SetOriginalInterfaceIP(ctx, interfaceIP)
+	}
+	common.SetOriginalInterface(ctx, uplink.Name, interfaceIP)
+
+	// 双栈网络：IPv6 也经 TUN，否则 IPv6 流量绕过代理
+	if gateway6, err := rm.getDefaultGateway6(ctx); err == nil {
+		rm.originalGateway6 = gateway6
+	} else {
+		logger.Info(ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"error":  err,
+		}, "no IPv6 default gateway, IPv6 routes not managed")
 	}
 
 	rm.backedUp = true
 
 	logger.Info(ctx, map[string]interface{}{
-		"action":  config.ActionRuntime,
-		"gateway": gateway,
+		"action":   config.ActionRuntime,
+		"gateway":  gateway,
+		"gateway6": rm.originalGateway6,
+		"uplink":   rm.uplink,
 	}, "backed up original gateway")
 
 	return nil
 }
 
 // SetupRoutes 配置路由表
-func (rm *RouteManager) SetupRoutes(ctx *context.Context) error {
+func (rm *RouteManager) SetupRoutes(ctx context2.Context) error {
 	if !rm.backedUp {
 		if err := rm.BackupRoutes(ctx); err != nil {
 			return err
@@ -105,10 +132,34 @@ func (rm *RouteManager) SetupRoutes(ctx *context.Context) error {
 		return fmt.Errorf("failed to add whitelist routes: %w", err)
 	}
 
+	// 先记录再安装，安装中途异常退出时下次启动也能清理
+	rm.defaultRoute = true
+	rm.saveBackup(ctx)
+
+	// Linux：带 SO_MARK 的出站连接按策略路由走原默认网关，不依赖源地址绑定；其他平台为空操作
+	if err := rm.addMarkRules(ctx); err != nil {
+		return fmt.Errorf("failed to add fwmark rules: %w", err)
+	}
+
 	// 5. 设置默认路由到 TUN 接口（最后设置，让 TUN 接管所有其他流量）
-	if err := rm.setDefaultRoute(ctx); err != nil {
+	err := rm.setDefaultRoute(ctx)
+	auditRoute("add", "0.0.0.0/0", rm.tunInterface, err)
+	if err != nil {
 		return fmt.Errorf("failed to set default route: %w", err)
 	}
+	if rm.originalGateway6 != "" {
+		err := rm.setDefaultRoute6(ctx)
+		auditRoute("add", "::/0", rm.tunInterface, err)
+		if err != nil {
+			logger.Warn(ctx, map[string]interface{}{
+				"action": config.ActionRuntime,
+				"error":  err,
+			}, "failed to set IPv6 default route, IPv6 traffic bypasses TUN")
+		}
+	}
+
+	// 默认路由被其他程序删除时重新设置
+	rm.startWatch(ctx)
 
 	logger.Info(ctx, map[string]interface{}{
 		"action": config.ActionRuntime,
@@ -117,66 +168,132 @@ func (rm *RouteManager) SetupRoutes(ctx *context.Context) error {
 	return nil
 }
 
-// addRemoteServerRoute 为远端代理服务器添加直连路由，避免走 TUN 形成死循环
+// addRemoteServerRoute 为远端代理服务器与订阅节点添加直连路由，避免走 TUN 形成死循环
 // 注意：此函数在 TUN 启动前调用，此时 DNS 查询不会走 TUN
-func (rm *RouteManager) addRemoteServerRoute(ctx *context.Context) error {
-	host := strings.TrimSpace(config.Config.Out.RemoteAddr)
-	if host == "" {
-		return nil
-	}
-
-	// 在 TUN 启动前解析，避免 DNS 查询走 TUN
-	ips, err := net.LookupIP(host)
-	if err != nil {
-		logger.Warn(ctx, map[string]interface{}{
-			"action": config.ActionRuntime,
-			"host":   host,
-			"error":  err,
-		}, "failed to lookup remote server IP, skip remote route")
-		return nil // 不阻塞启动
-	}
-
-	// 保存远程服务器 IP 列表，用于快速检查
-	rm.remoteIPsMu.Lock()
-	rm.remoteServerIPs = make([]net.IP, 0)
-	for _, ip := range ips {
-		ip4 := ip.To4()
-		if ip4 == nil {
-			continue
-		}
-		rm.remoteServerIPs = append(rm.remoteServerIPs, ip4)
-		cidr := ip4.String() + "/32"
-		if err := rm.addRoute(ctx, cidr, rm.originalGateway); err != nil {
+func (rm *RouteManager) addRemoteServerRoute(ctx context2.Context) error {
+	rm.nodesVersion = subscription.Version()
+	servers := resolveRemoteServers(ctx, net.DefaultResolver, rm.lookupNetwork(), nil)
+	cidrs := hostRoutes(remoteIPs(servers))
+	for i, err := range rm.addBypassRoutes(ctx, cidrs) {
+		if err != nil {
 			logger.Warn(ctx, map[string]interface{}{
 				"action": config.ActionRuntime,
-				"cidr":   cidr,
+				"cidr":   cidrs[i],
 				"error":  err,
 			}, "failed to add remote server route")
 		} else {
 			logger.Info(ctx, map[string]interface{}{
 				"action":  config.ActionRuntime,
-				"cidr":    cidr,
+				"cidr":    cidrs[i],
 				"gateway": rm.originalGateway,
 			}, "added remote server route")
 		}
 	}
-	rm.remoteIPsMu.Unlock()
+	rm.setRemoteServers(servers)
 	return nil
 }
 
+// resolveRemoteServers 解析全部远端服务器地址（network 为 ip4 或 ip）；解析失败的地址沿用 previous 中的结果（首次解析时跳过，不阻塞启动）
+func resolveRemoteServers(ctx context2.Context, resolver *net.Resolver, network string, previous map[string][]net.IP) map[string][]net.IP {
+	servers := make(map[string][]net.IP)
+	for _, host := range remoteServerHosts() {
+		if _, ok := servers[host]; ok {
+			continue
+		}
+		c, cancel := context2.WithTimeout(context2.Background(), remoteResolveTimeout)
+		ips, err := resolver.LookupIP(c, network, host)
+		cancel()
+		if err != nil {
+			if old, ok := previous[host]; ok {
+				servers[host] = old
+			}
+			logger.WarnAggregated(ctx, "remote_resolve", map[string]interface{}{
+				"action": config.ActionRuntime,
+				"host":   host,
+				"error":  err,
+			}, "failed to lookup remote server IP, skip remote route")
+			continue
+		}
+		for _, ip := range ips {
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			servers[host] = append(servers[host], ip)
+		}
+	}
+	return servers
+}
+
+// lookupNetwork 有 IPv6 默认网关时同时解析 AAAA，为远端的 IPv6 地址添加直连路由
+func (rm *RouteManager) lookupNetwork() string {
+	if rm.originalGateway6 != "" {
+		return "ip"
+	}
+	return "ip4"
+}
+
+// remoteIPs 去重后的全部远端服务器 IP
+func remoteIPs(servers map[string][]net.IP) []net.IP {
+	seen := make(map[string]bool)
+	ips := make([]net.IP, 0)
+	for _, list := range servers {
+		for _, ip := range list {
+			if !seen[ip.String()] {
+				seen[ip.String()] = true
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
+
+// hostRoutes 每个 IP 对应的主机路由（IPv4 为 /32，IPv6 为 /128）
+func hostRoutes(ips []net.IP) []string {
+	cidrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			cidrs = append(cidrs, ip.String()+"/32")
+		} else {
+			cidrs = append(cidrs, ip.String()+"/128")
+		}
+	}
+	return cidrs
+}
+
+// setRemoteServers 一次性替换远端服务器 IP 列表
+func (rm *RouteManager) setRemoteServers(servers map[string][]net.IP) {
+	ips := remoteIPs(servers)
+	rm.remoteIPsMu.Lock()
+	rm.remoteServers, rm.remoteServerIPs = servers, ips
+	rm.lastResolve = time.Now()
+	rm.remoteIPsMu.Unlock()
+}
+
+// remoteServerHosts 需要直连的远端服务器：out.remote_addr 中的全部地址、out.http_proxy 以及使用订阅节点时的全部节点地址
+func remoteServerHosts() []string {
+	hosts := make([]string, 0)
+	for _, addr := range append(config.RemoteAddrs(), config.Config.Out.HTTPProxy.Addr) {
+		if host, _, err := net.SplitHostPort(addr); err == nil && host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	if config.OutType() == config.RemoteTypeSubscription {
+		for _, n := range subscription.Nodes() {
+			hosts = append(hosts, n.Server)
+		}
+	}
+	return hosts
+}
+
 // IsRemoteServerIP 检查 IP 是否是远程服务器 IP
 func (rm *RouteManager) IsRemoteServerIP(ip net.IP) bool {
 	if ip == nil {
 		return false
 	}
-	ip4 := ip.To4()
-	if ip4 == nil {
-		return false
-	}
 	rm.remoteIPsMu.RLock()
 	defer rm.remoteIPsMu.RUnlock()
 	for _, remoteIP := range rm.remoteServerIPs {
-		if remoteIP.Equal(ip4) {
+		if remoteIP.Equal(ip) {
 			return true
 		}
 	}
@@ -202,19 +319,53 @@ func GetGlobalRouteManager() *RouteManager {
 }
 
 // RestoreRoutes 恢复原始路由表
-func (rm *RouteManager) RestoreRoutes(ctx *context.Context) error {
+func (rm *RouteManager) RestoreRoutes(ctx context2.Context) error {
 	if !rm.backedUp {
 		return nil
 	}
+	rm.stopWatch()
 
 	// 删除默认路由
-	if err := rm.deleteDefaultRoute(ctx); err != nil {
-		logger.Error(ctx, map[string]interface{}{
-			"action":    config.ActionRuntime,
-			"errorCode": logger.ErrCodeHandshake,
-			"error":     err,
-		}, "failed to delete default route")
+	if rm.defaultRoute {
+		err := rm.deleteDefaultRoute(ctx)
+		auditRoute("delete", "0.0.0.0/0", rm.tunInterface, err)
+		if err != nil {
+			logger.Error(ctx, map[string]interface{}{
+				"action":    config.ActionRuntime,
+				"errorCode": logger.ErrCodeHandshake,
+				"error":     err,
+			}, "failed to delete default route")
+		}
+		if rm.originalGateway6 != "" {
+			err := rm.deleteDefaultRoute6(ctx)
+			auditRoute("delete", "::/0", rm.tunInterface, err)
+			if err != nil {
+				logger.Error(ctx, map[string]interface{}{
+					"action":    config.ActionRuntime,
+					"errorCode": logger.ErrCodeHandshake,
+					"error":     err,
+				}, "failed to delete IPv6 default route")
+			}
+		}
+		rm.defaultRoute = false
 	}
+	rm.deleteMarkRules(ctx)
+
+	// 删除经原网关添加的直连路由
+	for _, network := range rm.added {
+		gateway := rm.bypassGateway(network)
+		err := rm.deleteRoute(ctx, network, gateway)
+		auditRoute("delete", network, gateway, err)
+		if err != nil {
+			logger.Warn(ctx, map[string]interface{}{
+				"action":  config.ActionRuntime,
+				"network": network,
+				"error":   err,
+			}, "failed to delete route")
+		}
+	}
+	rm.added = nil
+	removeRouteBackup()
 
 	logger.Info(ctx, map[string]interface{}{
 		"action": config.ActionRuntime,
@@ -225,7 +376,7 @@ func (rm *RouteManager) RestoreRoutes(ctx *context.Context) error {
 }
 
 // addLocalNetworkRoutes 添加本地网络路由
-func (rm *RouteManager) addLocalNetworkRoutes(ctx *context.Context) error {
+func (rm *RouteManager) addLocalNetworkRoutes(ctx context2.Context) error {
 	localNetworks := []string{
 		"127.0.0.0/8",    // 本地回环
 		"10.0.0.0/8",     // 私有网络
@@ -234,14 +385,19 @@ func (rm *RouteManager) addLocalNetworkRoutes(ctx *context.Context) error {
 		"169.254.0.0/16", // 链路本地
 	}
 
-	for _, network := range localNetworks {
-		if err := rm.addRoute(ctx, network, rm.originalGateway); err != nil {
+	if rm.originalGateway6 != "" {
+		// 链路本地与组播地址已有接口上更具体的路由，只需排除唯一本地地址
+		localNetworks = append(localNetworks, "fc00::/7")
+	}
+
+	// 单条失败时继续处理其他路由，不中断
+	for i, err := range rm.addBypassRoutes(ctx, localNetworks) {
+		if err != nil {
 			logger.Warn(ctx, map[string]interface{}{
 				"action":  config.ActionRuntime,
-				"network": network,
+				"network": localNetworks[i],
 				"error":   err,
 			}, "failed to add local network route")
-			// 继续处理其他路由，不中断
 		}
 	}
 
@@ -249,7 +405,7 @@ func (rm *RouteManager) addLocalNetworkRoutes(ctx *context.Context) error {
 }
 
 // addChinaIpRoutes 添加中国 IP 段路由
-func (rm *RouteManager) addChinaIpRoutes(ctx *context.Context) error {
+func (rm *RouteManager) addChinaIpRoutes(ctx context2.Context) error {
 	// 从配置中读取中国 IP 文件
 	if len(config.Config.ChinaIpFile) == 0 {
 		return nil
@@ -267,11 +423,11 @@ func (rm *RouteManager) addChinaIpRoutes(ctx *context.Context) error {
 	}
 
 	lines := strings.Split(string(fileContent), "\n")
-	addedCount := 0
+	cidrs := make([]string, 0)
 	maxRoutes := 1000 // 限制路由数量，避免路由表过大
 
 	for k, line := range lines {
-		if addedCount >= maxRoutes {
+		if len(cidrs) >= maxRoutes {
 			logger.Warn(ctx, map[string]interface{}{
 				"action": config.ActionRuntime,
 			}, "reached max China IP routes limit, some routes may be skipped")
@@ -294,16 +450,20 @@ func (rm *RouteManager) addChinaIpRoutes(ctx *context.Context) error {
 			continue
 		}
 
-		// 添加路由
-		if err := rm.addRoute(ctx, ipNet.String(), rm.originalGateway); err != nil {
+		cidrs = append(cidrs, ipNet.String())
+	}
+
+	// 批量添加路由
+	addedCount := 0
+	for i, err := range rm.addBypassRoutes(ctx, cidrs) {
+		if err != nil {
 			logger.Warn(ctx, map[string]interface{}{
 				"action": config.ActionRuntime,
-				"cidr":   ipNet.String(),
+				"cidr":   cidrs[i],
 				"error":  err,
 			}, "failed to add China IP route")
 			continue
 		}
-
 		addedCount++
 	}
 
@@ -315,454 +475,158 @@ func (rm *RouteManager) addChinaIpRoutes(ctx *context.Context) error {
 	return nil
 }
 
-// addWhiteListRoutes 添加白名单路由
-func (rm *RouteManager) addWhiteListRoutes(ctx *context.Context) error {
+// addWhiteListRoutes 添加白名单路由：CIDR 与 IP 段规则合并为最少的网段后分批添加，数量超过上限时只添加前面的部分
+func (rm *RouteManager) addWhiteListRoutes(ctx context2.Context) error {
 	engine := GetRuleEngine()
 	engine.mu.RLock()
 	rules := engine.whiteRules
 	engine.mu.RUnlock()
 
+	// 只处理 IPv4 的 CIDR 与 IP 段规则，域名规则在路由决策时处理
+	ranges := make([]ipv4Range, 0)
 	for _, rule := range rules {
-		// 只处理IP相关的规则（CIDR和IP范围）
-		// 使用类型断言检查规则类型
-		if cidrRule, ok := rule.(*cidrRule); ok {
-			if err := rm.addRoute(ctx, cidrRule.network.String(), rm.originalGateway); err != nil {
-				logger.Warn(ctx, map[string]interface{}{
-					"action": config.ActionRuntime,
-					"cidr":   cidrRule.network.String(),
-					"error":  err,
-				}, "failed to add whitelist route")
+		switch r := rule.(type) {
+		case *cidrRule:
+			if ipr, ok := networkRange(r.network); ok {
+				ranges = append(ranges, ipr)
 			}
-		} else if ipRangeRule, ok := rule.(*ipRangeRule); ok {
-			// IP范围需要转换为多个路由或单个大范围路由
-			// 这里简化处理，添加起始IP的路由
-			cidr := ipRangeRule.start.String() + "/32"
-			if err := rm.addRoute(ctx, cidr, rm.originalGateway); err != nil {
-				logger.Warn(ctx, map[string]interface{}{
-					"action": config.ActionRuntime,
-					"ip":     ipRangeRule.start.String(),
-					"error":  err,
-				}, "failed to add whitelist route")
+		case *ipRangeRule:
+			start, ok1 := ipv4ToUint(r.start)
+			end, ok2 := ipv4ToUint(r.end)
+			if ok1 && ok2 && start <= end {
+				ranges = append(ranges, ipv4Range{start: start, end: end})
 			}
 		}
-		// 域名规则不需要添加路由，在路由决策时处理
 	}
-
-	return nil
-}
-
-// setDefaultRoute 设置默认路由到 TUN 接口
-func (rm *RouteManager) setDefaultRoute(ctx *context.Context) error {
-	switch runtime.GOOS {
-	case "windows":
-		// Windows 下默认路由需要指定网关 IP，这里使用 TUN 地址作为网关
-		if rm.tunGateway == "" {
-			return fmt.Errorf("tun gateway is empty")
-		}
-		// 使用较高的 metric（10），确保更具体的路由（如 /32）优先
-		return rm.addDefaultRouteWindows(ctx, rm.tunGateway)
-	default:
-		// 其他平台沿用原逻辑（后续可根据需要细化为 dev 语义）
-		return rm.addRoute(ctx, "0.0.0.0/0", rm.tunInterface)
-	}
-}
-
-// addDefaultRouteWindows 添加 Windows 默认路由（使用较高 metric）
-func (rm *RouteManager) addDefaultRouteWindows(ctx *context.Context, gateway string) error {
-	// Windows 下，使用 metric 10 确保更具体的路由优先
-	cmd := exec.Command("route", "add", "0.0.0.0", "mask", "0.0.0.0", gateway, "metric", "10")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("route add default failed: %w, output: %s", err, string(output))
+	networks := make([]string, 0)
+	for _, n := range aggregateRanges(ranges) {
+		networks = append(networks, n.String())
 	}
-	return nil
-}
-
-// deleteDefaultRoute 删除默认路由
-func (rm *RouteManager) deleteDefaultRoute(ctx *context.Context) error {
-	switch runtime.GOOS {
-	case "windows":
-		// Windows 下删除默认路由需要指定网关
-		if rm.tunGateway == "" {
-			return fmt.Errorf("tun gateway is empty")
-		}
-		cmd := exec.Command("route", "delete", "0.0.0.0", "mask", "0.0.0.0", rm.tunGateway)
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("route delete default failed: %w, output: %s", err, string(output))
-		}
+	if len(networks) == 0 {
 		return nil
-	default:
-		return rm.deleteRoute(ctx, "0.0.0.0/0", rm.tunInterface)
 	}
-}
-
-// addRoute 添加路由
-func (rm *RouteManager) addRoute(ctx *context.Context, network, gateway string) error {
-	switch runtime.GOOS {
-	case "windows":
-		return rm.addRouteWindows(ctx, network, gateway)
-	case "linux":
-		return rm.addRouteLinux(ctx, network, gateway)
-	case "darwin":
-		return rm.addRouteDarwin(ctx, network, gateway)
-	default:
-		return fmt.Errorf("unsupported OS: %s", runtime.GOOS)
-	}
-}
-
-// deleteRoute 删除路由
-func (rm *RouteManager) deleteRoute(ctx *context.Context, network, gateway string) error {
-	switch runtime.GOOS {
-	case "windows":
-		return rm.deleteRouteWindows(ctx, network, gateway)
-	case "linux":
-		return rm.deleteRouteLinux(ctx, network, gateway)
-	case "darwin":
-		return rm.deleteRouteDarwin(ctx, network, gateway)
-	default:
-		return fmt.Errorf("unsupported OS: %s", runtime.GOOS)
-	}
-}
-
-// getDefaultGateway 获取默认网关
-func (rm *RouteManager) getDefaultGateway(ctx *context.Context) (string, error) {
-	switch runtime.GOOS {
-	case "windows":
-		return rm.getDefaultGatewayWindows(ctx)
-	case "linux":
-		return rm.getDefaultGatewayLinux(ctx)
-	case "darwin":
-		return rm.getDefaultGatewayDarwin(ctx)
-	default:
-		return "", fmt.Errorf("unsupported OS: %s", runtime.GOOS)
-	}
-}
-
-// getDefaultInterfaceIP 获取默认接口的 IP 地址
-// 用于绑定远程连接，确保不走 TUN
-func (rm *RouteManager) getDefaultInterfaceIP(ctx *context.Context) (net.IP, error) {
-	switch runtime.GOOS {
-	case "windows":
-		return rm.getDefaultInterfaceIPWindows(ctx)
-	case "linux":
-		return rm.getDefaultInterfaceIPLinux(ctx)
-	case "darwin":
-		return rm.getDefaultInterfaceIPDarwin(ctx)
-	default:
-		return nil, fmt.Errorf("unsupported OS: %s", runtime.GOOS)
-	}
-}
-
-// Windows 实现
-func (rm *RouteManager) getDefaultGatewayWindows(ctx *context.Context) (string, error) {
-	cmd := exec.Command("route", "print", "0.0.0.0")
-	output, err := cmd.Output()
-	if err != nil {
-		return "", err
+	if len(networks) > maxWhiteListRoutes {
+		logger.Warn(ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"count":  len(networks),
+			"limit":  maxWhiteListRoutes,
+		}, "too many whitelist routes, the rest are handled by the proxy")
+		networks = networks[:maxWhiteListRoutes]
 	}
 
-	// 解析输出，查找默认网关
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "0.0.0.0") {
-			fields := strings.Fields(line)
-			// 检查是否是默认路由行（包含两个 0.0.0.0）
-			if len(fields) >= 3 && fields[0] == "0.0.0.0" && fields[1] == "0.0.0.0" {
-				return fields[2], nil
+	added := 0
+	for start := 0; start < len(networks); start += whiteListRouteBatch {
+		batch := networks[start:min(start+whiteListRouteBatch, len(networks))]
+		for i, err := range rm.addBypassRoutes(ctx, batch) {
+			if err != nil {
+				logger.Warn(ctx, map[string]interface{}{
+					"action": config.ActionRuntime,
+					"cidr":   batch[i],
+					"error":  err,
+				}, "failed to add whitelist route")
+				continue
 			}
+			added++
 		}
-	}
-
-	return "", fmt.Errorf("default gateway not found")
-}
-
-func (rm *RouteManager) addRouteWindows(ctx *context.Context, network, gateway string) error {
-	// 解析网络
-	_, ipNet, err := net.ParseCIDR(network)
-	if err != nil {
-		return err
-	}
-
-	// 使用 route add 命令
-	// metric 1 确保优先级最高，比默认路由的 metric 10 更优先
-	// Windows 的 metric 值必须大于 0，所以使用 1 作为最高优先级
-	cmd := exec.Command("route", "add", ipNet.IP.String(), "mask", net.IP(ipNet.Mask).String(), gateway, "metric", "1", "if", "0")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		// 如果失败，尝试不使用 if 参数
-		cmd = exec.Command("route", "add", ipNet.IP.String(), "mask", net.IP(ipNet.Mask).String(), gateway, "metric", "1")
-		output, err = cmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("route add failed: %w, output: %s", err, string(output))
-		}
+		logger.Info(ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"done":   start + len(batch),
+			"total":  len(networks),
+			"added":  added,
+		}, "adding whitelist routes")
 	}
 	return nil
 }
 
-func (rm *RouteManager) deleteRouteWindows(ctx *context.Context, network, gateway string) error {
-	_, ipNet, err := net.ParseCIDR(network)
-	if err != nil {
-		return err
-	}
-
-	cmd := exec.Command("route", "delete", ipNet.IP.String(), "mask", net.IP(ipNet.Mask).String(), gateway)
-	return cmd.Run()
-}
-
-// Linux 实现
-func (rm *RouteManager) getDefaultGatewayLinux(ctx *context.Context) (string, error) {
-	cmd := exec.Command("ip", "route", "show", "default")
-	output, err := cmd.Output()
-	if err != nil {
-		return "", err
-	}
-
-	// 解析输出
-	line := strings.TrimSpace(string(output))
-	fields := strings.Fields(line)
-	for i, field := range fields {
-		if field == "via" && i+1 < len(fields) {
-			return fields[i+1], nil
+// addBypassRoutes 批量添加经原网关（IPv6 网段经原 IPv6 网关）的路由并记录成功的部分，返回与 networks 一一对应的错误
+func (rm *RouteManager) addBypassRoutes(ctx context2.Context, networks []string) []error {
+	errs := make([]error, len(networks))
+	groups := make(map[string][]int)
+	for i, network := range networks {
+		gateway := rm.bypassGateway(network)
+		if gateway == "" {
+			errs[i] = fmt.Errorf("add route %s: no IPv6 default gateway", network)
+			continue
 		}
+		groups[gateway] = append(groups[gateway], i)
 	}
-
-	return "", fmt.Errorf("default gateway not found")
-}
-
-func (rm *RouteManager) addRouteLinux(ctx *context.Context, network, gateway string) error {
-	cmd := exec.Command("ip", "route", "add", network, "via", gateway)
-	return cmd.Run()
-}
-
-func (rm *RouteManager) deleteRouteLinux(ctx *context.Context, network, gateway string) error {
-	cmd := exec.Command("ip", "route", "delete", network, "via", gateway)
-	return cmd.Run()
-}
-
-// macOS 实现
-func (rm *RouteManager) getDefaultGatewayDarwin(ctx *context.Context) (string, error) {
-	cmd := exec.Command("route", "-n", "get", "default")
-	output, err := cmd.Output()
-	if err != nil {
-		return "", err
-	}
-
-	// 解析输出
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "gateway:") {
-			fields := strings.Fields(line)
-			if len(fields) >= 2 {
-				return fields[1], nil
-			}
+	for gateway, index := range groups {
+		batch := make([]string, len(index))
+		for k, i := range index {
+			batch[k] = networks[i]
 		}
-	}
-
-	return "", fmt.Errorf("default gateway not found")
-}
-
-func (rm *RouteManager) addRouteDarwin(ctx *context.Context, network, gateway string) error {
-	_, ipNet, err := net.ParseCIDR(network)
-	if err != nil {
-		return err
-	}
-
-	cmd := exec.Command("route", "add", "-net", ipNet.IP.String(), "-netmask", net.IP(ipNet.Mask).String(), gateway)
-	return cmd.Run()
-}
-
-func (rm *RouteManager) deleteRouteDarwin(ctx *context.Context, network, gateway string) error {
-	_, ipNet, err := net.ParseCIDR(network)
-	if err != nil {
-		return err
-	}
-
-	cmd := exec.Command("route", "delete", "-net", ipNet.IP.String(), "-netmask", net.IP(ipNet.Mask).String(), gateway)
-	return cmd.Run()
-}
-
-// getDefaultInterfaceIPWindows 获取 Windows 默认接口的 IP 地址
-func (rm *RouteManager) getDefaultInterfaceIPWindows(ctx *context.Context) (net.IP, error) {
-	// 获取默认网关
-	gateway, err := rm.getDefaultGatewayWindows(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	// 通过默认网关找到对应的接口
-	// 使用 route print 查找默认路由对应的接口
-	cmd := exec.Command("route", "print", "0.0.0.0")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, err
-	}
-
-	// 解析输出，查找默认路由对应的接口索引
-	lines := strings.Split(string(output), "\n")
-	var interfaceIndex string
-	for _, line := range lines {
-		if strings.Contains(line, "0.0.0.0") {
-			fields := strings.Fields(line)
-			// 检查是否是默认路由行（包含两个 0.0.0.0）
-			if len(fields) >= 5 && fields[0] == "0.0.0.0" && fields[1] == "0.0.0.0" {
-				interfaceIndex = fields[3] // 接口索引通常在字段3
-				break
-			}
+		for k, err := range rm.addRoutes(ctx, batch, gateway) {
+			errs[index[k]] = err
+			auditRoute("add", batch[k], gateway, err)
 		}
 	}
-
-	if interfaceIndex == "" {
-		// 如果找不到接口索引，尝试通过网关 IP 查找接口
-		return rm.findInterfaceIPByGateway(gateway)
-	}
-
-	// 使用 netsh 获取接口 IP
-	cmd = exec.Command("netsh", "interface", "ip", "show", "address", "index="+interfaceIndex)
-	output, err = cmd.Output()
-	if err != nil {
-		return rm.findInterfaceIPByGateway(gateway)
-	}
-
-	// 解析输出，查找 IP 地址
-	outputStr := string(output)
-	lines = strings.Split(outputStr, "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "IP Address:") {
-			fields := strings.Fields(line)
-			for i, field := range fields {
-				if field == "Address:" && i+1 < len(fields) {
-					ip := net.ParseIP(fields[i+1])
-					if ip != nil && ip.To4() != nil {
-						return ip, nil
-					}
-				}
-			}
+	for i, err := range errs {
+		if err == nil {
+			rm.added = append(rm.added, networks[i])
 		}
 	}
-
-	// 如果解析失败，尝试通过网关查找
-	return rm.findInterfaceIPByGateway(gateway)
+	rm.saveBackup(ctx)
+	return errs
 }
 
-// findInterfaceIPByGateway 通过网关 IP 查找接口 IP
-func (rm *RouteManager) findInterfaceIPByGateway(gateway string) (net.IP, error) {
-	gatewayIP := net.ParseIP(gateway)
-	if gatewayIP == nil {
-		return nil, fmt.Errorf("invalid gateway IP: %s", gateway)
+// bypassGateway 网段对应的原网关：IPv6 网段为原 IPv6 网关
+func (rm *RouteManager) bypassGateway(network string) string {
+	if strings.Contains(network, ":") {
+		return rm.originalGateway6
 	}
-
-	// 遍历所有接口，找到与网关在同一网段的接口 IP
-	interfaces, err := net.Interfaces()
-	if err != nil {
-		return nil, err
-	}
-
-	for _, iface := range interfaces {
-		addrs, err := iface.Addrs()
-		if err != nil {
-			continue
-		}
-		for _, addr := range addrs {
-			if ipNet, ok := addr.(*net.IPNet); ok {
-				if ip := ipNet.IP.To4(); ip != nil {
-					// 检查网关是否在同一网段
-					if ipNet.Contains(gatewayIP) {
-						return ip, nil
-					}
-				}
-			}
-		}
-	}
-
-	return nil, fmt.Errorf("interface IP not found for gateway: %s", gateway)
+	return rm.originalGateway
 }
 
-// getDefaultInterfaceIPLinux 获取 Linux 默认接口的 IP 地址
-func (rm *RouteManager) getDefaultInterfaceIPLinux(ctx *context.Context) (net.IP, error) {
-	// 获取默认路由，找到对应的接口
-	cmd := exec.Command("ip", "route", "show", "default")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, err
-	}
-
-	// 解析输出，查找接口名称
-	line := strings.TrimSpace(string(output))
-	fields := strings.Fields(line)
-	var interfaceName string
-	for i, field := range fields {
-		if field == "dev" && i+1 < len(fields) {
-			interfaceName = fields[i+1]
-			break
+// forgetRoute 已删除的路由不再在恢复时删除
+func (rm *RouteManager) forgetRoute(network string) {
+	for i, n := range rm.added {
+		if n == network {
+			rm.added = append(rm.added[:i], rm.added[i+1:]...)
+			return
 		}
 	}
+}
 
-	if interfaceName == "" {
-		return nil, fmt.Errorf("default interface not found")
+// addRoutesOneByOne 逐条添加路由，供不支持批量提交的平台实现 addRoutes
+func (rm *RouteManager) addRoutesOneByOne(ctx context2.Context, networks []string, gateway string) []error {
+	errs := make([]error, len(networks))
+	for i, network := range networks {
+		errs[i] = rm.addRoute(ctx, network, gateway)
 	}
+	return errs
+}
 
-	// 获取接口 IP
-	iface, err := net.InterfaceByName(interfaceName)
-	if err != nil {
-		return nil, err
+// uplinkInterface 配置的原出口网卡（tun.uplink），未配置时返回 nil
+func uplinkInterface() (*net.Interface, error) {
+	name := config.Config.Tun.Uplink
+	if name == "" {
+		return nil, nil
 	}
-
-	addrs, err := iface.Addrs()
+	iface, err := net.InterfaceByName(name)
 	if err != nil {
-		return nil, err
-	}
-
-	// 返回第一个 IPv4 地址
-	for _, addr := range addrs {
-		if ipNet, ok := addr.(*net.IPNet); ok {
-			if ip := ipNet.IP.To4(); ip != nil {
-				return ip, nil
-			}
-		}
+		return nil, fmt.Errorf("tun.uplink %s: %w", name, err)
 	}
-
-	return nil, fmt.Errorf("no IPv4 address found on interface: %s", interfaceName)
+	return iface, nil
 }
 
-// getDefaultInterfaceIPDarwin 获取 macOS 默认接口的 IP 地址
-func (rm *RouteManager) getDefaultInterfaceIPDarwin(ctx *context.Context) (net.IP, error) {
-	// 获取默认路由，找到对应的接口
-	cmd := exec.Command("route", "-n", "get", "default")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, err
-	}
-
-	// 解析输出，查找接口名称
-	lines := strings.Split(string(output), "\n")
-	var interfaceName string
-	for _, line := range lines {
-		if strings.Contains(line, "interface:") {
-			fields := strings.Fields(line)
-			if len(fields) >= 2 {
-				interfaceName = fields[1]
-				break
-			}
-		}
+// errDefaultGatewayNotFound 没有可用的默认路由（配置了 tun.uplink 时指该网卡上）
+func errDefaultGatewayNotFound(v6 bool, uplink *net.Interface) error {
+	family := ""
+	if v6 {
+		family = "IPv6 "
 	}
-
-	if interfaceName == "" {
-		return nil, fmt.Errorf("default interface not found")
-	}
-
-	// 获取接口 IP
-	iface, err := net.InterfaceByName(interfaceName)
-	if err != nil {
-		return nil, err
+	if uplink != nil {
+		return fmt.Errorf("%sdefault gateway not found on %s", family, uplink.Name)
 	}
+	return fmt.Errorf("%sdefault gateway not found", family)
+}
 
+// interfaceIPv4 返回接口上的第一个 IPv4 地址
+func interfaceIPv4(iface *net.Interface) (net.IP, error) {
 	addrs, err := iface.Addrs()
 	if err != nil {
 		return nil, err
 	}
-
-	// 返回第一个 IPv4 地址
 	for _, addr := range addrs {
 		if ipNet, ok := addr.(*net.IPNet); ok {
 			if ip := ipNet.IP.To4(); ip != nil {
@@ -770,6 +634,5 @@ func (rm *RouteManager) getDefaultInterfaceIPDarwin(ctx *context.Context) (net.I
 			}
 		}
 	}
-
-	return nil, fmt.Errorf("no IPv4 address found on interface: %s", interfaceName)
+	return nil, fmt.Errorf("no IPv4 address found on interface: %s", iface.Name)
 }
@@ -0,0 +1,334 @@
+//go:build darwin
+
+package route
+
+import (
+	context2 "context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	xroute "golang.org/x/net/route"
+	"golang.org/x/sys/unix"
+)
+
+// macOS 实现：经 PF_ROUTE 路由套接字读写路由表，不解析 route 命令的输出。
+// 删除不存在的路由视为成功
+
+// tunRoutes6 经 TUN 的 IPv6 路由：已有非 scoped 的默认路由时不能再添加一条，以两个 /1 覆盖全部地址
+var tunRoutes6 = []string{"::/1", "8000::/1"}
+
+// darwinRoute 路由表中的一条路由
+type darwinRoute struct {
+	dst     net.IP
+	ones    int    // 前缀长度
+	gateway net.IP // 经接口的路由（如 TUN）为 nil
+	index   int    // 出接口序号
+	scoped  bool   // RTF_IFSCOPE：只对绑定该接口的连接生效，多网卡时非主网卡的默认路由
+}
+
+// setDefaultRoute 设置默认路由到 TUN 接口
+func (rm *RouteManager) setDefaultRoute(ctx context2.Context) error {
+	return rm.addRoute(ctx, "0.0.0.0/0", rm.tunInterface)
+}
+
+// deleteDefaultRoute 删除默认路由，TUN 接口已不存在时路由随之消失
+func (rm *RouteManager) deleteDefaultRoute(ctx context2.Context) error {
+	if _, err := net.InterfaceByName(rm.tunInterface); err != nil {
+		return nil
+	}
+	return rm.deleteRoute(ctx, "0.0.0.0/0", rm.tunInterface)
+}
+
+// setDefaultRoute6 设置经 TUN 接口的 IPv6 路由
+func (rm *RouteManager) setDefaultRoute6(ctx context2.Context) error {
+	for _, err := range rm.addRoutes(ctx, tunRoutes6, rm.tunInterface) {
+		if err != nil && !errors.Is(err, unix.EEXIST) {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteDefaultRoute6 删除经 TUN 接口的 IPv6 路由
+func (rm *RouteManager) deleteDefaultRoute6(ctx context2.Context) error {
+	if _, err := net.InterfaceByName(rm.tunInterface); err != nil {
+		return nil
+	}
+	for _, network := range tunRoutes6 {
+		if err := rm.deleteRoute(ctx, network, rm.tunInterface); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasDefaultRoute 经 TUN 接口的默认路由是否仍在路由表中
+func (rm *RouteManager) hasDefaultRoute(ctx context2.Context) (bool, error) {
+	return rm.hasTunRoutes(unix.AF_INET, 0, 1)
+}
+
+// hasDefaultRoute6 经 TUN 接口的两条 IPv6 /1 路由是否都在路由表中
+func (rm *RouteManager) hasDefaultRoute6(ctx context2.Context) (bool, error) {
+	return rm.hasTunRoutes(unix.AF_INET6, 1, len(tunRoutes6))
+}
+
+// hasTunRoutes 经 TUN 接口、前缀长度为 ones 的路由是否至少有 count 条
+func (rm *RouteManager) hasTunRoutes(family, ones, count int) (bool, error) {
+	iface, err := net.InterfaceByName(rm.tunInterface)
+	if err != nil {
+		return false, err
+	}
+	routes, err := darwinRoutes(family)
+	if err != nil {
+		return false, err
+	}
+	n := 0
+	for _, r := range routes {
+		if r.index == iface.Index && r.ones == ones {
+			n++
+		}
+	}
+	return n >= count, nil
+}
+
+// getDefaultGateway 获取默认网关及其出接口
+func (rm *RouteManager) getDefaultGateway(ctx context2.Context) (string, *net.Interface, error) {
+	r, err := rm.originalDefaultRoute(unix.AF_INET)
+	if err != nil {
+		return "", nil, err
+	}
+	iface, err := net.InterfaceByIndex(r.index)
+	if err != nil {
+		return "", nil, err
+	}
+	return r.gateway.String(), iface, nil
+}
+
+// getDefaultGateway6 获取 IPv6 默认网关，带出接口名（如 fe80::1%en0）
+func (rm *RouteManager) getDefaultGateway6(ctx context2.Context) (string, error) {
+	r, err := rm.originalDefaultRoute(unix.AF_INET6)
+	if err != nil {
+		return "", err
+	}
+	iface, err := net.InterfaceByIndex(r.index)
+	if err != nil {
+		return "", err
+	}
+	return (&net.IPAddr{IP: r.gateway, Zone: iface.Name}).String(), nil
+}
+
+// originalDefaultRoute 选出 family 地址族的原默认路由：Wi-Fi 与有线同时在线时各有一条默认路由，
+// 优先系统主网卡的非 scoped 路由，其次是其他已启用（IPv4 还须有 IPv4 地址）的网卡；经 TUN 的默认路由（上次异常退出的残留）不计入。
+// 配置了 tun.uplink 时只取该网卡上的默认路由
+func (rm *RouteManager) originalDefaultRoute(family int) (*darwinRoute, error) {
+	uplink, err := uplinkInterface()
+	if err != nil {
+		return nil, err
+	}
+	routes, err := darwinRoutes(family)
+	if err != nil {
+		return nil, err
+	}
+	var fallback *darwinRoute
+	for _, r := range routes {
+		if r.ones != 0 || r.gateway == nil {
+			continue
+		}
+		iface, err := net.InterfaceByIndex(r.index)
+		if err != nil || iface.Name == rm.tunInterface || iface.Flags&net.FlagUp == 0 || (uplink != nil && iface.Index != uplink.Index) {
+			continue
+		}
+		if family == unix.AF_INET {
+			if _, err = interfaceIPv4(iface); err != nil {
+				continue
+			}
+		}
+		if !r.scoped {
+			return r, nil
+		}
+		if fallback == nil {
+			fallback = r
+		}
+	}
+	if fallback == nil {
+		return nil, errDefaultGatewayNotFound(family == unix.AF_INET6, uplink)
+	}
+	return fallback, nil
+}
+
+// darwinRoutes 从路由表中读取 family 地址族的全部路由
+func darwinRoutes(family int) ([]*darwinRoute, error) {
+	rib, err := xroute.FetchRIB(family, xroute.RIBTypeRoute, 0)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := xroute.ParseRIB(xroute.RIBTypeRoute, rib)
+	if err != nil {
+		return nil, err
+	}
+	var routes []*darwinRoute
+	for _, msg := range msgs {
+		m, ok := msg.(*xroute.RouteMessage)
+		if !ok || m.Flags&unix.RTF_UP == 0 || len(m.Addrs) <= unix.RTAX_NETMASK {
+			continue
+		}
+		r := &darwinRoute{index: m.Index, scoped: m.Flags&unix.RTF_IFSCOPE != 0}
+		switch dst := m.Addrs[unix.RTAX_DST].(type) {
+		case *xroute.Inet4Addr:
+			r.dst, r.ones = net.IP(dst.IP[:]), 32
+		case *xroute.Inet6Addr:
+			r.dst, r.ones = net.IP(dst.IP[:]), 128
+		default:
+			continue
+		}
+		// 没有掩码的是主机路由；默认路由的掩码可能省略长度，解析为全 0
+		if m.Flags&unix.RTF_HOST == 0 {
+			var mask net.IPMask
+			switch a := m.Addrs[unix.RTAX_NETMASK].(type) {
+			case *xroute.Inet4Addr:
+				mask = a.IP[:]
+			case *xroute.Inet6Addr:
+				mask = a.IP[:]
+			}
+			if mask == nil {
+				r.ones = 0
+			} else if ones, bits := mask.Size(); bits != 0 {
+				r.ones = ones
+			} else {
+				continue // 非连续掩码
+			}
+		}
+		if m.Flags&unix.RTF_GATEWAY != 0 {
+			switch gw := m.Addrs[unix.RTAX_GATEWAY].(type) {
+			case *xroute.Inet4Addr:
+				r.gateway = net.IP(gw.IP[:])
+			case *xroute.Inet6Addr:
+				r.gateway = net.IP(gw.IP[:])
+			}
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// addRoute 添加路由
+func (rm *RouteManager) addRoute(ctx context2.Context, network, gateway string) error {
+	return rm.addRoutes(ctx, []string{network}, gateway)[0]
+}
+
+// addRoutes 在同一个路由套接字上批量添加路由，返回与 networks 一一对应的错误
+func (rm *RouteManager) addRoutes(ctx context2.Context, networks []string, gateway string) []error {
+	return changeRoutesDarwin(unix.RTM_ADD, networks, gateway)
+}
+
+// deleteRoute 删除路由
+func (rm *RouteManager) deleteRoute(ctx context2.Context, network, gateway string) error {
+	err := changeRoutesDarwin(unix.RTM_DELETE, []string{network}, gateway)[0]
+	if errors.Is(err, unix.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+// changeRoutesDarwin 写入 RTM_ADD/RTM_DELETE 消息：gateway 为 IP 时经该网关（可带 %接口名，用于 IPv6 链路本地网关），
+// 否则视为接口名，添加经该接口的路由
+func changeRoutesDarwin(typ int, networks []string, gateway string) []error {
+	errs := make([]error, len(networks))
+	op := "add"
+	if typ == unix.RTM_DELETE {
+		op = "delete"
+	}
+	gw, flags, err := darwinGateway(gateway)
+	if err != nil {
+		for i, network := range networks {
+			errs[i] = fmt.Errorf("%s route %s via %s: %w", op, network, gateway, err)
+		}
+		return errs
+	}
+
+	fd, err := unix.Socket(unix.AF_ROUTE, unix.SOCK_RAW, unix.AF_UNSPEC)
+	if err != nil {
+		err = os.NewSyscallError("socket", err)
+		for i, network := range networks {
+			errs[i] = fmt.Errorf("%s route %s via %s: %w", op, network, gateway, err)
+		}
+		return errs
+	}
+	defer unix.Close(fd)
+	// 只写不读，不接收内核回显的路由消息
+	_ = unix.Shutdown(fd, unix.SHUT_RD)
+
+	for i, network := range networks {
+		_, dst, err := net.ParseCIDR(network)
+		var dstAddr, maskAddr xroute.Addr
+		if err == nil {
+			if ip4 := dst.IP.To4(); ip4 != nil {
+				dstAddr, maskAddr = &xroute.Inet4Addr{IP: [4]byte(ip4)}, &xroute.Inet4Addr{IP: [4]byte(dst.Mask)}
+			} else {
+				dstAddr, maskAddr = &xroute.Inet6Addr{IP: [16]byte(dst.IP.To16())}, &xroute.Inet6Addr{IP: [16]byte(dst.Mask)}
+			}
+			if _, isLink := gw.(*xroute.LinkAddr); !isLink && dstAddr.Family() != gw.Family() {
+				err = fmt.Errorf("address family does not match gateway")
+			}
+		}
+		if err == nil {
+			m := &xroute.RouteMessage{
+				Version: unix.RTM_VERSION,
+				Type:    typ,
+				Flags:   flags,
+				ID:      uintptr(os.Getpid()),
+				Seq:     i + 1,
+				Addrs: []xroute.Addr{
+					unix.RTAX_DST:     dstAddr,
+					unix.RTAX_GATEWAY: gw,
+					unix.RTAX_NETMASK: maskAddr,
+				},
+			}
+			var b []byte
+			if b, err = m.Marshal(); err == nil {
+				_, err = unix.Write(fd, b)
+			}
+		}
+		if err != nil {
+			errs[i] = fmt.Errorf("%s route %s via %s: %w", op, network, gateway, err)
+		}
+	}
+	return errs
+}
+
+// darwinGateway 解析网关为路由消息中的地址与标志：IP（IPv6 可带 %接口名）为经网关的路由，否则按接口名
+func darwinGateway(gateway string) (xroute.Addr, int, error) {
+	flags := unix.RTF_UP | unix.RTF_STATIC
+	host, zone, _ := strings.Cut(gateway, "%")
+	if ip := net.ParseIP(host); ip != nil {
+		flags |= unix.RTF_GATEWAY
+		if ip4 := ip.To4(); ip4 != nil {
+			return &xroute.Inet4Addr{IP: [4]byte(ip4)}, flags, nil
+		}
+		gw := &xroute.Inet6Addr{IP: [16]byte(ip)}
+		if zone != "" {
+			iface, err := net.InterfaceByName(zone)
+			if err != nil {
+				return nil, 0, err
+			}
+			gw.ZoneID = iface.Index
+		}
+		return gw, flags, nil
+	}
+	iface, err := net.InterfaceByName(gateway)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &xroute.LinkAddr{Index: iface.Index, Name: iface.Name}, flags, nil
+}
+
+// addMarkRules macOS 没有 fwmark，出站连接靠绑定原接口绕过 TUN
+func (rm *RouteManager) addMarkRules(ctx context2.Context) error {
+	return nil
+}
+
+func (rm *RouteManager) deleteMarkRules(ctx context2.Context) {
+}
@@ -0,0 +1,362 @@
+//go:build linux
+
+package route
+
+import (
+	context2 "context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/utils/logger"
+)
+
+// Linux 实现：经 rtnetlink 直接读写路由表与策略路由，不依赖 iproute2。
+// 添加已存在的路由返回的错误满足 errors.Is(err, os.ErrExist)；删除不存在的路由视为成功
+
+// tunRoutePriority6 经 TUN 的 IPv6 默认路由的 metric，未指定时内核取 1024，与路由通告的默认路由冲突
+const tunRoutePriority6 = 1
+
+// setDefaultRoute 设置默认路由到 TUN 接口
+func (rm *RouteManager) setDefaultRoute(ctx context2.Context) error {
+	return rm.addRoute(ctx, "0.0.0.0/0", rm.tunInterface)
+}
+
+// deleteDefaultRoute 删除默认路由，TUN 接口已不存在时路由随之消失
+func (rm *RouteManager) deleteDefaultRoute(ctx context2.Context) error {
+	if _, err := net.InterfaceByName(rm.tunInterface); err != nil {
+		return nil
+	}
+	return rm.deleteRoute(ctx, "0.0.0.0/0", rm.tunInterface)
+}
+
+// setDefaultRoute6 设置 IPv6 默认路由到 TUN 接口，metric 1 优先于路由通告的默认路由（1024）
+func (rm *RouteManager) setDefaultRoute6(ctx context2.Context) error {
+	return changeRoutes(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_EXCL, []string{"::/0"}, rm.tunInterface, tunRoutePriority6)[0]
+}
+
+// deleteDefaultRoute6 删除经 TUN 接口的 IPv6 默认路由
+func (rm *RouteManager) deleteDefaultRoute6(ctx context2.Context) error {
+	if _, err := net.InterfaceByName(rm.tunInterface); err != nil {
+		return nil
+	}
+	return rm.deleteRoute(ctx, "::/0", rm.tunInterface)
+}
+
+// hasDefaultRoute 经 TUN 接口的默认路由是否仍在 main 表中
+func (rm *RouteManager) hasDefaultRoute(ctx context2.Context) (bool, error) {
+	return rm.hasTunDefaultRoute(unix.AF_INET)
+}
+
+// hasDefaultRoute6 经 TUN 接口的 IPv6 默认路由是否仍在 main 表中
+func (rm *RouteManager) hasDefaultRoute6(ctx context2.Context) (bool, error) {
+	return rm.hasTunDefaultRoute(unix.AF_INET6)
+}
+
+func (rm *RouteManager) hasTunDefaultRoute(family uint8) (bool, error) {
+	iface, err := net.InterfaceByName(rm.tunInterface)
+	if err != nil {
+		return false, err
+	}
+	nl, err := openRtnetlink()
+	if err != nil {
+		return false, err
+	}
+	defer nl.Close()
+	routes, err := nl.defaultRoutes(family)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range routes {
+		if r.oif == iface.Index {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// getDefaultGateway 获取默认网关及其出接口
+func (rm *RouteManager) getDefaultGateway(ctx context2.Context) (string, *net.Interface, error) {
+	r, err := rm.originalDefaultRoute(unix.AF_INET)
+	if err != nil {
+		return "", nil, err
+	}
+	iface, err := net.InterfaceByIndex(r.oif)
+	if err != nil {
+		return "", nil, err
+	}
+	return r.gateway.String(), iface, nil
+}
+
+// getDefaultGateway6 获取 IPv6 默认网关，带出接口名（如 fe80::1%eth0），路由通告的网关通常是链路本地地址
+func (rm *RouteManager) getDefaultGateway6(ctx context2.Context) (string, error) {
+	r, err := rm.originalDefaultRoute(unix.AF_INET6)
+	if err != nil {
+		return "", err
+	}
+	iface, err := net.InterfaceByIndex(r.oif)
+	if err != nil {
+		return "", err
+	}
+	return (&net.IPAddr{IP: r.gateway, Zone: iface.Name}).String(), nil
+}
+
+// addRoute 添加路由
+func (rm *RouteManager) addRoute(ctx context2.Context, network, gateway string) error {
+	return rm.addRoutes(ctx, []string{network}, gateway)[0]
+}
+
+// addRoutes 在同一个 netlink 套接字上批量添加路由，返回与 networks 一一对应的错误
+func (rm *RouteManager) addRoutes(ctx context2.Context, networks []string, gateway string) []error {
+	return changeRoutes(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_EXCL, networks, gateway, 0)
+}
+
+// deleteRoute 删除路由
+func (rm *RouteManager) deleteRoute(ctx context2.Context, network, gateway string) error {
+	err := changeRoutes(unix.RTM_DELROUTE, 0, []string{network}, gateway, 0)[0]
+	if errors.Is(err, unix.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+// changeRoutes 添加或删除 main 表中的路由：gateway 为 IP 时经该网关（可带 %接口名，用于链路本地网关），
+// 否则视为接口名，添加经该接口的直连路由；networks 的地址族须与网关一致
+func changeRoutes(typ uint16, flags uint16, networks []string, gateway string, priority uint32) []error {
+	errs := make([]error, len(networks))
+	op := "add"
+	if typ == unix.RTM_DELROUTE {
+		op = "delete"
+	}
+	gw, oif, scope, err := parseGateway(gateway)
+	if err != nil {
+		for i, network := range networks {
+			errs[i] = fmt.Errorf("%s route %s via %s: %w", op, network, gateway, err)
+		}
+		return errs
+	}
+	protocol := uint8(unix.RTPROT_BOOT)
+	if typ == unix.RTM_DELROUTE {
+		// 删除时不限定协议与作用域，与 ip route delete 一致
+		protocol, scope = unix.RTPROT_UNSPEC, unix.RT_SCOPE_NOWHERE
+	}
+
+	reqs := make([]nlRequest, 0, len(networks))
+	index := make([]int, 0, len(networks))
+	for i, network := range networks {
+		_, dst, err := net.ParseCIDR(network)
+		if err == nil && gw != nil && familyOf(dst.IP) != familyOf(gw) {
+			err = fmt.Errorf("address family does not match gateway")
+		}
+		if err != nil {
+			errs[i] = fmt.Errorf("%s route %s: %w", op, network, err)
+			continue
+		}
+		reqs = append(reqs, nlRequest{typ: typ, flags: flags, body: routeBody(dst, gw, oif, unix.RT_TABLE_MAIN, scope, protocol, priority)})
+		index = append(index, i)
+	}
+	if len(reqs) == 0 {
+		return errs
+	}
+
+	nl, err := openRtnetlink()
+	if err != nil {
+		for _, i := range index {
+			errs[i] = err
+		}
+		return errs
+	}
+	defer nl.Close()
+	for k, err := range nl.execute(reqs) {
+		if err != nil {
+			i := index[k]
+			errs[i] = fmt.Errorf("%s route %s via %s: %w", op, networks[i], gateway, err)
+		}
+	}
+	return errs
+}
+
+// parseGateway 解析网关：IP（可带 %接口名）经该网关，否则按接口名返回直连路由的出接口
+func parseGateway(gateway string) (net.IP, int, uint8, error) {
+	host, zone, _ := strings.Cut(gateway, "%")
+	if ip := net.ParseIP(host); ip != nil {
+		if zone == "" {
+			return ip, 0, unix.RT_SCOPE_UNIVERSE, nil
+		}
+		iface, err := net.InterfaceByName(zone)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		return ip, iface.Index, unix.RT_SCOPE_UNIVERSE, nil
+	}
+	iface, err := net.InterfaceByName(gateway)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return nil, iface.Index, unix.RT_SCOPE_LINK, nil
+}
+
+// originalDefaultRoute 选出 main 表中 family 地址族的原默认路由：经网关、不经 TUN（上次异常退出的残留），
+// 配置了 tun.uplink 时只取该网卡上的；多条时取 metric 最小的
+func (rm *RouteManager) originalDefaultRoute(family uint8) (*defaultRoute, error) {
+	uplink, err := uplinkInterface()
+	if err != nil {
+		return nil, err
+	}
+	nl, err := openRtnetlink()
+	if err != nil {
+		return nil, err
+	}
+	defer nl.Close()
+	routes, err := nl.defaultRoutes(family)
+	if err != nil {
+		return nil, err
+	}
+	tunIndex := -1
+	if iface, err := net.InterfaceByName(rm.tunInterface); err == nil {
+		tunIndex = iface.Index
+	}
+	var best *defaultRoute
+	for _, r := range routes {
+		if r.gateway == nil || r.oif == 0 || r.oif == tunIndex || (uplink != nil && r.oif != uplink.Index) {
+			continue
+		}
+		if best == nil || r.priority < best.priority {
+			best = r
+		}
+	}
+	if best == nil {
+		return nil, errDefaultGatewayNotFound(family == unix.AF_INET6, uplink)
+	}
+	return best, nil
+}
+
+// fibRule 标记流量的策略路由规则
+type fibRule struct {
+	table             int
+	priority          int
+	suppressPrefixlen int // 小于 0 表示不设置
+}
+
+// markRules 标记流量的策略路由：先查 main 表但忽略默认路由（本地网络、远端直连等具体路由仍生效），
+// 再查只含原默认路由的独立路由表
+func markRules(mark int) []fibRule {
+	return []fibRule{
+		{table: unix.RT_TABLE_MAIN, priority: 9000, suppressPrefixlen: 0},
+		{table: mark, priority: 9001, suppressPrefixlen: -1},
+	}
+}
+
+// addMarkRules 把原默认路由（有 IPv6 默认网关时包括 IPv6）复制到 tun.mark 路由表，安装策略路由后为出站连接设置 SO_MARK
+func (rm *RouteManager) addMarkRules(ctx context2.Context) error {
+	mark := config.TunMark()
+	gw := net.ParseIP(rm.originalGateway).To4()
+	if gw == nil {
+		return fmt.Errorf("invalid original gateway: %s", rm.originalGateway)
+	}
+	nl, err := openRtnetlink()
+	if err != nil {
+		return err
+	}
+	defer nl.Close()
+
+	target := fmt.Sprintf("fwmark %d table %d", mark, mark)
+	if err = rm.addFamilyMarkRules(nl, mark, "0.0.0.0/0", rm.originalGateway); err != nil {
+		logger.Audit("fwmark", "add", target, "", "via "+rm.originalGateway, err)
+		rm.deleteMarkRules(ctx)
+		return err
+	}
+	logger.Audit("fwmark", "add", target, "", "via "+rm.originalGateway, nil)
+	if rm.originalGateway6 != "" {
+		if err = rm.addFamilyMarkRules(nl, mark, "::/0", rm.originalGateway6); err != nil {
+			logger.Audit("fwmark", "add", target, "", "via "+rm.originalGateway6, err)
+			rm.deleteMarkRules(ctx)
+			return err
+		}
+		logger.Audit("fwmark", "add", target, "", "via "+rm.originalGateway6, nil)
+	}
+	rm.mark = mark
+	common.SetSocketMark(mark)
+	logger.Info(ctx, map[string]interface{}{
+		"action":   config.ActionRuntime,
+		"mark":     mark,
+		"gateway":  rm.originalGateway,
+		"gateway6": rm.originalGateway6,
+	}, "marked traffic bypasses TUN")
+	return nil
+}
+
+// addFamilyMarkRules 为 defaultDst 所属地址族复制经 gateway 的默认路由到 mark 路由表并安装策略路由
+func (rm *RouteManager) addFamilyMarkRules(nl *rtnetlink, mark int, defaultDst, gateway string) error {
+	gw, oif, _, err := parseGateway(gateway)
+	if err != nil || gw == nil {
+		return fmt.Errorf("invalid original gateway: %s", gateway)
+	}
+	_, dst, _ := net.ParseCIDR(defaultDst)
+	family := familyOf(dst.IP)
+	reqs := []nlRequest{{
+		typ:   unix.RTM_NEWROUTE,
+		flags: unix.NLM_F_CREATE | unix.NLM_F_REPLACE,
+		body:  routeBody(dst, gw, oif, mark, unix.RT_SCOPE_UNIVERSE, unix.RTPROT_BOOT, 0),
+	}}
+	// 先删除上次异常退出残留的同名规则，避免重复
+	rules := markRules(mark)
+	for _, r := range rules {
+		reqs = append(reqs, nlRequest{typ: unix.RTM_DELRULE, body: ruleBody(family, mark, r.table, r.priority, r.suppressPrefixlen)})
+	}
+	for _, r := range rules {
+		reqs = append(reqs, nlRequest{typ: unix.RTM_NEWRULE, flags: unix.NLM_F_CREATE | unix.NLM_F_EXCL, body: ruleBody(family, mark, r.table, r.priority, r.suppressPrefixlen)})
+	}
+	errs := nl.execute(reqs)
+	if errs[0] != nil {
+		return fmt.Errorf("replace default route %s in table %d: %w", defaultDst, mark, errs[0])
+	}
+	for i, r := range rules {
+		if err := errs[1+len(rules)+i]; err != nil {
+			return fmt.Errorf("add rule fwmark %d lookup %d priority %d: %w", mark, r.table, r.priority, err)
+		}
+	}
+	return nil
+}
+
+// deleteMarkRules 取消 SO_MARK 并删除两个地址族的策略路由与路由表
+func (rm *RouteManager) deleteMarkRules(ctx context2.Context) {
+	common.SetSocketMark(0)
+	mark := rm.mark
+	if mark == 0 {
+		mark = config.TunMark() // 安装中途失败时按配置清理
+	}
+	rm.mark = 0
+	target := fmt.Sprintf("fwmark %d table %d", mark, mark)
+	nl, err := openRtnetlink()
+	logger.Audit("fwmark", "delete", target, "", "", err)
+	if err != nil {
+		logger.Warn(ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"error":  err,
+		}, "failed to delete fwmark rules")
+		return
+	}
+	defer nl.Close()
+	for _, defaultDst := range []string{"0.0.0.0/0", "::/0"} {
+		_, dst, _ := net.ParseCIDR(defaultDst)
+		family := familyOf(dst.IP)
+		var reqs []nlRequest
+		for _, r := range markRules(mark) {
+			reqs = append(reqs, nlRequest{typ: unix.RTM_DELRULE, body: ruleBody(family, mark, r.table, r.priority, r.suppressPrefixlen)})
+		}
+		_ = nl.execute(reqs)
+		// 清空路由表：逐条删除默认路由直到不存在
+		del := []nlRequest{{typ: unix.RTM_DELROUTE, body: routeBody(dst, nil, 0, mark, unix.RT_SCOPE_NOWHERE, unix.RTPROT_UNSPEC, 0)}}
+		for i := 0; i < 16; i++ {
+			if nl.execute(del)[0] != nil {
+				break
+			}
+		}
+	}
+}
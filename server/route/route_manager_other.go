@@ -0,0 +1,63 @@
+//go:build !linux && !darwin && !windows
+
+package route
+
+import (
+	context2 "context"
+	"errors"
+	"net"
+	"runtime"
+)
+
+var errRouteUnsupported = errors.New("route management is not supported on " + runtime.GOOS)
+
+func (rm *RouteManager) setDefaultRoute(ctx context2.Context) error {
+	return errRouteUnsupported
+}
+
+func (rm *RouteManager) deleteDefaultRoute(ctx context2.Context) error {
+	return errRouteUnsupported
+}
+
+func (rm *RouteManager) hasDefaultRoute(ctx context2.Context) (bool, error) {
+	return false, errRouteUnsupported
+}
+
+func (rm *RouteManager) setDefaultRoute6(ctx context2.Context) error {
+	return errRouteUnsupported
+}
+
+func (rm *RouteManager) deleteDefaultRoute6(ctx context2.Context) error {
+	return errRouteUnsupported
+}
+
+func (rm *RouteManager) hasDefaultRoute6(ctx context2.Context) (bool, error) {
+	return false, errRouteUnsupported
+}
+
+func (rm *RouteManager) getDefaultGateway(ctx context2.Context) (string, *net.Interface, error) {
+	return "", nil, errRouteUnsupported
+}
+
+func (rm *RouteManager) getDefaultGateway6(ctx context2.Context) (string, error) {
+	return "", errRouteUnsupported
+}
+
+func (rm *RouteManager) addRoute(ctx context2.Context, network, gateway string) error {
+	return errRouteUnsupported
+}
+
+func (rm *RouteManager) addRoutes(ctx context2.Context, networks []string, gateway string) []error {
+	return rm.addRoutesOneByOne(ctx, networks, gateway)
+}
+
+func (rm *RouteManager) deleteRoute(ctx context2.Context, network, gateway string) error {
+	return errRouteUnsupported
+}
+
+func (rm *RouteManager) addMarkRules(ctx context2.Context) error {
+	return nil
+}
+
+func (rm *RouteManager) deleteMarkRules(ctx context2.Context) {
+}
@@ -0,0 +1,274 @@
+//go:build windows
+
+package route
+
+import (
+	context2 "context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Windows 实现：经 IP Helper（GetBestRoute2/CreateIpForwardEntry2）读写路由表，
+// 不解析随系统语言变化的 route.exe/netsh 输出。删除不存在的路由视为成功
+
+// defaultRouteProbe 查询默认路由时使用的公网地址（TEST-NET-2 与 IPv6 文档地址，不会有更具体的路由）
+var (
+	defaultRouteProbe  = net.IPv4(198, 51, 100, 1)
+	defaultRouteProbe6 = net.ParseIP("2001:db8::1")
+)
+
+// setDefaultRoute 设置默认路由到 TUN 接口
+// 以 TUN 地址作为下一跳，使用较高的 metric（10），确保更具体的路由（如 /32）优先
+func (rm *RouteManager) setDefaultRoute(ctx context2.Context) error {
+	row, err := rm.tunDefaultRoute()
+	if err != nil {
+		return err
+	}
+	if err = createIpForwardEntry2(row); err != nil {
+		return fmt.Errorf("add default route via %s: %w", rm.tunGateway, err)
+	}
+	return nil
+}
+
+// deleteDefaultRoute 删除默认路由，TUN 接口已不存在时路由随之消失
+func (rm *RouteManager) deleteDefaultRoute(ctx context2.Context) error {
+	row, err := rm.tunDefaultRoute()
+	if err != nil {
+		if _, e := net.InterfaceByName(rm.tunInterface); e != nil {
+			return nil
+		}
+		return err
+	}
+	if err = deleteIpForwardEntry2(row); err != nil && !errors.Is(err, windows.ERROR_NOT_FOUND) {
+		return fmt.Errorf("delete default route via %s: %w", rm.tunGateway, err)
+	}
+	return nil
+}
+
+// setDefaultRoute6 设置经 TUN 接口的 IPv6 默认路由，metric 与 IPv4 相同
+func (rm *RouteManager) setDefaultRoute6(ctx context2.Context) error {
+	row, err := rm.tunDefaultRoute6()
+	if err != nil {
+		return err
+	}
+	if err = createIpForwardEntry2(row); err != nil {
+		return fmt.Errorf("add IPv6 default route via %s: %w", rm.tunInterface, err)
+	}
+	return nil
+}
+
+// deleteDefaultRoute6 删除经 TUN 接口的 IPv6 默认路由
+func (rm *RouteManager) deleteDefaultRoute6(ctx context2.Context) error {
+	row, err := rm.tunDefaultRoute6()
+	if err != nil {
+		return nil // TUN 接口已不存在
+	}
+	if err = deleteIpForwardEntry2(row); err != nil && !errors.Is(err, windows.ERROR_NOT_FOUND) {
+		return fmt.Errorf("delete IPv6 default route via %s: %w", rm.tunInterface, err)
+	}
+	return nil
+}
+
+// tunDefaultRoute 经 TUN 接口、以 TUN 地址为下一跳的默认路由
+func (rm *RouteManager) tunDefaultRoute() (*windows.MibIpForwardRow2, error) {
+	gateway := net.ParseIP(rm.tunGateway).To4()
+	if gateway == nil {
+		return nil, fmt.Errorf("invalid tun gateway: %q", rm.tunGateway)
+	}
+	iface, err := net.InterfaceByName(rm.tunInterface)
+	if err != nil {
+		return nil, fmt.Errorf("tun interface %s: %w", rm.tunInterface, err)
+	}
+	_, dst, _ := net.ParseCIDR("0.0.0.0/0")
+	return newForwardRow(dst, gateway, uint32(iface.Index), 10), nil
+}
+
+// tunDefaultRoute6 经 TUN 接口直连（下一跳为 ::）的 IPv6 默认路由
+func (rm *RouteManager) tunDefaultRoute6() (*windows.MibIpForwardRow2, error) {
+	iface, err := net.InterfaceByName(rm.tunInterface)
+	if err != nil {
+		return nil, fmt.Errorf("tun interface %s: %w", rm.tunInterface, err)
+	}
+	_, dst, _ := net.ParseCIDR("::/0")
+	return newForwardRow(dst, net.IPv6unspecified, uint32(iface.Index), 10), nil
+}
+
+// hasDefaultRoute 经 TUN 接口的默认路由是否仍在路由表中
+func (rm *RouteManager) hasDefaultRoute(ctx context2.Context) (bool, error) {
+	return rm.hasTunDefaultRoute(windows.AF_INET)
+}
+
+// hasDefaultRoute6 经 TUN 接口的 IPv6 默认路由是否仍在路由表中
+func (rm *RouteManager) hasDefaultRoute6(ctx context2.Context) (bool, error) {
+	return rm.hasTunDefaultRoute(windows.AF_INET6)
+}
+
+func (rm *RouteManager) hasTunDefaultRoute(family uint16) (bool, error) {
+	iface, err := net.InterfaceByName(rm.tunInterface)
+	if err != nil {
+		return false, err
+	}
+	var table *windows.MibIpForwardTable2
+	if err = windows.GetIpForwardTable2(family, &table); err != nil {
+		return false, fmt.Errorf("GetIpForwardTable2: %w", err)
+	}
+	defer windows.FreeMibTable(unsafe.Pointer(table))
+	for _, row := range table.Rows() {
+		if row.DestinationPrefix.PrefixLength == 0 && row.InterfaceIndex == uint32(iface.Index) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// getDefaultGateway 获取默认网关及其出接口
+func (rm *RouteManager) getDefaultGateway(ctx context2.Context) (string, *net.Interface, error) {
+	gateway, ifIndex, err := originalDefaultRoute(windows.AF_INET, defaultRouteProbe)
+	if err != nil {
+		return "", nil, err
+	}
+	iface, err := net.InterfaceByIndex(int(ifIndex))
+	if err != nil {
+		return "", nil, err
+	}
+	return gateway.String(), iface, nil
+}
+
+// getDefaultGateway6 获取 IPv6 默认网关，带出接口名（路由通告的网关通常是链路本地地址）
+func (rm *RouteManager) getDefaultGateway6(ctx context2.Context) (string, error) {
+	gateway, ifIndex, err := originalDefaultRoute(windows.AF_INET6, defaultRouteProbe6)
+	if err != nil {
+		return "", err
+	}
+	iface, err := net.InterfaceByIndex(int(ifIndex))
+	if err != nil {
+		return "", err
+	}
+	return (&net.IPAddr{IP: gateway, Zone: iface.Name}).String(), nil
+}
+
+// originalDefaultRoute 选出 family 地址族的原默认路由：未配置 tun.uplink 时取系统到 probe 的最优路由（已计入接口 metric），
+// 否则取该网卡上 metric 最小的默认路由。返回下一跳与出接口序号
+func originalDefaultRoute(family uint16, probe net.IP) (net.IP, uint32, error) {
+	uplink, err := uplinkInterface()
+	if err != nil {
+		return nil, 0, err
+	}
+	if uplink == nil {
+		row, _, err := getBestRoute2(probe)
+		if err != nil {
+			return nil, 0, err
+		}
+		gateway := sockaddrInetIP(&row.NextHop)
+		if gateway == nil || gateway.IsUnspecified() {
+			return nil, 0, errDefaultGatewayNotFound(family == windows.AF_INET6, nil)
+		}
+		return gateway, row.InterfaceIndex, nil
+	}
+
+	var table *windows.MibIpForwardTable2
+	if err = windows.GetIpForwardTable2(family, &table); err != nil {
+		return nil, 0, fmt.Errorf("GetIpForwardTable2: %w", err)
+	}
+	defer windows.FreeMibTable(unsafe.Pointer(table))
+	var best net.IP
+	var bestMetric uint32
+	for _, row := range table.Rows() {
+		if row.DestinationPrefix.PrefixLength != 0 || row.InterfaceIndex != uint32(uplink.Index) {
+			continue
+		}
+		gateway := sockaddrInetIP(&row.NextHop)
+		if gateway == nil || gateway.IsUnspecified() {
+			continue
+		}
+		if best == nil || row.Metric < bestMetric {
+			best, bestMetric = gateway, row.Metric
+		}
+	}
+	if best == nil {
+		return nil, 0, errDefaultGatewayNotFound(family == windows.AF_INET6, uplink)
+	}
+	return best, uint32(uplink.Index), nil
+}
+
+// addRoute 添加路由
+func (rm *RouteManager) addRoute(ctx context2.Context, network, gateway string) error {
+	return rm.addRoutes(ctx, []string{network}, gateway)[0]
+}
+
+// addRoutes 批量添加经 gateway 的路由，返回与 networks 一一对应的错误；
+// 出接口按到网关的最优路由只查一次。metric 1 确保比默认路由的 metric 10 更优先
+func (rm *RouteManager) addRoutes(ctx context2.Context, networks []string, gateway string) []error {
+	errs := make([]error, len(networks))
+	ifIndex, gw, err := gatewayInterface(gateway)
+	for i, network := range networks {
+		if err != nil {
+			errs[i] = fmt.Errorf("add route %s via %s: %w", network, gateway, err)
+			continue
+		}
+		_, dst, e := net.ParseCIDR(network)
+		if e == nil && (dst.IP.To4() == nil) != (gw.To4() == nil) {
+			e = fmt.Errorf("address family does not match gateway")
+		}
+		if e == nil {
+			e = createIpForwardEntry2(newForwardRow(dst, gw, ifIndex, 1))
+		}
+		if e != nil {
+			errs[i] = fmt.Errorf("add route %s via %s: %w", network, gateway, e)
+		}
+	}
+	return errs
+}
+
+// deleteRoute 删除路由
+func (rm *RouteManager) deleteRoute(ctx context2.Context, network, gateway string) error {
+	ifIndex, gw, err := gatewayInterface(gateway)
+	if err != nil {
+		return fmt.Errorf("delete route %s via %s: %w", network, gateway, err)
+	}
+	_, dst, err := net.ParseCIDR(network)
+	if err != nil {
+		return err
+	}
+	if err = deleteIpForwardEntry2(newForwardRow(dst, gw, ifIndex, 1)); err != nil && !errors.Is(err, windows.ERROR_NOT_FOUND) {
+		return fmt.Errorf("delete route %s via %s: %w", network, gateway, err)
+	}
+	return nil
+}
+
+// gatewayInterface 解析网关地址并查询到达它的出接口，网关带 %接口名时直接使用该接口
+func gatewayInterface(gateway string) (uint32, net.IP, error) {
+	host, zone, _ := strings.Cut(gateway, "%")
+	gw := net.ParseIP(host)
+	if gw == nil {
+		return 0, nil, fmt.Errorf("invalid gateway IP: %s", gateway)
+	}
+	if ip4 := gw.To4(); ip4 != nil {
+		gw = ip4
+	}
+	if zone != "" {
+		iface, err := net.InterfaceByName(zone)
+		if err != nil {
+			return 0, nil, err
+		}
+		return uint32(iface.Index), gw, nil
+	}
+	row, _, err := getBestRoute2(gw)
+	if err != nil {
+		return 0, nil, err
+	}
+	return row.InterfaceIndex, gw, nil
+}
+
+// addMarkRules Windows 没有 fwmark，出站连接靠绑定原接口地址绕过 TUN
+func (rm *RouteManager) addMarkRules(ctx context2.Context) error {
+	return nil
+}
+
+func (rm *RouteManager) deleteMarkRules(ctx context2.Context) {
+}
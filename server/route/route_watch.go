@@ -0,0 +1,184 @@
+package route
+
+import (
+	context2 "context"
+	"net"
+	"strings"
+	"time"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/crash"
+	"proxy/server/proxy/client"
+	"proxy/server/subscription"
+	"proxy/utils/logger"
+)
+
+const (
+	routeWatchInterval    = 5 * time.Second  // 检查经 TUN 的默认路由是否仍在的间隔
+	remoteResolveInterval = 5 * time.Minute  // 重新解析远端服务器地址的间隔（DDNS、故障切换）
+	remoteRetryInterval   = 30 * time.Second // 远端不可达期间重新解析的间隔
+	remoteResolveTimeout  = 5 * time.Second  // 单个地址的解析超时
+)
+
+// startWatch 定期检查经 TUN 的默认路由，被 VPN 客户端、docker 或 DHCP 续约改写删除时重新设置；
+// 同时定期重新解析远端服务器地址，地址变化时更新直连路由
+func (rm *RouteManager) startWatch(ctx context2.Context) {
+	stop, done := make(chan struct{}), make(chan struct{})
+	rm.watchStop, rm.watchDone = stop, done
+	crash.Go(func() {
+		defer close(done)
+		ticker := time.NewTicker(routeWatchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				rm.checkDefaultRoute(ctx)
+				rm.checkRemoteServers(ctx)
+			}
+		}
+	})
+}
+
+// stopWatch 停止检查并等待正在进行的检查结束
+func (rm *RouteManager) stopWatch() {
+	if rm.watchStop == nil {
+		return
+	}
+	close(rm.watchStop)
+	<-rm.watchDone
+	rm.watchStop, rm.watchDone = nil, nil
+}
+
+// checkDefaultRoute 经 TUN 的默认路由（接管 IPv6 时包括 IPv6）消失时重新设置；TUN 接口尚未创建或已关闭时跳过
+func (rm *RouteManager) checkDefaultRoute(ctx context2.Context) {
+	if _, err := net.InterfaceByName(rm.tunInterface); err != nil {
+		return
+	}
+	rm.ensureDefaultRoute(ctx, "0.0.0.0/0", rm.hasDefaultRoute, rm.setDefaultRoute)
+	if rm.originalGateway6 != "" {
+		rm.ensureDefaultRoute(ctx, "::/0", rm.hasDefaultRoute6, rm.setDefaultRoute6)
+	}
+}
+
+func (rm *RouteManager) ensureDefaultRoute(ctx context2.Context, dst string, has func(context2.Context) (bool, error), set func(context2.Context) error) {
+	present, err := has(ctx)
+	if err != nil {
+		logger.WarnAggregated(ctx, "route_watch", map[string]interface{}{
+			"action": config.ActionRuntime,
+			"dst":    dst,
+			"error":  err,
+		}, "failed to check TUN default route")
+		return
+	}
+	if present {
+		return
+	}
+	err = set(ctx)
+	auditRoute("add", dst, rm.tunInterface, err)
+	if err != nil {
+		logger.ErrorAggregated(ctx, "route_watch", map[string]interface{}{
+			"action":    config.ActionRuntime,
+			"errorCode": logger.ErrCodeDefault,
+			"interface": rm.tunInterface,
+			"dst":       dst,
+			"error":     err,
+		}, "TUN default route disappeared, failed to re-install")
+		return
+	}
+	logger.Warn(ctx, map[string]interface{}{
+		"action":    config.ActionRuntime,
+		"interface": rm.tunInterface,
+		"dst":       dst,
+	}, "TUN default route disappeared, re-installed")
+}
+
+// checkRemoteServers 距上次解析超过 remoteResolveInterval 或订阅节点列表有变化时重新解析远端服务器地址；
+// 远端不可达（握手全部失败）时地址可能已经变化，缩短到 remoteRetryInterval
+func (rm *RouteManager) checkRemoteServers(ctx context2.Context) {
+	interval := remoteResolveInterval
+	if client.RemoteDown() {
+		interval = remoteRetryInterval
+	}
+	if time.Since(rm.lastResolve) < interval && subscription.Version() == rm.nodesVersion {
+		return
+	}
+	rm.refreshRemoteServers(ctx)
+}
+
+// refreshRemoteServers 重新解析远端服务器地址：先为新地址添加直连路由，再一次性替换 IsRemoteServerIP 使用的列表，
+// 最后删除旧地址的路由，切换过程中远端连接不会落入 TUN
+func (rm *RouteManager) refreshRemoteServers(ctx context2.Context) {
+	rm.nodesVersion = subscription.Version()
+	rm.remoteIPsMu.RLock()
+	previous, oldIPs := rm.remoteServers, rm.remoteServerIPs
+	rm.remoteIPsMu.RUnlock()
+
+	servers := resolveRemoteServers(ctx, bypassResolver(), rm.lookupNetwork(), previous)
+	newIPs := remoteIPs(servers)
+	added, removed := diffIPs(oldIPs, newIPs), diffIPs(newIPs, oldIPs)
+	if len(added) == 0 && len(removed) == 0 {
+		rm.setRemoteServers(servers)
+		return
+	}
+
+	cidrs := hostRoutes(added)
+	for i, err := range rm.addBypassRoutes(ctx, cidrs) {
+		if err != nil {
+			logger.Warn(ctx, map[string]interface{}{
+				"action": config.ActionRuntime,
+				"cidr":   cidrs[i],
+				"error":  err,
+			}, "failed to add remote server route")
+		}
+	}
+	rm.setRemoteServers(servers)
+	for _, network := range hostRoutes(removed) {
+		gateway := rm.bypassGateway(network)
+		err := rm.deleteRoute(ctx, network, gateway)
+		auditRoute("delete", network, gateway, err)
+		if err != nil {
+			logger.Warn(ctx, map[string]interface{}{
+				"action":  config.ActionRuntime,
+				"network": network,
+				"error":   err,
+			}, "failed to delete route")
+			continue
+		}
+		rm.forgetRoute(network)
+	}
+	rm.saveBackup(ctx)
+
+	logger.Warn(ctx, map[string]interface{}{
+		"action":  config.ActionRuntime,
+		"added":   strings.Join(cidrs, ","),
+		"removed": strings.Join(hostRoutes(removed), ","),
+	}, "remote server address changed, routes updated")
+}
+
+// bypassResolver 经原默认接口（Linux 为 SO_MARK）查询 DNS 的解析器，TUN 接管默认路由后解析远端地址不经过代理
+func bypassResolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(c context2.Context, network, address string) (net.Conn, error) {
+			return common.GetOriginalInterfaceDialer().DialContext(c, network, address)
+		},
+	}
+}
+
+// diffIPs 在 b 中但不在 a 中的 IP
+func diffIPs(a, b []net.IP) []net.IP {
+	seen := make(map[string]bool, len(a))
+	for _, ip := range a {
+		seen[ip.String()] = true
+	}
+	var diff []net.IP
+	for _, ip := range b {
+		if !seen[ip.String()] {
+			diff = append(diff, ip)
+		}
+	}
+	return diff
+}
@@ -13,6 +13,13 @@ import (
 type RuleEngine struct {
 	whiteRules []Rule
 	blackRules []Rule
+	torRules   []Rule
+	dnsAllow   []Rule // dns.guard.allow
+	mitmRules  []Rule // mitm.rules
+	blockAllow []Rule // adblock.allow
+	remoteDNS  []Rule // dns.remote_resolve
+	localDNS   []Rule // dns.local_resolve
+	blocks     *blocklist
 	mu         sync.RWMutex
 }
 
@@ -107,6 +114,12 @@ func NewRuleEngine() *RuleEngine {
 	return &RuleEngine{
 		whiteRules: make([]Rule, 0),
 		blackRules: make([]Rule, 0),
+		torRules:   make([]Rule, 0),
+		dnsAllow:   make([]Rule, 0),
+		mitmRules:  make([]Rule, 0),
+		blockAllow: make([]Rule, 0),
+		remoteDNS:  make([]Rule, 0),
+		localDNS:   make([]Rule, 0),
 	}
 }
 
@@ -118,6 +131,12 @@ func (e *RuleEngine) LoadRules() {
 	// 清空现有规则
 	e.whiteRules = make([]Rule, 0)
 	e.blackRules = make([]Rule, 0)
+	e.torRules = make([]Rule, 0)
+	e.dnsAllow = make([]Rule, 0)
+	e.mitmRules = make([]Rule, 0)
+	e.blockAllow = make([]Rule, 0)
+	e.remoteDNS = make([]Rule, 0)
+	e.localDNS = make([]Rule, 0)
 
 	// 加载白名单规则
 	for _, item := range config.Config.WhiteList {
@@ -132,6 +151,43 @@ func (e *RuleEngine) LoadRules() {
 			e.blackRules = append(e.blackRules, rule)
 		}
 	}
+
+	// 加载 Tor 规则
+	for _, item := range config.Config.Tor.Rules {
+		if rule := parseRule(item); rule != nil {
+			e.torRules = append(e.torRules, rule)
+		}
+	}
+
+	for _, item := range config.Config.DNS.Guard.Allow {
+		if rule := parseRule(item); rule != nil {
+			e.dnsAllow = append(e.dnsAllow, rule)
+		}
+	}
+
+	for _, item := range config.Config.MITM.Rules {
+		if rule := parseRule(item); rule != nil {
+			e.mitmRules = append(e.mitmRules, rule)
+		}
+	}
+
+	for _, item := range config.Config.AdBlock.Allow {
+		if rule := parseRule(item); rule != nil {
+			e.blockAllow = append(e.blockAllow, rule)
+		}
+	}
+
+	for _, item := range config.Config.DNS.RemoteResolve {
+		if rule := parseRule(item); rule != nil {
+			e.remoteDNS = append(e.remoteDNS, rule)
+		}
+	}
+
+	for _, item := range config.Config.DNS.LocalResolve {
+		if rule := parseRule(item); rule != nil {
+			e.localDNS = append(e.localDNS, rule)
+		}
+	}
 }
 
 // ReloadRules 重新加载规则
@@ -141,28 +197,168 @@ func (e *RuleEngine) ReloadRules() {
 
 // IsWhite 检查是否在白名单
 func (e *RuleEngine) IsWhite(target string, ip net.IP) bool {
+	return e.MatchWhite(target, ip) != nil
+}
+
+// IsBlack 检查是否在黑名单
+func (e *RuleEngine) IsBlack(target string, ip net.IP) bool {
+	return e.MatchBlack(target, ip) != nil
+}
+
+// MatchWhite 返回命中的白名单规则，未命中返回 nil
+func (e *RuleEngine) MatchWhite(target string, ip net.IP) Rule {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
 	for _, rule := range e.whiteRules {
 		if rule.Match(target, ip) {
-			return true
+			return rule
 		}
 	}
-	return false
+	return nil
 }
 
-// IsBlack 检查是否在黑名单
-func (e *RuleEngine) IsBlack(target string, ip net.IP) bool {
+// MatchBlack 返回命中的黑名单规则，未命中返回 nil
+func (e *RuleEngine) MatchBlack(target string, ip net.IP) Rule {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
 	for _, rule := range e.blackRules {
 		if rule.Match(target, ip) {
-			return true
+			return rule
+		}
+	}
+	return nil
+}
+
+// MatchTor 返回命中的 Tor 规则，未命中返回 nil
+func (e *RuleEngine) MatchTor(target string, ip net.IP) Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, rule := range e.torRules {
+		if rule.Match(target, ip) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// MatchDNSAllow 返回命中的 dns.guard.allow 规则，未命中返回 nil
+func (e *RuleEngine) MatchDNSAllow(target string, ip net.IP) Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, rule := range e.dnsAllow {
+		if rule.Match(target, ip) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// MatchMITM 返回命中的 mitm.rules 规则，未命中返回 nil
+func (e *RuleEngine) MatchMITM(target string, ip net.IP) Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, rule := range e.mitmRules {
+		if rule.Match(target, ip) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// MatchRemoteResolve 返回命中的 dns.remote_resolve 规则，未命中返回 nil
+func (e *RuleEngine) MatchRemoteResolve(target string, ip net.IP) Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, rule := range e.remoteDNS {
+		if rule.Match(target, ip) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// MatchLocalResolve 返回命中的 dns.local_resolve 规则，未命中返回 nil
+func (e *RuleEngine) MatchLocalResolve(target string, ip net.IP) Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, rule := range e.localDNS {
+		if rule.Match(target, ip) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// MatchBlock 返回拦截目标的 adblock.lists 规则描述，adblock.allow 或列表中的例外规则命中时不拦截，未拦截返回空串；
+// target 为域名或 host:port
+func (e *RuleEngine) MatchBlock(target string, ip net.IP) string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.blocks == nil {
+		return ""
+	}
+	for _, rule := range e.blockAllow {
+		if rule.Match(target, ip) {
+			return ""
+		}
+	}
+	host := target
+	if h, _, err := net.SplitHostPort(target); err == nil {
+		host = h
+	}
+	return e.blocks.match(host)
+}
+
+// setBlocklist 替换拦截列表，nil 表示不拦截
+func (e *RuleEngine) setBlocklist(b *blocklist) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.blocks = b
+}
+
+// ParseRule 解析规则字符串，格式同 white_list/black_list，空串返回 nil
+func ParseRule(ruleStr string) Rule {
+	return parseRule(ruleStr)
+}
+
+// ValidateRule 检查规则字符串格式，parseRule 对无法识别的规则会退化为精确匹配，这里把这类写错的规则找出来
+func ValidateRule(ruleStr string) error {
+	ruleStr = strings.TrimSpace(ruleStr)
+	switch {
+	case ruleStr == "":
+		return fmt.Errorf("empty rule")
+	case strings.ContainsAny(ruleStr, " \t"):
+		return fmt.Errorf("rule contains whitespace")
+	case strings.Contains(ruleStr, "/"):
+		if _, _, err := net.ParseCIDR(ruleStr); err != nil {
+			return fmt.Errorf("invalid CIDR: %s", ruleStr)
+		}
+	case strings.Contains(ruleStr, "*"):
+		if !strings.HasPrefix(ruleStr, "*.") || strings.Count(ruleStr, "*") > 1 {
+			return fmt.Errorf("wildcard must be a leading \"*.\", e.g. *.example.com")
+		}
+	case strings.Contains(ruleStr, "-"):
+		parts := strings.Split(ruleStr, "-")
+		if len(parts) != 2 {
+			return nil
+		}
+		startIP, endIP := net.ParseIP(parts[0]), net.ParseIP(parts[1])
+		if (startIP == nil) != (endIP == nil) {
+			return fmt.Errorf("invalid IP range: %s", ruleStr)
+		}
+		if startIP != nil && compareIP(startIP, endIP) > 0 {
+			return fmt.Errorf("IP range start is after end: %s", ruleStr)
 		}
 	}
-	return false
+	return nil
 }
 
 // parseRule 解析规则字符串
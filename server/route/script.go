@@ -0,0 +1,243 @@
+package route
+
+import (
+	context2 "context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/server/proxy/client"
+	"proxy/utils/context"
+	"proxy/utils/logger"
+	"proxy/utils/script"
+)
+
+// 路由脚本 route(c) 可返回的出口
+const (
+	ScriptDirect = "direct"
+	ScriptProxy  = "proxy"
+	ScriptReject = "reject"
+	ScriptTor    = "tor"
+)
+
+// routeScript 当前生效的路由脚本，未配置 script.file 时为 nil
+var routeScript atomic.Pointer[script.Program]
+
+func init() {
+	config.RegisterLoadCallback(func() {
+		// check 子命令由 diagnose 编译检查脚本
+		if config.CheckMode() {
+			return
+		}
+		loadScript()
+		config.RegisterReloadCallback(loadScript)
+	})
+}
+
+// loadScript 按 script.file 加载路由脚本；重载时脚本有错误则继续使用之前的脚本
+func loadScript() {
+	file := config.Config.Script.File
+	if file == "" {
+		routeScript.Store(nil)
+		return
+	}
+	ctx := context.NewContext()
+	p, err := CompileScript(file)
+	if err != nil {
+		logger.Error(ctx, map[string]interface{}{
+			"action":    config.ActionRuntime,
+			"errorCode": logger.ErrCodeDefault,
+			"error":     err,
+			"file":      file,
+		}, "load route script failed")
+		return
+	}
+	routeScript.Store(p)
+	logger.Info(ctx, map[string]interface{}{
+		"action": config.ActionRuntime,
+		"file":   file,
+	}, "route script loaded")
+}
+
+// CompileScript 编译路由脚本，脚本需定义 route 函数
+func CompileScript(file string) (*script.Program, error) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	p, err := script.Compile(file, string(src), scriptBuiltins)
+	if err != nil {
+		return nil, err
+	}
+	if !p.Has("route") {
+		return nil, fmt.Errorf("%s: function route(c) is not defined", file)
+	}
+	return p, nil
+}
+
+// scriptBuiltins 提供给路由脚本的函数
+var scriptBuiltins = starlark.StringDict{
+	// match(value, rule) 按 white_list 的规则格式匹配域名或 IP
+	"match": starlark.NewBuiltin("match", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var value, pattern string
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "value", &value, "rule", &pattern); err != nil {
+			return nil, err
+		}
+		if err := ValidateRule(pattern); err != nil {
+			return nil, err
+		}
+		return starlark.Bool(parseRule(pattern).Match(value, net.ParseIP(value))), nil
+	}),
+}
+
+// scriptRejectedRemote 路由脚本返回 reject 的连接使用的出口，握手直接失败
+type scriptRejectedRemote struct{}
+
+func (r *scriptRejectedRemote) Handshake(ctx context2.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+	return nil, errors.New("rejected by route script")
+}
+
+func (r *scriptRejectedRemote) Name() string {
+	return "ScriptRejected"
+}
+
+// runScript 调用路由脚本的 route(c)：返回出口时给出决策；返回 {"target": "host[:port]"} 时改写 target，
+// 返回值为改写的描述。脚本出错时记录日志并交给其他规则
+func runScript(ctx context2.Context, target *common.TargetAddr) (*Decision, string) {
+	p := routeScript.Load()
+	if p == nil {
+		return nil, ""
+	}
+	result, err := p.Call("route", scriptInput(ctx, target))
+	var decision *Decision
+	var rewrite string
+	if err == nil {
+		decision, rewrite, err = applyScriptResult(target, result)
+	}
+	if err != nil {
+		logger.ErrorAggregated(ctx, "script:route", map[string]interface{}{
+			"action":    config.ActionSocketOperate,
+			"errorCode": logger.ErrCodeDefault,
+			"error":     err,
+			"target":    target.String(),
+		}, "route script failed, fall back to the rules")
+		return nil, ""
+	}
+	return decision, rewrite
+}
+
+// scriptInput 传给 route 的参数；ip 仅在目标本身是 IP 时可知（脚本在 DNS 解析之前执行），
+// geo 为 private、CN 或 foreign，未知时为空；暂无法获取发起连接的进程，process 始终为空
+func scriptInput(ctx context2.Context, target *common.TargetAddr) *starlarkstruct.Struct {
+	c := starlark.StringDict{
+		"domain":  starlark.String(target.Name),
+		"ip":      starlark.String(""),
+		"port":    starlark.MakeInt(target.Port),
+		"network": starlark.String("tcp"),
+		"process": starlark.String(""),
+		"geo":     starlark.String(""),
+	}
+	if target.Proto == 3 {
+		c["network"] = starlark.String("udp")
+	}
+	if ip := target.IP; ip != nil {
+		c["ip"] = starlark.String(ip.String())
+		switch {
+		case ip.IsLoopback() || ip.IsPrivate():
+			c["geo"] = starlark.String("private")
+		case IsCnIp(ctx, ip.String()):
+			c["geo"] = starlark.String("CN")
+		default:
+			c["geo"] = starlark.String("foreign")
+		}
+	}
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, c)
+}
+
+// applyScriptResult 解析 route 的返回值：None、出口名称，或包含 action 与 target 的字典
+func applyScriptResult(target *common.TargetAddr, result starlark.Value) (*Decision, string, error) {
+	var action, addr string
+	switch v := result.(type) {
+	case starlark.NoneType:
+		return nil, "", nil
+	case starlark.String:
+		action = string(v)
+	case *starlark.Dict:
+		for _, field := range []struct {
+			name string
+			dst  *string
+		}{{"action", &action}, {"target", &addr}} {
+			value, ok, _ := v.Get(starlark.String(field.name))
+			if !ok || value == starlark.None {
+				continue
+			}
+			if *field.dst, ok = starlark.AsString(value); !ok {
+				return nil, "", fmt.Errorf("route() %s must be a string, got %s", field.name, value.Type())
+			}
+		}
+	default:
+		return nil, "", fmt.Errorf("route() must return None, a string or a dict, got %s", result.Type())
+	}
+	if action == "" && addr == "" {
+		return nil, "", fmt.Errorf("route() returned neither an action nor a target")
+	}
+	var d *Decision
+	if action != "" {
+		d = &Decision{Reason: ReasonScript, Rule: "route() = " + action}
+		switch action {
+		case ScriptDirect:
+			d.Remote = &client.DirectRemote{}
+		case ScriptProxy:
+			d.Remote = ProxyRemote()
+		case ScriptReject:
+			d.Remote = &scriptRejectedRemote{}
+		case ScriptTor:
+			d.Remote = &client.TorRemote{}
+		default:
+			return nil, "", fmt.Errorf("route() returned unknown action %q", action)
+		}
+	}
+	if addr == "" {
+		d.IP = target.IP
+		return d, "", nil
+	}
+	rewrite, err := rewriteTarget(target, addr)
+	if err != nil {
+		return nil, "", err
+	}
+	if d != nil {
+		d.IP = target.IP
+	}
+	return d, rewrite, nil
+}
+
+// rewriteTarget 把 target 改为 addr（host 或 host:port，省略端口时沿用原端口），返回改写描述
+func rewriteTarget(target *common.TargetAddr, addr string) (string, error) {
+	host, port := addr, target.Port
+	if h, p, err := net.SplitHostPort(addr); err == nil {
+		n, err := strconv.Atoi(p)
+		if err != nil || n <= 0 || n > 65535 {
+			return "", fmt.Errorf("route() target %q has an invalid port", addr)
+		}
+		host, port = h, n
+	}
+	if host == "" {
+		return "", fmt.Errorf("route() target %q has no host", addr)
+	}
+	from := target.String()
+	if ip := net.ParseIP(host); ip != nil {
+		target.Name, target.IP = "", ip
+	} else {
+		target.Name, target.IP = host, nil
+	}
+	target.Port = port
+	return from + " -> " + target.String(), nil
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+
+	"proxy/config"
+	"proxy/server/admin"
+	"proxy/server/common"
+	"proxy/server/doh"
+	"proxy/server/proxy/client"
+	"proxy/server/proxy/server"
+	"proxy/server/route"
+	"proxy/server/tun"
+	"proxy/utils/context"
+)
+
+var (
+	tunService *tun.Service
+	toggleMu   sync.Mutex // 保护 TUN / 系统代理的运行时开关
+)
+
+// registerToggles 在管理接口中注册 TUN 与系统代理开关
+func registerToggles() {
+	admin.RegisterToggle("tun", admin.Toggle{
+		Get: func() bool {
+			toggleMu.Lock()
+			defer toggleMu.Unlock()
+			return tunService != nil
+		},
+		Set: setTun,
+	})
+	admin.RegisterToggle("system_proxy", admin.Toggle{
+		Get: func() bool {
+			toggleMu.Lock()
+			defer toggleMu.Unlock()
+			return config.SystemProxyEnabled()
+		},
+		Set: setSystemProxy,
+	})
+}
+
+// registerProbes 在管理接口中注册 /healthz 与 /readyz 的检查项
+func registerProbes() {
+	admin.RegisterProbe("listener", admin.Probe{Live: true, Check: func() (bool, string) {
+		listenMu.Lock()
+		defer listenMu.Unlock()
+		if listener == nil {
+			return false, "not listening"
+		}
+		return true, listenAddr
+	}})
+	admin.RegisterProbe("tun", admin.Probe{Live: true, Check: func() (bool, string) {
+		toggleMu.Lock()
+		defer toggleMu.Unlock()
+		switch {
+		case !config.TunEnabled():
+			return true, "disabled"
+		case tunService.Running():
+			return true, "running"
+		default:
+			return false, "enabled but not running"
+		}
+	}})
+	admin.RegisterProbe("remote", admin.Probe{Check: func() (bool, string) {
+		if route.TunnelRemote() == nil {
+			return true, "not used"
+		}
+		if client.RemoteDown() {
+			return false, "out.remote_addr is unreachable"
+		}
+		return true, "reachable"
+	}})
+	admin.RegisterProbe("doh", admin.Probe{Check: func() (bool, string) {
+		success, failure, lastErr := doh.Status()
+		switch {
+		case failure.After(success):
+			return false, fmt.Sprintf("last query failed at %s: %s", failure.In(config.CstZone).Format(config.TimeFormat), lastErr)
+		case success.IsZero():
+			return true, "no queries yet"
+		default:
+			return true, "last success at " + success.In(config.CstZone).Format(config.TimeFormat)
+		}
+	}})
+}
+
+// setTun 运行时启停 TUN 服务
+func setTun(enable bool) error {
+	toggleMu.Lock()
+	defer toggleMu.Unlock()
+	config.SetTunEnabled(enable)
+	err := reloadTun(context.NewContext())
+	if err != nil {
+		config.SetTunEnabled(tunService != nil)
+	}
+	return err
+}
+
+// setSystemProxy 运行时设置/恢复系统代理
+func setSystemProxy(enable bool) error {
+	toggleMu.Lock()
+	defer toggleMu.Unlock()
+	config.SetSystemProxyEnabled(enable)
+	reloadSystemProxy(context.NewContext())
+	return nil
+}
+
+// NewServer 按 in.type 创建入口服务
+func NewServer() common.Server {
+	switch config.Config.In.Type {
+	case config.ServerTypeSocket:
+		return &server.SocketServer{
+			Type:     config.Config.In.Type,
+			Port:     config.Config.In.Port,
+			UserName: "",
+			Password: "",
+		}
+	case config.ServerTypeHttp:
+		return &server.HttpServer{
+			Type:     config.Config.In.Type,
+			Port:     config.Config.In.Port,
+			UserName: "",
+			Password: "",
+		}
+	case config.ServerTypeTLS:
+		return &server.TlsServer{
+			Type:     config.Config.In.Type,
+			Port:     config.Config.In.Port,
+			UserName: "",
+		}
+	case config.ServerTypeWSS:
+		return &server.WSSServer{
+			Type:     config.Config.In.Type,
+			Port:     config.Config.In.Port,
+			UserName: "",
+		}
+	case config.ServerTypeQUIC:
+		return &server.QuicServer{
+			Type:     config.Config.In.Type,
+			Port:     config.Config.In.Port,
+			UserName: "",
+		}
+	case config.ServerTypeKCP:
+		return &server.KcpServer{
+			QuicServer: server.QuicServer{
+				Type: config.Config.In.Type,
+				Port: config.Config.In.Port,
+			},
+		}
+	case config.ServerTypeGRPC:
+		return &server.GRPCServer{
+			Type:     config.Config.In.Type,
+			Port:     config.Config.In.Port,
+			UserName: "",
+		}
+	case config.ServerTypeSocksTLS:
+		return &server.SocksTLSServer{
+			SocketServer: server.SocketServer{
+				Type: config.Config.In.Type,
+				Port: config.Config.In.Port,
+			},
+		}
+	case config.ServerTypeMixed:
+		return &server.MixedServer{
+			Type: config.Config.In.Type,
+			Port: config.Config.In.Port,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	context2 "context"
+	"os"
+	"strconv"
+	"strings"
+
+	"proxy/config"
+	"proxy/server/route"
+	"proxy/server/systemproxy"
+	"proxy/server/tun"
+	"proxy/utils/helper"
+	"proxy/utils/logger"
+)
+
+// runMarkerFile 运行标记（可执行文件所在目录），内容为运行中进程的 pid，正常退出时删除
+const runMarkerFile = "clt.running"
+
+// recoverStaleState 启动时检查上次运行的遗留：标记中的进程仍在运行（如平滑重启时的旧进程）时不做清理，
+// 否则按备份删除遗留的路由与网关模式的防火墙规则，恢复系统 DNS 与系统代理，再写入本进程的运行标记
+func recoverStaleState(ctx context2.Context) {
+	path, err := helper.ExeFilePath(runMarkerFile)
+	if err != nil {
+		return
+	}
+	if buf, err := os.ReadFile(path); err == nil {
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(buf)))
+		if pid != os.Getpid() && helper.ProcessAlive(pid) {
+			logger.Info(ctx, map[string]interface{}{
+				"action": config.ActionRuntime,
+				"pid":    pid,
+			}, "previous instance is still running, skip stale state cleanup")
+			writeRunMarker(ctx, path)
+			return
+		}
+	}
+	route.CleanupStaleRoutes(ctx)
+	tun.CleanupStaleGateway(ctx)
+	tun.CleanupStaleDNS(ctx)
+	systemproxy.RecoverStale(ctx)
+	writeRunMarker(ctx, path)
+}
+
+// emergencyRestore 崩溃退出前停止 TUN（删除其路由）并恢复系统代理；
+// panic 的协程可能正持有 toggleMu，取不到锁时直接执行
+func emergencyRestore(ctx context2.Context) {
+	if toggleMu.TryLock() {
+		defer toggleMu.Unlock()
+	}
+	if tunService != nil {
+		tunService.Stop()
+		tunService, tunApplied = nil, nil
+	}
+	if proxyPort != 0 {
+		systemproxy.Restore(ctx)
+		proxyPort = 0
+	}
+	removeRunMarker()
+}
+
+func writeRunMarker(ctx context2.Context, path string) {
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		logger.Warn(ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"file":   path,
+			"error":  err,
+		}, "write run marker failed")
+	}
+}
+
+// removeRunMarker 删除运行标记；平滑重启后标记已由新进程改写，此时保留
+func removeRunMarker() {
+	path, err := helper.ExeFilePath(runMarkerFile)
+	if err != nil {
+		return
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil || strings.TrimSpace(string(buf)) != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	_ = os.Remove(path)
+}
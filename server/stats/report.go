@@ -0,0 +1,117 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"proxy/config"
+	"proxy/utils/helper"
+)
+
+// Item 一个出口或域名的流量
+type Item struct {
+	Name string `json:"name"`
+	Bytes
+}
+
+// DayReport 一天的合计与各出口流量
+type DayReport struct {
+	Day string `json:"day"`
+	Bytes
+	Remotes []Item `json:"remotes"`
+}
+
+// Report 最近若干天（含今天）的流量报表
+type Report struct {
+	From    string      `json:"from"`
+	To      string      `json:"to"`
+	Total   Bytes       `json:"total"`
+	Days    []DayReport `json:"days"`    // 按日期升序，没有流量的日期不列出
+	Remotes []Item      `json:"remotes"` // 期间各出口合计，按流量降序
+	Domains []Item      `json:"domains"` // 期间流量最多的 top 个域名
+}
+
+// BuildReport 按内存中的统计生成最近 n 天的报表，列出流量最多的 top 个域名
+func BuildReport(n, top int) Report {
+	mu.Lock()
+	defer mu.Unlock()
+	return buildReport(days, n, top)
+}
+
+// ReadReport 按持久化文件生成报表，供不连接运行中进程的 stats 子命令使用
+func ReadReport(file string, n, top int) (Report, error) {
+	loaded, err := read(file)
+	if err != nil {
+		return Report{}, err
+	}
+	return buildReport(loaded, n, top), nil
+}
+
+func buildReport(src map[string]*day, n, top int) Report {
+	now := time.Now().In(config.CstZone)
+	r := Report{
+		From:    now.AddDate(0, 0, 1-n).Format(dayFormat),
+		To:      now.Format(dayFormat),
+		Days:    make([]DayReport, 0),
+		Remotes: make([]Item, 0),
+		Domains: make([]Item, 0),
+	}
+	remotes := make(map[string]*Bytes)
+	domains := make(map[string]*Bytes)
+	for _, key := range sortedKeys(src) {
+		if key < r.From || key > r.To {
+			continue
+		}
+		d := src[key]
+		r.Total.add(d.Total.Up, d.Total.Down)
+		r.Days = append(r.Days, DayReport{Day: key, Bytes: d.Total, Remotes: items(d.Remotes, 0)})
+		for name, b := range d.Remotes {
+			entry(remotes, name).add(b.Up, b.Down)
+		}
+		for host, b := range d.Domains {
+			entry(domains, host).add(b.Up, b.Down)
+		}
+	}
+	r.Remotes = items(remotes, 0)
+	r.Domains = items(domains, top)
+	return r
+}
+
+// items 按流量降序排列，limit 大于 0 时只保留前 limit 个
+func items(m map[string]*Bytes, limit int) []Item {
+	list := make([]Item, 0, len(m))
+	for name, b := range m {
+		list = append(list, Item{Name: name, Bytes: *b})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Total() != list[j].Total() {
+			return list[i].Total() > list[j].Total()
+		}
+		return list[i].Name < list[j].Name
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
+
+// Print 以文本表格输出报表
+func (r Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "traffic %s ~ %s  total: %s (up %s, down %s)\n\n", r.From, r.To,
+		helper.FormatBytes(r.Total.Total()), helper.FormatBytes(r.Total.Up), helper.FormatBytes(r.Total.Down))
+	fmt.Fprintf(w, "%-12s %-12s %-12s %s\n", "day", "up", "down", "total")
+	for _, d := range r.Days {
+		fmt.Fprintf(w, "%-12s %-12s %-12s %s\n", d.Day, helper.FormatBytes(d.Up), helper.FormatBytes(d.Down), helper.FormatBytes(d.Total()))
+	}
+	printItems(w, "remote", r.Remotes)
+	printItems(w, "domain", r.Domains)
+}
+
+func printItems(w io.Writer, title string, list []Item) {
+	fmt.Fprintf(w, "\n%-40s %-12s %-12s %s\n", title, "up", "down", "total")
+	for _, item := range list {
+		fmt.Fprintf(w, "%-40s %-12s %-12s %s\n", item.Name, helper.FormatBytes(item.Up), helper.FormatBytes(item.Down), helper.FormatBytes(item.Total()))
+	}
+}
@@ -0,0 +1,277 @@
+// Package stats 按天、出口与目标域名统计转发的字节数，定期持久化到磁盘，供管理接口与 stats 子命令生成流量报表
+package stats
+
+import (
+	context2 "context"
+	"encoding/json"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"proxy/config"
+	"proxy/server/hooks"
+	"proxy/utils/logger"
+)
+
+const (
+	defaultDays    = 90
+	defaultDomains = 200
+	dayFormat      = "2006-01-02"
+	saveInterval   = time.Minute
+)
+
+// Bytes 上下行字节数
+type Bytes struct {
+	Up   int64 `json:"up_bytes"`
+	Down int64 `json:"down_bytes"`
+}
+
+func (b *Bytes) add(up, down int64) {
+	b.Up += up
+	b.Down += down
+}
+
+// Total 上下行合计
+func (b Bytes) Total() int64 {
+	return b.Up + b.Down
+}
+
+// day 一天的统计，也是持久化文件中每天的格式
+type day struct {
+	Total   Bytes             `json:"total"`
+	Remotes map[string]*Bytes `json:"remotes"`
+	Domains map[string]*Bytes `json:"domains"`
+}
+
+func newDay() *day {
+	return &day{Remotes: make(map[string]*Bytes), Domains: make(map[string]*Bytes)}
+}
+
+var (
+	mu    sync.Mutex
+	days  = make(map[string]*day)
+	dirty bool
+
+	saveMu   sync.Mutex
+	stopSave chan struct{} // 关闭时停止定期写回，未在写回时为 nil
+)
+
+func init() {
+	hooks.OnClose(record)
+}
+
+// record 连接结束时把流量计入结束当天
+func record(e hooks.ConnEvent) {
+	if e.Up == 0 && e.Down == 0 {
+		return
+	}
+	host := e.Target
+	if h, _, err := net.SplitHostPort(e.Target); err == nil {
+		host = h
+	}
+	key := today()
+	mu.Lock()
+	defer mu.Unlock()
+	d, ok := days[key]
+	if !ok {
+		d = newDay()
+		days[key] = d
+		prune()
+	}
+	d.Total.add(e.Up, e.Down)
+	entry(d.Remotes, e.Remote).add(e.Up, e.Down)
+	if _, ok := d.Domains[host]; !ok && len(d.Domains) >= maxDomains() {
+		evictSmallest(d.Domains)
+	}
+	entry(d.Domains, host).add(e.Up, e.Down)
+	dirty = true
+}
+
+func entry(m map[string]*Bytes, key string) *Bytes {
+	b, ok := m[key]
+	if !ok {
+		b = &Bytes{}
+		m[key] = b
+	}
+	return b
+}
+
+// evictSmallest 淘汰流量最少的域名
+func evictSmallest(m map[string]*Bytes) {
+	var victim string
+	var smallest int64 = -1
+	for host, b := range m {
+		if total := b.Total(); smallest < 0 || total < smallest {
+			victim, smallest = host, total
+		}
+	}
+	delete(m, victim)
+}
+
+// prune 删除超出 stats.days 的记录，调用方需持有 mu
+func prune() {
+	oldest := time.Now().In(config.CstZone).AddDate(0, 0, 1-keepDays()).Format(dayFormat)
+	for key := range days {
+		if key < oldest {
+			delete(days, key)
+		}
+	}
+}
+
+// Start 读取持久化的统计，并定期写回 stats.file
+func Start(ctx context2.Context) {
+	if file := config.Config.Stats.File; file != "" {
+		if err := Load(file); err != nil && !os.IsNotExist(err) {
+			logger.Error(ctx, map[string]interface{}{
+				"action":    config.ActionRuntime,
+				"errorCode": logger.ErrCodeDefault,
+				"error":     err,
+				"file":      file,
+			}, "load traffic stats failed")
+		}
+	}
+	Resume(ctx)
+}
+
+// Resume 开始定期写回 stats.file，已在写回时不做处理；平滑重启失败后由此恢复
+func Resume(ctx context2.Context) {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+	if stopSave != nil {
+		return
+	}
+	stop := make(chan struct{})
+	stopSave = stop
+	go func() {
+		ticker := time.NewTicker(saveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := Save(); err != nil {
+				logger.Error(ctx, map[string]interface{}{
+					"action":    config.ActionRuntime,
+					"errorCode": logger.ErrCodeDefault,
+					"error":     err,
+					"file":      config.Config.Stats.File,
+				}, "save traffic stats failed")
+			}
+		}
+	}()
+}
+
+// Stop 停止定期写回并最后写入一次统计，平滑重启交出监听前调用，之后统计文件由新进程写入
+func Stop() error {
+	saveMu.Lock()
+	if stopSave != nil {
+		close(stopSave)
+		stopSave = nil
+	}
+	saveMu.Unlock()
+	return Save()
+}
+
+// Save 有新的流量时写入 stats.file，未配置时不写
+func Save() error {
+	file := config.Config.Stats.File
+	if file == "" {
+		return nil
+	}
+	mu.Lock()
+	if !dirty {
+		mu.Unlock()
+		return nil
+	}
+	buf, err := json.MarshalIndent(days, "", "  ")
+	dirty = false
+	mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Load 读取 file 中的统计，与内存中同一天的记录合并
+func Load(file string) error {
+	loaded, err := read(file)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for key, ld := range loaded {
+		d, ok := days[key]
+		if !ok {
+			days[key] = ld
+			continue
+		}
+		d.Total.add(ld.Total.Up, ld.Total.Down)
+		for name, b := range ld.Remotes {
+			entry(d.Remotes, name).add(b.Up, b.Down)
+		}
+		for host, b := range ld.Domains {
+			entry(d.Domains, host).add(b.Up, b.Down)
+		}
+	}
+	prune()
+	return nil
+}
+
+func read(file string) (map[string]*day, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	loaded := make(map[string]*day)
+	if err := json.Unmarshal(buf, &loaded); err != nil {
+		return nil, err
+	}
+	for _, d := range loaded {
+		if d.Remotes == nil {
+			d.Remotes = make(map[string]*Bytes)
+		}
+		if d.Domains == nil {
+			d.Domains = make(map[string]*Bytes)
+		}
+	}
+	return loaded, nil
+}
+
+func today() string {
+	return time.Now().In(config.CstZone).Format(dayFormat)
+}
+
+func keepDays() int {
+	if n := config.Config.Stats.Days; n > 0 {
+		return n
+	}
+	return defaultDays
+}
+
+func maxDomains() int {
+	if n := config.Config.Stats.Domains; n > 0 {
+		return n
+	}
+	return defaultDomains
+}
+
+// sortedKeys 按字典序排列的键
+func sortedKeys(m map[string]*day) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
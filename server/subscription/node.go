@@ -0,0 +1,280 @@
+package subscription
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/xjasonlyu/tun2socks/v2/transport/shadowsocks/core"
+)
+
+const (
+	ProtocolShadowsocks = "shadowsocks"
+	ProtocolTrojan      = "trojan"
+	ProtocolVMess       = "vmess"
+)
+
+// Node 从分享链接解析出的出口节点
+type Node struct {
+	Name     string `json:"name"`
+	Protocol string `json:"protocol"`
+	Server   string `json:"server"`
+	Port     int    `json:"port"`
+	Method   string `json:"method,omitempty"` // Shadowsocks 加密方式
+	Password string `json:"-"`                // Shadowsocks/Trojan 密码，VMess 为用户 ID
+	SNI      string `json:"sni,omitempty"`
+	Insecure bool   `json:"insecure,omitempty"` // 跳过证书校验（allowInsecure）
+	Network  string `json:"network,omitempty"`  // 传输方式，目前只支持 tcp
+	Plugin   string `json:"plugin,omitempty"`   // Shadowsocks 插件，只支持 simple-obfs（obfs-local）
+	Opts     string `json:"plugin_opts,omitempty"`
+	Source   string `json:"source"` // 来源：links 或 urls[i]
+}
+
+// Addr 节点地址 host:port
+func (n *Node) Addr() string {
+	return net.JoinHostPort(n.Server, strconv.Itoa(n.Port))
+}
+
+// Supported 节点能否作为出口使用，不能时返回原因
+func (n *Node) Supported() error {
+	switch n.Protocol {
+	case ProtocolShadowsocks:
+		if _, err := core.PickCipher(n.Method, nil, n.Password); err != nil {
+			return fmt.Errorf("shadowsocks method %q: %w", n.Method, err)
+		}
+		if mode, _ := n.Obfs(); n.Plugin != "" && mode == "" {
+			return fmt.Errorf("shadowsocks plugin %q is not supported", n.Plugin)
+		}
+		return nil
+	case ProtocolTrojan:
+		if n.Network != "" && n.Network != "tcp" {
+			return fmt.Errorf("trojan transport %q is not supported", n.Network)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%s outbound is not supported", n.Protocol)
+	}
+}
+
+// Obfs 返回 simple-obfs 插件的混淆方式（http / tls）与伪装域名，未使用该插件时 mode 为空
+func (n *Node) Obfs() (mode, host string) {
+	if n.Plugin != "obfs-local" && n.Plugin != "simple-obfs" {
+		return "", ""
+	}
+	for _, opt := range strings.Split(n.Opts, ";") {
+		key, value, _ := strings.Cut(opt, "=")
+		switch key {
+		case "obfs":
+			mode = value
+		case "obfs-host":
+			host = value
+		}
+	}
+	if mode != "http" && mode != "tls" {
+		return "", ""
+	}
+	return mode, host
+}
+
+// Parse 解析一条分享链接：ss://、trojan://、vmess://
+func Parse(link string) (*Node, error) {
+	link = strings.TrimSpace(link)
+	scheme, _, ok := strings.Cut(link, "://")
+	if !ok {
+		return nil, fmt.Errorf("not a share link")
+	}
+	var (
+		n   *Node
+		err error
+	)
+	switch strings.ToLower(scheme) {
+	case "ss":
+		n, err = parseShadowsocks(link)
+	case "trojan":
+		n, err = parseTrojan(link)
+	case "vmess":
+		n, err = parseVMess(link)
+	default:
+		return nil, fmt.Errorf("unknown scheme %q", scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s link: %w", scheme, err)
+	}
+	if n.Server == "" || n.Port <= 0 || n.Port > 65535 {
+		return nil, fmt.Errorf("%s link: invalid server address", scheme)
+	}
+	if n.Name == "" {
+		n.Name = n.Addr()
+	}
+	return n, nil
+}
+
+// ParseList 解析订阅内容：base64 编码或明文的分享链接，每行一条；无法解析的行返回在 errs 中
+func ParseList(content string) (nodes []*Node, errs []error) {
+	content = strings.TrimSpace(content)
+	if !strings.Contains(content, "://") {
+		decoded, err := decodeBase64(content)
+		if err != nil {
+			return nil, []error{fmt.Errorf("subscription content is neither share links nor base64")}
+		}
+		content = string(decoded)
+	}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		n, err := Parse(line)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, errs
+}
+
+// parseShadowsocks 支持 SIP002 ss://base64(method:password)@host:port/?plugin=...#name
+// 与旧格式 ss://base64(method:password@host:port)#name
+func parseShadowsocks(link string) (*Node, error) {
+	_, rest, _ := strings.Cut(link, "://")
+	body, name := splitFragment(rest)
+	n := &Node{Protocol: ProtocolShadowsocks, Name: name}
+	var userinfo, hostport string
+	if at := strings.LastIndex(body, "@"); at >= 0 {
+		if i := strings.IndexAny(body[at:], "/?"); i >= 0 {
+			query, _ := url.ParseQuery(strings.TrimLeft(body[at+i:], "/?"))
+			n.Plugin, n.Opts, _ = strings.Cut(query.Get("plugin"), ";")
+			body = body[:at+i]
+		}
+		userinfo, hostport = body[:at], body[at+1:]
+		if decoded, err := decodeBase64(userinfo); err == nil {
+			userinfo = string(decoded)
+		} else if unescaped, err := url.PathUnescape(userinfo); err == nil {
+			userinfo = unescaped
+		}
+	} else {
+		decoded, err := decodeBase64(body)
+		if err != nil {
+			return nil, err
+		}
+		at = strings.LastIndex(string(decoded), "@")
+		if at < 0 {
+			return nil, fmt.Errorf("missing server address")
+		}
+		userinfo, hostport = string(decoded[:at]), string(decoded[at+1:])
+	}
+	method, password, ok := strings.Cut(userinfo, ":")
+	if !ok {
+		return nil, fmt.Errorf("missing method or password")
+	}
+	n.Method, n.Password = strings.ToLower(method), password
+	if err := n.setAddr(hostport); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// parseTrojan trojan://password@host:port?sni=...&allowInsecure=1&type=tcp#name
+func parseTrojan(link string) (*Node, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("missing password")
+	}
+	n := &Node{
+		Protocol: ProtocolTrojan,
+		Name:     u.Fragment,
+		Password: u.User.Username(),
+		Network:  u.Query().Get("type"),
+		SNI:      u.Query().Get("sni"),
+	}
+	if n.SNI == "" {
+		n.SNI = u.Query().Get("peer")
+	}
+	n.Insecure = parseBool(u.Query().Get("allowInsecure"))
+	if err := n.setAddr(u.Host); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// parseVMess vmess://base64(json)，JSON 字段沿用 v2rayN 的分享格式
+func parseVMess(link string) (*Node, error) {
+	_, rest, _ := strings.Cut(link, "://")
+	decoded, err := decodeBase64(rest)
+	if err != nil {
+		return nil, err
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal(decoded, &v); err != nil {
+		return nil, err
+	}
+	field := func(key string) string {
+		if value, ok := v[key]; ok && value != nil {
+			return fmt.Sprint(value)
+		}
+		return ""
+	}
+	port, err := strconv.Atoi(field("port"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", field("port"))
+	}
+	n := &Node{
+		Protocol: ProtocolVMess,
+		Name:     field("ps"),
+		Server:   field("add"),
+		Port:     port,
+		Password: field("id"),
+		Network:  field("net"),
+		SNI:      field("sni"),
+	}
+	return n, nil
+}
+
+// setAddr 解析 host:port，兼容带方括号的 IPv6
+func (n *Node) setAddr(hostport string) error {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return err
+	}
+	n.Server = host
+	n.Port, err = strconv.Atoi(port)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// splitFragment 拆出 #name 部分并做 URL 解码
+func splitFragment(s string) (string, string) {
+	body, fragment, _ := strings.Cut(s, "#")
+	if name, err := url.PathUnescape(fragment); err == nil {
+		fragment = name
+	}
+	return body, strings.TrimSpace(fragment)
+}
+
+// decodeBase64 依次尝试标准、URL 安全以及不带填充的 base64
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.Join(strings.Fields(s), "")
+	var err error
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		var decoded []byte
+		if decoded, err = enc.DecodeString(s); err == nil {
+			return decoded, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid base64: %w", err)
+}
+
+func parseBool(s string) bool {
+	b, _ := strconv.ParseBool(s)
+	return b
+}
@@ -0,0 +1,281 @@
+// Package subscription 解析分享链接与订阅地址得到出口节点列表，定期刷新订阅，并记录当前选中的节点
+package subscription
+
+import (
+	context2 "context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"proxy/config"
+	"proxy/server/common"
+	"proxy/utils/context"
+	"proxy/utils/logger"
+)
+
+const (
+	defaultInterval = time.Hour
+	maxContentSize  = 4 << 20 // 订阅内容上限
+)
+
+// ErrNoNode 没有可用的节点
+var ErrNoNode = errors.New("no usable subscription node")
+
+var (
+	mu       sync.RWMutex
+	links    []*Node             // subscription.links 中的节点
+	fetched  = map[int][]*Node{} // 各订阅地址最近一次成功拉取的节点，键为 urls 下标
+	selected string              // 当前选中的节点名，为空时使用第一个可用节点
+	version  atomic.Uint64       // 节点列表的版本号，links 重新解析或订阅拉取成功时递增
+
+	refreshMu    sync.Mutex
+	refreshCtx   context2.Context // Start 传入的 ctx，为 nil 时未启动定期刷新
+	refreshStop  chan struct{}    // 关闭时停止当前的定期刷新，没有订阅地址时为 nil
+	refreshURLs  []string         // 当前定期刷新的订阅地址
+	refreshEvery time.Duration    // 当前定期刷新的间隔
+)
+
+func init() {
+	config.RegisterLoadCallback(loadLinks)
+	config.RegisterReloadCallback(loadLinks)
+	config.RegisterReloadCallback(reschedule)
+}
+
+// loadLinks 按配置重新解析 links，订阅地址的节点保留到下次刷新
+func loadLinks() {
+	ctx := context.NewContext()
+	list := make([]*Node, 0, len(config.Config.Subscription.Links))
+	for _, link := range config.Config.Subscription.Links {
+		n, err := Parse(link)
+		if err != nil {
+			logger.Error(ctx, map[string]interface{}{
+				"action":    config.ActionRuntime,
+				"errorCode": logger.ErrCodeDefault,
+				"error":     err,
+			}, "invalid share link, ignored")
+			continue
+		}
+		n.Source = "links"
+		list = append(list, n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	links = list
+	selected = config.Config.Subscription.Node
+	for i := range fetched {
+		if i >= len(config.Config.Subscription.URLs) {
+			delete(fetched, i)
+		}
+	}
+	version.Add(1)
+}
+
+// Version 节点列表的版本号，节点可能变化时递增，TUN 据此重新为节点地址添加直连路由
+func Version() uint64 {
+	return version.Load()
+}
+
+// Nodes 返回全部节点，links 中的在前，订阅地址的按顺序在后
+func Nodes() []*Node {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := append([]*Node(nil), links...)
+	for i := 0; i < len(config.Config.Subscription.URLs); i++ {
+		list = append(list, fetched[i]...)
+	}
+	return list
+}
+
+// Selected 返回当前使用的节点：选中的节点可用时用它，否则用第一个可用节点
+func Selected() (*Node, error) {
+	mu.RLock()
+	name := selected
+	mu.RUnlock()
+	var first *Node
+	for _, n := range Nodes() {
+		if n.Supported() != nil {
+			continue
+		}
+		if n.Name == name {
+			return n, nil
+		}
+		if first == nil {
+			first = n
+		}
+	}
+	if first == nil {
+		return nil, ErrNoNode
+	}
+	return first, nil
+}
+
+// Select 切换当前节点，只影响之后新建的连接；配置重载后恢复为 subscription.node
+func Select(name string) error {
+	for _, n := range Nodes() {
+		if n.Name != name {
+			continue
+		}
+		if err := n.Supported(); err != nil {
+			return err
+		}
+		mu.Lock()
+		selected = name
+		mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("node %q not found", name)
+}
+
+// Start 立即拉取一次订阅，之后按 subscription.interval 定期刷新，ctx 取消时停止；
+// 启动时没有订阅地址也可由配置重载加入
+func Start(ctx context2.Context) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+	if refreshCtx != nil {
+		return
+	}
+	refreshCtx = ctx
+	if len(config.Config.Subscription.URLs) > 0 {
+		refresh(ctx)
+	}
+	schedule(false)
+}
+
+// reschedule 配置重载回调：订阅地址或刷新间隔变化时重新开始定期刷新，并立即拉取一次
+func reschedule() {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+	if refreshCtx == nil {
+		return
+	}
+	if slices.Equal(refreshURLs, config.Config.Subscription.URLs) && refreshEvery == interval(refreshCtx) {
+		return
+	}
+	schedule(true)
+}
+
+// schedule 停止之前的定期刷新，按当前配置重新开始，now 为 true 时先拉取一次；调用方需持有 refreshMu
+func schedule(now bool) {
+	if refreshStop != nil {
+		close(refreshStop)
+		refreshStop = nil
+	}
+	ctx := refreshCtx
+	refreshURLs = slices.Clone(config.Config.Subscription.URLs)
+	refreshEvery = interval(ctx)
+	if len(refreshURLs) == 0 {
+		return
+	}
+	stop, every := make(chan struct{}), refreshEvery
+	refreshStop = stop
+	go func() {
+		if now {
+			refresh(ctx)
+		}
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				refresh(ctx)
+			}
+		}
+	}()
+}
+
+// refresh 拉取全部订阅地址，失败的保留上次的节点
+func refresh(ctx context2.Context) {
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			// 绑定原默认接口，避免订阅请求走 TUN
+			DialContext: func(c context2.Context, network, addr string) (net.Conn, error) {
+				return common.GetOriginalInterfaceDialer().DialContext(c, network, addr)
+			},
+		},
+	}
+	for i, raw := range config.Config.Subscription.URLs {
+		host := raw
+		if u, err := url.Parse(raw); err == nil {
+			host = u.Host // 订阅地址通常带令牌，日志只记录主机名
+		}
+		nodes, err := fetch(client, raw)
+		if err != nil {
+			logger.Error(ctx, map[string]interface{}{
+				"action":    config.ActionRuntime,
+				"errorCode": logger.ErrCodeDefault,
+				"error":     err,
+				"host":      host,
+			}, "fetch subscription failed")
+			continue
+		}
+		for _, n := range nodes {
+			n.Source = fmt.Sprintf("urls[%d]", i)
+		}
+		mu.Lock()
+		fetched[i] = nodes
+		mu.Unlock()
+		version.Add(1)
+		logger.Info(ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"host":   host,
+			"nodes":  len(nodes),
+		}, "subscription refreshed")
+	}
+}
+
+func fetch(client *http.Client, raw string) ([]*Node, error) {
+	req, err := http.NewRequest(http.MethodGet, raw, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "celestial-ladder")
+	rsp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("subscription returned %s", rsp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(rsp.Body, maxContentSize))
+	if err != nil {
+		return nil, err
+	}
+	nodes, errs := ParseList(string(body))
+	if len(nodes) == 0 {
+		if len(errs) > 0 {
+			return nil, errs[0]
+		}
+		return nil, errors.New("subscription is empty")
+	}
+	return nodes, nil
+}
+
+func interval(ctx context2.Context) time.Duration {
+	raw := config.Config.Subscription.Interval
+	if raw == "" {
+		return defaultInterval
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < time.Minute {
+		logger.Error(ctx, map[string]interface{}{
+			"action":    config.ActionRuntime,
+			"errorCode": logger.ErrCodeDefault,
+			"interval":  raw,
+		}, "invalid subscription interval, use 1h")
+		return defaultInterval
+	}
+	return d
+}
@@ -1,17 +1,17 @@
 package systemproxy
 
 import (
+	context2 "context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
 
-	"proxy/utils/context"
+	"proxy/utils/helper"
 	"proxy/utils/logger"
 )
 
@@ -19,6 +19,8 @@ var (
 	backupData *BackupData
 	backupMu   sync.Mutex
 	backupFile = "system_proxy_backup.json"
+	// applied 本进程设置的代理地址，恢复时作为审计日志中的修改前的值；恢复上次运行遗留的设置时为空
+	applied string
 )
 
 // BackupData 备份的系统代理配置
@@ -61,9 +63,20 @@ type LinuxBackup struct {
 	HTTPSPort string `json:"https_port"`
 }
 
+// change 执行修改系统代理设置的命令并写入审计日志，op 为 set 或 restore，before / after 为修改前后的值
+func change(object, op, target, before, after string, name string, args ...string) ([]byte, error) {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	auditErr := err
+	if err != nil {
+		auditErr = fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	logger.Audit(object, op, target, before, after, auditErr)
+	return out, err
+}
+
 // Apply 根据配置自动设置系统代理
 // port 为本地代理监听端口（通常是 config.Config.In.Port）
-func Apply(ctx *context.Context, port int) {
+func Apply(ctx context2.Context, port int) {
 	// 只在启用了 SystemProxy 时调用（由上层控制）
 	// 先备份原始配置
 	if err := backup(ctx); err != nil {
@@ -86,7 +99,7 @@ func Apply(ctx *context.Context, port int) {
 }
 
 // Restore 恢复系统代理配置
-func Restore(ctx *context.Context) {
+func Restore(ctx context2.Context) {
 	backupMu.Lock()
 	defer backupMu.Unlock()
 
@@ -100,7 +113,41 @@ func Restore(ctx *context.Context) {
 			return
 		}
 	}
+	restoreBackup(ctx)
 
+	logger.Info(ctx, map[string]interface{}{
+		"action": "SystemProxy",
+	}, "system proxy restored")
+}
+
+// RecoverStale 上次运行异常退出（崩溃、被强制结束）时备份文件仍在，按其恢复系统代理配置，
+// 避免随后的 Apply 把指向本程序的代理当作原配置备份；没有遗留时返回 false
+func RecoverStale(ctx context2.Context) bool {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	if backupData != nil {
+		return false
+	}
+	if err := loadBackup(); err != nil || backupData == nil {
+		backupData = nil
+		return false
+	}
+	if backupData.OS != runtime.GOOS {
+		backupData = nil
+		removeBackup()
+		return false
+	}
+	restoreBackup(ctx)
+
+	logger.Warn(ctx, map[string]interface{}{
+		"action": "SystemProxy",
+	}, "restored system proxy settings left by a previous run")
+	return true
+}
+
+// restoreBackup 按 backupData 恢复并删除备份文件，调用方持有 backupMu
+func restoreBackup(ctx context2.Context) {
 	switch runtime.GOOS {
 	case "windows":
 		restoreWindows(ctx)
@@ -111,16 +158,13 @@ func Restore(ctx *context.Context) {
 	}
 
 	// 清除备份文件
-	os.Remove(backupFile)
+	removeBackup()
 	backupData = nil
-
-	logger.Info(ctx, map[string]interface{}{
-		"action": "SystemProxy",
-	}, "system proxy restored")
+	applied = ""
 }
 
 // backup 备份当前系统代理配置
-func backup(ctx *context.Context) error {
+func backup(ctx context2.Context) error {
 	backupMu.Lock()
 	defer backupMu.Unlock()
 
@@ -141,7 +185,7 @@ func backup(ctx *context.Context) error {
 }
 
 // backupWindows 备份Windows代理配置
-func backupWindows(ctx *context.Context) error {
+func backupWindows(ctx context2.Context) error {
 	backup := &WindowsBackup{}
 
 	cmd := exec.Command("netsh", "winhttp", "show", "proxy")
@@ -251,22 +295,21 @@ func backupWindows(ctx *context.Context) error {
 }
 
 // restoreWindows 恢复Windows代理配置
-func restoreWindows(ctx *context.Context) {
+func restoreWindows(ctx context2.Context) {
 	if backupData.Windows == nil {
 		logger.Warn(ctx, map[string]interface{}{
 			"action": "SystemProxy",
 		}, "no Windows backup data found, attempting to disable proxy")
 		// 如果没有备份数据，尝试禁用代理
-		exec.Command("netsh", "winhttp", "reset", "proxy").Run()
+		_, _ = change("winhttp", "restore", "proxy", applied, "", "netsh", "winhttp", "reset", "proxy")
 		const regPath = `HKCU\Software\Microsoft\Windows\CurrentVersion\Internet Settings`
-		exec.Command("reg", "add", regPath, "/v", "ProxyEnable", "/t", "REG_DWORD", "/d", "0", "/f").Run()
+		_, _ = change("registry", "restore", regPath+`\ProxyEnable`, "1", "0", "reg", "add", regPath, "/v", "ProxyEnable", "/t", "REG_DWORD", "/d", "0", "/f")
 		return
 	}
 
 	// 恢复 WinHTTP 代理
 	if backupData.Windows.WinHTTPProxy == "" {
-		cmd := exec.Command("netsh", "winhttp", "reset", "proxy")
-		if out, err := cmd.CombinedOutput(); err != nil {
+		if out, err := change("winhttp", "restore", "proxy", applied, "", "netsh", "winhttp", "reset", "proxy"); err != nil {
 			logger.Error(ctx, map[string]interface{}{
 				"action": "SystemProxy",
 				"error":  err,
@@ -278,8 +321,8 @@ func restoreWindows(ctx *context.Context) {
 			}, "WinHTTP proxy reset")
 		}
 	} else {
-		cmd := exec.Command("netsh", "winhttp", "set", "proxy", backupData.Windows.WinHTTPProxy)
-		if out, err := cmd.CombinedOutput(); err != nil {
+		if out, err := change("winhttp", "restore", "proxy", applied, backupData.Windows.WinHTTPProxy,
+			"netsh", "winhttp", "set", "proxy", backupData.Windows.WinHTTPProxy); err != nil {
 			logger.Error(ctx, map[string]interface{}{
 				"action": "SystemProxy",
 				"error":  err,
@@ -299,8 +342,8 @@ func restoreWindows(ctx *context.Context) {
 	// ProxyEnable - 如果为空或为 "0"，则禁用代理
 	proxyEnable := backupData.Windows.ProxyEnable
 	if proxyEnable == "" || proxyEnable == "0" {
-		cmd := exec.Command("reg", "add", regPath, "/v", "ProxyEnable", "/t", "REG_DWORD", "/d", "0", "/f")
-		if out, err := cmd.CombinedOutput(); err != nil {
+		if out, err := change("registry", "restore", regPath+`\ProxyEnable`, "1", "0",
+			"reg", "add", regPath, "/v", "ProxyEnable", "/t", "REG_DWORD", "/d", "0", "/f"); err != nil {
 			logger.Error(ctx, map[string]interface{}{
 				"action": "SystemProxy",
 				"error":  err,
@@ -312,8 +355,8 @@ func restoreWindows(ctx *context.Context) {
 			}, "WinINET proxy disabled")
 		}
 	} else {
-		cmd := exec.Command("reg", "add", regPath, "/v", "ProxyEnable", "/t", "REG_DWORD", "/d", proxyEnable, "/f")
-		if out, err := cmd.CombinedOutput(); err != nil {
+		if out, err := change("registry", "restore", regPath+`\ProxyEnable`, "1", proxyEnable,
+			"reg", "add", regPath, "/v", "ProxyEnable", "/t", "REG_DWORD", "/d", proxyEnable, "/f"); err != nil {
 			logger.Error(ctx, map[string]interface{}{
 				"action": "SystemProxy",
 				"error":  err,
@@ -329,8 +372,8 @@ func restoreWindows(ctx *context.Context) {
 
 	// ProxyServer
 	if backupData.Windows.ProxyServer == "" {
-		cmd := exec.Command("reg", "delete", regPath, "/v", "ProxyServer", "/f")
-		if out, err := cmd.CombinedOutput(); err != nil {
+		if out, err := change("registry", "restore", regPath+`\ProxyServer`, applied, "",
+			"reg", "delete", regPath, "/v", "ProxyServer", "/f"); err != nil {
 			// 如果键不存在，删除会失败，这是正常的
 			if !strings.Contains(string(out), "ERROR") {
 				logger.Warn(ctx, map[string]interface{}{
@@ -345,8 +388,8 @@ func restoreWindows(ctx *context.Context) {
 			}, "WinINET ProxyServer cleared")
 		}
 	} else {
-		cmd := exec.Command("reg", "add", regPath, "/v", "ProxyServer", "/t", "REG_SZ", "/d", backupData.Windows.ProxyServer, "/f")
-		if out, err := cmd.CombinedOutput(); err != nil {
+		if out, err := change("registry", "restore", regPath+`\ProxyServer`, applied, backupData.Windows.ProxyServer,
+			"reg", "add", regPath, "/v", "ProxyServer", "/t", "REG_SZ", "/d", backupData.Windows.ProxyServer, "/f"); err != nil {
 			logger.Error(ctx, map[string]interface{}{
 				"action": "SystemProxy",
 				"error":  err,
@@ -362,8 +405,8 @@ func restoreWindows(ctx *context.Context) {
 
 	// ProxyOverride
 	if backupData.Windows.ProxyOverride == "" {
-		cmd := exec.Command("reg", "delete", regPath, "/v", "ProxyOverride", "/f")
-		if out, err := cmd.CombinedOutput(); err != nil {
+		if out, err := change("registry", "restore", regPath+`\ProxyOverride`, "", "",
+			"reg", "delete", regPath, "/v", "ProxyOverride", "/f"); err != nil {
 			// 如果键不存在，删除会失败，这是正常的
 			if !strings.Contains(string(out), "ERROR") {
 				logger.Warn(ctx, map[string]interface{}{
@@ -378,8 +421,8 @@ func restoreWindows(ctx *context.Context) {
 			}, "WinINET ProxyOverride cleared")
 		}
 	} else {
-		cmd := exec.Command("reg", "add", regPath, "/v", "ProxyOverride", "/t", "REG_SZ", "/d", backupData.Windows.ProxyOverride, "/f")
-		if out, err := cmd.CombinedOutput(); err != nil {
+		if out, err := change("registry", "restore", regPath+`\ProxyOverride`, "", backupData.Windows.ProxyOverride,
+			"reg", "add", regPath, "/v", "ProxyOverride", "/t", "REG_SZ", "/d", backupData.Windows.ProxyOverride, "/f"); err != nil {
 			logger.Error(ctx, map[string]interface{}{
 				"action": "SystemProxy",
 				"error":  err,
@@ -395,12 +438,16 @@ func restoreWindows(ctx *context.Context) {
 }
 
 // applyWindows 配置 WinHTTP + WinINET 代理
-func applyWindows(ctx *context.Context, port int) {
+func applyWindows(ctx context2.Context, port int) {
 	proxy := "127.0.0.1:" + strconv.Itoa(port)
+	applied = proxy
+	before := &WindowsBackup{}
+	if backupData != nil && backupData.Windows != nil {
+		before = backupData.Windows
+	}
 
 	// 设置 WinHTTP 代理
-	cmd := exec.Command("netsh", "winhttp", "set", "proxy", proxy)
-	if out, err := cmd.CombinedOutput(); err != nil {
+	if out, err := change("winhttp", "set", "proxy", before.WinHTTPProxy, proxy, "netsh", "winhttp", "set", "proxy", proxy); err != nil {
 		logger.Warn(ctx, map[string]interface{}{
 			"action": "SystemProxy",
 			"os":     "windows",
@@ -416,9 +463,11 @@ func applyWindows(ctx *context.Context, port int) {
 	const regPathCorrect = `HKCU\Software\Microsoft\Windows\CurrentVersion\Internet Settings`
 
 	// 开启代理
-	exec.Command("reg", "add", regPathCorrect, "/v", "ProxyEnable", "/t", "REG_DWORD", "/d", "1", "/f").Run()
+	_, _ = change("registry", "set", regPathCorrect+`\ProxyEnable`, before.ProxyEnable, "1",
+		"reg", "add", regPathCorrect, "/v", "ProxyEnable", "/t", "REG_DWORD", "/d", "1", "/f")
 	// 设置代理服务器
-	exec.Command("reg", "add", regPathCorrect, "/v", "ProxyServer", "/t", "REG_SZ", "/d", proxy, "/f").Run()
+	_, _ = change("registry", "set", regPathCorrect+`\ProxyServer`, before.ProxyServer, proxy,
+		"reg", "add", regPathCorrect, "/v", "ProxyServer", "/t", "REG_SZ", "/d", proxy, "/f")
 
 	logger.Info(ctx, map[string]interface{}{
 		"action": "SystemProxy",
@@ -428,7 +477,7 @@ func applyWindows(ctx *context.Context, port int) {
 }
 
 // backupDarwin 备份macOS代理配置
-func backupDarwin(ctx *context.Context) error {
+func backupDarwin(ctx context2.Context) error {
 	backupData.Darwin = &DarwinBackup{
 		Services: make(map[string]*ServiceBackup),
 	}
@@ -485,39 +534,65 @@ func backupDarwin(ctx *context.Context) error {
 }
 
 // restoreDarwin 恢复macOS代理配置
-func restoreDarwin(ctx *context.Context) {
+func restoreDarwin(ctx context2.Context) {
 	if backupData.Darwin == nil {
 		return
 	}
 
 	for service, svcBackup := range backupData.Darwin.Services {
+		web := service + " web proxy"
 		if svcBackup.WebProxyEnabled {
-			exec.Command("networksetup", "-setwebproxy", service, svcBackup.WebProxyHost, svcBackup.WebProxyPort).Run()
-			exec.Command("networksetup", "-setwebproxystate", service, "on").Run()
+			restored := darwinProxy(true, svcBackup.WebProxyHost, svcBackup.WebProxyPort)
+			_, _ = change("networksetup", "restore", web, applied, restored, "networksetup", "-setwebproxy", service, svcBackup.WebProxyHost, svcBackup.WebProxyPort)
+			_, _ = change("networksetup", "restore", web+" state", "on", "on", "networksetup", "-setwebproxystate", service, "on")
 		} else {
-			exec.Command("networksetup", "-setwebproxystate", service, "off").Run()
+			_, _ = change("networksetup", "restore", web+" state", "on", "off", "networksetup", "-setwebproxystate", service, "off")
 		}
 
+		secure := service + " secure web proxy"
 		if svcBackup.SecureProxyEnabled {
-			exec.Command("networksetup", "-setsecurewebproxy", service, svcBackup.SecureProxyHost, svcBackup.SecureProxyPort).Run()
-			exec.Command("networksetup", "-setsecurewebproxystate", service, "on").Run()
+			restored := darwinProxy(true, svcBackup.SecureProxyHost, svcBackup.SecureProxyPort)
+			_, _ = change("networksetup", "restore", secure, applied, restored, "networksetup", "-setsecurewebproxy", service, svcBackup.SecureProxyHost, svcBackup.SecureProxyPort)
+			_, _ = change("networksetup", "restore", secure+" state", "on", "on", "networksetup", "-setsecurewebproxystate", service, "on")
 		} else {
-			exec.Command("networksetup", "-setsecurewebproxystate", service, "off").Run()
+			_, _ = change("networksetup", "restore", secure+" state", "on", "off", "networksetup", "-setsecurewebproxystate", service, "off")
 		}
 	}
 }
 
+// darwinProxy 审计日志中 macOS 网络服务的代理设置：未开启时为 off
+func darwinProxy(enabled bool, host, port string) string {
+	if !enabled {
+		return "off"
+	}
+	return host + ":" + port
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
+
 // applyDarwin 使用 networksetup 配置 macOS 系统代理（Wi-Fi/Ethernet）
-func applyDarwin(ctx *context.Context, port int) {
+func applyDarwin(ctx context2.Context, port int) {
 	proxyHost := "127.0.0.1"
 	proxyPort := strconv.Itoa(port)
+	proxy := proxyHost + ":" + proxyPort
+	applied = proxy
 
 	services := []string{"Wi-Fi", "Ethernet"}
 
 	for _, service := range services {
+		before := &ServiceBackup{}
+		if backupData != nil && backupData.Darwin != nil && backupData.Darwin.Services[service] != nil {
+			before = backupData.Darwin.Services[service]
+		}
+		web, secure := service+" web proxy", service+" secure web proxy"
 		// HTTP 代理
-		cmd := exec.Command("networksetup", "-setwebproxy", service, proxyHost, proxyPort)
-		if out, err := cmd.CombinedOutput(); err != nil {
+		if out, err := change("networksetup", "set", web, darwinProxy(before.WebProxyEnabled, before.WebProxyHost, before.WebProxyPort), proxy,
+			"networksetup", "-setwebproxy", service, proxyHost, proxyPort); err != nil {
 			logger.Warn(ctx, map[string]interface{}{
 				"action":  "SystemProxy",
 				"os":      "darwin",
@@ -528,8 +603,8 @@ func applyDarwin(ctx *context.Context, port int) {
 			continue
 		}
 		// HTTPS 代理
-		cmd = exec.Command("networksetup", "-setsecurewebproxy", service, proxyHost, proxyPort)
-		if out, err := cmd.CombinedOutput(); err != nil {
+		if out, err := change("networksetup", "set", secure, darwinProxy(before.SecureProxyEnabled, before.SecureProxyHost, before.SecureProxyPort), proxy,
+			"networksetup", "-setsecurewebproxy", service, proxyHost, proxyPort); err != nil {
 			logger.Warn(ctx, map[string]interface{}{
 				"action":  "SystemProxy",
 				"os":      "darwin",
@@ -540,8 +615,8 @@ func applyDarwin(ctx *context.Context, port int) {
 			continue
 		}
 		// 开启代理
-		exec.Command("networksetup", "-setwebproxystate", service, "on").Run()
-		exec.Command("networksetup", "-setsecurewebproxystate", service, "on").Run()
+		_, _ = change("networksetup", "set", web+" state", onOff(before.WebProxyEnabled), "on", "networksetup", "-setwebproxystate", service, "on")
+		_, _ = change("networksetup", "set", secure+" state", onOff(before.SecureProxyEnabled), "on", "networksetup", "-setsecurewebproxystate", service, "on")
 
 		logger.Info(ctx, map[string]interface{}{
 			"action":  "SystemProxy",
@@ -553,7 +628,7 @@ func applyDarwin(ctx *context.Context, port int) {
 }
 
 // backupLinux 备份Linux代理配置
-func backupLinux(ctx *context.Context) error {
+func backupLinux(ctx context2.Context) error {
 	// 检查 gsettings 是否可用
 	if _, err := exec.LookPath("gsettings"); err != nil {
 		return fmt.Errorf("gsettings not found")
@@ -594,7 +669,7 @@ func backupLinux(ctx *context.Context) error {
 }
 
 // restoreLinux 恢复Linux代理配置
-func restoreLinux(ctx *context.Context) {
+func restoreLinux(ctx context2.Context) {
 	if backupData.Linux == nil {
 		return
 	}
@@ -604,30 +679,45 @@ func restoreLinux(ctx *context.Context) {
 		return
 	}
 
+	appliedHost, appliedPort := "", ""
+	if applied != "" {
+		appliedHost, appliedPort = "127.0.0.1", strings.TrimPrefix(applied, "127.0.0.1:")
+	}
+
 	// 恢复代理模式
 	if backupData.Linux.Mode != "" {
-		exec.Command("gsettings", "set", "org.gnome.system.proxy", "mode", backupData.Linux.Mode).Run()
+		restoreGSetting("org.gnome.system.proxy", "mode", "manual", backupData.Linux.Mode)
 	}
 
 	// 恢复HTTP代理
 	if backupData.Linux.HTTPHost != "" {
-		exec.Command("gsettings", "set", "org.gnome.system.proxy.http", "host", backupData.Linux.HTTPHost).Run()
+		restoreGSetting("org.gnome.system.proxy.http", "host", appliedHost, backupData.Linux.HTTPHost)
 	}
 	if backupData.Linux.HTTPPort != "" {
-		exec.Command("gsettings", "set", "org.gnome.system.proxy.http", "port", backupData.Linux.HTTPPort).Run()
+		restoreGSetting("org.gnome.system.proxy.http", "port", appliedPort, backupData.Linux.HTTPPort)
 	}
 
 	// 恢复HTTPS代理
 	if backupData.Linux.HTTPSHost != "" {
-		exec.Command("gsettings", "set", "org.gnome.system.proxy.https", "host", backupData.Linux.HTTPSHost).Run()
+		restoreGSetting("org.gnome.system.proxy.https", "host", appliedHost, backupData.Linux.HTTPSHost)
 	}
 	if backupData.Linux.HTTPSPort != "" {
-		exec.Command("gsettings", "set", "org.gnome.system.proxy.https", "port", backupData.Linux.HTTPSPort).Run()
+		restoreGSetting("org.gnome.system.proxy.https", "port", appliedPort, backupData.Linux.HTTPSPort)
 	}
 }
 
+// setGSetting 设置 GNOME 代理设置中的一个键
+func setGSetting(schema, key, before, after string) {
+	_, _ = change("gsettings", "set", schema+" "+key, before, after, "gsettings", "set", schema, key, after)
+}
+
+// restoreGSetting 把 GNOME 代理设置中的一个键恢复为备份的值
+func restoreGSetting(schema, key, before, after string) {
+	_, _ = change("gsettings", "restore", schema+" "+key, before, after, "gsettings", "set", schema, key, after)
+}
+
 // applyLinux 使用 gsettings 配置 GNOME 系统代理（如可用），否则仅记录提示
-func applyLinux(ctx *context.Context, port int) {
+func applyLinux(ctx context2.Context, port int) {
 	proxyHost := "127.0.0.1"
 	proxyPort := strconv.Itoa(port)
 
@@ -640,16 +730,22 @@ func applyLinux(ctx *context.Context, port int) {
 		return
 	}
 
+	applied = proxyHost + ":" + proxyPort
+	before := &LinuxBackup{}
+	if backupData != nil && backupData.Linux != nil {
+		before = backupData.Linux
+	}
+
 	// 设置代理模式为手动
-	exec.Command("gsettings", "set", "org.gnome.system.proxy", "mode", "manual").Run()
+	setGSetting("org.gnome.system.proxy", "mode", before.Mode, "manual")
 
 	// HTTP 代理
-	exec.Command("gsettings", "set", "org.gnome.system.proxy.http", "host", proxyHost).Run()
-	exec.Command("gsettings", "set", "org.gnome.system.proxy.http", "port", proxyPort).Run()
+	setGSetting("org.gnome.system.proxy.http", "host", before.HTTPHost, proxyHost)
+	setGSetting("org.gnome.system.proxy.http", "port", before.HTTPPort, proxyPort)
 
 	// HTTPS 代理
-	exec.Command("gsettings", "set", "org.gnome.system.proxy.https", "host", proxyHost).Run()
-	exec.Command("gsettings", "set", "org.gnome.system.proxy.https", "port", proxyPort).Run()
+	setGSetting("org.gnome.system.proxy.https", "host", before.HTTPSHost, proxyHost)
+	setGSetting("org.gnome.system.proxy.https", "port", before.HTTPSPort, proxyPort)
 
 	logger.Info(ctx, map[string]interface{}{
 		"action": "SystemProxy",
@@ -665,26 +761,21 @@ func saveBackup() error {
 		return fmt.Errorf("failed to marshal backup: %w", err)
 	}
 
-	// 获取可执行文件所在目录
-	exePath, err := os.Executable()
+	// 保存在可执行文件所在目录
+	backupPath, err := helper.ExeFilePath(backupFile)
 	if err != nil {
-		return fmt.Errorf("failed to get executable path: %w", err)
+		return err
 	}
-	exeDir := filepath.Dir(exePath)
-	backupPath := filepath.Join(exeDir, backupFile)
 
 	return os.WriteFile(backupPath, data, 0644)
 }
 
 // loadBackup 从文件加载备份
 func loadBackup() error {
-	// 获取可执行文件所在目录
-	exePath, err := os.Executable()
+	backupPath, err := helper.ExeFilePath(backupFile)
 	if err != nil {
-		return fmt.Errorf("failed to get executable path: %w", err)
+		return err
 	}
-	exeDir := filepath.Dir(exePath)
-	backupPath := filepath.Join(exeDir, backupFile)
 
 	data, err := os.ReadFile(backupPath)
 	if err != nil {
@@ -697,3 +788,10 @@ func loadBackup() error {
 
 	return nil
 }
+
+// removeBackup 删除备份文件
+func removeBackup() {
+	if backupPath, err := helper.ExeFilePath(backupFile); err == nil {
+		_ = os.Remove(backupPath)
+	}
+}
@@ -0,0 +1,93 @@
+// Package tor 按 tor.binary 启动本机 Tor 进程，作为 Tor 出口使用的 SOCKS 端口，进程意外退出后自动重启
+package tor
+
+import (
+	context2 "context"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"proxy/config"
+	"proxy/utils/logger"
+)
+
+// restartDelay tor 进程意外退出后重新启动前的等待时间
+const restartDelay = 10 * time.Second
+
+var (
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	stopped bool
+)
+
+// Start 配置了 tor.binary 时启动 tor，监听 tor.socks，数据保存在 tor.data_dir；修改 tor.binary 需重启本程序
+func Start(ctx context2.Context) {
+	if config.Config.Tor.Binary == "" {
+		return
+	}
+	go run(ctx)
+}
+
+// Stop 结束 tor 进程，之后不再重启
+func Stop() {
+	mu.Lock()
+	defer mu.Unlock()
+	stopped = true
+	if cmd != nil && cmd.Process != nil {
+		_ = cmd.Process.Kill()
+	}
+}
+
+func run(ctx context2.Context) {
+	for {
+		c, err := launch()
+		if err == nil {
+			logger.Info(ctx, map[string]interface{}{
+				"action": config.ActionRuntime,
+				"pid":    c.Process.Pid,
+				"socks":  config.TorSocks(),
+			}, "tor started")
+			err = c.Wait()
+		}
+		mu.Lock()
+		done := stopped
+		cmd = nil
+		mu.Unlock()
+		if done {
+			return
+		}
+		logger.Error(ctx, map[string]interface{}{
+			"action":    config.ActionRuntime,
+			"errorCode": logger.ErrCodeDefault,
+			"error":     err,
+		}, "tor exited, restarting in 10s")
+		time.Sleep(restartDelay)
+	}
+}
+
+// launch 启动 tor；SOCKS 端口只用于本程序，关闭 DNS 与透明代理端口
+func launch() (*exec.Cmd, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if stopped {
+		return nil, os.ErrProcessDone
+	}
+	dataDir := config.TorDataDir()
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		return nil, err
+	}
+	c := exec.Command(config.Config.Tor.Binary,
+		"--SocksPort", config.TorSocks(),
+		"--DataDirectory", dataDir,
+		"--DNSPort", "0",
+		"--TransPort", "0",
+		"--Log", "notice stderr",
+	)
+	c.Stderr = os.Stderr
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	cmd = c
+	return c, nil
+}
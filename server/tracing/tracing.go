@@ -0,0 +1,100 @@
+// Package tracing 为请求链路生成 span 并以 OTLP/HTTP JSON 格式上报，traceID 沿用 utils/context 中的 traceID
+package tracing
+
+import (
+	context2 "context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"proxy/utils/context"
+)
+
+// spanKey 上下文中保存当前 span 的键，新 span 以其为父节点
+type spanKey struct{}
+
+// enabled 配置了上报地址并启动 Export 后才生成 span
+var enabled atomic.Bool
+
+// Span 一段耗时，nil 表示未开启追踪，所有方法均可安全调用
+type Span struct {
+	parent  *Span
+	traceID string
+	spanID  string
+	name    string
+	start   time.Time
+	attrs   map[string]interface{}
+}
+
+// Start 开始一个 span，父节点为 ctx 中的当前 span；返回以新 span 为当前 span 的 ctx，之后的 span 经它成为子节点。
+// 未开启追踪时原样返回 ctx 与 nil
+func Start(ctx context2.Context, name string) (context2.Context, *Span) {
+	if !enabled.Load() {
+		return ctx, nil
+	}
+	s := &Span{
+		spanID: newID(8),
+		name:   name,
+		start:  time.Now(),
+	}
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok {
+		s.parent, s.traceID = parent, parent.traceID
+	} else {
+		s.traceID = traceID(ctx)
+	}
+	return context2.WithValue(ctx, spanKey{}, s), s
+}
+
+// SetAttr 设置 span 属性
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	if s.attrs == nil {
+		s.attrs = make(map[string]interface{})
+	}
+	s.attrs[key] = value
+}
+
+// End 结束 span 并加入上报队列，err 不为空时标记为失败
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	record := spanRecord{
+		TraceID: s.traceID,
+		SpanID:  s.spanID,
+		Name:    s.name,
+		Kind:    kindInternal,
+		Start:   fmt.Sprint(s.start.UnixNano()),
+		End:     fmt.Sprint(time.Now().UnixNano()),
+		Attrs:   attributes(s.attrs),
+	}
+	if s.parent != nil {
+		record.ParentID = s.parent.spanID
+	} else {
+		record.Kind = kindServer
+	}
+	if err != nil {
+		record.Status = &status{Code: statusError, Message: err.Error()}
+	}
+	enqueue(record)
+}
+
+// traceID 把 ctx 中的 uuid 形式 traceID 转为 32 位十六进制，没有时随机生成
+func traceID(ctx context2.Context) string {
+	id := strings.ReplaceAll(context.TraceID(ctx), "-", "")
+	if len(id) != 32 {
+		id = newID(16)
+	}
+	return id
+}
+
+func newID(n int) string {
+	buf := make([]byte, n)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
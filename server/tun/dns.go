@@ -5,20 +5,26 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
 	"proxy/config"
 	"proxy/server/doh"
+	"proxy/server/metrics"
+	"proxy/server/route"
 	"proxy/utils/context"
 	"proxy/utils/logger"
+
+	"golang.org/x/sync/singleflight"
 )
 
 // DNSHandler DNS处理器
 type DNSHandler struct {
 	dohClient *doh.AliyunProvider
-	ctx       *context.Context
+	ctx       context2.Context
 	cache     *DNSCache
+	group     singleflight.Group // 合并相同 (name, type, ecs) 的并发查询
 }
 
 // DNSCache DNS缓存
@@ -27,12 +33,20 @@ type DNSCache struct {
 	mu      sync.RWMutex
 }
 
-// CacheEntry 缓存条目
+// CacheEntry 缓存条目，保存完整的应答记录集
 type CacheEntry struct {
-	IP        net.IP
+	Records   []DNSRecord
 	ExpiresAt time.Time
 }
 
+// DNSRecord 应答记录
+type DNSRecord struct {
+	Name string
+	Type uint16
+	TTL  uint32
+	Data []byte // rdata
+}
+
 // NewDNSHandler 创建DNS处理器
 func NewDNSHandler() *DNSHandler {
 	return &DNSHandler{
@@ -46,28 +60,88 @@ func NewDNSHandler() *DNSHandler {
 
 // HandleDNSQuery 处理DNS查询
 func (h *DNSHandler) HandleDNSQuery(ipPkt *IPPacket, udpPkt *UDPPacket) ([]byte, error) {
+	response, err := h.AnswerUDP(udpPkt.Data)
+	if err != nil {
+		metrics.TunPacketDrops.With("dns_malformed").Inc()
+		return nil, err
+	}
+	return buildDNSPacket(ipPkt, udpPkt, response), nil
+}
+
+// Answer 以 DoH 应答经 TCP 收到的 DNS 查询报文，返回应答报文
+func (h *DNSHandler) Answer(data []byte) ([]byte, error) {
+	return h.answer(data, false)
+}
+
+// AnswerUDP 以 DoH 应答经 UDP 收到的 DNS 查询报文，应答超过 512 字节（或查询 EDNS 声明的大小）时截断并置 TC 位
+func (h *DNSHandler) AnswerUDP(data []byte) ([]byte, error) {
+	return h.answer(data, true)
+}
+
+func (h *DNSHandler) answer(data []byte, udp bool) ([]byte, error) {
 	// 解析DNS查询包
-	dnsQuery, err := parseDNSQuery(udpPkt.Data)
+	dnsQuery, err := parseDNSQuery(data)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse DNS query: %w", err)
 	}
+	if !udp {
+		dnsQuery.MaxSize = 0
+	}
+
+	// 命中 adblock.lists 的域名直接返回 NXDOMAIN，应用不会再发起连接
+	if rule := route.GetRuleEngine().MatchBlock(dnsQuery.Domain, nil); rule != "" {
+		logger.Debug(h.ctx, map[string]interface{}{
+			"action": config.ActionSocketOperate,
+			"domain": dnsQuery.Domain,
+			"rule":   rule,
+		}, "DNS query blocked by adblock list")
+		return buildDNSErrorResponse(dnsQuery, 3), nil
+	}
+
+	// 只处理 A/AAAA 查询，其他类型返回空应答（NODATA）
+	var qtype doh.Type
+	switch dnsQuery.Type {
+	case dnsTypeA:
+		qtype = doh.TypeA
+	case dnsTypeAAAA:
+		// 仅 IPv4 时不返回 AAAA，避免应用优先尝试不可达的 IPv6 地址
+		if config.IPv4Only() {
+			return buildDNSErrorResponse(dnsQuery, 0), nil
+		}
+		qtype = doh.TypeAAAA
+	default:
+		return buildDNSErrorResponse(dnsQuery, 0), nil
+	}
+	// 静态 hosts 优先于 DoH
+	name := dnsQuery.Domain
+	var prefix []DNSRecord
+	if hostsIP, alias, ok := route.LookupHosts(name); ok {
+		if hostsIP == nil {
+			// 别名以 CNAME 形式返回，后续记录属于别名
+			prefix = append(prefix, DNSRecord{Name: name, Type: dnsTypeCNAME, TTL: 60, Data: encodeDNSName(alias)})
+			name = alias
+		} else if (qtype == doh.TypeA) == (hostsIP.To4() != nil) {
+			return buildDNSResponse(dnsQuery, []DNSRecord{ipRecord(name, hostsIP, 60)}), nil
+		} else {
+			// 映射的 IP 与查询的地址族不一致
+			return buildDNSErrorResponse(dnsQuery, 0), nil
+		}
+	}
+	cacheKey := name + ":" + string(qtype)
 
 	// 检查缓存
 	h.cache.mu.RLock()
-	if entry, exists := h.cache.entries[dnsQuery.Domain]; exists {
-		if time.Now().Before(entry.ExpiresAt) {
+	if entry, exists := h.cache.entries[cacheKey]; exists {
+		if remaining := time.Until(entry.ExpiresAt); remaining > 0 {
 			h.cache.mu.RUnlock()
-			// 使用缓存结果
-			return h.buildDNSResponse(ipPkt, udpPkt, dnsQuery, entry.IP), nil
+			metrics.DNSCacheResult("tun", true)
+			// 使用缓存结果，TTL 取剩余时间
+			records := append(prefix, withMaxTTL(entry.Records, uint32(remaining/time.Second)+1)...)
+			return buildDNSResponse(dnsQuery, records), nil
 		}
-		// 缓存过期，删除
-		delete(h.cache.entries, dnsQuery.Domain)
 	}
 	h.cache.mu.RUnlock()
-
-	// 使用DoH解析
-	ctxCancel, cancel := context2.WithTimeout(context2.Background(), 10*time.Second)
-	defer cancel()
+	metrics.DNSCacheResult("tun", false)
 
 	// ECS subnet
 	var subnet = config.Config.ECSSubnet
@@ -75,7 +149,32 @@ func (h *DNSHandler) HandleDNSQuery(ipPkt *IPPacket, udpPkt *UDPPacket) ([]byte,
 		subnet = "110.242.68.0/24"
 	}
 
-	rsp, err := h.dohClient.ECSQuery(ctxCancel, doh.Domain(dnsQuery.Domain), doh.Type("A"), doh.ECS(subnet))
+	// 使用DoH解析，相同查询在途时只发起一次
+	v, err, _ := h.group.Do(cacheKey+":"+subnet, func() (interface{}, error) {
+		ctxCancel, cancel := context2.WithTimeout(h.ctx, config.DialTimeout())
+		defer cancel()
+		rsp, err := h.dohClient.ECSQuery(ctxCancel, doh.Domain(name), qtype, doh.ECS(subnet))
+		if err != nil {
+			return nil, err
+		}
+		// 提取 CNAME 链及全部 A/AAAA 记录
+		records := answerRecords(rsp, qtype)
+		if len(records) == 0 {
+			return records, nil
+		}
+		// 按记录集中最小的 TTL 缓存
+		ttl := records[0].TTL
+		for _, record := range records[1:] {
+			ttl = min(ttl, record.TTL)
+		}
+		h.cache.mu.Lock()
+		h.cache.entries[cacheKey] = &CacheEntry{
+			Records:   records,
+			ExpiresAt: time.Now().Add(time.Duration(ttl) * time.Second),
+		}
+		h.cache.mu.Unlock()
+		return records, nil
+	})
 	if err != nil {
 		logger.Error(h.ctx, map[string]interface{}{
 			"action":    config.ActionSocketOperate,
@@ -84,42 +183,85 @@ func (h *DNSHandler) HandleDNSQuery(ipPkt *IPPacket, udpPkt *UDPPacket) ([]byte,
 			"domain":    dnsQuery.Domain,
 		}, "DoH query failed")
 		// 返回NXDOMAIN响应
-		return h.buildDNSErrorResponse(ipPkt, udpPkt, dnsQuery, 3), nil // NXDOMAIN
+		return buildDNSErrorResponse(dnsQuery, 3), nil // NXDOMAIN
 	}
+	records := v.([]DNSRecord)
+	if !hasAddress(records) {
+		// 没有对应类型的记录，返回空应答
+		return buildDNSErrorResponse(dnsQuery, 0), nil
+	}
+
+	// 构建DNS响应
+	return buildDNSResponse(dnsQuery, append(prefix, records...)), nil
+}
 
-	// 提取IP地址
-	var ip net.IP
+// answerRecords 按应答顺序提取 CNAME 及与查询类型匹配的 A/AAAA 记录
+func answerRecords(rsp *doh.Response, qtype doh.Type) []DNSRecord {
+	var records []DNSRecord
 	for _, answer := range rsp.Answer {
-		if answer.Type == 1 { // A record
-			ip = net.ParseIP(answer.Data)
-			if ip != nil && ip.To4() != nil {
-				break
+		ttl := uint32(60)
+		if answer.TTL > 0 {
+			ttl = uint32(answer.TTL)
+		}
+		name := strings.TrimSuffix(answer.Name, ".")
+		switch {
+		case answer.Type == doh.RRTypeCNAME:
+			records = append(records, DNSRecord{Name: name, Type: dnsTypeCNAME, TTL: ttl, Data: encodeDNSName(answer.Data)})
+		case answer.Type == doh.RRTypeA && qtype == doh.TypeA,
+			answer.Type == doh.RRTypeAAAA && qtype == doh.TypeAAAA:
+			if ip := net.ParseIP(answer.Data); ip != nil {
+				records = append(records, ipRecord(name, ip, ttl))
 			}
 		}
 	}
+	return records
+}
 
-	if ip == nil {
-		// 没有找到A记录，返回NXDOMAIN
-		return h.buildDNSErrorResponse(ipPkt, udpPkt, dnsQuery, 3), nil
+// ipRecord 构建 A/AAAA 记录
+func ipRecord(name string, ip net.IP, ttl uint32) DNSRecord {
+	if ip4 := ip.To4(); ip4 != nil {
+		return DNSRecord{Name: name, Type: dnsTypeA, TTL: ttl, Data: ip4}
 	}
+	return DNSRecord{Name: name, Type: dnsTypeAAAA, TTL: ttl, Data: ip.To16()}
+}
 
-	// 缓存结果（TTL 60秒）
-	h.cache.mu.Lock()
-	h.cache.entries[dnsQuery.Domain] = &CacheEntry{
-		IP:        ip,
-		ExpiresAt: time.Now().Add(60 * time.Second),
+// hasAddress 记录集中是否包含 A/AAAA 记录
+func hasAddress(records []DNSRecord) bool {
+	for _, record := range records {
+		if record.Type == dnsTypeA || record.Type == dnsTypeAAAA {
+			return true
+		}
 	}
-	h.cache.mu.Unlock()
+	return false
+}
 
-	// 构建DNS响应
-	return h.buildDNSResponse(ipPkt, udpPkt, dnsQuery, ip), nil
+// withMaxTTL 返回 TTL 不超过 ttl 的记录副本
+func withMaxTTL(records []DNSRecord, ttl uint32) []DNSRecord {
+	result := make([]DNSRecord, len(records))
+	for i, record := range records {
+		record.TTL = min(record.TTL, ttl)
+		result[i] = record
+	}
+	return result
 }
 
+// DNS 记录类型
+const (
+	dnsTypeA     = 1
+	dnsTypeCNAME = 5
+	dnsTypeAAAA  = 28
+	dnsTypeOPT   = 41
+)
+
+// dnsUDPSize 不带 EDNS 的 UDP 应答的最大长度
+const dnsUDPSize = 512
+
 // DNSQuery DNS查询结构
 type DNSQuery struct {
-	ID     uint16
-	Domain string
-	Type   uint16
+	ID      uint16
+	Domain  string
+	Type    uint16
+	MaxSize int // 应答的最大长度：512 或 EDNS 声明的 UDP 大小，为 0 时不限（TCP）
 }
 
 // parseDNSQuery 解析DNS查询包
@@ -148,10 +290,30 @@ func parseDNSQuery(data []byte) (*DNSQuery, error) {
 		return nil, fmt.Errorf("DNS query incomplete")
 	}
 	query.Type = binary.BigEndian.Uint16(data[offset : offset+2])
+	query.MaxSize = max(dnsUDPSize, ednsUDPSize(data, offset+4))
 
 	return query, nil
 }
 
+// ednsUDPSize 查询的附加部分中 OPT 记录声明的 UDP 大小，没有 OPT 记录或报文不完整时返回 0
+func ednsUDPSize(data []byte, offset int) int {
+	// 跳过回答与授权部分（查询中通常为空），在附加部分查找 OPT（类型 41，类字段为 UDP 大小）
+	skip := int(binary.BigEndian.Uint16(data[6:8])) + int(binary.BigEndian.Uint16(data[8:10]))
+	total := skip + int(binary.BigEndian.Uint16(data[10:12]))
+	for i := 0; i < total; i++ {
+		_, next, err := parseDNSName(data, offset)
+		if err != nil || len(data) < next+10 {
+			return 0
+		}
+		rrType := binary.BigEndian.Uint16(data[next : next+2])
+		if i >= skip && rrType == dnsTypeOPT {
+			return int(binary.BigEndian.Uint16(data[next+2 : next+4]))
+		}
+		offset = next + 10 + int(binary.BigEndian.Uint16(data[next+8:next+10]))
+	}
+	return 0
+}
+
 // parseDNSName 解析DNS名称
 func parseDNSName(data []byte, offset int) (string, int, error) {
 	var name string
@@ -211,20 +373,20 @@ func parseDNSName(data []byte, offset int) (string, int, error) {
 	return name, offset, nil
 }
 
-// buildDNSResponse 构建DNS响应包
-func (h *DNSHandler) buildDNSResponse(ipPkt *IPPacket, udpPkt *UDPPacket, query *DNSQuery, ip net.IP) []byte {
+// buildDNSResponse 构建DNS应答报文；超过 query.MaxSize 时只保留放得下的记录并置 TC 位，客户端随后改用 TCP 查询
+func buildDNSResponse(query *DNSQuery, records []DNSRecord) []byte {
 	// DNS响应包结构
 	response := make([]byte, 0, 512)
 
 	// DNS头部（12字节）
 	header := make([]byte, 12)
-	binary.BigEndian.PutUint16(header[0:2], query.ID) // ID
-	header[2] = 0x81                                  // Flags: QR=1, Opcode=0, AA=0, TC=0, RD=1
-	header[3] = 0x80                                  // Flags: RA=1, Z=0, RCODE=0
-	binary.BigEndian.PutUint16(header[4:6], 1)       // QDCOUNT = 1
-	binary.BigEndian.PutUint16(header[6:8], 1)       // ANCOUNT = 1
-	binary.BigEndian.PutUint16(header[8:10], 0)      // NSCOUNT = 0
-	binary.BigEndian.PutUint16(header[10:12], 0)    // ARCOUNT = 0
+	binary.BigEndian.PutUint16(header[0:2], query.ID)             // ID
+	header[2] = 0x81                                              // Flags: QR=1, Opcode=0, AA=0, TC=0, RD=1
+	header[3] = 0x80                                              // Flags: RA=1, Z=0, RCODE=0
+	binary.BigEndian.PutUint16(header[4:6], 1)                    // QDCOUNT = 1
+	binary.BigEndian.PutUint16(header[6:8], uint16(len(records))) // ANCOUNT
+	binary.BigEndian.PutUint16(header[8:10], 0)                   // NSCOUNT = 0
+	binary.BigEndian.PutUint16(header[10:12], 0)                  // ARCOUNT = 0
 	response = append(response, header...)
 
 	// 查询部分（从原始查询复制）
@@ -233,25 +395,36 @@ func (h *DNSHandler) buildDNSResponse(ipPkt *IPPacket, udpPkt *UDPPacket, query
 	response = append(response, queryPart...)
 
 	// 答案部分
-	answer := make([]byte, 0, 64)
-	// 名称（使用压缩指针指向查询部分）
-	answer = append(answer, 0xC0, 0x0C) // 指向偏移12（查询部分开始）
-	// 类型 A (1)
-	binary.BigEndian.PutUint16(answer[len(answer):len(answer)+2], 1)
-	answer = answer[:len(answer)+2]
-	// 类 IN (1)
-	binary.BigEndian.PutUint16(answer[len(answer):len(answer)+2], 1)
-	answer = answer[:len(answer)+2]
-	// TTL (60秒)
-	binary.BigEndian.PutUint32(answer[len(answer):len(answer)+4], 60)
-	answer = answer[:len(answer)+4]
-	// 数据长度 (4字节IPv4)
-	binary.BigEndian.PutUint16(answer[len(answer):len(answer)+2], 4)
-	answer = answer[:len(answer)+2]
-	// IP地址
-	answer = append(answer, ip.To4()...)
-	response = append(response, answer...)
+	for i, record := range records {
+		start := len(response)
+		// 名称：与查询相同时使用压缩指针指向偏移12（查询部分开始）
+		if strings.EqualFold(record.Name, query.Domain) {
+			response = append(response, 0xC0, 0x0C)
+		} else {
+			response = append(response, encodeDNSName(record.Name)...)
+		}
+		// 类型 A (1) / CNAME (5) / AAAA (28)
+		response = binary.BigEndian.AppendUint16(response, record.Type)
+		// 类 IN (1)
+		response = binary.BigEndian.AppendUint16(response, 1)
+		// TTL
+		response = binary.BigEndian.AppendUint32(response, record.TTL)
+		// 数据长度及数据
+		response = binary.BigEndian.AppendUint16(response, uint16(len(record.Data)))
+		response = append(response, record.Data...)
+		if query.MaxSize > 0 && len(response) > query.MaxSize {
+			// 截断：去掉放不下的记录，ANCOUNT 改为实际条数，置 TC 位
+			response = response[:start]
+			binary.BigEndian.PutUint16(response[6:8], uint16(i))
+			response[2] |= 0x02
+			break
+		}
+	}
+	return response
+}
 
+// buildDNSPacket 把应答报文封装为发回查询方的 IP/UDP 数据包
+func buildDNSPacket(ipPkt *IPPacket, udpPkt *UDPPacket, response []byte) []byte {
 	// 构建UDP数据包
 	udpResponse := make([]byte, 8+len(response))
 	binary.BigEndian.PutUint16(udpResponse[0:2], udpPkt.DstPort) // 源端口（响应中的目标端口）
@@ -271,8 +444,8 @@ func (h *DNSHandler) buildDNSResponse(ipPkt *IPPacket, udpPkt *UDPPacket, query
 	return ipResponse
 }
 
-// buildDNSErrorResponse 构建DNS错误响应
-func (h *DNSHandler) buildDNSErrorResponse(ipPkt *IPPacket, udpPkt *UDPPacket, query *DNSQuery, rcode uint8) []byte {
+// buildDNSErrorResponse 构建不含记录的DNS应答报文，rcode 为 0 时表示 NODATA
+func buildDNSErrorResponse(query *DNSQuery, rcode uint8) []byte {
 	header := make([]byte, 12)
 	binary.BigEndian.PutUint16(header[0:2], query.ID)
 	header[2] = 0x81 // QR=1
@@ -284,36 +457,13 @@ func (h *DNSHandler) buildDNSErrorResponse(ipPkt *IPPacket, udpPkt *UDPPacket, q
 
 	queryPart := buildDNSQueryPart(query.Domain, query.Type)
 
-	response := append(header, queryPart...)
-
-	udpResponse := make([]byte, 8+len(response))
-	binary.BigEndian.PutUint16(udpResponse[0:2], udpPkt.DstPort)
-	binary.BigEndian.PutUint16(udpResponse[2:4], udpPkt.SrcPort)
-	binary.BigEndian.PutUint16(udpResponse[4:6], uint16(len(response)+8))
-	binary.BigEndian.PutUint16(udpResponse[6:8], 0)
-	copy(udpResponse[8:], response)
-
-	ipResponse := BuildIPPacket(
-		ipPkt.DstIP,
-		ipPkt.SrcIP,
-		IPProtocolUDP,
-		udpResponse,
-	)
-
-	return ipResponse
+	return append(header, queryPart...)
 }
 
 // buildDNSQueryPart 构建DNS查询部分
 func buildDNSQueryPart(domain string, qtype uint16) []byte {
-	query := make([]byte, 0, 64)
-
 	// 域名
-	parts := splitDomain(domain)
-	for _, part := range parts {
-		query = append(query, byte(len(part)))
-		query = append(query, []byte(part)...)
-	}
-	query = append(query, 0) // 结束标记
+	query := encodeDNSName(domain)
 
 	// 类型
 	typeBytes := make([]byte, 2)
@@ -326,6 +476,16 @@ func buildDNSQueryPart(domain string, qtype uint16) []byte {
 	return query
 }
 
+// encodeDNSName 将域名编码为 DNS 标签序列
+func encodeDNSName(domain string) []byte {
+	name := make([]byte, 0, 64)
+	for _, part := range splitDomain(domain) {
+		name = append(name, byte(len(part)))
+		name = append(name, []byte(part)...)
+	}
+	return append(name, 0) // 结束标记
+}
+
 // splitDomain 分割域名
 func splitDomain(domain string) []string {
 	parts := []string{}
@@ -0,0 +1,149 @@
+package tun
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"proxy/server/common"
+	"proxy/server/metrics"
+	"proxy/server/route"
+	"proxy/utils/workpool"
+)
+
+func init() {
+	route.SetDNSHijack(&DNSRemote{})
+}
+
+// DNSRemote dns.guard 劫持 53 端口查询时的出口：不连接原目标，由 DNSHandler 经 DoH 应答；
+// UDP 按数据报应答，TCP 按 RFC 1035 的 2 字节长度前缀逐条应答
+type DNSRemote struct {
+}
+
+const (
+	// dnsWorkers 应答 UDP 查询的协程数，查询经 DoH 转发，大部分时间在等待网络
+	dnsWorkers = 64
+	// dnsQueue 每个协程排队的查询数上限，超出时丢弃，由客户端重试
+	dnsQueue = 64
+)
+
+var (
+	guardHandler     *DNSHandler
+	guardPool        *workpool.Pool
+	guardHandlerOnce sync.Once
+	flowID           atomic.Uint64 // UDP 会话编号，同一会话的查询由同一协程按顺序应答
+)
+
+func dnsGuardHandler() *DNSHandler {
+	guardHandlerOnce.Do(func() {
+		guardHandler = NewDNSHandler()
+		guardPool = workpool.New(dnsWorkers, dnsQueue)
+	})
+	return guardHandler
+}
+
+func (r *DNSRemote) Handshake(ctx context.Context, target *common.TargetAddr) (io.ReadWriter, error) {
+	h := dnsGuardHandler()
+	if target.Proto == 3 {
+		return &dnsPacketConn{h: h, flow: flowID.Add(1), replies: make(chan dnsReply, 16), done: make(chan struct{})}, nil
+	}
+	local, peer := net.Pipe()
+	go serveDNSStream(h, peer)
+	return local, nil
+}
+
+func (r *DNSRemote) Name() string {
+	return "DNSHijack"
+}
+
+type dnsReply struct {
+	data []byte
+	addr string
+}
+
+// dnsPacketConn 写入的每个查询交给协程池异步应答，应答以原目标地址作为来源读出
+type dnsPacketConn struct {
+	h       *DNSHandler
+	flow    uint64
+	replies chan dnsReply
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (c *dnsPacketConn) ReadPacket(p []byte) (int, string, error) {
+	select {
+	case r := <-c.replies:
+		return copy(p, r.data), r.addr, nil
+	case <-c.done:
+		return 0, "", net.ErrClosed
+	}
+}
+
+func (c *dnsPacketConn) WritePacket(p []byte, addr string) error {
+	query := append([]byte(nil), p...)
+	ok := guardPool.Submit(c.flow, func() {
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+		response, err := c.h.AnswerUDP(query)
+		if err != nil {
+			return
+		}
+		select {
+		case c.replies <- dnsReply{data: response, addr: addr}:
+		case <-c.done:
+		}
+	})
+	if !ok {
+		metrics.TunPacketDrops.With("dns_queue_full").Inc()
+	}
+	return nil
+}
+
+// Read 与 Write 只为满足 io.ReadWriter，UDP 会话按数据报读写
+func (c *dnsPacketConn) Read(p []byte) (int, error) {
+	n, _, err := c.ReadPacket(p)
+	return n, err
+}
+
+func (c *dnsPacketConn) Write(p []byte) (int, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func (c *dnsPacketConn) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+	})
+	return nil
+}
+
+// serveDNSStream 在 TCP 上逐条读取带长度前缀的查询并应答，读写出错时关闭连接
+func serveDNSStream(h *DNSHandler, conn net.Conn) {
+	defer conn.Close()
+	head := make([]byte, 2)
+	for {
+		if _, err := io.ReadFull(conn, head); err != nil {
+			return
+		}
+		query := make([]byte, binary.BigEndian.Uint16(head))
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+		response, err := h.Answer(query)
+		if err != nil {
+			return
+		}
+		if _, err = conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(response)))); err != nil {
+			return
+		}
+		if _, err = conn.Write(response); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package tun
+
+import (
+	context2 "context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"proxy/config"
+	"proxy/server/metrics"
+	"proxy/utils/context"
+	"proxy/utils/helper"
+	"proxy/utils/logger"
+)
+
+const (
+	// gatewayBackupFile 网关模式对系统所做改动的记录（可执行文件所在目录），恢复后删除；
+	// 进程崩溃或被强制结束时文件仍在，下次启动据此清理
+	gatewayBackupFile = "tun_gateway_backup.json"
+	// dnsStreamTimeout 局域网 DNS 的 TCP 连接的最长存活时间
+	dnsStreamTimeout = 30 * time.Second
+)
+
+// gatewayBackup 网关模式已做的改动
+type gatewayBackup struct {
+	Forward string     `json:"forward"`          // 开启前 net.ipv4.ip_forward 的值，为空时未改动
+	Rules   [][]string `json:"rules"`            // 已添加的防火墙规则：表、链与匹配参数
+	Rules6  [][]string `json:"rules6,omitempty"` // 已添加的 ip6tables 规则，格式同 Rules
+}
+
+// Gateway 网关模式：局域网设备以本机为默认网关时，转发的流量经 TUN 默认路由进入 tun2socks 分流，
+// 经原网关直连的流量（远端服务器、直连规则的路由）做源地址转换；局域网 DNS 查询由内置解析器（DoH、hosts、广告拦截）应答
+type Gateway struct {
+	lan     string
+	tunName string
+	lanIP   net.IP
+	subnet  *net.IPNet
+	dnsAddr string
+	ctx     context2.Context
+
+	backup gatewayBackup
+	udp    net.PacketConn
+	tcp    net.Listener
+}
+
+// NewGateway 按 tun.gateway 创建网关，未开启时返回 nil
+func NewGateway(tunName string) (*Gateway, error) {
+	cfg := config.Config.Tun.Gateway
+	if !cfg.Enable {
+		return nil, nil
+	}
+	if err := gatewaySupported(); err != nil {
+		return nil, err
+	}
+	if cfg.LAN == "" {
+		return nil, errors.New("tun.gateway.lan is required")
+	}
+	lanIP, subnet, err := interfaceIPv4(cfg.LAN)
+	if err != nil {
+		return nil, err
+	}
+	dnsAddr := cfg.DNS
+	switch dnsAddr {
+	case "-":
+		dnsAddr = ""
+	case "":
+		dnsAddr = net.JoinHostPort(lanIP.String(), "53")
+	}
+	return &Gateway{
+		lan:     cfg.LAN,
+		tunName: tunName,
+		lanIP:   lanIP,
+		subnet:  subnet,
+		dnsAddr: dnsAddr,
+		ctx:     context.NewContext(),
+	}, nil
+}
+
+// interfaceIPv4 网卡的第一个 IPv4 地址及其网段
+func interfaceIPv4(name string) (net.IP, *net.IPNet, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tun.gateway.lan %s: %w", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, nil, fmt.Errorf("tun.gateway.lan %s: %w", name, err)
+	}
+	for _, addr := range addrs {
+		if n, ok := addr.(*net.IPNet); ok && n.IP.To4() != nil {
+			return n.IP.To4(), &net.IPNet{IP: n.IP.Mask(n.Mask), Mask: n.Mask}, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("tun.gateway.lan %s has no IPv4 address", name)
+}
+
+// Start 开启转发与局域网 DNS；DNS 端口被占用时只记录日志，转发照常工作
+func (g *Gateway) Start() error {
+	if g == nil {
+		return nil
+	}
+	if err := g.enableForwarding(); err != nil {
+		return fmt.Errorf("enable gateway forwarding: %w", err)
+	}
+	if err := g.serveDNS(); err != nil {
+		logger.Warn(g.ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"listen": g.dnsAddr,
+			"error":  err,
+		}, "serve LAN DNS failed (another DNS server such as dnsmasq may be using the port), set tun.gateway.dns to another address or -")
+	}
+	logger.Info(g.ctx, map[string]interface{}{
+		"action": config.ActionRuntime,
+		"lan":    g.lan,
+		"subnet": g.subnet.String(),
+		"dns":    g.dnsAddr,
+	}, "gateway mode started, point the LAN default gateway and DNS to "+g.lanIP.String())
+	return nil
+}
+
+// Stop 关闭局域网 DNS，删除防火墙规则并恢复转发设置
+func (g *Gateway) Stop() {
+	if g == nil {
+		return
+	}
+	if g.udp != nil {
+		_ = g.udp.Close()
+		g.udp = nil
+	}
+	if g.tcp != nil {
+		_ = g.tcp.Close()
+		g.tcp = nil
+	}
+	restoreForwarding(g.ctx, &g.backup)
+	g.backup = gatewayBackup{}
+	removeGatewayBackup()
+}
+
+// serveDNS 在 dnsAddr 的 UDP 与 TCP 上应答局域网的 DNS 查询
+func (g *Gateway) serveDNS() error {
+	if g.dnsAddr == "" {
+		return nil
+	}
+	h := dnsGuardHandler()
+	pc, err := net.ListenPacket("udp", g.dnsAddr)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", g.dnsAddr)
+	if err != nil {
+		pc.Close()
+		return err
+	}
+	g.udp, g.tcp = pc, ln
+	go serveDNSPackets(h, pc)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.SetDeadline(time.Now().Add(dnsStreamTimeout))
+			go serveDNSStream(h, conn)
+		}
+	}()
+	return nil
+}
+
+// serveDNSPackets 逐个读取 UDP 查询交给协程池应答，同一客户端地址的查询由同一协程按顺序应答
+func serveDNSPackets(h *DNSHandler, pc net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		query := append([]byte(nil), buf[:n]...)
+		ok := guardPool.Submit(clientKey(addr), func() {
+			response, err := h.AnswerUDP(query)
+			if err != nil {
+				metrics.TunPacketDrops.With("dns_malformed").Inc()
+				return
+			}
+			_, _ = pc.WriteTo(response, addr)
+		})
+		if !ok {
+			metrics.TunPacketDrops.With("dns_queue_full").Inc()
+		}
+	}
+}
+
+func clientKey(addr net.Addr) uint64 {
+	u, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0
+	}
+	var key uint64
+	if ip := u.IP.To4(); ip != nil {
+		key = uint64(binary.BigEndian.Uint32(ip))
+	}
+	return key<<16 | uint64(u.Port)
+}
+
+// saveBackup 记录当前已做的改动，写入失败只记录日志
+func (g *Gateway) saveBackup() {
+	path, err := helper.ExeFilePath(gatewayBackupFile)
+	if err == nil {
+		var data []byte
+		if data, err = json.MarshalIndent(&g.backup, "", "  "); err == nil {
+			err = os.WriteFile(path, data, 0644)
+		}
+	}
+	if err != nil {
+		logger.Warn(g.ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"error":  err,
+		}, "failed to save gateway backup")
+	}
+}
+
+func removeGatewayBackup() {
+	if path, err := helper.ExeFilePath(gatewayBackupFile); err == nil {
+		_ = os.Remove(path)
+	}
+}
+
+// CleanupStaleGateway 上次运行异常退出时按备份删除遗留的防火墙规则并恢复转发设置；没有遗留时返回 false
+func CleanupStaleGateway(ctx context2.Context) bool {
+	path, err := helper.ExeFilePath(gatewayBackupFile)
+	if err != nil {
+		return false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	var b gatewayBackup
+	if err = json.Unmarshal(data, &b); err != nil {
+		logger.Warn(ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"file":   path,
+			"error":  err,
+		}, "invalid gateway backup, removed")
+		_ = os.Remove(path)
+		return false
+	}
+	restoreForwarding(ctx, &b)
+	_ = os.Remove(path)
+	logger.Warn(ctx, map[string]interface{}{
+		"action":  config.ActionRuntime,
+		"rules":   len(b.Rules) + len(b.Rules6),
+		"forward": b.Forward,
+	}, "cleaned up gateway settings left by a previous run")
+	return true
+}
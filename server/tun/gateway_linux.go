@@ -0,0 +1,145 @@
+//go:build linux
+
+package tun
+
+import (
+	context2 "context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"proxy/config"
+	"proxy/utils/logger"
+)
+
+const (
+	ipForwardPath = "/proc/sys/net/ipv4/ip_forward"
+	// ruleComment 本程序添加的防火墙规则的注释，便于用户识别
+	ruleComment = "celestial-ladder"
+)
+
+func gatewaySupported() error {
+	if _, err := exec.LookPath("iptables"); err != nil {
+		return fmt.Errorf("gateway mode needs iptables: %w", err)
+	}
+	return nil
+}
+
+// firewallRules 放行局域网网段发出的转发与回程（插在 FORWARD 链最前，先于 Docker 等的拒绝规则），外部主动发往局域网的连接不放行；
+// 局域网经其他网卡（原网关直连的路由）出去的流量做源地址转换；经 TUN 的流量由 tun2socks 代为连接，不需要转换
+func (g *Gateway) firewallRules() [][]string {
+	subnet := g.subnet.String()
+	comment := []string{"-m", "comment", "--comment", ruleComment}
+	return [][]string{
+		append(append([]string{"filter", "FORWARD", "-i", g.lan, "-s", subnet}, comment...), "-j", "ACCEPT"),
+		append(append([]string{"filter", "FORWARD", "-o", g.lan, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED"}, comment...), "-j", "ACCEPT"),
+		append(append([]string{"nat", "POSTROUTING", "-s", subnet, "!", "-d", subnet, "!", "-o", g.tunName}, comment...), "-j", "MASQUERADE"),
+	}
+}
+
+// firewallRules6 拒绝转发局域网发出的 IPv6 流量：网关模式只接管 IPv4，系统开着 IPv6 转发时局域网设备的 IPv6 连接会绕过 TUN，
+// 拒绝后应用回退到 IPv4
+func (g *Gateway) firewallRules6() [][]string {
+	comment := []string{"-m", "comment", "--comment", ruleComment}
+	return [][]string{
+		append(append([]string{"filter", "FORWARD", "-i", g.lan}, comment...), "-j", "REJECT"),
+	}
+}
+
+// enableForwarding 开启 IPv4 转发并添加防火墙规则，每一步都写入备份，中途失败时撤销已做的改动
+func (g *Gateway) enableForwarding() error {
+	before, err := os.ReadFile(ipForwardPath)
+	if err != nil {
+		return err
+	}
+	if value := strings.TrimSpace(string(before)); value != "1" {
+		err = os.WriteFile(ipForwardPath, []byte("1\n"), 0644)
+		logger.Audit("sysctl", "set", "net.ipv4.ip_forward", value, "1", err)
+		if err != nil {
+			return err
+		}
+		g.backup.Forward = value
+		g.saveBackup()
+	}
+	for _, rule := range g.firewallRules() {
+		err = iptables("-I", rule)
+		logger.Audit("iptables", "add", strings.Join(rule, " "), "", "", err)
+		if err != nil {
+			restoreForwarding(g.ctx, &g.backup)
+			g.backup = gatewayBackup{}
+			removeGatewayBackup()
+			return err
+		}
+		g.backup.Rules = append(g.backup.Rules, rule)
+		g.saveBackup()
+	}
+	if _, err = exec.LookPath("ip6tables"); err != nil {
+		logger.Warn(g.ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"error":  err,
+		}, "ip6tables not found, ipv6 forwarded from lan is not blocked and bypasses the tunnel")
+		return nil
+	}
+	for _, rule := range g.firewallRules6() {
+		err = ip6tables("-I", rule)
+		logger.Audit("ip6tables", "add", strings.Join(rule, " "), "", "", err)
+		if err != nil {
+			restoreForwarding(g.ctx, &g.backup)
+			g.backup = gatewayBackup{}
+			removeGatewayBackup()
+			return err
+		}
+		g.backup.Rules6 = append(g.backup.Rules6, rule)
+		g.saveBackup()
+	}
+	return nil
+}
+
+// restoreForwarding 按添加的逆序删除防火墙规则，把 net.ipv4.ip_forward 改回开启前的值
+func restoreForwarding(ctx context2.Context, b *gatewayBackup) {
+	for i := len(b.Rules6) - 1; i >= 0; i-- {
+		err := ip6tables("-D", b.Rules6[i])
+		logger.Audit("ip6tables", "delete", strings.Join(b.Rules6[i], " "), "", "", err)
+		if err != nil {
+			logger.Warn(ctx, map[string]interface{}{
+				"action": config.ActionRuntime,
+				"rule":   strings.Join(b.Rules6[i], " "),
+				"error":  err,
+			}, "failed to delete gateway firewall rule")
+		}
+	}
+	for i := len(b.Rules) - 1; i >= 0; i-- {
+		err := iptables("-D", b.Rules[i])
+		logger.Audit("iptables", "delete", strings.Join(b.Rules[i], " "), "", "", err)
+		if err != nil {
+			logger.Warn(ctx, map[string]interface{}{
+				"action": config.ActionRuntime,
+				"rule":   strings.Join(b.Rules[i], " "),
+				"error":  err,
+			}, "failed to delete gateway firewall rule")
+		}
+	}
+	if b.Forward != "" {
+		err := os.WriteFile(ipForwardPath, []byte(b.Forward+"\n"), 0644)
+		logger.Audit("sysctl", "restore", "net.ipv4.ip_forward", "1", b.Forward, err)
+	}
+}
+
+// iptables 以 op（-I 或 -D）执行规则，rule 为表、链与匹配参数
+func iptables(op string, rule []string) error {
+	return xtables("iptables", op, rule)
+}
+
+// ip6tables 同 iptables，作用于 IPv6
+func ip6tables(op string, rule []string) error {
+	return xtables("ip6tables", op, rule)
+}
+
+func xtables(bin, op string, rule []string) error {
+	args := append([]string{"-w", "-t", rule[0], op, rule[1]}, rule[2:]...)
+	if out, err := exec.Command(bin, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s: %w: %s", bin, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
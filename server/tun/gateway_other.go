@@ -0,0 +1,18 @@
+//go:build !linux
+
+package tun
+
+import (
+	context2 "context"
+	"errors"
+)
+
+func gatewaySupported() error {
+	return errors.New("gateway mode is only supported on Linux")
+}
+
+func (g *Gateway) enableForwarding() error {
+	return gatewaySupported()
+}
+
+func restoreForwarding(ctx context2.Context, b *gatewayBackup) {}
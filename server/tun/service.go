@@ -1,6 +1,7 @@
 package tun
 
 import (
+	context2 "context"
 	"fmt"
 	"net"
 	"os"
@@ -16,16 +17,18 @@ import (
 // Service TUN服务
 type Service struct {
 	tun2socks   *Tun2SocksService
+	gateway     *Gateway
+	sysDNS      *SystemDNS
 	routeMgr    *route.RouteManager
 	ipAllocator *IPAllocator
 	tunIP       net.IP
 	tunMask     net.IPMask
-	ctx         *context.Context
+	ctx         context2.Context
 }
 
 // NewService 创建TUN服务
 func NewService() (*Service, error) {
-	if !config.Config.Tun.Enable {
+	if !config.TunEnabled() {
 		return nil, nil
 	}
 
@@ -67,11 +70,18 @@ func NewService() (*Service, error) {
 		common.SetOriginalInterfaceIP(ctx, originalIP)
 	}
 
-	// 创建路由管理器
 	tunName := config.Config.Tun.Name
 	if tunName == "" {
 		tunName = "clt0"
 	}
+
+	// 网关模式，未开启时为 nil
+	gateway, err := NewGateway(tunName)
+	if err != nil {
+		return nil, err
+	}
+
+	// 创建路由管理器
 	routeMgr := route.NewRouteManager(tunName, gatewayIP.String())
 
 	// 设置全局路由管理器，供其他模块使用
@@ -103,6 +113,8 @@ func NewService() (*Service, error) {
 
 	return &Service{
 		tun2socks:   tun2socks,
+		gateway:     gateway,
+		sysDNS:      NewSystemDNS(tunName),
 		routeMgr:    routeMgr,
 		ipAllocator: ipAllocator,
 		tunIP:       gatewayIP,
@@ -122,6 +134,21 @@ func (s *Service) Start() error {
 		return fmt.Errorf("failed to start tun2socks: %w", err)
 	}
 
+	// 网关模式：局域网经本机转发的流量走 TUN 默认路由
+	if err := s.gateway.Start(); err != nil {
+		s.tun2socks.Stop()
+		return err
+	}
+
+	// tun.dns_push：系统 DNS 改为 tun.dns，设置失败不影响 TUN
+	if err := s.sysDNS.Start(); err != nil {
+		logger.Error(s.ctx, map[string]interface{}{
+			"action":    config.ActionRuntime,
+			"errorCode": logger.ErrCodeDefault,
+			"error":     err,
+		}, "failed to set system DNS")
+	}
+
 	logger.Info(s.ctx, map[string]interface{}{
 		"action": config.ActionRuntime,
 		"tunIP":  s.tunIP.String(),
@@ -130,6 +157,11 @@ func (s *Service) Start() error {
 	return nil
 }
 
+// Running TUN 服务是否在运行
+func (s *Service) Running() bool {
+	return s != nil && s.tun2socks != nil && s.tun2socks.Running()
+}
+
 // Stop 停止TUN服务
 func (s *Service) Stop() error {
 	if s == nil {
@@ -140,6 +172,10 @@ func (s *Service) Stop() error {
 		"action": config.ActionRuntime,
 	}, "TUN service stopping")
 
+	// 先恢复系统 DNS 并停止转发，避免局域网流量在 TUN 关闭后绕过代理直接发出
+	s.sysDNS.Stop()
+	s.gateway.Stop()
+
 	// 停止 tun2socks
 	if s.tun2socks != nil {
 		s.tun2socks.Stop()
@@ -0,0 +1,233 @@
+package tun
+
+import (
+	context2 "context"
+	"encoding/json"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"proxy/config"
+	"proxy/server/crash"
+	"proxy/utils/context"
+	"proxy/utils/helper"
+	"proxy/utils/logger"
+)
+
+const (
+	// dnsBackupFile 改动前的系统 DNS 设置（可执行文件所在目录），恢复后删除；
+	// 进程崩溃或被强制结束时文件仍在，下次启动据此恢复
+	dnsBackupFile = "tun_dns_backup.json"
+	// dnsWatchInterval 检查系统 DNS 是否仍为 tun.dns 的间隔
+	dnsWatchInterval = 5 * time.Second
+
+	dnsPushReassert = "reassert"
+	dnsPushWarn     = "warn"
+)
+
+// dnsBackup 改动前的系统 DNS 设置
+type dnsBackup struct {
+	Interface string            `json:"interface"` // TUN 网卡名
+	Saved     map[string]string `json:"saved"`     // 各平台保存的原设置：后端、resolv.conf 内容、网络服务的 DNS 等
+}
+
+// SystemDNS 开启 TUN 时把系统 DNS 改为 tun.dns，并定期检查：DHCP 续约、NetworkManager、
+// 网卡重建等改回原设置时按 tun.dns_push 重新设置或只记录日志，停止时恢复原设置
+type SystemDNS struct {
+	tunName string
+	servers []string
+	policy  string
+	ctx     context2.Context
+
+	backup   dnsBackup
+	reverted bool // warn 模式下已提示过当前这次改回
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewSystemDNS 按 tun.dns_push 创建，未开启或 tun.dns 为空时返回 nil
+func NewSystemDNS(tunName string) *SystemDNS {
+	cfg := config.Config.Tun
+	if cfg.DNSPush != dnsPushReassert && cfg.DNSPush != dnsPushWarn {
+		return nil
+	}
+	var servers []string
+	for _, s := range cfg.DNS {
+		if ip := net.ParseIP(s); ip != nil {
+			servers = append(servers, ip.String())
+		}
+	}
+	if len(servers) == 0 {
+		return nil
+	}
+	return &SystemDNS{
+		tunName: tunName,
+		servers: servers,
+		policy:  cfg.DNSPush,
+		ctx:     context.NewContext(),
+	}
+}
+
+// Start 设置系统 DNS 并开始检查，需在 TUN 网卡创建之后调用
+func (d *SystemDNS) Start() error {
+	if d == nil {
+		return nil
+	}
+	saved, err := pushDNS(d.tunName, d.servers, nil)
+	if err != nil {
+		return err
+	}
+	d.backup = dnsBackup{Interface: d.tunName, Saved: saved}
+	d.saveBackup()
+	logger.Info(d.ctx, map[string]interface{}{
+		"action":  config.ActionRuntime,
+		"servers": d.servers,
+		"backend": saved["backend"],
+		"policy":  d.policy,
+	}, "system DNS set to tun.dns")
+
+	d.stop, d.done = make(chan struct{}), make(chan struct{})
+	stop, done := d.stop, d.done
+	crash.Go(func() {
+		defer close(done)
+		ticker := time.NewTicker(dnsWatchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				d.check()
+			}
+		}
+	})
+	return nil
+}
+
+// Stop 停止检查并恢复原来的系统 DNS；系统已改为其他 DNS（warn 模式下被 DHCP 续约改回）时保留系统的设置
+func (d *SystemDNS) Stop() {
+	if d == nil || d.stop == nil {
+		return
+	}
+	close(d.stop)
+	<-d.done
+	d.stop, d.done = nil, nil
+	defer removeDNSBackup()
+	if current, err := currentDNS(d.tunName, d.backup.Saved); err == nil && len(current) > 0 && !slices.Equal(current, d.servers) {
+		logger.Info(d.ctx, map[string]interface{}{
+			"action":  config.ActionRuntime,
+			"current": strings.Join(current, " "),
+		}, "system DNS was already changed by the system, not restored")
+		return
+	}
+	if err := restoreDNS(d.tunName, d.backup.Saved); err != nil {
+		logger.Warn(d.ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"error":  err,
+		}, "failed to restore system DNS")
+	}
+}
+
+// check 系统 DNS 不再是 tun.dns 时重新设置（reassert）或提示一次（warn）；
+// 重新设置前保存当时的设置，退出时恢复为最近一次由系统下发的 DNS
+func (d *SystemDNS) check() {
+	current, err := currentDNS(d.tunName, d.backup.Saved)
+	if err != nil {
+		logger.WarnAggregated(d.ctx, "dns_watch", map[string]interface{}{
+			"action": config.ActionRuntime,
+			"error":  err,
+		}, "failed to read system DNS")
+		return
+	}
+	if slices.Equal(current, d.servers) {
+		d.reverted = false
+		return
+	}
+	fields := map[string]interface{}{
+		"action":  config.ActionRuntime,
+		"current": strings.Join(current, " "),
+		"servers": d.servers,
+	}
+	if d.policy == dnsPushWarn {
+		if !d.reverted {
+			d.reverted = true
+			logger.Warn(d.ctx, fields, "system DNS was changed (DHCP renew or network manager), queries may bypass TUN DNS")
+		}
+		return
+	}
+	saved, err := pushDNS(d.tunName, d.servers, d.backup.Saved)
+	if err != nil {
+		fields["error"] = err
+		logger.ErrorAggregated(d.ctx, "dns_watch", fields, "system DNS was changed, failed to set it again")
+		return
+	}
+	d.backup.Saved = saved
+	d.saveBackup()
+	logger.Warn(d.ctx, fields, "system DNS was changed (DHCP renew or network manager), set it again")
+}
+
+// saveBackup 记录原设置，写入失败只记录日志
+func (d *SystemDNS) saveBackup() {
+	path, err := helper.ExeFilePath(dnsBackupFile)
+	if err == nil {
+		var data []byte
+		if data, err = json.MarshalIndent(&d.backup, "", "  "); err == nil {
+			err = os.WriteFile(path, data, 0600)
+		}
+	}
+	if err != nil {
+		logger.Warn(d.ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"error":  err,
+		}, "failed to save DNS backup")
+	}
+}
+
+func removeDNSBackup() {
+	if path, err := helper.ExeFilePath(dnsBackupFile); err == nil {
+		_ = os.Remove(path)
+	}
+}
+
+// CleanupStaleDNS 上次运行异常退出时按备份恢复系统 DNS；没有遗留时返回 false
+func CleanupStaleDNS(ctx context2.Context) bool {
+	path, err := helper.ExeFilePath(dnsBackupFile)
+	if err != nil {
+		return false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	var b dnsBackup
+	if err = json.Unmarshal(data, &b); err != nil {
+		logger.Warn(ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"file":   path,
+			"error":  err,
+		}, "invalid DNS backup, removed")
+		_ = os.Remove(path)
+		return false
+	}
+	err = restoreDNS(b.Interface, b.Saved)
+	_ = os.Remove(path)
+	logger.Warn(ctx, map[string]interface{}{
+		"action":  config.ActionRuntime,
+		"backend": b.Saved["backend"],
+		"error":   err,
+	}, "restored system DNS left by a previous run")
+	return true
+}
+
+// parseIPs 取出文本中所有的 IP 地址，按出现顺序
+func parseIPs(text string) []string {
+	var ips []string
+	for _, field := range strings.Fields(text) {
+		if ip := net.ParseIP(strings.Trim(field, ",;")); ip != nil {
+			ips = append(ips, ip.String())
+		}
+	}
+	return ips
+}
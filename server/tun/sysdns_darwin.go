@@ -0,0 +1,103 @@
+//go:build darwin
+
+package tun
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"proxy/utils/logger"
+)
+
+// macOS：为 Wi-Fi 与 Ethernet 网络服务设置 DNS，与系统代理使用同样的服务列表；
+// 不存在的服务跳过。原来没有手动设置时备份为 Empty，恢复后重新使用 DHCP 下发的 DNS
+
+var dnsServices = []string{"Wi-Fi", "Ethernet"}
+
+// pushDNS 设置各服务的 DNS 并返回改动前的设置；previous 为重新设置时上一次的备份，仍是 tun.dns 的服务沿用其中的原设置
+func pushDNS(tunName string, servers []string, previous map[string]string) (map[string]string, error) {
+	saved := map[string]string{"backend": "networksetup"}
+	for _, service := range dnsServices {
+		before, err := getDNSServers(service)
+		if err != nil {
+			continue
+		}
+		value := "Empty"
+		if len(before) > 0 {
+			value = strings.Join(before, " ")
+		}
+		if old, ok := previous[service]; ok && value == strings.Join(servers, " ") {
+			saved[service] = old
+			continue
+		}
+		err = networksetup(append([]string{"-setdnsservers", service}, servers...)...)
+		logger.Audit("dns", "set", service, value, strings.Join(servers, " "), err)
+		if err != nil {
+			restoreDNS(tunName, saved)
+			return nil, err
+		}
+		saved[service] = value
+	}
+	if len(saved) == 1 {
+		return nil, fmt.Errorf("none of the network services %s exists", strings.Join(dnsServices, ", "))
+	}
+	return saved, nil
+}
+
+// currentDNS 各服务的 DNS 一致时返回该列表，不一致（部分服务被改回）时返回全部服务的列表依次相接
+func currentDNS(tunName string, saved map[string]string) ([]string, error) {
+	var first, all []string
+	seen, same := false, true
+	for _, service := range dnsServices {
+		if _, ok := saved[service]; !ok {
+			continue
+		}
+		servers, err := getDNSServers(service)
+		if err != nil {
+			return nil, err
+		}
+		if !seen {
+			first, seen = servers, true
+		} else if strings.Join(servers, " ") != strings.Join(first, " ") {
+			same = false
+		}
+		all = append(all, servers...)
+	}
+	if same {
+		return first, nil
+	}
+	return all, nil
+}
+
+func restoreDNS(tunName string, saved map[string]string) error {
+	var firstErr error
+	for _, service := range dnsServices {
+		value, ok := saved[service]
+		if !ok {
+			continue
+		}
+		err := networksetup(append([]string{"-setdnsservers", service}, strings.Fields(value)...)...)
+		logger.Audit("dns", "restore", service, "", value, err)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// getDNSServers 手动设置的 DNS，没有设置时为空；服务不存在时返回错误
+func getDNSServers(service string) ([]string, error) {
+	out, err := exec.Command("networksetup", "-getdnsservers", service).CombinedOutput()
+	if err != nil || strings.Contains(string(out), "Error") {
+		return nil, fmt.Errorf("networksetup -getdnsservers %s: %v: %s", service, err, strings.TrimSpace(string(out)))
+	}
+	return parseIPs(string(out)), nil
+}
+
+func networksetup(args ...string) error {
+	if out, err := exec.Command("networksetup", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("networksetup %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+//go:build linux
+
+package tun
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"proxy/utils/logger"
+)
+
+// Linux：resolv.conf 指向 systemd-resolved 的本机存根（127.0.0.53）时，经 resolvectl 为 TUN 网卡设置 DNS 与路由域 ~.，
+// 不改动 resolv.conf；否则改写 resolv.conf（是符号链接时替换为普通文件），保留其中的 search 与 options
+
+const resolvConf = "/etc/resolv.conf"
+
+// pushDNS 设置系统 DNS 并返回改动前的设置；previous 为重新设置时上一次的备份，原先的符号链接沿用其中的
+func pushDNS(tunName string, servers []string, previous map[string]string) (map[string]string, error) {
+	content, err := os.ReadFile(resolvConf)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if useResolved(string(content)) {
+		args := append([]string{"dns", tunName}, servers...)
+		if err = resolvectl(args...); err == nil {
+			err = resolvectl("domain", tunName, "~.")
+		}
+		logger.Audit("dns", "set", "resolvectl "+tunName, "", strings.Join(servers, " "), err)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{"backend": "resolved"}, nil
+	}
+
+	saved := map[string]string{"backend": "file", "content": string(content)}
+	if link, err := os.Readlink(resolvConf); err == nil {
+		saved["link"] = link
+	} else if previous["link"] != "" {
+		saved["link"] = previous["link"]
+	}
+	var b strings.Builder
+	b.WriteString("# written by celestial-ladder (tun.dns_push), restored on exit\n")
+	for _, s := range servers {
+		fmt.Fprintf(&b, "nameserver %s\n", s)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if f := strings.Fields(line); len(f) > 0 && (f[0] == "search" || f[0] == "domain" || f[0] == "options") {
+			b.WriteString(line + "\n")
+		}
+	}
+	err = writeResolvConf(b.String())
+	logger.Audit("dns", "set", resolvConf, strings.Join(parseNameservers(string(content)), " "), strings.Join(servers, " "), err)
+	if err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+func currentDNS(tunName string, saved map[string]string) ([]string, error) {
+	if saved["backend"] == "resolved" {
+		out, err := exec.Command("resolvectl", "dns", tunName).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("resolvectl dns %s: %w: %s", tunName, err, strings.TrimSpace(string(out)))
+		}
+		// Link 5 (clt0): 8.8.8.8 8.8.4.4
+		_, list, _ := strings.Cut(string(out), "):")
+		return parseIPs(list), nil
+	}
+	content, err := os.ReadFile(resolvConf)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return parseNameservers(string(content)), nil
+}
+
+func restoreDNS(tunName string, saved map[string]string) error {
+	switch saved["backend"] {
+	case "resolved":
+		// TUN 网卡已关闭时其上的设置随之消失
+		if _, err := os.Stat("/sys/class/net/" + tunName); err != nil {
+			return nil
+		}
+		err := resolvectl("revert", tunName)
+		logger.Audit("dns", "restore", "resolvectl "+tunName, "", "", err)
+		return err
+	case "file":
+		var err error
+		if link := saved["link"]; link != "" {
+			if err = os.Remove(resolvConf); err == nil || os.IsNotExist(err) {
+				err = os.Symlink(link, resolvConf)
+			}
+		} else {
+			err = writeResolvConf(saved["content"])
+		}
+		logger.Audit("dns", "restore", resolvConf, "", strings.Join(parseNameservers(saved["content"]), " "), err)
+		return err
+	}
+	return nil
+}
+
+// useResolved resolv.conf 指向 systemd-resolved 的本机存根且 resolvectl 可用
+func useResolved(content string) bool {
+	if _, err := exec.LookPath("resolvectl"); err != nil {
+		return false
+	}
+	for _, s := range parseNameservers(content) {
+		if s == "127.0.0.53" || s == "127.0.0.54" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeResolvConf 写入临时文件后替换，符号链接被替换为普通文件，不改动链接指向的文件
+func writeResolvConf(content string) error {
+	tmp := resolvConf + ".clt"
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, resolvConf); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func parseNameservers(content string) []string {
+	var servers []string
+	for _, line := range strings.Split(content, "\n") {
+		if f := strings.Fields(line); len(f) >= 2 && f[0] == "nameserver" {
+			servers = append(servers, parseIPs(f[1])...)
+		}
+	}
+	return servers
+}
+
+func resolvectl(args ...string) error {
+	if out, err := exec.Command("resolvectl", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("resolvectl %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
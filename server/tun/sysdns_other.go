@@ -0,0 +1,17 @@
+//go:build !linux && !windows && !darwin
+
+package tun
+
+import "errors"
+
+func pushDNS(tunName string, servers []string, previous map[string]string) (map[string]string, error) {
+	return nil, errors.New("tun.dns_push is not supported on this platform")
+}
+
+func currentDNS(tunName string, saved map[string]string) ([]string, error) {
+	return nil, errors.New("tun.dns_push is not supported on this platform")
+}
+
+func restoreDNS(tunName string, saved map[string]string) error {
+	return nil
+}
@@ -0,0 +1,53 @@
+//go:build windows
+
+package tun
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"proxy/utils/logger"
+)
+
+// Windows：为 TUN 网卡设置静态 DNS。物理网卡的 DNS 由 DHCP 下发不受影响；
+// TUN 网卡重建、被其他程序改写后其上的 DNS 丢失，由检查重新设置
+
+func pushDNS(tunName string, servers []string, previous map[string]string) (map[string]string, error) {
+	before, _ := currentDNS(tunName, nil)
+	err := netsh("interface", "ipv4", "set", "dnsservers", "name="+tunName, "source=static", "address="+servers[0], "register=none", "validate=no")
+	for i := 1; err == nil && i < len(servers); i++ {
+		err = netsh("interface", "ipv4", "add", "dnsservers", "name="+tunName, "address="+servers[i], "index="+strconv.Itoa(i+1), "validate=no")
+	}
+	logger.Audit("dns", "set", tunName, strings.Join(before, " "), strings.Join(servers, " "), err)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"backend": "netsh"}, nil
+}
+
+func currentDNS(tunName string, saved map[string]string) ([]string, error) {
+	out, err := exec.Command("netsh", "interface", "ipv4", "show", "dnsservers", "name="+tunName).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("netsh show dnsservers %s: %w: %s", tunName, err, strings.TrimSpace(string(out)))
+	}
+	return parseIPs(string(out)), nil
+}
+
+// restoreDNS TUN 网卡关闭后其上的设置随之消失，网卡仍在时改回 DHCP
+func restoreDNS(tunName string, saved map[string]string) error {
+	if _, err := currentDNS(tunName, saved); err != nil {
+		return nil
+	}
+	err := netsh("interface", "ipv4", "set", "dnsservers", "name="+tunName, "source=dhcp")
+	logger.Audit("dns", "restore", tunName, "", "dhcp", err)
+	return err
+}
+
+func netsh(args ...string) error {
+	if out, err := exec.Command("netsh", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("netsh %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -1,29 +1,84 @@
-// tun2socks.go - 使用 tun2socks engine 的 TUN 实现
-// 这是最成熟可靠的方案，直接复用 tun2socks 的完整实现
+// tun2socks.go - 使用 tun2socks 协议栈的 TUN 实现
+// 直接组装 tun2socks 的设备、gVisor 协议栈与 SOCKS5 转发，不经 engine 包：engine.Start 失败时直接退出进程，
+// 无法检查就绪与失败后重启
 package tun
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"os/exec"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/xjasonlyu/tun2socks/v2/engine"
+	"github.com/xjasonlyu/tun2socks/v2/core"
+	"github.com/xjasonlyu/tun2socks/v2/core/device"
+	t2sTun "github.com/xjasonlyu/tun2socks/v2/core/device/tun"
+	M "github.com/xjasonlyu/tun2socks/v2/metadata"
+	"github.com/xjasonlyu/tun2socks/v2/proxy"
+	"github.com/xjasonlyu/tun2socks/v2/tunnel"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
 
 	"proxy/config"
+	"proxy/server/memory"
 	utilContext "proxy/utils/context"
 	"proxy/utils/logger"
 )
 
-// Tun2SocksService 使用 tun2socks engine 的 TUN 服务
+const (
+	readyTimeout   = 5 * time.Second // 创建设备后等待网卡启用与协议栈就绪的最长时间
+	healthInterval = 5 * time.Second // 运行中检查网卡与协议栈的间隔
+	maxRestarts    = 5               // 连续重启失败的次数上限，超过后放弃，需重新开启 TUN
+	restartBackoff = time.Second     // 第一次重启前的等待，之后每次翻倍
+)
+
+// udpNoTimeout timeouts.udp_session 为 0（不限）时 TUN 的 UDP 会话超时
+const udpNoTimeout = 365 * 24 * time.Hour
+
+// errMemoryPressure 内存占用达到 memory.high_water 时新建的 TUN 连接被拒绝
+var errMemoryPressure = errors.New("memory usage is high, connection rejected")
+
+// admitDialer 内存紧张时拒绝 TUN 上的新连接，协议栈随即重置该连接，已建立的连接不受影响
+type admitDialer struct {
+	proxy.Dialer
+}
+
+func (d admitDialer) DialContext(ctx context.Context, metadata *M.Metadata) (net.Conn, error) {
+	if !memory.Admit() {
+		return nil, errMemoryPressure
+	}
+	return d.Dialer.DialContext(ctx, metadata)
+}
+
+func (d admitDialer) DialUDP(metadata *M.Metadata) (net.PacketConn, error) {
+	if !memory.Admit() {
+		return nil, errMemoryPressure
+	}
+	return d.Dialer.DialUDP(metadata)
+}
+
+// Tun2SocksService 使用 tun2socks 协议栈的 TUN 服务：创建设备后检查就绪，运行中定期检查，
+// 设备或协议栈失效时自动重建
 type Tun2SocksService struct {
 	tunName    string
 	socks5Addr string
 	tunIP      net.IP
 	tunMask    net.IPMask
 	mtu        int
-	ctx        *utilContext.Context
-	started    bool
+	ctx        context.Context
+
+	mu      sync.Mutex
+	dev     device.Device
+	stack   *stack.Stack
+	died    chan struct{} // 设备的收发协程退出时关闭
+	started atomic.Bool   // Start 之后、Stop 之前
+	healthy atomic.Bool   // 设备与协议栈正常
+	stop    chan struct{}
+	done    chan struct{}
 }
 
 // NewTun2SocksService 创建新的 tun2socks 服务
@@ -38,71 +93,41 @@ func NewTun2SocksService(tunName string, socks5Addr string, tunIP net.IP, tunMas
 	}
 }
 
-// Start 启动 tun2socks 服务
+// Start 创建 TUN 设备与协议栈，就绪后返回，并开始监控
 func (s *Tun2SocksService) Start() error {
-	if s.started {
+	if s.started.Load() {
 		return nil
 	}
-
-	// 构建设备字符串
-	deviceStr := s.buildDeviceString()
-	
-	// 构建代理字符串 (SOCKS5)
-	proxyStr := fmt.Sprintf("socks5://%s", s.socks5Addr)
-
-	// 构建 IP 配置命令（TUN 创建后执行）
-	postUpCmd := s.buildPostUpCommand()
-
-	// 创建 engine.Key 配置
-	key := &engine.Key{
-		Device:     deviceStr,
-		Proxy:      proxyStr,
-		MTU:        s.mtu,
-		LogLevel:   "info",
-		UDPTimeout: 5 * time.Minute,
-		TUNPostUp:  postUpCmd,
+	if err := s.startEngine(); err != nil {
+		return err
 	}
-
-	// 加载配置
-	engine.Insert(key)
-
-	// 启动 engine（这会创建 TUN 设备和 gvisor 栈）
-	// 注意：Start() 会调用 log.Fatalf，这里需要处理
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				logger.Error(s.ctx, map[string]interface{}{
-					"action": config.ActionRuntime,
-					"error":  r,
-				}, "tun2socks engine panic")
-			}
-		}()
-		engine.Start()
-	}()
-
-	// 等待一小段时间让 engine 启动
-	time.Sleep(500 * time.Millisecond)
-
-	s.started = true
+	s.started.Store(true)
+	s.stop, s.done = make(chan struct{}), make(chan struct{})
+	go s.supervise(s.stop, s.done)
 
 	logger.Info(s.ctx, map[string]interface{}{
-		"action":  config.ActionRuntime,
-		"device":  deviceStr,
-		"proxy":   proxyStr,
-		"tunIP":   s.tunIP.String(),
+		"action": config.ActionRuntime,
+		"device": s.tunName,
+		"proxy":  s.socks5Addr,
+		"tunIP":  s.tunIP.String(),
 	}, "tun2socks service started")
 
 	return nil
 }
 
-// Stop 停止 tun2socks 服务
+// Running 设备与协议栈是否正常运行
+func (s *Tun2SocksService) Running() bool {
+	return s.healthy.Load()
+}
+
+// Stop 停止监控并关闭设备与协议栈
 func (s *Tun2SocksService) Stop() error {
-	if !s.started {
+	if !s.started.Swap(false) {
 		return nil
 	}
-
-	engine.Stop()
-	s.started = false
+	close(s.stop)
+	<-s.done
+	s.closeEngine()
 
 	logger.Info(s.ctx, map[string]interface{}{
 		"action": config.ActionRuntime,
@@ -111,40 +136,199 @@ func (s *Tun2SocksService) Stop() error {
 	return nil
 }
 
-// buildDeviceString 构建 tun2socks 设备字符串
-// 格式: tun://tunName
-func (s *Tun2SocksService) buildDeviceString() string {
+// startEngine 创建设备、协议栈并配置网卡地址，等待就绪
+func (s *Tun2SocksService) startEngine() (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ones, _ := s.tunMask.Size()
+	defer func() {
+		logger.Audit("tun", "create", s.tunName, "", fmt.Sprintf("%s/%d mtu %d", s.tunIP, ones, s.mtu), err)
+	}()
+
+	dialer, err := proxy.NewSocks5(s.socks5Addr, "", "")
+	if err != nil {
+		return fmt.Errorf("socks5 %s: %w", s.socks5Addr, err)
+	}
+	tunnel.T().SetDialer(admitDialer{dialer})
+	// tun2socks 的 UDP 会话必须有超时，timeouts.udp_session 为 0（不限）时取一个足够长的时间
+	timeout := config.UDPSessionTimeout()
+	if timeout == 0 {
+		timeout = udpNoTimeout
+	}
+	tunnel.T().SetUDPTimeout(timeout)
+
+	dev, err := t2sTun.Open(s.tunName, uint32(s.mtu))
+	if err != nil {
+		return err
+	}
+	st, err := core.CreateStack(&core.Config{LinkEndpoint: dev, TransportHandler: tunnel.T()})
+	if err != nil {
+		dev.Close()
+		return fmt.Errorf("create stack: %w", err)
+	}
+	died := make(chan struct{})
+	go func() {
+		dev.Wait()
+		close(died)
+	}()
+	s.dev, s.stack, s.died = dev, st, died
+
+	if err = s.configureAddress(); err == nil {
+		err = s.waitReady()
+	}
+	if err != nil {
+		s.closeEngineLocked()
+		return err
+	}
+	s.healthy.Store(true)
+	return nil
+}
+
+// configureAddress 为网卡配置地址并启用
+func (s *Tun2SocksService) configureAddress() error {
+	ones, _ := s.tunMask.Size()
+	ip, name := s.tunIP.String(), s.tunName
+	var cmds [][]string
 	switch runtime.GOOS {
 	case "windows":
-		// Windows 使用 wintun，设备名作为 host
-		return fmt.Sprintf("tun://%s", s.tunName)
+		cmds = [][]string{{"netsh", "interface", "ip", "set", "address", "name=" + name, "static", ip, net.IP(s.tunMask).String()}}
 	case "darwin":
-		// macOS 使用 utun
-		return fmt.Sprintf("tun://%s", s.tunName)
+		cmds = [][]string{{"ifconfig", name, "inet", fmt.Sprintf("%s/%d", ip, ones), ip, "up"}}
 	default:
-		// Linux 使用 tun
-		return fmt.Sprintf("tun://%s", s.tunName)
+		cmds = [][]string{
+			{"ip", "addr", "replace", fmt.Sprintf("%s/%d", ip, ones), "dev", name},
+			{"ip", "link", "set", name, "up"},
+		}
 	}
+	for _, args := range cmds {
+		if out, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+			return fmt.Errorf("%s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
 }
 
-// buildPostUpCommand 构建 TUN 设备 IP 配置命令
-// 在 TUN 设备创建后执行，配置 IP 地址
-func (s *Tun2SocksService) buildPostUpCommand() string {
-	ones, _ := s.tunMask.Size()
-	ip := s.tunIP.String()
-	name := s.tunName
+// waitReady 等待网卡启用、协议栈的网卡可用，超过 readyTimeout 返回最后一次检查的错误
+func (s *Tun2SocksService) waitReady() error {
+	deadline := time.Now().Add(readyTimeout)
+	for {
+		err := s.check()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
 
-	switch runtime.GOOS {
-	case "windows":
-		// Windows 使用 netsh 配置 IP
-		// netsh interface ip set address "接口名" static IP掩码
-		mask := net.IP(s.tunMask).String()
-		return fmt.Sprintf("netsh interface ip set address \"%s\" static %s %s", name, ip, mask)
-	case "darwin":
-		// macOS 使用 ifconfig
-		return fmt.Sprintf("ifconfig %s inet %s/%d %s up", name, ip, ones, ip)
+// check 检查设备的收发协程仍在运行、系统网卡存在且已启用、协议栈的网卡可用，调用方需持有 mu
+func (s *Tun2SocksService) check() error {
+	select {
+	case <-s.died:
+		return errors.New("device stopped")
 	default:
-		// Linux 使用 ip 命令
-		return fmt.Sprintf("ip addr add %s/%d dev %s && ip link set %s up", ip, ones, name, name)
 	}
+	iface, err := net.InterfaceByName(s.tunName)
+	if err != nil {
+		return fmt.Errorf("interface %s: %w", s.tunName, err)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return fmt.Errorf("interface %s is down", s.tunName)
+	}
+	for id := range s.stack.NICInfo() {
+		if s.stack.CheckNIC(id) {
+			return nil
+		}
+	}
+	return errors.New("stack NIC is not enabled")
+}
+
+// closeEngine 关闭协议栈与设备
+func (s *Tun2SocksService) closeEngine() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeEngineLocked()
+}
+
+func (s *Tun2SocksService) closeEngineLocked() {
+	s.healthy.Store(false)
+	if s.stack == nil {
+		return
+	}
+	s.stack.Close()
+	s.dev.Close()
+	s.stack.Wait()
+	s.stack, s.dev = nil, nil
+	logger.Audit("tun", "close", s.tunName, "", "", nil)
+}
+
+// supervise 每 healthInterval 检查一次，设备退出或检查失败时关闭并重建，连续失败 maxRestarts 次后放弃
+func (s *Tun2SocksService) supervise(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		died := s.died
+		s.mu.Unlock()
+		select {
+		case <-stop:
+			return
+		case <-died:
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		err := s.check()
+		s.mu.Unlock()
+		if err == nil {
+			continue
+		}
+		s.healthy.Store(false)
+		logger.Error(s.ctx, map[string]interface{}{
+			"action":    config.ActionRuntime,
+			"errorCode": logger.ErrCodeDefault,
+			"device":    s.tunName,
+			"error":     err,
+		}, "TUN device unhealthy, restarting")
+		s.closeEngine()
+		if !s.restart(stop) {
+			return
+		}
+	}
+}
+
+// restart 按指数退避重建设备与协议栈，成功返回 true；收到 stop 或连续失败 maxRestarts 次返回 false
+func (s *Tun2SocksService) restart(stop chan struct{}) bool {
+	backoff := restartBackoff
+	for attempt := 1; attempt <= maxRestarts; attempt++ {
+		select {
+		case <-stop:
+			return false
+		case <-time.After(backoff):
+		}
+		err := s.startEngine()
+		if err == nil {
+			logger.Warn(s.ctx, map[string]interface{}{
+				"action":  config.ActionRuntime,
+				"device":  s.tunName,
+				"attempt": attempt,
+			}, "TUN device restarted")
+			return true
+		}
+		logger.Error(s.ctx, map[string]interface{}{
+			"action":    config.ActionRuntime,
+			"errorCode": logger.ErrCodeDefault,
+			"device":    s.tunName,
+			"attempt":   attempt,
+			"error":     err,
+		}, "restart TUN device failed")
+		backoff *= 2
+	}
+	logger.Error(s.ctx, map[string]interface{}{
+		"action":    config.ActionRuntime,
+		"errorCode": logger.ErrCodeDefault,
+		"device":    s.tunName,
+		"attempts":  maxRestarts,
+	}, "giving up restarting TUN device, re-enable TUN to retry")
+	return false
 }
@@ -8,6 +8,8 @@ import (
 	"os/exec"
 
 	"golang.zx2c4.com/wireguard/tun"
+
+	"proxy/utils/logger"
 )
 
 func newDevice(config *Config) (Device, error) {
@@ -111,8 +113,13 @@ func configureWindows(dev tun.Device, config *Config) error {
 		maskStr,
 		"none",
 	)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("configure TUN IP failed: %w, output: %s", err, string(out))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		err = fmt.Errorf("configure TUN IP failed: %w, output: %s", err, string(out))
+	}
+	logger.Audit("tun", "set", name+" address", "", fmt.Sprintf("%s/%d", ipAddr, prefixLen), err)
+	if err != nil {
+		return err
 	}
 
 	// 配置 DNS（如果有）
@@ -127,8 +134,13 @@ func configureWindows(dev tun.Device, config *Config) error {
 				dns0.String(),
 				"primary",
 			)
-			if out, err := cmdDNS.CombinedOutput(); err != nil {
-				return fmt.Errorf("configure TUN DNS failed: %w, output: %s", err, string(out))
+			out, err := cmdDNS.CombinedOutput()
+			if err != nil {
+				err = fmt.Errorf("configure TUN DNS failed: %w, output: %s", err, string(out))
+			}
+			logger.Audit("tun", "set", name+" dns", "", dns0.String(), err)
+			if err != nil {
+				return err
 			}
 		}
 		// 追加其他 DNS
@@ -143,7 +155,8 @@ func configureWindows(dev tun.Device, config *Config) error {
 				dnsIP.String(),
 				"index=2",
 			)
-			_, _ = cmdDNS.CombinedOutput() // 失败不致命，忽略错误
+			_, err := cmdDNS.CombinedOutput() // 失败不致命，忽略错误
+			logger.Audit("tun", "add", name+" dns", "", dnsIP.String(), err)
 		}
 	}
 
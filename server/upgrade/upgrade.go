@@ -0,0 +1,122 @@
+// Package upgrade 平滑重启：新进程继承旧进程的监听套接字，启动完成后通知旧进程，
+// 旧进程随即停止接受连接，等待在途连接结束后退出，升级与切换入口类型时不中断已建立的隧道
+package upgrade
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	envFDs   = "CLT_UPGRADE_FDS"   // 继承的监听地址，逗号分隔，依次对应 fd 3、4…
+	envReady = "CLT_UPGRADE_READY" // 新进程启动完成后写入一个字节的管道 fd
+)
+
+// ErrNotSupported 当前系统不支持平滑重启
+var ErrNotSupported = errors.New("graceful restart is not supported on this platform")
+
+var (
+	mu        sync.Mutex
+	inherited = make(map[string]net.Listener) // 从旧进程继承、尚未使用的监听
+	active    = make(map[string]*listener)    // 当前打开的监听，平滑重启时传给新进程
+)
+
+func init() {
+	addrs := os.Getenv(envFDs)
+	if addrs == "" {
+		return
+	}
+	_ = os.Unsetenv(envFDs)
+	for i, addr := range strings.Split(addrs, ",") {
+		f := os.NewFile(uintptr(3+i), addr)
+		l, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			continue
+		}
+		inherited[addr] = l
+	}
+}
+
+// listener 记录在 active 中的监听，关闭时移除
+type listener struct {
+	net.Listener
+	addr string
+}
+
+// SyscallConn 内层监听的原始套接字，供设置 TCP_FASTOPEN 等选项
+func (l *listener) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := l.Listener.(syscall.Conn)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return sc.SyscallConn()
+}
+
+func (l *listener) Close() error {
+	mu.Lock()
+	if active[l.addr] == l {
+		delete(active, l.addr)
+	}
+	mu.Unlock()
+	return l.Listener.Close()
+}
+
+// Listen 监听 TCP 地址，旧进程传下来同一地址的监听时直接复用
+func Listen(addr string) (net.Listener, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	l, ok := inherited[addr]
+	if ok {
+		delete(inherited, addr)
+	} else {
+		var err error
+		if l, err = net.Listen("tcp", addr); err != nil {
+			return nil, err
+		}
+	}
+	wrapped := &listener{Listener: l, addr: addr}
+	active[addr] = wrapped
+	return wrapped, nil
+}
+
+// CloseAll 关闭当前打开的全部监听，平滑重启成功后由旧进程调用，让新连接都进入新进程
+func CloseAll() {
+	mu.Lock()
+	list := make([]*listener, 0, len(active))
+	for _, l := range active {
+		list = append(list, l)
+	}
+	mu.Unlock()
+	for _, l := range list {
+		_ = l.Close()
+	}
+}
+
+// Ready 通知旧进程新进程已启动完成，并关闭没有用到的继承监听；不是由平滑重启启动时什么也不做
+func Ready() error {
+	mu.Lock()
+	for addr, l := range inherited {
+		_ = l.Close()
+		delete(inherited, addr)
+	}
+	mu.Unlock()
+	raw := os.Getenv(envReady)
+	if raw == "" {
+		return nil
+	}
+	_ = os.Unsetenv(envReady)
+	fd, err := strconv.Atoi(raw)
+	if err != nil {
+		return err
+	}
+	f := os.NewFile(uintptr(fd), "upgrade-ready")
+	defer f.Close()
+	_, err = f.Write([]byte{1})
+	return err
+}
@@ -0,0 +1,84 @@
+//go:build !windows
+
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Upgrade 以相同的参数启动新进程并传入当前的全部监听，等待新进程启动完成；
+// 成功时返回新进程的 pid，调用方应停止接受连接，等待在途连接结束后退出；失败时新进程已退出，当前进程照常运行
+func Upgrade(timeout time.Duration) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	mu.Lock()
+	addrs := make([]string, 0, len(active))
+	files := make([]*os.File, 0, len(active))
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	for addr, l := range active {
+		tl, ok := l.Listener.(*net.TCPListener)
+		if !ok {
+			continue
+		}
+		f, err := tl.File()
+		if err != nil {
+			mu.Unlock()
+			return 0, err
+		}
+		addrs = append(addrs, addr)
+		files = append(files, f)
+	}
+	mu.Unlock()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, w)
+	cmd.Env = append(os.Environ(),
+		envFDs+"="+strings.Join(addrs, ","),
+		fmt.Sprintf("%s=%d", envReady, 3+len(files)),
+	)
+	err = cmd.Start()
+	_ = w.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	// 新进程写入一个字节表示启动完成，未写入就退出时读到 EOF
+	ready := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err := <-ready:
+		if err != nil {
+			_ = cmd.Wait()
+			return 0, errors.New("new process exited before it was ready")
+		}
+		go func() {
+			_ = cmd.Wait()
+		}()
+		return cmd.Process.Pid, nil
+	case <-time.After(timeout):
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return 0, errors.New("timeout waiting for new process")
+	}
+}
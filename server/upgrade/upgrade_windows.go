@@ -0,0 +1,10 @@
+//go:build windows
+
+package upgrade
+
+import "time"
+
+// Upgrade Windows 下无法把监听套接字传给子进程
+func Upgrade(timeout time.Duration) (int, error) {
+	return 0, ErrNotSupported
+}
@@ -0,0 +1,96 @@
+//go:build darwin
+
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	launchdLabel = "com." + serviceName
+	plistPath    = "/Library/LaunchDaemons/" + launchdLabel + ".plist"
+)
+
+// plistTemplate LaunchDaemon：开机运行，异常退出后重启，正常退出（launchctl stop）不再拉起
+const plistTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>{{exe}}</string>
+		<string>-c</string>
+		<string>{{config}}</string>
+	</array>
+	<key>WorkingDirectory</key>
+	<string>{{dir}}</string>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>StandardErrorPath</key>
+	<string>{{stderr}}</string>
+</dict>
+</plist>
+`
+
+// runServiceCommand proxy service plist|install|uninstall|start|stop
+func runServiceCommand(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: proxy [-c config.json] service plist|install|uninstall|start|stop")
+		return 2
+	}
+	var err error
+	switch args[0] {
+	case "plist":
+		var plist string
+		if plist, err = launchdPlist(); err == nil {
+			fmt.Print(plist)
+			return 0
+		}
+	case "install":
+		var plist string
+		if plist, err = launchdPlist(); err == nil {
+			if err = os.WriteFile(plistPath, []byte(plist), 0644); err == nil {
+				err = runTool("launchctl", "load", "-w", plistPath)
+			}
+		}
+	case "uninstall":
+		_ = runTool("launchctl", "unload", "-w", plistPath)
+		err = os.Remove(plistPath)
+	case "start", "stop":
+		err = runTool("launchctl", args[0], launchdLabel)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown service command: %s\n", args[0])
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "service %s failed: %v\n", args[0], err)
+		return 1
+	}
+	fmt.Printf("service %s: %s ok\n", launchdLabel, args[0])
+	return 0
+}
+
+func launchdPlist() (string, error) {
+	exe, cfg, dir, err := serviceTarget()
+	if err != nil {
+		return "", err
+	}
+	return strings.NewReplacer(
+		"{{label}}", launchdLabel,
+		"{{exe}}", html.EscapeString(exe),
+		"{{config}}", html.EscapeString(cfg),
+		"{{dir}}", html.EscapeString(dir),
+		"{{stderr}}", html.EscapeString(filepath.Join(dir, "launchd.err.log")),
+	).Replace(plistTemplate), nil
+}
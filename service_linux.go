@@ -0,0 +1,102 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const unitPath = "/etc/systemd/system/" + serviceName + ".service"
+
+// unitTemplate systemd unit：Type=notify 等待就绪，SIGHUP 重载，SIGTERM 优雅退出；
+// TUN 与路由需要 CAP_NET_ADMIN，改为非 root 用户运行时由 AmbientCapabilities 提供
+const unitTemplate = `[Unit]
+Description=CelestialLadder Proxy
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=notify
+NotifyAccess=all
+ExecStart={{exe}} -c {{config}}
+ExecReload=/bin/kill -HUP $MAINPID
+WorkingDirectory={{dir}}
+Restart=on-failure
+RestartSec=5
+TimeoutStopSec=60
+AmbientCapabilities=CAP_NET_ADMIN CAP_NET_BIND_SERVICE CAP_NET_RAW
+LimitNOFILE=65535
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// runServiceCommand proxy service unit|install|uninstall|start|stop
+func runServiceCommand(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: proxy [-c config.json] service unit|install|uninstall|start|stop")
+		return 2
+	}
+	var err error
+	switch args[0] {
+	case "unit":
+		var unit string
+		if unit, err = systemdUnit(); err == nil {
+			fmt.Print(unit)
+			return 0
+		}
+	case "install":
+		err = installUnit()
+	case "uninstall":
+		_ = runTool("systemctl", "disable", "--now", serviceName)
+		if err = os.Remove(unitPath); err == nil {
+			err = runTool("systemctl", "daemon-reload")
+		}
+	case "start", "stop":
+		err = runTool("systemctl", args[0], serviceName)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown service command: %s\n", args[0])
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "service %s failed: %v\n", args[0], err)
+		return 1
+	}
+	fmt.Printf("service %s: %s ok\n", serviceName, args[0])
+	return 0
+}
+
+func systemdUnit() (string, error) {
+	exe, cfg, dir, err := serviceTarget()
+	if err != nil {
+		return "", err
+	}
+	return strings.NewReplacer(
+		"{{exe}}", systemdQuote(exe),
+		"{{config}}", systemdQuote(cfg),
+		"{{dir}}", strings.ReplaceAll(dir, "%", "%%"),
+	).Replace(unitTemplate), nil
+}
+
+// systemdQuote 按 systemd 的规则给 ExecStart 的参数加双引号：反斜杠与双引号转义，
+// % 与 $ 写两遍，避免路径中的空格拆分参数或字符被当作说明符、环境变量展开
+func systemdQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$").Replace(s) + `"`
+}
+
+// installUnit 写入 unit 文件并设为开机启动，需要 root 权限
+func installUnit() error {
+	unit, err := systemdUnit()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(unitPath, []byte(unit), 0644); err != nil {
+		return err
+	}
+	if err := runTool("systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+	return runTool("systemctl", "enable", serviceName)
+}
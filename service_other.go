@@ -0,0 +1,13 @@
+//go:build !windows && !linux && !darwin
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func runServiceCommand(args []string) int {
+	fmt.Fprintln(os.Stderr, "service command is not supported on this platform")
+	return 2
+}
@@ -0,0 +1,60 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"proxy/config"
+	"proxy/server"
+)
+
+func inService() bool {
+	return false
+}
+
+func runAsService(ctx context.Context, p *server.Proxy) int {
+	return 0
+}
+
+// notifyReady 由 systemd（Type=notify）启动时通过 NOTIFY_SOCKET 报告就绪；
+// 平滑重启的新进程同时报告 MAINPID，unit 中需设置 NotifyAccess=all
+func notifyReady() {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return
+	}
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	_, _ = fmt.Fprintf(conn, "READY=1\nMAINPID=%d", os.Getpid())
+}
+
+// serviceTarget 服务启动的可执行文件、配置文件与工作目录（配置文件所在目录），均为绝对路径
+func serviceTarget() (exe, cfg, dir string, err error) {
+	if exe, err = os.Executable(); err != nil {
+		return
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return
+	}
+	cfg = config.Path()
+	return exe, cfg, filepath.Dir(cfg), nil
+}
+
+// runTool 执行 systemctl / launchctl，输出直接打印到终端
+func runTool(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
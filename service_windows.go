@@ -0,0 +1,192 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+
+	"proxy/config"
+	"proxy/server"
+	"proxy/utils/logger"
+)
+
+const serviceDisplayName = "CelestialLadder Proxy"
+
+// runServiceCommand proxy service install|uninstall|start|stop
+func runServiceCommand(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: proxy [-c config.json] service install|uninstall|start|stop")
+		return 2
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connect to service manager failed (run as administrator): %v\n", err)
+		return 1
+	}
+	defer m.Disconnect()
+	switch args[0] {
+	case "install":
+		err = installService(m)
+	case "uninstall":
+		err = uninstallService(m)
+	case "start":
+		err = startService(m)
+	case "stop":
+		err = stopService(m)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown service command: %s\n", args[0])
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "service %s failed: %v\n", args[0], err)
+		return 1
+	}
+	fmt.Printf("service %s: %s ok\n", serviceName, args[0])
+	return 0
+}
+
+// installService 注册为开机自启的服务，以当前配置文件的绝对路径启动，异常退出后自动重启
+func installService(m *mgr.Mgr) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.Abs(exe); err != nil {
+		return err
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: serviceDisplayName,
+		Description: "CelestialLadder local proxy (SOCKS5/HTTP, TUN, system proxy)",
+		StartType:   mgr.StartAutomatic,
+	}, "-c", config.Path())
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return s.SetRecoveryActions([]mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+	}, 24*60*60)
+}
+
+func uninstallService(m *mgr.Mgr) error {
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return s.Delete()
+}
+
+func startService(m *mgr.Mgr) error {
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return s.Start()
+}
+
+// stopService 发送停止请求并等待服务停止，服务会先等待在途连接结束并恢复路由与系统代理
+func stopService(m *mgr.Mgr) error {
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(time.Minute)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout waiting for service to stop")
+		}
+		time.Sleep(300 * time.Millisecond)
+		if status, err = s.Query(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyReady 服务管理器通过 Execute 中的状态得知就绪，这里不需要额外通知
+func notifyReady() {}
+
+// inService 是否由服务管理器启动
+func inService() bool {
+	ok, err := svc.IsWindowsService()
+	return err == nil && ok
+}
+
+// runAsService 以服务方式运行，收到停止或关机请求时执行与 Ctrl+C 相同的优雅关闭
+func runAsService(ctx context.Context, p *server.Proxy) int {
+	h := &serviceHandler{ctx: ctx, p: p}
+	if err := svc.Run(serviceName, h); err != nil {
+		logger.Error(ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+			"error":  err,
+		}, "run as service failed")
+		return 1
+	}
+	return h.code
+}
+
+// serviceHandler 把服务管理器的控制请求转换为 Proxy 的生命周期
+type serviceHandler struct {
+	ctx  context.Context
+	p    *server.Proxy
+	code int
+}
+
+func (h *serviceHandler) Execute(args []string, r <-chan svc.ChangeRequest, s chan<- svc.Status) (bool, uint32) {
+	s <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- h.p.Run(ctx)
+	}()
+	s <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+loop:
+	for {
+		select {
+		case err := <-runErr:
+			if err != nil {
+				h.code = 1
+			}
+			break loop
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				s <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				logger.Info(h.ctx, map[string]interface{}{
+					"action": config.ActionRuntime,
+				}, "Received service stop request, gracefully shutting down...")
+				break loop
+			}
+		}
+	}
+
+	s <- svc.Status{State: svc.StopPending}
+	cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), h.p.GracePeriod()+30*time.Second)
+	defer shutdownCancel()
+	if err := h.p.Shutdown(shutdownCtx); err != nil {
+		logger.Warn(h.ctx, map[string]interface{}{
+			"action": config.ActionRuntime,
+		}, "Shutdown timeout, forcing exit")
+	}
+	s <- svc.Status{State: svc.Stopped}
+	return false, uint32(h.code)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"proxy/config"
+	"proxy/server/admin"
+)
+
+// runStatus 经控制套接字查询运行中的实例并输出运行概况
+func runStatus(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the raw JSON")
+	socket := fs.String("socket", config.ControlSocket(), "control socket of the running instance")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *socket == "" {
+		fmt.Fprintln(os.Stderr, "control socket is disabled (admin.socket is -)")
+		return 1
+	}
+	body, err := querySummary(*socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can not reach the running instance at %s (is the proxy running?): %v\n", *socket, err)
+		return 1
+	}
+	if *asJSON {
+		_, _ = os.Stdout.Write(body)
+		return 0
+	}
+	var s admin.Summary
+	if err := json.Unmarshal(body, &s); err != nil {
+		fmt.Fprintf(os.Stderr, "unexpected response: %v\n", err)
+		return 1
+	}
+	printSummary(os.Stdout, s)
+	return 0
+}
+
+// querySummary 经 Unix 域套接字请求 /api/summary
+func querySummary(socket string) ([]byte, error) {
+	c := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	resp, err := c.Get("http://control/api/summary")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	return body, nil
+}
+
+func printSummary(w io.Writer, s admin.Summary) {
+	fmt.Fprintf(w, "uptime:       %s (since %s)\n", time.Duration(s.Uptime)*time.Second, s.StartTime)
+	fmt.Fprintf(w, "mode:         %s\n", s.Mode)
+	fmt.Fprintf(w, "inbound:      type %d, port %d\n", s.InType, s.InPort)
+	outbound := fmt.Sprintf("type %d", s.OutType)
+	if s.RemoteAddr != "" {
+		outbound += ", " + s.RemoteAddr
+		if s.RemoteDown {
+			outbound += " (unreachable)"
+		}
+	}
+	fmt.Fprintf(w, "outbound:     %s\n", outbound)
+	fmt.Fprintf(w, "tun:          %v\n", s.Tun)
+	fmt.Fprintf(w, "connections:  %d\n", s.Connections)
+	fmt.Fprintf(w, "log level:    %s\n", s.LogLevel)
+	if len(s.Health) > 0 {
+		fmt.Fprintln(w, "\nlast handshake per remote:")
+		for _, h := range s.Health {
+			result := fmt.Sprintf("%.1fms", h.Latency)
+			if h.Error != "" {
+				result = "failed: " + h.Error
+			}
+			fmt.Fprintf(w, "  %-20s %s  %s\n", h.Remote, h.Time, result)
+		}
+	}
+	if len(s.Errors) > 0 {
+		fmt.Fprintln(w, "\nrecent warnings and errors:")
+		for _, r := range s.Errors {
+			line := r.Message
+			if r.Error != "" {
+				if line != "" {
+					line += ": "
+				}
+				line += r.Error
+			}
+			fmt.Fprintf(w, "  %s %-5s %s\n", r.Time, r.Level, line)
+		}
+	}
+}
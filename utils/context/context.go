@@ -1,207 +1,63 @@
+// Package context 在标准库 context.Context 上携带请求的 traceID 与开始时间，供日志与链路追踪使用；
+// 请求上下文都派生自进程级根 context，CancelAll 时一并取消
 package context
 
 import (
-	"sync"
+	"context"
 	"time"
 
 	"github.com/satori/go.uuid"
 )
 
-// Context used to transfer data
-type Context struct {
-	// This mutex protect Keys map
-	mu sync.RWMutex
-
-	// Keys is a key/value pair exclusively for the context of each request.
-	Keys map[string]interface{}
-}
-
-/************************************/
-/********** CONTEXT CREATION ********/
-/************************************/
-
-func (c *Context) reset() {
-	c.Keys = nil
-}
-
-// Copy returns a copy of the current context that can be safely used outside the request's scope.
-// This has to be used when the context has to be passed to a goroutine.
-func (c *Context) Copy() *Context {
-	cp := Context{}
-	cp.Keys = map[string]interface{}{}
-	for k, v := range c.Keys {
-		cp.Keys[k] = v
-	}
-	return &cp
-}
-
-// Set is used to store a new key/value pair exclusively for this context.
-// It also lazy initializes  c.Keys if it was not used previously.
-func (c *Context) Set(key string, value interface{}) {
-	c.mu.Lock()
-	if c.Keys == nil {
-		c.Keys = make(map[string]interface{})
-	}
-
-	c.Keys[key] = value
-	c.mu.Unlock()
-}
-
-// Get returns the value for the given key, ie: (value, true).
-// If the value does not exists it returns (nil, false)
-func (c *Context) Get(key string) (value interface{}, exists bool) {
-	c.mu.RLock()
-	value, exists = c.Keys[key]
-	c.mu.RUnlock()
-	return
-}
-
-// MustGet returns the value for the given key if it exists, otherwise it panics.
-func (c *Context) MustGet(key string) interface{} {
-	if value, exists := c.Get(key); exists {
-		return value
-	}
-	panic("Key \"" + key + "\" does not exist")
-}
-
-// GetString returns the value associated with the key as a string.
-func (c *Context) GetString(key string) (s string) {
-	if val, ok := c.Get(key); ok && val != nil {
-		s, _ = val.(string)
-	}
-	return
-}
-
-// GetBool returns the value associated with the key as a boolean.
-func (c *Context) GetBool(key string) (b bool) {
-	if val, ok := c.Get(key); ok && val != nil {
-		b, _ = val.(bool)
-	}
-	return
-}
-
-// GetInt returns the value associated with the key as an integer.
-func (c *Context) GetInt(key string) (i int) {
-	if val, ok := c.Get(key); ok && val != nil {
-		i, _ = val.(int)
-	}
-	return
-}
-
-// GetInt64 returns the value associated with the key as an integer.
-func (c *Context) GetInt64(key string) (i64 int64) {
-	if val, ok := c.Get(key); ok && val != nil {
-		i64, _ = val.(int64)
-	}
-	return
-}
-
-// GetUint returns the value associated with the key as an unsigned integer.
-func (c *Context) GetUint(key string) (ui uint) {
-	if val, ok := c.Get(key); ok && val != nil {
-		ui, _ = val.(uint)
-	}
-	return
-}
-
-// GetUint64 returns the value associated with the key as an unsigned integer.
-func (c *Context) GetUint64(key string) (ui64 uint64) {
-	if val, ok := c.Get(key); ok && val != nil {
-		ui64, _ = val.(uint64)
-	}
-	return
-}
-
-// GetFloat64 returns the value associated with the key as a float64.
-func (c *Context) GetFloat64(key string) (f64 float64) {
-	if val, ok := c.Get(key); ok && val != nil {
-		f64, _ = val.(float64)
-	}
-	return
-}
-
-// GetTime returns the value associated with the key as time.
-func (c *Context) GetTime(key string) (t time.Time) {
-	if val, ok := c.Get(key); ok && val != nil {
-		t, _ = val.(time.Time)
-	}
-	return
-}
+// traceIDKey、startTimeKey 请求上下文中 traceID 与开始时间的键，未导出的类型不会与其他包的键冲突
+type (
+	traceIDKey   struct{}
+	startTimeKey struct{}
+)
 
-// GetDuration returns the value associated with the key as a duration.
-func (c *Context) GetDuration(key string) (d time.Duration) {
-	if val, ok := c.Get(key); ok && val != nil {
-		d, _ = val.(time.Duration)
-	}
-	return
-}
+var root, cancelRoot = context.WithCancel(context.Background())
 
-// GetStringSlice returns the value associated with the key as a slice of strings.
-func (c *Context) GetStringSlice(key string) (ss []string) {
-	if val, ok := c.Get(key); ok && val != nil {
-		ss, _ = val.([]string)
-	}
-	return
+// NewContext 新建请求上下文，派生自进程级根 context，CancelAll 时一并取消
+func NewContext() context.Context {
+	return NewContextFrom(root)
 }
 
-// GetStringMap returns the value associated with the key as a map of interfaces.
-func (c *Context) GetStringMap(key string) (sm map[string]interface{}) {
-	if val, ok := c.Get(key); ok && val != nil {
-		sm, _ = val.(map[string]interface{})
-	}
-	return
+// NewContextFrom 以 parent 为父 context 新建请求上下文，生成新的 traceID 并记录开始时间
+func NewContextFrom(parent context.Context) context.Context {
+	ctx := context.WithValue(parent, traceIDKey{}, uuid.NewV4().String())
+	return context.WithValue(ctx, startTimeKey{}, time.Now())
 }
 
-// GetStringMapString returns the value associated with the key as a map of strings.
-func (c *Context) GetStringMapString(key string) (sms map[string]string) {
-	if val, ok := c.Get(key); ok && val != nil {
-		sms, _ = val.(map[string]string)
+// NewConnContext 为监听 accept 的连接新建请求上下文：沿用监听 ctx 中的值，但不随监听关闭（配置重载、开始退出）而取消，
+// 已建立的连接照常转发到结束，由 CancelAll 或返回的 cancel 取消；连接处理结束时需调用 cancel
+func NewConnContext(listener context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(listener))
+	stop := context.AfterFunc(root, cancel)
+	return NewContextFrom(ctx), func() {
+		stop()
+		cancel()
 	}
-	return
 }
 
-// GetStringMapStringSlice returns the value associated with the key as a map to a slice of strings.
-func (c *Context) GetStringMapStringSlice(key string) (smss map[string][]string) {
-	if val, ok := c.Get(key); ok && val != nil {
-		smss, _ = val.(map[string][]string)
+// TraceID 返回 ctx 携带的 traceID，没有时为空
+func TraceID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
 	}
-	return
-}
-
-// Deadline always returns that there is no deadline (ok==false),
-// maybe you want to use Request.Context().Deadline() instead.
-func (c *Context) Deadline() (deadline time.Time, ok bool) {
-	return
-}
-
-// Done always returns nil (chan which will wait forever),
-// if you want to abort your work when the connection was closed
-// you should use Request.Context().Done() instead.
-func (c *Context) Done() <-chan struct{} {
-	return nil
-}
-
-// Err always returns nil, maybe you want to use Request.Context().Err() instead.
-func (c *Context) Err() error {
-	return nil
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
 }
 
-// Value returns the value associated with this context for key, or nil
-// if no value is associated with key. Successive calls to Value with
-// the same key returns the same result.
-func (c *Context) Value(key interface{}) interface{} {
-	if keyAsString, ok := key.(string); ok {
-		val, _ := c.Get(keyAsString)
-		return val
+// StartTime 返回 ctx 所属请求的开始时间，ok 为 false 时 ctx 不是请求上下文
+func StartTime(ctx context.Context) (t time.Time, ok bool) {
+	if ctx == nil {
+		return time.Time{}, false
 	}
-	return nil
+	t, ok = ctx.Value(startTimeKey{}).(time.Time)
+	return t, ok
 }
 
-func NewContext() *Context {
-	now := time.Now()
-	gCtx := new(Context)
-	gCtx.Set("startTime", now)
-	gCtx.Set("startTimeUnix", now.Unix())
-	gCtx.Set("traceID", uuid.NewV4().String())
-	return gCtx
+// CancelAll 取消所有由 NewContext 与 NewConnContext 创建的上下文，进程退出时调用，中断进行中的拨号、查询与转发
+func CancelAll() {
+	cancelRoot()
 }
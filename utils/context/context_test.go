@@ -0,0 +1,60 @@
+package context
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNewContextFrom(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+	c := NewContextFrom(parent)
+	derived, stop := context.WithTimeout(c, time.Minute)
+	defer stop()
+
+	if TraceID(derived) == "" || TraceID(derived) != TraceID(c) {
+		t.Errorf("TraceID = %q", TraceID(derived))
+	}
+	if _, ok := StartTime(derived); !ok {
+		t.Error("StartTime not found through a derived context")
+	}
+	if _, ok := derived.Deadline(); !ok {
+		t.Error("derived context has no deadline")
+	}
+
+	cancel()
+	select {
+	case <-derived.Done():
+	case <-time.After(time.Second):
+		t.Fatal("cancelling the parent did not propagate")
+	}
+	if c.Err() != context.Canceled {
+		t.Errorf("Err = %v", c.Err())
+	}
+
+	if other := NewContextFrom(context.Background()); TraceID(other) == TraceID(c) {
+		t.Error("NewContextFrom should generate a new traceID")
+	}
+	if TraceID(context.Background()) != "" {
+		t.Error("TraceID of a plain context should be empty")
+	}
+}
+
+type listenerKey struct{}
+
+func TestNewConnContext(t *testing.T) {
+	listener, stopListener := context.WithCancel(context.WithValue(context.Background(), listenerKey{}, "in"))
+	c, cancel := NewConnContext(listener)
+
+	if c.Value(listenerKey{}) != "in" {
+		t.Error("values of the listener context are not inherited")
+	}
+	stopListener()
+	if c.Err() != nil {
+		t.Fatal("closing the listener cancelled the connection context")
+	}
+	cancel()
+	if c.Err() != context.Canceled {
+		t.Errorf("Err after cancel = %v", c.Err())
+	}
+}
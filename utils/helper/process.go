@@ -0,0 +1,16 @@
+package helper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ExeFilePath 可执行文件所在目录下名为 name 的文件路径，用于保存运行状态与备份
+func ExeFilePath(name string) (string, error) {
+	exePath, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to get executable path: %w", err)
+	}
+	return filepath.Join(filepath.Dir(exePath), name), nil
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package helper
+
+import (
+	"errors"
+	"syscall"
+)
+
+// ProcessAlive 进程 pid 是否仍在运行
+func ProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,26 @@
+package helper
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// stillActive GetExitCodeProcess 对仍在运行的进程返回的 STILL_ACTIVE
+const stillActive = 259
+
+// ProcessAlive 进程 pid 是否仍在运行
+func ProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// 无权打开说明进程存在
+		return err == windows.ERROR_ACCESS_DENIED
+	}
+	defer windows.CloseHandle(h)
+	var code uint32
+	if err = windows.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
@@ -0,0 +1,50 @@
+package helper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseBytes 解析字节数，支持 B/K/KB/M/MB/G/GB/T/TB 后缀（1024 进制，不区分大小写），空串返回 0
+func ParseBytes(raw string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(raw))
+	if s == "" {
+		return 0, nil
+	}
+	unit := int64(1)
+	number := strings.TrimSuffix(s, "B")
+	switch {
+	case strings.HasSuffix(number, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(number, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(number, "G"):
+		unit = 1 << 30
+	case strings.HasSuffix(number, "T"):
+		unit = 1 << 40
+	}
+	if unit > 1 {
+		number = number[:len(number)-1]
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", raw)
+	}
+	return int64(value * float64(unit)), nil
+}
+
+// FormatBytes 以 1024 进制格式化字节数，如 1.5GB，与 ParseBytes 的后缀一致
+func FormatBytes(n int64) string {
+	const units = "KMGT"
+	if n < 1<<10 {
+		return fmt.Sprintf("%dB", n)
+	}
+	value := float64(n) / (1 << 10)
+	i := 0
+	for value >= 1<<10 && i < len(units)-1 {
+		value /= 1 << 10
+		i++
+	}
+	return fmt.Sprintf("%.1f%cB", value, units[i])
+}
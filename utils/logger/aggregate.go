@@ -0,0 +1,93 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// aggregateWindow 相同错误的合并窗口
+const aggregateWindow = time.Minute
+
+// aggregate 一个窗口内同一 key 的错误
+type aggregate struct {
+	data    map[string]interface{}
+	message string
+	warn    bool // 以 warn 级别输出
+	count   int  // 窗口内被合并（未输出）的次数
+	start   time.Time
+}
+
+var aggregator = struct {
+	mu    sync.Mutex
+	once  sync.Once
+	items map[string]*aggregate
+}{items: make(map[string]*aggregate)}
+
+// ErrorAggregated 同一 key 的错误每分钟只输出第一条，其余的在窗口结束时汇总为一条
+// “... occurred N times in the last 1m0s”，用于抖动连接等会反复出现的错误
+func ErrorAggregated(ctx context.Context, key string, data map[string]interface{}, args ...interface{}) {
+	if aggregated(key, data, false, args) {
+		Error(ctx, data, args...)
+	}
+}
+
+// WarnAggregated 同 ErrorAggregated，以 warn 级别输出
+func WarnAggregated(ctx context.Context, key string, data map[string]interface{}, args ...interface{}) {
+	if aggregated(key, data, true, args) {
+		Warn(ctx, data, args...)
+	}
+}
+
+// aggregated 登记一次 key 的日志，返回是否为窗口内的第一条（需要立即输出）
+func aggregated(key string, data map[string]interface{}, warn bool, args []interface{}) bool {
+	aggregator.once.Do(func() {
+		go sweepAggregated()
+	})
+	aggregator.mu.Lock()
+	if item, ok := aggregator.items[key]; ok {
+		item.count++
+		aggregator.mu.Unlock()
+		return false
+	}
+	snapshot := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		snapshot[k] = v
+	}
+	aggregator.items[key] = &aggregate{data: snapshot, message: fmt.Sprint(args...), warn: warn, start: time.Now()}
+	aggregator.mu.Unlock()
+	return true
+}
+
+// sweepAggregated 定期输出到期窗口的汇总
+func sweepAggregated() {
+	ticker := time.NewTicker(aggregateWindow / 6)
+	defer ticker.Stop()
+	for range ticker.C {
+		var expired []*aggregate
+		aggregator.mu.Lock()
+		for key, item := range aggregator.items {
+			if time.Since(item.start) >= aggregateWindow {
+				delete(aggregator.items, key)
+				if item.count > 0 {
+					expired = append(expired, item)
+				}
+			}
+		}
+		aggregator.mu.Unlock()
+		for _, item := range expired {
+			item.data["repeat"] = item.count + 1
+			// 与单条错误一致，格式化时会在末尾附加 ":<error>"
+			message := item.message
+			if code, ok := item.data["errorCode"].(int); ok && message == "" {
+				message = Code2Message(code)
+			}
+			if item.warn {
+				Warnf(nil, item.data, "%s occurred %d times in the last %s", message, item.count+1, aggregateWindow)
+				continue
+			}
+			Errorf(nil, item.data, "%s occurred %d times in the last %s", message, item.count+1, aggregateWindow)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package logger
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"proxy/config"
+)
+
+// 审计日志：本程序对系统所做的改动（路由、策略路由、系统代理的注册表 / gsettings / networksetup、TUN 设备、网关模式的转发与防火墙规则、系统 DNS），
+// 每行一条 JSON，只追加不切分，便于用户核对改动了什么、退出时恢复了什么
+
+// AuditRecord 审计日志中的一条改动
+type AuditRecord struct {
+	Time   string `json:"time"`
+	PID    int    `json:"pid"`
+	Object string `json:"object"` // 改动的对象：route、fwmark、winhttp、registry、gsettings、networksetup、tun、sysctl、iptables、ip6tables、dns
+	Op     string `json:"op"`     // add、delete、set、restore、create、close
+	Target string `json:"target"` // 路由网段、注册表值、gsettings 键、网络服务或网卡名、内核参数、防火墙规则
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+var audit struct {
+	mu   sync.Mutex
+	file *os.File
+	path string
+}
+
+// auditPath 审计日志路径：log.audit，默认 log.path 下的 audit.log，- 表示不记录
+func auditPath() string {
+	switch p := config.Config.Log.Audit; p {
+	case "-":
+		return ""
+	case "":
+		return filepath.Join(config.Config.Log.Path, "audit.log")
+	default:
+		return p
+	}
+}
+
+// Audit 记录一次系统改动，before / after 为改动前后的值（不存在时为空），err 为改动失败的原因
+func Audit(object, op, target, before, after string, err error) {
+	if config.CheckMode() {
+		return
+	}
+	r := AuditRecord{
+		Time:   time.Now().In(config.CstZone).Format(config.TimeFormat),
+		PID:    os.Getpid(),
+		Object: object,
+		Op:     op,
+		Target: target,
+		Before: before,
+		After:  after,
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	line, _ := json.Marshal(&r)
+	line = append(line, '\n')
+
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	path := auditPath()
+	if path == "" {
+		return
+	}
+	// 配置重载可能修改路径
+	if audit.file == nil || audit.path != path {
+		if audit.file != nil {
+			_ = audit.file.Close()
+		}
+		f, openErr := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if openErr != nil {
+			audit.file = nil
+			Warn(nil, map[string]interface{}{
+				"action": config.ActionRuntime,
+				"file":   path,
+				"error":  openErr,
+			}, "open audit log failed")
+			return
+		}
+		audit.file, audit.path = f, path
+	}
+	_, _ = audit.file.Write(line)
+}
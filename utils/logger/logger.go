@@ -2,6 +2,7 @@ package logger
 
 import (
 	"bytes"
+	context2 "context"
 	"io"
 	"os"
 	"time"
@@ -16,6 +17,16 @@ var log = logrus.New()
 var logEntry *logrus.Entry
 
 func init() {
+	log.SetLevel(logrus.DebugLevel)
+	log.SetOutput(new(bytes.Buffer))
+	log.SetReportCaller(false)
+	log.SetFormatter(DefaultFormatter())
+	logEntry = log.WithTime(time.Now().In(config.CstZone))
+	config.RegisterLoadCallback(setup)
+}
+
+// setup 按加载的配置设置日志级别与输出，添加日志文件、最近日志与系统日志的 hook
+func setup() {
 	level, err := logrus.ParseLevel(config.Config.Log.Level)
 	if err != nil {
 		level = logrus.DebugLevel
@@ -27,10 +38,21 @@ func init() {
 		buf = os.Stdout
 	}
 	log.SetOutput(buf)
-	log.SetReportCaller(false)
-	log.SetFormatter(DefaultFormatter())
-	logEntry = log.WithTime(time.Now().In(config.CstZone))
+	// check 子命令只校验配置，不写日志文件与系统日志
+	if config.CheckMode() {
+		return
+	}
 	log.Hooks.Add(newLfsHook(28))
+	log.Hooks.Add(recent)
+	// 配置重载时同步日志级别
+	config.RegisterReloadCallback(func() {
+		_ = SetLevel(config.Config.Log.Level)
+	})
+	if hook, err := newSinkHook(); err != nil {
+		logrus.Errorf("config %s sink for logger error: %v", config.Config.Log.Sink, err)
+	} else if hook != nil {
+		log.Hooks.Add(hook)
+	}
 }
 
 func DefaultFormatter() *JSONFormatter {
@@ -47,19 +69,13 @@ func DefaultFormatter() *JSONFormatter {
 	}
 }
 
-func getContext(ctx *context.Context, data map[string]interface{}) logrus.Fields {
+func getContext(ctx context2.Context, data map[string]interface{}) logrus.Fields {
 	fields := logrus.Fields{}
 	if ctx != nil {
-		var (
-			processId    = os.Getpid()
-			startTime, _ = ctx.Get("startTime")
-			duration     = float64(time.Now().Sub(startTime.(time.Time)).Nanoseconds()/1e4) / 100.0 // 单位毫秒,保留2位小数
-			traceID      = ctx.GetString("traceID")
-		)
-		fields = logrus.Fields{
-			"processID": processId,
-			"traceID":   traceID,
-			"duration":  duration,
+		fields["processID"] = os.Getpid()
+		fields["traceID"] = context.TraceID(ctx)
+		if startTime, ok := context.StartTime(ctx); ok {
+			fields["duration"] = float64(time.Now().Sub(startTime).Nanoseconds()/1e4) / 100.0 // 单位毫秒,保留2位小数
 		}
 	}
 	for s, i := range data {
@@ -69,46 +85,61 @@ func getContext(ctx *context.Context, data map[string]interface{}) logrus.Fields
 }
 
 // Info 打印Info级别的日志
-func Info(ctx *context.Context, data map[string]interface{}, args ...interface{}) {
+func Info(ctx context2.Context, data map[string]interface{}, args ...interface{}) {
 	logEntry.WithTime(time.Now().In(config.CstZone)).WithFields(getContext(ctx, data)).Info(args...)
 }
 
 // Infof 打印Infof级别的日志
-func Infof(ctx *context.Context, data map[string]interface{}, format string, args ...interface{}) {
+func Infof(ctx context2.Context, data map[string]interface{}, format string, args ...interface{}) {
 	logEntry.WithTime(time.Now().In(config.CstZone)).WithFields(getContext(ctx, data)).Infof(format, args...)
 }
 
 // Debug 打印日志
-func Debug(ctx *context.Context, data map[string]interface{}, args ...interface{}) {
+func Debug(ctx context2.Context, data map[string]interface{}, args ...interface{}) {
 	logEntry.WithTime(time.Now().In(config.CstZone)).WithFields(getContext(ctx, data)).Debug(args...)
 }
 
 // Warn 打印日志
-func Warn(ctx *context.Context, data map[string]interface{}, args ...interface{}) {
+func Warn(ctx context2.Context, data map[string]interface{}, args ...interface{}) {
 	logEntry.WithTime(time.Now().In(config.CstZone)).WithFields(getContext(ctx, data)).Warn(args...)
 }
 
 // Warnf 打印日志
-func Warnf(ctx *context.Context, data map[string]interface{}, format string, args ...interface{}) {
+func Warnf(ctx context2.Context, data map[string]interface{}, format string, args ...interface{}) {
 	logEntry.WithTime(time.Now().In(config.CstZone)).WithFields(getContext(ctx, data)).Warnf(format, args...)
 }
 
 // Error 打印日志
-func Error(ctx *context.Context, data map[string]interface{}, args ...interface{}) {
+func Error(ctx context2.Context, data map[string]interface{}, args ...interface{}) {
 	logEntry.WithTime(time.Now().In(config.CstZone)).WithFields(getContext(ctx, data)).Error(args...)
 }
 
 // Errorf 打印日志
-func Errorf(ctx *context.Context, data map[string]interface{}, format string, args ...interface{}) {
+func Errorf(ctx context2.Context, data map[string]interface{}, format string, args ...interface{}) {
 	logEntry.WithTime(time.Now().In(config.CstZone)).WithFields(getContext(ctx, data)).Errorf(format, args...)
 }
 
 // Fatal 打印日志
-func Fatal(ctx *context.Context, data map[string]interface{}, args ...interface{}) {
+func Fatal(ctx context2.Context, data map[string]interface{}, args ...interface{}) {
 	logEntry.WithTime(time.Now().In(config.CstZone)).WithFields(getContext(ctx, data)).Fatal(args...)
 }
 
 // Trace 打印日志
-func Trace(ctx *context.Context, data map[string]interface{}, args ...interface{}) {
+func Trace(ctx context2.Context, data map[string]interface{}, args ...interface{}) {
 	logEntry.WithTime(time.Now().In(config.CstZone)).WithFields(getContext(ctx, data)).Trace(args...)
 }
+
+// SetLevel 运行时修改日志级别
+func SetLevel(level string) error {
+	l, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(l)
+	return nil
+}
+
+// GetLevel 当前日志级别
+func GetLevel() string {
+	return log.GetLevel().String()
+}
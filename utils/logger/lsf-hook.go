@@ -1,7 +1,10 @@
 package logger
 
 import (
+	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -9,26 +12,39 @@ import (
 	"github.com/rifflock/lfshook"
 	"github.com/sirupsen/logrus"
 	"proxy/config"
+	"proxy/utils/helper"
 )
 
 func newLfsHook(maxRemainCnt uint) logrus.Hook {
 	ext := path.Ext(config.Config.Log.FileName)
 	name := strings.TrimSuffix(path.Base(config.Config.Log.FileName), ext)
 	logName := path.Join(config.Config.Log.Path, name)
-	writer, err := rotate.New(
-		logName+"-%y-%m-%d-%H"+ext,
+	options := []rotate.Option{
 		// WithLinkName为最新的日志建立软连接，以方便随着找到当前日志文件
-		rotate.WithLinkName(logName+ext),
+		rotate.WithLinkName(logName + ext),
 
 		// WithRotationTime设置日志分割的时间，这里设置为一小时分割一次
-		rotate.WithRotationTime(time.Hour*6),
+		rotate.WithRotationTime(time.Hour * 6),
 
 		// WithMaxAge和WithRotationCount二者只能设置一个，
 		// WithMaxAge设置文件清理前的最长保存时间，
 		// WithRotationCount设置文件清理前最多保存的个数。
 		// rotate.WithMaxAge(time.Hour*24),
 		rotate.WithRotationCount(maxRemainCnt),
-	)
+	}
+	// 按大小切分，同一时间段内的新文件以 .1、.2 结尾
+	if maxSize := parseLogSize("max_size", config.Config.Log.MaxSize); maxSize > 0 {
+		options = append(options, rotate.WithRotationSize(maxSize))
+	}
+	// 每次切分后检查日志总大小
+	if budget := parseLogSize("max_total_size", config.Config.Log.MaxTotalSize); budget > 0 {
+		options = append(options, rotate.WithHandler(rotate.HandlerFunc(func(e rotate.Event) {
+			if rotated, ok := e.(*rotate.FileRotatedEvent); ok {
+				enforceLogBudget(logName, ext, rotated.CurrentFile(), budget)
+			}
+		})))
+	}
+	writer, err := rotate.New(logName+"-%y-%m-%d-%H"+ext, options...)
 
 	if err != nil {
 		logrus.Errorf("config local file system for logger error: %v", err)
@@ -45,3 +61,50 @@ func newLfsHook(maxRemainCnt uint) logrus.Hook {
 
 	return lfsHook
 }
+
+func parseLogSize(field, value string) int64 {
+	size, err := helper.ParseBytes(value)
+	if err != nil {
+		logrus.Errorf("config log.%s for logger error: %v", field, err)
+	}
+	return size
+}
+
+// enforceLogBudget 日志文件总大小超出 budget 时从最旧的文件开始删除，正在写入的文件除外
+func enforceLogBudget(logName, ext, current string, budget int64) {
+	// 按时间切分的文件与按大小切分产生的 .1、.2 文件
+	matches, _ := filepath.Glob(logName + "-*" + ext)
+	generations, _ := filepath.Glob(logName + "-*" + ext + ".*")
+	matches = append(matches, generations...)
+
+	type logFile struct {
+		name string
+		info os.FileInfo
+	}
+	var total int64
+	files := make([]logFile, 0, len(matches))
+	for _, name := range matches {
+		if strings.HasSuffix(name, "_lock") || strings.HasSuffix(name, "_symlink") {
+			continue
+		}
+		fi, err := os.Lstat(name)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		total += fi.Size()
+		if name != current {
+			files = append(files, logFile{name: name, info: fi})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime().Before(files[j].info.ModTime())
+	})
+	for _, f := range files {
+		if total <= budget {
+			return
+		}
+		if err := os.Remove(f.name); err == nil {
+			total -= f.info.Size()
+		}
+	}
+}
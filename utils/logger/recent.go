@@ -0,0 +1,64 @@
+package logger
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"proxy/config"
+)
+
+// recentSize 保留的最近告警与错误日志条数
+const recentSize = 20
+
+// Record 一条最近的告警或错误日志
+type Record struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+	Error   string `json:"error,omitempty"`
+}
+
+// recentHook 在内存中保留最近的告警与错误日志，供 status 子命令与管理接口查看
+type recentHook struct {
+	mu      sync.Mutex
+	records []Record // 环形缓冲
+	next    int
+}
+
+var recent = &recentHook{}
+
+func (h *recentHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel, logrus.WarnLevel}
+}
+
+func (h *recentHook) Fire(entry *logrus.Entry) error {
+	r := Record{
+		Time:    entry.Time.In(config.CstZone).Format(config.TimeFormat),
+		Level:   entry.Level.String(),
+		Message: entry.Message,
+	}
+	if err, ok := entry.Data["error"]; ok && err != nil {
+		r.Error = fmt.Sprint(err)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.records) < recentSize {
+		h.records = append(h.records, r)
+		return nil
+	}
+	h.records[h.next] = r
+	h.next = (h.next + 1) % recentSize
+	return nil
+}
+
+// Recent 最近的告警与错误日志，最新的在前
+func Recent() []Record {
+	recent.mu.Lock()
+	defer recent.mu.Unlock()
+	list := make([]Record, 0, len(recent.records))
+	for i := len(recent.records) - 1; i >= 0; i-- {
+		list = append(list, recent.records[(recent.next+i)%len(recent.records)])
+	}
+	return list
+}
@@ -0,0 +1,63 @@
+package logger
+
+import (
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"proxy/config"
+)
+
+// sink 系统日志输出（syslog、journald、Windows 事件日志），按级别写入一条已格式化的日志
+type sink interface {
+	Write(level logrus.Level, line string) error
+}
+
+// sinkHook 把日志同时写入系统日志
+type sinkHook struct {
+	sink      sink
+	formatter logrus.Formatter
+}
+
+func (h *sinkHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *sinkHook) Fire(entry *logrus.Entry) error {
+	line, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	return h.sink.Write(entry.Level, strings.TrimRight(string(line), "\n"))
+}
+
+// newSinkHook 按 log.sink 创建系统日志 hook，未配置时返回 nil
+func newSinkHook() (logrus.Hook, error) {
+	if config.Config.Log.Sink == "" {
+		return nil, nil
+	}
+	tag := config.Config.Log.Tag
+	if tag == "" {
+		tag = "celestial-ladder"
+	}
+	s, err := openSink(config.Config.Log.Sink, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &sinkHook{sink: s, formatter: DefaultFormatter()}, nil
+}
+
+// syslogPriority 日志级别对应的 syslog 优先级
+func syslogPriority(level logrus.Level) int {
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return 2 // crit
+	case logrus.ErrorLevel:
+		return 3 // err
+	case logrus.WarnLevel:
+		return 4 // warning
+	case logrus.InfoLevel:
+		return 6 // info
+	default:
+		return 7 // debug
+	}
+}
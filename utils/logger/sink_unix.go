@@ -0,0 +1,68 @@
+//go:build !windows
+
+package logger
+
+import (
+	"fmt"
+	"log/syslog"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// journaldSocket systemd-journald 原生协议的数据报套接字
+const journaldSocket = "/run/systemd/journal/socket"
+
+func openSink(kind, tag string) (sink, error) {
+	switch kind {
+	case "syslog":
+		w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+		if err != nil {
+			return nil, err
+		}
+		return &syslogSink{w: w}, nil
+	case "journald":
+		conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+		if err != nil {
+			return nil, err
+		}
+		return &journaldSink{conn: conn, tag: tag}, nil
+	}
+	return nil, fmt.Errorf("unsupported log sink %q on this platform", kind)
+}
+
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func (s *syslogSink) Write(level logrus.Level, line string) error {
+	switch syslogPriority(level) {
+	case 2:
+		return s.w.Crit(line)
+	case 3:
+		return s.w.Err(line)
+	case 4:
+		return s.w.Warning(line)
+	case 6:
+		return s.w.Info(line)
+	default:
+		return s.w.Debug(line)
+	}
+}
+
+type journaldSink struct {
+	conn *net.UnixConn
+	tag  string
+}
+
+// Write 按 journald 原生协议发送一条记录，JSON 格式的日志不含换行，可直接使用 KEY=VALUE 形式
+func (s *journaldSink) Write(level logrus.Level, line string) error {
+	var b strings.Builder
+	b.WriteString("PRIORITY=" + strconv.Itoa(syslogPriority(level)) + "\n")
+	b.WriteString("SYSLOG_IDENTIFIER=" + s.tag + "\n")
+	b.WriteString("MESSAGE=" + strings.ReplaceAll(line, "\n", " ") + "\n")
+	_, err := s.conn.Write([]byte(b.String()))
+	return err
+}
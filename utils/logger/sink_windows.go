@@ -0,0 +1,41 @@
+//go:build windows
+
+package logger
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// eventID 写入事件日志时统一使用的事件 ID
+const eventID = 1
+
+func openSink(kind, tag string) (sink, error) {
+	if kind != "eventlog" {
+		return nil, fmt.Errorf("unsupported log sink %q on this platform", kind)
+	}
+	// 注册事件源需要管理员权限，已注册或无权限时忽略，事件仍可写入
+	_ = eventlog.InstallAsEventCreate(tag, eventlog.Error|eventlog.Warning|eventlog.Info)
+	l, err := eventlog.Open(tag)
+	if err != nil {
+		return nil, err
+	}
+	return &eventlogSink{l: l}, nil
+}
+
+type eventlogSink struct {
+	l *eventlog.Log
+}
+
+func (s *eventlogSink) Write(level logrus.Level, line string) error {
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel:
+		return s.l.Error(eventID, line)
+	case logrus.WarnLevel:
+		return s.l.Warning(eventID, line)
+	default:
+		return s.l.Info(eventID, line)
+	}
+}
@@ -0,0 +1,75 @@
+// Package script 路由脚本的运行环境，基于 go.starlark.net：不支持 load、while 与递归，脚本不能访问文件与网络，
+// 宿主通过 builtins 提供额外的函数。顶层语句在 Compile 时执行一次，之后全局变量冻结为只读，Call 可以并发调用
+package script
+
+import (
+	"errors"
+	"fmt"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// maxSteps 单次 Compile 或 Call 可执行的步数上限，防止死循环拖住连接
+const maxSteps = 100000
+
+// Program 编译并执行过顶层语句的脚本
+type Program struct {
+	name    string
+	globals starlark.StringDict
+}
+
+// Compile 解析 src 并执行顶层语句，builtins 为宿主提供的函数；name 用于错误信息中的位置
+func Compile(name, src string, builtins starlark.StringDict) (*Program, error) {
+	globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, newThread(name), name, src, builtins)
+	if err != nil {
+		return nil, describe(err)
+	}
+	return &Program{name: name, globals: globals}, nil
+}
+
+// Has 脚本是否定义了名为 name 的函数
+func (p *Program) Has(name string) bool {
+	_, ok := p.globals[name].(*starlark.Function)
+	return ok
+}
+
+// Call 调用脚本中的函数 name
+func (p *Program) Call(name string, args ...starlark.Value) (starlark.Value, error) {
+	fn, ok := p.globals[name].(*starlark.Function)
+	if !ok {
+		return nil, fmt.Errorf("%s: function %s is not defined", p.name, name)
+	}
+	v, err := starlark.Call(newThread(p.name), fn, args, nil)
+	if err != nil {
+		return nil, describe(err)
+	}
+	return v, nil
+}
+
+// newThread 每次执行使用新的线程：限制执行步数，脚本中的 load 与 print 不可用
+func newThread(name string) *starlark.Thread {
+	t := &starlark.Thread{
+		Name: name,
+		Load: func(*starlark.Thread, string) (starlark.StringDict, error) {
+			return nil, errors.New("load is not supported")
+		},
+		Print: func(*starlark.Thread, string) {},
+	}
+	t.SetMaxExecutionSteps(maxSteps)
+	return t
+}
+
+// describe 运行时错误带上脚本中出错的位置（最内层的脚本调用），内置函数中的错误取调用它的行
+func describe(err error) error {
+	var evalErr *starlark.EvalError
+	if !errors.As(err, &evalErr) {
+		return err
+	}
+	for i := range evalErr.CallStack {
+		if fr := evalErr.CallStack.At(i); fr.Pos.Line > 0 {
+			return fmt.Errorf("%s: %s", fr.Pos, evalErr.Msg)
+		}
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+const routeScript = `
+# 按域名后缀与端口分流
+DIRECT = [".cn", ".lan"]
+PORTS = {22: "direct", 25: "reject"}
+
+def suffix(domain, suffixes):
+    for s in suffixes:
+        if domain.endswith(s):
+            return True
+    return False
+
+def route(c, fallback=None):
+    if c.port in PORTS:
+        return PORTS[c.port]
+    if suffix(c.domain, DIRECT):
+        return "direct"
+    elif c.domain.startswith("ads."): return "reject"
+    if c.domain == "old.example.com":
+        return {"action": "proxy", "target": "new.example.com:" + str(c.port)}
+    parts = c.domain.split(".")
+    n = 0
+    for i in range(len(parts)):
+        if i % 2 == 0:
+            continue
+        n += 1
+    return "odd" if n > 1 else fallback
+`
+
+func input(domain string, port int) *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"domain": starlark.String(domain),
+		"port":   starlark.MakeInt(port),
+	})
+}
+
+func TestRoute(t *testing.T) {
+	p, err := Compile("route.star", routeScript, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Has("route") || p.Has("DIRECT") {
+		t.Fatal("Has reports wrong functions")
+	}
+	cases := []struct {
+		domain string
+		port   int
+		want   string
+	}{
+		{"www.baidu.cn", 443, `"direct"`},
+		{"git.example.com", 22, `"direct"`},
+		{"mail.example.com", 25, `"reject"`},
+		{"ads.example.com", 443, `"reject"`},
+		{"a.b.c.d.e", 80, `"odd"`},
+		{"www.google.com", 443, "None"},
+		{"old.example.com", 443, `{"action": "proxy", "target": "new.example.com:443"}`},
+	}
+	for _, c := range cases {
+		v, err := p.Call("route", input(c.domain, c.port))
+		if err != nil {
+			t.Fatalf("%s: %v", c.domain, err)
+		}
+		if got := v.String(); got != c.want {
+			t.Errorf("%s:%d got %s, want %s", c.domain, c.port, got, c.want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	cases := map[string]string{
+		"x = 1\nwhile x:\n    pass\n":                                 "while",
+		"def f():\n    return g()\n\ndef g():\n    return f()\nf()\n": "called recursively",
+		"load(\"x.star\", \"y\")\n":                                   "load is not supported",
+		"fail(\"bad\")\n":                                             "err.star:1:5: fail: bad",
+	}
+	for src, want := range cases {
+		_, err := Compile("err.star", src, nil)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want error containing %q", src, err, want)
+		}
+	}
+
+	p, err := Compile("run.star", `
+L = [1]
+def mutate():
+    L.append(2)
+def loop():
+    for i in range(100000):
+        for j in range(100000):
+            pass
+def f(a, b=2):
+    return a + b
+def host():
+    return lookup("x")
+`, starlark.StringDict{"lookup": starlark.NewBuiltin("lookup", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var s string
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "s", &s); err != nil {
+			return nil, err
+		}
+		return starlark.String(s), nil
+	})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime := map[string]string{
+		"mutate": "frozen",
+		"loop":   "too many steps",
+		"f":      "missing 1 argument (a)",
+	}
+	for fn, want := range runtime {
+		if _, err = p.Call(fn); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want error containing %q", fn, err, want)
+		}
+	}
+	if v, err := p.Call("f", starlark.MakeInt(1)); err != nil || v.String() != "3" {
+		t.Errorf("f(1) = %v, %v", v, err)
+	}
+	if v, err := p.Call("host"); err != nil || v != starlark.String("x") {
+		t.Errorf("host() = %v, %v", v, err)
+	}
+}
@@ -0,0 +1,54 @@
+// Package workpool 固定数量的协程处理任务，代替每个数据包启动一个协程。
+// 任务按 key 分配到固定的协程，同一 key（如同一条流）的任务按提交顺序执行
+package workpool
+
+import (
+	"sync"
+)
+
+// Pool 按 key 分片的协程池，每个协程有各自的有界队列
+type Pool struct {
+	queues []chan func()
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// New 启动 workers 个协程，每个协程的队列可缓存 queue 个任务
+func New(workers, queue int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &Pool{queues: make([]chan func(), workers)}
+	for i := range p.queues {
+		q := make(chan func(), queue)
+		p.queues[i] = q
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for fn := range q {
+				fn()
+			}
+		}()
+	}
+	return p
+}
+
+// Submit 把 fn 交给 key 对应的协程；队列已满时不阻塞，返回 false，由调用方丢弃并计数
+func (p *Pool) Submit(key uint64, fn func()) bool {
+	select {
+	case p.queues[key%uint64(len(p.queues))] <- fn:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close 不再接受任务，等待已提交的任务执行完毕；Close 之后不能再调用 Submit
+func (p *Pool) Close() {
+	p.once.Do(func() {
+		for _, q := range p.queues {
+			close(q)
+		}
+	})
+	p.wg.Wait()
+}
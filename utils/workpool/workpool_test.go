@@ -0,0 +1,94 @@
+package workpool
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestOrderPerKey(t *testing.T) {
+	p := New(4, 1024)
+	const keys, n = 8, 500
+	got := make([][]int, keys)
+	var mu sync.Mutex
+	for i := 0; i < n; i++ {
+		for k := 0; k < keys; k++ {
+			k, i := k, i
+			for !p.Submit(uint64(k), func() {
+				mu.Lock()
+				got[k] = append(got[k], i)
+				mu.Unlock()
+			}) {
+				runtime.Gosched()
+			}
+		}
+	}
+	p.Close()
+	for k, list := range got {
+		if len(list) != n {
+			t.Fatalf("key %d ran %d tasks, want %d", k, len(list), n)
+		}
+		for i, v := range list {
+			if v != i {
+				t.Fatalf("key %d task %d ran at position %d", k, v, i)
+			}
+		}
+	}
+}
+
+func TestSubmitFull(t *testing.T) {
+	p := New(1, 1)
+	block := make(chan struct{})
+	started := make(chan struct{})
+	p.Submit(0, func() {
+		close(started)
+		<-block
+	})
+	<-started
+	if !p.Submit(0, func() {}) {
+		t.Fatal("queue should have room for one task")
+	}
+	if p.Submit(0, func() {}) {
+		t.Fatal("Submit should fail when the queue is full")
+	}
+	close(block)
+	p.Close()
+}
+
+// work 模拟处理一个数据包的开销
+func work(sink *atomic.Uint64) {
+	var h uint64 = 14695981039346656037
+	for i := 0; i < 256; i++ {
+		h = (h ^ uint64(i)) * 1099511628211
+	}
+	sink.Add(h & 1)
+}
+
+// BenchmarkGoroutinePerPacket 原先的做法：每个数据包一个协程
+func BenchmarkGoroutinePerPacket(b *testing.B) {
+	var sink atomic.Uint64
+	var wg sync.WaitGroup
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			work(&sink)
+		}()
+	}
+	wg.Wait()
+}
+
+// BenchmarkPool 按流分片的协程池，流数与 TUN 上同时活跃的 UDP 会话相当
+func BenchmarkPool(b *testing.B) {
+	var sink atomic.Uint64
+	p := New(runtime.GOMAXPROCS(0)*4, 256)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for !p.Submit(uint64(i%64), func() { work(&sink) }) {
+			runtime.Gosched()
+		}
+	}
+	p.Close()
+}